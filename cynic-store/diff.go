@@ -0,0 +1,200 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+var (
+	errDiffArgs    = fmt.Errorf("diff takes one or two stores")
+	errNoSnapshot  = fmt.Errorf("no snapshot at or before the given time")
+	errBadDiffTime = fmt.Errorf("times must be unix timestamps or RFC3339")
+)
+
+type diffSession struct {
+	from string
+	to   string
+}
+
+// diffCommand prints the keys that appeared, disappeared or changed
+// between two snapshots. With one store, the snapshots are picked
+// from it by time; with two, one is picked from each. Without a
+// time, the last snapshot is used, or the first one for -from when
+// diffing within one store.
+func diffCommand(args []string) error {
+	sess := &diffSession{}
+
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.StringVar(&sess.from, "from", sess.from, "time of the old snapshot (unix or RFC3339)")
+	flags.StringVar(&sess.to, "to", sess.to, "time of the new snapshot (unix or RFC3339)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cynic-store diff [-from time] [-to time] <store> [<store>]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	paths := flags.Args()
+	if len(paths) < 1 || len(paths) > 2 {
+		return errDiffArgs
+	}
+
+	oldStore, err := readStoreFile(paths[0])
+	if err != nil {
+		return fmt.Errorf("problem decoding store: %s: %w", paths[0], err)
+	}
+
+	newStore := oldStore
+	fromFirst := true
+	if len(paths) == 2 {
+		fromFirst = false
+		if newStore, err = readStoreFile(paths[1]); err != nil {
+			return fmt.Errorf("problem decoding store: %s: %w", paths[1], err)
+		}
+	}
+
+	oldSnapshot, err := pickSnapshot(&oldStore, sess.from, fromFirst)
+	if err != nil {
+		return err
+	}
+
+	newSnapshot, err := pickSnapshot(&newStore, sess.to, false)
+	if err != nil {
+		return err
+	}
+
+	return printDiff(os.Stdout, oldSnapshot, newSnapshot)
+}
+
+// pickSnapshot returns the last snapshot taken at or before the given
+// time. Without a time, the first or last snapshot is returned.
+func pickSnapshot(store *cynic.SnapshotStore, at string, first bool) (*cynic.Snapshot, error) {
+	if len(store.Snapshots) == 0 {
+		return nil, errNoSnapshot
+	}
+
+	snapshots := make([]*cynic.Snapshot, len(store.Snapshots))
+	copy(snapshots, store.Snapshots)
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp < snapshots[j].Timestamp
+	})
+
+	if at == "" {
+		if first {
+			return snapshots[0], nil
+		}
+		return snapshots[len(snapshots)-1], nil
+	}
+
+	ts, err := parseDiffTime(at)
+	if err != nil {
+		return nil, err
+	}
+
+	// index of the first snapshot after ts
+	i := sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i].Timestamp > ts
+	})
+	if i == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoSnapshot, at)
+	}
+
+	return snapshots[i-1], nil
+}
+
+func parseDiffTime(at string) (int64, error) {
+	if ts, err := strconv.ParseInt(at, 10, 64); err == nil {
+		return ts, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", errBadDiffTime, at)
+	}
+
+	return parsed.Unix(), nil
+}
+
+func printDiff(w io.Writer, oldSnapshot, newSnapshot *cynic.Snapshot) error {
+	oldStatus, err := decodeStatus(oldSnapshot)
+	if err != nil {
+		return err
+	}
+
+	newStatus, err := decodeStatus(newSnapshot)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "--- %d\n+++ %d\n", oldSnapshot.Timestamp, newSnapshot.Timestamp)
+
+	keys := make([]string, 0, len(oldStatus)+len(newStatus))
+	for key := range oldStatus {
+		keys = append(keys, key)
+	}
+	for key := range newStatus {
+		if _, ok := oldStatus[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		oldValue, inOld := oldStatus[key]
+		newValue, inNew := newStatus[key]
+
+		switch {
+		case !inOld:
+			fmt.Fprintf(w, "+ %s: %s\n", key, newValue)
+		case !inNew:
+			fmt.Fprintf(w, "- %s: %s\n", key, oldValue)
+		case !jsonEqual(oldValue, newValue):
+			fmt.Fprintf(w, "~ %s: %s -> %s\n", key, oldValue, newValue)
+		}
+	}
+
+	return nil
+}
+
+func decodeStatus(snp *cynic.Snapshot) (map[string]json.RawMessage, error) {
+	var status map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(snp.Data), &status); err != nil {
+		return nil, fmt.Errorf("problem decoding snapshot %d: %w", snp.Timestamp, err)
+	}
+	return status, nil
+}
+
+// jsonEqual compares two json values, ignoring formatting.
+func jsonEqual(a, b json.RawMessage) bool {
+	var compactA, compactB bytes.Buffer
+	if json.Compact(&compactA, a) != nil || json.Compact(&compactB, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
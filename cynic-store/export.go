@@ -0,0 +1,126 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+var errUnknownFormat = fmt.Errorf("unknown output format")
+
+// exportedSnapshot is how a snapshot is written in json and json
+// lines; the data is kept as json, instead of a string holding json.
+type exportedSnapshot struct {
+	Timestamp int64           `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func export(w io.Writer, store *cynic.SnapshotStore, format string) error {
+	switch format {
+	case formatText:
+		_, err := io.WriteString(w, store.String())
+		return err
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exportedSnapshots(store))
+	case formatJSONL:
+		enc := json.NewEncoder(w)
+		for _, snp := range exportedSnapshots(store) {
+			if err := enc.Encode(snp); err != nil {
+				return err
+			}
+		}
+		return nil
+	case formatCSV:
+		return exportCSV(w, store)
+	default:
+		return fmt.Errorf("%w: %s", errUnknownFormat, format)
+	}
+}
+
+func exportedSnapshots(store *cynic.SnapshotStore) []exportedSnapshot {
+	ret := make([]exportedSnapshot, 0, len(store.Snapshots))
+	for _, snp := range store.Snapshots {
+		data := json.RawMessage(snp.Data)
+		if !json.Valid(data) {
+			// keep the output valid, even if a snapshot is not
+			quoted, _ := json.Marshal(snp.Data)
+			data = quoted
+		}
+		ret = append(ret, exportedSnapshot{Timestamp: snp.Timestamp, Data: data})
+	}
+	return ret
+}
+
+// exportCSV writes one row per status key of every snapshot, so that
+// values can be charted per key.
+func exportCSV(w io.Writer, store *cynic.SnapshotStore) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"timestamp", "key", "value"}); err != nil {
+		return err
+	}
+
+	for _, snp := range store.Snapshots {
+		ts := strconv.FormatInt(snp.Timestamp, 10)
+
+		var status map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(snp.Data), &status); err != nil {
+			if err := writer.Write([]string{ts, "", snp.Data}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		keys := make([]string, 0, len(status))
+		for key := range status {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if err := writer.Write([]string{ts, key, csvValue(status[key])}); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvValue writes strings as they are, and anything else as json.
+func csvValue(value json.RawMessage) string {
+	var str string
+	if err := json.Unmarshal(value, &str); err == nil {
+		return str
+	}
+	return string(value)
+}
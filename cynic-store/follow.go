@@ -0,0 +1,160 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+var errFollowFormat = fmt.Errorf("follow only supports the text and jsonl formats")
+
+// follower polls a store file, or a directory of rotated store files,
+// and prints the snapshots newer than the last one it printed.
+type follower struct {
+	w         io.Writer
+	format    string
+	path      string
+	lastPrint int64
+	seen      map[string]fileVersion
+}
+
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+}
+
+func follow(w io.Writer, path, format string, interval time.Duration) error {
+	if format != formatText && format != formatJSONL {
+		return errFollowFormat
+	}
+
+	f := &follower{
+		w:      w,
+		format: format,
+		path:   path,
+		seen:   make(map[string]fileVersion),
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.poll(); err != nil {
+			return err
+		}
+		<-ticker.C
+	}
+}
+
+func (s *follower) poll() error {
+	paths, err := s.storePaths()
+	if err != nil {
+		return err
+	}
+
+	snapshots := make([]*cynic.Snapshot, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue // rotated away since listing
+		}
+
+		version := fileVersion{size: info.Size(), modTime: info.ModTime()}
+		if s.seen[path] == version {
+			continue
+		}
+
+		// a store being written may be cut short; print what could
+		// be read, and pick up the rest on the next poll
+		store, err := readStoreFile(path)
+		if err != nil && len(store.Snapshots) == 0 {
+			log.Println("could not read store, retrying: ", path, ": ", err)
+			continue
+		}
+		if err == nil {
+			s.seen[path] = version
+		}
+
+		for _, snp := range store.Snapshots {
+			if snp.Timestamp > s.lastPrint {
+				snapshots = append(snapshots, snp)
+			}
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp < snapshots[j].Timestamp
+	})
+
+	for _, snp := range snapshots {
+		if snp.Timestamp <= s.lastPrint {
+			continue // same snapshot, in more than one file
+		}
+
+		if err := s.print(snp); err != nil {
+			return err
+		}
+		s.lastPrint = snp.Timestamp
+	}
+
+	return nil
+}
+
+func (s *follower) storePaths() ([]string, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{s.path}, nil
+	}
+
+	files, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".cynic") {
+			paths = append(paths, filepath.Join(s.path, file.Name()))
+		}
+	}
+
+	return paths, nil
+}
+
+func (s *follower) print(snp *cynic.Snapshot) error {
+	if s.format == formatJSONL {
+		store := cynic.SnapshotStore{Snapshots: []*cynic.Snapshot{snp}}
+		return json.NewEncoder(s.w).Encode(exportedSnapshots(&store)[0])
+	}
+
+	_, err := fmt.Fprintf(s.w, "%d:%s\n", snp.Timestamp, snp.Data)
+	return err
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+var errImportArgs = fmt.Errorf("import takes one export, or - for stdin")
+
+type importSession struct {
+	outFile  string
+	format   string
+	stream   bool
+	compress bool
+}
+
+// importCommand converts the export of another monitoring tool, in
+// csv or json lines of key, timestamp and value, into a store.
+func importCommand(args []string) error {
+	sess := &importSession{}
+
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.StringVar(&sess.outFile, "output", sess.outFile, "the store to write")
+	flags.StringVar(&sess.format, "format", sess.format, "input format: csv or jsonl, by default the extension")
+	flags.BoolVar(&sess.stream, "stream", sess.stream, "write the store in the stream format")
+	flags.BoolVar(&sess.compress, "gzip", sess.compress, "gzip the snapshots of the store")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cynic-store import -output <file> [-format csv|jsonl] <export>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if sess.outFile == "" {
+		return errNoOutput
+	}
+	if flags.NArg() != 1 {
+		return errImportArgs
+	}
+	inFile := flags.Arg(0)
+
+	format := sess.format
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(inFile), ".")
+	}
+
+	var importFormat cynic.ImportFormat
+	switch format {
+	case formatCSV:
+		importFormat = cynic.ImportFormatCSV
+	case formatJSONL:
+		importFormat = cynic.ImportFormatJSONL
+	default:
+		return fmt.Errorf("%w: %s", errUnknownFormat, format)
+	}
+
+	var in io.Reader = os.Stdin
+	if inFile != "-" {
+		file, err := os.Open(inFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	store, err := cynic.ImportSnapshots(in, importFormat)
+	if err != nil {
+		return fmt.Errorf("problem importing: %s: %w", inFile, err)
+	}
+
+	storeFormat := cynic.SnapshotFormatGob
+	if sess.stream {
+		storeFormat = cynic.SnapshotFormatStream
+	}
+	if sess.compress {
+		store.Flags = uint8(cynic.SnapshotCompressionGzip)
+	}
+
+	return cynic.WriteSnapshotStoreFile(sess.outFile, store, storeFormat)
+}
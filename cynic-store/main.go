@@ -18,55 +18,101 @@ limitations under the License.
 package main
 
 import (
-	"bytes"
-	"encoding/gob"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
 	"github.com/psyomn/cynic/lib"
 )
 
+// keyEnv is the environment variable the key of encrypted stores is
+// read from, base64 encoded.
+const keyEnv = "CYNIC_SNAPSHOT_KEY"
+
+// readStoreFile reads a store file, decrypting it with the key in
+// keyEnv if it is encrypted.
+func readStoreFile(path string) (cynic.SnapshotStore, error) {
+	return cynic.ReadSnapshotStoreFileWithKey(path, cynic.SnapshotKeyFromEnv(keyEnv))
+}
+
 type session struct {
-	inFile string
+	inFile   string
+	format   string
+	outFile  string
+	follow   bool
+	interval time.Duration
 }
 
 func parseFlags(s *session) {
 	flag.StringVar(&s.inFile, "input", s.inFile, "the cynic db store to dump")
+	flag.StringVar(&s.format, "format", formatText, "output format: text, json, jsonl or csv")
+	flag.StringVar(&s.outFile, "output", s.outFile, "file to write to, instead of stdout")
+	flag.BoolVar(&s.follow, "follow", s.follow, "keep printing new snapshots; the input may be a directory")
+	flag.DurationVar(&s.interval, "interval", s.interval, "how often to check for new snapshots when following")
 	flag.Parse()
 }
 
 func usage() {
+	fmt.Fprintln(flag.CommandLine.Output(), "usage: cynic-store [diff|import|merge|serve|summary] [flags]")
+	fmt.Fprintln(flag.CommandLine.Output(), "encrypted stores are read with the key in $"+keyEnv)
 	flag.PrintDefaults()
 }
 
-func main() {
-	sess := &session{}
-	parseFlags(sess)
-
-	if sess.inFile == "" {
-		usage()
+func dump(sess *session) error {
+	out := os.Stdout
+	if sess.outFile != "" {
+		var err error
+		out, err = os.Create(sess.outFile)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
 	}
 
-	var buff bytes.Buffer
+	if sess.follow {
+		return follow(out, sess.inFile, sess.format, sess.interval)
+	}
 
-	dat, err := ioutil.ReadFile(sess.inFile)
+	snapstore, err := readStoreFile(sess.inFile)
 	if err != nil {
-		log.Fatal("problem opening file:", sess.inFile, ":", err)
-		os.Exit(1)
+		return fmt.Errorf("problem decoding store: %s: %w", sess.inFile, err)
 	}
 
-	dec := gob.NewDecoder(&buff)
-	var snapstore cynic.SnapshotStore
-	buff.Write(dat)
+	return export(out, &snapstore, sess.format)
+}
 
-	err = dec.Decode(&snapstore)
-	if err != nil {
-		log.Println("problem decoding store: ", sess.inFile, ":", err)
-		os.Exit(1)
+// subcommands take the arguments that follow their name. Without a
+// subcommand, the input store is dumped.
+var subcommands = map[string]func(args []string) error{
+	"diff":    diffCommand,
+	"import":  importCommand,
+	"merge":   mergeCommand,
+	"serve":   serveCommand,
+	"summary": summaryCommand,
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	sess := &session{format: formatText, interval: time.Second}
+	parseFlags(sess)
+
+	if sess.inFile == "" {
+		usage()
 	}
 
-	fmt.Println(snapstore.String())
+	if err := dump(sess); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
 }
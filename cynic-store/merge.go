@@ -0,0 +1,114 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+var (
+	errNoOutput = fmt.Errorf("an output file is required")
+	errNoInputs = fmt.Errorf("at least one input store is required")
+)
+
+type mergeSession struct {
+	outFile  string
+	stream   bool
+	compress bool
+	inFiles  []string
+}
+
+// mergeCommand merges store files into one, sorted by timestamp and
+// without duplicate snapshots.
+func mergeCommand(args []string) error {
+	sess := &mergeSession{}
+
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	flags.StringVar(&sess.outFile, "output", sess.outFile, "the merged store to write")
+	flags.BoolVar(&sess.stream, "stream", sess.stream, "write the merged store in the stream format")
+	flags.BoolVar(&sess.compress, "gzip", sess.compress, "gzip the snapshots of the merged store")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cynic-store merge -output <file> <store>...")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	sess.inFiles = flags.Args()
+
+	if sess.outFile == "" {
+		return errNoOutput
+	}
+	if len(sess.inFiles) == 0 {
+		return errNoInputs
+	}
+
+	merged, err := mergeStores(sess.inFiles)
+	if err != nil {
+		return err
+	}
+
+	format := cynic.SnapshotFormatGob
+	if sess.stream {
+		format = cynic.SnapshotFormatStream
+	}
+	if sess.compress {
+		merged.Flags = uint8(cynic.SnapshotCompressionGzip)
+	}
+
+	return cynic.WriteSnapshotStoreFile(sess.outFile, merged, format)
+}
+
+func mergeStores(paths []string) (*cynic.SnapshotStore, error) {
+	type snapshotKey struct {
+		timestamp int64
+		data      string
+	}
+
+	var merged cynic.SnapshotStore
+	seen := make(map[snapshotKey]bool)
+
+	for _, path := range paths {
+		store, err := readStoreFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("problem decoding store: %s: %w", path, err)
+		}
+
+		if err := store.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid store: %s: %w", path, err)
+		}
+
+		for _, snp := range store.Snapshots {
+			key := snapshotKey{timestamp: snp.Timestamp, data: snp.Data}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged.Snapshots = append(merged.Snapshots, snp)
+		}
+	}
+
+	sort.SliceStable(merged.Snapshots, func(i, j int) bool {
+		return merged.Snapshots[i].Timestamp < merged.Snapshots[j].Timestamp
+	})
+
+	return &merged, nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+const historyEndpoint = "/history/"
+
+var errServeArgs = fmt.Errorf("serve takes one store")
+
+type serveSession struct {
+	host string
+	port string
+	root string
+}
+
+// storeServer serves the snapshots of a store like a live status
+// server would, and the history of every key across them. Nothing
+// can be changed through it.
+type storeServer struct {
+	root      string
+	snapshots []*cynic.Snapshot
+}
+
+// historyEntry is the value of a key in one snapshot.
+type historyEntry struct {
+	Timestamp int64           `json:"timestamp"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// serveCommand serves a store over http: the last snapshot under
+// the status root (or the one at ?at=), and the values a key took
+// over time under /history/key (?since=, ?until=).
+func serveCommand(args []string) error {
+	sess := &serveSession{port: cynic.StatusPort, root: cynic.DefaultStatusEndpoint}
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.StringVar(&sess.host, "host", sess.host, "host to listen on")
+	flags.StringVar(&sess.port, "port", sess.port, "port to listen on")
+	flags.StringVar(&sess.root, "root", sess.root, "where the status is served")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cynic-store serve [-host host] [-port port] [-root path] <store>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errServeArgs
+	}
+	path := flags.Arg(0)
+
+	store, err := readStoreFile(path)
+	if err != nil {
+		return fmt.Errorf("problem decoding store: %s: %w", path, err)
+	}
+
+	server := storeServerNew(&store, sess.root)
+	httpServer := &http.Server{
+		Addr:           sess.host + ":" + sess.port,
+		Handler:        server.mux(),
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	log.Printf("serving %d snapshots of %s on %s", len(server.snapshots), path, httpServer.Addr)
+	return httpServer.ListenAndServe()
+}
+
+func storeServerNew(store *cynic.SnapshotStore, root string) *storeServer {
+	snapshots := make([]*cynic.Snapshot, len(store.Snapshots))
+	copy(snapshots, store.Snapshots)
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp < snapshots[j].Timestamp
+	})
+
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+
+	return &storeServer{root: root, snapshots: snapshots}
+}
+
+func (s *storeServer) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.root, readOnly(s.handleStatus))
+	mux.HandleFunc(historyEndpoint, readOnly(s.handleHistory))
+	return mux
+}
+
+func readOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+func (s *storeServer) handleStatus(w http.ResponseWriter, req *http.Request) {
+	key := req.URL.Path[len(s.root):]
+
+	store := cynic.SnapshotStore{Snapshots: s.snapshots}
+	snp, err := pickSnapshot(&store, req.URL.Query().Get("at"), false)
+	switch {
+	case errors.Is(err, errNoSnapshot):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Last-Modified", time.Unix(snp.Timestamp, 0).UTC().Format(http.TimeFormat))
+
+	if key == "" {
+		fmt.Fprint(w, snp.Data)
+		return
+	}
+
+	status, err := decodeStatus(snp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	value, ok := status[key]
+	if !ok {
+		value = json.RawMessage("null")
+	}
+	w.Write(value) // #nosec
+}
+
+func (s *storeServer) handleHistory(w http.ResponseWriter, req *http.Request) {
+	key := req.URL.Path[len(historyEndpoint):]
+
+	since, until := int64(0), int64(1<<63-1)
+	var err error
+	if at := req.URL.Query().Get("since"); at != "" {
+		if since, err = parseDiffTime(at); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if at := req.URL.Query().Get("until"); at != "" {
+		if until, err = parseDiffTime(at); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// without a key, the times of the snapshots are listed, to pick
+	// one with ?at= under the status root
+	if key == "" {
+		timestamps := make([]int64, 0, len(s.snapshots))
+		for _, snp := range s.snapshots {
+			if snp.Timestamp >= since && snp.Timestamp <= until {
+				timestamps = append(timestamps, snp.Timestamp)
+			}
+		}
+		writeJSON(w, timestamps)
+		return
+	}
+
+	entries := make([]historyEntry, 0)
+	for _, snp := range s.snapshots {
+		if snp.Timestamp < since || snp.Timestamp > until {
+			continue
+		}
+
+		status, err := decodeStatus(snp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if value, ok := status[key]; ok {
+			entries = append(entries, historyEntry{Timestamp: snp.Timestamp, Value: value})
+		}
+	}
+	writeJSON(w, entries)
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Println("problem writing response: ", err)
+	}
+}
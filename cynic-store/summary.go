@@ -0,0 +1,216 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+var errSummaryArgs = fmt.Errorf("summary takes one store")
+
+// Sizes of the framing of stream formatted stores, see
+// lib/snapshot_stream.go.
+const (
+	streamVersion    = 2
+	streamHeaderSize = 10
+	streamRecordSize = 4 + 8
+)
+
+type summarySession struct {
+	format string
+}
+
+// storeSummary describes what is in a store, and where its bytes go.
+type storeSummary struct {
+	File      string       `json:"file"`
+	Version   uint8        `json:"version"`
+	Format    string       `json:"format"`
+	Gzip      bool         `json:"gzip"`
+	Encrypted bool         `json:"encrypted"`
+	Snapshots int          `json:"snapshots"`
+	First     int64        `json:"first,omitempty"`
+	Last      int64        `json:"last,omitempty"`
+	Sizes     summarySizes `json:"sizes"`
+	Keys      []keySummary `json:"keys"`
+}
+
+// summarySizes breaks down the size of a store file. The framing of
+// gob stores is not known, so only their total and data are set.
+type summarySizes struct {
+	File     int64 `json:"file"`
+	Header   int64 `json:"header,omitempty"`
+	Framing  int64 `json:"framing,omitempty"`
+	Payloads int64 `json:"payloads,omitempty"`
+	Data     int64 `json:"data"`
+}
+
+// keySummary is how often a key of the status shows up in the
+// snapshots of a store, and how often its value changed between them.
+type keySummary struct {
+	Key       string `json:"key"`
+	Snapshots int    `json:"snapshots"`
+	Changes   int    `json:"changes"`
+}
+
+// summaryCommand prints what a store holds: how many snapshots, over
+// what time, the keys in them and the size of the file.
+func summaryCommand(args []string) error {
+	sess := &summarySession{}
+
+	flags := flag.NewFlagSet("summary", flag.ExitOnError)
+	flags.StringVar(&sess.format, "format", formatText, "output format: text or json")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cynic-store summary [-format text|json] <store>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errSummaryArgs
+	}
+	path := flags.Arg(0)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	store, err := readStoreFile(path)
+	if err != nil {
+		return fmt.Errorf("problem decoding store: %s: %w", path, err)
+	}
+
+	summary, err := summarize(&store, info.Size())
+	if err != nil {
+		return err
+	}
+	summary.File = path
+
+	switch sess.format {
+	case formatText:
+		printSummary(os.Stdout, summary)
+		return nil
+	case formatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summary)
+	default:
+		return fmt.Errorf("%w: %s", errUnknownFormat, sess.format)
+	}
+}
+
+func summarize(store *cynic.SnapshotStore, size int64) (*storeSummary, error) {
+	summary := &storeSummary{
+		Version:   store.Version,
+		Format:    "gob",
+		Gzip:      store.Flags&uint8(cynic.SnapshotCompressionGzip) != 0,
+		Encrypted: store.Flags&cynic.SnapshotFlagEncrypted != 0,
+		Snapshots: len(store.Snapshots),
+		Sizes:     summarySizes{File: size},
+		Keys:      []keySummary{},
+	}
+
+	snapshots := make([]*cynic.Snapshot, len(store.Snapshots))
+	copy(snapshots, store.Snapshots)
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp < snapshots[j].Timestamp
+	})
+
+	if len(snapshots) > 0 {
+		summary.First = snapshots[0].Timestamp
+		summary.Last = snapshots[len(snapshots)-1].Timestamp
+	}
+
+	keys := map[string]*keySummary{}
+	previous := map[string]json.RawMessage{}
+	for _, snp := range snapshots {
+		summary.Sizes.Data += int64(len(snp.Data))
+
+		status, err := decodeStatus(snp)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range status {
+			ks, ok := keys[key]
+			if !ok {
+				ks = &keySummary{Key: key}
+				keys[key] = ks
+			}
+			ks.Snapshots++
+
+			if old, seen := previous[key]; seen && !jsonEqual(old, value) {
+				ks.Changes++
+			}
+		}
+		previous = status
+	}
+
+	for _, ks := range keys {
+		summary.Keys = append(summary.Keys, *ks)
+	}
+	sort.Slice(summary.Keys, func(i, j int) bool {
+		return summary.Keys[i].Key < summary.Keys[j].Key
+	})
+
+	if store.Version == streamVersion {
+		summary.Format = "stream"
+		summary.Sizes.Header = streamHeaderSize
+		summary.Sizes.Framing = int64(streamRecordSize * len(snapshots))
+		summary.Sizes.Payloads = size - summary.Sizes.Header - summary.Sizes.Framing
+	}
+
+	return summary, nil
+}
+
+func printSummary(w io.Writer, summary *storeSummary) {
+	fmt.Fprintf(w, "file:       %s\n", summary.File)
+	fmt.Fprintf(w, "version:    %d (%s)\n", summary.Version, summary.Format)
+	fmt.Fprintf(w, "gzip:       %t\n", summary.Gzip)
+	fmt.Fprintf(w, "encrypted:  %t\n", summary.Encrypted)
+	fmt.Fprintf(w, "snapshots:  %d\n", summary.Snapshots)
+
+	if summary.Snapshots > 0 {
+		first := time.Unix(summary.First, 0).UTC()
+		last := time.Unix(summary.Last, 0).UTC()
+		fmt.Fprintf(w, "span:       %s to %s (%s)\n",
+			first.Format(time.RFC3339), last.Format(time.RFC3339), last.Sub(first))
+	}
+
+	fmt.Fprintf(w, "size:       %d bytes\n", summary.Sizes.File)
+	if summary.Format == "stream" {
+		fmt.Fprintf(w, "  header:   %d bytes\n", summary.Sizes.Header)
+		fmt.Fprintf(w, "  framing:  %d bytes\n", summary.Sizes.Framing)
+		fmt.Fprintf(w, "  payloads: %d bytes\n", summary.Sizes.Payloads)
+	}
+	fmt.Fprintf(w, "  data:     %d bytes, decoded\n", summary.Sizes.Data)
+
+	fmt.Fprintf(w, "keys:       %d\n", len(summary.Keys))
+	for _, ks := range summary.Keys {
+		fmt.Fprintf(w, "  %s: in %d snapshots, changed %d times\n", ks.Key, ks.Snapshots, ks.Changes)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Use this to run cynic as a daemon, from a config file.
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+type session struct {
+	config   string
+	pidFile  string
+	poll     time.Duration
+	simulate time.Duration
+	logTo    string
+	location string
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: cynic [flags] -config <file>")
+	fmt.Fprintln(out, "       cynic init [flags] [directory]")
+	fmt.Fprintln(out, "\nSIGINT and SIGTERM shut down gracefully: snapshots are flushed,")
+	fmt.Fprintln(out, "pending alerts are delivered, and the status server is stopped.")
+	fmt.Fprintln(out, "SIGHUP reloads the config file.")
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+}
+
+func simulate(sess *session) error {
+	config, err := cynic.LoadConfig(sess.config)
+	if err != nil {
+		return err
+	}
+
+	runs, err := config.Simulate(sess.simulate)
+	if err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		fmt.Printf("%-10s %-20s %s\n", run.After, run.Group, run.Label)
+	}
+
+	return nil
+}
+
+// setLogOutput sends the logs to syslog or the journal, if asked to.
+func setLogOutput(logTo string) error {
+	switch logTo {
+	case "", "stderr":
+	case "syslog":
+		log.SetFlags(0)
+		log.SetOutput(cynic.SyslogSinkNew("", ""))
+	case "journald":
+		log.SetFlags(0)
+		log.SetOutput(cynic.JournaldSinkNew())
+	default:
+		return fmt.Errorf("unknown log output: %s", logTo)
+	}
+	return nil
+}
+
+func run(sess *session) error {
+	watcher, cynicSession, err := cynic.ConfigWatcherNew(sess.config)
+	if err != nil {
+		return err
+	}
+	watcher.SetPollInterval(sess.poll)
+
+	if sess.location != "" {
+		cynicSession.Location = sess.location
+	}
+
+	if sess.pidFile != "" {
+		if err := writePidFile(sess.pidFile); err != nil {
+			return err
+		}
+		defer removePidFile(sess.pidFile)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	stop := make(chan struct{})
+	go watcher.Watch(stop)
+	defer close(stop)
+
+	log.Println("cynic started, events:", len(watcher.Events()))
+	cynic.Run(ctx, cynicSession)
+	log.Println("cynic stopped")
+
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		if err := initCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sess := &session{}
+
+	flag.StringVar(&sess.config, "config", "", "json, yaml or toml config file describing events, alerts and snapshots")
+	flag.StringVar(&sess.pidFile, "pidfile", "", "write the process id to this file while running")
+	flag.DurationVar(&sess.poll, "poll", 5*time.Second, "how often to check the config for changes (0 to disable)")
+	flag.DurationVar(&sess.simulate, "simulate", 0, "print when events would run over this duration, and exit")
+	flag.StringVar(&sess.logTo, "log", "stderr", "where logs go: stderr, syslog or journald")
+	flag.StringVar(&sess.location, "location", "", "where this instance probes from, overriding the config")
+	flag.Usage = usage
+	flag.Parse()
+
+	if sess.config == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := setLogOutput(sess.logTo); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if sess.simulate > 0 {
+		if err := simulate(sess); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := run(sess); err != nil {
+		log.Fatal(err)
+	}
+}
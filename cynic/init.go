@@ -0,0 +1,69 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+var errInitArgs = fmt.Errorf("init takes at most one directory")
+
+// initCommand writes a starter project, to build a monitor with hooks
+// of its own from.
+func initCommand(args []string) error {
+	project := cynic.ScaffoldProject{}
+
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	flags.StringVar(&project.Name, "name", "", "name of the binary and service, by default the directory")
+	flags.StringVar(&project.Module, "module", "", "go module of the project, by default its name")
+	flags.StringVar(&project.Port, "port", "", "port of the status server (default 9999)")
+	flags.BoolVar(&project.Force, "force", false, "overwrite files that already exist")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: cynic init [flags] [directory]")
+		fmt.Fprintln(flags.Output(), "\nwrites a starter project: a config, a main with named hooks,")
+		fmt.Fprintln(flags.Output(), "a systemd unit, and a docker compose file with a demo target.")
+		fmt.Fprintln(flags.Output(), "\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	dir := "."
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		dir = flags.Arg(0)
+	default:
+		return errInitArgs
+	}
+
+	written, err := cynic.Scaffold(dir, project)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range written {
+		fmt.Println("wrote", path)
+	}
+	fmt.Println("\nsee README.md in", dir, "to run it")
+
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var errAlreadyRunning = errors.New("cynic is already running")
+
+// writePidFile writes the pid of this process to path. A pid file
+// left behind by a process that is gone is overwritten.
+func writePidFile(path string) error {
+	if pid, ok := readPidFile(path); ok && processAlive(pid) {
+		return fmt.Errorf("%w: pid %d in %s", errAlreadyRunning, pid, path)
+	}
+
+	pid := strconv.Itoa(os.Getpid()) + "\n"
+	return ioutil.WriteFile(path, []byte(pid), 0o600)
+}
+
+func removePidFile(path string) {
+	if err := os.Remove(path); err != nil {
+		log.Println("could not remove pid file: ", err)
+	}
+}
+
+func readPidFile(path string) (int, bool) {
+	contents, err := ioutil.ReadFile(path) // #nosec
+	if err != nil {
+		return 0, false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+
+	return pid, true
+}
+
+func processAlive(pid int) bool {
+	if pid == os.Getpid() {
+		return false
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return process.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+var errRequestFailed = fmt.Errorf("request failed")
+
+// client talks to the admin interface of a cynic status server.
+type client struct {
+	addr  string
+	token string
+	root  string
+	http  *http.Client
+}
+
+func clientNew(addr, token, root string) *client {
+	return &client{
+		addr:  addr,
+		token: token,
+		root:  root,
+		http:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// do sends a request with an optional json body, and decodes the json
+// response into out, if given.
+func (s *client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), method, s.addr+path, reader)
+	if err != nil {
+		return err
+	}
+
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%w: %s: %s", errRequestFailed, resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("%w: %s", errRequestFailed, resp.Status)
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+
+	if raw, ok := out.(*json.RawMessage); ok {
+		*raw = data
+		return nil
+	}
+
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+var errNeedID = fmt.Errorf("an id is required")
+
+func listEvents(client *client, _ []string) error {
+	var state cynic.PlannerState
+	if err := client.do(http.MethodGet, "/admin/planner", nil, &state); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLABEL\tGROUP\tEVERY\tREPEAT\tSEVERITY\tNEXT RUN")
+
+	for _, event := range state.Events {
+		// the planner ticks once a second
+		nextRun := fmt.Sprintf("in %s", time.Duration(event.NextTick-int64(state.Ticks))*time.Second)
+		if event.Disabled {
+			nextRun = "disabled"
+		}
+		if event.SkippedRuns > 0 {
+			nextRun += fmt.Sprintf(" (%d skipped, still running)", event.SkippedRuns)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\t%s\t%s\n",
+			event.ID, event.Label, event.Group, time.Duration(event.Secs)*time.Second,
+			event.Repeat, event.Severity, nextRun)
+	}
+
+	return w.Flush()
+}
+
+func showPlanner(client *client, _ []string) error {
+	return printJSON(client, http.MethodGet, "/admin/planner", nil)
+}
+
+func showStatus(client *client, args []string) error {
+	key := ""
+	if len(args) > 0 {
+		key = url.PathEscape(args[0])
+	}
+	return printJSON(client, http.MethodGet, client.root+key, nil)
+}
+
+func addEvent(client *client, args []string) error {
+	var config cynic.EventConfig
+	var interval, offset time.Duration
+	var severity, hooks, status, contains string
+	var maxLatency time.Duration
+
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	flags.StringVar(&config.Label, "label", "", "label of the event")
+	flags.StringVar(&config.Group, "group", "", "group of the event")
+	flags.StringVar(&config.URL, "url", "", "url to probe")
+	flags.DurationVar(&interval, "every", time.Minute, "how often the event runs")
+	flags.DurationVar(&offset, "offset", 0, "delay before the first run")
+	flags.BoolVar(&config.Repeat, "repeat", true, "run the event repeatedly")
+	flags.BoolVar(&config.Immediate, "now", false, "run the event right away")
+	flags.StringVar(&severity, "severity", "", "info, warning or critical")
+	flags.StringVar(&hooks, "hooks", "", "comma separated names of registered hooks")
+	flags.StringVar(&status, "expect-status", "", "status code the url must respond with")
+	flags.StringVar(&contains, "expect-body", "", "text the body of the url must contain")
+	flags.DurationVar(&maxLatency, "max-latency", 0, "latency the url must respond within")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config.Interval = cynic.ConfigDuration(interval)
+	config.Offset = cynic.ConfigDuration(offset)
+
+	if severity != "" {
+		sev, err := cynic.ParseSeverity(severity)
+		if err != nil {
+			return err
+		}
+		config.Severity = &sev
+	}
+
+	if hooks != "" {
+		config.Hooks = strings.Split(hooks, ",")
+	}
+
+	if status != "" || contains != "" || maxLatency > 0 {
+		contract := cynic.ContractConfig{Contains: contains, MaxLatency: cynic.ConfigDuration(maxLatency)}
+		if status != "" {
+			code, err := strconv.Atoi(status)
+			if err != nil {
+				return err
+			}
+			contract.Status = code
+		}
+		config.Contracts = []cynic.ContractConfig{contract}
+	}
+
+	var created cynic.EventState
+	if err := client.do(http.MethodPost, "/admin/events", config, &created); err != nil {
+		return err
+	}
+
+	fmt.Println("added event", created.ID)
+	return nil
+}
+
+func removeEvent(client *client, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return client.do(http.MethodDelete, "/admin/events?id="+id, nil, nil)
+}
+
+func runEvent(client *client, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	wait := flags.Bool("wait", false, "wait for the event to run, and print what it did")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	id, err := idArg(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	if *wait {
+		return printJSON(client, http.MethodPost, "/admin/events/run?wait=true&id="+id, nil)
+	}
+	return client.do(http.MethodPost, "/admin/events/run?id="+id, nil, nil)
+}
+
+func disableEvent(client *client, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return client.do(http.MethodPost, "/admin/events/disable?id="+id, nil, nil)
+}
+
+func enableEvent(client *client, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return client.do(http.MethodPost, "/admin/events/enable?id="+id, nil, nil)
+}
+
+func muteAlerts(client *client, args []string) error {
+	var rule struct {
+		cynic.MuteRule
+		Duration string `json:"duration"`
+	}
+
+	flags := flag.NewFlagSet("mute", flag.ExitOnError)
+	flags.Uint64Var(&rule.EventID, "event", 0, "id of the event to mute")
+	flags.StringVar(&rule.Label, "label", "", "label of the events to mute")
+	flags.StringVar(&rule.Group, "group", "", "group of the events to mute")
+	flags.StringVar(&rule.Duration, "for", "1h", "how long to mute for")
+	flags.StringVar(&rule.Reason, "reason", "", "why the alerts are muted")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var created cynic.MuteRule
+	if err := client.do(http.MethodPost, "/admin/mutes", rule, &created); err != nil {
+		return err
+	}
+
+	fmt.Println("added mute", created.ID, "until", created.Until.Format(time.RFC3339))
+	return nil
+}
+
+func unmuteAlerts(client *client, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	return client.do(http.MethodDelete, "/admin/mutes?id="+id, nil, nil)
+}
+
+func listMutes(client *client, _ []string) error {
+	return printJSON(client, http.MethodGet, "/admin/mutes", nil)
+}
+
+func showCapture(client *client, args []string) error {
+	path := "/admin/captures"
+	if len(args) > 0 {
+		path += "?key=" + url.QueryEscape(args[0])
+	}
+	return printJSON(client, http.MethodGet, path, nil)
+}
+
+func testAlert(client *client, args []string) error {
+	var alert cynic.TestAlert
+
+	flags := flag.NewFlagSet("test-alert", flag.ExitOnError)
+	flags.StringVar(&alert.Label, "label", "", "label of the alert, to check routing")
+	flags.StringVar(&alert.Group, "group", "", "group of the alert, to check routing")
+	severity := flags.String("severity", "warning", "severity of the alert: info, warning or critical")
+	flags.StringVar(&alert.Message, "message", "", "message of the alert")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	sev, err := cynic.ParseSeverity(*severity)
+	if err != nil {
+		return err
+	}
+	alert.Severity = sev
+
+	var sent cynic.AlertMessage
+	if err := client.do(http.MethodPost, "/admin/alerts/test", alert, &sent); err != nil {
+		return err
+	}
+
+	fmt.Println("sent test alert", sent.Fingerprint, "as", sent.Label)
+	return nil
+}
+
+func idArg(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errNeedID
+	}
+	if _, err := strconv.ParseUint(args[0], 10, 64); err != nil {
+		return "", fmt.Errorf("%w: %s", errNeedID, err.Error())
+	}
+	return args[0], nil
+}
+
+func printJSON(client *client, method, path string, body interface{}) error {
+	var raw json.RawMessage
+	if err := client.do(method, path, body, &raw); err != nil {
+		return err
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, raw, "", "  "); err != nil {
+		_, err = os.Stdout.Write(raw)
+		return err
+	}
+
+	fmt.Println(indented.String())
+	return nil
+}
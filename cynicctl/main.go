@@ -0,0 +1,106 @@
+/*
+Use this to control a running cynic through its admin interface.
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+type session struct {
+	addr  string
+	token string
+	root  string
+}
+
+// commands take a client, and the arguments after their name.
+var commands = map[string]struct {
+	run  func(client *client, args []string) error
+	help string
+}{
+	"events":  {listEvents, "list events and their next run"},
+	"planner": {showPlanner, "show the state of the planner"},
+	"status":  {showStatus, "status [key]: show the status, or the entry of a key"},
+	"add":     {addEvent, "add [flags]: add an event, see add -h"},
+	"rm":      {removeEvent, "rm <id>: remove an event"},
+	"run":     {runEvent, "run [-wait] <id>: run an event now"},
+	"disable": {disableEvent, "disable <id>: skip the runs of an event"},
+	"enable":  {enableEvent, "enable <id>: run a disabled event again"},
+	"mute":    {muteAlerts, "mute [flags]: silence alerts, see mute -h"},
+	"unmute":  {unmuteAlerts, "unmute <id>: remove a mute"},
+	"mutes":   {listMutes, "list the mutes"},
+	"capture": {showCapture, "capture [key]: show the last failures probes captured, or the one of a key"},
+
+	"test-alert": {testAlert, "test-alert [flags]: send a test alert to the sinks, see test-alert -h"},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: cynicctl [flags] <command> [args]")
+	fmt.Fprintln(out, "\ncommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].help)
+	}
+
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	sess := &session{
+		addr:  "http://127.0.0.1:9999",
+		token: os.Getenv("CYNIC_ADMIN_TOKEN"),
+		root:  "/status/",
+	}
+
+	flag.StringVar(&sess.addr, "addr", sess.addr, "address of the cynic status server")
+	flag.StringVar(&sess.token, "token", sess.token, "admin token (default $CYNIC_ADMIN_TOKEN)")
+	flag.StringVar(&sess.root, "root", sess.root, "root of the status endpoint")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command:", args[0])
+		usage()
+		os.Exit(2)
+	}
+
+	client := clientNew(strings.TrimSuffix(sess.addr, "/"), sess.token, sess.root)
+	if err := command.run(client, args[1:]); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
@@ -32,12 +32,13 @@ type alertInfo struct {
 	Timestamp int64
 }
 
-func alerter(alerts []cynic.AlertMessage) {
+func alerter(alerts []cynic.AlertMessage) error {
 	for _, alert := range alerts {
 		info := alert.Response.(alertInfo)
 		log.Println("ALERT: ", info.Name, ": ", info.Desc)
 		log.Println("  problematic timestamp: ", info.Timestamp)
 	}
+	return nil
 }
 
 func main() {
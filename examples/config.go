@@ -0,0 +1,52 @@
+// +build ignore
+
+/*
+Example code on cynic usage.
+
+Copyright 2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func main() {
+	configPath := "examples/config.json"
+	flag.StringVar(&configPath, "config", configPath, "path to the config file")
+	flag.Parse()
+
+	// named hooks can be referred to by events in the config
+	cynic.RegisterHook("hello", func(params *cynic.HookParameters) (bool, interface{}) {
+		log.Println("hello from a named hook")
+		return false, nil
+	})
+
+	// the watcher reloads the events when the file changes, or on
+	// SIGHUP
+	watcher, session, err := cynic.ConfigWatcherNew(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go watcher.Watch(stop)
+
+	cynic.Start(session)
+}
@@ -66,7 +66,7 @@ func usage() {
 
 // This is to show that you can have a simple alerter, if something is
 // detected to be awry in the monitoring.
-func exampleAlerter(messages []cynic.AlertMessage) {
+func exampleAlerter(messages []cynic.AlertMessage) error {
 	fmt.Println("############################################")
 	fmt.Println("# Hey you! Better pay attention!            ")
 	fmt.Println("############################################")
@@ -81,6 +81,7 @@ func exampleAlerter(messages []cynic.AlertMessage) {
 	}
 
 	fmt.Println("##################################")
+	return nil
 }
 
 func handleLog(logPath string) {
@@ -244,7 +245,12 @@ func main() {
 		events[i].SetDataRepo(&statusServer)
 	}
 
-	alerter := cynic.AlerterNew(20, exampleAlerter)
+	alertFn := exampleAlerter
+	if slackHook != "" {
+		alertFn = cynic.SlackAlerterNew(slackHook).Alert
+	}
+
+	alerter := cynic.AlerterNew(20, alertFn)
 	session := cynic.Session{
 		Events:      events,
 		Alerter:     &alerter,
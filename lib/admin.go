@@ -0,0 +1,475 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// muteRequest is what is posted to the mutes endpoint. Either Until
+// or Duration (eg "2h30m") must be given.
+type muteRequest struct {
+	MuteRule
+	Duration string `json:"duration,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Println("problem writing json response: ", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *StatusCache) handleMutes(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.alerter.Mutes())
+
+	case http.MethodPost:
+		var muteReq muteRequest
+		if err := json.NewDecoder(req.Body).Decode(&muteReq); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		rule := muteReq.MuteRule
+		if muteReq.Duration != "" {
+			duration, err := time.ParseDuration(muteReq.Duration)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			rule.Until = s.alerter.clock.Now().Add(duration)
+		}
+
+		id, err := s.alerter.Mute(rule)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		rule.ID = id
+		writeJSON(w, http.StatusCreated, rule)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(req.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if !s.alerter.Unmute(id) {
+			writeJSONError(w, http.StatusNotFound, ErrMuteRuleNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type ackRequest struct {
+	EventID uint64 `json:"event_id"`
+}
+
+func (s *StatusCache) handleAck(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ack ackRequest
+	if err := json.NewDecoder(req.Body).Decode(&ack); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.alerter.Acknowledge(ack.EventID); err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTestAlert sends the test alert of the body, or the default
+// one, through the alerter, and returns it.
+func (s *StatusCache) handleTestAlert(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var alert TestAlert
+	if err := json.NewDecoder(req.Body).Decode(&alert); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), testAlertTimeout)
+	defer cancel()
+
+	msg, err := s.alerter.TestFire(ctx, alert)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func (s *StatusCache) handleAlerts(w http.ResponseWriter, req *http.Request) {
+	filter, err := alertHistoryFilterFromQuery(req.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.alerter.History(filter))
+}
+
+func (s *StatusCache) handleActiveAlerts(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.alerter.ActiveAlerts())
+}
+
+func (s *StatusCache) handleIncidents(w http.ResponseWriter, req *http.Request) {
+	filter, err := incidentFilterFromQuery(req.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.incidents.Incidents(filter))
+}
+
+func (s *StatusCache) handleIncidentStats(w http.ResponseWriter, req *http.Request) {
+	filter, err := incidentFilterFromQuery(req.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.incidents.Stats(filter))
+}
+
+// incidentFilterFromQuery reads the since (RFC3339), event (id) and
+// open (bool) query parameters.
+func incidentFilterFromQuery(query url.Values) (IncidentFilter, error) {
+	var filter IncidentFilter
+	var err error
+
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, err
+		}
+	}
+
+	if event := query.Get("event"); event != "" {
+		if filter.EventID, err = strconv.ParseUint(event, 10, 64); err != nil {
+			return filter, err
+		}
+	}
+
+	if open := query.Get("open"); open != "" {
+		if filter.OnlyOpen, err = strconv.ParseBool(open); err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
+
+// alertHistoryFilterFromQuery reads the since and until (RFC3339),
+// event (id) and severity (name) query parameters.
+func alertHistoryFilterFromQuery(query url.Values) (AlertHistoryFilter, error) {
+	var filter AlertHistoryFilter
+	var err error
+
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, err
+		}
+	}
+
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, err
+		}
+	}
+
+	if event := query.Get("event"); event != "" {
+		if filter.EventID, err = strconv.ParseUint(event, 10, 64); err != nil {
+			return filter, err
+		}
+	}
+
+	if severityName := query.Get("severity"); severityName != "" {
+		severity, err := ParseSeverity(severityName)
+		if err != nil {
+			return filter, err
+		}
+		filter.Severity = &severity
+	}
+
+	if filter.Tags, err = tagsFromQuery(query); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// tagsFromQuery reads the tag query parameters, each as key:value.
+func tagsFromQuery(query url.Values) (map[string]string, error) {
+	values := query["tag"]
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	tags := make(map[string]string, len(values))
+	for _, value := range values {
+		i := strings.Index(value, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrBadTag, value)
+		}
+		tags[value[:i]] = value[i+1:]
+	}
+
+	return tags, nil
+}
+
+// AdminConfig configures the admin interface. Requests must carry the
+// token as a bearer token.
+type AdminConfig struct {
+	Token string
+}
+
+// requireAdmin rejects requests without the admin token, and all of
+// them when the admin interface is not enabled.
+func (s *StatusCache) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if s.admin == nil {
+			writeJSONError(w, http.StatusForbidden, ErrAdminDisabled)
+			return
+		}
+		if !s.admin.authorized(req) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, ErrAdminUnauthorized)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+func (s *AdminConfig) authorized(req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return s.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+func (s *StatusCache) control() *ControlService {
+	return ControlServiceNew(s.planner, s, s.alerter)
+}
+
+// handleEvents lists (GET), adds (POST an EventConfig) and deletes
+// (DELETE ?id=) events.
+func (s *StatusCache) handleEvents(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.control().ListEvents())
+
+	case http.MethodPost:
+		var config EventConfig
+		if err := json.NewDecoder(req.Body).Decode(&config); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		state, err := s.control().AddEvent(config)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, state)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(req.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.control().DeleteEvent(id); err != nil {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// executionResponse is the json of an execution result.
+type executionResponse struct {
+	ExecutionResult
+	Error string `json:"error,omitempty"`
+}
+
+// handleRunEvent runs an event (POST ?id=) right away, on top of its
+// schedule. With wait=true, it responds once the event ran, with what
+// it did.
+func (s *StatusCache) handleRunEvent(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseUint(req.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if wait, _ := strconv.ParseBool(req.URL.Query().Get("wait")); wait {
+		execution, err := s.control().RunEventAndWait(id)
+		if err != nil {
+			writeJSONError(w, runEventStatus(err), err)
+			return
+		}
+
+		response := executionResponse{ExecutionResult: execution}
+		if execution.Err != nil {
+			response.Error = execution.Err.Error()
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	if err := s.control().RunEvent(id); err != nil {
+		writeJSONError(w, runEventStatus(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// runEventStatus is the status of a response to an event that could
+// not be run.
+func runEventStatus(err error) int {
+	if errors.Is(err, ErrPlannerStopped) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusNotFound
+}
+
+// handleToggleEvent disables or enables an event (POST ?id=), and
+// responds with its state.
+func (s *StatusCache) handleToggleEvent(disable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseUint(req.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		toggle := s.control().EnableEvent
+		if disable {
+			toggle = s.control().DisableEvent
+		}
+
+		state, err := toggle(id)
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, state)
+	}
+}
+
+func (s *StatusCache) handlePlanner(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.planner.State())
+}
+
+// handleLateness shows how late the events of the planner ran, after
+// they were due, overall and per event.
+func (s *StatusCache) handleLateness(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.planner.Lateness())
+}
+
+// handleJournal lists the entries of the journal of the planner,
+// filtered by the since (RFC3339), event (id) and kind query
+// parameters.
+func (s *StatusCache) handleJournal(w http.ResponseWriter, req *http.Request) {
+	journal := s.planner.Journal()
+	if journal == nil {
+		writeJSONError(w, http.StatusNotFound, ErrNoPlannerJournal)
+		return
+	}
+
+	filter, err := journalFilterFromQuery(req.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, journal.Entries(filter))
+}
+
+func journalFilterFromQuery(query url.Values) (JournalFilter, error) {
+	filter := JournalFilter{Kind: JournalEntryKind(query.Get("kind"))}
+	var err error
+
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, err
+		}
+	}
+
+	if event := query.Get("event"); event != "" {
+		if filter.EventID, err = strconv.ParseUint(event, 10, 64); err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
@@ -18,21 +18,41 @@ limitations under the License.
 package cynic
 
 import (
+	"context"
+	"log"
 	"time"
 )
 
-// AlertFunc defines the hook signature for alert messages.
-type AlertFunc = func([]AlertMessage)
+const (
+	// minAlertBackoff is how long the alerter waits before giving a
+	// batch the alert hook failed on another go, doubling every failure
+	// up to maxAlertBackoff.
+	minAlertBackoff = time.Second
+	maxAlertBackoff = 5 * time.Minute
+)
+
+// AlertFunc defines the hook signature for alert messages. It returns
+// an error if the alerts were not delivered, in which case they stay
+// queued, and are given to it again after a backoff.
+type AlertFunc = func([]AlertMessage) error
 
 // Alerter is an entity that ticks, and if there are alert messages,
 // will fire up behavior.
 type Alerter struct {
-	alerts     []AlertMessage
-	Ch         chan AlertMessage
-	stopCh     chan int
-	waitTime   int
-	waitTicker *time.Ticker
-	alerterFn  AlertFunc
+	queue     *alertQueue
+	Ch        chan AlertMessage
+	testCh    chan AlertMessage
+	stopCh    chan int
+	drainCh   chan chan struct{}
+	doneCh    chan alertDelivery
+	waitTime  int
+	clock     Clock
+	alerterFn AlertFunc
+	dedup     *AlertDeduper
+	mutes     *muteList
+	digest    *DigestConfig
+	history   *AlertHistory
+	active    *activeAlerts
 }
 
 // AlertMessage defines a simple alert structure that can be used by
@@ -42,28 +62,180 @@ type AlertMessage struct {
 	Response      interface{} `json:"response_text"`
 	Now           string      `json:"now"`
 	CynicHostname string      `json:"cynic_hostname"`
+	Location      string      `json:"location,omitempty"`
+	Label         string      `json:"label"`
+	Group         string      `json:"group"`
+	Severity      Severity    `json:"severity"`
+	EventID       uint64      `json:"event_id"`
+	Fingerprint   string      `json:"fingerprint"`
+
+	// RootCause is what the alert is likely caused by, like the host
+	// of a failed probe and the class of its error, which alerts of
+	// different events may share. See AlertRootCause.
+	RootCause string `json:"root_cause,omitempty"`
+
+	// Endpoint is the url that failed, if the event probes one. See
+	// AlertEndpoint.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Tags are the tags of the event. They must not be changed.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// alertDelivery is the outcome of giving the batch of alerts up to seq
+// to the alert hook.
+type alertDelivery struct {
+	seq uint64
+	err error
+}
+
+// DigestConfig configures digest mode: alerts below ImmediateSeverity
+// are held back and sent every Interval as a single summary message,
+// while the rest are sent right away.
+type DigestConfig struct {
+	Interval          time.Duration
+	ImmediateSeverity Severity
+}
+
+// AlertDigest is the response of the summary message sent in digest
+// mode.
+type AlertDigest struct {
+	Count  int            `json:"count"`
+	Alerts []AlertMessage `json:"alerts"`
+}
+
+// AlertFanout returns an alert hook giving the alerts to each of the
+// given hooks, in order. It returns the first error of the hooks, once
+// they all had the alerts, so the hooks that did not fail get them
+// again when they are retried.
+func AlertFanout(hooks ...AlertFunc) AlertFunc {
+	return func(alerts []AlertMessage) error {
+		var first error
+		for _, hook := range hooks {
+			if err := hook(alerts); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
 }
 
 // AlerterNew creates a new alerter.
 func AlerterNew(waitTime int, alerter AlertFunc) Alerter {
-	var alerts []AlertMessage
 	ch := make(chan AlertMessage)
 	stop := make(chan int)
-	ticker := time.NewTicker(time.Second * time.Duration(waitTime))
+	done := make(chan alertDelivery, 1)
 
 	return Alerter{
-		alerts:     alerts,
-		Ch:         ch,
-		stopCh:     stop,
-		waitTime:   waitTime,
-		waitTicker: ticker,
-		alerterFn:  alerter,
+		queue:     alertQueueNew(AlertQueueConfig{}),
+		Ch:        ch,
+		testCh:    make(chan AlertMessage),
+		stopCh:    stop,
+		drainCh:   make(chan chan struct{}),
+		doneCh:    done,
+		waitTime:  waitTime,
+		clock:     SystemClock,
+		alerterFn: alerter,
+		mutes:     &muteList{},
+		history:   AlertHistoryNew(AlertHistoryConfig{}),
+		active:    activeAlertsNew(),
 	}
 }
 
+// WithDedup will make the alerter drop repeated alerts, and alerts
+// of flapping events.
+func (s *Alerter) WithDedup(config *DedupConfig) {
+	s.dedup = AlertDeduperNew(*config)
+}
+
+// WithQueue configures the size and overflow behavior of the queue
+// of pending alerts, and whether it is spilled to disk. Alerts that
+// were spilled by a previous run are loaded back.
+func (s *Alerter) WithQueue(config *AlertQueueConfig) {
+	s.queue = alertQueueNew(*config)
+}
+
+// WithDigest enables digest mode.
+func (s *Alerter) WithDigest(config *DigestConfig) {
+	s.digest = config
+}
+
+// WithClock sets the clock the alerter tells time and waits with.
+func (s *Alerter) WithClock(clock Clock) {
+	s.clock = clockOr(clock)
+}
+
+// WithHistory configures how many alert records are kept, and
+// whether they are persisted.
+func (s *Alerter) WithHistory(config *AlertHistoryConfig) {
+	s.history = AlertHistoryNew(*config)
+}
+
+// History returns the alert records matching the filter.
+func (s *Alerter) History(filter AlertHistoryFilter) []AlertRecord {
+	return s.history.Query(filter)
+}
+
+// ActiveAlerts returns the events that have alerted and not recovered
+// since.
+func (s *Alerter) ActiveAlerts() []ActiveAlert {
+	return s.active.list()
+}
+
+// Acknowledge marks the active alert of an event as acknowledged, so
+// that it is not notified again until the event recovers.
+func (s *Alerter) Acknowledge(eventID uint64) error {
+	now := s.clock.Now()
+
+	alert, ok := s.active.acknowledge(eventID, now)
+	if !ok {
+		return ErrNoActiveAlert
+	}
+
+	s.record(AlertRecordAcknowledged, &alert.LastMessage, now)
+	return nil
+}
+
+// Pending returns the number of alerts waiting to be delivered.
+func (s *Alerter) Pending() int {
+	return s.queue.len()
+}
+
+// Dropped returns the number of alerts that were discarded because
+// the queue was full.
+func (s *Alerter) Dropped() uint64 {
+	return s.queue.droppedCount()
+}
+
+// Mute silences the alerts matching the rule until the rule
+// expires. The returned id can be used to unmute.
+func (s *Alerter) Mute(rule MuteRule) (uint64, error) {
+	return s.mutes.add(rule)
+}
+
+// Unmute removes a mute rule. Returns false if there was no such
+// rule.
+func (s *Alerter) Unmute(id uint64) bool {
+	return s.mutes.remove(id)
+}
+
+// Mutes returns the mute rules that are currently in effect.
+func (s *Alerter) Mutes() []MuteRule {
+	return s.mutes.active(s.clock.Now())
+}
+
 // Start begins the alerter.
 func (s *Alerter) Start() {
-	go s.run()
+	// the tickers are made before returning, so that a manual clock
+	// can be advanced right after
+	waitTicker := s.clock.NewTicker(time.Second * time.Duration(s.waitTime))
+
+	var digestTicker Ticker
+	if s.digest != nil {
+		digestTicker = s.clock.NewTicker(s.digest.Interval)
+	}
+
+	go s.run(waitTicker, digestTicker)
 }
 
 // Stop the alerter.
@@ -71,21 +243,239 @@ func (s *Alerter) Stop() {
 	s.stopCh <- 0
 }
 
-func (s *Alerter) run() {
-	defer s.waitTicker.Stop()
+// Shutdown delivers the pending alerts, digested ones included, and
+// stops the alerter. It stops waiting on the alert hook once ctx is
+// done.
+func (s *Alerter) Shutdown(ctx context.Context) {
+	drained := make(chan struct{})
+
+	select {
+	case s.drainCh <- drained:
+	case <-ctx.Done():
+		return
+	}
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+}
+
+func (s *Alerter) run(waitTicker, digestTicker Ticker) {
+	defer waitTicker.Stop()
+
+	var digested []AlertMessage
+	var digestC <-chan time.Time
+	if digestTicker != nil {
+		defer digestTicker.Stop()
+		digestC = digestTicker.C()
+	}
+
+	// the alert hook runs on its own goroutine, so that a slow or
+	// dead sink never blocks the events sending alerts. Batches it
+	// fails on stay queued, and are retried after a backoff.
+	delivering := false
+	var backoff time.Duration
+	var retryAt time.Time
+	deliver := func() {
+		if delivering || s.queue.len() == 0 || s.clock.Now().Before(retryAt) {
+			return
+		}
+
+		alerts, seq := s.queue.peek()
+		delivering = true
+		go func() {
+			s.doneCh <- alertDelivery{seq: seq, err: s.alerterFn(alerts)}
+		}()
+	}
 
 	for {
 		select {
 		case recvAlert := <-s.Ch:
-			s.alerts = append(s.alerts, recvAlert)
-		case <-s.waitTicker.C:
-			if len(s.alerts) > 0 {
-				s.alerterFn(s.alerts)
+			now := s.clock.Now()
+			acknowledged := s.active.raise(&recvAlert, now)
+
+			if acknowledged || s.mutes.isMuted(&recvAlert, now) {
+				continue
+			}
+			if s.dedup != nil && !s.dedup.Allow(recvAlert, now) {
+				continue
+			}
+
+			s.record(AlertRecordAlert, &recvAlert, now)
+
+			if s.digest == nil {
+				s.queue.push(recvAlert)
+			} else if recvAlert.Severity >= s.digest.ImmediateSeverity {
+				s.queue.push(recvAlert)
+				deliver()
+			} else {
+				digested = append(digested, recvAlert)
+			}
+		case testAlert := <-s.testCh:
+			s.record(AlertRecordAlert, &testAlert, s.clock.Now())
+			s.queue.push(testAlert)
+			deliver()
+		case <-waitTicker.C():
+			deliver()
+		case <-digestC:
+			if len(digested) > 0 {
+				s.queue.push(digestMessageNew(digested, s.clock.Now()))
+				digested = nil
+				deliver()
+			}
+		case done := <-s.doneCh:
+			delivering = false
+			if done.err != nil {
+				backoff = nextAlertBackoff(backoff)
+				retryAt = s.clock.Now().Add(backoff)
+				log.Printf("could not deliver %d alerts, retrying in %s: %v", s.queue.len(), backoff, done.err)
+				continue
+			}
+
+			s.queue.ack(done.seq)
+			backoff = 0
+			retryAt = time.Time{}
+
+			// in digest mode, everything queued while delivering
+			// is due right away.
+			if s.digest != nil {
+				deliver()
+			}
+		case drained := <-s.drainCh:
+			if len(digested) > 0 {
+				s.queue.push(digestMessageNew(digested, s.clock.Now()))
 			}
-			var clear []AlertMessage
-			s.alerts = clear
+			s.drain(delivering)
+			close(drained)
+			return
 		case <-s.stopCh:
 			return
 		}
 	}
 }
+
+// drain delivers everything in the queue, waiting for the delivery
+// in flight if there is one. Alerts the hook fails on stay queued, and
+// spilled if the queue spills.
+func (s *Alerter) drain(delivering bool) {
+	if delivering {
+		if done := <-s.doneCh; done.err == nil {
+			s.queue.ack(done.seq)
+		}
+	}
+
+	if s.queue.len() == 0 {
+		return
+	}
+
+	alerts, seq := s.queue.peek()
+	if err := s.alerterFn(alerts); err != nil {
+		log.Printf("could not deliver %d alerts on shutdown: %v", len(alerts), err)
+		return
+	}
+	s.queue.ack(seq)
+}
+
+// nextAlertBackoff doubles the backoff, between minAlertBackoff and
+// maxAlertBackoff.
+func nextAlertBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff < minAlertBackoff {
+		return minAlertBackoff
+	}
+	if backoff > maxAlertBackoff {
+		return maxAlertBackoff
+	}
+	return backoff
+}
+
+func digestMessageNew(alerts []AlertMessage, now time.Time) AlertMessage {
+	severity := SeverityInfo
+	for _, alert := range alerts {
+		if alert.Severity > severity {
+			severity = alert.Severity
+		}
+	}
+
+	return AlertMessage{
+		Response: AlertDigest{
+			Count:  len(alerts),
+			Alerts: alerts,
+		},
+		Now:           now.Format(time.RFC3339),
+		CynicHostname: currentHost(),
+		Location:      digestLocation(alerts),
+		Label:         "digest",
+		Severity:      severity,
+	}
+}
+
+// digestLocation is the location of the alerts of a digest, if they
+// all come from the same one.
+func digestLocation(alerts []AlertMessage) string {
+	if len(alerts) == 0 {
+		return ""
+	}
+
+	location := alerts[0].Location
+	for _, alert := range alerts[1:] {
+		if alert.Location != location {
+			return ""
+		}
+	}
+	return location
+}
+
+// observe records the outcome of an event execution.
+func (s *Alerter) observe(eventID uint64, failing bool) {
+	now := s.clock.Now()
+
+	if s.dedup != nil {
+		s.dedup.Observe(eventID, failing, now)
+	}
+
+	if failing {
+		return
+	}
+
+	if alert, ok := s.active.resolve(eventID); ok {
+		s.record(AlertRecordResolved, &alert.LastMessage, now)
+	}
+}
+
+func (s *Alerter) record(kind AlertRecordKind, msg *AlertMessage, now time.Time) {
+	record := AlertRecord{
+		Time:     now,
+		Kind:     kind,
+		EventID:  msg.EventID,
+		Label:    msg.Label,
+		Group:    msg.Group,
+		Severity: msg.Severity,
+		Tags:     msg.Tags,
+	}
+
+	if kind == AlertRecordAlert {
+		message := *msg
+		record.Message = &message
+	}
+
+	s.history.Record(record)
+}
+
+// AlertEndpoint returns the url a hook result failed on: the url of a
+// probe, or of the failed step of a transaction. It is empty for other
+// results.
+func AlertEndpoint(result interface{}) string {
+	switch result := result.(type) {
+	case ProbeResult:
+		return result.URL
+	case *ProbeResult:
+		return result.URL
+	case TransactionResult:
+		return result.failedURL()
+	case *TransactionResult:
+		return result.failedURL()
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ActiveAlert is an event that has alerted, and has not recovered
+// since.
+type ActiveAlert struct {
+	EventID     uint64       `json:"event_id"`
+	Label       string       `json:"label"`
+	Group       string       `json:"group"`
+	Severity    Severity     `json:"severity"`
+	Since       time.Time    `json:"since"`
+	LastMessage AlertMessage `json:"last_message"`
+
+	// Acknowledged alerts are not notified again until the event
+	// recovers.
+	Acknowledged   bool      `json:"acknowledged"`
+	AcknowledgedAt time.Time `json:"acknowledged_at,omitempty"`
+}
+
+type activeAlerts struct {
+	mux    sync.Mutex
+	alerts map[uint64]*ActiveAlert
+}
+
+func activeAlertsNew() *activeAlerts {
+	return &activeAlerts{alerts: make(map[uint64]*ActiveAlert)}
+}
+
+// raise marks the event of the message as alerting. Returns true if
+// the alert was acknowledged.
+func (s *activeAlerts) raise(msg *AlertMessage, now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	alert, ok := s.alerts[msg.EventID]
+	if !ok {
+		alert = &ActiveAlert{EventID: msg.EventID, Since: now}
+		s.alerts[msg.EventID] = alert
+	}
+
+	alert.Label = msg.Label
+	alert.Group = msg.Group
+	alert.Severity = msg.Severity
+	alert.LastMessage = *msg
+
+	return alert.Acknowledged
+}
+
+func (s *activeAlerts) acknowledge(eventID uint64, now time.Time) (ActiveAlert, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	alert, ok := s.alerts[eventID]
+	if !ok {
+		return ActiveAlert{}, false
+	}
+
+	alert.Acknowledged = true
+	alert.AcknowledgedAt = now
+	return *alert, true
+}
+
+// resolve removes the active alert of the event, if there is one.
+func (s *activeAlerts) resolve(eventID uint64) (ActiveAlert, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	alert, ok := s.alerts[eventID]
+	if !ok {
+		return ActiveAlert{}, false
+	}
+
+	delete(s.alerts, eventID)
+	return *alert, true
+}
+
+// list returns the active alerts, oldest first.
+func (s *activeAlerts) list() []ActiveAlert {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := make([]ActiveAlert, 0, len(s.alerts))
+	for _, alert := range s.alerts {
+		ret = append(ret, *alert)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Since.Before(ret[j].Since)
+	})
+
+	return ret
+}
@@ -0,0 +1,146 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"crypto/sha1" // #nosec: used for fingerprinting, not security
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DedupConfig configures how repeated and flapping alerts are
+// suppressed.
+type DedupConfig struct {
+	// RenotifyAfter is the minimum time before an alert with the
+	// same fingerprint, from the same event, is sent again.
+	RenotifyAfter time.Duration
+
+	// FlapThreshold is the number of state changes within
+	// FlapWindow after which an event is considered flapping. Alerts
+	// of flapping events are suppressed. Zero disables flap
+	// detection.
+	FlapThreshold int
+	FlapWindow    time.Duration
+}
+
+// AlertDeduper decides whether alert messages should be forwarded to
+// the alert hook, given what was sent before and how the events have
+// been behaving.
+type AlertDeduper struct {
+	config DedupConfig
+	mux    sync.Mutex
+	states map[uint64]*eventAlertState
+}
+
+type eventAlertState struct {
+	failing  bool
+	changes  []time.Time
+	lastSent map[string]time.Time
+}
+
+// AlertDeduperNew creates a new deduper with the given configuration.
+func AlertDeduperNew(config DedupConfig) *AlertDeduper {
+	return &AlertDeduper{
+		config: config,
+		states: make(map[uint64]*eventAlertState),
+	}
+}
+
+// Observe records whether an event was failing on its last
+// execution. State changes are used for flap detection, and a
+// recovery resets the renotify timers of the event.
+func (s *AlertDeduper) Observe(eventID uint64, failing bool, now time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	state := s.state(eventID)
+	if state.failing == failing {
+		return
+	}
+
+	state.failing = failing
+	state.changes = append(state.changes, now)
+	state.prune(now, s.config.FlapWindow)
+
+	if !failing {
+		state.lastSent = make(map[string]time.Time)
+	}
+}
+
+// Allow returns true if the message should be sent, and records it as
+// sent if so.
+func (s *AlertDeduper) Allow(msg AlertMessage, now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	state := s.state(msg.EventID)
+	state.prune(now, s.config.FlapWindow)
+
+	if s.config.FlapThreshold > 0 && len(state.changes) >= s.config.FlapThreshold {
+		return false
+	}
+
+	if last, ok := state.lastSent[msg.Fingerprint]; ok &&
+		now.Sub(last) < s.config.RenotifyAfter {
+		return false
+	}
+
+	state.lastSent[msg.Fingerprint] = now
+	return true
+}
+
+// IsFlapping returns true if the event is currently considered to be
+// flapping.
+func (s *AlertDeduper) IsFlapping(eventID uint64, now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	state := s.state(eventID)
+	state.prune(now, s.config.FlapWindow)
+
+	return s.config.FlapThreshold > 0 && len(state.changes) >= s.config.FlapThreshold
+}
+
+func (s *AlertDeduper) state(eventID uint64) *eventAlertState {
+	state, ok := s.states[eventID]
+	if !ok {
+		state = &eventAlertState{lastSent: make(map[string]time.Time)}
+		s.states[eventID] = state
+	}
+	return state
+}
+
+func (s *eventAlertState) prune(now time.Time, window time.Duration) {
+	keep := 0
+	for _, change := range s.changes {
+		if now.Sub(change) < window {
+			s.changes[keep] = change
+			keep++
+		}
+	}
+	s.changes = s.changes[:keep]
+}
+
+// alertFingerprint hashes a hook result so that identical alerts can
+// be recognized.
+func alertFingerprint(result interface{}) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%v", result))) // #nosec
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,31 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import "fmt"
+
+var (
+	ErrAlertSinkRejected     = fmt.Errorf("alert sink rejected the alerts")
+	ErrMuteRuleEmpty         = fmt.Errorf("mute rule needs an event id, label, group or tags")
+	ErrMuteRuleNotFound      = fmt.Errorf("no such mute rule")
+	ErrUnknownSeverity       = fmt.Errorf("unknown severity")
+	ErrNoActiveAlert         = fmt.Errorf("event has no active alert")
+	ErrUnknownSyslogFacility = fmt.Errorf("unknown syslog facility")
+	ErrSNMPConfig            = fmt.Errorf("invalid snmp config")
+	ErrBadHolidayCalendar    = fmt.Errorf("bad holiday calendar")
+)
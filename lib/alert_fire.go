@@ -0,0 +1,96 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultTestAlertLabel   = "cynic test alert"
+	defaultTestAlertMessage = "this is a test, nothing is broken"
+
+	// TestAlertTag is the tag test alerts carry, set to "true", so
+	// that sinks and templates can tell them apart.
+	TestAlertTag = "cynic_test"
+
+	// testAlertTimeout is how long the admin endpoint waits for the
+	// alerter to take a test alert.
+	testAlertTimeout = 10 * time.Second
+)
+
+// TestAlert is a synthetic alert, to check that alerts get where they
+// should.
+type TestAlert struct {
+	// Label defaults to "cynic test alert".
+	Label    string   `json:"label"`
+	Group    string   `json:"group"`
+	Severity Severity `json:"severity"`
+
+	// Message is the response of the alert.
+	Message string `json:"message"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// TestFire sends a test alert through the alerter and its sinks, with
+// their routing, grouping and templates, right away. It skips the
+// mutes, deduplication and digest, so that it is always sent, is never
+// kept active, and is recorded in the history. It returns the alert
+// once the alerter took it, or an error if ctx is done first.
+func (s *Alerter) TestFire(ctx context.Context, alert TestAlert) (AlertMessage, error) {
+	msg := alert.message(s.clock.Now())
+
+	select {
+	case s.testCh <- msg:
+		return msg, nil
+	case <-ctx.Done():
+		return msg, ctx.Err()
+	}
+}
+
+func (s *TestAlert) message(now time.Time) AlertMessage {
+	label := s.Label
+	if label == "" {
+		label = defaultTestAlertLabel
+	}
+
+	message := s.Message
+	if message == "" {
+		message = defaultTestAlertMessage
+	}
+
+	tags := make(map[string]string, len(s.Tags)+1)
+	for key, value := range s.Tags {
+		tags[key] = value
+	}
+	tags[TestAlertTag] = "true"
+
+	return AlertMessage{
+		Response:      message,
+		Now:           now.Format(time.RFC3339),
+		CynicHostname: currentHost(),
+		Label:         label,
+		Group:         s.Group,
+		Severity:      s.Severity,
+		Fingerprint:   fmt.Sprintf("test:%d", now.UnixNano()),
+		Tags:          tags,
+	}
+}
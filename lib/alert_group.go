@@ -0,0 +1,204 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"net/url"
+	"strings"
+)
+
+const defaultGroupMinAlerts = 2
+
+// AlertGroupConfig configures how alerts of different events sharing a
+// root cause, like a host that died, are grouped into one.
+type AlertGroupConfig struct {
+	// MinAlerts is how many alerts delivered together must share a
+	// root cause to be grouped. It defaults to two.
+	MinAlerts int `json:"min_alerts"`
+
+	// RootCause, if set, tells the root cause of an alert, or "" for
+	// alerts that are never grouped. It defaults to the root cause
+	// the alert was raised with.
+	RootCause func(msg *AlertMessage) string `json:"-"`
+}
+
+// AlertGroup is the response of the message grouping alerts sharing a
+// root cause.
+type AlertGroup struct {
+	RootCause string         `json:"root_cause"`
+	Count     int            `json:"count"`
+	Labels    []string       `json:"labels"`
+	Alerts    []AlertMessage `json:"alerts"`
+}
+
+// The classes of errors of root causes, from the results of probes.
+const (
+	rootCauseRefused     = "refused"
+	rootCauseTimeout     = "timeout"
+	rootCauseDNS         = "dns"
+	rootCauseTLS         = "tls"
+	rootCauseReset       = "reset"
+	rootCauseUnreachable = "unreachable"
+	rootCauseError       = "error"
+	rootCauseServerError = "5xx"
+	rootCauseContract    = "contract"
+)
+
+// rootCauseErrors tells the class of an error by its text, first
+// match first.
+var rootCauseErrors = []struct {
+	class string
+	texts []string
+}{
+	{rootCauseRefused, []string{"connection refused"}},
+	{rootCauseTimeout, []string{"timeout", "deadline exceeded"}},
+	{rootCauseDNS, []string{"no such host", "server misbehaving"}},
+	{rootCauseTLS, []string{"x509:", "tls:"}},
+	{rootCauseReset, []string{"connection reset", "EOF", "broken pipe"}},
+	{rootCauseUnreachable, []string{"no route to host", "network is unreachable"}},
+}
+
+// WithGrouping has the alerter deliver the alerts sharing a root cause
+// as one message. Only alerts delivered together are grouped, so the
+// interval of the alerter is how long they are gathered for. It must
+// be set before the alerter starts.
+func (s *Alerter) WithGrouping(config *AlertGroupConfig) {
+	s.alerterFn = AlertGrouping(*config, s.alerterFn)
+}
+
+// AlertGrouping returns an alert hook giving the alerts to next, with
+// those sharing a root cause grouped into one message, in the place of
+// the first of them.
+func AlertGrouping(config AlertGroupConfig, next AlertFunc) AlertFunc {
+	if config.MinAlerts <= 0 {
+		config.MinAlerts = defaultGroupMinAlerts
+	}
+	rootCause := config.RootCause
+	if rootCause == nil {
+		rootCause = func(msg *AlertMessage) string { return msg.RootCause }
+	}
+
+	return func(alerts []AlertMessage) error {
+		causes := make([]string, len(alerts))
+		members := make(map[string][]AlertMessage)
+		for i := range alerts {
+			causes[i] = rootCause(&alerts[i])
+			if causes[i] != "" {
+				members[causes[i]] = append(members[causes[i]], alerts[i])
+			}
+		}
+
+		grouped := make([]AlertMessage, 0, len(alerts))
+		sent := make(map[string]bool)
+		for i := range alerts {
+			group := members[causes[i]]
+			switch {
+			case len(group) < config.MinAlerts:
+				grouped = append(grouped, alerts[i])
+			case !sent[causes[i]]:
+				// the group is sent once, in the place of its first
+				// alert
+				sent[causes[i]] = true
+				grouped = append(grouped, groupMessageNew(causes[i], group))
+			}
+		}
+
+		return next(grouped)
+	}
+}
+
+func groupMessageNew(rootCause string, alerts []AlertMessage) AlertMessage {
+	msg := digestMessageNew(alerts, alertTime(&alerts[0]))
+	msg.Label = rootCause
+	msg.Group = alerts[0].Group
+	msg.RootCause = rootCause
+	msg.Fingerprint = "group:" + rootCause
+
+	group := AlertGroup{RootCause: rootCause, Count: len(alerts), Alerts: alerts}
+	for i := range alerts {
+		group.Labels = append(group.Labels, alerts[i].Label)
+		if alerts[i].Group != msg.Group {
+			msg.Group = ""
+		}
+	}
+	msg.Response = group
+
+	return msg
+}
+
+// AlertRootCause tells the root cause of an alert from the result it
+// was raised with: the host of a failed probe, and the class of its
+// error, like "db.internal refused" or "api.example.com timeout". It
+// returns "" for results it can't tell a root cause of.
+func AlertRootCause(result interface{}) string {
+	var probe *ProbeResult
+	switch result := result.(type) {
+	case ProbeResult:
+		probe = &result
+	case *ProbeResult:
+		probe = result
+	}
+	if probe == nil {
+		return ""
+	}
+
+	host, err := probeHost(probe.URL)
+	if err != nil || host == "" {
+		return ""
+	}
+
+	var class string
+	switch {
+	case probe.Error != "":
+		class = errorClass(probe.Error)
+	case probe.Status >= 500:
+		class = rootCauseServerError
+	case len(probe.Failures) > 0:
+		class = rootCauseContract
+	default:
+		return ""
+	}
+
+	return host + " " + class
+}
+
+// probeHost returns the host of a probed url, without brackets for
+// IPv6 literals, or the path of its socket for urls over unix sockets.
+func probeHost(rawURL string) (string, error) {
+	if isUnixURL(rawURL) {
+		socket, _, err := unixTarget(rawURL)
+		return socket, err
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return target.Hostname(), nil
+}
+
+func errorClass(text string) string {
+	for _, candidate := range rootCauseErrors {
+		for _, match := range candidate.texts {
+			if strings.Contains(text, match) {
+				return candidate.class
+			}
+		}
+	}
+	return rootCauseError
+}
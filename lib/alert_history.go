@@ -0,0 +1,190 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultAlertHistoryCapacity is how many alert records are kept in
+// memory, unless configured otherwise.
+const DefaultAlertHistoryCapacity = 1000
+
+// AlertRecordKind says what happened in an alert record.
+type AlertRecordKind string
+
+const (
+	// AlertRecordAlert is recorded when an alert is sent.
+	AlertRecordAlert AlertRecordKind = "alert"
+
+	// AlertRecordResolved is recorded when an alerting event stops
+	// alerting.
+	AlertRecordResolved AlertRecordKind = "resolved"
+
+	// AlertRecordAcknowledged is recorded when someone acknowledges
+	// an active alert.
+	AlertRecordAcknowledged AlertRecordKind = "acknowledged"
+)
+
+// AlertRecord is an entry in the alert history.
+type AlertRecord struct {
+	Time     time.Time       `json:"time"`
+	Kind     AlertRecordKind `json:"kind"`
+	EventID  uint64          `json:"event_id"`
+	Label    string          `json:"label"`
+	Group    string          `json:"group"`
+	Severity Severity        `json:"severity"`
+	Message  *AlertMessage   `json:"message,omitempty"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// AlertHistoryConfig configures the alert history.
+type AlertHistoryConfig struct {
+	// Capacity is how many records are kept in memory.
+	Capacity int
+
+	// Path is an optional json lines file every record is appended
+	// to. It is read back when the history is created.
+	Path string
+}
+
+// AlertHistoryFilter selects alert records. Zero values match
+// everything.
+type AlertHistoryFilter struct {
+	Since    time.Time
+	Until    time.Time
+	EventID  uint64
+	Severity *Severity
+
+	// Tags are tags the records must all have.
+	Tags map[string]string
+}
+
+// AlertHistory is a log of the alerts that were sent, and of their
+// resolutions.
+type AlertHistory struct {
+	config  AlertHistoryConfig
+	mux     sync.Mutex
+	records []AlertRecord
+}
+
+// AlertHistoryNew creates an alert history, loading any records from
+// its file.
+func AlertHistoryNew(config AlertHistoryConfig) *AlertHistory {
+	if config.Capacity <= 0 {
+		config.Capacity = DefaultAlertHistoryCapacity
+	}
+
+	history := &AlertHistory{config: config}
+	if err := history.load(); err != nil {
+		log.Println("could not load alert history: ", err)
+	}
+
+	return history
+}
+
+// Record adds a record to the history.
+func (s *AlertHistory) Record(record AlertRecord) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.records = append(s.records, record)
+	if len(s.records) > s.config.Capacity {
+		s.records = s.records[len(s.records)-s.config.Capacity:]
+	}
+
+	if err := s.appendToFile(&record); err != nil {
+		log.Println("problem persisting alert record: ", err)
+	}
+}
+
+// Query returns the records matching the filter, oldest first.
+func (s *AlertHistory) Query(filter AlertHistoryFilter) []AlertRecord {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := make([]AlertRecord, 0)
+	for i := range s.records {
+		if filter.matches(&s.records[i]) {
+			ret = append(ret, s.records[i])
+		}
+	}
+
+	return ret
+}
+
+func (s *AlertHistoryFilter) matches(record *AlertRecord) bool {
+	return (s.Since.IsZero() || !record.Time.Before(s.Since)) &&
+		(s.Until.IsZero() || record.Time.Before(s.Until)) &&
+		(s.EventID == 0 || s.EventID == record.EventID) &&
+		(s.Severity == nil || *s.Severity == record.Severity) &&
+		tagsMatch(s.Tags, record.Tags)
+}
+
+func (s *AlertHistory) appendToFile(record *AlertRecord) error {
+	if s.config.Path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(s.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(record)
+}
+
+func (s *AlertHistory) load() error {
+	if s.config.Path == "" {
+		return nil
+	}
+
+	file, err := os.Open(s.config.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		var record AlertRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return err
+		}
+
+		s.records = append(s.records, record)
+		if len(s.records) > s.config.Capacity {
+			s.records = s.records[1:]
+		}
+	}
+
+	return scanner.Err()
+}
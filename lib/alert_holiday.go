@@ -0,0 +1,342 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	holidayDateLayout = "2006-01-02"
+	icalDateLayout    = "20060102"
+
+	// icalRouteProperty is the property of the events of an iCal
+	// calendar naming the alert hook of their holiday.
+	icalRouteProperty = "X-CYNIC-ROUTE"
+)
+
+// Holiday is a day, or days, non-critical alerts are suppressed on,
+// or sent to another alert hook, like the one paging whoever is on
+// call over the holiday.
+type Holiday struct {
+	Name string `json:"name"`
+
+	// Date is the first day of the holiday, like "2021-12-25", and
+	// Until its last day, which defaults to Date.
+	Date  string `json:"date"`
+	Until string `json:"until"`
+
+	// Route is the name of a registered alert hook the alerts go to
+	// during the holiday. Without one, they are suppressed.
+	Route string `json:"route"`
+}
+
+// HolidayCalendarFile is a holiday calendar, as read from json, yaml
+// or toml.
+type HolidayCalendarFile struct {
+	Holidays []Holiday `json:"holidays"`
+
+	// Timezone is the name of the zone the days are in, like
+	// "Europe/Paris". Defaults to the local time.
+	Timezone string `json:"timezone"`
+}
+
+// HolidayCalendar routes the alerts raised on its holidays. Critical
+// alerts are never held back.
+type HolidayCalendar struct {
+	holidays []holiday
+	location *time.Location
+}
+
+type holiday struct {
+	Holiday
+	first time.Time
+	last  time.Time
+	route AlertFunc
+}
+
+// HolidayCalendarNew creates a calendar of the holidays, in the given
+// location, or the local time if it is nil.
+func HolidayCalendarNew(holidays []Holiday, location *time.Location) (*HolidayCalendar, error) {
+	if location == nil {
+		location = time.Local
+	}
+
+	calendar := &HolidayCalendar{location: location}
+	for i := range holidays {
+		parsed, err := holidays[i].parse(location)
+		if err != nil {
+			return nil, err
+		}
+		calendar.holidays = append(calendar.holidays, parsed)
+	}
+
+	return calendar, nil
+}
+
+// LoadHolidayCalendar reads a calendar file. Files ending in .ics are
+// read as iCal, with an event per holiday, its summary as name, and
+// the X-CYNIC-ROUTE property as route. Others are read as a
+// HolidayCalendarFile, with the config decoder of their extension.
+func LoadHolidayCalendar(path string) (*HolidayCalendar, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".ics" {
+		holidays, err := parseICalHolidays(data)
+		if err != nil {
+			return nil, err
+		}
+		return HolidayCalendarNew(holidays, nil)
+	}
+
+	registryMutex.RLock()
+	decoder, ok := configDecoders[ext]
+	registryMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrConfigUnknownFormat, ext)
+	}
+
+	var generic interface{}
+	if err := decoder(data, &generic); err != nil {
+		return nil, err
+	}
+
+	normalized, err := json.Marshal(normalizeConfigValue(generic))
+	if err != nil {
+		return nil, err
+	}
+
+	var file HolidayCalendarFile
+	if err := json.Unmarshal(normalized, &file); err != nil {
+		return nil, err
+	}
+
+	location := time.Local
+	if file.Timezone != "" {
+		if location, err = time.LoadLocation(file.Timezone); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadHolidayCalendar, err)
+		}
+	}
+
+	return HolidayCalendarNew(file.Holidays, location)
+}
+
+// On returns the holiday the given time falls on, if any.
+func (s *HolidayCalendar) On(t time.Time) (Holiday, bool) {
+	day, ok := s.on(t)
+	if !ok {
+		return Holiday{}, false
+	}
+	return day.Holiday, true
+}
+
+func (s *HolidayCalendar) on(t time.Time) (*holiday, bool) {
+	t = t.In(s.location)
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.location)
+
+	for i := range s.holidays {
+		day := &s.holidays[i]
+		if !date.Before(day.first) && !date.After(day.last) {
+			return day, true
+		}
+	}
+	return nil, false
+}
+
+// Route returns an alert hook giving the alerts to next, except for
+// the non-critical alerts raised on a holiday, which go to its route,
+// or nowhere. It returns the first error of the hooks.
+func (s *HolidayCalendar) Route(next AlertFunc) AlertFunc {
+	return func(alerts []AlertMessage) error {
+		var passed []AlertMessage
+		var routes []*holiday
+		routed := make(map[*holiday][]AlertMessage)
+
+		for i := range alerts {
+			alert := alerts[i]
+			day, ok := s.on(alertTime(&alert))
+			switch {
+			case !ok || alert.Severity >= SeverityCritical:
+				passed = append(passed, alert)
+			case day.route != nil:
+				if _, seen := routed[day]; !seen {
+					routes = append(routes, day)
+				}
+				routed[day] = append(routed[day], alert)
+			}
+		}
+
+		var first error
+		if len(passed) > 0 {
+			first = next(passed)
+		}
+		for _, day := range routes {
+			if err := day.route(routed[day]); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+}
+
+// alertTime is when the alert was raised, or now if that can't be
+// told.
+func alertTime(alert *AlertMessage) time.Time {
+	if t, err := time.Parse(time.RFC3339, alert.Now); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+func (s *Holiday) parse(location *time.Location) (holiday, error) {
+	parsed := holiday{Holiday: *s}
+
+	var err error
+	if parsed.first, err = time.ParseInLocation(holidayDateLayout, s.Date, location); err != nil {
+		return parsed, fmt.Errorf("%w: %s: bad date %q", ErrBadHolidayCalendar, s.Name, s.Date)
+	}
+
+	parsed.last = parsed.first
+	if s.Until != "" {
+		if parsed.last, err = time.ParseInLocation(holidayDateLayout, s.Until, location); err != nil {
+			return parsed, fmt.Errorf("%w: %s: bad date %q", ErrBadHolidayCalendar, s.Name, s.Until)
+		}
+	}
+	if parsed.last.Before(parsed.first) {
+		return parsed, fmt.Errorf("%w: %s: ends before it starts", ErrBadHolidayCalendar, s.Name)
+	}
+
+	if s.Route != "" {
+		registryMutex.RLock()
+		route, ok := namedAlertHooks[s.Route]
+		registryMutex.RUnlock()
+
+		if !ok {
+			return parsed, fmt.Errorf("%w: %s", ErrConfigUnknownHook, s.Route)
+		}
+		parsed.route = route
+	}
+
+	return parsed, nil
+}
+
+// parseICalHolidays reads the events of an iCal calendar as holidays.
+// Only their days are kept: the end of an event is exclusive, like
+// that of the all day events calendars export.
+func parseICalHolidays(data []byte) ([]Holiday, error) {
+	var holidays []Holiday
+	var current *Holiday
+	var end string
+
+	for _, line := range unfoldICal(data) {
+		name, value := splitICalLine(line)
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			current = &Holiday{}
+			end = ""
+		case current == nil:
+			continue
+		case name == "END" && value == "VEVENT":
+			if current.Date == "" {
+				return nil, fmt.Errorf("%w: event %q has no start", ErrBadHolidayCalendar, current.Name)
+			}
+			if end != "" && end != current.Date {
+				until, err := time.Parse(holidayDateLayout, end)
+				if err != nil {
+					return nil, fmt.Errorf("%w: bad end %q", ErrBadHolidayCalendar, end)
+				}
+				current.Until = until.AddDate(0, 0, -1).Format(holidayDateLayout)
+			}
+			holidays = append(holidays, *current)
+			current = nil
+		case name == "SUMMARY":
+			current.Name = value
+		case name == icalRouteProperty:
+			current.Route = value
+		case name == "DTSTART" || name == "DTEND":
+			date, err := icalDate(value)
+			if err != nil {
+				return nil, err
+			}
+			if name == "DTSTART" {
+				current.Date = date
+			} else {
+				end = date
+			}
+		}
+	}
+
+	return holidays, nil
+}
+
+// unfoldICal splits an iCal calendar in lines, joining the lines that
+// were folded.
+func unfoldICal(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitICalLine returns the name of the property of the line, without
+// its parameters, and its value.
+func splitICalLine(line string) (string, string) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", ""
+	}
+
+	name := line[:i]
+	if j := strings.Index(name, ";"); j >= 0 {
+		name = name[:j]
+	}
+	return strings.ToUpper(name), line[i+1:]
+}
+
+// icalDate returns the day of an iCal date, or date and time.
+func icalDate(value string) (string, error) {
+	if len(value) < len(icalDateLayout) {
+		return "", fmt.Errorf("%w: bad date %q", ErrBadHolidayCalendar, value)
+	}
+
+	date, err := time.Parse(icalDateLayout, value[:len(icalDateLayout)])
+	if err != nil {
+		return "", fmt.Errorf("%w: bad date %q", ErrBadHolidayCalendar, value)
+	}
+	return date.Format(holidayDateLayout), nil
+}
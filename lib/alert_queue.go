@@ -0,0 +1,193 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+// DefaultAlertQueueCapacity is how many alerts are kept pending
+// delivery, unless configured otherwise.
+const DefaultAlertQueueCapacity = 1024
+
+// OverflowPolicy decides which alerts are dropped when the alert
+// queue is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest pending alert to make room.
+	DropOldest OverflowPolicy = iota
+
+	// DropNewest discards the incoming alert.
+	DropNewest
+)
+
+// AlertQueueConfig configures how pending alerts are buffered.
+type AlertQueueConfig struct {
+	Capacity int
+	Overflow OverflowPolicy
+
+	// SpillPath is an optional file where pending alerts are
+	// kept, so that they survive restarts and sink outages.
+	SpillPath string
+}
+
+type queuedAlert struct {
+	Seq     uint64       `json:"seq"`
+	Message AlertMessage `json:"message"`
+}
+
+// alertQueue is a bounded fifo of alerts waiting to be delivered.
+type alertQueue struct {
+	config  AlertQueueConfig
+	mux     sync.Mutex
+	items   []queuedAlert
+	lastSeq uint64
+	dropped uint64
+}
+
+func alertQueueNew(config AlertQueueConfig) *alertQueue {
+	if config.Capacity <= 0 {
+		config.Capacity = DefaultAlertQueueCapacity
+	}
+
+	queue := &alertQueue{config: config}
+	if err := queue.load(); err != nil {
+		log.Println("could not load spilled alerts: ", err)
+	}
+
+	return queue
+}
+
+// push adds an alert, applying the overflow policy if the queue is
+// full.
+func (s *alertQueue) push(msg AlertMessage) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if len(s.items) >= s.config.Capacity {
+		s.dropped++
+		if s.config.Overflow == DropNewest {
+			return
+		}
+		s.items = s.items[1:]
+	}
+
+	s.lastSeq++
+	s.items = append(s.items, queuedAlert{Seq: s.lastSeq, Message: msg})
+	s.persist()
+}
+
+// peek returns the pending alerts, and the sequence number of the
+// last one, to be given to ack once they are delivered.
+func (s *alertQueue) peek() ([]AlertMessage, uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	messages := make([]AlertMessage, len(s.items))
+	for i, item := range s.items {
+		messages[i] = item.Message
+	}
+
+	return messages, s.lastSeq
+}
+
+// ack removes all alerts up to and including the given sequence
+// number.
+func (s *alertQueue) ack(seq uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	keep := 0
+	for keep < len(s.items) && s.items[keep].Seq <= seq {
+		keep++
+	}
+	s.items = s.items[keep:]
+	s.persist()
+}
+
+func (s *alertQueue) len() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.items)
+}
+
+func (s *alertQueue) droppedCount() uint64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.dropped
+}
+
+// persist writes the queue to the spill file. Must be called with
+// the lock held.
+func (s *alertQueue) persist() {
+	if s.config.SpillPath == "" {
+		return
+	}
+
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		log.Println("problem encoding alert queue: ", err)
+		return
+	}
+
+	tmpPath := s.config.SpillPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		log.Println("problem spilling alert queue: ", err)
+		return
+	}
+
+	if err := os.Rename(tmpPath, s.config.SpillPath); err != nil {
+		log.Println("problem spilling alert queue: ", err)
+	}
+}
+
+func (s *alertQueue) load() error {
+	if s.config.SpillPath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.config.SpillPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var items []queuedAlert
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+
+	if len(items) > s.config.Capacity {
+		items = items[len(items)-s.config.Capacity:]
+	}
+
+	s.items = items
+	if len(items) > 0 {
+		s.lastSeq = items[len(items)-1].Seq
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+)
+
+// AlertTemplate renders alert messages with a go text/template. The
+// template is executed with the AlertMessage as its data, so fields
+// like {{.Label}}, {{.CynicHostname}} and {{.Response}} are
+// available. Hook results that are structs can be walked into, eg
+// {{.Response.Message}}.
+//
+// Besides the builtin functions, templates can use:
+//   - json: encodes a value as json
+//   - truncate: cuts a string down to n characters
+type AlertTemplate struct {
+	tmpl *template.Template
+}
+
+var alertTemplateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		buff, err := json.Marshal(value)
+		return string(buff), err
+	},
+	"truncate": func(n int, str string) string {
+		return truncateText(str, n)
+	},
+}
+
+// truncateText cuts str down to n characters, rather than bytes, so
+// that none is cut in half, and marks it with "...".
+func truncateText(str string, n int) string {
+	count := 0
+	for i := range str {
+		if count == n {
+			return str[:i] + "..."
+		}
+		count++
+	}
+	return str
+}
+
+// AlertTemplateNew parses a template for alert messages.
+func AlertTemplateNew(name, text string) (*AlertTemplate, error) {
+	tmpl, err := template.New(name).Funcs(alertTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AlertTemplate{tmpl: tmpl}, nil
+}
+
+// Render executes the template against the given message.
+func (s *AlertTemplate) Render(msg *AlertMessage) (string, error) {
+	var builder strings.Builder
+	if err := s.tmpl.Execute(&builder, msg); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// alertText is the text of an alert: its rendered template if there
+// is one, or the hook result as is.
+func alertText(template *AlertTemplate, msg *AlertMessage) string {
+	if template != nil {
+		text, err := template.Render(msg)
+		if err == nil {
+			return text
+		}
+		log.Println("problem rendering alert template: ", err)
+	}
+
+	return fmt.Sprintf("%v", msg.Response)
+}
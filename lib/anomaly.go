@@ -0,0 +1,174 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// AnomalyLatency is the metric of the latency of a probe.
+	AnomalyLatency = "latency"
+
+	defaultAnomalySigma  = 3
+	defaultAnomalyAlpha  = 0.1
+	defaultAnomalyWarmup = 20
+)
+
+// AnomalyConfig configures an anomaly detector.
+type AnomalyConfig struct {
+	// Metric is AnomalyLatency, the default, or the path of a
+	// threshold of the event, whose value is watched.
+	Metric string `json:"metric"`
+
+	// Sigma is how many standard deviations away from the mean a
+	// value is anomalous. It defaults to 3.
+	Sigma float64 `json:"sigma"`
+
+	// Alpha is how much each value weighs in the moving mean and
+	// variance, between 0 and 1. It defaults to 0.1.
+	Alpha float64 `json:"alpha"`
+
+	// Warmup is how many values are learned before any is flagged.
+	// It defaults to 20.
+	Warmup int `json:"warmup"`
+
+	// MinDeviation is how far from the mean a value must at least
+	// be to be anomalous, so that very steady metrics don't flag
+	// every small change.
+	MinDeviation float64 `json:"min_deviation"`
+}
+
+// AnomalyResult is what an anomaly hook returns.
+type AnomalyResult struct {
+	Metric string  `json:"metric"`
+	Value  float64 `json:"value"`
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+
+	// Score is how many standard deviations the value is from the
+	// mean, or 0 while there is no deviation yet.
+	Score     float64 `json:"score"`
+	Anomalous bool    `json:"anomalous,omitempty"`
+
+	// Learning is set while the detector warms up.
+	Learning bool `json:"learning,omitempty"`
+}
+
+// AnomalyDetector learns the normal values of a metric, as an
+// exponentially weighted moving mean and variance, and flags values
+// that stray too far from them.
+type AnomalyDetector struct {
+	config AnomalyConfig
+
+	mux      sync.Mutex
+	count    int
+	mean     float64
+	variance float64
+}
+
+// AnomalyDetectorNew returns a detector, with the defaults of the
+// config filled in.
+func AnomalyDetectorNew(config AnomalyConfig) *AnomalyDetector {
+	if config.Metric == "" {
+		config.Metric = AnomalyLatency
+	}
+	if config.Sigma <= 0 {
+		config.Sigma = defaultAnomalySigma
+	}
+	if config.Alpha <= 0 || config.Alpha >= 1 {
+		config.Alpha = defaultAnomalyAlpha
+	}
+	if config.Warmup <= 0 {
+		config.Warmup = defaultAnomalyWarmup
+	}
+
+	return &AnomalyDetector{config: config}
+}
+
+// Observe tells whether a value is anomalous against what was learned
+// so far, and then learns it. Anomalous values are learned too, so
+// that a lasting change becomes the new normal.
+func (s *AnomalyDetector) Observe(value float64) AnomalyResult {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	stddev := math.Sqrt(s.variance)
+	result := AnomalyResult{
+		Metric:   s.config.Metric,
+		Value:    value,
+		Mean:     s.mean,
+		Stddev:   stddev,
+		Learning: s.count < s.config.Warmup,
+	}
+
+	diff := value - s.mean
+	if stddev > 0 {
+		result.Score = math.Abs(diff) / stddev
+	}
+	result.Anomalous = !result.Learning &&
+		math.Abs(diff) > s.config.Sigma*stddev &&
+		math.Abs(diff) > s.config.MinDeviation
+
+	// while warming up, the first values weigh more, so that the mean
+	// does not start from zero
+	s.count++
+	alpha := math.Max(s.config.Alpha, 1/float64(s.count))
+
+	increment := alpha * diff
+	s.mean += increment
+	s.variance = (1 - alpha) * (s.variance + diff*increment)
+
+	return result
+}
+
+// AnomalyHookNew returns a hook that fails when the metric of the probe
+// result stored under key in the status cache is anomalous. It is
+// added after the probe of an event, whose label or url is the key.
+// Probes that got no response are not learned.
+func AnomalyHookNew(key string, config AnomalyConfig) HookSignature {
+	detector := AnomalyDetectorNew(config)
+	metric := detector.config.Metric
+
+	return func(params *HookParameters) (bool, interface{}) {
+		if params.Status == nil {
+			return false, nil
+		}
+
+		value, err := params.Status.Get(key)
+		if err != nil {
+			return false, nil
+		}
+
+		probe, ok := value.(ProbeResult)
+		if !ok || probe.Error != "" || probe.Throttled || probe.CircuitOpen {
+			return false, nil
+		}
+
+		observed := float64(probe.LatencyMs)
+		if metric != AnomalyLatency {
+			if observed, ok = probe.Values[metric]; !ok {
+				return false, nil
+			}
+		}
+
+		result := detector.Observe(observed)
+		return result.Anomalous, result
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBusResultsTopic = "cynic.results"
+	defaultBusAlertsTopic  = "cynic.alerts"
+	defaultBusTimeout      = 10 * time.Second
+
+	// busQueueSize is how many messages can wait to be published
+	// before new ones are dropped.
+	busQueueSize = 4096
+)
+
+// BusTransport publishes messages on the topics of an event bus, like
+// NATS or Kafka.
+type BusTransport interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+}
+
+// busTransportCloser is a transport that is closed along with its
+// publisher.
+type busTransportCloser interface {
+	Close()
+}
+
+// BusTransportFunc is a function publishing messages, as a
+// BusTransport. It lets any bus client be used, eg. a kafka writer:
+//
+//	cynic.BusTransportFunc(func(ctx context.Context, topic string, payload []byte) error {
+//		return writer.WriteMessages(ctx, kafka.Message{Topic: topic, Value: payload})
+//	})
+type BusTransportFunc func(ctx context.Context, topic string, payload []byte) error
+
+// Publish calls the function.
+func (s BusTransportFunc) Publish(ctx context.Context, topic string, payload []byte) error {
+	return s(ctx, topic, payload)
+}
+
+// BusTransportFactory creates a transport connecting to addr.
+type BusTransportFactory func(addr string) (BusTransport, error)
+
+var (
+	busTransportsMutex sync.RWMutex
+	busTransports      = map[string]BusTransportFactory{
+		"nats": func(addr string) (BusTransport, error) {
+			return NATSTransportNew(addr), nil
+		},
+	}
+)
+
+// RegisterBusTransport names a transport, so that config files can
+// refer to it. NATS is supported out of the box; there is no kafka
+// client in the standard library, so one must be registered as
+// "kafka" before using it.
+func RegisterBusTransport(name string, factory BusTransportFactory) {
+	busTransportsMutex.Lock()
+	defer busTransportsMutex.Unlock()
+
+	busTransports[strings.ToLower(name)] = factory
+}
+
+func busTransportFor(name, addr string) (BusTransport, error) {
+	busTransportsMutex.RLock()
+	factory, ok := busTransports[strings.ToLower(name)]
+	busTransportsMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBusUnknownTransport, name)
+	}
+	return factory(addr)
+}
+
+// BusFormat is how messages are serialized on the bus.
+type BusFormat int
+
+const (
+	// BusFormatJSON serializes messages as json. It is the default.
+	BusFormatJSON BusFormat = iota
+
+	// BusFormatProtobuf serializes messages as protobuf, with the
+	// schema in proto/bus.proto.
+	BusFormatProtobuf
+)
+
+// ParseBusFormat returns the format with the given name: "json" or
+// "protobuf".
+func ParseBusFormat(name string) (BusFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "json":
+		return BusFormatJSON, nil
+	case "protobuf", "proto":
+		return BusFormatProtobuf, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrBusUnknownFormat, name)
+	}
+}
+
+// BusConfig configures a bus publisher.
+type BusConfig struct {
+	Format BusFormat
+
+	// ResultsTopic and AlertsTopic are the topics results and
+	// alerts are published on. They default to "cynic.results" and
+	// "cynic.alerts".
+	ResultsTopic string
+	AlertsTopic  string
+
+	// Timeout bounds each publish. It defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// BusPublisher publishes the results of events, and alerts, on an
+// event bus, one message each. It is a ResultSink, and its Alert
+// method can be given to AlerterNew, or to AlertFanout along with
+// other alert hooks. Messages are published in the background; a
+// message that can't be published is logged and dropped.
+type BusPublisher struct {
+	// dropped is first, to keep it aligned for atomic access on 32
+	// bit platforms.
+	dropped uint64
+
+	transport BusTransport
+	config    BusConfig
+
+	messages chan busMessage
+	done     chan struct{}
+
+	// mux guards closed, so that messages published after Close
+	// are dropped instead of sent on the closed channel.
+	mux    sync.RWMutex
+	closed bool
+}
+
+type busMessage struct {
+	topic   string
+	payload []byte
+}
+
+// BusPublisherNew creates a publisher on the given transport.
+func BusPublisherNew(transport BusTransport, config BusConfig) *BusPublisher {
+	if config.ResultsTopic == "" {
+		config.ResultsTopic = defaultBusResultsTopic
+	}
+	if config.AlertsTopic == "" {
+		config.AlertsTopic = defaultBusAlertsTopic
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultBusTimeout
+	}
+
+	publisher := &BusPublisher{
+		transport: transport,
+		config:    config,
+		messages:  make(chan busMessage, busQueueSize),
+		done:      make(chan struct{}),
+	}
+	go publisher.run()
+
+	return publisher
+}
+
+// Record publishes the result of a run of an event.
+func (s *BusPublisher) Record(result EventResult) {
+	var payload []byte
+	var err error
+
+	if s.config.Format == BusFormatProtobuf {
+		payload = protoEventResult(result)
+	} else {
+		payload, err = json.Marshal(result)
+	}
+
+	if err != nil {
+		log.Println("could not encode result for the bus: ", err)
+		return
+	}
+
+	s.enqueue(busMessage{topic: s.config.ResultsTopic, payload: payload})
+}
+
+// Alert publishes the alert messages, one message each. The messages
+// are queued for publishing, so it only fails to encode them, which
+// is logged.
+func (s *BusPublisher) Alert(alerts []AlertMessage) error {
+	for i := range alerts {
+		var payload []byte
+		var err error
+
+		if s.config.Format == BusFormatProtobuf {
+			payload, err = protoAlertMessage(&alerts[i])
+		} else {
+			payload, err = json.Marshal(&alerts[i])
+		}
+
+		if err != nil {
+			log.Println("could not encode alert for the bus: ", err)
+			continue
+		}
+
+		s.enqueue(busMessage{topic: s.config.AlertsTopic, payload: payload})
+	}
+
+	return nil
+}
+
+// Dropped returns how many messages were dropped because the queue
+// was full.
+func (s *BusPublisher) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close publishes the queued messages, and stops the publisher,
+// closing its transport if it has a Close method. It gives up on the
+// messages once ctx is done.
+func (s *BusPublisher) Close(ctx context.Context) {
+	s.mux.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.messages)
+	}
+	s.mux.Unlock()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
+
+func (s *BusPublisher) enqueue(message busMessage) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	if s.closed {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+
+	select {
+	case s.messages <- message:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *BusPublisher) run() {
+	defer close(s.done)
+
+	for message := range s.messages {
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+		if err := s.transport.Publish(ctx, message.topic, message.payload); err != nil {
+			log.Println("could not publish on ", message.topic, ": ", err)
+		}
+		cancel()
+	}
+
+	if closer, ok := s.transport.(busTransportCloser); ok {
+		closer.Close()
+	}
+}
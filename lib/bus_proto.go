@@ -0,0 +1,146 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"encoding/binary"
+	"encoding/json"
+)
+
+// Results and alerts are encoded in protobuf by hand, as there is no
+// protobuf runtime in the standard library, following the schema in
+// proto/bus.proto.
+
+const (
+	protoVarint = 0
+	protoBytes  = 2
+)
+
+func protoEventResult(result EventResult) []byte {
+	var buf []byte
+	buf = protoAppendUint(buf, 1, result.EventID)
+	buf = protoAppendString(buf, 2, result.Label)
+	buf = protoAppendString(buf, 3, result.Group)
+	buf = protoAppendString(buf, 4, result.Severity.String())
+	buf = protoAppendBool(buf, 5, result.Failed)
+	buf = protoAppendUint(buf, 6, uint64(result.Latency))
+	buf = protoAppendUint(buf, 7, uint64(result.At.UnixNano()))
+
+	for _, hook := range result.Hooks {
+		buf = protoAppendMessage(buf, 8, protoHookResult(hook))
+	}
+
+	buf = protoAppendString(buf, 9, result.Location)
+	buf = protoAppendTags(buf, 10, result.Tags)
+	return buf
+}
+
+func protoHookResult(hook HookResult) []byte {
+	var buf []byte
+	buf = protoAppendBool(buf, 1, hook.Failed)
+	if hook.Result != nil {
+		// results that can't be encoded are left out
+		if raw, err := json.Marshal(hook.Result); err == nil {
+			buf = protoAppendString(buf, 2, string(raw))
+		}
+	}
+	return buf
+}
+
+func protoAlertMessage(alert *AlertMessage) ([]byte, error) {
+	var buf []byte
+	buf = protoAppendUint(buf, 1, alert.EventID)
+	buf = protoAppendString(buf, 2, alert.Label)
+	buf = protoAppendString(buf, 3, alert.Group)
+	buf = protoAppendString(buf, 4, alert.Severity.String())
+	buf = protoAppendString(buf, 5, alert.Now)
+	buf = protoAppendString(buf, 6, alert.CynicHostname)
+	buf = protoAppendString(buf, 7, alert.Fingerprint)
+
+	if alert.Response != nil {
+		raw, err := json.Marshal(alert.Response)
+		if err != nil {
+			return nil, err
+		}
+		buf = protoAppendString(buf, 8, string(raw))
+	}
+
+	buf = protoAppendString(buf, 9, alert.Location)
+	buf = protoAppendTags(buf, 10, alert.Tags)
+	buf = protoAppendString(buf, 11, alert.RootCause)
+	buf = protoAppendString(buf, 12, alert.Endpoint)
+
+	return buf, nil
+}
+
+// protoAppendKey appends the key of a field. The other appenders
+// leave out zero values, as proto3 does.
+func protoAppendKey(buf []byte, field int, wireType int) []byte {
+	return protoAppendVarint(buf, uint64(field)<<3|uint64(wireType))
+}
+
+func protoAppendVarint(buf []byte, value uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], value)
+	return append(buf, tmp[:n]...)
+}
+
+func protoAppendUint(buf []byte, field int, value uint64) []byte {
+	if value == 0 {
+		return buf
+	}
+	buf = protoAppendKey(buf, field, protoVarint)
+	return protoAppendVarint(buf, value)
+}
+
+func protoAppendBool(buf []byte, field int, value bool) []byte {
+	if !value {
+		return buf
+	}
+	return protoAppendUint(buf, field, 1)
+}
+
+// protoAppendTags appends tags as a map field, which is a repeated
+// message of a key and a value, sorted by key.
+func protoAppendTags(buf []byte, field int, tags map[string]string) []byte {
+	for _, key := range tagKeys(tags) {
+		var entry []byte
+		entry = protoAppendString(entry, 1, key)
+		entry = protoAppendString(entry, 2, tags[key])
+
+		buf = protoAppendMessage(buf, field, entry)
+	}
+	return buf
+}
+
+// protoAppendMessage appends a message field, even when it is empty,
+// as the elements of repeated fields must all be there.
+func protoAppendMessage(buf []byte, field int, msg []byte) []byte {
+	buf = protoAppendKey(buf, field, protoBytes)
+	buf = protoAppendVarint(buf, uint64(len(msg)))
+	return append(buf, msg...)
+}
+
+func protoAppendString(buf []byte, field int, value string) []byte {
+	if value == "" {
+		return buf
+	}
+	buf = protoAppendKey(buf, field, protoBytes)
+	buf = protoAppendVarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
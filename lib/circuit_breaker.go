@@ -0,0 +1,139 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every probe through.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen skips probes, until the cool down is over.
+	CircuitOpen
+
+	// CircuitHalfOpen lets one probe through after the cool down,
+	// which closes the circuit if it succeeds, and opens it again
+	// otherwise.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops probing a target in sustained failure: after a
+// number of failures in a row, probes are skipped for a cool down,
+// and then a single probe checks whether the target recovered.
+type CircuitBreaker struct {
+	failures int
+	cooldown time.Duration
+	now      func() time.Time
+
+	mux      sync.Mutex
+	state    CircuitState
+	failed   int
+	openedAt time.Time
+}
+
+// CircuitBreakerNew creates a closed circuit breaker, which opens
+// after failures failures in a row, for cooldown.
+func CircuitBreakerNew(failures int, cooldown time.Duration) *CircuitBreaker {
+	if failures <= 0 {
+		failures = 1
+	}
+
+	return &CircuitBreaker{
+		failures: failures,
+		cooldown: cooldown,
+		now:      time.Now,
+	}
+}
+
+// Allow returns whether a probe should be made. Once the cool down of
+// an open circuit is over, it lets one probe through, and no other
+// until that probe is recorded.
+func (s *CircuitBreaker) Allow() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	switch s.state {
+	case CircuitOpen:
+		if s.now().Sub(s.openedAt) < s.cooldown {
+			return false
+		}
+		s.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// Record records the outcome of an allowed probe, and returns the
+// state of the circuit after it.
+func (s *CircuitBreaker) Record(failed bool) CircuitState {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !failed {
+		s.state = CircuitClosed
+		s.failed = 0
+		return s.state
+	}
+
+	s.failed++
+	if s.state == CircuitHalfOpen || s.failed >= s.failures {
+		s.state = CircuitOpen
+		s.openedAt = s.now()
+	}
+
+	return s.state
+}
+
+// abort gives up on the probe a half open circuit let through, which
+// was not made after all, so that the next one is let through instead.
+func (s *CircuitBreaker) abort() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.state == CircuitHalfOpen {
+		s.state = CircuitOpen
+	}
+}
+
+// State returns the state of the circuit.
+func (s *CircuitBreaker) State() CircuitState {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.state
+}
@@ -0,0 +1,183 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time, and makes tickers. The planner loop, the
+// alerter and the snapshotter take a clock, so that tests and
+// simulations can move time forward on their own.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers the time on its channel, every period.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the clock of the system. It is the default.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func (s systemTicker) C() <-chan time.Time {
+	return s.ticker.C
+}
+
+func (s systemTicker) Stop() {
+	s.ticker.Stop()
+}
+
+// clockOr returns the clock, or the system clock if it is nil.
+func clockOr(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock
+	}
+	return clock
+}
+
+// ManualClock is a clock that only moves when told to.
+type ManualClock struct {
+	mux     sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+type manualTicker struct {
+	ch      chan time.Time
+	stopped chan struct{}
+	period  time.Duration
+	next    time.Time
+	once    sync.Once
+}
+
+// ManualClockNew creates a manual clock, starting at the given time.
+func ManualClockNew(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns the time of the clock.
+func (s *ManualClock) Now() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.now
+}
+
+// NewTicker creates a ticker that fires as the clock is advanced.
+func (s *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for ManualClock.NewTicker")
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ticker := &manualTicker{
+		ch:      make(chan time.Time),
+		stopped: make(chan struct{}),
+		period:  d,
+		next:    s.now.Add(d),
+	}
+	s.tickers = append(s.tickers, ticker)
+
+	return ticker
+}
+
+// Advance moves the clock forward, firing the tickers that are due on
+// the way, in order. It returns once every tick was received, so
+// tickers that are not read from any more must be stopped.
+func (s *ManualClock) Advance(d time.Duration) {
+	s.mux.Lock()
+	target := s.now.Add(d)
+	s.mux.Unlock()
+
+	for {
+		s.mux.Lock()
+		ticker := s.nextTicker(target)
+		if ticker == nil {
+			s.now = target
+			s.mux.Unlock()
+			return
+		}
+
+		s.now = ticker.next
+		ticker.next = ticker.next.Add(ticker.period)
+		now := s.now
+		s.mux.Unlock()
+
+		select {
+		case ticker.ch <- now:
+		case <-ticker.stopped:
+		}
+	}
+}
+
+// nextTicker returns the running ticker that is due first, by the
+// target time, or nil.
+func (s *ManualClock) nextTicker(target time.Time) *manualTicker {
+	var next *manualTicker
+	running := s.tickers[:0]
+
+	for _, ticker := range s.tickers {
+		select {
+		case <-ticker.stopped:
+			continue
+		default:
+		}
+		running = append(running, ticker)
+
+		if ticker.next.After(target) {
+			continue
+		}
+		if next == nil || ticker.next.Before(next.next) {
+			next = ticker
+		}
+	}
+	s.tickers = running
+
+	return next
+}
+
+func (s *manualTicker) C() <-chan time.Time {
+	return s.ch
+}
+
+func (s *manualTicker) Stop() {
+	s.once.Do(func() {
+		close(s.stopped)
+	})
+}
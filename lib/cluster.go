@@ -0,0 +1,264 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClusterRefresh = 10 * time.Second
+
+	// defaultShardReplicas is how many points each member has on the
+	// ring, which evens out the share of events of each.
+	defaultShardReplicas = 128
+)
+
+// Membership lists the instances of a cluster, like a directory of
+// heartbeats, or the members of a service in etcd or consul.
+//
+// Memberships that also have a Leave(context.Context) error method are
+// left when their session shuts down.
+type Membership interface {
+	Members(ctx context.Context) ([]string, error)
+}
+
+type membershipLeaver interface {
+	Leave(ctx context.Context) error
+}
+
+// StaticMembership is a fixed list of members.
+type StaticMembership []string
+
+// Members returns the list.
+func (s StaticMembership) Members(_ context.Context) ([]string, error) {
+	return s, nil
+}
+
+// ClusterConfig makes instances sharing a config share its events:
+// each event is run by one instance only, picked by consistent
+// hashing of its group and label, so that a change of members only
+// moves the events of the members that came or went.
+type ClusterConfig struct {
+	Membership Membership
+
+	// Self names this instance among the members. It defaults to
+	// the hostname and the process id.
+	Self string
+
+	// Refresh is how often the members are listed. It defaults to
+	// 10 seconds.
+	Refresh time.Duration
+
+	// Replicas is how many points each member has on the ring. It
+	// defaults to 128.
+	Replicas int
+
+	// OnRebalance, if set, is called with the members every time
+	// they change.
+	OnRebalance func(members []string)
+}
+
+// ShardRing is a consistent hashing ring of members, which events are
+// shared out on.
+type ShardRing struct {
+	points  []uint64
+	members []string
+}
+
+// ShardRingNew creates a ring of the given members, each with
+// replicas points on it.
+func ShardRingNew(members []string, replicas int) *ShardRing {
+	if replicas <= 0 {
+		replicas = defaultShardReplicas
+	}
+
+	ring := &ShardRing{}
+	for _, member := range members {
+		for i := 0; i < replicas; i++ {
+			ring.points = append(ring.points, shardHash(member+"#"+strconv.Itoa(i)))
+			ring.members = append(ring.members, member)
+		}
+	}
+
+	sort.Sort(ring)
+	return ring
+}
+
+func (s *ShardRing) Len() int           { return len(s.points) }
+func (s *ShardRing) Less(i, j int) bool { return s.points[i] < s.points[j] }
+func (s *ShardRing) Swap(i, j int) {
+	s.points[i], s.points[j] = s.points[j], s.points[i]
+	s.members[i], s.members[j] = s.members[j], s.members[i]
+}
+
+// Owner returns the member that runs the event, or "" if the ring is
+// empty.
+func (s *ShardRing) Owner(event *Event) string {
+	if len(s.points) == 0 {
+		return ""
+	}
+
+	hash := shardHash(eventKey(event))
+	i := sort.Search(len(s.points), func(i int) bool {
+		return s.points[i] >= hash
+	})
+	if i == len(s.points) {
+		i = 0
+	}
+
+	return s.members[i]
+}
+
+// shardHash hashes a key onto the ring. FNV alone leaves keys that
+// only differ at the end, like "a#1" and "a#2", close together, so its
+// sum is mixed with the finalizer of murmur3.
+func shardHash(key string) uint64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(key))
+
+	sum := hash.Sum64()
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	sum *= 0xc4ceb9fe1a85ec53
+	sum ^= sum >> 33
+	return sum
+}
+
+// shard is the ring a planner runs its part of the events of.
+type shard struct {
+	ring *ShardRing
+	self string
+}
+
+// clusterer keeps the shard of a planner in step with the members of
+// the cluster.
+type clusterer struct {
+	config  ClusterConfig
+	planner *Planner
+	clock   Clock
+	members []string
+}
+
+func clustererNew(config ClusterConfig, planner *Planner, clock Clock) *clusterer {
+	if config.Self == "" {
+		config.Self = instanceName()
+	}
+	if config.Refresh <= 0 {
+		config.Refresh = defaultClusterRefresh
+	}
+
+	return &clusterer{config: config, planner: planner, clock: clock}
+}
+
+// run lists the members every refresh until ctx is done, and then
+// leaves the cluster.
+func (s *clusterer) run(ctx context.Context) {
+	ticker := s.clock.NewTicker(s.config.Refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.refresh(ctx)
+		case <-ctx.Done():
+			s.leave()
+			return
+		}
+	}
+}
+
+// refresh rebuilds the ring if the members changed. This instance is
+// always one of them, so that it runs its share even when it can't
+// list the others; if listing fails, the last ring is kept.
+func (s *clusterer) refresh(ctx context.Context) {
+	listCtx, cancel := context.WithTimeout(ctx, s.config.Refresh)
+	members, err := s.config.Membership.Members(listCtx)
+	cancel()
+
+	if err != nil {
+		log.Println("could not list cluster members: ", err)
+		if s.members != nil {
+			return
+		}
+		members = nil
+	}
+
+	members = withMember(members, s.config.Self)
+	if equalStrings(members, s.members) {
+		return
+	}
+	s.members = members
+
+	s.planner.setShard(&shard{
+		ring: ShardRingNew(members, s.config.Replicas),
+		self: s.config.Self,
+	})
+	log.Println("cluster members changed, sharing events with:", strings.Join(members, ", "))
+
+	if s.config.OnRebalance != nil {
+		s.config.OnRebalance(members)
+	}
+}
+
+func (s *clusterer) leave() {
+	leaver, ok := s.config.Membership.(membershipLeaver)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Refresh)
+	defer cancel()
+
+	if err := leaver.Leave(ctx); err != nil {
+		log.Println("could not leave the cluster: ", err)
+	}
+}
+
+// withMember returns the sorted members, with member among them.
+func withMember(members []string, member string) []string {
+	ret := make([]string, 0, len(members)+1)
+	ret = append(ret, member)
+	for _, other := range members {
+		if other != member {
+			ret = append(ret, other)
+		}
+	}
+
+	sort.Strings(ret)
+	return ret
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
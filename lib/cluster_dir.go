@@ -0,0 +1,108 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultMembershipTTL is how long a member is listed after its last
+// heartbeat, when none is given.
+const defaultMembershipTTL = 30 * time.Second
+
+// DirectoryMembership lists the members of a cluster as the files in
+// a directory, on a filesystem the members share. Every listing is
+// also the heartbeat of self, which touches its file; members whose
+// file was not touched for the ttl are left out.
+type DirectoryMembership struct {
+	dir  string
+	self string
+	ttl  time.Duration
+}
+
+// DirectoryMembershipNew creates a membership of the files in dir, of
+// which self is one. A ttl of zero defaults to 30 seconds, and should
+// be a few times the refresh of the cluster.
+func DirectoryMembershipNew(dir, self string, ttl time.Duration) *DirectoryMembership {
+	if ttl <= 0 {
+		ttl = defaultMembershipTTL
+	}
+
+	return &DirectoryMembership{dir: dir, self: self, ttl: ttl}
+}
+
+// Members beats the heart of self, and returns the members with a
+// recent heartbeat.
+func (s *DirectoryMembership) Members(_ context.Context) ([]string, error) {
+	if err := s.heartbeat(); err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	members := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || now.Sub(info.ModTime()) > s.ttl {
+			continue
+		}
+
+		member, err := url.PathUnescape(info.Name())
+		if err != nil {
+			continue
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// Leave removes the file of self, so the others take over its events
+// on their next listing rather than after the ttl.
+func (s *DirectoryMembership) Leave(_ context.Context) error {
+	err := os.Remove(s.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *DirectoryMembership) heartbeat() error {
+	path := s.path()
+	now := time.Now()
+
+	err := os.Chtimes(path, now, now)
+	if os.IsNotExist(err) {
+		return ioutil.WriteFile(path, nil, 0600)
+	}
+	return err
+}
+
+// path is the file of self. Names are escaped, as they often hold
+// colons and could hold slashes.
+func (s *DirectoryMembership) path() string {
+	return filepath.Join(s.dir, url.PathEscape(s.self))
+}
@@ -0,0 +1,371 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CompositeResult is what a composite event stores in the status
+// cache, and sends along with its alerts.
+type CompositeResult struct {
+	Expression string `json:"expression"`
+
+	// Failing are the events of the expression that failed on their
+	// last run.
+	Failing []string `json:"failing,omitempty"`
+
+	// Unknown are the events of the expression that did not run
+	// yet, or could not be found. They count as not failing.
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// CompositeHookNew returns a hook that fails when the expression holds
+// over the last runs of other events of the planner, without probing
+// anything itself. Events are named by label, or by group/label, and
+// an event name holds when the event failed on its last run.
+// Expressions combine them with and, or, not and parentheses, and with
+// counts over lists of events:
+//
+//	2 of (db-1, db-2, db-3)
+//	any of (api-eu, api-us) and not maintenance
+//	all of (lb-1, lb-2) or 50% of (web-1, web-2, web-3, web-4)
+func CompositeHookNew(expression string) (HookSignature, error) {
+	return compositeHookNew(expression, "")
+}
+
+// compositeHookNew returns the hook of a composite expression, which
+// also stores its result in the status cache under key, unless it is
+// empty.
+func compositeHookNew(expression, key string) (HookSignature, error) {
+	expr, err := parseComposite(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	names := expr.names(nil)
+	sort.Strings(names)
+
+	return func(params *HookParameters) (bool, interface{}) {
+		states := compositeStates(params.Planner, names)
+
+		result := CompositeResult{Expression: expression}
+		for _, name := range names {
+			switch state := states[name]; {
+			case state == eventStateFailed:
+				result.Failing = append(result.Failing, name)
+			case state == eventStateUnknown:
+				result.Unknown = append(result.Unknown, name)
+			}
+		}
+
+		if key != "" && params.Status != nil {
+			params.Status.Update(key, result)
+		}
+
+		return expr.eval(states), result
+	}, nil
+}
+
+// compositeStates returns the last state of the named events, by their
+// label, or group/label.
+func compositeStates(planner *Planner, names []string) map[string]int32 {
+	states := make(map[string]int32, len(names))
+	if planner == nil {
+		return states
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	planner.mux.Lock()
+	defer planner.mux.Unlock()
+
+	for _, event := range planner.uniqueEvents {
+		for _, name := range [...]string{event.Label, event.Group + "/" + event.Label} {
+			if wanted[name] && event.Label != "" {
+				// any failing event of a name makes it fail
+				if state := event.lastState(); state > states[name] {
+					states[name] = state
+				}
+			}
+		}
+	}
+
+	return states
+}
+
+// compositeExpr is a parsed composite expression.
+type compositeExpr interface {
+	eval(states map[string]int32) bool
+	names(names []string) []string
+}
+
+type compositeName string
+
+func (s compositeName) eval(states map[string]int32) bool {
+	return states[string(s)] == eventStateFailed
+}
+
+func (s compositeName) names(names []string) []string {
+	for _, name := range names {
+		if name == string(s) {
+			return names
+		}
+	}
+	return append(names, string(s))
+}
+
+type compositeNot struct{ expr compositeExpr }
+
+func (s compositeNot) eval(states map[string]int32) bool {
+	return !s.expr.eval(states)
+}
+
+func (s compositeNot) names(names []string) []string {
+	return s.expr.names(names)
+}
+
+// compositeBinary is an and, or an or.
+type compositeBinary struct {
+	and         bool
+	left, right compositeExpr
+}
+
+func (s compositeBinary) eval(states map[string]int32) bool {
+	if s.and {
+		return s.left.eval(states) && s.right.eval(states)
+	}
+	return s.left.eval(states) || s.right.eval(states)
+}
+
+func (s compositeBinary) names(names []string) []string {
+	return s.right.names(s.left.names(names))
+}
+
+// compositeCount holds when at least count of its events are failing.
+type compositeCount struct {
+	count   int
+	percent bool
+	events  []compositeName
+}
+
+func (s compositeCount) eval(states map[string]int32) bool {
+	failing := 0
+	for _, event := range s.events {
+		if event.eval(states) {
+			failing++
+		}
+	}
+
+	if s.percent {
+		return failing*100 >= s.count*len(s.events)
+	}
+	return failing >= s.count
+}
+
+func (s compositeCount) names(names []string) []string {
+	for _, event := range s.events {
+		names = event.names(names)
+	}
+	return names
+}
+
+// compositeParser is a recursive descent parser of:
+//
+//	expr   = term { "or" term }
+//	term   = factor { "and" factor }
+//	factor = "not" factor | "(" expr ")" | count "of" "(" name { "," name } ")" | name
+//	count  = "any" | "all" | number | number "%"
+type compositeParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseComposite(expression string) (compositeExpr, error) {
+	parser := &compositeParser{tokens: compositeTokens(expression)}
+	if len(parser.tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty expression", ErrCompositeExpression)
+	}
+
+	expr, err := parser.expr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, parser.errorf("unexpected %q", parser.tokens[parser.pos])
+	}
+
+	return expr, nil
+}
+
+// compositeTokens splits an expression into parentheses, commas and
+// words.
+func compositeTokens(expression string) []string {
+	var tokens []string
+	var word strings.Builder
+
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range expression {
+		switch {
+		case r == '(' || r == ')' || r == ',':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+func (s *compositeParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrCompositeExpression, fmt.Sprintf(format, args...))
+}
+
+func (s *compositeParser) peek() string {
+	if s.pos < len(s.tokens) {
+		return s.tokens[s.pos]
+	}
+	return ""
+}
+
+func (s *compositeParser) next() string {
+	token := s.peek()
+	s.pos++
+	return token
+}
+
+func (s *compositeParser) expect(token string) error {
+	if got := s.next(); got != token {
+		return s.errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func (s *compositeParser) expr() (compositeExpr, error) {
+	left, err := s.term()
+	for err == nil && strings.EqualFold(s.peek(), "or") {
+		s.next()
+
+		var right compositeExpr
+		if right, err = s.term(); err == nil {
+			left = compositeBinary{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (s *compositeParser) term() (compositeExpr, error) {
+	left, err := s.factor()
+	for err == nil && strings.EqualFold(s.peek(), "and") {
+		s.next()
+
+		var right compositeExpr
+		if right, err = s.factor(); err == nil {
+			left = compositeBinary{and: true, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (s *compositeParser) factor() (compositeExpr, error) {
+	token := s.next()
+
+	switch {
+	case token == "":
+		return nil, s.errorf("unexpected end")
+	case strings.EqualFold(token, "not"):
+		expr, err := s.factor()
+		return compositeNot{expr: expr}, err
+	case token == "(":
+		expr, err := s.expr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, s.expect(")")
+	case token == ")" || token == ",":
+		return nil, s.errorf("unexpected %q", token)
+	case strings.EqualFold(s.peek(), "of"):
+		s.next()
+		return s.count(token)
+	}
+
+	return compositeName(token), nil
+}
+
+// count parses the list of a count, which has been read up to "of".
+func (s *compositeParser) count(quantifier string) (compositeExpr, error) {
+	if err := s.expect("("); err != nil {
+		return nil, err
+	}
+
+	var count compositeCount
+	for {
+		name := s.next()
+		if name == "" || name == "(" || name == ")" || name == "," {
+			return nil, s.errorf("expected an event name, got %q", name)
+		}
+		count.events = append(count.events, compositeName(name))
+
+		if s.peek() != "," {
+			break
+		}
+		s.next()
+	}
+
+	if err := s.expect(")"); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.EqualFold(quantifier, "any"):
+		count.count = 1
+	case strings.EqualFold(quantifier, "all"):
+		count.count = len(count.events)
+	case strings.HasSuffix(quantifier, "%"):
+		percent, err := strconv.Atoi(strings.TrimSuffix(quantifier, "%"))
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, s.errorf("bad percentage %q", quantifier)
+		}
+		count.count, count.percent = percent, true
+	default:
+		n, err := strconv.Atoi(quantifier)
+		if err != nil || n < 0 {
+			return nil, s.errorf("bad count %q", quantifier)
+		}
+		count.count = n
+	}
+
+	return count, nil
+}
@@ -0,0 +1,1757 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"crypto/aes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultConfigAlertInterval is how often, in seconds, alerts are
+// sent when the config does not say.
+const defaultConfigAlertInterval = 60
+
+// Config describes a whole cynic session: the events to run, and the
+// status server, alerting and snapshots around them. Its fields use
+// json names, which the yaml and toml decoders go through as well.
+//
+// A minimal config:
+//
+//	{
+//	  "status": {"port": "9999"},
+//	  "events": [
+//	    {"label": "api", "url": "http://localhost:8080/health",
+//	     "interval": "30s", "repeat": true,
+//	     "contracts": [{"status": 200, "max_latency": "2s"}]}
+//	  ]
+//	}
+type Config struct {
+	Status    *StatusServerConfig `json:"status"`
+	Events    []EventConfig       `json:"events"`
+	Alerts    *AlertsConfig       `json:"alerts"`
+	Snapshots *SnapshotsConfig    `json:"snapshots"`
+	Metrics   *MetricsConfig      `json:"metrics"`
+	Influx    *InfluxConfig       `json:"influx"`
+	Bus       *BusPublisherConfig `json:"bus"`
+	Leader    *LeaderFileConfig   `json:"leader"`
+	Cluster   *ClusterFileConfig  `json:"cluster"`
+
+	// Discovery keeps an event for every instance of services, made
+	// from an event template.
+	Discovery []DiscoveryFileConfig `json:"discovery"`
+
+	// Distribute spreads the events evenly over the given time, with
+	// the event builder.
+	Distribute ConfigDuration `json:"distribute"`
+
+	// Stagger spreads the first runs of the events over their
+	// intervals instead, keeping the intervals: "round_robin" or
+	// "hashed".
+	Stagger string `json:"stagger"`
+
+	// Location is where this instance probes from, which its results
+	// and alerts carry.
+	Location string `json:"location"`
+
+	HostLimits *HostLimitsConfig `json:"host_limits"`
+
+	// Transport keeps connections open to the hosts the events
+	// probe, for their probes to reuse.
+	Transport *TransportLimitsConfig `json:"transport"`
+
+	// CircuitBreaker and Retry are those of the events with a url
+	// and none of their own.
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker"`
+	Retry          *RetryConfig          `json:"retry"`
+
+	SLO       *SLOFileConfig   `json:"slo"`
+	Incidents *IncidentsConfig `json:"incidents"`
+
+	// Grafana serves the last points runs of the events, and the
+	// incidents, as a grafana datasource on /grafana/.
+	Grafana *GrafanaFileConfig `json:"grafana"`
+
+	// StatusPage serves a public status page, with the uptime of the
+	// groups of events, and their incidents if incidents are tracked.
+	StatusPage *StatusPageFileConfig `json:"status_page"`
+
+	// Journal records the scheduling decisions of the planner, served
+	// on /admin/planner/journal.
+	Journal *JournalConfig `json:"journal"`
+
+	// Seed makes the jitter of the events the same on every run.
+	// Instances running the same events should have different seeds.
+	Seed *int64 `json:"seed"`
+}
+
+// JournalConfig keeps the last capacity scheduling decisions of the
+// planner, also appending them to path if it is set.
+type JournalConfig struct {
+	Capacity int    `json:"capacity"`
+	Path     string `json:"path"`
+}
+
+// IncidentsConfig tracks the failures of the events as incidents.
+type IncidentsConfig struct {
+	Capacity int `json:"capacity"`
+}
+
+// GrafanaFileConfig keeps the last points runs of every event, for
+// grafana.
+type GrafanaFileConfig struct {
+	Points int `json:"points"`
+}
+
+// StatusPageFileConfig is where the public status page is served, and
+// how long it may be cached for.
+type StatusPageFileConfig struct {
+	Host   string         `json:"host"`
+	Port   string         `json:"port"`
+	Title  string         `json:"title"`
+	MaxAge ConfigDuration `json:"max_age"`
+}
+
+// SLOFileConfig computes the availability of the events, and alerts
+// when it drops below the objective over alert_window, with alert.
+type SLOFileConfig struct {
+	Objective   float64 `json:"objective"`
+	AlertWindow string  `json:"alert_window"`
+	Alert       bool    `json:"alert"`
+}
+
+// HostLimitsConfig caps the requests of the events to any one host.
+type HostLimitsConfig struct {
+	Concurrency int     `json:"concurrency"`
+	Rate        float64 `json:"rate"`
+	Burst       int     `json:"burst"`
+}
+
+// TransportLimitsConfig are the connections kept open to the hosts
+// probed.
+type TransportLimitsConfig struct {
+	MaxIdlePerHost int            `json:"max_idle_per_host"`
+	IdleTimeout    ConfigDuration `json:"idle_timeout"`
+}
+
+// StatusServerConfig configures the status server.
+type StatusServerConfig struct {
+	Host string `json:"host"`
+	Port string `json:"port"`
+	Root string `json:"root"`
+
+	// AdminToken enables the admin interface, with this token.
+	AdminToken string `json:"admin_token"`
+
+	// PushToken lets other systems push status documents on
+	// /push/<key>, with this token, kept for PushTTL if it is set.
+	PushToken string         `json:"push_token"`
+	PushTTL   ConfigDuration `json:"push_ttl"`
+
+	// SelfMetrics is how often cynic publishes its own metrics,
+	// under "__cynic". Zero disables them.
+	SelfMetrics ConfigDuration `json:"self_metrics"`
+
+	// Signature, if set, rejects the heartbeat pings and pushed
+	// documents that were not signed with it.
+	Signature *SignatureConfig `json:"signature"`
+}
+
+// EventConfig describes an event. Events with a url probe it over
+// http, checking the contracts; named hooks must be registered with
+// RegisterHook before the config is loaded.
+type EventConfig struct {
+	Label     string           `json:"label"`
+	Group     string           `json:"group"`
+	URL       string           `json:"url"`
+	Interval  ConfigDuration   `json:"interval"`
+	Offset    ConfigDuration   `json:"offset"`
+	Repeat    bool             `json:"repeat"`
+	Immediate bool             `json:"immediate"`
+	Severity  *Severity        `json:"severity"`
+	Contracts []ContractConfig `json:"contracts"`
+	Hooks     []string         `json:"hooks"`
+
+	// Tags are tags of the event, like {"team": "payments"}.
+	Tags map[string]string `json:"tags"`
+
+	// Thresholds alert on numbers in the json body of the url.
+	Thresholds []ThresholdConfig `json:"thresholds"`
+
+	// Anomaly, if set, alerts when the latency of the url, or the
+	// value of one of its thresholds, strays from what it learned.
+	Anomaly *AnomalyConfig `json:"anomaly"`
+
+	// Composite is an expression over the last runs of other events,
+	// see CompositeHookNew, which the event fails on.
+	Composite string `json:"composite"`
+
+	// Heartbeat, if set, makes the event a passive check, which fails
+	// when a job stops pinging it.
+	Heartbeat *HeartbeatConfig `json:"heartbeat"`
+
+	// Pushed is the key of a document pushed to the status server,
+	// which the event fails on when it is missing, expired, or was
+	// pushed as failed.
+	Pushed string `json:"pushed"`
+
+	// Host, if set, checks the disks, memory and load of the host
+	// cynic runs on.
+	Host *HostCheckConfig `json:"host"`
+
+	// Process, if set, checks that a process runs on the host.
+	Process *ProcessCheckConfig `json:"process"`
+
+	// NTP, if set, checks the local clock against an ntp server.
+	NTP *NTPCheckConfig `json:"ntp"`
+
+	// KeyWatch, if set, checks how often a key of the status cache
+	// changes.
+	KeyWatch *KeyWatchConfig `json:"key_watch"`
+
+	// CircuitBreaker, if set, stops probing the url for a while
+	// after it failed to respond too many times in a row. It
+	// defaults to the circuit breaker of the config.
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker"`
+
+	// StopOnFailure skips the hooks of the event after the first one
+	// that fails, like those after a failed probe.
+	StopOnFailure bool `json:"stop_on_failure"`
+
+	// Family forces the address family the url is probed over, either
+	// "ipv4" or "ipv6". DualStack probes it over both instead, each
+	// on its own, storing their results under the label or url of
+	// the event followed by "/ipv4" and "/ipv6".
+	Family    string `json:"family"`
+	DualStack bool   `json:"dual_stack"`
+
+	// OAuth2, if set, authenticates the probes of the url with
+	// client credentials tokens, shared by the events with the same
+	// credentials.
+	OAuth2 *OAuth2Config `json:"oauth2"`
+
+	// MaxBodyBytes is how much of the body of the url is read, to
+	// check against. It defaults to 4 MB.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+
+	// Retry, if set, probes the url again when it fails to respond.
+	// It defaults to the retry of the config.
+	Retry *RetryConfig `json:"retry"`
+
+	// Disabled plans the event without running it, until it is
+	// enabled through the admin interface.
+	Disabled bool `json:"disabled"`
+
+	// Windows, if set, restrict when the event runs, by time of the
+	// day and calendar.
+	Windows *ExecutionWindows `json:"windows"`
+
+	// Overlap, if set, runs the event in the background, and decides
+	// what happens to its runs that are due while it still runs.
+	Overlap *OverlapConfig `json:"overlap"`
+
+	// TLS, if set, is how the certificate of the url is verified.
+	TLS *ProbeTLSConfig `json:"tls"`
+
+	// Capture, if set, keeps what the probe of the url saw when it
+	// last failed, for the admin interface.
+	Capture *CaptureConfig `json:"capture"`
+
+	// Golden, if set, compares the body of the url to a fixture.
+	Golden *GoldenConfig `json:"golden"`
+
+	// Transaction, if set, runs several requests in a row, sharing
+	// cookies and variables, and stores how they went under the label.
+	Transaction *TransactionConfig `json:"transaction"`
+}
+
+// RetryConfig probes a url up to Attempts times, waiting Backoff
+// before the first retry, and twice as long before each next one, for
+// no more than half the interval of the event in all. Only probes that
+// got no response are retried, and those that got a server error, with
+// On5xx. Events retrying their probes run in the background, skipping
+// their runs that overlap, unless they have an overlap policy.
+type RetryConfig struct {
+	Attempts int            `json:"attempts"`
+	Backoff  ConfigDuration `json:"backoff"`
+	On5xx    bool           `json:"on_5xx"`
+}
+
+// HeartbeatConfig expects a ping on /ping/<token> of the status server
+// at least once every Within, which defaults to the interval of the
+// event.
+type HeartbeatConfig struct {
+	Token  string         `json:"token"`
+	Within ConfigDuration `json:"within"`
+}
+
+// CircuitBreakerConfig opens the circuit of a probe after Failures
+// requests in a row got no response, for Cooldown.
+type CircuitBreakerConfig struct {
+	Failures int            `json:"failures"`
+	Cooldown ConfigDuration `json:"cooldown"`
+}
+
+// ContractConfig is what a probed url must satisfy. Zero values are
+// not checked.
+type ContractConfig struct {
+	Status     int            `json:"status"`
+	Contains   string         `json:"contains"`
+	MaxLatency ConfigDuration `json:"max_latency"`
+}
+
+// AlertsConfig configures the alerter and its sinks.
+type AlertsConfig struct {
+	// Interval is how often pending alerts are sent.
+	Interval ConfigDuration `json:"interval"`
+
+	Slack    *SlackConfig    `json:"slack"`
+	Webhook  *WebhookConfig  `json:"webhook"`
+	Syslog   *SyslogConfig   `json:"syslog"`
+	Journald *JournaldConfig `json:"journald"`
+	SNMP     *SNMPTrapConfig `json:"snmp"`
+
+	// Hook is the name of a registered alert hook, used when no
+	// other sink is configured.
+	Hook string `json:"hook"`
+
+	// Holidays, if set, is the path of a holiday calendar, see
+	// LoadHolidayCalendar, on whose days the non-critical alerts are
+	// suppressed, or routed elsewhere.
+	Holidays string `json:"holidays"`
+
+	// Grouping, if set, groups the alerts of different events sharing
+	// a root cause, like a host that died, into one message.
+	Grouping *AlertGroupConfig `json:"grouping"`
+}
+
+// SlackConfig configures the slack alert sink.
+type SlackConfig struct {
+	Hook string `json:"hook"`
+
+	// Signature, if set, signs the posts.
+	Signature *SignatureConfig `json:"signature"`
+}
+
+// WebhookConfig configures the webhook alert sink, which posts the
+// alerts as json.
+type WebhookConfig struct {
+	URL string `json:"url"`
+
+	// Signature, if set, signs the posts.
+	Signature *SignatureConfig `json:"signature"`
+}
+
+// SignatureConfig signs requests with an HMAC of their body, or
+// verifies their signatures. See WebhookSignature.
+type SignatureConfig struct {
+	Secret  string         `json:"secret"`
+	Header  string         `json:"header"`
+	MaxSkew ConfigDuration `json:"max_skew"`
+}
+
+// SyslogConfig configures the syslog alert sink. Without a network,
+// alerts go to the local syslog daemon.
+type SyslogConfig struct {
+	Network string `json:"network"`
+	Addr    string `json:"addr"`
+
+	// Facility is "user", "daemon", or "local0" to "local7". It
+	// defaults to daemon.
+	Facility string `json:"facility"`
+	AppName  string `json:"app_name"`
+}
+
+// JournaldConfig configures the journald alert sink.
+type JournaldConfig struct {
+	Socket     string `json:"socket"`
+	Identifier string `json:"identifier"`
+}
+
+// SNMPTrapConfig configures the snmp trap alert sink.
+type SNMPTrapConfig struct {
+	Addr string `json:"addr"`
+
+	// Version is "2c" or "3".
+	Version   string `json:"version"`
+	Community string `json:"community"`
+
+	User         string `json:"user"`
+	AuthProtocol string `json:"auth_protocol"`
+	AuthPassword string `json:"auth_password"`
+	PrivProtocol string `json:"priv_protocol"`
+	PrivPassword string `json:"priv_password"`
+
+	// BootsFile keeps the engine boots of v3 traps across restarts.
+	BootsFile string `json:"boots_file"`
+}
+
+// SnapshotsConfig configures snapshots of the status server.
+type SnapshotsConfig struct {
+	Interval  ConfigDuration `json:"interval"`
+	DumpEvery ConfigDuration `json:"dump_every"`
+	Path      string         `json:"path"`
+	Stream    bool           `json:"stream"`
+
+	// Compression is "none", "gzip", or "zstd" once a zstd codec is
+	// given to RegisterSnapshotCodec.
+	Compression string `json:"compression"`
+
+	// Backend is "file", "sqlite", or the name of a backend given to
+	// RegisterSnapshotBackend.
+	Backend string `json:"backend"`
+
+	// EncryptionKeyEnv, if set, encrypts the snapshots with the key,
+	// base64 encoded, in this environment variable.
+	EncryptionKeyEnv string `json:"encryption_key_env"`
+}
+
+// MetricsConfig configures the emission of per event metrics, to
+// either statsd or graphite, given as host:port.
+type MetricsConfig struct {
+	Statsd   string `json:"statsd"`
+	Graphite string `json:"graphite"`
+	Prefix   string `json:"prefix"`
+
+	// Tags sends the tags of the events to statsd, the DogStatsD
+	// way.
+	Tags bool `json:"tags"`
+}
+
+// InfluxConfig configures writing the results of events to InfluxDB.
+type InfluxConfig struct {
+	// URL is the write url, with the database or bucket in its
+	// query.
+	URL         string `json:"url"`
+	Token       string `json:"token"`
+	Measurement string `json:"measurement"`
+}
+
+// BusPublisherConfig configures publishing results and alerts on an
+// event bus.
+type BusPublisherConfig struct {
+	// Transport is "nats", or the name of a transport given to
+	// RegisterBusTransport, like "kafka".
+	Transport string `json:"transport"`
+	Addr      string `json:"addr"`
+
+	// Format is "json" or "protobuf".
+	Format       string `json:"format"`
+	ResultsTopic string `json:"results_topic"`
+	AlertsTopic  string `json:"alerts_topic"`
+}
+
+// LeaderFileConfig makes the instance one of an active and standby
+// pair, with a lease kept in a file both can reach.
+type LeaderFileConfig struct {
+	LeaseFile string         `json:"lease_file"`
+	TTL       ConfigDuration `json:"ttl"`
+	Holder    string         `json:"holder"`
+}
+
+// ClusterFileConfig makes the instance share the events with the
+// other members of a cluster, either listed in members, or found by
+// their heartbeats in members_dir.
+type ClusterFileConfig struct {
+	MembersDir string         `json:"members_dir"`
+	Members    []string       `json:"members"`
+	Self       string         `json:"self"`
+	Refresh    ConfigDuration `json:"refresh"`
+	TTL        ConfigDuration `json:"ttl"`
+}
+
+func (s *ClusterFileConfig) cluster() *ClusterConfig {
+	self := s.Self
+	if self == "" {
+		self = instanceName()
+	}
+
+	var membership Membership = StaticMembership(s.Members)
+	if s.MembersDir != "" {
+		membership = DirectoryMembershipNew(s.MembersDir, self, time.Duration(s.TTL))
+	}
+
+	return &ClusterConfig{
+		Membership: membership,
+		Self:       self,
+		Refresh:    time.Duration(s.Refresh),
+	}
+}
+
+// DiscoveryFileConfig finds the instances of a service with one of
+// srv, consul or kubernetes, and keeps an event for each, out of the
+// event template. The template may use {host}, {port} and {addr} in
+// its label, group and url, and its events get the tags of their
+// instance.
+type DiscoveryFileConfig struct {
+	SRV        string                `json:"srv"`
+	Consul     *ConsulSource         `json:"consul"`
+	Kubernetes *KubernetesFileConfig `json:"kubernetes"`
+	Refresh    ConfigDuration        `json:"refresh"`
+	Event      EventConfig           `json:"event"`
+}
+
+// KubernetesFileConfig finds the instances of a kubernetes service,
+// either with the service account of the pod, in_cluster, or at api.
+type KubernetesFileConfig struct {
+	InCluster bool   `json:"in_cluster"`
+	API       string `json:"api"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Port      string `json:"port"`
+	TokenFile string `json:"token_file"`
+}
+
+func (s *DiscoveryFileConfig) source() (DiscoverySource, error) {
+	switch {
+	case s.SRV != "":
+		return &SRVSource{Name: s.SRV}, nil
+	case s.Consul != nil:
+		return s.Consul, nil
+	}
+
+	kubernetes := s.Kubernetes
+	if kubernetes.InCluster {
+		return KubernetesInClusterSourceNew(kubernetes.Namespace, kubernetes.Service, kubernetes.Port)
+	}
+
+	return &KubernetesSource{
+		API:       kubernetes.API,
+		Namespace: kubernetes.Namespace,
+		Service:   kubernetes.Service,
+		Port:      kubernetes.Port,
+		TokenFile: kubernetes.TokenFile,
+	}, nil
+}
+
+// template returns the event template for the target, with its
+// placeholders filled in and its tags. IPv6 hosts are bracketed in the
+// url.
+func (s *DiscoveryFileConfig) template(target DiscoveryTarget) EventConfig {
+	port := strconv.Itoa(target.Port)
+	replacer := strings.NewReplacer("{host}", target.Host, "{port}", port, "{addr}", target.Addr())
+
+	urlHost := target.Host
+	if strings.Contains(urlHost, ":") {
+		urlHost = "[" + urlHost + "]"
+	}
+	urlReplacer := strings.NewReplacer("{host}", urlHost, "{port}", port, "{addr}", target.Addr())
+
+	config := s.Event
+	config.Label = replacer.Replace(config.Label)
+	config.Group = replacer.Replace(config.Group)
+	config.URL = urlReplacer.Replace(config.URL)
+
+	config.Tags = make(map[string]string, len(target.Tags)+len(s.Event.Tags))
+	for key, value := range target.Tags {
+		config.Tags[key] = value
+	}
+	for key, value := range s.Event.Tags {
+		config.Tags[key] = value
+	}
+
+	return config
+}
+
+// discovery returns the discovery, whose events store their results
+// in repo, if set.
+func (s *DiscoveryFileConfig) discovery(repo *StatusCache) (DiscoveryConfig, error) {
+	source, err := s.source()
+	if err != nil {
+		return DiscoveryConfig{}, err
+	}
+
+	// the template is checked once, so that its events can be made
+	// without errors later on
+	if _, err := s.Event.event(); err != nil {
+		return DiscoveryConfig{}, err
+	}
+
+	file := *s
+	return DiscoveryConfig{
+		Source:  source,
+		Refresh: time.Duration(s.Refresh),
+		Template: func(target DiscoveryTarget) Event {
+			config := file.template(target)
+			event, _ := config.event()
+			if repo != nil {
+				event.SetDataRepo(repo)
+			}
+			return event
+		},
+	}, nil
+}
+
+// ConfigDuration is a duration written as a string, like "1m30s", or
+// as a number of seconds.
+type ConfigDuration time.Duration
+
+// UnmarshalJSON decodes a duration string, or a number of seconds.
+func (s *ConfigDuration) UnmarshalJSON(data []byte) error {
+	var secs float64
+	if err := json.Unmarshal(data, &secs); err == nil {
+		*s = ConfigDuration(secs * float64(time.Second))
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("%w: bad duration %s", ErrConfigInvalid, string(data))
+	}
+
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrConfigInvalid, err.Error())
+	}
+
+	*s = ConfigDuration(duration)
+	return nil
+}
+
+// MarshalJSON encodes the duration as a string.
+func (s ConfigDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(s).String())
+}
+
+// ConfigDecoder decodes a config file into generic values: maps,
+// slices, strings, numbers and booleans.
+type ConfigDecoder func(data []byte, v interface{}) error
+
+var (
+	registryMutex  sync.RWMutex
+	configDecoders = map[string]ConfigDecoder{
+		".json": json.Unmarshal,
+		".yaml": decodeYAML,
+		".yml":  decodeYAML,
+		".toml": decodeTOML,
+	}
+	namedHooks      = map[string]HookSignature{}
+	namedAlertHooks = map[string]AlertFunc{}
+)
+
+// RegisterConfigDecoder sets the decoder of config files with the
+// given extension. json, yaml and toml are built in, without
+// dependencies: the yaml and toml decoders only read the parts of
+// those formats that configs need, like nested mappings, tables and
+// arrays, but not anchors or dates. A full decoder can replace them,
+// eg:
+//
+//	cynic.RegisterConfigDecoder(".yaml", yaml.Unmarshal)
+func RegisterConfigDecoder(ext string, decoder ConfigDecoder) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	configDecoders[strings.ToLower(ext)] = decoder
+}
+
+// RegisterHook names a hook, so that config files can refer to it.
+func RegisterHook(name string, hook HookSignature) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	namedHooks[name] = hook
+}
+
+// RegisterAlertHook names an alert hook, so that config files can
+// refer to it.
+func RegisterAlertHook(name string, hook AlertFunc) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	namedAlertHooks[name] = hook
+}
+
+// LoadConfig reads a config file, picking the decoder by extension.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseConfig(data, filepath.Ext(path))
+}
+
+// ParseConfig decodes a config with the decoder of the given
+// extension, and validates it.
+func ParseConfig(data []byte, ext string) (*Config, error) {
+	registryMutex.RLock()
+	decoder, ok := configDecoders[strings.ToLower(ext)]
+	registryMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrConfigUnknownFormat, ext)
+	}
+
+	var generic interface{}
+	if err := decoder(data, &generic); err != nil {
+		return nil, err
+	}
+
+	// go through json, so that every format shares the json names
+	normalized, err := json.Marshal(normalizeConfigValue(generic))
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(normalized, &config); err != nil {
+		return nil, err
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	for i := range config.Events {
+		if config.Events[i].CircuitBreaker == nil {
+			config.Events[i].CircuitBreaker = config.CircuitBreaker
+		}
+		if config.Events[i].Retry == nil {
+			config.Events[i].Retry = config.Retry
+		}
+	}
+
+	return &config, nil
+}
+
+// normalizeConfigValue turns the map[interface{}]interface{} some
+// yaml decoders produce into maps json can encode.
+func normalizeConfigValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			ret[fmt.Sprintf("%v", key)] = normalizeConfigValue(val)
+		}
+		return ret
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = normalizeConfigValue(val)
+		}
+		return v
+	case []interface{}:
+		for i, val := range v {
+			v[i] = normalizeConfigValue(val)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func (s *Config) validate() error {
+	for i := range s.Events {
+		if err := s.Events[i].validate(fmt.Sprintf("event %d", i)); err != nil {
+			return err
+		}
+	}
+
+	if breaker := s.CircuitBreaker; breaker != nil && (breaker.Failures < 1 || breaker.Cooldown <= 0) {
+		return fmt.Errorf("%w: circuit_breaker needs failures and a cooldown", ErrConfigInvalid)
+	}
+
+	if retry := s.Retry; retry != nil && (retry.Attempts < 1 || retry.Backoff < 0) {
+		return fmt.Errorf("%w: retry needs attempts", ErrConfigInvalid)
+	}
+
+	if s.SLO != nil {
+		if s.SLO.Objective < 0 || s.SLO.Objective >= 100 {
+			return fmt.Errorf("%w: slo objective must be a percentage under 100", ErrConfigInvalid)
+		}
+		if s.SLO.AlertWindow != "" && !isSLOWindow(s.SLO.AlertWindow) {
+			return fmt.Errorf("%w: slo alert_window must be 1h, 24h or 30d", ErrConfigInvalid)
+		}
+		if s.SLO.Alert && s.Alerts == nil {
+			return fmt.Errorf("%w: slo alerts need alerts", ErrConfigInvalid)
+		}
+	}
+
+	switch s.Stagger {
+	case "", "round_robin", "hashed":
+	default:
+		return fmt.Errorf("%w: unknown stagger %q", ErrConfigInvalid, s.Stagger)
+	}
+
+	if s.Stagger != "" && s.Distribute > 0 {
+		return fmt.Errorf("%w: use either distribute or stagger", ErrConfigInvalid)
+	}
+
+	if s.Metrics != nil && (s.Metrics.Statsd == "") == (s.Metrics.Graphite == "") {
+		return fmt.Errorf("%w: metrics need either statsd or graphite", ErrConfigInvalid)
+	}
+
+	if s.Alerts != nil && s.Alerts.Syslog != nil {
+		if _, err := ParseSyslogFacility(s.Alerts.Syslog.Facility); err != nil {
+			return fmt.Errorf("%w: %s", ErrConfigInvalid, err.Error())
+		}
+	}
+
+	if s.Alerts != nil && s.Alerts.Webhook != nil && s.Alerts.Webhook.URL == "" {
+		return fmt.Errorf("%w: webhook needs a url", ErrConfigInvalid)
+	}
+
+	for _, signature := range s.signatures() {
+		if signature.Secret == "" {
+			return fmt.Errorf("%w: signature needs a secret", ErrConfigInvalid)
+		}
+		if signature.MaxSkew < 0 {
+			return fmt.Errorf("%w: signature max_skew must be positive", ErrConfigInvalid)
+		}
+	}
+
+	if s.Alerts != nil && s.Alerts.SNMP != nil {
+		switch s.Alerts.SNMP.Version {
+		case "", "2c", "3":
+		default:
+			return fmt.Errorf("%w: unknown snmp version %q", ErrConfigInvalid, s.Alerts.SNMP.Version)
+		}
+	}
+
+	if s.Influx != nil && s.Influx.URL == "" {
+		return fmt.Errorf("%w: influx needs a url", ErrConfigInvalid)
+	}
+
+	if s.Leader != nil && s.Leader.LeaseFile == "" {
+		return fmt.Errorf("%w: leader needs a lease_file", ErrConfigInvalid)
+	}
+
+	if s.HostLimits != nil && (s.HostLimits.Concurrency < 0 || s.HostLimits.Rate < 0 || s.HostLimits.Burst < 0) {
+		return fmt.Errorf("%w: host_limits can't be negative", ErrConfigInvalid)
+	}
+
+	if s.Transport != nil && (s.Transport.MaxIdlePerHost < 0 || s.Transport.IdleTimeout < 0) {
+		return fmt.Errorf("%w: transport limits can't be negative", ErrConfigInvalid)
+	}
+
+	if s.Grafana != nil && s.Status == nil {
+		return fmt.Errorf("%w: grafana needs a status server", ErrConfigInvalid)
+	}
+
+	if s.Journal != nil && s.Journal.Capacity < 0 {
+		return fmt.Errorf("%w: journal capacity can't be negative", ErrConfigInvalid)
+	}
+
+	if s.Cluster != nil {
+		if (s.Cluster.MembersDir == "") == (len(s.Cluster.Members) == 0) {
+			return fmt.Errorf("%w: cluster needs one of members_dir or members", ErrConfigInvalid)
+		}
+		if len(s.Cluster.Members) > 0 && s.Cluster.Self == "" {
+			return fmt.Errorf("%w: cluster members need a self", ErrConfigInvalid)
+		}
+	}
+
+	for i := range s.Discovery {
+		discovery := &s.Discovery[i]
+
+		sources := 0
+		if discovery.SRV != "" {
+			sources++
+		}
+		if discovery.Consul != nil {
+			sources++
+		}
+		if discovery.Kubernetes != nil {
+			sources++
+		}
+		if sources != 1 {
+			return fmt.Errorf("%w: discovery %d needs one of srv, consul or kubernetes", ErrConfigInvalid, i)
+		}
+
+		if discovery.Consul != nil && discovery.Consul.Service == "" {
+			return fmt.Errorf("%w: discovery %d: consul needs a service", ErrConfigInvalid, i)
+		}
+
+		if kubernetes := discovery.Kubernetes; kubernetes != nil {
+			if kubernetes.Service == "" || (!kubernetes.InCluster && (kubernetes.API == "" || kubernetes.Namespace == "")) {
+				return fmt.Errorf("%w: discovery %d: kubernetes needs a service, and an api and namespace", ErrConfigInvalid, i)
+			}
+		}
+
+		if err := discovery.Event.validate(fmt.Sprintf("discovery %d event", i)); err != nil {
+			return err
+		}
+	}
+
+	if s.Bus != nil {
+		if s.Bus.Transport == "" || s.Bus.Addr == "" {
+			return fmt.Errorf("%w: bus needs a transport and an addr", ErrConfigInvalid)
+		}
+
+		if _, err := ParseBusFormat(s.Bus.Format); err != nil {
+			return fmt.Errorf("%w: %s", ErrConfigInvalid, err.Error())
+		}
+	}
+
+	if s.Snapshots != nil {
+		if time.Duration(s.Snapshots.Interval) <= 0 || time.Duration(s.Snapshots.DumpEvery) <= 0 {
+			return fmt.Errorf("%w: snapshots need an interval and dump_every", ErrConfigInvalid)
+		}
+
+		if s.Status == nil {
+			return fmt.Errorf("%w: snapshots need a status server", ErrConfigInvalid)
+		}
+
+		compression, err := parseSnapshotCompression(s.Snapshots.Compression)
+		if err != nil {
+			return err
+		}
+		if _, err := snapshotCodecFor(compression); err != nil {
+			return fmt.Errorf("%w: snapshots: %v", ErrConfigInvalid, err)
+		}
+
+		if s.Snapshots.Backend != "" {
+			if _, err := snapshotBackendFactory(s.Snapshots.Backend); err != nil {
+				return fmt.Errorf("%w: snapshots: %v", ErrConfigInvalid, err)
+			}
+		}
+
+		if env := s.Snapshots.EncryptionKeyEnv; env != "" {
+			key, err := SnapshotKeyFromEnv(env)()
+			if err == nil {
+				_, err = aes.NewCipher(key)
+			}
+			if err != nil {
+				return fmt.Errorf("%w: snapshots: %v", ErrConfigInvalid, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Session builds a session out of the config.
+func (s *Config) Session() (Session, error) {
+	session, err := s.scheduledSession()
+	if err != nil {
+		return Session{}, err
+	}
+
+	session.Location = s.Location
+
+	if s.HostLimits != nil {
+		session.HostLimits = &HostLimits{
+			Concurrency: s.HostLimits.Concurrency,
+			Rate:        s.HostLimits.Rate,
+			Burst:       s.HostLimits.Burst,
+		}
+	}
+
+	if s.Transport != nil {
+		session.Transports = &TransportLimits{
+			MaxIdlePerHost: s.Transport.MaxIdlePerHost,
+			IdleTimeout:    time.Duration(s.Transport.IdleTimeout),
+		}
+	}
+
+	if s.Journal != nil {
+		session.Journal = &PlannerJournalConfig{Capacity: s.Journal.Capacity, Path: s.Journal.Path}
+	}
+
+	session.Seed = s.Seed
+
+	if s.Status != nil {
+		statusCache := s.Status.statusCache()
+		session.StatusCache = &statusCache
+		session.SelfMetrics = time.Duration(s.Status.SelfMetrics)
+
+		for i := range session.Events {
+			session.Events[i].SetDataRepo(session.StatusCache)
+		}
+	}
+
+	var alertHooks []AlertFunc
+	if s.Bus != nil {
+		publisher, err := s.Bus.publisher()
+		if err != nil {
+			return Session{}, err
+		}
+		session.Sinks = append(session.Sinks, publisher)
+		alertHooks = append(alertHooks, publisher.Alert)
+	}
+
+	if s.Alerts != nil {
+		alerter, err := s.Alerts.alerter(alertHooks...)
+		if err != nil {
+			return Session{}, err
+		}
+		session.Alerter = alerter
+	}
+
+	if s.SLO != nil {
+		config := SLOConfig{Objective: s.SLO.Objective, AlertWindow: s.SLO.AlertWindow}
+		if s.SLO.Alert {
+			config.Alerter = session.Alerter
+		}
+		session.SLO = SLOTrackerNew(config)
+	}
+
+	if s.Incidents != nil {
+		session.Incidents = IncidentTrackerNew(IncidentConfig{
+			Capacity: s.Incidents.Capacity,
+			Alerter:  session.Alerter,
+		})
+	}
+
+	if s.Grafana != nil {
+		session.Grafana = GrafanaDatasourceNew(GrafanaConfig{Points: s.Grafana.Points})
+	}
+
+	if s.StatusPage != nil {
+		page, err := StatusPageNew(StatusPageConfig{
+			Host:      s.StatusPage.Host,
+			Port:      s.StatusPage.Port,
+			Title:     s.StatusPage.Title,
+			MaxAge:    time.Duration(s.StatusPage.MaxAge),
+			Incidents: session.Incidents,
+		})
+		if err != nil {
+			return Session{}, err
+		}
+		session.StatusPage = page
+	}
+
+	if s.Snapshots != nil {
+		session.SnapshotConfig = s.Snapshots.snapshotConfig()
+	}
+
+	if s.Metrics != nil {
+		metrics, err := s.Metrics.emitter()
+		if err != nil {
+			return Session{}, err
+		}
+		session.Metrics = metrics
+	}
+
+	if s.Influx != nil {
+		session.Sinks = append(session.Sinks, s.Influx.sink())
+	}
+
+	if s.Leader != nil {
+		session.Leader = &LeaderConfig{
+			Lock:   FileLeaseLockNew(s.Leader.LeaseFile),
+			Holder: s.Leader.Holder,
+			TTL:    time.Duration(s.Leader.TTL),
+		}
+	}
+
+	if s.Cluster != nil {
+		session.Cluster = s.Cluster.cluster()
+	}
+
+	for i := range s.Discovery {
+		discovery, err := s.Discovery[i].discovery(session.StatusCache)
+		if err != nil {
+			return Session{}, err
+		}
+		session.Discovery = append(session.Discovery, discovery)
+	}
+
+	return session, nil
+}
+
+// Simulate returns when the events of the config would run, over the
+// given duration, without running them or starting anything.
+func (s *Config) Simulate(duration time.Duration) ([]SimulatedRun, error) {
+	session, err := s.scheduledSession()
+	if err != nil {
+		return nil, err
+	}
+
+	planner := PlannerNew()
+	for i := range session.Events {
+		planner.Add(&session.Events[i])
+	}
+
+	return planner.Simulate(duration), nil
+}
+
+// scheduledSession returns a session with the events of the config,
+// distributed or staggered as configured.
+func (s *Config) scheduledSession() (Session, error) {
+	events, err := s.events()
+	if err != nil {
+		return Session{}, err
+	}
+
+	builder := EventBuilderNew(events)
+	if s.Distribute > 0 {
+		builder.DistributeEvents(int(time.Duration(s.Distribute) / time.Second))
+	}
+
+	switch s.Stagger {
+	case "round_robin":
+		builder.Stagger(StaggerRoundRobin)
+	case "hashed":
+		builder.Stagger(StaggerHashed)
+	}
+
+	session, ok := builder.Build()
+	if !ok && s.Distribute > 0 {
+		return Session{}, fmt.Errorf("%w: could not distribute events", ErrConfigInvalid)
+	}
+
+	return session, nil
+}
+
+func (s *Config) events() ([]Event, error) {
+	events := make([]Event, 0, len(s.Events))
+	for i := range s.Events {
+		event, err := s.Events[i].event()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// validate checks the event, named name in the errors.
+func (s *EventConfig) validate(name string) error {
+	if time.Duration(s.Interval) < time.Second {
+		return fmt.Errorf("%w: %s: interval must be at least a second", ErrConfigInvalid, name)
+	}
+
+	if !s.checks() {
+		return fmt.Errorf("%w: %s: needs a url, hooks or another check", ErrConfigInvalid, name)
+	}
+
+	if s.Pushed != "" && s.URL != "" {
+		return fmt.Errorf("%w: %s: a pushed event can't have a url", ErrConfigInvalid, name)
+	}
+
+	if host := s.Host; host != nil {
+		if s.URL != "" {
+			return fmt.Errorf("%w: %s: a host check can't have a url", ErrConfigInvalid, name)
+		}
+		if host.MaxDiskPercent < 0 || host.MaxInodePercent < 0 || host.MaxMemoryPercent < 0 || host.MaxLoad < 0 {
+			return fmt.Errorf("%w: %s: host limits can't be negative", ErrConfigInvalid, name)
+		}
+		if len(host.Mounts) == 0 && (host.MaxDiskPercent > 0 || host.MaxInodePercent > 0) {
+			return fmt.Errorf("%w: %s: host disk limits need mounts", ErrConfigInvalid, name)
+		}
+	}
+
+	if process := s.Process; process != nil {
+		if s.URL != "" {
+			return fmt.Errorf("%w: %s: a process check can't have a url", ErrConfigInvalid, name)
+		}
+		if process.MaxCPUPercent < 0 {
+			return fmt.Errorf("%w: %s: process limits can't be negative", ErrConfigInvalid, name)
+		}
+		if _, err := processHookNew(*process, ""); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+		}
+	}
+
+	if ntp := s.NTP; ntp != nil {
+		if s.URL != "" || ntp.Server == "" {
+			return fmt.Errorf("%w: %s: an ntp check needs a server, and no url", ErrConfigInvalid, name)
+		}
+		if ntp.MaxOffset < 0 || ntp.Timeout < 0 {
+			return fmt.Errorf("%w: %s: ntp durations can't be negative", ErrConfigInvalid, name)
+		}
+	}
+
+	if watch := s.KeyWatch; watch != nil {
+		if s.URL != "" || watch.Key == "" || watch.Key == s.Label {
+			return fmt.Errorf("%w: %s: a key watch needs a key other than its label, and no url", ErrConfigInvalid, name)
+		}
+		if watch.MaxChangesPerHour < 0 || watch.MaxUnchanged < 0 {
+			return fmt.Errorf("%w: %s: key watch limits can't be negative", ErrConfigInvalid, name)
+		}
+		if watch.MaxChangesPerHour == 0 && watch.MaxUnchanged == 0 {
+			return fmt.Errorf("%w: %s: a key watch needs a limit", ErrConfigInvalid, name)
+		}
+	}
+
+	if transaction := s.Transaction; transaction != nil {
+		if s.URL != "" || s.Label == "" {
+			return fmt.Errorf("%w: %s: a transaction needs a label, and no url", ErrConfigInvalid, name)
+		}
+		if err := transaction.validate(); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+		}
+	}
+
+	if heartbeat := s.Heartbeat; heartbeat != nil {
+		if s.URL != "" || s.Label == "" || heartbeat.Token == "" {
+			return fmt.Errorf("%w: %s: a heartbeat needs a label and a token, and no url", ErrConfigInvalid, name)
+		}
+		if strings.Contains(heartbeat.Token, "/") || heartbeat.Within < 0 {
+			return fmt.Errorf("%w: %s: bad heartbeat token or period", ErrConfigInvalid, name)
+		}
+	}
+
+	if s.Composite != "" {
+		if s.URL != "" {
+			return fmt.Errorf("%w: %s: a composite can't have a url", ErrConfigInvalid, name)
+		}
+		if _, err := parseComposite(s.Composite); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+		}
+	}
+
+	if s.URL == "" && len(s.Contracts) > 0 {
+		return fmt.Errorf("%w: %s: contracts need a url", ErrConfigInvalid, name)
+	}
+
+	if s.URL == "" && len(s.Thresholds) > 0 {
+		return fmt.Errorf("%w: %s: thresholds need a url", ErrConfigInvalid, name)
+	}
+
+	for _, threshold := range s.Thresholds {
+		if _, err := parseJSONPath(threshold.Path); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+		}
+		if threshold.MaxDelta != nil && threshold.Window <= 0 {
+			return fmt.Errorf("%w: %s: threshold max_delta needs a window", ErrConfigInvalid, name)
+		}
+	}
+
+	if err := s.validateAnomaly(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+	}
+
+	if s.Windows != nil {
+		if _, err := s.Windows.parse(); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+		}
+	}
+
+	if s.Overlap != nil {
+		if err := s.Overlap.validate(); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+		}
+	}
+
+	if breaker := s.CircuitBreaker; breaker != nil && (breaker.Failures < 1 || breaker.Cooldown <= 0) {
+		return fmt.Errorf("%w: %s: circuit_breaker needs failures and a cooldown", ErrConfigInvalid, name)
+	}
+
+	if retry := s.Retry; retry != nil && (retry.Attempts < 1 || retry.Backoff < 0) {
+		return fmt.Errorf("%w: %s: retry needs attempts", ErrConfigInvalid, name)
+	}
+
+	switch s.Family {
+	case "", FamilyIPv4, FamilyIPv6:
+	default:
+		return fmt.Errorf("%w: %s: unknown family %q", ErrConfigInvalid, name, s.Family)
+	}
+
+	if (s.Family != "" || s.DualStack) && s.URL == "" {
+		return fmt.Errorf("%w: %s: families need a url", ErrConfigInvalid, name)
+	}
+
+	if s.Family != "" && s.DualStack {
+		return fmt.Errorf("%w: %s: use either family or dual_stack", ErrConfigInvalid, name)
+	}
+
+	if s.TLS != nil {
+		if s.URL == "" || isUnixURL(s.URL) {
+			return fmt.Errorf("%w: %s: tls needs a url, not over a unix socket", ErrConfigInvalid, name)
+		}
+		if _, _, err := s.TLS.pins(); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+		}
+	}
+
+	if capture := s.Capture; capture != nil && (s.URL == "" || capture.MaxBodyBytes < 0) {
+		return fmt.Errorf("%w: %s: capture needs a url, and max_body_bytes can't be negative", ErrConfigInvalid, name)
+	}
+
+	if golden := s.Golden; golden != nil {
+		if s.URL == "" {
+			return fmt.Errorf("%w: %s: golden needs a url", ErrConfigInvalid, name)
+		}
+		if err := golden.validate(); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+		}
+	}
+
+	if isUnixURL(s.URL) {
+		if _, _, err := unixTarget(s.URL); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfigInvalid, name, err)
+		}
+		if s.Family != "" || s.DualStack {
+			return fmt.Errorf("%w: %s: unix sockets have no address family", ErrConfigInvalid, name)
+		}
+	}
+
+	if oauth2 := s.OAuth2; oauth2 != nil {
+		if s.URL == "" || oauth2.TokenURL == "" || oauth2.ClientID == "" {
+			return fmt.Errorf("%w: %s: oauth2 needs a url, a token_url and a client_id", ErrConfigInvalid, name)
+		}
+		if oauth2.RefreshBefore < 0 {
+			return fmt.Errorf("%w: %s: oauth2 refresh_before can't be negative", ErrConfigInvalid, name)
+		}
+	}
+
+	if s.MaxBodyBytes < 0 {
+		return fmt.Errorf("%w: %s: max_body_bytes can't be negative", ErrConfigInvalid, name)
+	}
+
+	return nil
+}
+
+// checks returns whether the event checks anything: a url, hooks, a
+// composite, a heartbeat, a pushed document, the host, a process, the
+// clock, a key of the status or a transaction.
+func (s *EventConfig) checks() bool {
+	return s.URL != "" || len(s.Hooks) > 0 || s.Composite != "" || s.Heartbeat != nil || s.Pushed != "" ||
+		s.Host != nil || s.Process != nil || s.NTP != nil || s.KeyWatch != nil ||
+		s.Transaction != nil
+}
+
+// validateAnomaly checks that the anomaly detector of the event, if
+// any, watches a metric its probe has.
+func (s *EventConfig) validateAnomaly() error {
+	anomaly := s.Anomaly
+	if anomaly == nil {
+		return nil
+	}
+
+	if s.URL == "" {
+		return fmt.Errorf("anomaly needs a url")
+	}
+
+	if anomaly.Sigma < 0 || anomaly.Alpha < 0 || anomaly.Alpha >= 1 || anomaly.Warmup < 0 {
+		return fmt.Errorf("anomaly needs a positive sigma and warmup, and an alpha under 1")
+	}
+
+	if anomaly.Metric == "" || anomaly.Metric == AnomalyLatency {
+		return nil
+	}
+	for _, threshold := range s.Thresholds {
+		if threshold.Path == anomaly.Metric {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("anomaly metric %q is not latency or the path of a threshold", anomaly.Metric)
+}
+
+func (s *EventConfig) event() (Event, error) {
+	event := EventNew(int(time.Duration(s.Interval) / time.Second))
+	event.Label = s.Label
+	event.Group = s.Group
+	for key, value := range s.Tags {
+		event.SetTag(key, value)
+	}
+	event.SetOffset(int(time.Duration(s.Offset) / time.Second))
+	event.Repeat(s.Repeat)
+	event.Immediate(s.Immediate)
+	event.StopOnFailure(s.StopOnFailure)
+	if s.Disabled {
+		event.Disable()
+	}
+	if s.Windows != nil {
+		if err := event.SetWindows(*s.Windows); err != nil {
+			return Event{}, err
+		}
+	}
+	if s.Overlap != nil {
+		if err := event.SetOverlap(*s.Overlap); err != nil {
+			return Event{}, err
+		}
+	} else if s.Retry != nil && s.Retry.Attempts > 1 {
+		// waiting on retries holds up the tick of the planner
+		if err := event.SetOverlap(OverlapConfig{Policy: OverlapSkip}); err != nil {
+			return Event{}, err
+		}
+	}
+
+	if s.Severity != nil {
+		event.SetSeverity(*s.Severity)
+	}
+
+	if s.TLS != nil && s.TLS.InsecureSkipVerify {
+		log.Println("not verifying the certificate of", s.URL, "as insecure_skip_verify is set")
+		event.insecureTLS = true
+	}
+
+	if s.URL != "" {
+		families := []string{s.Family}
+		if s.DualStack {
+			families = []string{FamilyIPv4, FamilyIPv6}
+		}
+
+		// each family is probed on its own, with its own circuit,
+		// thresholds and anomalies
+		for _, family := range families {
+			probeConfig := *s
+			probeConfig.Family = family
+			if err := probeConfig.addProbeHooks(&event); err != nil {
+				return Event{}, err
+			}
+		}
+	}
+
+	if s.Composite != "" {
+		key := s.Label
+		if key == "" {
+			key = s.Composite
+		}
+
+		hook, err := compositeHookNew(s.Composite, key)
+		if err != nil {
+			return Event{}, err
+		}
+		event.AddHook(hook)
+	}
+
+	if s.Pushed != "" {
+		event.AddHook(PushedHookNew(s.Pushed))
+	}
+
+	if s.Host != nil {
+		event.AddHook(hostHookNew(*s.Host, s.Label))
+	}
+
+	if s.Process != nil {
+		hook, err := processHookNew(*s.Process, s.Label)
+		if err != nil {
+			return Event{}, err
+		}
+		event.AddHook(hook)
+	}
+
+	if s.NTP != nil {
+		event.AddHook(ntpHookNew(*s.NTP, s.Label))
+	}
+
+	if s.KeyWatch != nil {
+		event.AddHook(keyWatchHookNew(*s.KeyWatch, s.Label))
+	}
+
+	if s.Transaction != nil {
+		hook, err := transactionHookNew(s.Label, s.Transaction)
+		if err != nil {
+			return Event{}, err
+		}
+		event.AddHook(hook)
+	}
+
+	if s.Heartbeat != nil {
+		within := time.Duration(s.Heartbeat.Within)
+		if within == 0 {
+			within = time.Duration(s.Interval)
+		}
+		event.AddHook(heartbeatHookNew(s.Heartbeat.Token, within, s.Label))
+	}
+
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	for _, name := range s.Hooks {
+		hook, ok := namedHooks[name]
+		if !ok {
+			return Event{}, fmt.Errorf("%w: %s", ErrConfigUnknownHook, name)
+		}
+		event.AddHook(hook)
+	}
+
+	return event, nil
+}
+
+// addProbeHooks adds the hooks probing the url to event.
+func (s *EventConfig) addProbeHooks(event *Event) error {
+	var breaker *CircuitBreaker
+	if s.CircuitBreaker != nil {
+		breaker = CircuitBreakerNew(s.CircuitBreaker.Failures, time.Duration(s.CircuitBreaker.Cooldown))
+	}
+
+	var thresholds *ThresholdRules
+	if len(s.Thresholds) > 0 {
+		rules, err := ThresholdRulesNew(s.Thresholds)
+		if err != nil {
+			return err
+		}
+		thresholds = rules
+	}
+
+	var client *http.Client
+	if s.TLS != nil {
+		config, err := s.TLS.TLSConfig()
+		if err != nil {
+			return err
+		}
+		client = tlsClient(s.Family, config)
+	}
+
+	event.AddHook(httpProbeHookNew(s, client, breaker, thresholds))
+
+	if s.Anomaly != nil {
+		event.AddHook(AnomalyHookNew(s.probeKey(), *s.Anomaly))
+	}
+
+	return nil
+}
+
+// probeKey is where the probe of the url stores its result in the
+// status cache: the label of the event, or its url, followed by the
+// family when both are probed.
+func (s *EventConfig) probeKey() string {
+	key := s.Label
+	if key == "" {
+		key = s.URL
+	}
+
+	if s.DualStack {
+		key += "/" + s.Family
+	}
+	return key
+}
+
+// key identifies an event across config reloads.
+func (s *EventConfig) key() string {
+	if s.Label != "" {
+		return s.Label
+	}
+	return s.URL
+}
+
+func (s *StatusServerConfig) statusCache() StatusCache {
+	port := s.Port
+	if port == "" {
+		port = StatusPort
+	}
+
+	root := s.Root
+	if root == "" {
+		root = DefaultStatusEndpoint
+	}
+
+	statusCache := StatusServerNew(s.Host, port, root)
+	if s.AdminToken != "" {
+		statusCache.WithAdmin(&AdminConfig{Token: s.AdminToken})
+	}
+	if s.PushToken != "" {
+		statusCache.WithPush(&PushConfig{Token: s.PushToken, TTL: time.Duration(s.PushTTL)})
+	}
+	if s.Signature != nil {
+		statusCache.WithSignature(s.Signature.signature())
+	}
+
+	return statusCache
+}
+
+// signatures are the signatures set in the config.
+func (s *Config) signatures() []*SignatureConfig {
+	var signatures []*SignatureConfig
+	if s.Status != nil && s.Status.Signature != nil {
+		signatures = append(signatures, s.Status.Signature)
+	}
+	if s.Alerts != nil && s.Alerts.Slack != nil && s.Alerts.Slack.Signature != nil {
+		signatures = append(signatures, s.Alerts.Slack.Signature)
+	}
+	if s.Alerts != nil && s.Alerts.Webhook != nil && s.Alerts.Webhook.Signature != nil {
+		signatures = append(signatures, s.Alerts.Webhook.Signature)
+	}
+	return signatures
+}
+
+func (s *SignatureConfig) signature() *WebhookSignature {
+	return &WebhookSignature{Secret: s.Secret, Header: s.Header, MaxSkew: time.Duration(s.MaxSkew)}
+}
+
+// alerter creates the alerter, giving the alerts to the extra hooks
+// as well as to the sink.
+func (s *AlertsConfig) alerter(extra ...AlertFunc) (*Alerter, error) {
+	var alertFn AlertFunc
+
+	switch {
+	case s.Slack != nil:
+		slack := SlackAlerterNew(s.Slack.Hook)
+		if s.Slack.Signature != nil {
+			slack.SetSignature(s.Slack.Signature.signature())
+		}
+		alertFn = slack.Alert
+	case s.Webhook != nil:
+		webhook := WebhookAlerterNew(s.Webhook.URL)
+		if s.Webhook.Signature != nil {
+			webhook.SetSignature(s.Webhook.Signature.signature())
+		}
+		alertFn = webhook.Alert
+	case s.Syslog != nil:
+		sink := SyslogSinkNew(s.Syslog.Network, s.Syslog.Addr)
+		if facility, err := ParseSyslogFacility(s.Syslog.Facility); err == nil {
+			sink.SetFacility(facility)
+		}
+		if s.Syslog.AppName != "" {
+			sink.SetAppName(s.Syslog.AppName)
+		}
+		alertFn = sink.Alert
+	case s.SNMP != nil:
+		sink, err := s.SNMP.sink()
+		if err != nil {
+			return nil, err
+		}
+		alertFn = sink.Alert
+	case s.Journald != nil:
+		sink := JournaldSinkNew()
+		if s.Journald.Socket != "" {
+			sink.SetSocket(s.Journald.Socket)
+		}
+		if s.Journald.Identifier != "" {
+			sink.SetIdentifier(s.Journald.Identifier)
+		}
+		alertFn = sink.Alert
+	case s.Hook != "":
+		registryMutex.RLock()
+		hook, ok := namedAlertHooks[s.Hook]
+		registryMutex.RUnlock()
+
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrConfigUnknownHook, s.Hook)
+		}
+		alertFn = hook
+	default:
+		return nil, fmt.Errorf("%w: alerts need a sink", ErrConfigInvalid)
+	}
+
+	interval := int(time.Duration(s.Interval) / time.Second)
+	if interval <= 0 {
+		interval = defaultConfigAlertInterval
+	}
+
+	// alerts are grouped once the holidays routed theirs elsewhere
+	if s.Grouping != nil {
+		alertFn = AlertGrouping(*s.Grouping, alertFn)
+	}
+
+	if s.Holidays != "" {
+		calendar, err := LoadHolidayCalendar(s.Holidays)
+		if err != nil {
+			return nil, err
+		}
+		alertFn = calendar.Route(alertFn)
+	}
+
+	if len(extra) > 0 {
+		alertFn = AlertFanout(append([]AlertFunc{alertFn}, extra...)...)
+	}
+
+	alerter := AlerterNew(interval, alertFn)
+	return &alerter, nil
+}
+
+func (s *SNMPTrapConfig) sink() (*SNMPTrapSink, error) {
+	config := SNMPConfig{
+		Addr:         s.Addr,
+		Community:    s.Community,
+		User:         s.User,
+		AuthProtocol: SNMPAuthProtocol(strings.ToLower(s.AuthProtocol)),
+		AuthPassword: s.AuthPassword,
+		PrivProtocol: SNMPPrivProtocol(strings.ToLower(s.PrivProtocol)),
+		PrivPassword: s.PrivPassword,
+		BootsFile:    s.BootsFile,
+	}
+
+	// the version was checked when the config was loaded
+	if s.Version == "3" {
+		config.Version = SNMPv3
+	}
+
+	sink, err := SNMPTrapSinkNew(config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrConfigInvalid, err.Error())
+	}
+	return sink, nil
+}
+
+func (s *BusPublisherConfig) publisher() (*BusPublisher, error) {
+	transport, err := busTransportFor(s.Transport, s.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the format was checked when the config was loaded
+	format, _ := ParseBusFormat(s.Format)
+
+	return BusPublisherNew(transport, BusConfig{
+		Format:       format,
+		ResultsTopic: s.ResultsTopic,
+		AlertsTopic:  s.AlertsTopic,
+	}), nil
+}
+
+func (s *MetricsConfig) emitter() (*MetricsEmitter, error) {
+	if s.Statsd != "" && s.Tags {
+		return MetricsEmitterNew("udp", s.Statsd, s.Prefix, DogStatsdFormat)
+	}
+	if s.Statsd != "" {
+		return StatsdEmitterNew(s.Statsd, s.Prefix)
+	}
+	return GraphiteEmitterNew(s.Graphite, s.Prefix)
+}
+
+func (s *InfluxConfig) sink() *InfluxSink {
+	sink := InfluxSinkNew(s.URL)
+	if s.Token != "" {
+		sink.SetToken(s.Token)
+	}
+	if s.Measurement != "" {
+		sink.SetMeasurement(s.Measurement)
+	}
+	return sink
+}
+
+func (s *SnapshotsConfig) snapshotConfig() *SnapshotConfig {
+	config := &SnapshotConfig{
+		Interval:  time.Duration(s.Interval),
+		DumpEvery: time.Duration(s.DumpEvery),
+		Path:      s.Path,
+		Backend:   s.Backend,
+	}
+
+	if s.Stream {
+		config.Format = SnapshotFormatStream
+	}
+
+	// the name was checked by validate
+	config.Compression, _ = parseSnapshotCompression(s.Compression)
+
+	if s.EncryptionKeyEnv != "" {
+		config.Encrypt = true
+		config.Key = SnapshotKeyFromEnv(s.EncryptionKeyEnv)
+	}
+
+	return config
+}
+
+func parseSnapshotCompression(name string) (SnapshotCompression, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return SnapshotCompressionNone, nil
+	case "gzip":
+		return SnapshotCompressionGzip, nil
+	case "zstd":
+		return SnapshotCompressionZstd, nil
+	}
+
+	return SnapshotCompressionNone, fmt.Errorf("%w: unknown compression %q", ErrConfigInvalid, name)
+}
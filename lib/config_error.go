@@ -0,0 +1,34 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import "fmt"
+
+var (
+	ErrConfigUnknownFormat = fmt.Errorf("no decoder registered for config format")
+	ErrConfigInvalid       = fmt.Errorf("invalid config")
+	ErrConfigSyntax        = fmt.Errorf("config syntax error")
+	ErrConfigUnknownHook   = fmt.Errorf("unknown hook")
+	ErrProbeContract       = fmt.Errorf("probe contract failed")
+	ErrProbeThreshold      = fmt.Errorf("probe threshold crossed")
+	ErrThresholdPath       = fmt.Errorf("bad threshold path")
+	ErrOAuth2Token         = fmt.Errorf("could not get an oauth2 token")
+	ErrUnixURL             = fmt.Errorf("bad unix socket url")
+	ErrProbeTLS            = fmt.Errorf("bad probe tls")
+	ErrProbeGolden         = fmt.Errorf("probe differs from its golden fixture")
+)
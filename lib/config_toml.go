@@ -0,0 +1,446 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tomlParser reads the part of toml that configs need: tables, arrays
+// of tables, dotted keys, strings, numbers, booleans, arrays and
+// inline tables. Dates and multi-line strings are not supported.
+type tomlParser struct {
+	data []byte
+	pos  int
+	line int
+}
+
+// decodeTOML is the built in decoder of .toml config files.
+func decodeTOML(data []byte, v interface{}) error {
+	ptr, ok := v.(*interface{})
+	if !ok {
+		return fmt.Errorf("%w: toml decodes into *interface{}, not %T", ErrConfigSyntax, v)
+	}
+
+	root, err := parseTOML(data)
+	if err != nil {
+		return err
+	}
+
+	*ptr = root
+	return nil
+}
+
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	p := &tomlParser{data: data, line: 1}
+	root := map[string]interface{}{}
+	table := root
+
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			array := p.hasPrefix("[[")
+			if array {
+				p.pos += 2
+			} else {
+				p.pos++
+			}
+
+			keys, err := p.keys()
+			if err != nil {
+				return nil, err
+			}
+
+			closing := "]"
+			if array {
+				closing = "]]"
+			}
+			p.skipSpaces()
+			if !p.hasPrefix(closing) {
+				return nil, p.errorf("expected %q", closing)
+			}
+			p.pos += len(closing)
+
+			if table, err = p.table(root, keys, array); err != nil {
+				return nil, err
+			}
+		} else if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: toml line %d: %s", ErrConfigSyntax, s.line, fmt.Sprintf(format, args...))
+}
+
+func (s *tomlParser) eof() bool {
+	return s.pos >= len(s.data)
+}
+
+func (s *tomlParser) peek() byte {
+	if s.eof() {
+		return 0
+	}
+	return s.data[s.pos]
+}
+
+func (s *tomlParser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(string(s.data[s.pos:]), prefix)
+}
+
+// skipSpaces skips the spaces and tabs on the current line.
+func (s *tomlParser) skipSpaces() {
+	for !s.eof() && (s.peek() == ' ' || s.peek() == '\t') {
+		s.pos++
+	}
+}
+
+func (s *tomlParser) skipComment() {
+	if s.peek() != '#' {
+		return
+	}
+	for !s.eof() && s.peek() != '\n' {
+		s.pos++
+	}
+}
+
+// skipBlank skips spaces, comments and new lines.
+func (s *tomlParser) skipBlank() {
+	for {
+		s.skipSpaces()
+		s.skipComment()
+
+		switch {
+		case s.hasPrefix("\r\n"):
+			s.pos += 2
+		case s.peek() == '\n':
+			s.pos++
+		default:
+			return
+		}
+		s.line++
+	}
+}
+
+func (s *tomlParser) endOfLine() error {
+	s.skipSpaces()
+	s.skipComment()
+
+	switch {
+	case s.eof():
+		return nil
+	case s.hasPrefix("\r\n"):
+		s.pos += 2
+	case s.peek() == '\n':
+		s.pos++
+	default:
+		return s.errorf("unexpected %q", s.peek())
+	}
+
+	s.line++
+	return nil
+}
+
+// keys reads a dotted key, like a."b c".d
+func (s *tomlParser) keys() ([]string, error) {
+	var keys []string
+
+	for {
+		s.skipSpaces()
+
+		var (
+			key string
+			err error
+		)
+		switch s.peek() {
+		case '"':
+			key, err = s.basicString()
+		case '\'':
+			key, err = s.literalString()
+		default:
+			start := s.pos
+			for !s.eof() && isTOMLBareKey(s.peek()) {
+				s.pos++
+			}
+			if start == s.pos {
+				return nil, s.errorf("expected a key")
+			}
+			key = string(s.data[start:s.pos])
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		s.skipSpaces()
+		if s.peek() != '.' {
+			return keys, nil
+		}
+		s.pos++
+	}
+}
+
+func isTOMLBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// table finds or makes the table of a [header], or appends one to the
+// array of a [[header]].
+func (s *tomlParser) table(root map[string]interface{}, keys []string, array bool) (map[string]interface{}, error) {
+	table := root
+
+	for i, key := range keys {
+		last := i == len(keys)-1
+
+		switch existing := table[key].(type) {
+		case nil:
+			if last && array {
+				next := map[string]interface{}{}
+				table[key] = []interface{}{next}
+				return next, nil
+			}
+			next := map[string]interface{}{}
+			table[key] = next
+			table = next
+		case map[string]interface{}:
+			if last && array {
+				return nil, s.errorf("%q is a table, not an array of tables", key)
+			}
+			table = existing
+		case []interface{}:
+			if last && array {
+				next := map[string]interface{}{}
+				table[key] = append(existing, next)
+				return next, nil
+			}
+			if last || len(existing) == 0 {
+				return nil, s.errorf("%q is an array, not a table", key)
+			}
+			next, ok := existing[len(existing)-1].(map[string]interface{})
+			if !ok {
+				return nil, s.errorf("%q is an array, not a table", key)
+			}
+			table = next
+		default:
+			return nil, s.errorf("%q is already a value", key)
+		}
+	}
+
+	return table, nil
+}
+
+// keyValue reads a key = value line into the table.
+func (s *tomlParser) keyValue(table map[string]interface{}) error {
+	keys, err := s.keys()
+	if err != nil {
+		return err
+	}
+
+	s.skipSpaces()
+	if s.peek() != '=' {
+		return s.errorf("expected '=' after %q", strings.Join(keys, "."))
+	}
+	s.pos++
+	s.skipSpaces()
+
+	value, err := s.value()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys[:len(keys)-1] {
+		switch existing := table[key].(type) {
+		case nil:
+			next := map[string]interface{}{}
+			table[key] = next
+			table = next
+		case map[string]interface{}:
+			table = existing
+		default:
+			return s.errorf("%q is already a value", key)
+		}
+	}
+
+	key := keys[len(keys)-1]
+	if _, ok := table[key]; ok {
+		return s.errorf("%q is defined twice", key)
+	}
+	table[key] = value
+
+	return nil
+}
+
+func (s *tomlParser) value() (interface{}, error) {
+	switch c := s.peek(); {
+	case s.hasPrefix(`"""`) || s.hasPrefix("'''"):
+		return nil, s.errorf("multi-line strings are not supported")
+	case c == '"':
+		return s.basicString()
+	case c == '\'':
+		return s.literalString()
+	case c == '[':
+		return s.array()
+	case c == '{':
+		return s.inlineTable()
+	case s.hasPrefix("true"):
+		s.pos += len("true")
+		return true, nil
+	case s.hasPrefix("false"):
+		s.pos += len("false")
+		return false, nil
+	default:
+		return s.number()
+	}
+}
+
+func (s *tomlParser) basicString() (string, error) {
+	start := s.pos
+	s.pos++
+
+	for !s.eof() && s.peek() != '"' && s.peek() != '\n' {
+		if s.peek() == '\\' {
+			s.pos++
+		}
+		s.pos++
+	}
+	if s.peek() != '"' {
+		return "", s.errorf("unterminated string")
+	}
+	s.pos++
+
+	// toml escapes are a subset of go's, bar \U which is the same
+	str, err := strconv.Unquote(string(s.data[start:s.pos]))
+	if err != nil {
+		return "", s.errorf("bad string %s", s.data[start:s.pos])
+	}
+
+	return str, nil
+}
+
+func (s *tomlParser) literalString() (string, error) {
+	s.pos++
+	start := s.pos
+
+	for !s.eof() && s.peek() != '\'' && s.peek() != '\n' {
+		s.pos++
+	}
+	if s.peek() != '\'' {
+		return "", s.errorf("unterminated string")
+	}
+	s.pos++
+
+	return string(s.data[start : s.pos-1]), nil
+}
+
+func (s *tomlParser) array() ([]interface{}, error) {
+	s.pos++
+	values := []interface{}{}
+
+	for {
+		s.skipBlank()
+		if s.peek() == ']' {
+			s.pos++
+			return values, nil
+		}
+
+		value, err := s.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		s.skipBlank()
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return values, nil
+		default:
+			return nil, s.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (s *tomlParser) inlineTable() (map[string]interface{}, error) {
+	s.pos++
+	table := map[string]interface{}{}
+
+	s.skipSpaces()
+	if s.peek() == '}' {
+		s.pos++
+		return table, nil
+	}
+
+	for {
+		if err := s.keyValue(table); err != nil {
+			return nil, err
+		}
+
+		s.skipSpaces()
+		switch s.peek() {
+		case ',':
+			s.pos++
+			s.skipSpaces()
+		case '}':
+			s.pos++
+			return table, nil
+		default:
+			return nil, s.errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
+
+func (s *tomlParser) number() (interface{}, error) {
+	start := s.pos
+	for !s.eof() && strings.IndexByte("+-0123456789abcdefoxABCDEF._", s.peek()) >= 0 {
+		s.pos++
+	}
+
+	text := string(s.data[start:s.pos])
+	if text == "" {
+		return nil, s.errorf("unexpected %q", s.peek())
+	}
+
+	digits := strings.TrimLeft(text, "+-")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+		return nil, s.errorf("bad value %q: leading zeros", text)
+	}
+
+	if n, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return n, nil
+	}
+
+	isHex := strings.HasPrefix(digits, "0x")
+	if f, err := strconv.ParseFloat(strings.ReplaceAll(text, "_", ""), 64); err == nil && !isHex {
+		return f, nil
+	}
+
+	return nil, s.errorf("bad value %q", text)
+}
@@ -0,0 +1,260 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultConfigPollInterval = 5 * time.Second
+
+// ConfigWatcher runs the events of a config file, and applies the
+// changes made to them while running: events are added, removed, or
+// replaced when their config changed. Events are told apart by label,
+// or url when they have no label. The status cache is kept as is, so
+// no history is lost, and so is whether events were disabled.
+//
+// Only events are reloaded; changes to the status server, alerts and
+// snapshots need a restart.
+type ConfigWatcher struct {
+	path    string
+	planner *Planner
+	status  *StatusCache
+
+	mux     sync.Mutex
+	events  map[string]*watchedEvent
+	modTime time.Time
+	poll    time.Duration
+}
+
+type watchedEvent struct {
+	config EventConfig
+	event  *Event
+}
+
+// ConfigWatcherNew loads the config file at path, and returns a
+// watcher, and a session to give to Start. The events of the session
+// are in its planner.
+func ConfigWatcherNew(path string) (*ConfigWatcher, Session, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, Session{}, err
+	}
+
+	if err := validateEventKeys(config.Events); err != nil {
+		return nil, Session{}, err
+	}
+
+	session, err := config.Session()
+	if err != nil {
+		return nil, Session{}, err
+	}
+
+	watcher := &ConfigWatcher{
+		path:    path,
+		planner: PlannerNew(),
+		status:  session.StatusCache,
+		events:  make(map[string]*watchedEvent),
+		poll:    defaultConfigPollInterval,
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		watcher.modTime = info.ModTime()
+	}
+
+	for i := range session.Events {
+		event := session.Events[i]
+		watcher.events[config.Events[i].key()] = &watchedEvent{
+			config: config.Events[i],
+			event:  &event,
+		}
+		watcher.planner.Add(&event)
+	}
+
+	session.Events = nil
+	session.Planner = watcher.planner
+
+	return watcher, session, nil
+}
+
+// SetPollInterval sets how often the config file is checked for
+// changes. Zero disables polling, leaving SIGHUP.
+func (s *ConfigWatcher) SetPollInterval(interval time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.poll = interval
+}
+
+// Watch reloads the config on SIGHUP, and whenever the file changes,
+// until stop is closed.
+func (s *ConfigWatcher) Watch(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	s.mux.Lock()
+	poll := s.poll
+	s.mux.Unlock()
+
+	var pollCh <-chan time.Time
+	if poll > 0 {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		pollCh = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			s.logReload(s.Reload())
+		case <-pollCh:
+			if s.changed() {
+				s.logReload(s.Reload())
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *ConfigWatcher) logReload(err error) {
+	if err != nil {
+		log.Println("could not reload config, keeping the running one: ", err)
+		return
+	}
+	log.Println("reloaded config: ", s.path)
+}
+
+func (s *ConfigWatcher) changed() bool {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return !info.ModTime().Equal(s.modTime)
+}
+
+// Reload reads the config file again, and applies the changes made to
+// its events. Nothing changes if the new config is invalid. Added and
+// changed events get the offsets, and intervals when distributed, that
+// starting with the new config gives them; the others keep theirs.
+func (s *ConfigWatcher) Reload() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+
+	config, err := LoadConfig(s.path)
+	if err != nil {
+		return err
+	}
+
+	if err := validateEventKeys(config.Events); err != nil {
+		return err
+	}
+
+	// build everything first, so a bad event changes nothing, like on
+	// start, so that new events are staggered or distributed the same
+	scheduled, err := config.scheduledSession()
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]*watchedEvent, len(config.Events))
+	for i := range config.Events {
+		eventConfig := config.Events[i]
+		key := eventConfig.key()
+
+		if running, ok := s.events[key]; ok && reflect.DeepEqual(running.config, eventConfig) {
+			wanted[key] = running
+			continue
+		}
+
+		event := scheduled.Events[i]
+		event.SetDataRepo(s.status)
+
+		// events disabled or enabled through the admin interface stay
+		// so, unless the config changed it
+		if running, ok := s.events[key]; ok && running.config.Disabled == eventConfig.Disabled {
+			if running.event.IsDisabled() {
+				event.Disable()
+			} else {
+				event.Enable()
+			}
+		}
+		wanted[key] = &watchedEvent{config: eventConfig, event: &event}
+	}
+
+	for key, running := range s.events {
+		if wanted[key] != running {
+			s.planner.Delete(running.event)
+		}
+	}
+
+	for key, next := range wanted {
+		if s.events[key] != next {
+			s.planner.Add(next.event)
+		}
+	}
+
+	s.events = wanted
+	return nil
+}
+
+// Events returns the labels, or urls, of the running events.
+func (s *ConfigWatcher) Events() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	keys := make([]string, 0, len(s.events))
+	for key := range s.events {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func validateEventKeys(events []EventConfig) error {
+	seen := make(map[string]bool, len(events))
+	for i := range events {
+		key := events[i].key()
+		if key == "" {
+			return fmt.Errorf("%w: event %d: reloadable events need a label or url", ErrConfigInvalid, i)
+		}
+		if seen[key] {
+			return fmt.Errorf("%w: duplicate event %q", ErrConfigInvalid, key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
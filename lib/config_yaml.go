@@ -0,0 +1,470 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a line of yaml, without its indentation and comment.
+type yamlLine struct {
+	indent int
+	text   string
+	number int
+}
+
+// yamlParser reads the block part of yaml that configs need: nested
+// mappings and sequences, quoted and plain scalars, and flow
+// sequences and mappings on a single line. Anchors, tags, block
+// scalars and multiple documents are not supported.
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+// decodeYAML is the built in decoder of .yaml and .yml config files.
+func decodeYAML(data []byte, v interface{}) error {
+	ptr, ok := v.(*interface{})
+	if !ok {
+		return fmt.Errorf("%w: yaml decodes into *interface{}, not %T", ErrConfigSyntax, v)
+	}
+
+	root, err := parseYAML(data)
+	if err != nil {
+		return err
+	}
+
+	*ptr = root
+	return nil
+}
+
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, "\r")
+
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, yamlErrorf(i+1, "tabs can not indent")
+		}
+
+		text = strings.TrimRight(stripYAMLComment(text), " \t")
+		switch {
+		case text == "" || text == "---" && len(p.lines) == 0:
+			continue
+		case text == "---" || text == "...":
+			return nil, yamlErrorf(i+1, "only one document is supported")
+		}
+
+		p.lines = append(p.lines, yamlLine{indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text, number: i + 1})
+	}
+
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+
+	value, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, p.errorf("bad indentation")
+	}
+
+	return value, nil
+}
+
+func yamlErrorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: yaml line %d: %s", ErrConfigSyntax, line, fmt.Sprintf(format, args...))
+}
+
+func (s *yamlParser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if s.i < len(s.lines) {
+		line = s.lines[s.i].number
+	} else if len(s.lines) > 0 {
+		line = s.lines[len(s.lines)-1].number
+	}
+	return yamlErrorf(line, format, args...)
+}
+
+// stripYAMLComment cuts the comment off a line: a # at its start or
+// after a space, outside of quotes.
+func stripYAMLComment(text string) string {
+	var quote byte
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+
+	return text
+}
+
+func isYAMLSequenceEntry(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLMappingEntry splits "key: value" in its key and value.
+func splitYAMLMappingEntry(text string) (string, string, bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingYAMLQuote(text)
+		if end < 0 {
+			return "", "", false
+		}
+		rest := text[end+1:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		key, err := yamlQuoted(text[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+
+	if strings.HasSuffix(text, ":") && !strings.Contains(text, ": ") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true
+	}
+
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		return "", "", false
+	}
+
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+2:]), true
+}
+
+// closingYAMLQuote is the index of the quote closing the one text
+// starts with, or -1.
+func closingYAMLQuote(text string) int {
+	quote := text[0]
+
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case quote == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+
+	return -1
+}
+
+func yamlQuoted(text string) (string, error) {
+	if text[0] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	return strconv.Unquote(text)
+}
+
+// block reads the mapping or sequence whose entries are at indent.
+func (s *yamlParser) block(indent int) (interface{}, error) {
+	if isYAMLSequenceEntry(s.lines[s.i].text) {
+		return s.sequence(indent)
+	}
+
+	if _, _, ok := splitYAMLMappingEntry(s.lines[s.i].text); !ok {
+		// a lone scalar, like a document that is only a string
+		value, err := s.scalar(s.lines[s.i].text)
+		s.i++
+		return value, err
+	}
+
+	return s.mapping(indent)
+}
+
+func (s *yamlParser) sequence(indent int) ([]interface{}, error) {
+	values := []interface{}{}
+
+	for s.i < len(s.lines) && s.lines[s.i].indent == indent && isYAMLSequenceEntry(s.lines[s.i].text) {
+		line := s.lines[s.i]
+		rest := strings.TrimLeft(line.text[1:], " ")
+
+		var (
+			value interface{}
+			err   error
+		)
+		switch _, _, isMapping := splitYAMLMappingEntry(rest); {
+		case rest == "":
+			s.i++
+			if s.i < len(s.lines) && s.lines[s.i].indent > indent {
+				value, err = s.block(s.lines[s.i].indent)
+			}
+		case isMapping || isYAMLSequenceEntry(rest):
+			// "- key: value" starts a mapping, whose other keys line
+			// up with its first one
+			s.lines[s.i] = yamlLine{
+				indent: indent + len(line.text) - len(rest),
+				text:   rest,
+				number: line.number,
+			}
+			value, err = s.block(s.lines[s.i].indent)
+		default:
+			value, err = s.scalar(rest)
+			s.i++
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	if s.i < len(s.lines) && s.lines[s.i].indent > indent {
+		return nil, s.errorf("bad indentation")
+	}
+
+	return values, nil
+}
+
+func (s *yamlParser) mapping(indent int) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	for s.i < len(s.lines) && s.lines[s.i].indent == indent {
+		key, rest, ok := splitYAMLMappingEntry(s.lines[s.i].text)
+		if !ok {
+			return nil, s.errorf("expected \"key: value\", got %q", s.lines[s.i].text)
+		}
+		if _, ok := values[key]; ok {
+			return nil, s.errorf("%q is defined twice", key)
+		}
+
+		var (
+			value interface{}
+			err   error
+		)
+		if rest != "" {
+			value, err = s.scalar(rest)
+			s.i++
+		} else {
+			s.i++
+			switch {
+			case s.i < len(s.lines) && s.lines[s.i].indent > indent:
+				value, err = s.block(s.lines[s.i].indent)
+			case s.i < len(s.lines) && s.lines[s.i].indent == indent && isYAMLSequenceEntry(s.lines[s.i].text):
+				// sequences may line up with their key
+				value, err = s.sequence(indent)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		values[key] = value
+	}
+
+	if s.i < len(s.lines) && s.lines[s.i].indent > indent {
+		return nil, s.errorf("bad indentation")
+	}
+
+	return values, nil
+}
+
+// scalar reads the value after a key or a dash, which may be a flow
+// sequence or mapping.
+func (s *yamlParser) scalar(text string) (interface{}, error) {
+	if text[0] == '[' || text[0] == '{' {
+		flow := &yamlFlow{text: text}
+		value, err := flow.value()
+		if err != nil {
+			return nil, s.errorf("%v", err)
+		}
+		if flow.skipSpaces(); flow.pos != len(text) {
+			return nil, s.errorf("unexpected %q after %q", text[flow.pos:], text[:flow.pos])
+		}
+		return value, nil
+	}
+
+	if text[0] == '"' || text[0] == '\'' {
+		if end := closingYAMLQuote(text); end != len(text)-1 {
+			return nil, s.errorf("bad quoted string %s", text)
+		}
+		value, err := yamlQuoted(text)
+		if err != nil {
+			return nil, s.errorf("bad quoted string %s", text)
+		}
+		return value, nil
+	}
+
+	if strings.IndexByte("&*!|>%@`", text[0]) >= 0 {
+		return nil, s.errorf("%q is not supported", text[0])
+	}
+
+	return yamlPlain(text), nil
+}
+
+// yamlPlain resolves an unquoted scalar to a boolean, number or null,
+// or keeps it as a string.
+func yamlPlain(text string) interface{} {
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return n
+	}
+
+	digits := strings.TrimLeft(text, "+-.")
+	if digits != "" && digits[0] >= '0' && digits[0] <= '9' && !strings.Contains(text, "_") {
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	}
+
+	return text
+}
+
+// yamlFlow reads a flow sequence or mapping, like [a, b] or {a: 1}.
+type yamlFlow struct {
+	text string
+	pos  int
+}
+
+func (s *yamlFlow) skipSpaces() {
+	for s.pos < len(s.text) && s.text[s.pos] == ' ' {
+		s.pos++
+	}
+}
+
+func (s *yamlFlow) value() (interface{}, error) {
+	s.skipSpaces()
+	if s.pos >= len(s.text) {
+		return nil, fmt.Errorf("unterminated flow collection")
+	}
+
+	switch s.text[s.pos] {
+	case '[':
+		return s.sequence()
+	case '{':
+		return s.mapping()
+	case '"', '\'':
+		end := closingYAMLQuote(s.text[s.pos:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		value, err := yamlQuoted(s.text[s.pos : s.pos+end+1])
+		s.pos += end + 1
+		return value, err
+	default:
+		start := s.pos
+		for s.pos < len(s.text) && strings.IndexByte(",]}", s.text[s.pos]) < 0 &&
+			!strings.HasPrefix(s.text[s.pos:], ": ") {
+			s.pos++
+		}
+		return yamlPlain(strings.TrimSpace(s.text[start:s.pos])), nil
+	}
+}
+
+func (s *yamlFlow) sequence() ([]interface{}, error) {
+	s.pos++
+	values := []interface{}{}
+
+	for {
+		s.skipSpaces()
+		if s.pos < len(s.text) && s.text[s.pos] == ']' {
+			s.pos++
+			return values, nil
+		}
+
+		value, err := s.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		if err := s.separator(']'); err != nil {
+			return nil, err
+		}
+		if s.text[s.pos-1] == ']' {
+			return values, nil
+		}
+	}
+}
+
+func (s *yamlFlow) mapping() (map[string]interface{}, error) {
+	s.pos++
+	values := map[string]interface{}{}
+
+	for {
+		s.skipSpaces()
+		if s.pos < len(s.text) && s.text[s.pos] == '}' {
+			s.pos++
+			return values, nil
+		}
+
+		key, err := s.value()
+		if err != nil {
+			return nil, err
+		}
+
+		s.skipSpaces()
+		if s.pos >= len(s.text) || s.text[s.pos] != ':' {
+			return nil, fmt.Errorf("expected ':' after %v", key)
+		}
+		s.pos++
+
+		value, err := s.value()
+		if err != nil {
+			return nil, err
+		}
+		values[fmt.Sprintf("%v", key)] = value
+
+		if err := s.separator('}'); err != nil {
+			return nil, err
+		}
+		if s.text[s.pos-1] == '}' {
+			return values, nil
+		}
+	}
+}
+
+// separator reads the comma between entries, or the closing bracket.
+func (s *yamlFlow) separator(closing byte) error {
+	s.skipSpaces()
+	if s.pos >= len(s.text) || s.text[s.pos] != ',' && s.text[s.pos] != closing {
+		return fmt.Errorf("expected ',' or %q", closing)
+	}
+	s.pos++
+	return nil
+}
@@ -0,0 +1,218 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+const defaultStreamInterval = time.Second
+
+// ControlService manages a running cynic: its events, status and
+// mutes. It is what the admin endpoints are built on.
+type ControlService struct {
+	planner *Planner
+	status  *StatusCache
+	alerter *Alerter
+}
+
+// StatusUpdate is the status, as json, at some generation of the
+// status cache.
+type StatusUpdate struct {
+	JSON       []byte
+	Generation uint64
+}
+
+// ControlServiceNew creates a control service. The status cache and
+// alerter are optional; the calls that need them fail without them.
+func ControlServiceNew(planner *Planner, status *StatusCache, alerter *Alerter) *ControlService {
+	return &ControlService{
+		planner: planner,
+		status:  status,
+		alerter: alerter,
+	}
+}
+
+// AddEvent adds an event described like in config files.
+func (s *ControlService) AddEvent(config EventConfig) (EventState, error) {
+	if err := (&Config{Events: []EventConfig{config}}).validate(); err != nil {
+		return EventState{}, err
+	}
+
+	event, err := config.event()
+	if err != nil {
+		return EventState{}, err
+	}
+
+	if s.status != nil {
+		event.SetDataRepo(s.status)
+	}
+
+	s.planner.Add(&event)
+	return s.planner.stateOf(&event), nil
+}
+
+// DeleteEvent removes the event with the given id.
+func (s *ControlService) DeleteEvent(id uint64) error {
+	event, ok := s.planner.Find(id)
+	if !ok || !s.planner.Delete(event) {
+		return ErrEventNotFound
+	}
+	return nil
+}
+
+// DisableEvent skips the runs of the event with the given id, until
+// it is enabled again.
+func (s *ControlService) DisableEvent(id uint64) (EventState, error) {
+	event, ok := s.planner.Find(id)
+	if !ok {
+		return EventState{}, ErrEventNotFound
+	}
+
+	event.Disable()
+	return s.planner.stateOf(event), nil
+}
+
+// EnableEvent runs the event with the given id again, after it was
+// disabled.
+func (s *ControlService) EnableEvent(id uint64) (EventState, error) {
+	event, ok := s.planner.Find(id)
+	if !ok {
+		return EventState{}, ErrEventNotFound
+	}
+
+	event.Enable()
+	return s.planner.stateOf(event), nil
+}
+
+// RunEvent runs the event with the given id right away, on top of
+// its schedule.
+func (s *ControlService) RunEvent(id uint64) error {
+	event, ok := s.planner.Find(id)
+	if !ok {
+		return ErrEventNotFound
+	}
+
+	if !s.planner.beginRun(event) {
+		return ErrPlannerStopped
+	}
+
+	s.planner.recordDecision(JournalEntry{Kind: JournalFired, Reason: journalReasonRunNow}, event)
+	go func() {
+		defer s.planner.endRun(event)
+		event.Execute()
+	}()
+	return nil
+}
+
+// RunEventAndWait runs the event with the given id right away, on top
+// of its schedule, and returns what it did once it is done.
+func (s *ControlService) RunEventAndWait(id uint64) (ExecutionResult, error) {
+	return s.planner.RunNow(id)
+}
+
+// ListEvents returns the planned events.
+func (s *ControlService) ListEvents() []EventState {
+	return s.planner.State().Events
+}
+
+// GetStatus returns the status as json, or only the entry of the
+// given key.
+func (s *ControlService) GetStatus(key string) (StatusUpdate, error) {
+	if s.status == nil {
+		return StatusUpdate{}, ErrNoStatusCache
+	}
+
+	generation := s.status.Generation()
+	data, err := s.status.statusCacheToJSON(key)
+
+	// the json is shared with the status cache
+	data = append([]byte(nil), data...)
+
+	return StatusUpdate{JSON: data, Generation: generation}, err
+}
+
+// MuteAlert mutes the alerts matching the rule.
+func (s *ControlService) MuteAlert(rule MuteRule) (uint64, error) {
+	if s.alerter == nil {
+		return 0, ErrNoAlerter
+	}
+	return s.alerter.Mute(rule)
+}
+
+// TestAlert sends a test alert through the alerter and its sinks.
+func (s *ControlService) TestAlert(ctx context.Context, alert TestAlert) (AlertMessage, error) {
+	if s.alerter == nil {
+		return AlertMessage{}, ErrNoAlerter
+	}
+	return s.alerter.TestFire(ctx, alert)
+}
+
+// StreamStatus sends the status whenever it changes, checking every d,
+// until the context is done. The current status is sent first.
+func (s *ControlService) StreamStatus(ctx context.Context, key string, d time.Duration) (<-chan StatusUpdate, error) {
+	if s.status == nil {
+		return nil, ErrNoStatusCache
+	}
+
+	if d <= 0 {
+		d = defaultStreamInterval
+	}
+
+	updates := make(chan StatusUpdate)
+
+	go func() {
+		defer close(updates)
+
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+
+		// the json is compared, rather than the generation, as the
+		// extras change without it
+		var sent []byte
+		first := true
+
+		for {
+			update, err := s.GetStatus(key)
+			if err != nil {
+				return
+			}
+
+			if first || !bytes.Equal(update.JSON, sent) {
+				select {
+				case updates <- update:
+				case <-ctx.Done():
+					return
+				}
+
+				sent = update.JSON
+				first = false
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return updates, nil
+}
@@ -0,0 +1,223 @@
+/*
+Package cynictest has helpers to test hooks, alerting and events that
+use cynic.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynictest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+// pollInterval is how often conditions are checked while waiting.
+const pollInterval = 5 * time.Millisecond
+
+// stopTimeout is how long a planner waits for its runs in flight when
+// the test ends.
+const stopTimeout = 5 * time.Second
+
+// StatusServer starts a status server on a free port, for hooks to
+// store their results in. It is stopped when the test ends.
+func StatusServer(tb testing.TB, root string) *cynic.StatusCache {
+	tb.Helper()
+
+	server := cynic.StatusServerNew("127.0.0.1", "0", root)
+	go server.Start()
+	tb.Cleanup(server.Stop)
+
+	url := "http://127.0.0.1:" + strconv.Itoa(server.GetPort()) + "/links"
+	if !Eventually(2*time.Second, func() bool { return get(url) == nil }) {
+		tb.Fatal("status server never came up on port", server.GetPort())
+	}
+
+	return &server
+}
+
+// Planner returns a new planner, stopped when the test ends, which
+// fails the test if its runs are still going after a few seconds.
+func Planner(tb testing.TB) *cynic.Planner {
+	tb.Helper()
+
+	planner := cynic.PlannerNew()
+	tb.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		defer cancel()
+
+		if pending, err := planner.Stop(ctx); err != nil {
+			tb.Errorf("%d events still running once the test ended: %v", pending, err)
+		}
+	})
+
+	return planner
+}
+
+func get(url string) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// AlertRecorder is an alert sink that keeps the alerts it is given,
+// in memory.
+type AlertRecorder struct {
+	mux     sync.Mutex
+	batches [][]cynic.AlertMessage
+}
+
+// Func is the alert function to give to cynic.AlerterNew.
+func (s *AlertRecorder) Func() cynic.AlertFunc {
+	return func(alerts []cynic.AlertMessage) error {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		batch := make([]cynic.AlertMessage, len(alerts))
+		copy(batch, alerts)
+		s.batches = append(s.batches, batch)
+		return nil
+	}
+}
+
+// Alerts returns every alert recorded so far, in order.
+func (s *AlertRecorder) Alerts() []cynic.AlertMessage {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var alerts []cynic.AlertMessage
+	for _, batch := range s.batches {
+		alerts = append(alerts, batch...)
+	}
+	return alerts
+}
+
+// Batches returns how many times the sink was called.
+func (s *AlertRecorder) Batches() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return len(s.batches)
+}
+
+// WaitFor fails the test if fewer than n alerts are recorded within
+// the timeout, and returns the alerts recorded.
+func (s *AlertRecorder) WaitFor(tb testing.TB, n int, timeout time.Duration) []cynic.AlertMessage {
+	tb.Helper()
+
+	if !Eventually(timeout, func() bool { return len(s.Alerts()) >= n }) {
+		tb.Fatalf("expected %d alerts within %s, got %d", n, timeout, len(s.Alerts()))
+	}
+
+	return s.Alerts()
+}
+
+// JSONEndpoint starts an http server that answers every request with
+// the status code and the json of body, and returns its url. It is
+// closed when the test ends.
+func JSONEndpoint(tb testing.TB, status int, body interface{}) string {
+	tb.Helper()
+
+	return JSONHandlerEndpoint(tb, func(_ *http.Request) (int, interface{}) {
+		return status, body
+	})
+}
+
+// JSONHandlerEndpoint is like JSONEndpoint, with the status and body
+// picked for each request, for endpoints that change over time.
+func JSONHandlerEndpoint(tb testing.TB, handler func(*http.Request) (int, interface{})) string {
+	tb.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status, body := handler(req)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			tb.Error("could not encode json endpoint body: ", err)
+		}
+	}))
+	tb.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// FireCounter counts how many times the events it hooks into ran.
+type FireCounter struct {
+	count int64
+}
+
+// Hook is a hook that counts, and never alerts.
+func (s *FireCounter) Hook() cynic.HookSignature {
+	return func(_ *cynic.HookParameters) (bool, interface{}) {
+		atomic.AddInt64(&s.count, 1)
+		return false, nil
+	}
+}
+
+// Count returns how many times the hook ran.
+func (s *FireCounter) Count() int {
+	return int(atomic.LoadInt64(&s.count))
+}
+
+// AssertFired fails the test unless the hook ran at least n times
+// within the timeout.
+func (s *FireCounter) AssertFired(tb testing.TB, n int, within time.Duration) {
+	tb.Helper()
+
+	if !Eventually(within, func() bool { return s.Count() >= n }) {
+		tb.Fatalf("expected the event to fire %d times within %s, it fired %d times", n, within, s.Count())
+	}
+}
+
+// AssertFiredExactly fails the test unless the hook ran exactly n
+// times within the timeout, and no more after.
+func (s *FireCounter) AssertFiredExactly(tb testing.TB, n int, within time.Duration) {
+	tb.Helper()
+
+	s.AssertFired(tb, n, within)
+	if count := s.Count(); count != n {
+		tb.Fatalf("expected the event to fire %d times, it fired %d times", n, count)
+	}
+}
+
+// Eventually polls the condition until it holds, or the timeout
+// passes. It returns whether the condition held.
+func Eventually(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(pollInterval)
+	}
+	return cond()
+}
@@ -0,0 +1,148 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+const defaultDiscoveryRefresh = 30 * time.Second
+
+// DiscoveryTarget is an instance of a service, found by discovery.
+type DiscoveryTarget struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+
+	// Tags are what the source knows of the instance, like the
+	// metadata of a consul service, or the pod of an endpoint.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Addr returns host:port.
+func (s *DiscoveryTarget) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// DiscoverySource finds the instances of a service, like DNS SRV
+// records, the consul catalog, or kubernetes endpoints.
+type DiscoverySource interface {
+	Targets(ctx context.Context) ([]DiscoveryTarget, error)
+}
+
+// DiscoveryConfig keeps an event for every instance of a service in
+// the planner: events are added for new instances, and deleted for
+// those that went away.
+type DiscoveryConfig struct {
+	Source DiscoverySource
+
+	// Refresh is how often the source is asked. It defaults to 30
+	// seconds.
+	Refresh time.Duration
+
+	// Template makes the event of an instance.
+	Template func(target DiscoveryTarget) Event
+}
+
+// discoverer keeps the events of a discovery in step with its source.
+type discoverer struct {
+	config   DiscoveryConfig
+	planner  *Planner
+	clock    Clock
+	defaults *SessionDefaults
+
+	// events are the events of the targets, by address.
+	events map[string]*Event
+}
+
+func discovererNew(config DiscoveryConfig, planner *Planner, clock Clock, defaults *SessionDefaults) *discoverer {
+	if config.Refresh <= 0 {
+		config.Refresh = defaultDiscoveryRefresh
+	}
+
+	return &discoverer{
+		config:   config,
+		planner:  planner,
+		clock:    clock,
+		defaults: defaults,
+		events:   make(map[string]*Event),
+	}
+}
+
+// run refreshes the events right away, and then every refresh until
+// ctx is done. The events are left in the planner when it is.
+func (s *discoverer) run(ctx context.Context) {
+	ticker := s.clock.NewTicker(s.config.Refresh)
+	defer ticker.Stop()
+
+	s.refresh(ctx)
+	for {
+		select {
+		case <-ticker.C():
+			s.refresh(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh adds the events of new targets, and deletes those of
+// targets that went away. If the source fails, the events are kept as
+// they are.
+func (s *discoverer) refresh(ctx context.Context) {
+	targetsCtx, cancel := context.WithTimeout(ctx, s.config.Refresh)
+	targets, err := s.config.Source.Targets(targetsCtx)
+	cancel()
+
+	if err != nil {
+		log.Println("could not discover targets: ", err)
+		return
+	}
+
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		addr := target.Addr()
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+
+		if _, ok := s.events[addr]; ok {
+			continue
+		}
+
+		event := s.config.Template(target)
+		if s.defaults != nil {
+			s.defaults.apply(&event)
+		}
+		s.events[addr] = &event
+		s.planner.Add(&event)
+		log.Println("discovered target:", addr)
+	}
+
+	for addr, event := range s.events {
+		if !seen[addr] {
+			s.planner.Delete(event)
+			delete(s.events, addr)
+			log.Println("target went away:", addr)
+		}
+	}
+}
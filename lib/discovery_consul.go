@@ -0,0 +1,139 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultConsulAddr = "http://127.0.0.1:8500"
+
+// ConsulSource finds the instances of a service in the consul
+// catalog, through its health api.
+type ConsulSource struct {
+	// Addr is the url of the consul agent. It defaults to
+	// http://127.0.0.1:8500.
+	Addr    string `json:"addr"`
+	Service string `json:"service"`
+
+	// Tag, if set, only finds the instances with this tag.
+	Tag   string `json:"tag"`
+	Token string `json:"token"`
+
+	// Passing only finds the instances passing their health checks.
+	Passing bool `json:"passing"`
+
+	// Client, if set, is used instead of the default client.
+	Client *http.Client `json:"-"`
+}
+
+// consulServiceEntry is the part of an entry of the consul health api
+// that is read.
+type consulServiceEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string            `json:"ID"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Meta    map[string]string `json:"Meta"`
+	} `json:"Service"`
+}
+
+// Targets lists the instances of the service. Their tags are the
+// metadata of the service, with its node and id.
+func (s *ConsulSource) Targets(ctx context.Context) ([]DiscoveryTarget, error) {
+	addr := s.Addr
+	if addr == "" {
+		addr = defaultConsulAddr
+	}
+
+	query := url.Values{}
+	if s.Tag != "" {
+		query.Set("tag", s.Tag)
+	}
+	if s.Passing {
+		query.Set("passing", "true")
+	}
+
+	endpoint := strings.TrimSuffix(addr, "/") + "/v1/health/service/" + url.PathEscape(s.Service)
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Token != "" {
+		req.Header.Set("X-Consul-Token", s.Token)
+	}
+
+	var entries []consulServiceEntry
+	if err := discoveryGetJSON(s.Client, req, &entries); err != nil {
+		return nil, err
+	}
+
+	targets := make([]DiscoveryTarget, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+
+		tags := make(map[string]string, len(entry.Service.Meta)+2)
+		for key, value := range entry.Service.Meta {
+			tags[key] = value
+		}
+		tags["node"] = entry.Node.Node
+		tags["id"] = entry.Service.ID
+
+		targets = append(targets, DiscoveryTarget{Host: host, Port: entry.Service.Port, Tags: tags})
+	}
+
+	return targets, nil
+}
+
+// discoveryGetJSON makes the request, and decodes its json response.
+func discoveryGetJSON(client *http.Client, req *http.Request, value interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: %s: %s", ErrDiscoveryStatus, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(value)
+}
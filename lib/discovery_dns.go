@@ -0,0 +1,57 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// SRVSource finds the instances of a service in DNS SRV records.
+type SRVSource struct {
+	// Name is the full name of the records, like
+	// _http._tcp.api.example.com.
+	Name string
+
+	// Resolver, if set, is used instead of the default resolver.
+	Resolver *net.Resolver
+}
+
+// Targets looks the records up.
+func (s *SRVSource) Targets(ctx context.Context) ([]DiscoveryTarget, error) {
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, "", "", s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]DiscoveryTarget, 0, len(records))
+	for _, record := range records {
+		targets = append(targets, DiscoveryTarget{
+			Host: strings.TrimSuffix(record.Target, "."),
+			Port: int(record.Port),
+		})
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,164 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// kubernetesServiceAccountDir is where pods find the credentials of
+// their service account.
+const kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// KubernetesSource finds the instances of a service in its kubernetes
+// endpoints.
+type KubernetesSource struct {
+	// API is the url of the kubernetes api server.
+	API       string
+	Namespace string
+	Service   string
+
+	// Port is the name of the port of the endpoints to use. It
+	// defaults to their first port.
+	Port string
+
+	// TokenFile, if set, is read for a bearer token on every
+	// request, as tokens are rotated.
+	TokenFile string
+
+	// Client, if set, is used instead of the default client, like
+	// one trusting the certificate of the api server.
+	Client *http.Client
+}
+
+// KubernetesInClusterSourceNew returns a source for a service, running
+// within the cluster, with the service account of the pod. An empty
+// namespace defaults to the one of the pod.
+func KubernetesInClusterSourceNew(namespace, service, port string) (*KubernetesSource, error) {
+	host, apiPort := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || apiPort == "" {
+		return nil, fmt.Errorf("%w: not running in a kubernetes cluster", ErrDiscoverySource)
+	}
+
+	if namespace == "" {
+		data, err := ioutil.ReadFile(kubernetesServiceAccountDir + "namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	ca, err := ioutil.ReadFile(kubernetesServiceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("%w: bad kubernetes ca certificate", ErrDiscoverySource)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	return &KubernetesSource{
+		API:       "https://" + net.JoinHostPort(host, apiPort),
+		Namespace: namespace,
+		Service:   service,
+		Port:      port,
+		TokenFile: kubernetesServiceAccountDir + "token",
+		Client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// kubernetesEndpoints is the part of kubernetes endpoints that is
+// read.
+type kubernetesEndpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP        string `json:"ip"`
+			Hostname  string `json:"hostname"`
+			TargetRef *struct {
+				Kind string `json:"kind"`
+				Name string `json:"name"`
+			} `json:"targetRef"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+// Targets lists the ready addresses of the endpoints of the service.
+// Their tags have the pod they belong to.
+func (s *KubernetesSource) Targets(ctx context.Context) ([]DiscoveryTarget, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/namespaces/%s/endpoints/%s",
+		strings.TrimSuffix(s.API, "/"), url.PathEscape(s.Namespace), url.PathEscape(s.Service))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.TokenFile != "" {
+		token, err := ioutil.ReadFile(s.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	var endpoints kubernetesEndpoints
+	if err := discoveryGetJSON(s.Client, req, &endpoints); err != nil {
+		return nil, err
+	}
+
+	var targets []DiscoveryTarget
+	for _, subset := range endpoints.Subsets {
+		port := 0
+		for _, candidate := range subset.Ports {
+			if s.Port == "" || candidate.Name == s.Port {
+				port = candidate.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+
+		for _, address := range subset.Addresses {
+			target := DiscoveryTarget{Host: address.IP, Port: port}
+			if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+				target.Tags = map[string]string{"pod": address.TargetRef.Name}
+			}
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
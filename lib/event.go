@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"sync/atomic"
 	"time"
 )
@@ -35,6 +36,12 @@ func currentHost() string {
 	return ret
 }
 
+// instanceName tells this instance apart from others on the same
+// host, as a leader or a cluster member.
+func instanceName() string {
+	return fmt.Sprintf("%s:%d", currentHost(), os.Getpid())
+}
+
 // HookParameters is any state that should be passed to the hook.
 type HookParameters struct {
 	// Planner is access to the planner that the hook executes
@@ -49,9 +56,63 @@ type HookParameters struct {
 	// Extra is meant to be used by the user for any extra state
 	// that needs to be passed to the hooks.
 	Extra interface{}
+
+	// Timeout is how long the hook should take at most, if it is
+	// not zero.
+	Timeout time.Duration
+
+	// Headers are extra headers for hooks making requests.
+	Headers map[string]string
+
+	// Location is where the instance probes from, if it was given
+	// one, for hooks to tag their results with.
+	Location string
+
+	// HostLimiter, if set, is to be acquired by hooks making
+	// requests, before making them.
+	HostLimiter *HostLimiter
+
+	// Tags are the tags of the event, for hooks to tag their results
+	// with. They must not be changed.
+	Tags map[string]string
+
+	// Transports, if set, are to be used by hooks making requests,
+	// for their connections to be reused.
+	Transports *TransportPool
+
+	// Previous is the result of the hook that ran before this one,
+	// and PreviousFailed whether it failed. They are zero for the
+	// first hook of the event.
+	Previous       interface{}
+	PreviousFailed bool
+
+	// stopped is set by StopChain, and inconclusive by Inconclusive.
+	stopped      bool
+	inconclusive bool
+}
+
+// StopChain stops the hooks of the event after the one running: the
+// hooks after it are skipped for this run. Whether the run failed is
+// still up to the hooks that ran.
+func (s *HookParameters) StopChain() {
+	s.stopped = true
+}
+
+// Inconclusive tells that the hook checked nothing on this run, like a
+// probe throttled by the host limits. Unless a hook failed, the run
+// then counts for nothing: it resolves no alert, and is not given to
+// the metrics or the result sinks, like the SLO and incident trackers.
+func (s *HookParameters) Inconclusive() {
+	s.inconclusive = true
 }
 
 // HookSignature specifies what the event hooks should look like.
+//
+// The hooks of an event run in order, as a chain. Each returns whether
+// it failed, which fails the run of the event, and its result, which
+// the next hook is given as Previous, and which is sent along with
+// the alert of a failure. A hook can stop the chain with StopChain,
+// and the event can stop it on the first failure with StopOnFailure.
 type HookSignature = func(*HookParameters) (bool, interface{})
 
 // Event is some event that should be executed in a specified
@@ -59,6 +120,7 @@ type HookSignature = func(*HookParameters) (bool, interface{})
 // - A event is an action
 // - A event can have many:
 //   - hooks (that can act as contracts)
+//
 // - A event may be bound to a data repository/cache.
 type Event struct {
 	id        uint64
@@ -67,18 +129,67 @@ type Event struct {
 	immediate bool
 	offset    int
 	repeat    bool
+	severity  Severity
 	Label     string
+	Group     string
 	planner   *Planner
 
-	repo *StatusCache
+	// tags are replaced rather than changed, so that copies of the
+	// event don't share changes.
+	tags map[string]string
+
+	repo    *StatusCache
+	alerter *Alerter
+
+	timeout time.Duration
+	jitter  int
+	headers map[string]string
+
+	// overrides records which settings were set on the event, so
+	// session defaults leave them be.
+	overrides eventSetting
 
 	index    int
 	priority int
-	deleted  bool
+
+	// where the event is, in a timing wheel
+	wheelLevel int
+	wheelSlot  int
+
+	deleted bool
+
+	// disabled is set while the event is disabled. It is toggled
+	// through the admin interface while the planner runs it.
+	disabled int32
+
+	// windows, if set, are when the event may run.
+	windows *executionWindows
+
+	// overlap, if set, has the event run in the background, and
+	// decides what happens to its runs due while it still runs.
+	overlap *eventOverlap
+
+	// insecureTLS is set when its probes don't verify certificates.
+	insecureTLS bool
+
+	// runState is how the last run went, one of the event states.
+	// It is read by composite events, while the event may be running.
+	runState int32
 
 	extra interface{}
+
+	stopOnFailure bool
+	asyncHooks    []asyncHook
 }
 
+// The states of an event, after its last run. They are ordered, so
+// that the worst of several events is the greatest.
+const (
+	eventStateUnknown int32 = iota
+	eventStateOK
+	eventStateFailed
+)
+
 var lastID uint64
 
 // EventNew creates a new event that is primarily used for pure
@@ -99,11 +210,13 @@ func EventNew(secs int) Event {
 		immediate: false,
 		offset:    0,
 		repeat:    false,
+		severity:  SeverityWarning,
 		id:        id,
 		priority:  priority,
 		deleted:   false,
 
 		Label:   "",
+		Group:   "",
 		planner: nil,
 		repo:    nil,
 		index:   0,
@@ -126,6 +239,12 @@ func (s *Event) Immediate(val bool) {
 	s.immediate = val
 }
 
+// StopOnFailure makes the event skip its hooks after the first one
+// that fails, like the checks depending on a probe that failed.
+func (s *Event) StopOnFailure(val bool) {
+	s.stopOnFailure = val
+}
+
 // IsImmediate returns true if event is immediate.
 func (s *Event) IsImmediate() bool {
 	return s.immediate
@@ -144,6 +263,7 @@ func (s *Event) GetOffset() int {
 // Repeat makes the event repeatable.
 func (s *Event) Repeat(rep bool) {
 	s.repeat = rep
+	s.overrides |= settingRepeat
 }
 
 // IsRepeating says whether a event repeats or not.
@@ -151,6 +271,16 @@ func (s *Event) IsRepeating() bool {
 	return s.repeat
 }
 
+// SetSeverity sets how urgent the alerts of the event are.
+func (s *Event) SetSeverity(severity Severity) {
+	s.severity = severity
+}
+
+// GetSeverity returns how urgent the alerts of the event are.
+func (s *Event) GetSeverity() Severity {
+	return s.severity
+}
+
 // ID returns the unique identifier of the event.
 func (s *Event) ID() uint64 {
 	return s.id
@@ -183,19 +313,224 @@ func (s *Event) UniqStr() string {
 // SetDataRepo sets where the data processed should be stored in.
 func (s *Event) SetDataRepo(repo *StatusCache) {
 	s.repo = repo
+	s.overrides |= settingRepo
 }
 
-// Execute the event.
-func (s *Event) Execute() {
-	for _, hook := range s.hooks {
-		ok, result := hook(&HookParameters{
-			s.planner,
-			s.repo,
-			s.extra,
-		})
+// SetAlerter sets the alerter the alerts of this event go to, instead
+// of the alerter of its planner. The alerter needs to be started by
+// the caller.
+func (s *Event) SetAlerter(alerter *Alerter) {
+	s.alerter = alerter
+	s.overrides |= settingAlerter
+}
+
+// SetTimeout sets how long each hook of the event may take. Zero
+// leaves it to the hooks.
+func (s *Event) SetTimeout(timeout time.Duration) {
+	s.timeout = timeout
+	s.overrides |= settingTimeout
+}
+
+// GetTimeout returns the timeout given to the hooks.
+func (s *Event) GetTimeout() time.Duration {
+	return s.timeout
+}
+
+// SetJitter sets the most seconds added at random to every run of the
+// event, so that events with the same interval do not all run on the
+// same tick.
+func (s *Event) SetJitter(secs int) {
+	s.jitter = secs
+	s.overrides |= settingJitter
+}
+
+// GetJitter returns the jitter of the event, in seconds.
+func (s *Event) GetJitter() int {
+	return s.jitter
+}
+
+// SetHeader sets a header given to the hooks of the event.
+func (s *Event) SetHeader(key, value string) {
+	if s.headers == nil {
+		s.headers = make(map[string]string)
+	}
+	s.headers[key] = value
+}
+
+// ExecutionResult is what a run of an event did.
+type ExecutionResult struct {
+	// Hooks are the results of the hooks, in order.
+	Hooks []HookResult `json:"hooks"`
+
+	// Failures is how many hooks reported a failure, or panicked.
+	Failures int `json:"failures"`
+
+	// Alerted is how many failures were sent to the alerter.
+	Alerted int `json:"alerted"`
+
+	Duration time.Duration `json:"duration_ns"`
+
+	// Err is set if a hook panicked. The other hooks still run,
+	// unless the event stops on failures.
+	Err error `json:"-"`
+
+	// Skipped is how many hooks did not run, as the chain was
+	// stopped.
+	Skipped int `json:"skipped,omitempty"`
+
+	// Inconclusive is set when a hook checked nothing, and none
+	// failed. See HookParameters.Inconclusive.
+	Inconclusive bool `json:"inconclusive,omitempty"`
+
+	// AsyncStarted is how many async hooks were started, and
+	// AsyncBusy how many were not, as they had too many runs in
+	// flight, or the planner was at its async limit.
+	AsyncStarted int `json:"async_started,omitempty"`
+	AsyncBusy    int `json:"async_busy,omitempty"`
+}
+
+// Failed returns whether any hook reported a failure, or panicked.
+func (s *ExecutionResult) Failed() bool {
+	return s.Failures > 0
+}
+
+// Execute runs the hooks of the event, and returns what they did.
+func (s *Event) Execute() ExecutionResult {
+	return s.execute(true)
+}
+
+// run executes the event for the planner, which has no use for the
+// results of the hooks, so they are only kept for result sinks.
+func (s *Event) run() {
+	s.execute(s.planner != nil && len(s.planner.sinks) > 0)
+}
+
+func (s *Event) execute(keepHooks bool) ExecutionResult {
+	start := s.planner.now()
+
+	var execution ExecutionResult
+	if keepHooks {
+		execution.Hooks = make([]HookResult, 0, len(s.hooks))
+	}
+
+	params := HookParameters{
+		Planner:     s.planner,
+		Status:      s.repo,
+		Extra:       s.extra,
+		Timeout:     s.timeout,
+		Headers:     s.headers,
+		Location:    s.location(),
+		HostLimiter: s.hostLimiter(),
+		Tags:        s.tags,
+		Transports:  s.transportPool(),
+	}
+
+	for i, hook := range s.hooks {
+		hookParams := params
+		ok, result, err := runHook(hook, &hookParams)
+		if err != nil && execution.Err == nil {
+			execution.Err = err
+		}
+
+		if ok {
+			execution.Failures++
+		}
+		if hookParams.inconclusive {
+			execution.Inconclusive = true
+		}
+		if keepHooks {
+			execution.Hooks = append(execution.Hooks, HookResult{Failed: ok, Result: result})
+		}
+		if s.maybeAlert(ok, result) {
+			execution.Alerted++
+		}
+
+		if hookParams.stopped || (ok && s.stopOnFailure) {
+			execution.Skipped = len(s.hooks) - i - 1 + len(s.asyncHooks)
+			break
+		}
+		params.Previous, params.PreviousFailed = result, ok
+	}
 
-		s.maybeAlert(ok, result)
+	if execution.Skipped == 0 {
+		for i := range s.asyncHooks {
+			if s.startAsync(&s.asyncHooks[i], params) {
+				execution.AsyncStarted++
+			} else {
+				execution.AsyncBusy++
+			}
+		}
 	}
+
+	execution.Duration = s.planner.now().Sub(start)
+	if execution.Failed() {
+		execution.Inconclusive = false
+	}
+
+	if s.planner != nil {
+		s.planner.recordExecution(execution.Failures)
+	}
+
+	// an inconclusive run leaves the event as it was
+	if execution.Inconclusive {
+		return execution
+	}
+
+	state := eventStateOK
+	if execution.Failed() {
+		state = eventStateFailed
+	}
+	atomic.StoreInt32(&s.runState, state)
+
+	if s.planner != nil {
+		if metrics := s.planner.metrics; metrics != nil {
+			metrics.Emit(EventSample{
+				EventID: s.id,
+				Label:   s.Label,
+				Group:   s.Group,
+				Tags:    s.tags,
+				Failed:  execution.Failed(),
+				Latency: execution.Duration,
+				At:      start,
+			})
+		}
+
+		for _, sink := range s.planner.sinks {
+			sink.Record(EventResult{
+				EventID:  s.id,
+				Label:    s.Label,
+				Group:    s.Group,
+				Tags:     s.tags,
+				Severity: s.severity,
+				Failed:   execution.Failed(),
+				Location: s.planner.location,
+				Latency:  execution.Duration,
+				At:       start,
+				Hooks:    execution.Hooks,
+			})
+		}
+	}
+
+	if alerter := s.currentAlerter(); alerter != nil {
+		alerter.observe(s.id, execution.Failed())
+	}
+
+	return execution
+}
+
+// runHook runs a hook, and turns a panic of it into an error, with the
+// hook reporting a failure.
+func runHook(hook HookSignature, params *HookParameters) (failed bool, result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			failed = true
+			err = fmt.Errorf("%w: %v", ErrHookPanicked, recovered)
+			result = err.Error()
+		}
+	}()
+
+	failed, result = hook(params)
+	return failed, result, nil
 }
 
 // SetAbsExpiry sets the timestamp that the event is supposed to
@@ -232,25 +567,180 @@ func (s *Event) IsDeleted() bool {
 	return s.deleted
 }
 
+// Disable keeps the event in its planner, and on its schedule, but
+// skips its runs until it is enabled again. Unlike deleting and adding
+// it again, the event keeps its id and history.
+func (s *Event) Disable() {
+	atomic.StoreInt32(&s.disabled, 1)
+}
+
+// Enable makes a disabled event run again.
+func (s *Event) Enable() {
+	atomic.StoreInt32(&s.disabled, 0)
+}
+
+// IsDisabled returns whether the event is disabled.
+func (s *Event) IsDisabled() bool {
+	return atomic.LoadInt32(&s.disabled) == 1
+}
+
 // SetExtra state you may want passed to hooks.
 func (s *Event) SetExtra(extra interface{}) {
 	s.extra = extra
 }
 
+// SetTag tags the event, like "team" with "payments", or "env" with
+// "prod". Tags go along with the results, alerts and metrics of the
+// event, and mute rules and repo routes can match them. An empty value
+// removes the tag.
+func (s *Event) SetTag(key, value string) {
+	tags := make(map[string]string, len(s.tags)+1)
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+
+	if value == "" {
+		delete(tags, key)
+	} else {
+		tags[key] = value
+	}
+
+	if len(tags) == 0 {
+		tags = nil
+	}
+	s.tags = tags
+}
+
+// Tag returns the value of a tag of the event, or "" if it has none.
+func (s *Event) Tag(key string) string {
+	return s.tags[key]
+}
+
+// Tags returns a copy of the tags of the event.
+func (s *Event) Tags() map[string]string {
+	if len(s.tags) == 0 {
+		return nil
+	}
+
+	tags := make(map[string]string, len(s.tags))
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// tagKeys returns the keys of tags, sorted.
+func tagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// tagsMatch returns whether tags has all of the wanted tags.
+func tagsMatch(wanted, tags map[string]string) bool {
+	for key, value := range wanted {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Event) state() EventState {
+	state := EventState{
+		ID:       s.id,
+		Label:    s.Label,
+		Group:    s.Group,
+		Secs:     s.secs,
+		Repeat:   s.repeat,
+		Severity: s.severity,
+		Hooks:    len(s.hooks),
+		NextTick: int64(s.priority),
+		Tags:     s.tags,
+		Disabled: s.IsDisabled(),
+
+		InsecureTLS: s.insecureTLS,
+	}
+
+	if s.overlap != nil {
+		s.overlap.fill(&state)
+	}
+	return state
+}
+
 func (s *Event) setPlanner(planner *Planner) {
 	s.planner = planner
 }
 
-func (s *Event) maybeAlert(shouldAlert bool, result interface{}) {
-	if !shouldAlert || s.planner == nil || s.planner.alerter == nil {
-		return
+// currentAlerter is the alerter of the event if it has one, or the
+// alerter of its planner.
+func (s *Event) currentAlerter() *Alerter {
+	if s.alerter != nil {
+		return s.alerter
+	}
+
+	if s.planner != nil {
+		return s.planner.alerter
+	}
+
+	return nil
+}
+
+// location is the location of the planner of the event, if any.
+func (s *Event) location() string {
+	if s.planner == nil {
+		return ""
+	}
+	return s.planner.location
+}
+
+// hostLimiter is the host limiter of the planner of the event, if any.
+func (s *Event) hostLimiter() *HostLimiter {
+	if s.planner == nil {
+		return nil
 	}
+	return s.planner.hostLimiter
+}
+
+// transportPool is the transport pool of the planner of the event, if
+// any.
+func (s *Event) transportPool() *TransportPool {
+	if s.planner == nil {
+		return nil
+	}
+	return s.planner.transports
+}
 
-	alerter := s.planner.alerter
+// lastState returns how the last run of the event went.
+func (s *Event) lastState() int32 {
+	return atomic.LoadInt32(&s.runState)
+}
+
+// maybeAlert sends an alert if the hook failed, and returns whether it
+// did.
+func (s *Event) maybeAlert(shouldAlert bool, result interface{}) bool {
+	alerter := s.currentAlerter()
+	if !shouldAlert || alerter == nil {
+		return false
+	}
 
 	alerter.Ch <- AlertMessage{
 		Response:      result,
-		Now:           time.Now().Format(time.RFC3339),
+		Now:           alerter.clock.Now().Format(time.RFC3339),
 		CynicHostname: currentHost(),
+		Location:      s.location(),
+		Label:         s.Label,
+		Group:         s.Group,
+		Tags:          s.tags,
+		Severity:      s.severity,
+		EventID:       s.id,
+		Fingerprint:   alertFingerprint(result),
+		RootCause:     AlertRootCause(result),
+		Endpoint:      AlertEndpoint(result),
 	}
+
+	return true
 }
@@ -0,0 +1,157 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const defaultAsyncHookTimeout = time.Minute
+
+// AsyncHookSignature is a hook starting long work, like paging
+// through an api, without holding up the other events. It returns a
+// channel the result of the work is sent on, once, and should give up
+// once ctx is done.
+type AsyncHookSignature = func(ctx context.Context, params *HookParameters) <-chan HookResult
+
+// AsyncHookConfig bounds an async hook.
+type AsyncHookConfig struct {
+	// Timeout is how long the work may take, after which it fails.
+	// It defaults to a minute.
+	Timeout time.Duration
+
+	// MaxInFlight is how many runs of the hook may be working at
+	// once. Runs of the event over it don't start the hook. It
+	// defaults to one.
+	MaxInFlight int
+
+	// OnComplete, if set, is called with the result of every run of
+	// the hook, once it completes.
+	OnComplete func(result HookResult)
+}
+
+type asyncHook struct {
+	hook   AsyncHookSignature
+	config AsyncHookConfig
+
+	// inFlight is shared by the copies of the event.
+	inFlight *int32
+}
+
+// AddAsyncHook adds a hook started once the hooks of the event ran,
+// unless their chain was stopped. Its result is recorded when it
+// completes: a failure is alerted on, and the result is given to the
+// result sinks of the planner, marked async.
+func (s *Event) AddAsyncHook(hook AsyncHookSignature, config AsyncHookConfig) {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultAsyncHookTimeout
+	}
+	if config.MaxInFlight <= 0 {
+		config.MaxInFlight = 1
+	}
+
+	s.asyncHooks = append(s.asyncHooks, asyncHook{hook: hook, config: config, inFlight: new(int32)})
+}
+
+// startAsync starts an async hook in the background, unless it has
+// too many runs in flight, or the planner is at its async limit.
+func (s *Event) startAsync(hook *asyncHook, params HookParameters) bool {
+	if atomic.AddInt32(hook.inFlight, 1) > int32(hook.config.MaxInFlight) {
+		atomic.AddInt32(hook.inFlight, -1)
+		return false
+	}
+
+	release, ok := s.planner.acquireAsync()
+	if !ok {
+		atomic.AddInt32(hook.inFlight, -1)
+		return false
+	}
+
+	if !s.planner.beginRun(s) {
+		release()
+		atomic.AddInt32(hook.inFlight, -1)
+		return false
+	}
+
+	start := time.Now()
+	go func() {
+		defer atomic.AddInt32(hook.inFlight, -1)
+		defer release()
+		defer s.planner.endRun(s)
+
+		result := awaitAsyncHook(hook, &params)
+		s.completeAsync(hook, result, start)
+	}()
+
+	return true
+}
+
+// awaitAsyncHook runs an async hook, and waits for its result until
+// its timeout.
+func awaitAsyncHook(hook *asyncHook, params *HookParameters) (result HookResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.config.Timeout)
+	defer cancel()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = HookResult{Failed: true, Result: fmt.Errorf("%w: %v", ErrHookPanicked, recovered).Error()}
+		}
+	}()
+
+	select {
+	case result, ok := <-hook.hook(ctx, params):
+		if !ok {
+			return HookResult{Failed: true, Result: ErrAsyncHookNoResult.Error()}
+		}
+		return result
+	case <-ctx.Done():
+		return HookResult{Failed: true, Result: fmt.Sprintf("%v after %s", ErrAsyncHookTimeout, hook.config.Timeout)}
+	}
+}
+
+// completeAsync records the result of an async hook.
+func (s *Event) completeAsync(hook *asyncHook, result HookResult, start time.Time) {
+	s.maybeAlert(result.Failed, result.Result)
+
+	if hook.config.OnComplete != nil {
+		hook.config.OnComplete(result)
+	}
+
+	if s.planner == nil {
+		return
+	}
+
+	for _, sink := range s.planner.sinks {
+		sink.Record(EventResult{
+			EventID:  s.id,
+			Label:    s.Label,
+			Group:    s.Group,
+			Tags:     s.tags,
+			Severity: s.severity,
+			Failed:   result.Failed,
+			Location: s.planner.location,
+			Latency:  time.Since(start),
+			At:       start,
+			Hooks:    []HookResult{result},
+			Async:    true,
+		})
+	}
+}
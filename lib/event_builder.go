@@ -17,40 +17,132 @@ limitations under the License.
 */
 package cynic
 
+import (
+	"hash/fnv"
+	"strconv"
+)
+
 type distributionParams struct {
 	maxTime int
 }
 
+// StaggerMode is how the builder staggers the first runs of events,
+// keeping their intervals.
+type StaggerMode int
+
+const (
+	// StaggerRoundRobin offsets the events one after the other,
+	// evenly over their interval.
+	StaggerRoundRobin StaggerMode = iota
+
+	// StaggerHashed offsets each event by a hash of its group and
+	// label, so that its offset stays the same across restarts, and
+	// as other events come and go.
+	StaggerHashed
+)
+
 // EventBuilder is a helper to set properties to a lot of
 // events. For example, if you have 10 events you want to run
 // within 100 seconds, you can use this builder in oder to disperse
 // everything over 10 seconds.
+//
+// The setters return the builder, so they can be chained:
+//
+//	session, ok := cynic.EventBuilderNew(events).
+//		WithInterval(60).
+//		Repeat(true).
+//		WithRepo(&statusCache).
+//		DistributeOver(60).
+//		Build()
 type EventBuilder struct {
 	events []Event
 
-	evenDistribute bool
-	allRepeatable  bool
-
 	distribution *distributionParams
+	stagger      *StaggerMode
 }
 
 // EventBuilderNew creates a new events builder. Simple
 // configurations. If you want something more complex, you should do
 // it on your own.
-func EventBuilderNew(events []Event) EventBuilder {
-	return EventBuilder{
-		events:         events,
-		evenDistribute: false,
-		allRepeatable:  false,
-		distribution:   nil,
+func EventBuilderNew(events []Event) *EventBuilder {
+	return &EventBuilder{
+		events:       events,
+		distribution: nil,
+		stagger:      nil,
+	}
+}
+
+// Add adds more events to the builder.
+func (s *EventBuilder) Add(events ...Event) *EventBuilder {
+	s.events = append(s.events, events...)
+	return s
+}
+
+// WithInterval sets every how many seconds all events run.
+func (s *EventBuilder) WithInterval(secs int) *EventBuilder {
+	for i := range s.events {
+		s.events[i].SetSecs(secs)
+	}
+	return s
+}
+
+// WithOffset sets the offset of all events, in seconds.
+func (s *EventBuilder) WithOffset(secs int) *EventBuilder {
+	for i := range s.events {
+		s.events[i].SetOffset(secs)
+	}
+	return s
+}
+
+// Repeat sets if all events repeat.
+func (s *EventBuilder) Repeat(rep bool) *EventBuilder {
+	for i := range s.events {
+		s.events[i].Repeat(rep)
+	}
+	return s
+}
+
+// WithHook adds a hook to all events.
+func (s *EventBuilder) WithHook(fn HookSignature) *EventBuilder {
+	for i := range s.events {
+		s.events[i].AddHook(fn)
+	}
+	return s
+}
+
+// WithRepo sets where all events store their results.
+func (s *EventBuilder) WithRepo(repo *StatusCache) *EventBuilder {
+	for i := range s.events {
+		s.events[i].SetDataRepo(repo)
+	}
+	return s
+}
+
+// DistributeOver spreads the events over a max time interval, in
+// seconds, when building. Every event runs every maxTime/n seconds.
+func (s *EventBuilder) DistributeOver(maxTime int) *EventBuilder {
+	s.distribution = &distributionParams{
+		maxTime: maxTime,
 	}
+	s.stagger = nil
+	return s
+}
+
+// Stagger spreads the first runs of the events over their intervals,
+// when building. Unlike DistributeOver, the intervals are kept.
+func (s *EventBuilder) Stagger(mode StaggerMode) *EventBuilder {
+	s.stagger = &mode
+	s.distribution = nil
+	return s
 }
 
 // Build takes all the things you gave the builder, puts them
 // together, and gives you a session object to do whatever you
-// will with it.
+// will with it. It is not ok if there are no events, or if they
+// could not be distributed as asked.
 func (s *EventBuilder) Build() (Session, bool) {
-	ret := s.makeRepeatable() && s.makeDistributeEvents()
+	ret := len(s.events) > 0 && s.makeDistributeEvents()
+	s.makeStaggerEvents()
 
 	sess := Session{
 		Events:  s.events,
@@ -60,16 +152,22 @@ func (s *EventBuilder) Build() (Session, bool) {
 	return sess, ret
 }
 
+// Events returns the events of the builder.
+func (s *EventBuilder) Events() []Event {
+	return s.events
+}
+
 // DistributeEvents over a max time interval.
 func (s *EventBuilder) DistributeEvents(maxTime int) {
-	s.distribution = &distributionParams{
-		maxTime: maxTime,
-	}
+	s.DistributeOver(maxTime)
 }
 
 func (s *EventBuilder) makeDistributeEvents() bool {
-	if s.distribution == nil ||
-		s.distribution.maxTime <= 0 ||
+	if s.distribution == nil {
+		return true
+	}
+
+	if s.distribution.maxTime <= 0 ||
 
 		// min granularity is a sec, so 11 events in 10 secs
 		// do not guarantee some sort of distribution
@@ -88,14 +186,42 @@ func (s *EventBuilder) makeDistributeEvents() bool {
 	return true
 }
 
-// Repeatable will mark all events as repeatable.
-func (s *EventBuilder) Repeatable() {
-	s.allRepeatable = true
+func (s *EventBuilder) makeStaggerEvents() {
+	if s.stagger == nil {
+		return
+	}
+
+	eventCount := len(s.events)
+	for i := 0; i < eventCount; i++ {
+		event := &s.events[i]
+
+		switch *s.stagger {
+		case StaggerRoundRobin:
+			event.SetOffset(i * event.GetSecs() / eventCount)
+		case StaggerHashed:
+			event.SetOffset(hashedOffset(event))
+		}
+	}
+}
+
+// hashedOffset is the offset of an event with StaggerHashed.
+func hashedOffset(event *Event) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(eventKey(event)))
+	return int(hash.Sum32() % uint32(event.GetSecs()))
 }
 
-func (s *EventBuilder) makeRepeatable() bool {
-	for _, el := range s.events {
-		el.Repeat(true)
+// eventKey identifies an event by its group and label, which are the
+// same across instances loading the same config, unlike ids. Events
+// without a label fall back to their id.
+func eventKey(event *Event) string {
+	if event.Label == "" {
+		return strconv.FormatUint(event.ID(), 10)
 	}
-	return true
+	return event.Group + "/" + event.Label
+}
+
+// Repeatable will mark all events as repeatable.
+func (s *EventBuilder) Repeatable() {
+	s.Repeat(true)
 }
@@ -0,0 +1,32 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import "fmt"
+
+var (
+	ErrHookPanicked        = fmt.Errorf("hook panicked")
+	ErrCompositeExpression = fmt.Errorf("bad composite expression")
+	ErrHostCheck           = fmt.Errorf("could not check the host")
+	ErrNTPResponse         = fmt.Errorf("bad ntp response")
+	ErrAsyncHookTimeout    = fmt.Errorf("async hook timed out")
+	ErrAsyncHookNoResult   = fmt.Errorf("async hook gave no result")
+	ErrBadWindow           = fmt.Errorf("bad execution window")
+	ErrBadOverlap          = fmt.Errorf("bad overlap policy")
+	ErrPlannerStopped      = fmt.Errorf("the planner is stopped")
+)
@@ -0,0 +1,162 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"sync"
+)
+
+// OverlapPolicy is what the planner does with a run of an event that
+// is due while its last run is still going.
+type OverlapPolicy string
+
+const (
+	// OverlapSkip skips the run.
+	OverlapSkip OverlapPolicy = "skip"
+
+	// OverlapQueue runs it once the last run is done, if fewer than
+	// the limit of runs are waiting, and skips it otherwise.
+	OverlapQueue OverlapPolicy = "queue"
+
+	// OverlapConcurrent runs it alongside the last run, if fewer than
+	// the limit of runs are going, and skips it otherwise. A limit of
+	// zero runs it always.
+	OverlapConcurrent OverlapPolicy = "concurrent"
+)
+
+// OverlapConfig is the overlap policy of an event.
+type OverlapConfig struct {
+	Policy OverlapPolicy `json:"policy"`
+
+	// Limit is how many runs may wait, when queued, which defaults
+	// to one, or how many may go at once, when concurrent.
+	Limit int `json:"limit"`
+}
+
+// eventOverlap runs an event out of the planner tick, following its
+// overlap policy. It is shared by the copies of the event.
+type eventOverlap struct {
+	policy OverlapPolicy
+	limit  int
+
+	mux     sync.Mutex
+	running int
+	queued  int
+	skipped uint64
+}
+
+// SetOverlap has the planner run the event in the background, so that
+// slow runs don't hold up the other events, and decides what happens
+// to its runs that are due while it is still running.
+func (s *Event) SetOverlap(config OverlapConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	limit := config.Limit
+	if config.Policy == OverlapQueue && limit == 0 {
+		limit = 1
+	}
+
+	s.overlap = &eventOverlap{policy: config.Policy, limit: limit}
+	return nil
+}
+
+func (s *OverlapConfig) validate() error {
+	switch s.Policy {
+	case OverlapSkip, OverlapQueue, OverlapConcurrent:
+	default:
+		return fmt.Errorf("%w: unknown policy %q", ErrBadOverlap, s.Policy)
+	}
+
+	if s.Limit < 0 {
+		return fmt.Errorf("%w: negative limit %d", ErrBadOverlap, s.Limit)
+	}
+	return nil
+}
+
+// start runs the event in the background, or queues its run, unless
+// the policy skips it. It returns whether the run was skipped. The run
+// was begun on the planner, and is ended once the work is done, or
+// right away when it is not started.
+func (s *eventOverlap) start(planner *Planner, event *Event) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	switch {
+	case s.running == 0,
+		s.policy == OverlapConcurrent && (s.limit == 0 || s.running < s.limit):
+		s.running++
+		go s.work(planner, event)
+		return true
+	case s.policy == OverlapQueue && s.queued < s.limit:
+		s.queued++
+		planner.endRun(event)
+		return true
+	default:
+		s.skipped++
+		planner.endRun(event)
+		return false
+	}
+}
+
+// work runs the event, and then the runs that were queued behind it,
+// unless the planner was stopped in the meantime.
+func (s *eventOverlap) work(planner *Planner, event *Event) {
+	defer planner.endRun(event)
+
+	for {
+		event.run()
+
+		s.mux.Lock()
+		if planner.drain.isStopped() {
+			s.queued = 0
+		}
+		if s.queued == 0 {
+			s.running--
+			s.mux.Unlock()
+			return
+		}
+		s.queued--
+		s.mux.Unlock()
+	}
+}
+
+// fill sets the overlap counters of the state of the event.
+func (s *eventOverlap) fill(state *EventState) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	state.Overlap = s.policy
+	state.Running = s.running
+	state.QueuedRuns = s.queued
+	state.SkippedRuns = s.skipped
+}
+
+// Overlapping returns the events whose runs were skipped, because
+// they were still running.
+func (s *Planner) Overlapping() []EventState {
+	var overlapping []EventState
+	for _, event := range s.State().Events {
+		if event.SkippedRuns > 0 {
+			overlapping = append(overlapping, event)
+		}
+	}
+	return overlapping
+}
@@ -17,59 +17,139 @@ limitations under the License.
 */
 package cynic
 
+import "container/heap"
+
 // EventQueue is a priority queue that sorts events that are to
-// happen via their absolute expiry.
-type EventQueue []*Event
+// happen via their absolute expiry. An event is in the queue at most
+// once; pushing it again moves it to its new expiry.
+type EventQueue struct {
+	items eventHeap
+	byID  map[uint64]*Event
+}
+
+// EventQueueNew creates an empty event queue.
+func EventQueueNew() *EventQueue {
+	return &EventQueue{
+		items: make(eventHeap, 0),
+		byID:  make(map[uint64]*Event),
+	}
+}
+
+// Len returns how many events are queued.
+func (s *EventQueue) Len() int {
+	return len(s.items)
+}
+
+// Push inserts an event into the queue, or moves it to its current
+// expiry if it is queued already.
+func (s *EventQueue) Push(event *Event) {
+	if queued, ok := s.byID[event.ID()]; ok {
+		if queued == event {
+			heap.Fix(&s.items, event.index)
+			return
+		}
+		heap.Remove(&s.items, queued.index)
+	}
+
+	s.byID[event.ID()] = event
+	heap.Push(&s.items, event)
+}
+
+// Pop removes and returns the soonest event, or nil if the queue is
+// empty.
+func (s *EventQueue) Pop() *Event {
+	if len(s.items) == 0 {
+		return nil
+	}
+
+	event := heap.Pop(&s.items).(*Event)
+	delete(s.byID, event.ID())
+	return event
+}
+
+// Remove removes the event with the given id, if it is queued.
+func (s *EventQueue) Remove(id uint64) (*Event, bool) {
+	event, ok := s.byID[id]
+	if !ok {
+		return nil, false
+	}
+
+	heap.Remove(&s.items, event.index)
+	delete(s.byID, id)
+	return event, true
+}
+
+// Contains returns if the event with the given id is queued.
+func (s *EventQueue) Contains(id uint64) bool {
+	_, ok := s.byID[id]
+	return ok
+}
+
+// PeekTimestamp gives the timestamp at the root of the heap.
+func (s *EventQueue) PeekTimestamp() (int64, bool) {
+	if len(s.items) == 0 {
+		return 0, false
+	}
+
+	return int64(s.items[0].priority), true
+}
 
-func (pq EventQueue) Len() int { return len(pq) }
+// PeekID returns the id of the event at root.
+func (s *EventQueue) PeekID() (uint64, bool) {
+	if len(s.items) == 0 {
+		return 0, false
+	}
+
+	return s.items[0].ID(), true
+}
+
+// Events returns the queued events, in no particular order.
+func (s *EventQueue) Events() []*Event {
+	events := make([]*Event, len(s.items))
+	copy(events, s.items)
+	return events
+}
 
-func (pq EventQueue) Less(i, j int) bool {
+// popDue removes and returns the soonest event, if it expires by now.
+func (s *EventQueue) popDue(now int) *Event {
+	if len(s.items) == 0 || s.items[0].priority > now {
+		return nil
+	}
+
+	return s.Pop()
+}
+
+// eventHeap implements heap.Interface for the queue. It should only
+// be used through the heap package, which keeps the index of each
+// event up to date.
+type eventHeap []*Event
+
+func (pq eventHeap) Len() int { return len(pq) }
+
+func (pq eventHeap) Less(i, j int) bool {
 	// Want lowest value here (smaller timestamp = sooner)
 	return pq[i].priority < pq[j].priority
 }
 
-func (pq EventQueue) Swap(i, j int) {
+func (pq eventHeap) Swap(i, j int) {
 	pq[i], pq[j] = pq[j], pq[i]
 	pq[i].index = i
 	pq[j].index = j
 }
 
-// Push inserts an event into the priority queue.
-func (pq *EventQueue) Push(x interface{}) {
+func (pq *eventHeap) Push(x interface{}) {
 	n := len(*pq)
 	item := x.(*Event)
 	item.index = n
 	*pq = append(*pq, item)
 }
 
-// Pop retrieves the soonest event.
-func (pq *EventQueue) Pop() interface{} {
+func (pq *eventHeap) Pop() interface{} {
 	old := *pq
 	n := len(old)
 	item := old[n-1]
+	old[n-1] = nil
 	item.index = -1
 	*pq = old[0 : n-1]
 	return item
 }
-
-// PeekTimestamp gives the timestamp at the root of the heap.
-func (pq *EventQueue) PeekTimestamp() (int64, bool) {
-	if len(*pq) == 0 {
-		return 0, false
-	}
-
-	old := *pq
-	item := old[0]
-	return int64(item.priority), true
-}
-
-// PeekID returns the id of the event at root.
-func (pq *EventQueue) PeekID() (uint64, bool) {
-	if len(*pq) == 0 {
-		return 0, false
-	}
-
-	old := *pq
-	item := old[0]
-	return item.ID(), true
-}
@@ -0,0 +1,237 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const windowTimeLayout = "15:04"
+
+// ExecutionWindows restrict when an event runs, for checks that load
+// what they check, or cost money. The event runs when the time is in
+// one of the Only windows, if there are any, and in none of the Never
+// windows. Runs outside of them are skipped, and the event stays on
+// its schedule.
+type ExecutionWindows struct {
+	Only  []TimeWindow `json:"only"`
+	Never []TimeWindow `json:"never"`
+
+	// Timezone is the name of the zone the windows are in, like
+	// "Europe/Paris". Defaults to the local time.
+	Timezone string `json:"timezone"`
+}
+
+// TimeWindow is a time of the day, on some days. Fields that are not
+// set match any time: a window of Days only is those whole days.
+type TimeWindow struct {
+	// From and To are times of the day, like "09:00" and "17:00". A
+	// window that ends before it starts spans midnight, and belongs
+	// to the day it starts on.
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Days are days of the week, like "mon" or "sat", or "weekdays"
+	// and "weekends".
+	Days []string `json:"days"`
+
+	// MonthDays are days of the month. Negative days count from the
+	// end of the month, -1 being its last day.
+	MonthDays []int `json:"month_days"`
+}
+
+// executionWindows are execution windows, parsed.
+type executionWindows struct {
+	only     []timeWindow
+	never    []timeWindow
+	location *time.Location
+}
+
+type timeWindow struct {
+	// from and to are durations since midnight.
+	from time.Duration
+	to   time.Duration
+
+	// weekdays is a bit per weekday, or zero for every day.
+	weekdays  uint8
+	monthDays []int
+}
+
+var windowDays = map[string]uint8{
+	"sun":      1 << time.Sunday,
+	"mon":      1 << time.Monday,
+	"tue":      1 << time.Tuesday,
+	"wed":      1 << time.Wednesday,
+	"thu":      1 << time.Thursday,
+	"fri":      1 << time.Friday,
+	"sat":      1 << time.Saturday,
+	"weekdays": 1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday | 1<<time.Friday,
+	"weekends": 1<<time.Saturday | 1<<time.Sunday,
+}
+
+// SetWindows restricts when the event runs. Runs outside of the
+// windows are skipped by the planner.
+func (s *Event) SetWindows(windows ExecutionWindows) error {
+	parsed, err := windows.parse()
+	if err != nil {
+		return err
+	}
+
+	s.windows = parsed
+	return nil
+}
+
+// inWindows returns whether the event may run at the given time.
+func (s *Event) inWindows(now time.Time) bool {
+	return s.windows == nil || s.windows.contains(now)
+}
+
+func (s *ExecutionWindows) parse() (*executionWindows, error) {
+	parsed := &executionWindows{location: time.Local}
+
+	if s.Timezone != "" {
+		location, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadWindow, err)
+		}
+		parsed.location = location
+	}
+
+	for _, window := range s.Only {
+		only, err := window.parse()
+		if err != nil {
+			return nil, err
+		}
+		parsed.only = append(parsed.only, only)
+	}
+
+	for _, window := range s.Never {
+		never, err := window.parse()
+		if err != nil {
+			return nil, err
+		}
+		parsed.never = append(parsed.never, never)
+	}
+
+	return parsed, nil
+}
+
+func (s *TimeWindow) parse() (timeWindow, error) {
+	window := timeWindow{to: 24 * time.Hour, monthDays: s.MonthDays}
+
+	var err error
+	if s.From != "" {
+		if window.from, err = parseTimeOfDay(s.From); err != nil {
+			return window, err
+		}
+	}
+	if s.To != "" && s.To != "24:00" {
+		if window.to, err = parseTimeOfDay(s.To); err != nil {
+			return window, err
+		}
+	}
+	if window.from == window.to {
+		return window, fmt.Errorf("%w: %s to %s is empty", ErrBadWindow, s.From, s.To)
+	}
+
+	for _, day := range s.Days {
+		bits, ok := windowDays[strings.ToLower(day)]
+		if !ok {
+			return window, fmt.Errorf("%w: unknown day %q", ErrBadWindow, day)
+		}
+		window.weekdays |= bits
+	}
+
+	for _, day := range s.MonthDays {
+		if day == 0 || day > 31 || day < -31 {
+			return window, fmt.Errorf("%w: bad day of the month %d", ErrBadWindow, day)
+		}
+	}
+
+	return window, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse(windowTimeLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad time of the day %q", ErrBadWindow, value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (s *executionWindows) contains(now time.Time) bool {
+	now = now.In(s.location)
+
+	for i := range s.never {
+		if s.never[i].contains(now) {
+			return false
+		}
+	}
+
+	if len(s.only) == 0 {
+		return true
+	}
+
+	for i := range s.only {
+		if s.only[i].contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *timeWindow) contains(t time.Time) bool {
+	sinceMidnight := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	if s.from < s.to {
+		return sinceMidnight >= s.from && sinceMidnight < s.to && s.onDay(t)
+	}
+
+	// the window spans midnight, and the hours after it belong to
+	// the day before
+	if sinceMidnight >= s.from {
+		return s.onDay(t)
+	}
+	return sinceMidnight < s.to && s.onDay(t.AddDate(0, 0, -1))
+}
+
+func (s *timeWindow) onDay(t time.Time) bool {
+	if s.weekdays != 0 && s.weekdays&(1<<t.Weekday()) == 0 {
+		return false
+	}
+
+	if len(s.monthDays) == 0 {
+		return true
+	}
+
+	// day zero of the next month is the last day of this one
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	for _, day := range s.monthDays {
+		if day < 0 {
+			day += last + 1
+		}
+		if day == t.Day() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultFeedEntries is how many entries the feed has, unless asked
+// for more or less with ?limit=.
+const defaultFeedEntries = 50
+
+const atomNamespace = "http://www.w3.org/2005/Atom"
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Summary    string         `xml:"summary,omitempty"`
+	Categories []atomCategory `xml:"category"`
+
+	// at orders the entries.
+	at time.Time
+}
+
+// feedName is how an event is named in the feed.
+func feedName(group, label string, eventID uint64) string {
+	switch {
+	case label == "":
+		return "event " + strconv.FormatUint(eventID, 10)
+	case group == "":
+		return label
+	default:
+		return group + "/" + label
+	}
+}
+
+func alertEntry(record *AlertRecord) atomEntry {
+	name := feedName(record.Group, record.Label, record.EventID)
+
+	entry := atomEntry{
+		ID:         fmt.Sprintf("urn:cynic:alert:%d:%s:%d", record.EventID, record.Kind, record.Time.UnixNano()),
+		Updated:    record.Time.UTC().Format(time.RFC3339),
+		Categories: []atomCategory{{Term: string(record.Kind)}, {Term: record.Severity.String()}},
+		at:         record.Time,
+	}
+
+	switch record.Kind {
+	case AlertRecordAlert:
+		entry.Title = fmt.Sprintf("[%s] %s is alerting", record.Severity, name)
+	default:
+		entry.Title = fmt.Sprintf("[%s] %s was %s", record.Severity, name, record.Kind)
+	}
+
+	if record.Message != nil && record.Message.Response != nil {
+		if data, err := json.Marshal(record.Message.Response); err == nil {
+			entry.Summary = string(data)
+		}
+	}
+
+	return entry
+}
+
+// incidentEntries are the entries of the opening of the incident, and
+// of its closing, if it is closed.
+func incidentEntries(incident *Incident) []atomEntry {
+	name := feedName(incident.Group, incident.Label, incident.EventID)
+
+	opened := atomEntry{
+		ID:         fmt.Sprintf("urn:cynic:incident:%d:opened", incident.ID),
+		Title:      fmt.Sprintf("Incident %d opened: %s is failing", incident.ID, name),
+		Updated:    incident.Start.UTC().Format(time.RFC3339),
+		Categories: []atomCategory{{Term: "incident"}},
+		at:         incident.Start,
+	}
+	if incident.FirstError != nil {
+		if data, err := json.Marshal(incident.FirstError); err == nil {
+			opened.Summary = string(data)
+		}
+	}
+
+	if incident.Open() {
+		return []atomEntry{opened}
+	}
+
+	closed := atomEntry{
+		ID:         fmt.Sprintf("urn:cynic:incident:%d:closed", incident.ID),
+		Title:      fmt.Sprintf("Incident %d closed: %s recovered after %s", incident.ID, name, incident.Duration),
+		Updated:    incident.End.UTC().Format(time.RFC3339),
+		Summary:    fmt.Sprintf("%d failed runs", incident.Failures),
+		Categories: []atomCategory{{Term: "incident"}},
+		at:         incident.End,
+	}
+
+	return []atomEntry{opened, closed}
+}
+
+// handleFeed serves the alerts, and the openings and closings of
+// incidents, newest first, as an atom feed. The event (id) and limit
+// query parameters narrow it down.
+func (s *StatusCache) handleFeed(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	var eventID uint64
+	if event := query.Get("event"); event != "" {
+		var err error
+		if eventID, err = strconv.ParseUint(event, 10, 64); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	limit := defaultFeedEntries
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("%w: %q", ErrBadFeedLimit, value))
+			return
+		}
+	}
+
+	var entries []atomEntry
+	if s.alerter != nil {
+		records := s.alerter.History(AlertHistoryFilter{EventID: eventID})
+		for i := range records {
+			entries = append(entries, alertEntry(&records[i]))
+		}
+	}
+	if s.incidents != nil {
+		incidents := s.incidents.Incidents(IncidentFilter{EventID: eventID})
+		for i := range incidents {
+			entries = append(entries, incidentEntries(&incidents[i])...)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].at.After(entries[j].at)
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	updated := time.Now()
+	if len(entries) > 0 {
+		updated = entries[0].at
+	}
+
+	feed := atomFeed{
+		XMLNS:   atomNamespace,
+		ID:      "urn:cynic:feed:" + req.Host,
+		Title:   "cynic alerts and incidents",
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "cynic"},
+		Links:   []atomLink{{Href: "http://" + req.Host + req.URL.RequestURI(), Rel: "self"}},
+		Entries: entries,
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		log.Println("problem writing feed: ", err)
+		return
+	}
+	if err := xml.NewEncoder(w).Encode(&feed); err != nil {
+		log.Println("problem writing feed: ", err)
+	}
+}
@@ -0,0 +1,358 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGrafanaPoints is how many runs of every event the grafana
+// datasource keeps, unless configured otherwise: a day of runs every
+// minute.
+const DefaultGrafanaPoints = 1440
+
+// Suffixes of the grafana metrics of an event: the latency of its runs
+// in milliseconds, and whether they succeeded, as 1 or 0.
+const (
+	grafanaLatencySuffix = ".latency_ms"
+	grafanaUpSuffix      = ".up"
+)
+
+// GrafanaConfig configures a grafana datasource.
+type GrafanaConfig struct {
+	// Points is how many runs of every event are kept.
+	Points int
+}
+
+// GrafanaDatasource is a result sink keeping the last runs of the
+// events, which the status server serves as a grafana simple json
+// (or infinity) datasource, on /grafana/. The latency and the success
+// of every event are metrics, and the incidents of the status server,
+// if it has any, are annotations.
+type GrafanaDatasource struct {
+	config GrafanaConfig
+	mux    sync.Mutex
+	series map[string][]grafanaPoint
+}
+
+type grafanaPoint struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// GrafanaDatasourceNew creates a grafana datasource, to add to the
+// result sinks of the planner, and to give to the status cache with
+// WithGrafana.
+func GrafanaDatasourceNew(config GrafanaConfig) *GrafanaDatasource {
+	if config.Points <= 0 {
+		config.Points = DefaultGrafanaPoints
+	}
+
+	return &GrafanaDatasource{config: config, series: make(map[string][]grafanaPoint)}
+}
+
+// Record keeps the run of the event. Results of async hooks are left
+// out, as they are not runs.
+func (s *GrafanaDatasource) Record(result EventResult) {
+	if result.Async {
+		return
+	}
+
+	at := result.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	key := resultKey(result)
+	points := append(s.series[key], grafanaPoint{at: at, latency: result.Latency, failed: result.Failed})
+	if len(points) > s.config.Points {
+		points = points[len(points)-s.config.Points:]
+	}
+	s.series[key] = points
+}
+
+// metrics returns the names of the metrics containing the query,
+// sorted.
+func (s *GrafanaDatasource) metrics(query string) []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := make([]string, 0, 2*len(s.series))
+	for key := range s.series {
+		for _, metric := range []string{key + grafanaLatencySuffix, key + grafanaUpSuffix} {
+			if strings.Contains(metric, query) {
+				ret = append(ret, metric)
+			}
+		}
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// datapoints returns the [value, unix ms] of the metric between from
+// and to, averaged down to at most limit points if limit is set.
+func (s *GrafanaDatasource) datapoints(metric string, from, to time.Time, limit int) [][2]float64 {
+	var key string
+	var value func(point *grafanaPoint) float64
+
+	switch {
+	case strings.HasSuffix(metric, grafanaLatencySuffix):
+		key = strings.TrimSuffix(metric, grafanaLatencySuffix)
+		value = func(point *grafanaPoint) float64 { return float64(point.latency) / float64(time.Millisecond) }
+	case strings.HasSuffix(metric, grafanaUpSuffix):
+		key = strings.TrimSuffix(metric, grafanaUpSuffix)
+		value = func(point *grafanaPoint) float64 {
+			if point.failed {
+				return 0
+			}
+			return 1
+		}
+	default:
+		return [][2]float64{}
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := make([][2]float64, 0)
+	for i := range s.series[key] {
+		point := &s.series[key][i]
+		if point.at.Before(from) || point.at.After(to) {
+			continue
+		}
+		ret = append(ret, [2]float64{value(point), float64(point.at.UnixNano() / int64(time.Millisecond))})
+	}
+
+	return downsample(ret, limit)
+}
+
+// downsample averages consecutive datapoints together, keeping at
+// most limit of them, each at the time of the first it averages.
+func downsample(points [][2]float64, limit int) [][2]float64 {
+	if limit <= 0 || len(points) <= limit {
+		return points
+	}
+
+	per := (len(points) + limit - 1) / limit
+	ret := make([][2]float64, 0, limit)
+	for start := 0; start < len(points); start += per {
+		end := start + per
+		if end > len(points) {
+			end = len(points)
+		}
+
+		var sum float64
+		for _, point := range points[start:end] {
+			sum += point[0]
+		}
+		ret = append(ret, [2]float64{sum / float64(end-start), points[start][1]})
+	}
+
+	return ret
+}
+
+// grafanaRange is the time range of grafana queries.
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+type grafanaQueryRequest struct {
+	Range         grafanaRange `json:"range"`
+	MaxDataPoints int          `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+type grafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type grafanaAnnotationRequest struct {
+	Range      grafanaRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Annotation interface{} `json:"annotation"`
+	Time       int64       `json:"time"`
+	TimeEnd    int64       `json:"timeEnd"`
+	IsRegion   bool        `json:"isRegion"`
+	Title      string      `json:"title"`
+	Text       string      `json:"text,omitempty"`
+	Tags       []string    `json:"tags,omitempty"`
+}
+
+// WithGrafana serves the runs kept by the datasource, and the
+// incidents of the cache, as a grafana simple json datasource on
+// /grafana/.
+func (s *StatusCache) WithGrafana(datasource *GrafanaDatasource) {
+	s.grafana = datasource
+}
+
+// handleGrafanaTest answers the connection test of grafana.
+func (s *StatusCache) handleGrafanaTest(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != grafanaEndpoint {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGrafanaSearch lists the metrics containing the target.
+func (s *StatusCache) handleGrafanaSearch(w http.ResponseWriter, req *http.Request) {
+	var search grafanaSearchRequest
+	if req.Method == http.MethodPost {
+		if err := json.NewDecoder(req.Body).Decode(&search); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, s.grafana.metrics(search.Target))
+}
+
+// handleGrafanaQuery returns the datapoints of the targets, as time
+// series, or as tables for targets of the table type.
+func (s *StatusCache) handleGrafanaQuery(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var query grafanaQueryRequest
+	if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	to := query.Range.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	ret := make([]interface{}, 0, len(query.Targets))
+	for _, target := range query.Targets {
+		points := s.grafana.datapoints(target.Target, query.Range.From, to, query.MaxDataPoints)
+
+		if target.Type != "table" {
+			ret = append(ret, grafanaTimeSeries{Target: target.Target, Datapoints: points})
+			continue
+		}
+
+		table := grafanaTable{
+			Type:    "table",
+			Columns: []grafanaColumn{{Text: "Time", Type: "time"}, {Text: target.Target, Type: "number"}},
+			Rows:    make([][]interface{}, 0, len(points)),
+		}
+		for _, point := range points {
+			table.Rows = append(table.Rows, []interface{}{int64(point[1]), point[0]})
+		}
+		ret = append(ret, table)
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// handleGrafanaAnnotations returns the incidents over the range, of
+// the events whose group/label contains the query of the annotation,
+// as regions.
+func (s *StatusCache) handleGrafanaAnnotations(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var query grafanaAnnotationRequest
+	if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ret := make([]grafanaAnnotation, 0)
+	if s.incidents == nil {
+		writeJSON(w, http.StatusOK, ret)
+		return
+	}
+
+	to := query.Range.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	for _, incident := range s.incidents.Incidents(IncidentFilter{Since: query.Range.From}) {
+		key := incident.Group + "/" + incident.Label
+		if incident.Start.After(to) || !strings.Contains(key, query.Annotation.Query) {
+			continue
+		}
+
+		end := incident.End
+		if incident.Open() {
+			end = time.Now()
+		}
+
+		annotation := grafanaAnnotation{
+			Annotation: query.Annotation,
+			Time:       incident.Start.UnixNano() / int64(time.Millisecond),
+			TimeEnd:    end.UnixNano() / int64(time.Millisecond),
+			IsRegion:   true,
+			Title:      incident.Label + " failing",
+			Tags:       []string{incident.Group},
+		}
+		if incident.FirstError != nil {
+			if data, err := json.Marshal(incident.FirstError); err == nil {
+				annotation.Text = string(data)
+			}
+		}
+		ret = append(ret, annotation)
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
@@ -0,0 +1,143 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// heartbeatEndpoint is where jobs ping their heartbeats, followed by
+// their token.
+const heartbeatEndpoint = "/ping/"
+
+// HeartbeatResult is what a heartbeat event stores in the status
+// cache, and sends along with its alerts.
+type HeartbeatResult struct {
+	// LastPing is when the job last pinged, if it ever did.
+	LastPing time.Time `json:"last_ping,omitempty"`
+	Pings    uint64    `json:"pings"`
+
+	// Late is set when the job did not ping within its period.
+	Late bool `json:"late,omitempty"`
+}
+
+// heartbeats are the pings received by the status cache, for the
+// tokens the heartbeat hooks expect.
+type heartbeats struct {
+	mux   sync.Mutex
+	beats map[string]*heartbeat
+}
+
+type heartbeat struct {
+	since time.Time
+	last  time.Time
+	pings uint64
+}
+
+func heartbeatsNew() *heartbeats {
+	return &heartbeats{beats: make(map[string]*heartbeat)}
+}
+
+// expect registers a token, if it was not already, counting its
+// period from now until its first ping.
+func (s *heartbeats) expect(token string, now time.Time) heartbeat {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	beat, ok := s.beats[token]
+	if !ok {
+		beat = &heartbeat{since: now}
+		s.beats[token] = beat
+	}
+	return *beat
+}
+
+// ping records a ping of a token, and returns false if no heartbeat
+// expects it.
+func (s *heartbeats) ping(token string, now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	beat, ok := s.beats[token]
+	if !ok {
+		return false
+	}
+
+	beat.last = now
+	beat.pings++
+	return true
+}
+
+// HeartbeatHookNew returns a hook for a passive check: external jobs
+// ping the status server under /ping/<token>, and the hook fails when
+// no ping came within the period, starting from the first run of the
+// hook. Pings are only taken for tokens of hooks that ran, so the
+// event should run soon after it is added, and the token kept secret.
+func HeartbeatHookNew(token string, within time.Duration) HookSignature {
+	return heartbeatHookNew(token, within, "")
+}
+
+// heartbeatHookNew returns the hook of a heartbeat, which also stores
+// its result in the status cache under key, unless it is empty.
+func heartbeatHookNew(token string, within time.Duration, key string) HookSignature {
+	return func(params *HookParameters) (bool, interface{}) {
+		if params.Status == nil || params.Status.heartbeats == nil {
+			return false, nil
+		}
+
+		now := params.Planner.now()
+		beat := params.Status.heartbeats.expect(token, now)
+
+		last := beat.last
+		if last.IsZero() {
+			last = beat.since
+		}
+
+		result := HeartbeatResult{
+			LastPing: beat.last,
+			Pings:    beat.pings,
+			Late:     now.Sub(last) > within,
+		}
+
+		if key != "" {
+			params.Status.Update(key, result)
+		}
+
+		return result.Late, result
+	}
+}
+
+func (s *StatusCache) handlePing(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(req.URL.Path, heartbeatEndpoint)
+	if token == "" || !s.heartbeats.ping(token, s.planner.now()) {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,160 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"sort"
+)
+
+// HostCheckConfig checks the resources of the host cynic runs on.
+// Limits left at zero are not checked.
+type HostCheckConfig struct {
+	// Mounts are the mount points whose disk and inode usage is
+	// checked, like "/" or "/var".
+	Mounts []string `json:"mounts"`
+
+	MaxDiskPercent   float64 `json:"max_disk_percent"`
+	MaxInodePercent  float64 `json:"max_inode_percent"`
+	MaxMemoryPercent float64 `json:"max_memory_percent"`
+
+	// MaxLoad is checked against the load average over a minute.
+	MaxLoad float64 `json:"max_load"`
+}
+
+// DiskUsage is the usage of a mount. Percent is of the space usable
+// by users, like df shows it.
+type DiskUsage struct {
+	Total   uint64  `json:"total"`
+	Used    uint64  `json:"used"`
+	Percent float64 `json:"percent"`
+
+	Inodes        uint64  `json:"inodes"`
+	InodesUsed    uint64  `json:"inodes_used"`
+	InodesPercent float64 `json:"inodes_percent"`
+}
+
+// MemoryUsage is the memory of the host, in bytes. Available memory
+// counts the caches that can be reclaimed.
+type MemoryUsage struct {
+	Total     uint64  `json:"total"`
+	Available uint64  `json:"available"`
+	Percent   float64 `json:"percent"`
+}
+
+// LoadAverage is the load of the host over 1, 5 and 15 minutes.
+type LoadAverage struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+// HostResult is what a host check stores in the status cache, and
+// sends along with its alerts.
+type HostResult struct {
+	Disks  map[string]DiskUsage `json:"disks,omitempty"`
+	Memory *MemoryUsage         `json:"memory,omitempty"`
+	Load   *LoadAverage         `json:"load,omitempty"`
+
+	// Problems are the limits crossed, and the checks that could not
+	// be made.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// HostHookNew returns a hook checking the disks, inodes, memory and
+// load of the host against the limits of config. It fails when any
+// limit is crossed, or any check can't be made.
+func HostHookNew(config HostCheckConfig) HookSignature {
+	return hostHookNew(config, "")
+}
+
+// hostHookNew returns the hook of a host check, which also stores its
+// result in the status cache under key, unless it is empty.
+func hostHookNew(config HostCheckConfig, key string) HookSignature {
+	return func(params *HookParameters) (bool, interface{}) {
+		result := checkHost(&config)
+
+		if key != "" && params.Status != nil {
+			params.Status.Update(key, result)
+		}
+
+		return len(result.Problems) > 0, result
+	}
+}
+
+func checkHost(config *HostCheckConfig) HostResult {
+	var result HostResult
+
+	if len(config.Mounts) > 0 {
+		result.Disks = make(map[string]DiskUsage, len(config.Mounts))
+	}
+	for _, mount := range config.Mounts {
+		usage, err := diskUsage(mount)
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("disk %s: %v", mount, err))
+			continue
+		}
+		result.Disks[mount] = usage
+
+		if config.MaxDiskPercent > 0 && usage.Percent > config.MaxDiskPercent {
+			result.Problems = append(result.Problems,
+				fmt.Sprintf("disk %s is %.1f%% full, over %.1f%%", mount, usage.Percent, config.MaxDiskPercent))
+		}
+		if config.MaxInodePercent > 0 && usage.InodesPercent > config.MaxInodePercent {
+			result.Problems = append(result.Problems,
+				fmt.Sprintf("disk %s uses %.1f%% of its inodes, over %.1f%%", mount, usage.InodesPercent, config.MaxInodePercent))
+		}
+	}
+
+	if config.MaxMemoryPercent > 0 {
+		memory, err := memoryUsage()
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("memory: %v", err))
+		} else {
+			result.Memory = &memory
+			if memory.Percent > config.MaxMemoryPercent {
+				result.Problems = append(result.Problems,
+					fmt.Sprintf("memory is %.1f%% used, over %.1f%%", memory.Percent, config.MaxMemoryPercent))
+			}
+		}
+	}
+
+	if config.MaxLoad > 0 {
+		load, err := loadAverage()
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("load: %v", err))
+		} else {
+			result.Load = &load
+			if load.Load1 > config.MaxLoad {
+				result.Problems = append(result.Problems,
+					fmt.Sprintf("load is %.2f, over %.2f", load.Load1, config.MaxLoad))
+			}
+		}
+	}
+
+	sort.Strings(result.Problems)
+	return result
+}
+
+// percentOf returns part as a percentage of total, or 0 if total is.
+func percentOf(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
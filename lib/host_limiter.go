@@ -0,0 +1,140 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HostLimits caps the requests hooks make to any one host, so that a
+// pile of events pointing at the same service cannot flood it.
+// Requests over the limits are not made, and their results are marked
+// throttled instead.
+type HostLimits struct {
+	// Concurrency is how many requests to a host can be in flight
+	// at once. Zero is no limit.
+	Concurrency int
+
+	// Rate is how many requests per second can be made to a host,
+	// on average. Zero is no limit.
+	Rate float64
+
+	// Burst is how many requests can be made to a host at once, on
+	// top of the rate. It defaults to one.
+	Burst int
+}
+
+// HostLimiter applies host limits. It is shared by all the events of
+// a planner.
+type HostLimiter struct {
+	throttled uint64
+
+	limits HostLimits
+	now    func() time.Time
+
+	mux   sync.Mutex
+	hosts map[string]*hostBucket
+}
+
+// hostBucket is the token bucket and the requests in flight of a host.
+type hostBucket struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+// HostLimiterNew creates a limiter with the given limits.
+func HostLimiterNew(limits HostLimits) *HostLimiter {
+	if limits.Burst <= 0 {
+		limits.Burst = 1
+	}
+
+	return &HostLimiter{
+		limits: limits,
+		now:    time.Now,
+		hosts:  make(map[string]*hostBucket),
+	}
+}
+
+// Acquire takes a request to host, if the limits allow it. The
+// returned release must be called once the request is done. If the
+// request would go over the limits, ok is false and nothing needs to
+// be released.
+func (s *HostLimiter) Acquire(host string) (release func(), ok bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	bucket, found := s.hosts[host]
+	if !found {
+		bucket = &hostBucket{tokens: float64(s.limits.Burst), last: s.now()}
+		s.hosts[host] = bucket
+	}
+
+	if s.limits.Concurrency > 0 && bucket.inFlight >= s.limits.Concurrency {
+		atomic.AddUint64(&s.throttled, 1)
+		return nil, false
+	}
+
+	if s.limits.Rate > 0 {
+		now := s.now()
+		bucket.tokens += now.Sub(bucket.last).Seconds() * s.limits.Rate
+		if burst := float64(s.limits.Burst); bucket.tokens > burst {
+			bucket.tokens = burst
+		}
+		bucket.last = now
+
+		if bucket.tokens < 1 {
+			atomic.AddUint64(&s.throttled, 1)
+			return nil, false
+		}
+		bucket.tokens--
+	}
+
+	bucket.inFlight++
+	return func() { s.release(host) }, true
+}
+
+func (s *HostLimiter) release(host string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if bucket, ok := s.hosts[host]; ok && bucket.inFlight > 0 {
+		bucket.inFlight--
+	}
+}
+
+// AcquireURL is Acquire, for the host of a url.
+func (s *HostLimiter) AcquireURL(rawURL string) (release func(), ok bool) {
+	host := rawURL
+	if isUnixURL(rawURL) {
+		if socket, _, err := unixTarget(rawURL); err == nil {
+			host = socket
+		}
+	} else if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	return s.Acquire(host)
+}
+
+// Throttled returns how many requests were refused so far.
+func (s *HostLimiter) Throttled() uint64 {
+	return atomic.LoadUint64(&s.throttled)
+}
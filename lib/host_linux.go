@@ -0,0 +1,118 @@
+//go:build linux
+// +build linux
+
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func diskUsage(mount string) (DiskUsage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(mount, &stat); err != nil {
+		return DiskUsage{}, err
+	}
+
+	size := uint64(stat.Bsize)
+	used := (stat.Blocks - stat.Bfree) * size
+	usable := used + stat.Bavail*size
+	inodesUsed := stat.Files - stat.Ffree
+
+	return DiskUsage{
+		Total:         stat.Blocks * size,
+		Used:          used,
+		Percent:       percentOf(used, usable),
+		Inodes:        stat.Files,
+		InodesUsed:    inodesUsed,
+		InodesPercent: percentOf(inodesUsed, stat.Files),
+	}, nil
+}
+
+func memoryUsage() (MemoryUsage, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return MemoryUsage{}, err
+	}
+	defer file.Close()
+
+	fields := map[string]uint64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// lines are like "MemTotal:       16318540 kB"
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		fields[strings.TrimSuffix(parts[0], ":")] = value * 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return MemoryUsage{}, err
+	}
+
+	total, ok := fields["MemTotal"]
+	if !ok {
+		return MemoryUsage{}, fmt.Errorf("%w: no MemTotal in /proc/meminfo", ErrHostCheck)
+	}
+
+	available, ok := fields["MemAvailable"]
+	if !ok {
+		// kernels before 3.14
+		available = fields["MemFree"] + fields["Buffers"] + fields["Cached"]
+	}
+
+	return MemoryUsage{
+		Total:     total,
+		Available: available,
+		Percent:   percentOf(total-available, total),
+	}, nil
+}
+
+func loadAverage() (LoadAverage, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return LoadAverage{}, err
+	}
+
+	// like "0.52 0.58 0.59 1/1018 8432"
+	parts := strings.Fields(string(data))
+	if len(parts) < 3 {
+		return LoadAverage{}, fmt.Errorf("%w: bad /proc/loadavg", ErrHostCheck)
+	}
+
+	var loads [3]float64
+	for i := range loads {
+		if loads[i], err = strconv.ParseFloat(parts[i], 64); err != nil {
+			return LoadAverage{}, fmt.Errorf("%w: bad /proc/loadavg", ErrHostCheck)
+		}
+	}
+
+	return LoadAverage{Load1: loads[0], Load5: loads[1], Load15: loads[2]}, nil
+}
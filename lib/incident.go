@@ -0,0 +1,299 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultIncidentCapacity is how many incidents are kept, unless
+// configured otherwise.
+const DefaultIncidentCapacity = 200
+
+// Incident is a period over which an event kept failing, from its
+// first failed run to its next successful one.
+type Incident struct {
+	ID      uint64    `json:"id"`
+	EventID uint64    `json:"event_id"`
+	Label   string    `json:"label"`
+	Group   string    `json:"group"`
+	Start   time.Time `json:"start"`
+
+	// End is zero while the incident is open.
+	End time.Time `json:"end,omitempty"`
+
+	// Duration is up to the end, or up to now if the incident is
+	// open.
+	Duration time.Duration `json:"duration_ns"`
+
+	// Failures is how many runs failed during the incident.
+	Failures int `json:"failures"`
+
+	// FirstError is the result of the first failed hook of the
+	// first failed run.
+	FirstError interface{} `json:"first_error,omitempty"`
+
+	// Alerts are the alerts the event sent during the incident, if
+	// there is an alerter with a history.
+	Alerts []AlertRecord `json:"alerts,omitempty"`
+}
+
+// Open returns whether the event is still failing.
+func (s *Incident) Open() bool {
+	return s.End.IsZero()
+}
+
+// IncidentFilter selects incidents. Zero values match everything.
+type IncidentFilter struct {
+	EventID  uint64
+	Since    time.Time
+	OnlyOpen bool
+}
+
+func (s *IncidentFilter) matches(incident *Incident) bool {
+	return (s.EventID == 0 || incident.EventID == s.EventID) &&
+		(s.Since.IsZero() || incident.Open() || !incident.End.Before(s.Since)) &&
+		(!s.OnlyOpen || incident.Open())
+}
+
+// IncidentStats sums up incidents, for reports.
+type IncidentStats struct {
+	Incidents int `json:"incidents"`
+	Open      int `json:"open"`
+
+	// MTTR is the mean time to recovery, of the closed incidents.
+	MTTR time.Duration `json:"mttr_ns"`
+}
+
+// IncidentConfig configures an incident tracker.
+type IncidentConfig struct {
+	// Capacity is how many incidents are kept. The oldest closed
+	// ones go first.
+	Capacity int
+
+	// Alerter, if set, is where the alerts of incidents are looked
+	// up.
+	Alerter *Alerter
+}
+
+// IncidentTracker is a result sink turning the failures of events into
+// incidents. The incidents are shown in the status, and so kept in
+// snapshots.
+type IncidentTracker struct {
+	config IncidentConfig
+
+	mux       sync.Mutex
+	lastID    uint64
+	incidents []*Incident
+	open      map[string]*Incident
+}
+
+// IncidentTrackerNew creates an incident tracker with the given config.
+func IncidentTrackerNew(config IncidentConfig) *IncidentTracker {
+	if config.Capacity <= 0 {
+		config.Capacity = DefaultIncidentCapacity
+	}
+
+	return &IncidentTracker{
+		config: config,
+		open:   make(map[string]*Incident),
+	}
+}
+
+// Record opens an incident on the first failure of an event, and closes
+// it on its next success. Results of async hooks are left out, as they
+// are not runs.
+func (s *IncidentTracker) Record(result EventResult) {
+	if result.Async {
+		return
+	}
+
+	at := result.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	key := resultKey(result)
+	incident, open := s.open[key]
+
+	switch {
+	case result.Failed && open:
+		incident.Failures++
+	case result.Failed:
+		s.lastID++
+		incident = &Incident{
+			ID:         s.lastID,
+			EventID:    result.EventID,
+			Label:      result.Label,
+			Group:      result.Group,
+			Start:      at,
+			Failures:   1,
+			FirstError: firstError(result.Hooks),
+		}
+		s.open[key] = incident
+		s.add(incident)
+	case open:
+		incident.End = at
+		incident.Duration = at.Sub(incident.Start)
+		delete(s.open, key)
+	}
+}
+
+// add keeps the incident, dropping the oldest closed one if over
+// capacity.
+func (s *IncidentTracker) add(incident *Incident) {
+	s.incidents = append(s.incidents, incident)
+	if len(s.incidents) <= s.config.Capacity {
+		return
+	}
+
+	for i, old := range s.incidents {
+		if !old.Open() {
+			s.incidents = append(s.incidents[:i], s.incidents[i+1:]...)
+			return
+		}
+	}
+}
+
+func firstError(hooks []HookResult) interface{} {
+	for _, hook := range hooks {
+		if hook.Failed {
+			return hook.Result
+		}
+	}
+	return nil
+}
+
+// Incidents returns copies of the incidents matching the filter, the
+// oldest first, with their alerts.
+func (s *IncidentTracker) Incidents(filter IncidentFilter) []Incident {
+	now := time.Now()
+
+	s.mux.Lock()
+	incidents := make([]Incident, 0, len(s.incidents))
+	for _, incident := range s.incidents {
+		if filter.matches(incident) {
+			incidents = append(incidents, *incident)
+		}
+	}
+	s.mux.Unlock()
+
+	for i := range incidents {
+		incident := &incidents[i]
+		if incident.Open() {
+			incident.Duration = now.Sub(incident.Start)
+		}
+
+		if s.config.Alerter != nil {
+			incident.Alerts = incidentAlerts(s.config.Alerter, incident)
+		}
+	}
+
+	return incidents
+}
+
+// incidentAlerts returns the alerts the event of the incident sent
+// while it was open.
+func incidentAlerts(alerter *Alerter, incident *Incident) []AlertRecord {
+	records := alerter.History(AlertHistoryFilter{
+		EventID: incident.EventID,
+		Since:   incident.Start,
+		Until:   incident.End,
+	})
+
+	alerts := records[:0]
+	for _, record := range records {
+		if record.Kind == AlertRecordAlert {
+			alerts = append(alerts, record)
+		}
+	}
+	return alerts
+}
+
+// Stats sums up the incidents matching the filter.
+func (s *IncidentTracker) Stats(filter IncidentFilter) IncidentStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var stats IncidentStats
+	var recovered time.Duration
+	for _, incident := range s.incidents {
+		if !filter.matches(incident) {
+			continue
+		}
+
+		stats.Incidents++
+		if incident.Open() {
+			stats.Open++
+		} else {
+			recovered += incident.Duration
+		}
+	}
+
+	if closed := stats.Incidents - stats.Open; closed > 0 {
+		stats.MTTR = recovered / time.Duration(closed)
+	}
+
+	return stats
+}
+
+// Restore loads the incidents kept in a snapshot, like the last one
+// before a restart. Incidents that were open are open again, and are
+// closed by the next success of their event.
+func (s *IncidentTracker) Restore(snapshot *Snapshot) error {
+	var status map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(snapshot.Data), &status); err != nil {
+		return err
+	}
+
+	raw, ok := status[incidentsStatusKey]
+	if !ok {
+		return nil
+	}
+
+	var incidents []Incident
+	if err := json.Unmarshal(raw, &incidents); err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.incidents = s.incidents[:0]
+	s.open = make(map[string]*Incident)
+	for i := range incidents {
+		incident := &incidents[i]
+		incident.Alerts = nil
+		if incident.ID > s.lastID {
+			s.lastID = incident.ID
+		}
+
+		if incident.Open() {
+			incident.Duration = 0
+			s.open[resultKey(EventResult{EventID: incident.EventID, Label: incident.Label, Group: incident.Group})] = incident
+		}
+		s.incidents = append(s.incidents, incident)
+	}
+
+	return nil
+}
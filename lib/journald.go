@@ -0,0 +1,174 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultJournaldSocket is where systemd-journald takes messages in
+// its native protocol.
+const defaultJournaldSocket = "/run/systemd/journal/socket"
+
+// JournaldSink writes alerts, and log lines, to systemd-journald with
+// its native protocol, with structured fields. Its Alert method can be
+// given to AlerterNew, and it is an io.Writer, so that logs can be
+// sent to the journal with log.SetOutput.
+//
+// Alerts carry the fields CYNIC_EVENT_ID, CYNIC_LABEL, CYNIC_GROUP,
+// CYNIC_SEVERITY, CYNIC_FINGERPRINT, CYNIC_HOSTNAME and CYNIC_LOCATION.
+// Messages are
+// sent as single datagrams, so very large alerts may be rejected by
+// the socket.
+type JournaldSink struct {
+	socket     string
+	identifier string
+	template   *AlertTemplate
+
+	mux  sync.Mutex
+	conn net.Conn
+}
+
+// JournaldSinkNew creates a sink writing to the local journal.
+func JournaldSinkNew() *JournaldSink {
+	return &JournaldSink{
+		socket:     defaultJournaldSocket,
+		identifier: defaultSyslogAppName,
+	}
+}
+
+// SetSocket sets the socket of the journal.
+func (s *JournaldSink) SetSocket(path string) {
+	s.socket = path
+}
+
+// SetIdentifier sets the SYSLOG_IDENTIFIER of the messages. It
+// defaults to "cynic".
+func (s *JournaldSink) SetIdentifier(identifier string) {
+	s.identifier = identifier
+}
+
+// SetTemplate sets the template used for the text of each alert. By
+// default the hook result is printed as is.
+func (s *JournaldSink) SetTemplate(template *AlertTemplate) {
+	s.template = template
+}
+
+// Alert writes each alert as a message, with the severity of its
+// event as priority. It stops at the first message that can't be
+// sent, and returns its error, so that the alerts are retried.
+func (s *JournaldSink) Alert(messages []AlertMessage) error {
+	for i := range messages {
+		msg := &messages[i]
+
+		fields := [][2]string{
+			{"MESSAGE", alertText(s.template, msg)},
+			{"PRIORITY", strconv.Itoa(syslogSeverity(msg.Severity))},
+			{"CYNIC_EVENT_ID", strconv.FormatUint(msg.EventID, 10)},
+			{"CYNIC_LABEL", msg.Label},
+			{"CYNIC_GROUP", msg.Group},
+			{"CYNIC_SEVERITY", msg.Severity.String()},
+			{"CYNIC_FINGERPRINT", msg.Fingerprint},
+			{"CYNIC_ROOT_CAUSE", msg.RootCause},
+			{"CYNIC_HOSTNAME", msg.CynicHostname},
+			{"CYNIC_LOCATION", msg.Location},
+		}
+
+		if err := s.send(fields); err != nil {
+			return fmt.Errorf("could not send alert to journald: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Write writes a log line, at the info priority.
+func (s *JournaldSink) Write(p []byte) (int, error) {
+	fields := [][2]string{
+		{"MESSAGE", strings.TrimRight(string(p), "\n")},
+		{"PRIORITY", strconv.Itoa(syslogInfo)},
+	}
+
+	if err := s.send(fields); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection to the journal.
+func (s *JournaldSink) Close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// send writes the fields that have a value as a message. Values with
+// newlines are written in the binary form of the protocol: the name,
+// a newline, the length of the value as a little endian 64 bit
+// integer, and the value.
+func (s *JournaldSink) send(fields [][2]string) error {
+	var msg bytes.Buffer
+
+	fields = append(fields, [2]string{"SYSLOG_IDENTIFIER", s.identifier})
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+
+		msg.WriteString(field[0])
+		if strings.Contains(field[1], "\n") {
+			var size [8]byte
+			binary.LittleEndian.PutUint64(size[:], uint64(len(field[1])))
+			msg.WriteByte('\n')
+			msg.Write(size[:])
+		} else {
+			msg.WriteByte('=')
+		}
+		msg.WriteString(field[1])
+		msg.WriteByte('\n')
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.conn == nil {
+		conn, err := net.Dial("unixgram", s.socket)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	if _, err := s.conn.Write(msg.Bytes()); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keyWatchWindow is the window changes are counted over.
+const keyWatchWindow = time.Hour
+
+// KeyWatchConfig watches how often a key of the status cache changes,
+// whoever writes it: an event, a pushed document, or another hook. At
+// least one of the limits must be set.
+type KeyWatchConfig struct {
+	Key string `json:"key"`
+
+	// MaxChangesPerHour is how many times the value may change over
+	// the last hour.
+	MaxChangesPerHour int `json:"max_changes_per_hour"`
+
+	// MaxUnchanged is how long the value may stay the same, counted
+	// from when it is first watched.
+	MaxUnchanged ConfigDuration `json:"max_unchanged"`
+}
+
+// KeyWatchResult is how a watched key changed.
+type KeyWatchResult struct {
+	Key             string `json:"key"`
+	ChangesLastHour int    `json:"changes_last_hour"`
+
+	// LastChange is when the value last changed, or when it started
+	// being watched if it didn't since.
+	LastChange time.Time `json:"last_change"`
+
+	Problems []string `json:"problems,omitempty"`
+}
+
+// keyWatches are the watched keys of a status cache, by key. Count
+// keeps updates of caches without any from looking them up.
+type keyWatches struct {
+	count int32
+	keys  sync.Map
+}
+
+// keyWatch tracks the changes of a watched key. A change is an update
+// to a value with a different json.
+type keyWatch struct {
+	mux        sync.Mutex
+	last       []byte
+	changes    []time.Time
+	lastChange time.Time
+}
+
+// watchKey starts tracking the changes of the key, if it isn't
+// already, and returns its tracker.
+func (s *StatusCache) watchKey(key string) *keyWatch {
+	if watch, ok := s.keyWatches.keys.Load(key); ok {
+		return watch.(*keyWatch)
+	}
+
+	watch := &keyWatch{lastChange: time.Now()}
+	if value, err := s.Get(key); err == nil {
+		watch.last, _ = json.Marshal(value)
+	}
+
+	actual, loaded := s.keyWatches.keys.LoadOrStore(key, watch)
+	if !loaded {
+		atomic.AddInt32(&s.keyWatches.count, 1)
+	}
+	return actual.(*keyWatch)
+}
+
+// observeKey records a change of the key, if it is watched and its
+// value changed.
+func (s *StatusCache) observeKey(key string, value interface{}) {
+	if atomic.LoadInt32(&s.keyWatches.count) == 0 {
+		return
+	}
+
+	watch, ok := s.keyWatches.keys.Load(key)
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	watch.(*keyWatch).observe(data, time.Now())
+}
+
+func (s *keyWatch) observe(data []byte, now time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if bytes.Equal(data, s.last) {
+		return
+	}
+
+	s.last = data
+	s.lastChange = now
+	s.changes = append(s.prune(now), now)
+}
+
+// prune drops the changes older than the window. The watch must be
+// locked.
+func (s *keyWatch) prune(now time.Time) []time.Time {
+	i := 0
+	for i < len(s.changes) && now.Sub(s.changes[i]) >= keyWatchWindow {
+		i++
+	}
+	s.changes = s.changes[i:]
+
+	return s.changes
+}
+
+func (s *keyWatch) result(config *KeyWatchConfig, now time.Time) KeyWatchResult {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	result := KeyWatchResult{
+		Key:             config.Key,
+		ChangesLastHour: len(s.prune(now)),
+		LastChange:      s.lastChange,
+	}
+
+	if config.MaxChangesPerHour > 0 && result.ChangesLastHour > config.MaxChangesPerHour {
+		result.Problems = append(result.Problems,
+			fmt.Sprintf("changed %d times over the last hour, over %d", result.ChangesLastHour, config.MaxChangesPerHour))
+	}
+
+	maxUnchanged := time.Duration(config.MaxUnchanged)
+	if unchanged := now.Sub(s.lastChange); maxUnchanged > 0 && unchanged > maxUnchanged {
+		result.Problems = append(result.Problems,
+			fmt.Sprintf("unchanged for %s, over %s", unchanged.Round(time.Second), maxUnchanged))
+	}
+
+	return result
+}
+
+// KeyWatchHookNew returns a hook failing when the key of the status
+// cache of the event changes more often than allowed, or stays the same
+// for too long. The key is watched from the first run of the hook.
+func KeyWatchHookNew(config KeyWatchConfig) HookSignature {
+	return keyWatchHookNew(config, "")
+}
+
+// keyWatchHookNew returns the hook of a key watch, which also stores
+// its result in the status cache under key, unless it is empty.
+func keyWatchHookNew(config KeyWatchConfig, key string) HookSignature {
+	return func(params *HookParameters) (bool, interface{}) {
+		if params.Status == nil {
+			return true, ErrNoStatusCache.Error()
+		}
+
+		result := params.Status.watchKey(config.Key).result(&config, time.Now())
+
+		if key != "" {
+			params.Status.Update(key, result)
+		}
+
+		return len(result.Problems) > 0, result
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultLeaseTTL = 15 * time.Second
+
+// LeaseLock is a lease held by one instance at a time, that lapses if
+// it is not renewed, like a file, or a key in etcd or consul with a
+// ttl.
+type LeaseLock interface {
+	// Acquire takes the lease for holder for ttl, or renews it if
+	// holder has it already. It returns false if another holder has
+	// the lease.
+	Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+
+	// Release gives up the lease, if holder has it.
+	Release(ctx context.Context, holder string) error
+}
+
+// LeaderConfig makes a session one of an active and standby pair:
+// only the instance holding the lease runs its events. The standby
+// keeps its schedule moving without running anything, and takes over
+// once the leader stops renewing the lease.
+type LeaderConfig struct {
+	Lock LeaseLock
+
+	// Holder names this instance. It defaults to the hostname and
+	// the process id.
+	Holder string
+
+	// TTL is how long the lease lasts without being renewed. It is
+	// renewed every third of it, and defaults to 15 seconds.
+	TTL time.Duration
+
+	// OnChange, if set, is called with true when this instance
+	// becomes the leader, and with false when it stops being it.
+	OnChange func(leader bool)
+}
+
+// leaderElector keeps acquiring the lease, and knows whether this
+// instance is the leader. Leadership lapses on its own at the end of
+// the lease, even if renewing it hangs.
+type leaderElector struct {
+	config LeaderConfig
+	clock  Clock
+
+	mux        sync.Mutex
+	leaseUntil time.Time
+	leader     bool
+}
+
+func leaderElectorNew(config LeaderConfig, clock Clock) *leaderElector {
+	if config.Holder == "" {
+		config.Holder = instanceName()
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaultLeaseTTL
+	}
+
+	return &leaderElector{config: config, clock: clock}
+}
+
+// IsLeader returns whether this instance holds an unexpired lease.
+func (s *leaderElector) IsLeader() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.clock.Now().Before(s.leaseUntil)
+}
+
+// run acquires the lease right away, and then every third of its ttl,
+// until ctx is done, and then releases it.
+func (s *leaderElector) run(ctx context.Context) {
+	ticker := s.clock.NewTicker(s.config.TTL / 3)
+	defer ticker.Stop()
+
+	s.acquire(ctx)
+	for {
+		select {
+		case <-ticker.C():
+			s.acquire(ctx)
+		case <-ctx.Done():
+			s.release()
+			return
+		}
+	}
+}
+
+func (s *leaderElector) acquire(ctx context.Context) {
+	start := s.clock.Now()
+
+	acquireCtx, cancel := context.WithTimeout(ctx, s.config.TTL/3)
+	ok, err := s.config.Lock.Acquire(acquireCtx, s.config.Holder, s.config.TTL)
+	cancel()
+
+	s.mux.Lock()
+	switch {
+	case err != nil:
+		// the lease held, if any, is good until it runs out
+		log.Println("could not acquire leader lease: ", err)
+	case ok:
+		// the lease is counted from before asking for it, so that
+		// it never outlasts the one the lock gave
+		s.leaseUntil = start.Add(s.config.TTL)
+	default:
+		s.leaseUntil = time.Time{}
+	}
+	s.mux.Unlock()
+
+	s.notify()
+}
+
+// notify logs and reports changes of leadership. Leadership lost by
+// the lease running out is noticed on the next acquire.
+func (s *leaderElector) notify() {
+	leader := s.IsLeader()
+
+	s.mux.Lock()
+	changed := leader != s.leader
+	s.leader = leader
+	s.mux.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if leader {
+		log.Println("became the leader as", s.config.Holder)
+	} else {
+		log.Println("lost the leader lease, standing by")
+	}
+
+	if s.config.OnChange != nil {
+		s.config.OnChange(leader)
+	}
+}
+
+func (s *leaderElector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.TTL/3)
+	defer cancel()
+
+	if err := s.config.Lock.Release(ctx, s.config.Holder); err != nil {
+		log.Println("could not release leader lease: ", err)
+	}
+
+	s.mux.Lock()
+	s.leaseUntil = time.Time{}
+	s.mux.Unlock()
+
+	s.notify()
+}
@@ -0,0 +1,164 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	fileLeaseRetry = 10 * time.Millisecond
+
+	// fileLeaseStaleLock is how old a lock file has to be to be
+	// taken as left over by a crashed instance. Lock files are only
+	// held while a lease is read and written.
+	fileLeaseStaleLock = 10 * time.Second
+)
+
+// FileLeaseLock is a lease kept in a file, for instances on the same
+// host or sharing a filesystem. The file is changed under a lock
+// file, created exclusively. Instances must have clocks in sync, as
+// the expiry of the lease is a time.
+type FileLeaseLock struct {
+	path string
+}
+
+type fileLease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// FileLeaseLockNew creates a lease kept in the file at path.
+func FileLeaseLockNew(path string) *FileLeaseLock {
+	return &FileLeaseLock{path: path}
+}
+
+// Acquire takes or renews the lease for holder, unless another holder
+// has an unexpired lease.
+func (s *FileLeaseLock) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	lease, err := s.read()
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if lease.Holder != "" && lease.Holder != holder && now.Before(lease.Expires) {
+		return false, nil
+	}
+
+	return true, s.write(fileLease{Holder: holder, Expires: now.Add(ttl)})
+}
+
+// Release removes the lease, if holder has it.
+func (s *FileLeaseLock) Release(ctx context.Context, holder string) error {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	lease, err := s.read()
+	if err != nil || lease.Holder != holder {
+		return err
+	}
+
+	return os.Remove(s.path)
+}
+
+// lock creates the lock file, waiting for other instances to be done
+// with the lease, and returns what removes it.
+func (s *FileLeaseLock) lock(ctx context.Context) (func(), error) {
+	lockPath := s.path + ".lock"
+
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > fileLeaseStaleLock {
+			os.Remove(lockPath)
+			continue
+		}
+
+		select {
+		case <-time.After(fileLeaseRetry):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *FileLeaseLock) read() (fileLease, error) {
+	var lease fileLease
+
+	data, err := ioutil.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return lease, nil
+	}
+	if err != nil {
+		return lease, err
+	}
+
+	// a lease that can't be read is as good as none
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return fileLease{}, nil
+	}
+	return lease, nil
+}
+
+// write replaces the lease file in one go, so that it is never seen
+// half written.
+func (s *FileLeaseLock) write(lease fileLease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -19,10 +19,15 @@ limitations under the License.
 package cynic
 
 import (
-	"sync"
+	"context"
+	"log"
 	"time"
 )
 
+// shutdownTimeout bounds how long a shutdown waits on connections and
+// pending alerts, unless given a context.
+const shutdownTimeout = 30 * time.Second
+
 const (
 	// StopEvent is the signal to stop the running querying event.
 	StopEvent = iota
@@ -41,42 +46,337 @@ type Session struct {
 	StatusCache    *StatusCache
 	Alerter        *Alerter
 	SnapshotConfig *SnapshotConfig
+
+	// Planner, if set, is the planner the events are added to,
+	// which lets events be added and removed while running.
+	Planner *Planner
+
+	// Defaults, if set, are given to the events on Start.
+	Defaults *SessionDefaults
+
+	// Clock, if set, drives the planner, and is given to the
+	// alerter and the snapshots. Defaults to the system clock.
+	Clock Clock
+
+	// SelfMetrics, if set, is how often cynic publishes its own
+	// metrics to the status cache, under SelfMetricsStatusKey.
+	SelfMetrics time.Duration
+
+	// Metrics, if set, is sent the metrics of every run of an
+	// event, and is closed on shutdown.
+	Metrics *MetricsEmitter
+
+	// Sinks are given the result of every run of an event.
+	Sinks []ResultSink
+
+	// Location is where this instance probes from, like "eu-west",
+	// which the results and alerts of its events carry.
+	Location string
+
+	// HostLimits, if set, caps the requests the events make to any
+	// one host.
+	HostLimits *HostLimits
+
+	// SLO, if set, is given the results of the events, and its
+	// availabilities are shown in the status cache.
+	SLO *SLOTracker
+
+	// Incidents, if set, is given the results of the events, and its
+	// incidents are served by the status cache.
+	Incidents *IncidentTracker
+
+	// StatusPage, if set, is given the results of the events, and
+	// serves them publicly, started and stopped with the session.
+	StatusPage *StatusPage
+
+	// Grafana, if set, is given the results of the events, which the
+	// status cache serves as a grafana datasource.
+	Grafana *GrafanaDatasource
+
+	// Leader, if set, makes this session run its events only while
+	// it holds the leader lease.
+	Leader *LeaderConfig
+
+	// Cluster, if set, makes this session run only its share of the
+	// events, which it shares with the other members.
+	Cluster *ClusterConfig
+
+	// Routes, if set, store the results of the events they match in
+	// other status caches, like a public status page, which are
+	// started and stopped with the session.
+	Routes []RepoRoute
+
+	// Transports, if set, keep connections open to the hosts the
+	// events probe, for their probes to reuse.
+	Transports *TransportLimits
+
+	// Seed, if set, is the seed the planner draws the jitter of the
+	// events with, see Planner.SetSeed.
+	Seed *int64
+
+	// Journal, if set, records the scheduling decisions of the
+	// planner, which the admin interface serves.
+	Journal *PlannerJournalConfig
+
+	// AsyncLimit, if set, bounds how many async hooks of the events
+	// may be in flight at once.
+	AsyncLimit int
+
+	// Discovery, if set, keeps an event for every instance of the
+	// services it finds, next to the events of the session.
+	Discovery []DiscoveryConfig
 }
 
-// Start starts a cynic instance, with any provided hooks.
+// Start starts a cynic instance, with any provided hooks. It runs
+// forever.
 func Start(session Session) {
+	Run(context.Background(), session)
+}
+
+// Run is like Start, but returns once ctx is done, after a graceful
+// shutdown: events stop running, the status server stops and flushes
+// its snapshots, and pending alerts are delivered.
+func Run(ctx context.Context, session Session) {
+	run(ctx, session, nil)
+}
+
+// run runs the session until ctx is done, and shuts it down with the
+// context from stopCh, if there is one.
+func run(ctx context.Context, session Session, stopCh <-chan context.Context) {
+	if session.Clock != nil {
+		if session.Alerter != nil {
+			session.Alerter.WithClock(session.Clock)
+		}
+		if session.SnapshotConfig != nil && session.SnapshotConfig.Clock == nil {
+			session.SnapshotConfig.Clock = session.Clock
+		}
+		if session.Journal != nil && session.Journal.Clock == nil {
+			session.Journal.Clock = session.Clock
+		}
+	}
+
 	if session.Alerter != nil {
 		session.Alerter.Start()
-		defer session.Alerter.Stop()
 	}
 
-	planner := PlannerNew()
+	planner := session.Planner
+	if planner == nil {
+		planner = PlannerNew()
+	}
 	planner.alerter = session.Alerter
+	if session.Metrics != nil {
+		planner.metrics = session.Metrics
+	}
+	for _, sink := range session.Sinks {
+		planner.AddSink(sink)
+	}
+	if session.Location != "" {
+		planner.SetLocation(session.Location)
+	}
+	if session.HostLimits != nil {
+		planner.SetHostLimiter(HostLimiterNew(*session.HostLimits))
+	}
+	if session.Transports != nil {
+		planner.SetTransportPool(TransportPoolNew(*session.Transports))
+	}
+	if session.AsyncLimit > 0 {
+		planner.SetAsyncLimit(session.AsyncLimit)
+	}
+	if session.Journal != nil {
+		planner.SetJournal(PlannerJournalNew(*session.Journal))
+	}
+	if session.Seed != nil {
+		planner.SetSeed(*session.Seed)
+	}
+	if session.Clock != nil {
+		planner.SetClock(session.Clock)
+	}
+	if session.SLO != nil {
+		planner.AddSink(session.SLO)
+		if session.StatusCache != nil {
+			session.StatusCache.WithSLO(session.SLO)
+		}
+	}
+	if session.Incidents != nil {
+		planner.AddSink(session.Incidents)
+		if session.StatusCache != nil {
+			session.StatusCache.WithIncidents(session.Incidents)
+		}
+	}
+	if session.StatusPage != nil {
+		planner.AddSink(session.StatusPage)
+	}
+	if session.Grafana != nil {
+		planner.AddSink(session.Grafana)
+		if session.StatusCache != nil {
+			session.StatusCache.WithGrafana(session.Grafana)
+		}
+	}
+	if len(session.Routes) > 0 {
+		planner.SetRepoRoutes(session.Routes...)
+	}
 
 	for i := 0; i < len(session.Events); i++ {
+		if session.Defaults != nil {
+			session.Defaults.apply(&session.Events[i])
+		}
 		planner.Add(&session.Events[i])
 	}
 
-	if session.SnapshotConfig != nil {
-		session.StatusCache.WithSnapshots(session.SnapshotConfig)
+	if session.StatusCache != nil && session.Alerter != nil {
+		session.StatusCache.WithAlerter(session.Alerter)
 	}
 
-	ticker := time.NewTicker(time.Second)
+	if session.StatusCache != nil {
+		session.StatusCache.WithPlanner(planner)
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		for range ticker.C {
-			planner.Tick()
+	if session.SnapshotConfig != nil {
+		if session.StatusCache != nil {
+			session.StatusCache.WithSnapshots(session.SnapshotConfig)
+		} else {
+			log.Println("snapshots need a status cache to snapshot, ignoring")
 		}
-		wg.Done()
-	}()
+	}
+
+	if session.StatusCache != nil {
+		go session.StatusCache.Start()
+	}
+	for _, repo := range session.routedRepos() {
+		go repo.Start()
+	}
+	if session.StatusPage != nil {
+		go session.StatusPage.Start()
+	}
+
+	clock := clockOr(session.Clock)
+
+	var elector *leaderElector
+	if session.Leader != nil {
+		elector = leaderElectorNew(*session.Leader, clock)
+
+		electorCtx, cancel := context.WithCancel(context.Background())
+		electorDone := make(chan struct{})
+		go func() {
+			elector.run(electorCtx)
+			close(electorDone)
+		}()
+
+		// the lease is released once the events stopped running
+		defer func() {
+			cancel()
+			<-electorDone
+		}()
+	}
+
+	if session.Cluster != nil {
+		clusterer := clustererNew(*session.Cluster, planner, clock)
+
+		// the first ring is in place before any event runs
+		clusterer.refresh(context.Background())
+
+		clusterCtx, cancel := context.WithCancel(context.Background())
+		clusterDone := make(chan struct{})
+		go func() {
+			clusterer.run(clusterCtx)
+			close(clusterDone)
+		}()
+
+		defer func() {
+			cancel()
+			<-clusterDone
+		}()
+	}
+
+	for _, config := range session.Discovery {
+		discoverer := discovererNew(config, planner, clock, session.Defaults)
+
+		discoveryCtx, cancel := context.WithCancel(context.Background())
+		discoveryDone := make(chan struct{})
+		go func() {
+			discoverer.run(discoveryCtx)
+			close(discoveryDone)
+		}()
+
+		defer func() {
+			cancel()
+			<-discoveryDone
+		}()
+	}
+
+	ticker := clock.NewTicker(time.Second)
 	defer ticker.Stop()
 
+	var metricsCh <-chan time.Time
+	var metrics *selfMetricsCollector
+	if session.SelfMetrics > 0 && session.StatusCache != nil {
+		metricsTicker := clock.NewTicker(session.SelfMetrics)
+		defer metricsTicker.Stop()
+
+		metricsCh = metricsTicker.C()
+		now := clock.Now()
+		metrics = &selfMetricsCollector{
+			planner: planner,
+			alerter: session.Alerter,
+			elector: elector,
+			started: now,
+			lastAt:  now,
+		}
+	}
+
+	for {
+		select {
+		case <-ticker.C():
+			planner.tick(elector == nil || elector.IsLeader())
+		case now := <-metricsCh:
+			session.StatusCache.Update(SelfMetricsStatusKey, metrics.collect(now))
+		case <-ctx.Done():
+			ticker.Stop()
+
+			select {
+			case stopCtx := <-stopCh:
+				stopPlanner(stopCtx, planner)
+				shutdown(stopCtx, session)
+			default:
+				stopCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+				stopPlanner(stopCtx, planner)
+				shutdown(stopCtx, session)
+				cancel()
+			}
+			return
+		}
+	}
+}
+
+// stopPlanner stops the planner, and waits for the runs in flight, for
+// their results to be stored and alerted on before the shutdown.
+func stopPlanner(ctx context.Context, planner *Planner) {
+	if pending, err := planner.Stop(ctx); err != nil {
+		log.Println("stopped waiting for", pending, "events still running:", err)
+	}
+}
+
+// shutdown stops the status server, which flushes its snapshots,
+// delivers the pending alerts, and sends the pending metrics and
+// results. It gives up waiting once ctx is done.
+func shutdown(ctx context.Context, session Session) {
 	if session.StatusCache != nil {
-		session.StatusCache.Start()
-		defer session.StatusCache.Stop()
+		session.StatusCache.stop(ctx)
+	}
+	for _, repo := range session.routedRepos() {
+		repo.stop(ctx)
+	}
+	if session.StatusPage != nil {
+		session.StatusPage.stop(ctx)
+	}
+
+	if session.Alerter != nil {
+		session.Alerter.Shutdown(ctx)
+	}
+
+	if session.Metrics != nil {
+		session.Metrics.Close(ctx)
 	}
 
-	wg.Wait()
+	closeResultSinks(ctx, session.Sinks)
 }
@@ -0,0 +1,289 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMetricsPrefix = "cynic"
+
+	// metricsBuffer is how many samples wait to be sent before new
+	// ones are dropped.
+	metricsBuffer = 1024
+
+	metricsDialTimeout  = 5 * time.Second
+	metricsWriteTimeout = 5 * time.Second
+)
+
+// EventSample is the outcome of a run of an event, as sent to a
+// metrics emitter.
+type EventSample struct {
+	EventID uint64
+	Label   string
+	Group   string
+
+	// Tags are the tags of the event. They must not be changed.
+	Tags map[string]string
+
+	// Failed is true if any hook of the event reported a failure.
+	Failed bool
+
+	// Latency is how long the hooks of the event took.
+	Latency time.Duration
+
+	At time.Time
+}
+
+// MetricsFormat writes the lines of a sample, for metrics named
+// after name.
+type MetricsFormat func(name string, sample EventSample) []byte
+
+// MetricsEmitter sends per event success and failure counters, and
+// latency timings, to a metrics server after each run of an event.
+// Samples are sent in the background, and dropped if the server can't
+// keep up, so that events are never held back by metrics.
+type MetricsEmitter struct {
+	// dropped is first, to keep it aligned for atomic access on 32
+	// bit platforms.
+	dropped uint64
+
+	network string
+	addr    string
+	prefix  string
+	format  MetricsFormat
+
+	samples chan EventSample
+	done    chan struct{}
+
+	// mux guards closed, so that samples emitted after Close are
+	// dropped instead of sent on the closed channel.
+	mux    sync.RWMutex
+	closed bool
+
+	// conn is only used by the sending goroutine.
+	conn net.Conn
+}
+
+// StatsdEmitterNew creates an emitter that sends to statsd over udp,
+// at addr (host:port). Metrics are named prefix.group.label.
+func StatsdEmitterNew(addr, prefix string) (*MetricsEmitter, error) {
+	return MetricsEmitterNew("udp", addr, prefix, StatsdFormat)
+}
+
+// GraphiteEmitterNew creates an emitter that sends to graphite's
+// plaintext protocol over tcp, at addr (host:port). Metrics are named
+// prefix.group.label.
+func GraphiteEmitterNew(addr, prefix string) (*MetricsEmitter, error) {
+	return MetricsEmitterNew("tcp", addr, prefix, GraphiteFormat)
+}
+
+// MetricsEmitterNew creates an emitter that sends samples written by
+// format to addr, over the given network. An empty prefix defaults to
+// "cynic".
+func MetricsEmitterNew(network, addr, prefix string, format MetricsFormat) (*MetricsEmitter, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMetricsAddress, err.Error())
+	}
+
+	if prefix == "" {
+		prefix = defaultMetricsPrefix
+	}
+
+	emitter := &MetricsEmitter{
+		network: network,
+		addr:    addr,
+		prefix:  prefix,
+		format:  format,
+		samples: make(chan EventSample, metricsBuffer),
+		done:    make(chan struct{}),
+	}
+	go emitter.run()
+
+	return emitter, nil
+}
+
+// Emit queues a sample to be sent.
+func (s *MetricsEmitter) Emit(sample EventSample) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	if s.closed {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+
+	select {
+	case s.samples <- sample:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns how many samples were dropped because the queue was
+// full.
+func (s *MetricsEmitter) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close sends the queued samples, and stops the emitter. It gives up
+// on the samples once ctx is done. Samples emitted after Close are
+// dropped.
+func (s *MetricsEmitter) Close(ctx context.Context) {
+	s.mux.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.samples)
+	}
+	s.mux.Unlock()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
+
+func (s *MetricsEmitter) run() {
+	defer close(s.done)
+
+	for sample := range s.samples {
+		s.send(s.format(s.metricName(sample), sample))
+	}
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// send writes the lines of a sample, dialing the server first if
+// needed. On errors the connection is dropped, to be dialed again
+// with the next sample.
+func (s *MetricsEmitter) send(lines []byte) {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, metricsDialTimeout)
+		if err != nil {
+			log.Println("could not connect to metrics server: ", err)
+			return
+		}
+		s.conn = conn
+	}
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(metricsWriteTimeout)); err == nil {
+		_, err = s.conn.Write(lines)
+		if err == nil {
+			return
+		}
+		log.Println("could not send metrics: ", err)
+	}
+
+	s.conn.Close()
+	s.conn = nil
+}
+
+// metricName is the name of the metrics of the event of a sample:
+// the prefix, the group if any, and the label, or the id of the event
+// if it has no label.
+func (s *MetricsEmitter) metricName(sample EventSample) string {
+	parts := []string{s.prefix}
+	if sample.Group != "" {
+		parts = append(parts, sanitizeMetricName(sample.Group))
+	}
+
+	if sample.Label != "" {
+		parts = append(parts, sanitizeMetricName(sample.Label))
+	} else {
+		parts = append(parts, "event_"+strconv.FormatUint(sample.EventID, 10))
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// sanitizeMetricName replaces what statsd and graphite don't take in
+// a name component with underscores.
+func sanitizeMetricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// StatsdFormat writes a sample as a success or failure counter, and a
+// latency timing in milliseconds.
+func StatsdFormat(name string, sample EventSample) []byte {
+	outcome := "success"
+	if sample.Failed {
+		outcome = "failure"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s.%s:1|c\n", name, outcome)
+	fmt.Fprintf(&buf, "%s.latency:%s|ms\n", name, formatMilliseconds(sample.Latency))
+	return buf.Bytes()
+}
+
+// DogStatsdFormat is StatsdFormat, with the tags of the event of the
+// sample, as taken by DogStatsD and Telegraf.
+func DogStatsdFormat(name string, sample EventSample) []byte {
+	lines := StatsdFormat(name, sample)
+	if len(sample.Tags) == 0 {
+		return lines
+	}
+
+	tags := make([]string, 0, len(sample.Tags))
+	for _, key := range tagKeys(sample.Tags) {
+		tags = append(tags, sanitizeMetricName(key)+":"+sanitizeMetricName(sample.Tags[key]))
+	}
+	suffix := "|#" + strings.Join(tags, ",") + "\n"
+
+	return bytes.ReplaceAll(lines, []byte("\n"), []byte(suffix))
+}
+
+// GraphiteFormat writes a sample as success and failure values of 1
+// or 0, and a latency in milliseconds, at the time of the sample.
+func GraphiteFormat(name string, sample EventSample) []byte {
+	success, failure := 1, 0
+	if sample.Failed {
+		success, failure = 0, 1
+	}
+
+	ts := sample.At.Unix()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s.success %d %d\n", name, success, ts)
+	fmt.Fprintf(&buf, "%s.failure %d %d\n", name, failure, ts)
+	fmt.Fprintf(&buf, "%s.latency_ms %s %d\n", name, formatMilliseconds(sample.Latency), ts)
+	return buf.Bytes()
+}
+
+func formatMilliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
@@ -0,0 +1,118 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"sync"
+	"time"
+)
+
+// MuteRule silences the alerts that match it until the given
+// time. Every selector that is set must match; at least one selector
+// must be set.
+type MuteRule struct {
+	ID      uint64    `json:"id"`
+	EventID uint64    `json:"event_id,omitempty"`
+	Label   string    `json:"label,omitempty"`
+	Group   string    `json:"group,omitempty"`
+	Until   time.Time `json:"until"`
+	Reason  string    `json:"reason,omitempty"`
+
+	// Tags, if set, are tags the events must all have.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+type muteList struct {
+	mux    sync.Mutex
+	lastID uint64
+	rules  []MuteRule
+}
+
+// Matches returns true if the rule silences the given message at
+// the given time.
+func (s *MuteRule) Matches(msg *AlertMessage, now time.Time) bool {
+	if !now.Before(s.Until) {
+		return false
+	}
+
+	return (s.EventID == 0 || s.EventID == msg.EventID) &&
+		(s.Label == "" || s.Label == msg.Label) &&
+		(s.Group == "" || s.Group == msg.Group) &&
+		tagsMatch(s.Tags, msg.Tags)
+}
+
+func (s *muteList) add(rule MuteRule) (uint64, error) {
+	if rule.EventID == 0 && rule.Label == "" && rule.Group == "" && len(rule.Tags) == 0 {
+		return 0, ErrMuteRuleEmpty
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.lastID++
+	rule.ID = s.lastID
+	s.rules = append(s.rules, rule)
+
+	return rule.ID, nil
+}
+
+func (s *muteList) remove(id uint64) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for i, rule := range s.rules {
+		if rule.ID == id {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// active prunes the expired rules, and returns a copy of the rest.
+func (s *muteList) active(now time.Time) []MuteRule {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	keep := 0
+	for _, rule := range s.rules {
+		if now.Before(rule.Until) {
+			s.rules[keep] = rule
+			keep++
+		}
+	}
+	s.rules = s.rules[:keep]
+
+	ret := make([]MuteRule, len(s.rules))
+	copy(ret, s.rules)
+	return ret
+}
+
+func (s *muteList) isMuted(msg *AlertMessage, now time.Time) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for i := range s.rules {
+		if s.rules[i].Matches(msg, now) {
+			return true
+		}
+	}
+
+	return false
+}
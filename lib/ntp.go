@@ -0,0 +1,196 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	defaultNTPMaxOffset = time.Second
+	defaultNTPTimeout   = 5 * time.Second
+
+	// ntpEpochOffset is the seconds from 1900, where ntp time starts,
+	// to 1970.
+	ntpEpochOffset = 2208988800
+)
+
+// NTPCheckConfig checks the offset of the local clock against an ntp
+// server.
+type NTPCheckConfig struct {
+	// Server is the host of the ntp server, with port 123 unless it
+	// has one.
+	Server string `json:"server"`
+
+	// MaxOffset is how far the local clock may be off, either way.
+	// It defaults to a second.
+	MaxOffset ConfigDuration `json:"max_offset"`
+
+	// Timeout defaults to 5 seconds.
+	Timeout ConfigDuration `json:"timeout"`
+}
+
+// NTPResult is the answer of an ntp server. Offset is how far the
+// server clock is ahead of the local one, which is behind when it is
+// positive.
+type NTPResult struct {
+	Server   string  `json:"server"`
+	OffsetMs float64 `json:"offset_ms"`
+	DelayMs  float64 `json:"delay_ms"`
+	Stratum  int     `json:"stratum"`
+
+	// Drifted is set when the offset is over the limit of the check.
+	Drifted bool   `json:"drifted,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Offset returns the offset as a duration.
+func (s *NTPResult) Offset() time.Duration {
+	return time.Duration(s.OffsetMs * float64(time.Millisecond))
+}
+
+// QueryNTP asks an ntp server for its time, the simple way of SNTP,
+// and returns the offset of the local clock to it.
+func QueryNTP(ctx context.Context, server string) (NTPResult, error) {
+	result := NTPResult{Server: server}
+
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, "123")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return result, err
+		}
+	}
+
+	// no leap indicator, version 4, mode 3 (client)
+	request := make([]byte, 48)
+	request[0] = 4<<3 | 3
+
+	sent := time.Now()
+	origin := ntpTime(sent)
+	binary.BigEndian.PutUint64(request[40:], origin)
+
+	if _, err := conn.Write(request); err != nil {
+		return result, err
+	}
+
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	received := time.Now()
+	if err != nil {
+		return result, err
+	}
+
+	if n < 48 {
+		return result, fmt.Errorf("%w: short response", ErrNTPResponse)
+	}
+	if mode := response[0] & 0x7; mode != 4 && mode != 5 {
+		return result, fmt.Errorf("%w: not a server response", ErrNTPResponse)
+	}
+	if binary.BigEndian.Uint64(response[24:]) != origin {
+		return result, fmt.Errorf("%w: response to another request", ErrNTPResponse)
+	}
+	if response[0]>>6 == 3 {
+		return result, fmt.Errorf("%w: server clock is not synchronized", ErrNTPResponse)
+	}
+	if response[1] == 0 {
+		return result, fmt.Errorf("%w: server refused, %q", ErrNTPResponse, string(response[12:16]))
+	}
+
+	serverReceived := ntpTimeOf(binary.BigEndian.Uint64(response[32:]))
+	serverSent := ntpTimeOf(binary.BigEndian.Uint64(response[40:]))
+
+	offset := (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+	delay := received.Sub(sent) - serverSent.Sub(serverReceived)
+
+	result.OffsetMs = float64(offset) / float64(time.Millisecond)
+	result.DelayMs = float64(delay) / float64(time.Millisecond)
+	result.Stratum = int(response[1])
+
+	return result, nil
+}
+
+// ntpTime returns t as an ntp timestamp: seconds since 1900, and
+// their fraction, in 32 bits each.
+func ntpTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func ntpTimeOf(stamp uint64) time.Time {
+	secs := int64(stamp>>32) - ntpEpochOffset
+	nanos := (stamp & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(secs, int64(nanos))
+}
+
+// NTPHookNew returns a hook checking the local clock against an ntp
+// server. It fails when the server can't be reached, or the offset is
+// over the limit of config.
+func NTPHookNew(config NTPCheckConfig) HookSignature {
+	return ntpHookNew(config, "")
+}
+
+// ntpHookNew returns the hook of an ntp check, which also stores its
+// result in the status cache under key, unless it is empty.
+func ntpHookNew(config NTPCheckConfig, key string) HookSignature {
+	maxOffset := time.Duration(config.MaxOffset)
+	if maxOffset <= 0 {
+		maxOffset = defaultNTPMaxOffset
+	}
+
+	timeout := time.Duration(config.Timeout)
+	if timeout <= 0 {
+		timeout = defaultNTPTimeout
+	}
+
+	return func(params *HookParameters) (bool, interface{}) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		result, err := QueryNTP(ctx, config.Server)
+		cancel()
+
+		failed := true
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			offset := result.Offset()
+			result.Drifted = offset > maxOffset || offset < -maxOffset
+			failed = result.Drifted
+		}
+
+		if key != "" && params.Status != nil {
+			params.Status.Update(key, result)
+		}
+
+		return failed, result
+	}
+}
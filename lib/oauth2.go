@@ -0,0 +1,205 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOAuth2RefreshBefore = 30 * time.Second
+
+	// defaultOAuth2Lifetime is how long tokens are kept when the
+	// server does not say when they expire.
+	defaultOAuth2Lifetime = 5 * time.Minute
+)
+
+// OAuth2Config gets tokens with the client credentials grant of
+// OAuth2, for probes of authenticated apis.
+type OAuth2Config struct {
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+
+	// AuthInBody sends the client credentials in the body of the
+	// token request, instead of with basic auth, for servers that
+	// only take them there.
+	AuthInBody bool `json:"auth_in_body"`
+
+	// RefreshBefore is how long before they expire tokens are
+	// refreshed, at most half their lifetime. It defaults to 30
+	// seconds.
+	RefreshBefore ConfigDuration `json:"refresh_before"`
+}
+
+// OAuth2TokenSource fetches tokens, and keeps them until they are
+// about to expire.
+type OAuth2TokenSource struct {
+	config OAuth2Config
+	client *http.Client
+
+	mux       sync.Mutex
+	token     string
+	refreshAt time.Time
+	expiresAt time.Time
+}
+
+// OAuth2TokenSourceNew returns a token source for the config.
+func OAuth2TokenSourceNew(config OAuth2Config) *OAuth2TokenSource {
+	if config.RefreshBefore <= 0 {
+		config.RefreshBefore = ConfigDuration(defaultOAuth2RefreshBefore)
+	}
+
+	return &OAuth2TokenSource{config: config, client: http.DefaultClient}
+}
+
+// Token returns the current token, fetching a new one if there is
+// none, or it is about to expire. If fetching fails while the current
+// token is still valid, it is returned anyway.
+func (s *OAuth2TokenSource) Token(ctx context.Context) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now()
+	if s.token != "" && now.Before(s.refreshAt) {
+		return s.token, nil
+	}
+
+	token, lifetime, err := s.fetch(ctx)
+	if err != nil {
+		if s.token != "" && now.Before(s.expiresAt) {
+			log.Println("could not refresh oauth2 token, using the current one: ", err)
+			return s.token, nil
+		}
+		return "", err
+	}
+
+	refreshBefore := time.Duration(s.config.RefreshBefore)
+	if refreshBefore > lifetime/2 {
+		refreshBefore = lifetime / 2
+	}
+
+	s.token = token
+	s.expiresAt = now.Add(lifetime)
+	s.refreshAt = s.expiresAt.Add(-refreshBefore)
+
+	return token, nil
+}
+
+// Invalidate drops the current token, for the next one to be fetched
+// anew, like when it was refused.
+func (s *OAuth2TokenSource) Invalidate() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.token = ""
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (s *OAuth2TokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	if s.config.AuthInBody {
+		form.Set("client_id", s.config.ClientID)
+		form.Set("client_secret", s.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !s.config.AuthInBody {
+		req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrOAuth2Token, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", 0, fmt.Errorf("%w: %s: %s", ErrOAuth2Token, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var token oauth2TokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrOAuth2Token, err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, fmt.Errorf("%w: no access_token", ErrOAuth2Token)
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("%w: unsupported token_type %q", ErrOAuth2Token, token.TokenType)
+	}
+
+	lifetime := defaultOAuth2Lifetime
+	if token.ExpiresIn > 0 {
+		lifetime = time.Duration(token.ExpiresIn) * time.Second
+	}
+
+	return token.AccessToken, lifetime, nil
+}
+
+var (
+	oauth2SourcesMutex sync.Mutex
+	oauth2Sources      = map[string]*OAuth2TokenSource{}
+)
+
+// sharedOAuth2Source returns the token source of the config, shared by
+// the events with the same credentials, and kept over config reloads.
+func sharedOAuth2Source(config *OAuth2Config) *OAuth2TokenSource {
+	key := strings.Join([]string{
+		config.TokenURL,
+		config.ClientID,
+		config.ClientSecret,
+		strings.Join(config.Scopes, " "),
+		fmt.Sprint(config.AuthInBody, time.Duration(config.RefreshBefore)),
+	}, "\x00")
+
+	oauth2SourcesMutex.Lock()
+	defer oauth2SourcesMutex.Unlock()
+
+	source, ok := oauth2Sources[key]
+	if !ok {
+		source = OAuth2TokenSourceNew(*config)
+		oauth2Sources[key] = source
+	}
+	return source
+}
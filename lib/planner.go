@@ -18,29 +18,107 @@ limitations under the License.
 package cynic
 
 import (
-	"container/heap"
+	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type eventMap map[uint64]*Event
 
+// PlannerBackend is the structure a planner keeps its events in.
+type PlannerBackend int
+
+const (
+	// PlannerHeap keeps the events in a binary heap. It is the
+	// default.
+	PlannerHeap PlannerBackend = iota
+
+	// PlannerTimingWheel keeps the events in a hierarchical timing
+	// wheel, which adds and expires events in constant time. It suits
+	// planners with a lot of events.
+	PlannerTimingWheel
+)
+
+// eventScheduler is what a planner backend does.
+type eventScheduler interface {
+	Len() int
+	Push(event *Event)
+	Remove(id uint64) (*Event, bool)
+	Events() []*Event
+
+	// popDue removes and returns an event that expires by now, or
+	// nil if there is none.
+	popDue(now int) *Event
+}
+
 // Planner is a structure that manages events inserted with expiration
 // timestamps. The underlying data structures are magic, and you
 // shouldn't care about them, unless you're opening up the hatch and
 // stuff.
 type Planner struct {
-	events       EventQueue
+	// executions and hookFailures are first, to keep them aligned
+	// for atomic access on 32 bit platforms.
+	executions   uint64
+	hookFailures uint64
+	overlapSkips uint64
+
+	events       eventScheduler
 	ticks        int
 	uniqueEvents eventMap
 	mux          sync.Mutex
 	alerter      *Alerter
+	metrics      *MetricsEmitter
+	sinks        []ResultSink
+	location     string
+	hostLimiter  *HostLimiter
+	routes       []RepoRoute
+	transports   *TransportPool
+
+	// asyncSlots, if set, bounds the async hooks in flight.
+	asyncSlots chan struct{}
+
+	// journal, if set, records the scheduling decisions.
+	journal *PlannerJournal
+
+	// source and rng, if set, are what the jitter is drawn from.
+	source *countingSource
+	rng    *rand.Rand
+
+	// clock, if set, is what the execution windows of the events
+	// are checked against.
+	clock Clock
+
+	// epoch is when the planner was at tick zero, to tell when events
+	// were due, and lateness how late they ran.
+	epoch    time.Time
+	lateness plannerLateness
+
+	// shard, if set, is the ring deciding which events the planner
+	// runs, when it shares them with other instances.
+	shard *shard
+
+	// drain keeps track of the runs in flight, for Stop.
+	drain plannerDrain
 }
 
-// PlannerNew creates a new, empty, timing wheel.
+// PlannerNew creates a new, empty planner, keeping its events in a
+// heap.
 func PlannerNew() *Planner {
+	return PlannerNewWithBackend(PlannerHeap)
+}
+
+// PlannerNewWithBackend creates a new, empty planner, keeping its
+// events in the given backend.
+func PlannerNewWithBackend(backend PlannerBackend) *Planner {
 	var tw Planner
-	tw.events = make(EventQueue, 0)
+	switch backend {
+	case PlannerTimingWheel:
+		tw.events = timingWheelNew()
+	default:
+		tw.events = EventQueueNew()
+	}
 	tw.uniqueEvents = make(eventMap)
 	return &tw
 }
@@ -50,7 +128,7 @@ func PlannerNew() *Planner {
 func (s *Planner) Len() int {
 	s.mux.Lock()
 	defer s.mux.Unlock()
-	return len(s.events)
+	return s.events.Len()
 }
 
 func (s *Planner) String() string {
@@ -63,47 +141,136 @@ func (s *Planner) String() string {
 	str += mkline("=======================")
 	str += mkline("Events: \n")
 
-	for _, el := range s.events {
+	for _, el := range s.events.Events() {
 		str += mkline("  - " + el.String())
 	}
 	str += mkline("=======================")
 	return str
 }
 
-// Tick moves the cursor of the timing wheel, by one second.
+// Tick moves the planner forward by one second, and runs the events
+// that are due.
 func (s *Planner) Tick() {
+	s.tick(true)
+}
+
+// tick moves the planner forward by one second. The events that are
+// due are run if execute is set, and only rescheduled otherwise, which
+// keeps a standby planner in step with the leader. Stopped planners
+// don't move.
+func (s *Planner) tick(execute bool) {
+	if s.drain.isStopped() {
+		return
+	}
+
+	s.markTick()
+
 	for {
-		if s.events.Len() == 0 {
+		event := s.popExpired()
+		if event == nil {
 			break
 		}
 
-		rootTimestamp, _ := s.events.PeekTimestamp()
+		switch {
+		case !execute:
+			s.recordDecision(JournalEntry{Kind: JournalSkipped, Reason: journalReasonStandby}, event)
+		case !s.owns(event):
+			s.recordDecision(JournalEntry{Kind: JournalSkipped, Reason: journalReasonNotOwned}, event)
+		case event.IsDisabled():
+			s.recordDecision(JournalEntry{Kind: JournalSkipped, Reason: journalReasonDisabled}, event)
+		case !event.inWindows(clockOr(s.clock).Now()):
+			s.recordDecision(JournalEntry{Kind: JournalSkipped, Reason: journalReasonOutsideWindows}, event)
+		default:
+			s.fire(event)
+		}
 
-		if s.ticks >= int(rootTimestamp) {
-			event := heap.Pop(&s.events).(*Event)
+		if event.IsRepeating() {
+			s.Add(event)
+		}
+	}
 
-			if event.IsDeleted() {
-				continue
-			}
+	s.mux.Lock()
+	s.ticks++
+	s.mux.Unlock()
+}
 
-			event.Execute()
+// fire runs the event, in the background if it has an overlap policy,
+// which may skip the run.
+func (s *Planner) fire(event *Event) {
+	if !s.beginRun(event) {
+		s.recordDecision(JournalEntry{Kind: JournalSkipped, Reason: journalReasonStopped}, event)
+		return
+	}
 
-			if event.IsRepeating() {
-				s.Add(event)
-			}
-		} else {
-			break
+	if event.overlap == nil {
+		s.recordDecision(JournalEntry{Kind: JournalFired}, event)
+		s.recordLateness(event)
+		event.run()
+		s.endRun(event)
+		return
+	}
+
+	if !event.overlap.start(s, event) {
+		atomic.AddUint64(&s.overlapSkips, 1)
+		s.recordDecision(JournalEntry{Kind: JournalSkipped, Reason: journalReasonOverlapping}, event)
+		return
+	}
+
+	s.recordDecision(JournalEntry{Kind: JournalFired}, event)
+	s.recordLateness(event)
+}
+
+// owns returns whether the planner runs the event, rather than
+// another instance sharing its events.
+func (s *Planner) owns(event *Event) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.shard == nil || s.shard.ring.Owner(event) == s.shard.self
+}
+
+func (s *Planner) setShard(shard *shard) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.shard = shard
+}
+
+// popExpired removes and returns the next event that is due, or nil
+// if there is none. Deleted events are dropped along the way.
+func (s *Planner) popExpired() *Event {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for {
+		event := s.events.popDue(s.ticks)
+		if event == nil || !event.IsDeleted() {
+			return event
 		}
+		s.journal.record(JournalEntry{Tick: s.ticks, Kind: JournalSkippedDeleted}, event)
 	}
+}
 
-	s.ticks++
+// recordDecision records a scheduling decision about the event in the
+// journal, if the planner has one.
+func (s *Planner) recordDecision(entry JournalEntry, event *Event) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	entry.Tick = s.ticks
+	s.journal.record(entry, event)
 }
 
-// Add adds an event to the planner.
+// Add adds an event to the planner. Deleted events are ignored.
 func (s *Planner) Add(event *Event) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
+	if event.IsDeleted() {
+		s.journal.record(JournalEntry{Tick: s.ticks, Kind: JournalSkippedDeleted}, event)
+		return
+	}
+
 	var expiry int64
 
 	if event.IsImmediate() {
@@ -115,24 +282,63 @@ func (s *Planner) Add(event *Event) {
 		event.Immediate(false)
 		event.SetOffset(0)
 	} else {
-		expiry = int64(event.GetOffset() + event.GetSecs() + s.ticks)
+		// the offset only delays the first run, so that the runs after
+		// it keep the interval
+		expiry = int64(event.GetOffset() + event.GetSecs() + s.ticks + s.jitterOf(event))
+		event.SetOffset(0)
 	}
 
+	// events are routed when they are first added, unless they were
+	// given a repo of their own
+	if event.planner != s && event.overrides&settingRepo == 0 {
+		if repo := routeRepo(s.routes, event); repo != nil {
+			event.repo = repo
+		}
+	}
+
+	kind := JournalAdded
+	if _, ok := s.uniqueEvents[event.ID()]; ok {
+		kind = JournalRescheduled
+	}
+	s.journal.record(JournalEntry{Tick: s.ticks, Kind: kind, Expiry: expiry}, event)
+
 	s.uniqueEvents[event.ID()] = event
 	event.SetAbsExpiry(expiry)
-	event.setPlanner(s)
-	heap.Push(&s.events, event)
+
+	// the planner of a repeating event is only set once, as it may
+	// still be running in the background when it is added again
+	if event.planner != s {
+		event.setPlanner(s)
+	}
+	s.events.Push(event)
 }
 
-// Run runs the wheel, with a 1s tick.
+// Advance ticks the planner once for every second of d, running the
+// events that are due on the way.
+func (s *Planner) Advance(d time.Duration) {
+	for i := time.Duration(0); i < d/time.Second; i++ {
+		s.Tick()
+	}
+}
+
+// Run ticks the planner every second, in the background, until it is
+// stopped.
+//
+// Deprecated: use func Run(ctx context.Context, session Session), with
+// this planner as the Planner of the session, and cancel ctx to stop
+// it.
 func (s *Planner) Run() {
 	ticker := time.NewTicker(time.Second)
 	go func() {
+		defer ticker.Stop()
+
 		for range ticker.C {
+			if s.drain.isStopped() {
+				return
+			}
 			s.Tick()
 		}
 	}()
-	defer ticker.Stop()
 }
 
 // Delete marks a Event to be deleted. Returns true if event
@@ -146,12 +352,162 @@ func (s *Planner) Delete(event *Event) bool {
 	if value, ok := s.uniqueEvents[id]; ok {
 		value.Delete()
 		delete(s.uniqueEvents, id)
+		s.journal.record(JournalEntry{Tick: s.ticks, Kind: JournalDeleted}, value)
+		s.events.Remove(id)
+		s.lateness.forget(id)
 		return true
 	}
 
 	return false
 }
 
+// Find returns the planned event with the given id.
+func (s *Planner) Find(id uint64) (*Event, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	event, ok := s.uniqueEvents[id]
+	return event, ok
+}
+
+// RunNow runs the event with the given id right away, out of band, and
+// returns what it did. Its schedule is left as it is: it still runs
+// when it is due.
+func (s *Planner) RunNow(id uint64) (ExecutionResult, error) {
+	event, ok := s.Find(id)
+	if !ok {
+		return ExecutionResult{}, ErrEventNotFound
+	}
+
+	if !s.beginRun(event) {
+		return ExecutionResult{}, ErrPlannerStopped
+	}
+	defer s.endRun(event)
+
+	s.recordDecision(JournalEntry{Kind: JournalFired, Reason: journalReasonRunNow}, event)
+	return event.Execute(), nil
+}
+
+// PlannerState is a view of the planner, for inspection.
+type PlannerState struct {
+	Ticks  int          `json:"ticks"`
+	Queued int          `json:"queued"`
+	Events []EventState `json:"events"`
+}
+
+// EventState is a view of a planned event, for inspection.
+type EventState struct {
+	ID       uint64   `json:"id"`
+	Label    string   `json:"label"`
+	Group    string   `json:"group"`
+	Secs     int      `json:"secs"`
+	Repeat   bool     `json:"repeat"`
+	Severity Severity `json:"severity"`
+	Hooks    int      `json:"hooks"`
+
+	// NextTick is the planner tick the event runs on next.
+	NextTick int64 `json:"next_tick"`
+
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Disabled is set while the event is disabled, and its runs
+	// skipped.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Overlap is the overlap policy of the event, if it has one, with
+	// its runs going and waiting, and those it skipped.
+	Overlap     OverlapPolicy `json:"overlap,omitempty"`
+	Running     int           `json:"running,omitempty"`
+	QueuedRuns  int           `json:"queued_runs,omitempty"`
+	SkippedRuns uint64        `json:"skipped_runs,omitempty"`
+
+	// InsecureTLS is set when the probes of the event don't verify
+	// certificates.
+	InsecureTLS bool `json:"insecure_tls,omitempty"`
+}
+
+// State returns a view of the planner and its events, sorted by id.
+func (s *Planner) State() PlannerState {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	state := PlannerState{
+		Ticks:  s.ticks,
+		Queued: s.events.Len(),
+		Events: make([]EventState, 0, len(s.uniqueEvents)),
+	}
+
+	for _, event := range s.uniqueEvents {
+		state.Events = append(state.Events, event.state())
+	}
+
+	sort.Slice(state.Events, func(i, j int) bool {
+		return state.Events[i].ID < state.Events[j].ID
+	})
+
+	return state
+}
+
+// Disabled returns a view of the disabled events, sorted by id.
+func (s *Planner) Disabled() []EventState {
+	var disabled []EventState
+	for _, event := range s.State().Events {
+		if event.Disabled {
+			disabled = append(disabled, event)
+		}
+	}
+	return disabled
+}
+
+func (s *Planner) stateOf(event *Event) EventState {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return event.state()
+}
+
+// PlannerStats are counters of what the planner did.
+type PlannerStats struct {
+	Ticks  int `json:"ticks"`
+	Queued int `json:"queued"`
+
+	// Executions is how many times events ran.
+	Executions uint64 `json:"executions"`
+
+	// HookFailures is how many times hooks reported a failure.
+	HookFailures uint64 `json:"hook_failures"`
+
+	// OverlapSkips is how many runs were skipped because their event
+	// was still running.
+	OverlapSkips uint64 `json:"overlap_skips"`
+
+	// Lateness is how late the events ran, after they were due.
+	Lateness LatenessStats `json:"lateness"`
+}
+
+// Stats returns the counters of the planner.
+func (s *Planner) Stats() PlannerStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return PlannerStats{
+		Ticks:        s.ticks,
+		Queued:       s.events.Len(),
+		Executions:   atomic.LoadUint64(&s.executions),
+		HookFailures: atomic.LoadUint64(&s.hookFailures),
+		OverlapSkips: atomic.LoadUint64(&s.overlapSkips),
+		Lateness:     s.lateness.overallStats(),
+	}
+}
+
+// recordExecution counts a run of an event, and the hooks that failed
+// in it. Events run outside of the planner lock, so the counters are
+// atomic.
+func (s *Planner) recordExecution(failures int) {
+	atomic.AddUint64(&s.executions, 1)
+	atomic.AddUint64(&s.hookFailures, uint64(failures))
+}
+
 // GetAlerter gets the assigned alerter of planner.
 func (s *Planner) GetAlerter() *Alerter {
 	return s.alerter
@@ -161,3 +517,105 @@ func (s *Planner) GetAlerter() *Alerter {
 func (s *Planner) SetAlerter(alerter *Alerter) {
 	s.alerter = alerter
 }
+
+// AddSink adds a sink the results of the events of the planner are
+// given to. Sinks should be added before the planner runs.
+func (s *Planner) AddSink(sink ResultSink) {
+	s.sinks = append(s.sinks, sink)
+}
+
+// SetLocation sets where the planner probes from, like a region or a
+// datacenter, which the results and alerts of its events carry, so
+// that an outage seen from one location can be told apart from one
+// seen from all of them. It should be set before the planner runs.
+func (s *Planner) SetLocation(location string) {
+	s.location = location
+}
+
+// SetHostLimiter sets the limits the hooks of the events of the
+// planner make requests within. It should be set before the planner
+// runs.
+func (s *Planner) SetHostLimiter(limiter *HostLimiter) {
+	s.hostLimiter = limiter
+}
+
+// SetTransportPool sets the transports the hooks of the events of the
+// planner make requests with. It should be set before the planner
+// runs.
+func (s *Planner) SetTransportPool(pool *TransportPool) {
+	s.transports = pool
+}
+
+// SetClock sets the clock the execution windows of the events are
+// checked against, and their runs, heartbeats and pushes are timed
+// with. It should be set before the planner runs.
+func (s *Planner) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+// now returns the time of the clock of the planner. Events without a
+// planner use the system clock.
+func (s *Planner) now() time.Time {
+	if s == nil {
+		return SystemClock.Now()
+	}
+	return clockOr(s.clock).Now()
+}
+
+// SetAsyncLimit bounds how many async hooks of the events of the
+// planner may be in flight at once. Zero is no limit. It should be set
+// before the planner runs.
+func (s *Planner) SetAsyncLimit(limit int) {
+	s.asyncSlots = nil
+	if limit > 0 {
+		s.asyncSlots = make(chan struct{}, limit)
+	}
+}
+
+// acquireAsync takes a slot for an async hook, if there is one free.
+// The returned release must be called once the hook completed.
+func (s *Planner) acquireAsync() (release func(), ok bool) {
+	if s == nil || s.asyncSlots == nil {
+		return func() {}, true
+	}
+
+	select {
+	case s.asyncSlots <- struct{}{}:
+		return func() { <-s.asyncSlots }, true
+	default:
+		return nil, false
+	}
+}
+
+// SetJournal makes the planner record its scheduling decisions in the
+// journal. It should be set before events are added.
+func (s *Planner) SetJournal(journal *PlannerJournal) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.journal = journal
+}
+
+// Journal returns the journal of the planner, or nil if it has none.
+func (s *Planner) Journal() *PlannerJournal {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.journal
+}
+
+// SetRepoRoutes sets where the events added from now on store their
+// results, by the first route they match. Events given a repo of their
+// own keep it.
+func (s *Planner) SetRepoRoutes(routes ...RepoRoute) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.routes = routes
+}
+
+// SetMetrics sets the emitter the events of the planner send their
+// metrics to.
+func (s *Planner) SetMetrics(metrics *MetricsEmitter) {
+	s.metrics = metrics
+}
@@ -0,0 +1,189 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultPlannerJournalCapacity is how many journal entries are kept
+// in memory, unless configured otherwise.
+const DefaultPlannerJournalCapacity = 10000
+
+// JournalEntryKind is the scheduling decision a journal entry records.
+type JournalEntryKind string
+
+const (
+	// JournalAdded is recorded when an event is added to the planner.
+	JournalAdded JournalEntryKind = "added"
+
+	// JournalRescheduled is recorded when a repeating event is planned
+	// again, after it was due.
+	JournalRescheduled JournalEntryKind = "rescheduled"
+
+	// JournalFired is recorded when an event is run.
+	JournalFired JournalEntryKind = "fired"
+
+	// JournalSkipped is recorded when an event was due, but not run
+	// by this planner, for the reason of the entry.
+	JournalSkipped JournalEntryKind = "skipped"
+
+	// JournalDeleted is recorded when an event is deleted.
+	JournalDeleted JournalEntryKind = "deleted"
+
+	// JournalSkippedDeleted is recorded when an event is dropped
+	// instead of being run or added, as it was deleted.
+	JournalSkippedDeleted JournalEntryKind = "skipped_deleted"
+)
+
+// Reasons of the journal entries of events that were not run.
+const (
+	journalReasonStandby  = "standby"
+	journalReasonNotOwned = "owned by another instance"
+	journalReasonRunNow   = "run now"
+	journalReasonDisabled = "disabled"
+	journalReasonStopped  = "stopped"
+
+	journalReasonOutsideWindows = "outside its execution windows"
+	journalReasonOverlapping    = "still running"
+)
+
+// JournalEntry is a scheduling decision of the planner.
+type JournalEntry struct {
+	Time    time.Time        `json:"time"`
+	Tick    int              `json:"tick"`
+	Kind    JournalEntryKind `json:"kind"`
+	EventID uint64           `json:"event_id"`
+	Label   string           `json:"label,omitempty"`
+
+	// Expiry is the tick the event is planned to run on, when it is
+	// added or rescheduled.
+	Expiry int64 `json:"expiry,omitempty"`
+
+	Reason string `json:"reason,omitempty"`
+}
+
+// PlannerJournalConfig configures the journal of a planner.
+type PlannerJournalConfig struct {
+	// Capacity is how many entries are kept in memory.
+	Capacity int
+
+	// Path is an optional json lines file every entry is appended
+	// to. Unlike the alert history, it is not read back, as the ticks
+	// of the planner start over.
+	Path string
+
+	// Clock tells the time of the entries. Defaults to the system
+	// clock.
+	Clock Clock
+}
+
+// JournalFilter selects journal entries. Zero values match everything.
+type JournalFilter struct {
+	Since   time.Time
+	EventID uint64
+	Kind    JournalEntryKind
+}
+
+// PlannerJournal records the scheduling decisions of a planner, to
+// find out why an event did or didn't run when it was expected to.
+type PlannerJournal struct {
+	config  PlannerJournalConfig
+	clock   Clock
+	mux     sync.Mutex
+	entries []JournalEntry
+}
+
+// PlannerJournalNew creates a planner journal, to give to
+// Planner.SetJournal.
+func PlannerJournalNew(config PlannerJournalConfig) *PlannerJournal {
+	if config.Capacity <= 0 {
+		config.Capacity = DefaultPlannerJournalCapacity
+	}
+
+	return &PlannerJournal{config: config, clock: clockOr(config.Clock)}
+}
+
+// Entries returns the entries matching the filter, oldest first.
+func (s *PlannerJournal) Entries(filter JournalFilter) []JournalEntry {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := make([]JournalEntry, 0)
+	for i := range s.entries {
+		if filter.matches(&s.entries[i]) {
+			ret = append(ret, s.entries[i])
+		}
+	}
+
+	return ret
+}
+
+func (s *JournalFilter) matches(entry *JournalEntry) bool {
+	return (s.Since.IsZero() || !entry.Time.Before(s.Since)) &&
+		(s.EventID == 0 || s.EventID == entry.EventID) &&
+		(s.Kind == "" || s.Kind == entry.Kind)
+}
+
+// record adds an entry about the event. It is nil safe, so that the
+// planner records its decisions whether it has a journal or not.
+func (s *PlannerJournal) record(entry JournalEntry, event *Event) {
+	if s == nil {
+		return
+	}
+
+	// the entry escapes in add, which would allocate it on every
+	// decision of planners without a journal, if it were done here
+	s.add(entry, event)
+}
+
+func (s *PlannerJournal) add(entry JournalEntry, event *Event) {
+	entry.Time = s.clock.Now()
+	entry.EventID = event.ID()
+	entry.Label = event.Label
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.entries = append(s.entries, entry)
+	if len(s.entries) > s.config.Capacity {
+		s.entries = s.entries[len(s.entries)-s.config.Capacity:]
+	}
+
+	if err := s.appendToFile(&entry); err != nil {
+		log.Println("problem persisting journal entry: ", err)
+	}
+}
+
+func (s *PlannerJournal) appendToFile(entry *JournalEntry) error {
+	if s.config.Path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(s.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(entry)
+}
@@ -0,0 +1,205 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// eventLatenessSamples and plannerLatenessSamples are how many of
+	// the last runs the percentiles are over, for each event and for
+	// the planner.
+	eventLatenessSamples   = 128
+	plannerLatenessSamples = 1024
+)
+
+// LatenessStats describe how late runs started, after they were due.
+// Percentiles are over the last runs.
+type LatenessStats struct {
+	// Runs is how many runs were counted, overall.
+	Runs uint64 `json:"runs"`
+
+	P50 time.Duration `json:"p50_ns"`
+	P90 time.Duration `json:"p90_ns"`
+	P99 time.Duration `json:"p99_ns"`
+	Max time.Duration `json:"max_ns"`
+}
+
+// EventLateness is how late the runs of an event started.
+type EventLateness struct {
+	EventID uint64 `json:"event_id"`
+	Label   string `json:"label"`
+	Group   string `json:"group"`
+
+	LatenessStats
+}
+
+// LatenessReport is how late the runs of the events of a planner
+// started, overall and per event.
+type LatenessReport struct {
+	Overall LatenessStats   `json:"overall"`
+	Events  []EventLateness `json:"events"`
+}
+
+// plannerLateness keeps the lateness of the last runs. A run is late
+// when the planner ticks behind the clock, as when it is overloaded,
+// or when the hooks of the events due before it on the same tick are
+// slow.
+type plannerLateness struct {
+	mux     sync.Mutex
+	overall latenessRing
+	events  map[uint64]*eventLatenessRing
+}
+
+type eventLatenessRing struct {
+	label string
+	group string
+	latenessRing
+}
+
+// latenessRing is the lateness of the last runs.
+type latenessRing struct {
+	samples []time.Duration
+	next    int
+	runs    uint64
+}
+
+func (s *latenessRing) add(lateness time.Duration, capacity int) {
+	s.runs++
+	if len(s.samples) < capacity {
+		s.samples = append(s.samples, lateness)
+		return
+	}
+	s.samples[s.next] = lateness
+	s.next = (s.next + 1) % capacity
+}
+
+func (s *latenessRing) stats() LatenessStats {
+	stats := LatenessStats{Runs: s.runs}
+	if len(s.samples) == 0 {
+		return stats
+	}
+
+	sorted := make([]time.Duration, len(s.samples))
+	copy(sorted, s.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+
+	stats.P50 = percentile(50)
+	stats.P90 = percentile(90)
+	stats.P99 = percentile(99)
+	stats.Max = sorted[len(sorted)-1]
+	return stats
+}
+
+func (s *plannerLateness) record(event *Event, lateness time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.events == nil {
+		s.events = make(map[uint64]*eventLatenessRing)
+	}
+
+	ring, ok := s.events[event.id]
+	if !ok {
+		ring = &eventLatenessRing{}
+		s.events[event.id] = ring
+	}
+	ring.label, ring.group = event.Label, event.Group
+
+	ring.add(lateness, eventLatenessSamples)
+	s.overall.add(lateness, plannerLatenessSamples)
+}
+
+func (s *plannerLateness) forget(id uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.events, id)
+}
+
+func (s *plannerLateness) overallStats() LatenessStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.overall.stats()
+}
+
+func (s *plannerLateness) report() LatenessReport {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	report := LatenessReport{
+		Overall: s.overall.stats(),
+		Events:  make([]EventLateness, 0, len(s.events)),
+	}
+
+	for id, ring := range s.events {
+		report.Events = append(report.Events, EventLateness{
+			EventID:       id,
+			Label:         ring.label,
+			Group:         ring.group,
+			LatenessStats: ring.stats(),
+		})
+	}
+
+	sort.Slice(report.Events, func(i, j int) bool {
+		return report.Events[i].EventID < report.Events[j].EventID
+	})
+
+	return report
+}
+
+// markTick notes when the planner ticked, the first time, to tell
+// when the ticks after it are due.
+func (s *Planner) markTick() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.epoch.IsZero() {
+		s.epoch = clockOr(s.clock).Now().Add(-time.Duration(s.ticks) * time.Second)
+	}
+}
+
+// recordLateness records how late the event starts running, after
+// the time its tick was due. Runs ahead of the clock, as when the
+// planner is advanced by hand, count as on time.
+func (s *Planner) recordLateness(event *Event) {
+	s.mux.Lock()
+	due := s.epoch.Add(time.Duration(event.GetAbsExpiry()) * time.Second)
+	s.mux.Unlock()
+
+	lateness := clockOr(s.clock).Now().Sub(due)
+	if lateness < 0 {
+		lateness = 0
+	}
+
+	s.lateness.record(event, lateness)
+}
+
+// Lateness returns how late the runs of the events started, after
+// they were due, overall and per event.
+func (s *Planner) Lateness() LatenessReport {
+	return s.lateness.report()
+}
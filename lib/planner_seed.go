@@ -0,0 +1,82 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import "math/rand"
+
+// countingSource is a source of random numbers that counts its draws,
+// so that a copy of it can be made by seeding a new one the same way,
+// and drawing as many numbers.
+type countingSource struct {
+	seed  int64
+	draws uint64
+	src   rand.Source
+}
+
+func countingSourceNew(seed int64) *countingSource {
+	return &countingSource{seed: seed, src: rand.NewSource(seed)}
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.seed = seed
+	s.draws = 0
+	s.src.Seed(seed)
+}
+
+// clone returns a source that draws the same numbers as this one from
+// now on.
+func (s *countingSource) clone() *countingSource {
+	clone := countingSourceNew(s.seed)
+	for clone.draws < s.draws {
+		clone.Int63()
+	}
+	return clone
+}
+
+// SetSeed makes the planner draw the jitter of its events from a
+// random source with the seed, so that its schedule, and its
+// simulations, are the same on every run. Instances running the same
+// events, like a leader and its standby, should be given different
+// seeds, so that they don't probe in step. Without a seed, the jitter
+// is drawn from the global source.
+func (s *Planner) SetSeed(seed int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.source = countingSourceNew(seed)
+	s.rng = rand.New(s.source) // #nosec
+}
+
+// jitterOf draws the jitter of a run of the event, up to its jitter.
+// The planner must be locked.
+func (s *Planner) jitterOf(event *Event) int {
+	jitter := event.GetJitter()
+	if jitter <= 0 {
+		return 0
+	}
+
+	if s.rng == nil {
+		return rand.Intn(jitter + 1) // #nosec
+	}
+	return s.rng.Intn(jitter + 1)
+}
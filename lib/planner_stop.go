@@ -0,0 +1,166 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"sync"
+)
+
+// plannerDrain keeps track of the runs of the events of a planner in
+// flight, for the planner to be stopped once they are done.
+type plannerDrain struct {
+	mux     sync.Mutex
+	stopped bool
+
+	// running counts the runs in flight, by event id.
+	running map[uint64]int
+
+	// idle, if set, is closed once no run is in flight.
+	idle chan struct{}
+
+	// done, if set, is closed once the planner is stopped.
+	done chan struct{}
+}
+
+// begin records a run of the event starting, unless the planner was
+// stopped.
+func (s *plannerDrain) begin(event *Event) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.stopped {
+		return false
+	}
+
+	if s.running == nil {
+		s.running = make(map[uint64]int)
+	}
+	s.running[event.ID()]++
+	return true
+}
+
+// end records a run of the event completing.
+func (s *plannerDrain) end(event *Event) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.running[event.ID()]--; s.running[event.ID()] <= 0 {
+		delete(s.running, event.ID())
+	}
+
+	if len(s.running) == 0 && s.idle != nil {
+		close(s.idle)
+		s.idle = nil
+	}
+}
+
+// stop refuses the runs starting from now on, and returns a channel
+// closed once the runs in flight are done.
+func (s *plannerDrain) stop() <-chan struct{} {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.stopped && s.done != nil {
+		close(s.done)
+	}
+	s.stopped = true
+
+	idle := make(chan struct{})
+	if len(s.running) == 0 {
+		close(idle)
+		return idle
+	}
+
+	if s.idle == nil {
+		s.idle = idle
+	}
+	return s.idle
+}
+
+// stopping returns a channel closed once the planner is stopped, for
+// runs waiting on something to give up.
+func (s *plannerDrain) stopping() <-chan struct{} {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.done == nil {
+		s.done = make(chan struct{})
+		if s.stopped {
+			close(s.done)
+		}
+	}
+	return s.done
+}
+
+func (s *plannerDrain) isStopped() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.stopped
+}
+
+// pending returns how many events have runs in flight.
+func (s *plannerDrain) pending() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return len(s.running)
+}
+
+// Stop stops the planner: it ignores its ticks from now on, and no
+// run of its events starts anymore, whether due, run now, queued
+// behind an overlapping run or async. It then waits for the runs in
+// flight, and their hooks, until ctx is done, and returns how many
+// events were still running, with the error of ctx. Stopping twice is
+// fine.
+func (s *Planner) Stop(ctx context.Context) (int, error) {
+	idle := s.drain.stop()
+
+	select {
+	case <-idle:
+		return 0, nil
+	case <-ctx.Done():
+		return s.drain.pending(), ctx.Err()
+	}
+}
+
+// beginRun records a run of the event starting, unless the planner
+// was stopped. Events without a planner always run.
+func (s *Planner) beginRun(event *Event) bool {
+	if s == nil {
+		return true
+	}
+	return s.drain.begin(event)
+}
+
+// stopping returns a channel closed once the planner is stopped.
+// Events without a planner are never stopped.
+func (s *Planner) stopping() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.drain.stopping()
+}
+
+// endRun records a run of the event, begun with beginRun, completing.
+func (s *Planner) endRun(event *Event) {
+	if s != nil {
+		s.drain.end(event)
+	}
+}
@@ -0,0 +1,464 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProbeTimeout = 10 * time.Second
+
+	// defaultProbeBodyLimit is how much of a probed body is read, to
+	// check contracts and thresholds against, unless the event says
+	// otherwise.
+	defaultProbeBodyLimit = 4 << 20
+)
+
+// ProbeResult is what the http probe of an event stores in the status
+// cache, and sends along with its alerts.
+type ProbeResult struct {
+	URL       string   `json:"url"`
+	Location  string   `json:"location,omitempty"`
+	Status    int      `json:"status"`
+	LatencyMs int64    `json:"latency_ms"`
+	Error     string   `json:"error,omitempty"`
+	Failures  []string `json:"failures,omitempty"`
+
+	// Attempts is how many requests the probe took, with retries.
+	Attempts int `json:"attempts,omitempty"`
+
+	// Throttled is set when the probe was not made, as it would have
+	// gone over the host limits. The run of the event is then
+	// inconclusive, rather than a success.
+	Throttled bool `json:"throttled,omitempty"`
+
+	// CircuitOpen is set when the probe was not made, as the target
+	// failed too many times in a row, and is cooling down.
+	CircuitOpen bool `json:"circuit_open,omitempty"`
+
+	// Values are the numbers the thresholds of the event found in
+	// the body, by path.
+	Values map[string]float64 `json:"values,omitempty"`
+
+	// Tags are the tags of the event. They must not be changed.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Reused is set when the probe was made on a connection kept
+	// open from an earlier one.
+	Reused bool `json:"reused,omitempty"`
+
+	// Connections count the connections the probes of the event were
+	// made on, new or reused.
+	Connections *ConnectionStats `json:"connections,omitempty"`
+
+	// Family is the address family the probe was forced over, if it
+	// was.
+	Family string `json:"family,omitempty"`
+
+	// Truncated is set when the body was over the size limit of the
+	// event, and only its beginning was checked.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Insecure is set when the certificate of the url was not
+	// verified.
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Timings break the latency of the probe down, to tell a slow
+	// lookup or handshake from a slow server.
+	Timings *ProbeTimings `json:"timings,omitempty"`
+
+	// connected is set once the probe got a connection.
+	connected bool
+
+	// body is what was read of the body, until the thresholds are
+	// checked. Bodies of events with thresholds only are decoded as
+	// they are read, into document, instead.
+	body     []byte
+	document *probeDocument
+
+	// capture is what the probe saw, for events capturing their
+	// failures.
+	capture *ProbeCapture
+}
+
+// probeDocument is a json body decoded as it was read.
+type probeDocument struct {
+	value interface{}
+	err   error
+}
+
+// jsonBody returns the body decoded as json.
+func (s *ProbeResult) jsonBody() (interface{}, error) {
+	if s.document != nil {
+		return s.document.value, s.document.err
+	}
+
+	var doc interface{}
+	err := json.Unmarshal(s.body, &doc)
+	return doc, err
+}
+
+// httpProbeHookNew returns a hook that GETs the url of the event, and
+// alerts if any of its contracts fail, or its thresholds are crossed.
+// The result is stored in the status cache under the probe key of the
+// event. With a circuit breaker, probes that fail to get a response
+// open the circuit, once they ran out of retries.
+func httpProbeHookNew(config *EventConfig, client *http.Client, breaker *CircuitBreaker,
+	thresholds *ThresholdRules) HookSignature {
+	probeConfig := *config
+	insecure := config.TLS != nil && config.TLS.InsecureSkipVerify
+	contracts := config.Contracts
+	key := config.probeKey()
+
+	timeout := defaultProbeTimeout
+	for _, contract := range contracts {
+		if maxLatency := time.Duration(contract.MaxLatency); maxLatency > 0 && 2*maxLatency < timeout {
+			timeout = 2 * maxLatency
+		}
+	}
+
+	var connections ConnectionStats
+
+	return func(params *HookParameters) (bool, interface{}) {
+		hookTimeout := timeout
+		if params.Timeout > 0 {
+			hookTimeout = params.Timeout
+		}
+
+		result := probeConfig.guardedProbe(params, client, breaker, hookTimeout)
+		if result.Throttled {
+			params.Inconclusive()
+		}
+		result.Family = probeConfig.Family
+		result.Insecure = insecure
+		result.Location = params.Location
+		result.Tags = params.Tags
+
+		if result.connected {
+			connections.record(result.Reused)
+		}
+		stats := connections.load()
+		result.Connections = &stats
+
+		if thresholds != nil && result.Error == "" && (result.body != nil || result.document != nil) {
+			if doc, err := result.jsonBody(); err != nil {
+				result.Failures = append(result.Failures, fmt.Sprintf("%v: body is not json: %v", ErrProbeThreshold, err))
+			} else {
+				values, failures := thresholds.CheckDocument(doc, time.Now())
+				result.Values = values
+				result.Failures = append(result.Failures, failures...)
+			}
+		}
+		result.body, result.document = nil, nil
+
+		failed := result.Error != "" || len(result.Failures) > 0 || result.CircuitOpen
+		if failed && result.capture != nil && params.Status != nil {
+			result.capture.Error, result.capture.Failures = result.Error, result.Failures
+			params.Status.setCapture(key, result.capture)
+		}
+		result.capture = nil
+
+		if params.Status != nil {
+			params.Status.Update(key, result)
+		}
+
+		return failed, result
+	}
+}
+
+// guardedProbe probes the url of the event, unless its circuit is
+// open, or the probe would go over the host limits. The client of the
+// transports of params is used, unless client is set.
+func (s *EventConfig) guardedProbe(params *HookParameters, client *http.Client, breaker *CircuitBreaker,
+	timeout time.Duration) ProbeResult {
+	url := s.URL
+	if breaker != nil && !breaker.Allow() {
+		return ProbeResult{URL: url, CircuitOpen: true}
+	}
+
+	release, ok := acquireHost(params.HostLimiter, url)
+	if !ok {
+		if breaker != nil {
+			breaker.abort()
+		}
+		return ProbeResult{URL: url, Throttled: true}
+	}
+
+	result := s.probeWithRetries(params, client, timeout)
+	release()
+
+	if breaker != nil {
+		// only the first opening and the closing are logged, and not
+		// every failed probe of a half open circuit
+		before := breaker.State()
+		after := breaker.Record(result.Error != "")
+		if before == CircuitClosed && after == CircuitOpen {
+			log.Println("circuit open, not probing for a while:", url)
+		} else if before != CircuitClosed && after == CircuitClosed {
+			log.Println("circuit closed, probing again:", url)
+		}
+	}
+
+	return result
+}
+
+// probeWithRetries probes the url of the event until an attempt
+// succeeds, or its retry policy gives up. Each attempt has its own
+// timeout. The retries wait no longer than half the interval of the
+// event in total, and not at all once the planner is stopped.
+func (s *EventConfig) probeWithRetries(params *HookParameters, client *http.Client, timeout time.Duration) ProbeResult {
+	if client == nil {
+		client = params.Transports.client(s.URL, s.Family)
+	}
+
+	attempts := 1
+	var backoff time.Duration
+	if s.Retry != nil {
+		attempts = s.Retry.Attempts
+		backoff = time.Duration(s.Retry.Backoff)
+	}
+	limit := time.Duration(s.Interval) / 2
+	var waited time.Duration
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		result := s.probe(ctx, client, params.Headers)
+		cancel()
+
+		result.Attempts = attempt
+		if attempt >= attempts || !s.Retry.retries(&result) {
+			return result
+		}
+
+		wait := backoff
+		if limit > 0 && waited+wait > limit {
+			wait = limit - waited
+			if wait <= 0 {
+				return result
+			}
+		}
+		if !waitRetry(params.Planner.stopping(), wait) {
+			return result
+		}
+		waited += wait
+		backoff *= 2
+	}
+}
+
+// waitRetry waits for d, and returns whether it did, or whether
+// stopping was closed first.
+func waitRetry(stopping <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stopping:
+		return false
+	}
+}
+
+// retries returns whether a probe with the result is tried again.
+// Probes that got no response are, and so are those that got a server
+// error, with On5xx.
+func (s *RetryConfig) retries(result *ProbeResult) bool {
+	return result.Error != "" || (s.On5xx && result.Status >= 500)
+}
+
+// acquireHost acquires the host of url from limiter, if there is one.
+func acquireHost(limiter *HostLimiter, url string) (release func(), ok bool) {
+	if limiter == nil {
+		return func() {}, true
+	}
+	return limiter.AcquireURL(url)
+}
+
+// probe GETs the url of the event once, with client, and checks its
+// contracts.
+func (s *EventConfig) probe(ctx context.Context, client *http.Client, headers map[string]string) ProbeResult {
+	result := ProbeResult{URL: s.URL}
+
+	// urls over unix sockets are requested from localhost, the client
+	// dialing the socket
+	target := s.URL
+	if isUnixURL(target) {
+		var err error
+		if _, target, err = unixTarget(s.URL); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req = withConnTrace(req, func(reused bool) {
+		result.connected = true
+		result.Reused = reused
+	})
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	var tokens *OAuth2TokenSource
+	if s.OAuth2 != nil {
+		tokens = sharedOAuth2Source(s.OAuth2)
+		token, err := tokens.Token(ctx)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	timer := probeTimerNew()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), timer.trace()))
+
+	start := timer.start
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		timings := timer.timings(time.Now())
+		result.Timings = &timings
+		if s.Capture != nil {
+			result.capture = s.captureNew(req, nil, nil, timer)
+			result.capture.Timings = timings
+		}
+		return result
+	}
+	defer resp.Body.Close()
+
+	// a refused token is fetched anew on the next probe
+	if tokens != nil && resp.StatusCode == http.StatusUnauthorized {
+		tokens.Invalidate()
+	}
+
+	// one more byte than the limit is read, to tell whether the body
+	// was over it
+	limit := s.bodyLimit()
+	limited := &io.LimitedReader{R: resp.Body, N: limit + 1}
+
+	var body []byte
+	if s.streamsBody() {
+		var doc interface{}
+		err = json.NewDecoder(limited).Decode(&doc)
+		result.document = &probeDocument{value: doc, err: err}
+		err = nil
+	} else {
+		body, err = ioutil.ReadAll(limited)
+	}
+	end := time.Now()
+	latency := end.Sub(start)
+
+	if limited.N == 0 {
+		result.Truncated = true
+		if len(body) > int(limit) {
+			body = body[:limit]
+		}
+		if result.document != nil {
+			result.document.err = fmt.Errorf("truncated at %d bytes", limit)
+		}
+	} else {
+		// the rest of the body is drained, for the connection to be
+		// reused
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, limit))
+	}
+
+	result.Status = resp.StatusCode
+	result.LatencyMs = latency.Milliseconds()
+	timings := timer.timings(end)
+	result.Timings = &timings
+	if s.Capture != nil {
+		result.capture = s.captureNew(req, resp, body, timer)
+		result.capture.Timings = timings
+		result.capture.BodyTruncated = result.capture.BodyTruncated || result.Truncated
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.body = body
+
+	for _, contract := range s.Contracts {
+		if err := contract.check(resp.StatusCode, string(body), latency); err != nil {
+			result.Failures = append(result.Failures, err.Error())
+		}
+	}
+
+	if s.Golden != nil {
+		if err := s.Golden.check(resp.StatusCode, body, result.Truncated); err != nil {
+			result.Failures = append(result.Failures, err.Error())
+		}
+	}
+
+	return result
+}
+
+// bodyLimit returns how much of the body of the url is read.
+func (s *EventConfig) bodyLimit() int64 {
+	if s.MaxBodyBytes > 0 {
+		return s.MaxBodyBytes
+	}
+	return defaultProbeBodyLimit
+}
+
+// streamsBody returns whether the body of the url is decoded as json
+// as it is read, which it is when only thresholds need it, and it is
+// neither captured nor compared to a golden fixture.
+func (s *EventConfig) streamsBody() bool {
+	if len(s.Thresholds) == 0 || s.Capture != nil || s.Golden != nil {
+		return false
+	}
+
+	for _, contract := range s.Contracts {
+		if contract.Contains != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *ContractConfig) check(status int, body string, latency time.Duration) error {
+	if s.Status != 0 && status != s.Status {
+		return fmt.Errorf("%w: expected status %d, got %d", ErrProbeContract, s.Status, status)
+	}
+
+	if s.Contains != "" && !strings.Contains(body, s.Contains) {
+		return fmt.Errorf("%w: body does not contain %q", ErrProbeContract, s.Contains)
+	}
+
+	if maxLatency := time.Duration(s.MaxLatency); maxLatency > 0 && latency > maxLatency {
+		return fmt.Errorf("%w: latency %s over %s", ErrProbeContract, latency, maxLatency)
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCaptureBodyBytes = 64 << 10
+
+// redactedHeaders are the parts of header names whose values are not
+// captured.
+var redactedHeaders = []string{"authorization", "cookie", "token", "secret", "api-key", "apikey", "signature"}
+
+const redacted = "REDACTED"
+
+// CaptureConfig keeps what the probe of an event saw when it last
+// failed: the request and response, their headers, the timings of the
+// request, and the beginning of the body.
+type CaptureConfig struct {
+	// MaxBodyBytes is how much of the body is kept. It defaults to
+	// 64 KB.
+	MaxBodyBytes int `json:"max_body_bytes"`
+}
+
+// ProbeCapture is what a probe saw, when it failed. Headers carrying
+// credentials, and the userinfo and query values of the url, are
+// redacted.
+type ProbeCapture struct {
+	Time       time.Time   `json:"time"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Request    http.Header `json:"request_headers"`
+	RemoteAddr string      `json:"remote_addr,omitempty"`
+
+	Proto    string      `json:"proto,omitempty"`
+	Status   int         `json:"status,omitempty"`
+	Response http.Header `json:"response_headers,omitempty"`
+
+	// Body is the beginning of the body, and BodyTruncated is set
+	// when there was more of it.
+	Body          string `json:"body,omitempty"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"`
+
+	Error    string       `json:"error,omitempty"`
+	Failures []string     `json:"failures,omitempty"`
+	Timings  ProbeTimings `json:"timings"`
+
+	// Curl is a curl command making the same request.
+	Curl string `json:"curl"`
+}
+
+func (s *CaptureConfig) bodyLimit() int {
+	if s.MaxBodyBytes > 0 {
+		return s.MaxBodyBytes
+	}
+	return defaultCaptureBodyBytes
+}
+
+// captureNew captures the request of a probe, and its response and
+// body if it got one.
+func (s *EventConfig) captureNew(req *http.Request, resp *http.Response, body []byte, timer *probeTimer) *ProbeCapture {
+	capture := &ProbeCapture{
+		Time:       timer.start,
+		Method:     req.Method,
+		URL:        redactURL(s.URL),
+		Request:    redactHeaders(req.Header),
+		RemoteAddr: timer.addr(),
+		Curl:       s.curl(req),
+	}
+
+	if resp != nil {
+		capture.Proto = resp.Proto
+		capture.Status = resp.StatusCode
+		capture.Response = redactHeaders(resp.Header)
+	}
+
+	limit := s.Capture.bodyLimit()
+	if len(body) > limit {
+		body = body[:limit]
+		capture.BodyTruncated = true
+	}
+	capture.Body = string(body)
+
+	return capture
+}
+
+func redactHeaders(header http.Header) http.Header {
+	copied := make(http.Header, len(header))
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		for _, part := range redactedHeaders {
+			if strings.Contains(lower, part) {
+				values = []string{redacted}
+				break
+			}
+		}
+		copied[name] = append([]string(nil), values...)
+	}
+	return copied
+}
+
+// curl returns a curl command making req, the way the probe made it.
+func (s *EventConfig) curl(req *http.Request) string {
+	args := []string{"curl", "-sS", "-v"}
+
+	switch s.Family {
+	case FamilyIPv4:
+		args = append(args, "-4")
+	case FamilyIPv6:
+		args = append(args, "-6")
+	}
+
+	if tls := s.TLS; tls != nil {
+		if tls.CAFile != "" {
+			args = append(args, "--cacert", shellQuote(tls.CAFile))
+		}
+		if tls.InsecureSkipVerify {
+			args = append(args, "-k")
+		}
+	}
+
+	target := s.URL
+	if isUnixURL(s.URL) {
+		if socket, unixURL, err := unixTarget(s.URL); err == nil {
+			args = append(args, "--unix-socket", shellQuote(socket))
+			target = unixURL
+		}
+	}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := redactHeaders(req.Header)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			args = append(args, "-H", shellQuote(name+": "+value))
+		}
+	}
+
+	return strings.Join(append(args, shellQuote(redactURL(target))), " ")
+}
+
+// redactURL redacts the userinfo of rawURL, and the values of its
+// query. It splits the url by hand, since urls over unix sockets do
+// not parse.
+func redactURL(rawURL string) string {
+	rest, fragment := rawURL, ""
+	if i := strings.Index(rest, "#"); i >= 0 {
+		rest, fragment = rest[:i], rest[i:]
+	}
+
+	base, query := rest, ""
+	if i := strings.Index(rest, "?"); i >= 0 {
+		base, query = rest[:i], rest[i+1:]
+	}
+
+	if i := strings.Index(base, "://"); i >= 0 {
+		authority := base[i+len("://"):]
+		end := strings.Index(authority, "/")
+		if end < 0 {
+			end = len(authority)
+		}
+		if at := strings.LastIndex(authority[:end], "@"); at >= 0 {
+			base = base[:i+len("://")] + redacted + authority[at:]
+		}
+	}
+
+	if query == "" {
+		return base + fragment
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		if param == "" {
+			continue
+		}
+		if eq := strings.Index(param, "="); eq >= 0 {
+			param = param[:eq]
+		}
+		params[i] = param + "=" + redacted
+	}
+
+	return base + "?" + strings.Join(params, "&") + fragment
+}
+
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// captures are the last failures captured, by the key of their probe.
+type captures struct {
+	mux   sync.Mutex
+	byKey map[string]ProbeCapture
+}
+
+func (s *captures) set(key string, capture *ProbeCapture) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.byKey == nil {
+		s.byKey = make(map[string]ProbeCapture)
+	}
+	s.byKey[key] = *capture
+}
+
+// setCapture keeps the failure captured by the probe storing its
+// results under key.
+func (s *StatusCache) setCapture(key string, capture *ProbeCapture) {
+	if s.captures != nil {
+		s.captures.set(key, capture)
+	}
+}
+
+// Capture returns the last failure captured by the probe storing its
+// results under key.
+func (s *StatusCache) Capture(key string) (ProbeCapture, bool) {
+	if s.captures == nil {
+		return ProbeCapture{}, false
+	}
+
+	s.captures.mux.Lock()
+	defer s.captures.mux.Unlock()
+
+	capture, ok := s.captures.byKey[key]
+	return capture, ok
+}
+
+// Captures returns the last failures captured, by the key of their
+// probes.
+func (s *StatusCache) Captures() map[string]ProbeCapture {
+	all := make(map[string]ProbeCapture)
+	if s.captures == nil {
+		return all
+	}
+
+	s.captures.mux.Lock()
+	defer s.captures.mux.Unlock()
+
+	for key, capture := range s.captures.byKey {
+		all[key] = capture
+	}
+	return all
+}
+
+// handleCaptures serves the last failures captured, or the one of the
+// probe of the key query parameter.
+func (s *StatusCache) handleCaptures(w http.ResponseWriter, req *http.Request) {
+	key := req.URL.Query().Get("key")
+	if key == "" {
+		writeJSON(w, http.StatusOK, s.Captures())
+		return
+	}
+
+	capture, ok := s.Capture(key)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, ErrNoCapture)
+		return
+	}
+	writeJSON(w, http.StatusOK, capture)
+}
@@ -0,0 +1,219 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// goldenMaxDiffs is how many differences from a golden fixture are
+// told, the others being counted.
+const goldenMaxDiffs = 5
+
+// goldenMaxValue is how much of a differing value is told.
+const goldenMaxValue = 40
+
+// GoldenConfig compares the body of the url to a golden fixture. Json
+// fixtures are compared as json, so that the order of keys and the
+// spacing don't matter, and Ignore has JSONPaths of the fields that
+// change from a response to the other, like timestamps. Other fixtures
+// must be the same, byte for byte.
+type GoldenConfig struct {
+	File   string   `json:"file"`
+	Ignore []string `json:"ignore"`
+
+	// Record writes the first successful response as the fixture, when
+	// it does not exist yet.
+	Record bool `json:"record"`
+}
+
+func (s *GoldenConfig) validate() error {
+	if s.File == "" {
+		return fmt.Errorf("golden needs a file")
+	}
+
+	for _, path := range s.Ignore {
+		if _, err := parseJSONPath(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check compares the body to the fixture.
+func (s *GoldenConfig) check(status int, body []byte, truncated bool) error {
+	if truncated {
+		return fmt.Errorf("%w: body is truncated", ErrProbeGolden)
+	}
+
+	fixture, err := ioutil.ReadFile(s.File)
+	if os.IsNotExist(err) && s.Record && status >= 200 && status < 300 {
+		if err := ioutil.WriteFile(s.File, body, 0o600); err != nil {
+			return fmt.Errorf("%w: %v", ErrProbeGolden, err)
+		}
+		log.Println("recorded golden fixture", s.File)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProbeGolden, err)
+	}
+
+	var expected interface{}
+	if json.Unmarshal(fixture, &expected) != nil {
+		if !bytes.Equal(fixture, body) {
+			return fmt.Errorf("%w: body differs from byte %d", ErrProbeGolden, firstDifference(fixture, body))
+		}
+		return nil
+	}
+
+	var actual interface{}
+	if err := json.Unmarshal(body, &actual); err != nil {
+		return fmt.Errorf("%w: body is not json: %v", ErrProbeGolden, err)
+	}
+
+	for _, path := range s.Ignore {
+		steps, err := parseJSONPath(path)
+		if err != nil {
+			return err
+		}
+		jsonPathClear(expected, steps)
+		jsonPathClear(actual, steps)
+	}
+
+	diffs := jsonDiff("$", expected, actual, nil)
+	if len(diffs) == 0 {
+		return nil
+	}
+	if len(diffs) > goldenMaxDiffs {
+		diffs = append(diffs[:goldenMaxDiffs], fmt.Sprintf("and %d more", len(diffs)-goldenMaxDiffs))
+	}
+	return fmt.Errorf("%w: %s", ErrProbeGolden, strings.Join(diffs, "; "))
+}
+
+// firstDifference returns the index of the first byte that differs.
+func firstDifference(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// jsonPathClear removes what the steps lead to from a decoded json
+// document: the key of an object, or the value at an index of an
+// array, which is nulled instead, not to shift the others.
+func jsonPathClear(doc interface{}, steps []jsonPathStep) {
+	if len(steps) == 0 {
+		return
+	}
+
+	parent, err := jsonPathValue(doc, steps[:len(steps)-1])
+	if err != nil {
+		return
+	}
+
+	last := steps[len(steps)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		delete(node, last.key)
+	case []interface{}:
+		if last.key == "" && last.index >= 0 && last.index < len(node) {
+			node[last.index] = nil
+		}
+	}
+}
+
+// jsonDiff appends how actual differs from expected, under path, to
+// diffs.
+func jsonDiff(path string, expected, actual interface{}, diffs []string) []string {
+	switch want := expected.(type) {
+	case map[string]interface{}:
+		got, ok := actual.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(want)+len(got))
+		for key := range want {
+			keys = append(keys, key)
+		}
+		for key := range got {
+			if _, ok := want[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			wantValue, wanted := want[key]
+			gotValue, present := got[key]
+			switch {
+			case !present:
+				diffs = append(diffs, path+"."+key+": missing")
+			case !wanted:
+				diffs = append(diffs, path+"."+key+": unexpected")
+			default:
+				diffs = jsonDiff(path+"."+key, wantValue, gotValue, diffs)
+			}
+		}
+		return diffs
+
+	case []interface{}:
+		got, ok := actual.([]interface{})
+		if !ok {
+			break
+		}
+
+		if len(want) != len(got) {
+			diffs = append(diffs, fmt.Sprintf("%s: expected %d items, got %d", path, len(want), len(got)))
+		}
+		for i := 0; i < len(want) && i < len(got); i++ {
+			diffs = jsonDiff(fmt.Sprintf("%s[%d]", path, i), want[i], got[i], diffs)
+		}
+		return diffs
+
+	default:
+		// values of different types are never equal, and those of the
+		// same type are comparable, objects and arrays aside
+		if expected == actual {
+			return diffs
+		}
+	}
+
+	return append(diffs, fmt.Sprintf("%s: expected %s, got %s", path, goldenValue(expected), goldenValue(actual)))
+}
+
+// goldenValue returns the beginning of a value, as json.
+func goldenValue(value interface{}) string {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	if len(raw) > goldenMaxValue {
+		return string(raw[:goldenMaxValue]) + "..."
+	}
+	return string(raw)
+}
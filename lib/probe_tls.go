@@ -0,0 +1,147 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ProbeTLSConfig is how the probes of an event verify the certificate
+// of their url, for services with private CAs, pinned certificates or
+// names of their own.
+type ProbeTLSConfig struct {
+	// CAFile is a pem bundle of the CAs trusted, instead of those of
+	// the system.
+	CAFile string `json:"ca_file"`
+
+	// PinnedKeys are the SHA-256 hashes of the public keys (SPKI),
+	// base64 encoded, and optionally prefixed with "sha256/", and
+	// PinnedCerts the SHA-256 fingerprints of the certificates, in
+	// hex, with or without colons. The certificate chain must have
+	// one of them.
+	PinnedKeys  []string `json:"pinned_keys"`
+	PinnedCerts []string `json:"pinned_certs"`
+
+	// ServerName is the name sent in the SNI, and verified against the
+	// certificate, instead of the host of the url.
+	ServerName string `json:"server_name"`
+
+	// InsecureSkipVerify does not verify the certificate chain, nor
+	// its name, which pins still are checked against. Events that skip
+	// verification are shown under /status/__insecure, and their
+	// results marked insecure.
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+// TLSConfig returns the tls config verifying certificates as
+// configured.
+func (s *ProbeTLSConfig) TLSConfig() (*tls.Config, error) {
+	keys, certs, err := s.pins()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName:         s.ServerName,
+		InsecureSkipVerify: s.InsecureSkipVerify, // #nosec: opted into, and annotated
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if s.CAFile != "" {
+		bundle, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrProbeTLS, err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("%w: no certificates in %s", ErrProbeTLS, s.CAFile)
+		}
+		config.RootCAs = roots
+	}
+
+	if len(keys) > 0 || len(certs) > 0 {
+		// called after the chain is verified, or not, if skipped
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, cert := range state.PeerCertificates {
+				if keys[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] || certs[sha256.Sum256(cert.Raw)] {
+					return nil
+				}
+			}
+			return fmt.Errorf("%w: no certificate matches the pins", ErrProbeTLS)
+		}
+	}
+
+	return config, nil
+}
+
+// pins decodes the pinned keys and certificates.
+func (s *ProbeTLSConfig) pins() (keys, certs map[[sha256.Size]byte]bool, err error) {
+	keys = make(map[[sha256.Size]byte]bool, len(s.PinnedKeys))
+	for _, pin := range s.PinnedKeys {
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimLeft(strings.TrimPrefix(pin, "sha256"), "/"))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, nil, fmt.Errorf("%w: bad pinned key %q", ErrProbeTLS, pin)
+		}
+		keys[sha256Array(hash)] = true
+	}
+
+	certs = make(map[[sha256.Size]byte]bool, len(s.PinnedCerts))
+	for _, pin := range s.PinnedCerts {
+		hash, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, nil, fmt.Errorf("%w: bad pinned certificate %q", ErrProbeTLS, pin)
+		}
+		certs[sha256Array(hash)] = true
+	}
+
+	return keys, certs, nil
+}
+
+func sha256Array(hash []byte) [sha256.Size]byte {
+	var array [sha256.Size]byte
+	copy(array[:], hash)
+	return array
+}
+
+// tlsClient returns a client dialing the address family only, or any
+// if it is empty, and verifying certificates with config. Its
+// connections are its own, and not part of a transport pool.
+func tlsClient(family string, config *tls.Config) *http.Client {
+	transport := familyTransport(family)
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}
+}
+
+// Insecure returns the events whose probes don't verify certificates.
+func (s *Planner) Insecure() []EventState {
+	var insecure []EventState
+	for _, event := range s.State().Events {
+		if event.InsecureTLS {
+			insecure = append(insecure, event)
+		}
+	}
+	return insecure
+}
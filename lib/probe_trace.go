@@ -0,0 +1,134 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// ProbeTimings break the latency of a probe down, in milliseconds.
+// Steps that did not happen, like looking up an ip, or connecting and
+// handshaking on a reused connection, are zero.
+type ProbeTimings struct {
+	DNSMs     float64 `json:"dns_ms"`
+	ConnectMs float64 `json:"connect_ms"`
+	TLSMs     float64 `json:"tls_ms"`
+
+	// TTFBMs is from the request being sent to the first byte of the
+	// response, which is how long the server took.
+	TTFBMs float64 `json:"ttfb_ms"`
+
+	// TransferMs is from the first byte of the response to the end of
+	// what was read of its body.
+	TransferMs float64 `json:"transfer_ms"`
+
+	TotalMs float64 `json:"total_ms"`
+}
+
+// probeTimer times the steps of a request, with httptrace. Its hooks
+// may be called from the goroutines dialing.
+type probeTimer struct {
+	mux sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+
+	remoteAddr string
+}
+
+func probeTimerNew() *probeTimer {
+	return &probeTimer{start: time.Now()}
+}
+
+// mark sets the time of a step, once.
+func (s *probeTimer) mark(step *time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if step.IsZero() {
+		*step = time.Now()
+	}
+}
+
+func (s *probeTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { s.mark(&s.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { s.mark(&s.dnsDone) },
+
+		// with several addresses, the first connection made is timed
+		ConnectStart: func(_, _ string) { s.mark(&s.connectStart) },
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				s.mark(&s.connectDone)
+			}
+		},
+
+		TLSHandshakeStart: func() { s.mark(&s.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { s.mark(&s.tlsDone) },
+
+		GotConn: func(info httptrace.GotConnInfo) {
+			s.mux.Lock()
+			defer s.mux.Unlock()
+			if info.Conn != nil {
+				s.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { s.mark(&s.wroteRequest) },
+		GotFirstResponseByte: func() { s.mark(&s.firstByte) },
+	}
+}
+
+// timings returns the timings of the request, which ended at end.
+func (s *probeTimer) timings(end time.Time) ProbeTimings {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return ProbeTimings{
+		DNSMs:      milliseconds(s.dnsStart, s.dnsDone),
+		ConnectMs:  milliseconds(s.connectStart, s.connectDone),
+		TLSMs:      milliseconds(s.tlsStart, s.tlsDone),
+		TTFBMs:     milliseconds(s.wroteRequest, s.firstByte),
+		TransferMs: milliseconds(s.firstByte, end),
+		TotalMs:    milliseconds(s.start, end),
+	}
+}
+
+func (s *probeTimer) addr() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.remoteAddr
+}
+
+// milliseconds returns the milliseconds from start to end, to the
+// microsecond, or zero if either is.
+func milliseconds(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return float64(end.Sub(start).Microseconds()) / 1000
+}
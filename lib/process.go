@@ -0,0 +1,164 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ProcessCheckConfig checks that a process runs, found by its pid file
+// or by a pattern, and optionally its memory and cpu usage. Limits
+// left at zero are not checked.
+type ProcessCheckConfig struct {
+	PidFile string `json:"pid_file"`
+
+	// Pattern is a regular expression matched against the command
+	// lines of the processes, other than cynic. All the processes
+	// matching it are counted together.
+	Pattern string `json:"pattern"`
+
+	MaxRSSMB      uint64  `json:"max_rss_mb"`
+	MaxCPUPercent float64 `json:"max_cpu_percent"`
+}
+
+// ProcessResult is what a process check stores in the status cache,
+// and sends along with its alerts.
+type ProcessResult struct {
+	Pids []int `json:"pids,omitempty"`
+
+	// RSS is the resident memory of the processes, in bytes.
+	RSS uint64 `json:"rss"`
+
+	// CPUPercent is the cpu used by the processes since the last run
+	// of the check, of a single cpu. It is missing on the first run.
+	CPUPercent *float64 `json:"cpu_percent,omitempty"`
+
+	// Problems are the limits crossed, and the checks that could not
+	// be made.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// processStat is what is read of a process.
+type processStat struct {
+	rss uint64
+
+	// ticks is the cpu time of the process, in clock ticks.
+	ticks uint64
+}
+
+// processChecker keeps the cpu times of the processes between runs,
+// to tell their cpu usage.
+type processChecker struct {
+	config  ProcessCheckConfig
+	pattern *regexp.Regexp
+
+	mux    sync.Mutex
+	ticks  map[int]uint64
+	lastAt time.Time
+}
+
+// ProcessHookNew returns a hook checking that a process runs, and that
+// it keeps within the limits of config. It fails when no process is
+// found, or it crosses a limit.
+func ProcessHookNew(config ProcessCheckConfig) (HookSignature, error) {
+	return processHookNew(config, "")
+}
+
+// processHookNew returns the hook of a process check, which also
+// stores its result in the status cache under key, unless it is empty.
+func processHookNew(config ProcessCheckConfig, key string) (HookSignature, error) {
+	if (config.PidFile == "") == (config.Pattern == "") {
+		return nil, fmt.Errorf("%w: a process check needs one of a pid file or a pattern", ErrHostCheck)
+	}
+
+	checker := &processChecker{config: config}
+	if config.Pattern != "" {
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrHostCheck, err)
+		}
+		checker.pattern = pattern
+	}
+
+	return func(params *HookParameters) (bool, interface{}) {
+		result := checker.check(time.Now())
+
+		if key != "" && params.Status != nil {
+			params.Status.Update(key, result)
+		}
+
+		return len(result.Problems) > 0, result
+	}, nil
+}
+
+func (s *processChecker) check(now time.Time) ProcessResult {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var result ProcessResult
+
+	pids, err := findProcesses(s.config.PidFile, s.pattern)
+	if err != nil {
+		result.Problems = append(result.Problems, err.Error())
+		s.ticks = nil
+		return result
+	}
+
+	ticks := make(map[int]uint64, len(pids))
+	var used uint64
+	for _, pid := range pids {
+		stat, err := readProcess(pid)
+		if err != nil {
+			// it exited since it was found
+			continue
+		}
+
+		result.Pids = append(result.Pids, pid)
+		result.RSS += stat.rss
+		ticks[pid] = stat.ticks
+
+		if last, ok := s.ticks[pid]; ok && stat.ticks >= last {
+			used += stat.ticks - last
+		}
+	}
+
+	if len(result.Pids) == 0 {
+		result.Problems = append(result.Problems, "no process is running")
+	}
+
+	if s.ticks != nil && now.After(s.lastAt) {
+		percent := float64(used) / clockTicks / now.Sub(s.lastAt).Seconds() * 100
+		result.CPUPercent = &percent
+	}
+	s.ticks, s.lastAt = ticks, now
+
+	if limit := s.config.MaxRSSMB; limit > 0 && result.RSS > limit<<20 {
+		result.Problems = append(result.Problems,
+			fmt.Sprintf("process uses %d MB of memory, over %d MB", result.RSS>>20, limit))
+	}
+
+	if limit := s.config.MaxCPUPercent; limit > 0 && result.CPUPercent != nil && *result.CPUPercent > limit {
+		result.Problems = append(result.Problems,
+			fmt.Sprintf("process uses %.1f%% of a cpu, over %.1f%%", *result.CPUPercent, limit))
+	}
+
+	return result
+}
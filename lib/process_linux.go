@@ -0,0 +1,130 @@
+//go:build linux
+// +build linux
+
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is the unit of the cpu times in /proc, which is 100 per
+// second on about every linux there is.
+const clockTicks = 100
+
+// findProcesses returns the pid in the pid file, if it runs, or the
+// pids of the processes matching pattern.
+func findProcesses(pidFile string, pattern *regexp.Regexp) ([]int, error) {
+	if pidFile != "" {
+		data, err := ioutil.ReadFile(pidFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || pid <= 0 {
+			return nil, fmt.Errorf("%w: bad pid file %s", ErrHostCheck, pidFile)
+		}
+
+		if _, err := os.Stat("/proc/" + strconv.Itoa(pid)); err != nil {
+			return nil, nil
+		}
+		return []int{pid}, nil
+	}
+
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	self := os.Getpid()
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		cmdline, err := ioutil.ReadFile("/proc/" + entry.Name() + "/cmdline")
+		if err != nil || len(cmdline) == 0 {
+			// gone, or a kernel thread
+			continue
+		}
+
+		cmdline = bytes.TrimRight(cmdline, "\x00")
+		if pattern.Match(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})) {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids, nil
+}
+
+func readProcess(pid int) (processStat, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+
+	statm, err := ioutil.ReadFile(dir + "/statm")
+	if err != nil {
+		return processStat{}, err
+	}
+
+	// pages of "size resident shared text lib data dt"
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return processStat{}, fmt.Errorf("%w: bad %s/statm", ErrHostCheck, dir)
+	}
+	resident, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return processStat{}, fmt.Errorf("%w: bad %s/statm", ErrHostCheck, dir)
+	}
+
+	stat, err := ioutil.ReadFile(dir + "/stat")
+	if err != nil {
+		return processStat{}, err
+	}
+
+	// the command name may have spaces and parentheses, so fields
+	// are counted after its closing one: utime and stime are the
+	// 14th and 15th fields, the 12th and 13th after it
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return processStat{}, fmt.Errorf("%w: bad %s/stat", ErrHostCheck, dir)
+	}
+	fields = strings.Fields(string(stat[end+1:]))
+	if len(fields) < 13 {
+		return processStat{}, fmt.Errorf("%w: bad %s/stat", ErrHostCheck, dir)
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return processStat{}, fmt.Errorf("%w: bad %s/stat", ErrHostCheck, dir)
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return processStat{}, fmt.Errorf("%w: bad %s/stat", ErrHostCheck, dir)
+	}
+
+	return processStat{rss: resident * uint64(os.Getpagesize()), ticks: utime + stime}, nil
+}
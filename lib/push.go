@@ -0,0 +1,217 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pushEndpoint = "/push/"
+
+	// defaultPushMaxBytes is how big a pushed document can be.
+	defaultPushMaxBytes = 1 << 20
+)
+
+// PushConfig lets other systems push status documents to the status
+// cache, on /push/<key>. Requests must carry the token as a bearer
+// token.
+type PushConfig struct {
+	Token string
+
+	// TTL is how long pushed documents are kept, unless a push says
+	// otherwise with ?ttl=. Zero keeps them until they are replaced.
+	TTL time.Duration
+
+	// MaxBytes caps the size of pushed documents. It defaults to
+	// 1MiB.
+	MaxBytes int64
+}
+
+// PushedStatus is a document pushed to the status cache.
+type PushedStatus struct {
+	Status json.RawMessage `json:"status"`
+
+	// Failed is set by pushes with ?failed=true, and fails the
+	// events watching the document.
+	Failed    bool      `json:"failed,omitempty"`
+	PushedAt  time.Time `json:"pushed_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// pushes are the keys of pushed documents that expire, with when they
+// do.
+type pushes struct {
+	mux     sync.Mutex
+	expires map[string]time.Time
+}
+
+// WithPush lets other systems push status documents to the cache.
+func (s *StatusCache) WithPush(config *PushConfig) {
+	s.push = config
+}
+
+// expirePushes removes the pushed documents that expired.
+func (s *StatusCache) expirePushes(now time.Time) {
+	if s.pushes == nil {
+		return
+	}
+
+	s.pushes.mux.Lock()
+	defer s.pushes.mux.Unlock()
+
+	for key, expires := range s.pushes.expires {
+		if now.After(expires) {
+			delete(s.pushes.expires, key)
+			s.Delete(key)
+		}
+	}
+}
+
+// handlePush stores (POST) or removes (DELETE) the document under the
+// key of the path.
+func (s *StatusCache) handlePush(w http.ResponseWriter, req *http.Request) {
+	if !s.push.authorized(req) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(w, http.StatusUnauthorized, ErrPushUnauthorized)
+		return
+	}
+
+	key := strings.TrimPrefix(req.URL.Path, pushEndpoint)
+	if key == "" || strings.HasPrefix(key, "__") {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("%w: %q", ErrPushKey, key))
+		return
+	}
+
+	now := s.planner.now()
+	s.expirePushes(now)
+
+	switch req.Method {
+	case http.MethodPost, http.MethodPut:
+		pushed, ttl, err := s.push.read(req, now)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		s.pushes.mux.Lock()
+		if ttl > 0 {
+			s.pushes.expires[key] = pushed.ExpiresAt
+		} else {
+			delete(s.pushes.expires, key)
+		}
+		s.Update(key, pushed)
+		s.pushes.mux.Unlock()
+
+		writeJSON(w, http.StatusOK, pushed)
+
+	case http.MethodDelete:
+		s.pushes.mux.Lock()
+		delete(s.pushes.expires, key)
+		s.Delete(key)
+		s.pushes.mux.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *PushConfig) authorized(req *http.Request) bool {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return s.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// read reads a pushed document, with its ttl (?ttl=) and whether it
+// failed (?failed=).
+func (s *PushConfig) read(req *http.Request, now time.Time) (PushedStatus, time.Duration, error) {
+	pushed := PushedStatus{PushedAt: now}
+	query := req.URL.Query()
+
+	ttl := s.TTL
+	if value := query.Get("ttl"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil || ttl < 0 {
+			return pushed, 0, fmt.Errorf("%w: bad ttl %q", ErrPushDocument, value)
+		}
+	}
+	if ttl > 0 {
+		pushed.ExpiresAt = now.Add(ttl)
+	}
+
+	if value := query.Get("failed"); value != "" {
+		failed, err := strconv.ParseBool(value)
+		if err != nil {
+			return pushed, 0, fmt.Errorf("%w: bad failed %q", ErrPushDocument, value)
+		}
+		pushed.Failed = failed
+	}
+
+	maxBytes := s.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultPushMaxBytes
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+	if err != nil {
+		return pushed, 0, err
+	}
+	if int64(len(body)) > maxBytes {
+		return pushed, 0, fmt.Errorf("%w: over %d bytes", ErrPushDocument, maxBytes)
+	}
+	if !json.Valid(body) {
+		return pushed, 0, fmt.Errorf("%w: not json", ErrPushDocument)
+	}
+
+	pushed.Status = json.RawMessage(body)
+	return pushed, ttl, nil
+}
+
+// PushedHookNew returns a hook that fails when the document pushed
+// under key is missing, expired, or was pushed as failed. It is how
+// pushed documents feed alerts.
+func PushedHookNew(key string) HookSignature {
+	return func(params *HookParameters) (bool, interface{}) {
+		if params.Status == nil {
+			return false, nil
+		}
+		params.Status.expirePushes(params.Planner.now())
+
+		value, err := params.Status.Get(key)
+		if err != nil {
+			return true, fmt.Sprintf("nothing pushed under %q", key)
+		}
+
+		pushed, ok := value.(PushedStatus)
+		if !ok {
+			return true, fmt.Sprintf("%q was not pushed", key)
+		}
+
+		return pushed.Failed, pushed
+	}
+}
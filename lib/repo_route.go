@@ -0,0 +1,77 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import "path"
+
+// RepoRoute stores the results of the events it matches in Repo. Empty
+// fields match any event.
+type RepoRoute struct {
+	// Group is the group of the events.
+	Group string
+
+	// Label is a pattern of the labels of the events, as taken by
+	// path.Match, like "public-*".
+	Label string
+
+	// Tags are tags the events must all have.
+	Tags map[string]string
+
+	Repo *StatusCache
+}
+
+// matches returns whether the route takes the event.
+func (s *RepoRoute) matches(event *Event) bool {
+	if (s.Group != "" && s.Group != event.Group) || !tagsMatch(s.Tags, event.tags) {
+		return false
+	}
+
+	if s.Label != "" {
+		matched, err := path.Match(s.Label, event.Label)
+		return err == nil && matched
+	}
+
+	return true
+}
+
+// routeRepo returns the repo of the first route the event matches, or
+// nil if it matches none.
+func routeRepo(routes []RepoRoute, event *Event) *StatusCache {
+	for i := range routes {
+		if routes[i].matches(event) {
+			return routes[i].Repo
+		}
+	}
+	return nil
+}
+
+// routedRepos returns the repos of the routes, other than the status
+// cache of the session, once each.
+func (s *Session) routedRepos() []*StatusCache {
+	var repos []*StatusCache
+	seen := map[*StatusCache]bool{s.StatusCache: true}
+
+	for _, route := range s.Routes {
+		if route.Repo != nil && !seen[route.Repo] {
+			seen[route.Repo] = true
+			repos = append(repos, route.Repo)
+		}
+	}
+
+	return repos
+}
@@ -0,0 +1,82 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"time"
+)
+
+// EventResult is the outcome of a run of an event, given to result
+// sinks.
+type EventResult struct {
+	EventID  uint64   `json:"event_id"`
+	Label    string   `json:"label"`
+	Group    string   `json:"group"`
+	Severity Severity `json:"severity"`
+
+	// Location is where the event ran from, if the planner has one.
+	Location string `json:"location,omitempty"`
+
+	// Tags are the tags of the event. They must not be changed.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Failed is true if any hook of the event reported a failure.
+	Failed bool `json:"failed"`
+
+	// Latency is how long the hooks of the event took.
+	Latency time.Duration `json:"latency_ns"`
+
+	At    time.Time    `json:"at"`
+	Hooks []HookResult `json:"hooks"`
+
+	// Async is set for the result of an async hook, recorded when it
+	// completed, apart from the run of the event that started it.
+	Async bool `json:"async,omitempty"`
+}
+
+// HookResult is what a hook of an event returned.
+type HookResult struct {
+	Failed bool        `json:"failed"`
+	Result interface{} `json:"result"`
+}
+
+// ResultSink is given the result of every run of the events of a
+// planner, and of their async hooks. Record is called on the goroutine
+// running the events, or the async hook that completed, so sinks must
+// be safe for concurrent use, and should do slow work in the
+// background.
+//
+// Sinks that also have a Close(context.Context) method are closed
+// when their session shuts down.
+type ResultSink interface {
+	Record(result EventResult)
+}
+
+type resultSinkCloser interface {
+	Close(ctx context.Context)
+}
+
+// closeResultSinks closes the sinks that can be closed.
+func closeResultSinks(ctx context.Context, sinks []ResultSink) {
+	for _, sink := range sinks {
+		if closer, ok := sink.(resultSinkCloser); ok {
+			closer.Close(ctx)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultInfluxMeasurement   = "cynic"
+	defaultInfluxBatchSize     = 500
+	defaultInfluxFlushInterval = time.Second
+
+	// influxQueueSize is how many results can wait to be written
+	// before new ones are dropped.
+	influxQueueSize = 4096
+)
+
+var (
+	influxKeyEscaper    = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// InfluxSink writes the results of events to InfluxDB, in its line
+// protocol. Results are written in batches, in the background; a
+// batch that can't be written is logged and dropped.
+//
+// Each result is a point of the measurement, tagged with the label,
+// group and severity of the event, with the fields event_id, failed,
+// failures, hooks, latency_ms and, if the hooks returned anything,
+// result, as json.
+type InfluxSink struct {
+	// dropped is first, to keep it aligned for atomic access on 32
+	// bit platforms.
+	dropped uint64
+
+	writeURL      string
+	token         string
+	measurement   string
+	client        *http.Client
+	batchSize     int
+	flushInterval time.Duration
+
+	lines chan []byte
+	done  chan struct{}
+	start sync.Once
+
+	// mux guards closed, so that results recorded after Close are
+	// dropped instead of sent on the closed channel.
+	mux    sync.RWMutex
+	closed bool
+}
+
+// InfluxSinkNew creates a sink writing to the given write url of
+// InfluxDB, with the database or bucket in its query, eg.
+// http://localhost:8086/write?db=cynic for InfluxDB 1, or
+// http://localhost:8086/api/v2/write?org=o&bucket=b&precision=ns for
+// InfluxDB 2. Points have nanosecond timestamps.
+func InfluxSinkNew(writeURL string) *InfluxSink {
+	return &InfluxSink{
+		writeURL:      writeURL,
+		measurement:   defaultInfluxMeasurement,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		batchSize:     defaultInfluxBatchSize,
+		flushInterval: defaultInfluxFlushInterval,
+		lines:         make(chan []byte, influxQueueSize),
+		done:          make(chan struct{}),
+	}
+}
+
+// SetToken sets the token sent with writes, for InfluxDB 2.
+func (s *InfluxSink) SetToken(token string) {
+	s.token = token
+}
+
+// SetMeasurement sets the measurement of the points. It defaults to
+// "cynic".
+func (s *InfluxSink) SetMeasurement(measurement string) {
+	s.measurement = measurement
+}
+
+// SetBatching sets how many points are written at once at most, and
+// how long a point waits for its batch to fill up.
+func (s *InfluxSink) SetBatching(size int, interval time.Duration) {
+	s.batchSize = size
+	s.flushInterval = interval
+}
+
+// Record queues the result to be written.
+func (s *InfluxSink) Record(result EventResult) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	if s.closed {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+
+	s.start.Do(func() {
+		go s.run()
+	})
+
+	select {
+	case s.lines <- s.line(result):
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns how many results were dropped because the queue
+// was full.
+func (s *InfluxSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close writes the queued results, and stops the sink. It gives up on
+// the results once ctx is done.
+func (s *InfluxSink) Close(ctx context.Context) {
+	s.mux.Lock()
+	if !s.closed {
+		s.closed = true
+		s.start.Do(func() {
+			close(s.done)
+		})
+		close(s.lines)
+	}
+	s.mux.Unlock()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
+
+func (s *InfluxSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	var batch bytes.Buffer
+	pending := 0
+
+	flush := func() {
+		if pending == 0 {
+			return
+		}
+		if err := s.write(batch.Bytes()); err != nil {
+			log.Println("could not write results to influxdb: ", err)
+		}
+		batch.Reset()
+		pending = 0
+	}
+
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				flush()
+				return
+			}
+
+			batch.Write(line)
+			pending++
+			if pending >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *InfluxSink) write(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: %s: %s", ErrInfluxWrite, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// line writes the result as a point in line protocol.
+func (s *InfluxSink) line(result EventResult) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(influxKeyEscaper.Replace(s.measurement))
+
+	tags := [][2]string{
+		{"group", result.Group},
+		{"label", result.Label},
+		{"location", result.Location},
+		{"severity", result.Severity.String()},
+	}
+
+	// the tags of the event, which don't replace the ones above, in
+	// the key order influx prefers
+	if len(result.Tags) > 0 {
+		for _, key := range tagKeys(result.Tags) {
+			switch key {
+			case "group", "label", "location", "severity":
+			default:
+				tags = append(tags, [2]string{influxKeyEscaper.Replace(key), result.Tags[key]})
+			}
+		}
+		sort.Slice(tags, func(i, j int) bool { return tags[i][0] < tags[j][0] })
+	}
+
+	for _, tag := range tags {
+		if tag[1] == "" {
+			continue
+		}
+		buf.WriteByte(',')
+		buf.WriteString(tag[0])
+		buf.WriteByte('=')
+		buf.WriteString(influxKeyEscaper.Replace(tag[1]))
+	}
+
+	failures := 0
+	hasResults := false
+	results := make([]interface{}, len(result.Hooks))
+	for i, hook := range result.Hooks {
+		if hook.Failed {
+			failures++
+		}
+		if hook.Result != nil {
+			hasResults = true
+		}
+		results[i] = hook.Result
+	}
+
+	fmt.Fprintf(&buf, " event_id=%di,failed=%t,failures=%di,hooks=%di,latency_ms=%s",
+		result.EventID, result.Failed, failures, len(result.Hooks),
+		strconv.FormatFloat(float64(result.Latency)/float64(time.Millisecond), 'f', -1, 64))
+
+	if hasResults {
+		if raw, err := json.Marshal(results); err == nil {
+			buf.WriteString(`,result="`)
+			buf.WriteString(influxStringEscaper.Replace(string(raw)))
+			buf.WriteByte('"')
+		}
+	}
+
+	fmt.Fprintf(&buf, " %d\n", result.At.UnixNano())
+	return buf.Bytes()
+}
@@ -0,0 +1,77 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Runner is a running session, that can be stopped.
+type Runner struct {
+	cancel context.CancelFunc
+	stopCh chan context.Context
+	done   chan struct{}
+	once   sync.Once
+}
+
+// StartWithStopper starts a cynic instance like Start, but returns
+// right away, with a runner to stop it with.
+func StartWithStopper(session Session) (*Runner, error) {
+	if session.SnapshotConfig != nil && session.StatusCache == nil {
+		return nil, fmt.Errorf("%w: snapshots need a status cache", ErrSessionInvalid)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := &Runner{
+		cancel: cancel,
+		stopCh: make(chan context.Context, 1),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		run(ctx, session, runner.stopCh)
+		close(runner.done)
+	}()
+
+	return runner, nil
+}
+
+// Stop stops running events, waiting for the ones in flight, stops
+// the status server, which flushes its snapshots, and delivers the
+// pending alerts. It returns the error of ctx if it is done before
+// all of that is.
+func (s *Runner) Stop(ctx context.Context) error {
+	s.once.Do(func() {
+		s.stopCh <- ctx
+		s.cancel()
+	})
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed once the runner has stopped.
+func (s *Runner) Done() <-chan struct{} {
+	return s.done
+}
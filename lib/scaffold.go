@@ -0,0 +1,275 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	defaultScaffoldPort = "9999"
+
+	// ScaffoldConfigFile is the config file of a starter project.
+	ScaffoldConfigFile = "cynic.json"
+)
+
+var scaffoldNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// ScaffoldProject describes a starter project.
+type ScaffoldProject struct {
+	// Name is the name of the binary of the project, and of its
+	// service. It defaults to the name of its directory.
+	Name string
+
+	// Module is the go module of the project. It defaults to Name.
+	Module string
+
+	// Port is the port of the status server. It defaults to 9999.
+	Port string
+
+	// Force overwrites the files that already exist.
+	Force bool
+}
+
+// scaffoldFile is a file of a starter project, and its template. The
+// name may hold the name of the project, as {{.Name}}.
+type scaffoldFile struct {
+	name     string
+	template *template.Template
+}
+
+func (s *scaffoldFile) path(dir string, project *ScaffoldProject) string {
+	return filepath.Join(dir, strings.ReplaceAll(s.name, "{{.Name}}", project.Name))
+}
+
+// Scaffold writes a starter project to dir: a config probing a demo
+// target, a main registering a hook and an alert hook by name, a
+// systemd unit, and a docker compose file running the project next to
+// the demo target. It returns the paths of the files it wrote. Files
+// that exist are not overwritten, unless forced.
+func Scaffold(dir string, project ScaffoldProject) ([]string, error) {
+	if project.Name == "" {
+		absolute, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		project.Name = filepath.Base(absolute)
+	}
+	if !scaffoldNamePattern.MatchString(project.Name) {
+		return nil, fmt.Errorf("%w: %q", ErrScaffoldName, project.Name)
+	}
+	if project.Module == "" {
+		project.Module = project.Name
+	}
+	if project.Port == "" {
+		project.Port = defaultScaffoldPort
+	}
+
+	if !project.Force {
+		for _, file := range scaffoldFiles {
+			path := file.path(dir, &project)
+			_, err := os.Stat(path)
+			if err == nil {
+				return nil, fmt.Errorf("%w: %s", ErrScaffoldExists, path)
+			}
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	written := make([]string, 0, len(scaffoldFiles))
+	for _, file := range scaffoldFiles {
+		var buf bytes.Buffer
+		if err := file.template.Execute(&buf, project); err != nil {
+			return written, err
+		}
+
+		path := file.path(dir, &project)
+		if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+
+	return written, nil
+}
+
+func scaffoldTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Parse(text))
+}
+
+var scaffoldFiles = []scaffoldFile{
+	{name: "go.mod", template: scaffoldTemplate("go.mod", scaffoldGoMod)},
+	{name: "main.go", template: scaffoldTemplate("main.go", scaffoldMain)},
+	{name: ScaffoldConfigFile, template: scaffoldTemplate("config", scaffoldConfig)},
+	{name: "Dockerfile", template: scaffoldTemplate("Dockerfile", scaffoldDockerfile)},
+	{name: "docker-compose.yml", template: scaffoldTemplate("compose", scaffoldCompose)},
+	{name: "{{.Name}}.service", template: scaffoldTemplate("unit", scaffoldUnit)},
+	{name: "README.md", template: scaffoldTemplate("readme", scaffoldReadme)},
+}
+
+// the requirement on cynic is added by go mod tidy, which picks its
+// latest release
+const scaffoldGoMod = `module {{.Module}}
+
+go 1.16
+`
+
+const scaffoldMain = `package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+var started = time.Now()
+
+func main() {
+	configPath := flag.String("config", "cynic.json", "config file describing events, alerts and snapshots")
+	flag.Parse()
+
+	// named hooks can be referred to by the events of the config
+	cynic.RegisterHook("uptime", func(params *cynic.HookParameters) (bool, interface{}) {
+		uptime := time.Since(started).Round(time.Second).String()
+		if params.Status != nil {
+			params.Status.Update("uptime", uptime)
+		}
+		return false, uptime
+	})
+
+	// and named alert hooks by its alerts
+	cynic.RegisterAlertHook("log", func(alerts []cynic.AlertMessage) error {
+		for _, alert := range alerts {
+			log.Printf("alert: %s %s: %v", alert.Severity, alert.Label, alert.Response)
+		}
+		return nil
+	})
+
+	// the watcher reloads the events when the config changes, or on
+	// SIGHUP
+	watcher, session, err := cynic.ConfigWatcherNew(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go watcher.Watch(stop)
+
+	// SIGINT and SIGTERM flush the snapshots and pending alerts
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	log.Println("{{.Name}} started, events:", len(watcher.Events()))
+	cynic.Run(ctx, session)
+}
+`
+
+const scaffoldConfig = `{
+  "status": {"host": "", "port": "{{.Port}}"},
+  "alerts": {"interval": "10s", "hook": "log"},
+  "events": [
+    {
+      "label": "demo",
+      "url": "http://localhost:8080/",
+      "interval": "10s",
+      "repeat": true,
+      "immediate": true,
+      "severity": "critical",
+      "contracts": [{"status": 200, "contains": "Hostname", "max_latency": "2s"}]
+    },
+    {"label": "uptime", "interval": "30s", "repeat": true, "immediate": true, "hooks": ["uptime"]}
+  ]
+}
+`
+
+const scaffoldDockerfile = `FROM golang:1.16 AS build
+WORKDIR /src
+COPY . .
+RUN go mod tidy && CGO_ENABLED=0 go build -o /{{.Name}} .
+
+FROM gcr.io/distroless/static
+COPY --from=build /{{.Name}} /{{.Name}}
+COPY cynic.json /etc/{{.Name}}/cynic.json
+ENTRYPOINT ["/{{.Name}}", "-config", "/etc/{{.Name}}/cynic.json"]
+`
+
+// the monitor shares the network of the demo target, so that the
+// config probes it on localhost both in and out of compose
+const scaffoldCompose = `services:
+  target:
+    image: traefik/whoami
+    command: ["--port", "8080"]
+    ports:
+      - "8080:8080"
+      - "{{.Port}}:{{.Port}}"
+
+  {{.Name}}:
+    build: .
+    network_mode: "service:target"
+    depends_on:
+      - target
+`
+
+const scaffoldUnit = `[Unit]
+Description={{.Name}} monitoring
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=/usr/local/bin/{{.Name}} -config /etc/{{.Name}}/cynic.json
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+DynamicUser=yes
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const scaffoldReadme = "# {{.Name}}\n\n" +
+	"Monitoring with [cynic](https://github.com/psyomn/cynic).\n\n" +
+	"`cynic.json` probes a demo target every ten seconds, and runs the\n" +
+	"`uptime` hook of `main.go` every thirty. Alerts are logged by the `log`\n" +
+	"alert hook. Results are served on http://localhost:{{.Port}}/status/.\n\n" +
+	"Run it, with the demo target, with:\n\n" +
+	"    docker compose up --build\n\n" +
+	"Or build it, and run it against your own targets, with:\n\n" +
+	"    go mod tidy\n" +
+	"    go build\n" +
+	"    ./{{.Name}} -config cynic.json\n\n" +
+	"To run it as a service, copy the binary to `/usr/local/bin`, the config\n" +
+	"to `/etc/{{.Name}}/cynic.json`, and `{{.Name}}.service` to\n" +
+	"`/etc/systemd/system`, then `systemctl enable --now {{.Name}}`.\n" +
+	"`systemctl reload {{.Name}}` reloads the config.\n"
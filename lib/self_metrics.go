@@ -0,0 +1,119 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"runtime"
+	"time"
+)
+
+// SelfMetrics are the operational metrics of cynic itself, which it
+// publishes in the status cache, so that the monitor can be monitored.
+type SelfMetrics struct {
+	Uptime string `json:"uptime"`
+
+	// Role is "leader" or "standby", if the session has a leader
+	// config.
+	Role string `json:"role,omitempty"`
+
+	// Location is where the instance probes from, if it was given
+	// one.
+	Location string `json:"location,omitempty"`
+
+	Planner PlannerStats `json:"planner"`
+
+	// ExecutionsPerSecond is the rate at which events ran since the
+	// last metrics.
+	ExecutionsPerSecond float64 `json:"executions_per_second"`
+
+	// AlertsPending and AlertsDropped are those of the alert queue,
+	// if there is an alerter.
+	AlertsPending int    `json:"alerts_pending"`
+	AlertsDropped uint64 `json:"alerts_dropped"`
+
+	// ProbesThrottled is how many requests the host limits refused,
+	// if there are any.
+	ProbesThrottled uint64 `json:"probes_throttled,omitempty"`
+
+	// Connections count the connections of the transports, if there
+	// are any.
+	Connections *ConnectionStats `json:"connections,omitempty"`
+
+	Goroutines int    `json:"goroutines"`
+	HeapAlloc  uint64 `json:"heap_alloc"`
+	HeapSys    uint64 `json:"heap_sys"`
+	NumGC      uint32 `json:"num_gc"`
+}
+
+// selfMetricsCollector collects the self metrics, and keeps what the
+// rates need between collections. lastAt starts at the time the
+// collector is made.
+type selfMetricsCollector struct {
+	planner *Planner
+	alerter *Alerter
+	elector *leaderElector
+	started time.Time
+
+	lastAt         time.Time
+	lastExecutions uint64
+}
+
+func (s *selfMetricsCollector) collect(now time.Time) SelfMetrics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	metrics := SelfMetrics{
+		Uptime:     now.Sub(s.started).Truncate(time.Second).String(),
+		Location:   s.planner.location,
+		Planner:    s.planner.Stats(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  mem.HeapAlloc,
+		HeapSys:    mem.HeapSys,
+		NumGC:      mem.NumGC,
+	}
+
+	if elapsed := now.Sub(s.lastAt).Seconds(); elapsed > 0 {
+		executions := metrics.Planner.Executions - s.lastExecutions
+		metrics.ExecutionsPerSecond = float64(executions) / elapsed
+	}
+	s.lastAt = now
+	s.lastExecutions = metrics.Planner.Executions
+
+	if s.elector != nil {
+		metrics.Role = "standby"
+		if s.elector.IsLeader() {
+			metrics.Role = "leader"
+		}
+	}
+
+	if s.alerter != nil {
+		metrics.AlertsPending = s.alerter.Pending()
+		metrics.AlertsDropped = s.alerter.Dropped()
+	}
+
+	if limiter := s.planner.hostLimiter; limiter != nil {
+		metrics.ProbesThrottled = limiter.Throttled()
+	}
+
+	if pool := s.planner.transports; pool != nil {
+		stats := pool.Stats()
+		metrics.Connections = &stats
+	}
+
+	return metrics
+}
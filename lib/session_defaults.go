@@ -0,0 +1,86 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import "time"
+
+// eventSetting is a setting of an event that a session default can
+// fill in.
+type eventSetting uint8
+
+const (
+	settingTimeout eventSetting = 1 << iota
+	settingRepeat
+	settingAlerter
+	settingRepo
+	settingJitter
+)
+
+// SessionDefaults are settings given to every event of a session on
+// Start, unless the event set them itself. Zero values are not given.
+type SessionDefaults struct {
+	// Timeout is how long each hook may take.
+	Timeout time.Duration
+
+	// Repeat makes the events repeat.
+	Repeat bool
+
+	// Alerter receives the alerts of the events, instead of the
+	// alerter of the session. It needs to be started by the caller.
+	Alerter *Alerter
+
+	// Repo is where the events store their results.
+	Repo *StatusCache
+
+	// Jitter is the most seconds added at random to each run.
+	Jitter int
+
+	// Headers are given to the hooks of the events. Headers set on
+	// an event win over these.
+	Headers map[string]string
+}
+
+// apply gives the defaults to an event, for the settings it did not
+// set.
+func (s *SessionDefaults) apply(event *Event) {
+	if s.Timeout > 0 && event.overrides&settingTimeout == 0 {
+		event.timeout = s.Timeout
+	}
+
+	if s.Repeat && event.overrides&settingRepeat == 0 {
+		event.repeat = true
+	}
+
+	if s.Alerter != nil && event.overrides&settingAlerter == 0 {
+		event.alerter = s.Alerter
+	}
+
+	if s.Repo != nil && event.overrides&settingRepo == 0 {
+		event.repo = s.Repo
+	}
+
+	if s.Jitter > 0 && event.overrides&settingJitter == 0 {
+		event.jitter = s.Jitter
+	}
+
+	for key, value := range s.Headers {
+		if _, ok := event.headers[key]; !ok {
+			event.SetHeader(key, value)
+		}
+	}
+}
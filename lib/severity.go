@@ -0,0 +1,76 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity is how urgent the alerts of an event are.
+type Severity int
+
+const (
+	// SeverityInfo is for alerts that are merely informative.
+	SeverityInfo Severity = iota
+
+	// SeverityWarning is for alerts that need attention
+	// eventually. This is the default severity of events.
+	SeverityWarning
+
+	// SeverityCritical is for alerts that need attention now.
+	SeverityCritical
+)
+
+var severityNames = [...]string{
+	SeverityInfo:     "info",
+	SeverityWarning:  "warning",
+	SeverityCritical: "critical",
+}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// MarshalText encodes the severity by name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity from its name.
+func (s *Severity) UnmarshalText(text []byte) error {
+	sev, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = sev
+	return nil
+}
+
+// ParseSeverity returns the severity with the given name.
+func ParseSeverity(name string) (Severity, error) {
+	for i, sevName := range severityNames {
+		if strings.EqualFold(name, sevName) {
+			return Severity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownSeverity, name)
+}
@@ -0,0 +1,113 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"math/rand"
+	"time"
+)
+
+// SimulatedRun is a run of an event, in a simulation.
+type SimulatedRun struct {
+	// Tick is the planner tick the event runs on.
+	Tick int `json:"tick"`
+
+	// After is how long from the start of the simulation the event
+	// runs.
+	After time.Duration `json:"after"`
+
+	EventID uint64 `json:"event_id"`
+	Label   string `json:"label"`
+	Group   string `json:"group"`
+}
+
+// Simulate fast-forwards the schedule of the planner by duration, and
+// returns when each event would run, in order. No hooks are run, and
+// the planner is left as it is. With jitter, the timeline is one of
+// the possible ones, unless the planner has a seed, in which case it
+// is the one the planner will follow. Runs outside of the execution
+// windows of their events are left out, as they are skipped.
+func (s *Planner) Simulate(duration time.Duration) []SimulatedRun {
+	shadow := s.shadow()
+	start := shadow.ticks
+	end := start + int(duration/time.Second)
+	now := clockOr(s.clock).Now()
+
+	var runs []SimulatedRun
+	for shadow.ticks < end {
+		for {
+			event := shadow.popExpired()
+			if event == nil {
+				break
+			}
+
+			after := time.Duration(shadow.ticks-start) * time.Second
+			if event.inWindows(now.Add(after)) {
+				runs = append(runs, SimulatedRun{
+					Tick:    shadow.ticks,
+					After:   after,
+					EventID: event.id,
+					Label:   event.Label,
+					Group:   event.Group,
+				})
+			}
+
+			if event.IsRepeating() {
+				shadow.Add(event)
+			}
+		}
+		shadow.ticks++
+	}
+
+	return runs
+}
+
+// shadow returns a planner with copies of the queued events, without
+// their hooks, due when the originals are.
+func (s *Planner) shadow() *Planner {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	shadow := PlannerNew()
+	shadow.ticks = s.ticks
+	if s.source != nil {
+		shadow.source = s.source.clone()
+		shadow.rng = rand.New(shadow.source) // #nosec
+	}
+
+	for _, event := range s.events.Events() {
+		if event.IsDeleted() {
+			continue
+		}
+
+		shadow.events.Push(&Event{
+			id:        event.id,
+			secs:      event.secs,
+			immediate: event.immediate,
+			offset:    event.offset,
+			repeat:    event.repeat,
+			jitter:    event.jitter,
+			windows:   event.windows,
+			priority:  event.priority,
+			Label:     event.Label,
+			Group:     event.Group,
+		})
+	}
+
+	return shadow
+}
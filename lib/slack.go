@@ -0,0 +1,267 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// slack allows roughly one message per second per webhook.
+	defaultSlackInterval = time.Second
+	defaultSlackRetries  = 3
+	defaultSlackBackoff  = 2 * time.Second
+
+	slackSummaryLength = 512
+
+	// slackMaxAttachments is how many attachments slack takes in one
+	// message.
+	slackMaxAttachments = 100
+)
+
+// SlackAlerter posts alert messages to a slack incoming webhook. Its
+// Alert method can be given to AlerterNew as the alert hook.
+type SlackAlerter struct {
+	hookURL  string
+	client   *http.Client
+	interval time.Duration
+	retries  int
+	backoff  time.Duration
+	template *AlertTemplate
+
+	// signature, if set, signs the posts.
+	signature *WebhookSignature
+
+	mux      sync.Mutex
+	lastSent time.Time
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Fallback string       `json:"fallback"`
+	Color    string       `json:"color"`
+	Title    string       `json:"title"`
+	Text     string       `json:"text"`
+	Fields   []slackField `json:"fields"`
+	Ts       int64        `json:"ts"`
+}
+
+type slackPayload struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// SlackAlerterNew creates a slack alerter that posts to the given
+// webhook url.
+func SlackAlerterNew(hookURL string) *SlackAlerter {
+	return &SlackAlerter{
+		hookURL:  hookURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: defaultSlackInterval,
+		retries:  defaultSlackRetries,
+		backoff:  defaultSlackBackoff,
+	}
+}
+
+// SetRateLimit sets the minimum time between two posts to the
+// webhook.
+func (s *SlackAlerter) SetRateLimit(interval time.Duration) {
+	s.interval = interval
+}
+
+// SetRetries sets how many times a failed post is retried, and the
+// initial backoff between retries. The backoff doubles every retry.
+func (s *SlackAlerter) SetRetries(retries int, backoff time.Duration) {
+	s.retries = retries
+	s.backoff = backoff
+}
+
+// SetTemplate sets the template used for the text of each alert
+// attachment. By default the hook result is printed as is.
+func (s *SlackAlerter) SetTemplate(template *AlertTemplate) {
+	s.template = template
+}
+
+// SetSignature signs the posts to the webhook, for proxies in front of
+// it to tell they come from cynic.
+func (s *SlackAlerter) SetSignature(signature *WebhookSignature) {
+	s.signature = signature
+}
+
+// Alert formats the messages into slack payloads of at most 100
+// attachments, and posts them in order. It returns the error of the
+// first post that fails, so that the alerts are retried; the payloads
+// posted before it are posted again then.
+func (s *SlackAlerter) Alert(messages []AlertMessage) error {
+	parts := (len(messages) + slackMaxAttachments - 1) / slackMaxAttachments
+
+	for part := 0; part < parts; part++ {
+		chunk := messages[part*slackMaxAttachments:]
+		if len(chunk) > slackMaxAttachments {
+			chunk = chunk[:slackMaxAttachments]
+		}
+
+		payload := s.payload(chunk)
+		if parts > 1 {
+			payload.Text += fmt.Sprintf(" (%d of %d)", part+1, parts)
+		}
+
+		// a payload that can't be encoded never will be, so it is
+		// dropped
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Println("problem encoding slack payload: ", err)
+			continue
+		}
+
+		if err := s.post(body); err != nil {
+			return fmt.Errorf("could not post alerts to slack: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SlackAlerter) post(body []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	backoff := s.backoff
+	var err error
+
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if wait := s.interval - time.Since(s.lastSent); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		var retryAfter time.Duration
+		retryAfter, err = s.postOnce(body)
+		s.lastSent = time.Now()
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt == s.retries {
+			break
+		}
+
+		log.Println("slack post failed, retrying: ", err)
+		if retryAfter > backoff {
+			time.Sleep(retryAfter)
+		} else {
+			time.Sleep(backoff)
+		}
+		backoff *= 2
+	}
+
+	return err
+}
+
+// postOnce returns the time slack asked us to wait, if it rate
+// limited us.
+func (s *SlackAlerter) postOnce(body []byte) (time.Duration, error) {
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.hookURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.signature != nil {
+		s.signature.Sign(req, body, time.Now())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return 0, nil
+	}
+
+	var retryAfter time.Duration
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(secs) * time.Second
+	}
+
+	return retryAfter, fmt.Errorf("%w: slack responded with: %s", ErrAlertSinkRejected, resp.Status)
+}
+
+func (s *SlackAlerter) payload(messages []AlertMessage) slackPayload {
+	attachments := make([]slackAttachment, 0, len(messages))
+
+	for i := range messages {
+		msg := &messages[i]
+
+		title := msg.Label
+		if title == "" {
+			title = fmt.Sprintf("event %d", msg.EventID)
+		}
+
+		summary := truncateText(s.summary(msg), slackSummaryLength)
+
+		var ts int64
+		if now, err := time.Parse(time.RFC3339, msg.Now); err == nil {
+			ts = now.Unix()
+		}
+
+		var fields []slackField
+		if msg.Endpoint != "" {
+			fields = append(fields, slackField{Title: "endpoint", Value: msg.Endpoint})
+		}
+		fields = append(fields,
+			slackField{Title: "hostname", Value: msg.CynicHostname, Short: true},
+			slackField{Title: "time", Value: msg.Now, Short: true})
+		if msg.Location != "" {
+			fields = append(fields, slackField{Title: "location", Value: msg.Location, Short: true})
+		}
+
+		attachments = append(attachments, slackAttachment{
+			Fallback: title + ": " + summary,
+			Color:    "danger",
+			Title:    title,
+			Text:     summary,
+			Fields:   fields,
+			Ts:       ts,
+		})
+	}
+
+	return slackPayload{
+		Text:        fmt.Sprintf("cynic: %d alert(s)", len(messages)),
+		Attachments: attachments,
+	}
+}
+
+func (s *SlackAlerter) summary(msg *AlertMessage) string {
+	return alertText(s.template, msg)
+}
@@ -0,0 +1,282 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	sloMinutes = 60
+	sloHours   = 30 * 24
+
+	// defaultSLOObjective is the availability, in percent, error
+	// budgets are computed against when none is given.
+	defaultSLOObjective = 99.9
+)
+
+// SLO windows, over which availability is computed.
+const (
+	SLOWindowHour  = "1h"
+	SLOWindowDay   = "24h"
+	SLOWindowMonth = "30d"
+)
+
+// SLOConfig configures an SLO tracker.
+type SLOConfig struct {
+	// Objective is the availability, in percent, that events
+	// should have, like 99.9. It defaults to 99.9.
+	Objective float64
+
+	// Alerter, if set, is sent an alert when the availability of an
+	// event over AlertWindow drops below the objective.
+	Alerter *Alerter
+
+	// AlertWindow is one of the SLO windows. It defaults to 30d.
+	AlertWindow string
+}
+
+// SLOWindow is the availability of an event over a window.
+type SLOWindow struct {
+	Window string `json:"window"`
+	Runs   int    `json:"runs"`
+
+	// Availability is the percentage of runs that did not fail.
+	Availability float64 `json:"availability"`
+
+	// BudgetBurn is how fast the error budget of the window is spent:
+	// 1 spends it exactly over the window, and 2 in half of it.
+	BudgetBurn float64 `json:"budget_burn"`
+}
+
+// SLOReport is the availability of an event over every window.
+type SLOReport struct {
+	EventID   uint64      `json:"event_id"`
+	Label     string      `json:"label"`
+	Group     string      `json:"group"`
+	Objective float64     `json:"objective"`
+	Windows   []SLOWindow `json:"windows"`
+}
+
+// SLOTracker is a result sink computing the availability of events
+// over the last hour, day and 30 days, out of their results. It keeps
+// counts per minute for the last hour and per hour for the rest, in
+// memory, so it starts over when cynic restarts.
+type SLOTracker struct {
+	config SLOConfig
+
+	mux    sync.Mutex
+	events map[string]*sloHistory
+}
+
+// sloBucket counts the runs of an event in a minute or an hour, which
+// slot is the index of.
+type sloBucket struct {
+	slot   int64
+	runs   uint32
+	failed uint32
+}
+
+type sloHistory struct {
+	eventID  uint64
+	label    string
+	group    string
+	minutes  [sloMinutes]sloBucket
+	hours    [sloHours]sloBucket
+	breached bool
+}
+
+// SLOTrackerNew creates a tracker with the given config.
+func SLOTrackerNew(config SLOConfig) *SLOTracker {
+	if config.Objective <= 0 || config.Objective >= 100 {
+		config.Objective = defaultSLOObjective
+	}
+	if !isSLOWindow(config.AlertWindow) {
+		config.AlertWindow = SLOWindowMonth
+	}
+
+	return &SLOTracker{
+		config: config,
+		events: make(map[string]*sloHistory),
+	}
+}
+
+// Record counts the result, and alerts if the event went below the
+// objective over the alert window. Results of async hooks are left
+// out, as they are not runs.
+func (s *SLOTracker) Record(result EventResult) {
+	if result.Async {
+		return
+	}
+
+	at := result.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	s.mux.Lock()
+
+	key := resultKey(result)
+	history, ok := s.events[key]
+	if !ok {
+		history = &sloHistory{}
+		s.events[key] = history
+	}
+	history.eventID, history.label, history.group = result.EventID, result.Label, result.Group
+
+	sloBuckets(history.minutes[:]).count(at.Unix()/60, result.Failed)
+	sloBuckets(history.hours[:]).count(at.Unix()/3600, result.Failed)
+
+	var alert *AlertMessage
+	if s.config.Alerter != nil {
+		window := history.window(s.config.AlertWindow, at, s.config.Objective)
+		breached := window.Runs > 0 && window.Availability < s.config.Objective
+		if breached && !history.breached {
+			alert = &AlertMessage{
+				Response:      history.report(at, s.config.Objective),
+				Now:           s.config.Alerter.clock.Now().Format(time.RFC3339),
+				CynicHostname: currentHost(),
+				Location:      result.Location,
+				Label:         result.Label,
+				Group:         result.Group,
+				Severity:      result.Severity,
+				EventID:       result.EventID,
+				Fingerprint:   "slo:" + s.config.AlertWindow,
+			}
+		}
+		history.breached = breached
+	}
+
+	s.mux.Unlock()
+
+	if alert != nil {
+		s.config.Alerter.Ch <- *alert
+	}
+}
+
+// Reports returns the availability of every event, as of now, sorted
+// by group and label.
+func (s *SLOTracker) Reports(now time.Time) []SLOReport {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	reports := make([]SLOReport, 0, len(s.events))
+	for _, history := range s.events {
+		reports = append(reports, history.report(now, s.config.Objective))
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Group != reports[j].Group {
+			return reports[i].Group < reports[j].Group
+		}
+		if reports[i].Label != reports[j].Label {
+			return reports[i].Label < reports[j].Label
+		}
+		return reports[i].EventID < reports[j].EventID
+	})
+
+	return reports
+}
+
+func (s *sloHistory) report(now time.Time, objective float64) SLOReport {
+	report := SLOReport{
+		EventID:   s.eventID,
+		Label:     s.label,
+		Group:     s.group,
+		Objective: objective,
+	}
+
+	for _, window := range []string{SLOWindowHour, SLOWindowDay, SLOWindowMonth} {
+		report.Windows = append(report.Windows, s.window(window, now, objective))
+	}
+
+	return report
+}
+
+// window sums the buckets of the window ending now.
+func (s *sloHistory) window(name string, now time.Time, objective float64) SLOWindow {
+	var runs, failed uint64
+	sum := func(buckets []sloBucket, first, last int64) {
+		for i := range buckets {
+			if slot := buckets[i].slot; slot >= first && slot <= last {
+				runs += uint64(buckets[i].runs)
+				failed += uint64(buckets[i].failed)
+			}
+		}
+	}
+
+	switch name {
+	case SLOWindowHour:
+		minute := now.Unix() / 60
+		sum(s.minutes[:], minute-sloMinutes+1, minute)
+	case SLOWindowDay:
+		hour := now.Unix() / 3600
+		sum(s.hours[:], hour-24+1, hour)
+	default:
+		hour := now.Unix() / 3600
+		sum(s.hours[:], hour-sloHours+1, hour)
+	}
+
+	window := SLOWindow{Window: name, Runs: int(runs), Availability: 100}
+	if runs > 0 {
+		window.Availability = 100 * float64(runs-failed) / float64(runs)
+	}
+	window.BudgetBurn = (100 - window.Availability) / (100 - objective)
+
+	return window
+}
+
+type sloBuckets []sloBucket
+
+// count counts a run in the bucket of slot, which is reset first if
+// it held an older slot.
+func (s sloBuckets) count(slot int64, failed bool) {
+	bucket := &s[slot%int64(len(s))]
+	if bucket.slot != slot {
+		if bucket.slot > slot {
+			// too old to count, the bucket was reused since
+			return
+		}
+		*bucket = sloBucket{slot: slot}
+	}
+
+	bucket.runs++
+	if failed {
+		bucket.failed++
+	}
+}
+
+func isSLOWindow(name string) bool {
+	switch name {
+	case SLOWindowHour, SLOWindowDay, SLOWindowMonth:
+		return true
+	}
+	return false
+}
+
+// resultKey identifies the event of a result by its group and label,
+// like eventKey, or its id if it has no label.
+func resultKey(result EventResult) string {
+	if result.Label == "" {
+		return strconv.FormatUint(result.EventID, 10)
+	}
+	return result.Group + "/" + result.Label
+}
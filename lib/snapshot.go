@@ -20,10 +20,14 @@ package cynic
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -34,16 +38,73 @@ const (
 	storeVersion = 1
 )
 
+// SnapshotStorage selects where snapshots are written to.
+type SnapshotStorage int
+
+const (
+	// SnapshotStorageGob dumps gob encoded store files in Path,
+	// every DumpEvery.
+	SnapshotStorageGob SnapshotStorage = iota
+
+	// SnapshotStorageSQLite inserts one row per status key, for
+	// every snapshot, in the DB of the config.
+	SnapshotStorageSQLite
+)
+
 // SnapshotConfig is the configuration for the snapshots to be taken
 type SnapshotConfig struct {
 	Interval  time.Duration
 	DumpEvery time.Duration
 	Path      string
+
+	Storage SnapshotStorage
+
+	// Backend is the name of a backend given to
+	// RegisterSnapshotBackend. It takes precedence over Storage.
+	Backend string
+
+	// DB is the database used by SnapshotStorageSQLite. It must be
+	// opened by the user with a sqlite driver of their choosing.
+	DB *sql.DB
+
+	// Table is the table snapshot rows are inserted in. Defaults to
+	// cynic_snapshots.
+	Table string
+
+	// Format is the encoding of the dumped store files.
+	Format SnapshotFormat
+
+	// Compression is the algorithm the snapshots of dumped store
+	// files are compressed with.
+	Compression SnapshotCompression
+
+	// Encrypt encrypts the snapshots of dumped store files, with the
+	// key of Key.
+	Encrypt bool
+
+	// Key returns the key snapshots are encrypted with, and read back
+	// with when they are merged or compacted.
+	Key SnapshotKeyFunc
+
+	// Rotation, if set, makes dumps accumulate in one file that is
+	// rotated, instead of creating a new file per dump.
+	Rotation *RotationConfig
+
+	// Shipping, if set, uploads every completed dump file: each dump
+	// without rotation, or each rotated file with it.
+	Shipping *ShippingConfig
+
+	// Retention, if set, downsamples and expires old snapshots.
+	Retention *RetentionConfig
+
+	// Clock is what snapshots are timed with. Defaults to the
+	// system clock.
+	Clock Clock
 }
 
 // Snapshot is a copy of the state of the map currently being
 // monitored.
-type snapshot struct {
+type Snapshot struct {
 	Timestamp int64  // unix timestamp
 	Data      string // json
 }
@@ -52,7 +113,10 @@ type snapshot struct {
 type SnapshotStore struct {
 	Magic     uint64
 	Version   uint8 // storage version
-	Snapshots []*snapshot
+	Flags     uint8 // low two bits are the SnapshotCompression, then SnapshotFlagEncrypted
+	Snapshots []*Snapshot
+
+	key SnapshotKeyFunc
 }
 
 var snapshotMutex sync.Mutex
@@ -75,8 +139,15 @@ func (s *SnapshotStore) String() string {
 	return build.String()
 }
 
+// SetKey sets the key the store is encrypted with when it is written,
+// if its flags have SnapshotFlagEncrypted. Stores read with a key keep
+// it.
+func (s *SnapshotStore) SetKey(key SnapshotKeyFunc) {
+	s.key = key
+}
+
 func snapshotStoreNew() SnapshotStore {
-	snps := make([]*snapshot, 0)
+	snps := make([]*Snapshot, 0)
 	return SnapshotStore{
 		Magic:     storeMagic,
 		Version:   storeVersion,
@@ -84,18 +155,54 @@ func snapshotStoreNew() SnapshotStore {
 	}
 }
 
-func (s *SnapshotStore) add(snapshot *snapshot) {
+func (s *SnapshotStore) add(snapshot *Snapshot) {
 	snapshotMutex.Lock()
 	defer snapshotMutex.Unlock()
 
 	s.Snapshots = append(s.Snapshots, snapshot)
 }
 
+func (s *SnapshotStore) len() int {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	return len(s.Snapshots)
+}
+
+// storeFlags are the flags of the stores dumped with the config.
+func (s *SnapshotConfig) storeFlags() uint8 {
+	flags := uint8(s.Compression)
+	if s.Encrypt {
+		flags |= SnapshotFlagEncrypted
+	}
+	return flags
+}
+
+// encode gob encodes the store, compressing and encrypting the
+// snapshot data as the flags say. The store itself is left as is.
 func (s *SnapshotStore) encode() (bytes.Buffer, error) {
 	var buffer bytes.Buffer
+
+	payloads, err := snapshotPayloadsFor(s.Flags, s.key)
+	if err != nil {
+		return buffer, err
+	}
+
+	store := *s
+	if payloads.codec != nil || payloads.aead != nil {
+		store.Snapshots = make([]*Snapshot, len(s.Snapshots))
+		for i, snp := range s.Snapshots {
+			data, err := payloads.encode(snp.Timestamp, []byte(snp.Data))
+			if err != nil {
+				return buffer, err
+			}
+			store.Snapshots[i] = &Snapshot{Timestamp: snp.Timestamp, Data: string(data)}
+		}
+	}
+
 	enc := gob.NewEncoder(&buffer)
 
-	err := enc.Encode(*s)
+	err = enc.Encode(store)
 	if err != nil {
 		log.Println("problem encoding cynic store file: ", err)
 	}
@@ -120,6 +227,64 @@ func (s *SnapshotStore) clear() {
 	snapshotMutex.Lock()
 	defer snapshotMutex.Unlock()
 
-	snp := make([]*snapshot, 0)
+	snp := make([]*Snapshot, 0)
 	s.Snapshots = snp
 }
+
+// merge appends the snapshots of the store to the gob store file at
+// path, creating it if it does not exist. Returns the timestamp of
+// the first snapshot of the file.
+func (s *SnapshotStore) mergeIntoFile(path string) (time.Time, error) {
+	existing, err := decodeSnapshotStoreFile(path, s.key)
+	if errors.Is(err, os.ErrNotExist) {
+		existing = snapshotStoreNew()
+		existing.Flags = s.Flags
+		existing.key = s.key
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	snapshotMutex.Lock()
+	existing.Snapshots = append(existing.Snapshots, s.Snapshots...)
+	snapshotMutex.Unlock()
+
+	var created time.Time
+	if len(existing.Snapshots) > 0 {
+		created = time.Unix(existing.Snapshots[0].Timestamp, 0)
+	}
+
+	return created, existing.encodeToFile(path)
+}
+
+func decodeSnapshotStoreFile(path string, key SnapshotKeyFunc) (SnapshotStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return SnapshotStore{}, err
+	}
+
+	return decodeSnapshotStore(bytes.NewReader(data), key)
+}
+
+// decodeSnapshotStore decodes a gob store, decrypting and
+// decompressing the snapshot data as its flags say.
+func decodeSnapshotStore(r io.Reader, key SnapshotKeyFunc) (SnapshotStore, error) {
+	store := SnapshotStore{key: key}
+	if err := gob.NewDecoder(r).Decode(&store); err != nil {
+		return store, err
+	}
+
+	payloads, err := snapshotPayloadsFor(store.Flags, key)
+	if err != nil || (payloads.codec == nil && payloads.aead == nil) {
+		return store, err
+	}
+
+	for _, snp := range store.Snapshots {
+		data, err := payloads.decode(snp.Timestamp, []byte(snp.Data))
+		if err != nil {
+			return store, err
+		}
+		snp.Data = string(data)
+	}
+
+	return store, nil
+}
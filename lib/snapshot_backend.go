@@ -0,0 +1,158 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the backends that are registered out of the box.
+const (
+	// SnapshotBackendFile accumulates snapshots, and dumps them in
+	// store files in Path every DumpEvery. It is the default.
+	SnapshotBackendFile = "file"
+
+	// SnapshotBackendSQLite is SnapshotStorageSQLite.
+	SnapshotBackendSQLite = "sqlite"
+)
+
+// SnapshotBackend stores the snapshots of a status cache. Backends
+// other than the store files and sqlite, eg. for a database of your
+// own, are named with RegisterSnapshotBackend.
+type SnapshotBackend interface {
+	// Append stores a snapshot, as it is taken.
+	Append(snp *Snapshot) error
+
+	// Iterate calls fn with every stored snapshot, oldest first,
+	// and stops at the first error it returns.
+	Iterate(fn func(snp *Snapshot) error) error
+
+	// Prune applies the retention tiers to what was stored.
+	Prune(retention *RetentionConfig, now time.Time) error
+}
+
+// SnapshotFlusher is a backend buffering the snapshots it is given.
+// Flush is called every DumpEvery, and when stopping, and returns the
+// path of the file it completed, if any, to be shipped.
+type SnapshotFlusher interface {
+	Flush() (string, error)
+}
+
+// SnapshotBackendFactory creates a backend for a snapshot config.
+type SnapshotBackendFactory func(config *SnapshotConfig) (SnapshotBackend, error)
+
+var (
+	snapshotBackendsMutex sync.RWMutex
+	snapshotBackends      = map[string]SnapshotBackendFactory{
+		SnapshotBackendFile: func(config *SnapshotConfig) (SnapshotBackend, error) {
+			return fileSnapshotBackendNew(config), nil
+		},
+		SnapshotBackendSQLite: func(config *SnapshotConfig) (SnapshotBackend, error) {
+			return sqlSnapshotBackendNew(config)
+		},
+	}
+)
+
+// RegisterSnapshotBackend names a backend, so that snapshot configs
+// and config files can refer to it.
+func RegisterSnapshotBackend(name string, factory SnapshotBackendFactory) {
+	snapshotBackendsMutex.Lock()
+	defer snapshotBackendsMutex.Unlock()
+
+	snapshotBackends[strings.ToLower(name)] = factory
+}
+
+func snapshotBackendFactory(name string) (SnapshotBackendFactory, error) {
+	snapshotBackendsMutex.RLock()
+	factory, ok := snapshotBackends[strings.ToLower(name)]
+	snapshotBackendsMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotUnknownBackend, name)
+	}
+	return factory, nil
+}
+
+// snapshotBackendNew creates the backend of the config, falling back
+// to store files if it can't be.
+func snapshotBackendNew(config *SnapshotConfig) SnapshotBackend {
+	name := config.Backend
+	if name == "" {
+		name = SnapshotBackendFile
+		if config.Storage == SnapshotStorageSQLite {
+			name = SnapshotBackendSQLite
+		}
+	}
+
+	factory, err := snapshotBackendFactory(name)
+	if err == nil {
+		var backend SnapshotBackend
+		if backend, err = factory(config); err == nil {
+			return backend
+		}
+	}
+
+	log.Printf("could not use snapshot backend %s, falling back to files: %v", name, err)
+	return fileSnapshotBackendNew(config)
+}
+
+// Iterate reads every store file of the dump directory, rotated or
+// not, along with the snapshots not dumped yet.
+func (s *fileSnapshotBackend) Iterate(fn func(snp *Snapshot) error) error {
+	infos, err := ioutil.ReadDir(s.config.Path)
+	if err != nil {
+		return err
+	}
+
+	var snapshots []*Snapshot
+	for _, info := range infos {
+		name := strings.TrimSuffix(info.Name(), gzipFileSuffix)
+		if info.IsDir() || !strings.HasSuffix(name, snapshotFileSuffix) {
+			continue
+		}
+
+		storePath := path.Join(s.config.Path, info.Name())
+		store, err := ReadSnapshotStoreFileWithKey(storePath, s.config.Key)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", storePath, err)
+		}
+		snapshots = append(snapshots, store.Snapshots...)
+	}
+
+	snapshotMutex.Lock()
+	snapshots = append(snapshots, s.store.Snapshots...)
+	snapshotMutex.Unlock()
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp < snapshots[j].Timestamp
+	})
+
+	for _, snp := range snapshots {
+		if err := fn(snp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// SnapshotCompression is the algorithm snapshot payloads are
+// compressed with. It is stored in the flags of the store header, so
+// readers know how to decompress a store.
+type SnapshotCompression uint8
+
+const (
+	// SnapshotCompressionNone stores payloads as they are.
+	SnapshotCompressionNone SnapshotCompression = iota
+
+	// SnapshotCompressionGzip compresses payloads with gzip.
+	SnapshotCompressionGzip
+
+	// SnapshotCompressionZstd compresses payloads with zstd. There is
+	// no zstd codec in the standard library, so one must be given to
+	// RegisterSnapshotCodec before using it.
+	SnapshotCompressionZstd
+)
+
+// snapshotCompressionMask selects the compression bits of the store
+// header flags.
+const snapshotCompressionMask = 0x03
+
+// SnapshotCodec compresses and decompresses snapshot payloads.
+type SnapshotCodec interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	snapshotCodecsMutex sync.RWMutex
+	snapshotCodecs      = map[SnapshotCompression]SnapshotCodec{
+		SnapshotCompressionGzip: gzipSnapshotCodec{},
+	}
+)
+
+// RegisterSnapshotCodec sets the codec used for a compression
+// algorithm, replacing any previous one.
+func RegisterSnapshotCodec(compression SnapshotCompression, codec SnapshotCodec) {
+	snapshotCodecsMutex.Lock()
+	defer snapshotCodecsMutex.Unlock()
+
+	snapshotCodecs[compression] = codec
+}
+
+// snapshotCodecFor returns the codec of a compression algorithm, or
+// nil when payloads are not compressed.
+func snapshotCodecFor(compression SnapshotCompression) (SnapshotCodec, error) {
+	if compression == SnapshotCompressionNone {
+		return nil, nil
+	}
+
+	snapshotCodecsMutex.RLock()
+	defer snapshotCodecsMutex.RUnlock()
+
+	codec, ok := snapshotCodecs[compression]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotNoCodec, compression)
+	}
+
+	return codec, nil
+}
+
+type gzipSnapshotCodec struct{}
+
+func (gzipSnapshotCodec) Compress(data []byte) ([]byte, error) {
+	var buff bytes.Buffer
+
+	writer := gzip.NewWriter(&buff)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (gzipSnapshotCodec) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
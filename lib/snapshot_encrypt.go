@@ -0,0 +1,154 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// SnapshotFlagEncrypted is set in the flags of stores whose snapshot
+// payloads are encrypted, with AES-GCM. Every payload is sealed after
+// it is compressed, with a nonce of its own which precedes it, and its
+// timestamp as additional data, so that payloads can't be tampered
+// with, nor moved to another time.
+const SnapshotFlagEncrypted uint8 = 0x04
+
+// SnapshotKeyFunc returns the AES key snapshots are encrypted with: 16,
+// 24 or 32 bytes. It is called once per store written or read, so it
+// may fetch the key from a KMS.
+type SnapshotKeyFunc func() ([]byte, error)
+
+// SnapshotKeyFromEnv returns a key func reading the key, base64
+// encoded, from the environment variable name.
+func SnapshotKeyFromEnv(name string) SnapshotKeyFunc {
+	return func() ([]byte, error) {
+		encoded := os.Getenv(name)
+		if encoded == "" {
+			return nil, fmt.Errorf("%w: %s is not set", ErrSnapshotKey, name)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrSnapshotKey, name, err)
+		}
+		return key, nil
+	}
+}
+
+// snapshotPayloads compresses and encrypts the payloads of the
+// snapshots of a store, as its flags say, and back.
+type snapshotPayloads struct {
+	codec SnapshotCodec
+	aead  cipher.AEAD
+}
+
+// snapshotPayloadsFor returns how payloads are encoded in a store with
+// the given flags, encrypted with the key of key.
+func snapshotPayloadsFor(flags uint8, key SnapshotKeyFunc) (snapshotPayloads, error) {
+	var payloads snapshotPayloads
+	var err error
+
+	if payloads.codec, err = snapshotCodecFor(SnapshotCompression(flags & snapshotCompressionMask)); err != nil {
+		return payloads, err
+	}
+
+	if flags&SnapshotFlagEncrypted != 0 {
+		payloads.aead, err = snapshotCipher(key)
+	}
+
+	return payloads, err
+}
+
+func snapshotCipher(keyFn SnapshotKeyFunc) (cipher.AEAD, error) {
+	if keyFn == nil {
+		return nil, ErrSnapshotNoKey
+	}
+
+	key, err := keyFn()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotKey, err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func snapshotAdditionalData(timestamp int64) []byte {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(timestamp))
+	return data[:]
+}
+
+// encode compresses, then encrypts, the payload of a snapshot taken at
+// timestamp.
+func (s *snapshotPayloads) encode(timestamp int64, data []byte) ([]byte, error) {
+	if s.codec != nil {
+		var err error
+		if data, err = s.codec.Compress(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.aead == nil {
+		return data, nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(data)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, data, snapshotAdditionalData(timestamp)), nil
+}
+
+// decode decrypts, then decompresses, the payload of a snapshot taken
+// at timestamp.
+func (s *snapshotPayloads) decode(timestamp int64, data []byte) ([]byte, error) {
+	if s.aead != nil {
+		if len(data) < s.aead.NonceSize() {
+			return nil, fmt.Errorf("%w: encrypted payload too short", ErrSnapshotBadRecord)
+		}
+
+		nonce, sealed := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+
+		var err error
+		if data, err = s.aead.Open(nil, nonce, sealed, snapshotAdditionalData(timestamp)); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSnapshotBadRecord, err.Error())
+		}
+	}
+
+	if s.codec == nil {
+		return data, nil
+	}
+
+	data, err := s.codec.Decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotBadRecord, err.Error())
+	}
+	return data, nil
+}
@@ -0,0 +1,38 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import "fmt"
+
+var (
+	ErrSnapshotNoDB           = fmt.Errorf("sqlite snapshot storage needs a database")
+	ErrSnapshotBadTable       = fmt.Errorf("invalid snapshot table name")
+	ErrSnapshotUnknownBackend = fmt.Errorf("unknown snapshot backend")
+
+	ErrSnapshotBadHeader = fmt.Errorf("invalid snapshot store header")
+	ErrSnapshotBadRecord = fmt.Errorf("invalid snapshot store record")
+	ErrSnapshotNoCodec   = fmt.Errorf("no codec registered for snapshot compression")
+	ErrSnapshotNoKey     = fmt.Errorf("no key set for encrypted snapshots")
+	ErrSnapshotKey       = fmt.Errorf("bad snapshot key")
+
+	ErrSnapshotUnknownImport = fmt.Errorf("unknown snapshot import format")
+	ErrSnapshotBadImportRow  = fmt.Errorf("invalid snapshot import row")
+
+	ErrSnapshotUploadRejected   = fmt.Errorf("snapshot upload rejected")
+	ErrSnapshotChecksumMismatch = fmt.Errorf("snapshot upload checksum mismatch")
+)
@@ -0,0 +1,222 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportFormat is the format of the exports of other monitoring
+// tools that can be imported into a snapshot store.
+type ImportFormat int
+
+const (
+	// ImportFormatCSV is rows of key, timestamp and value. A header
+	// naming the columns may put them in any order.
+	ImportFormatCSV ImportFormat = iota
+
+	// ImportFormatJSONL is one json object per line, with a key,
+	// timestamp and value.
+	ImportFormatJSONL
+)
+
+// importRow is one value of one key, at one time.
+type importRow struct {
+	key       string
+	timestamp int64
+	value     json.RawMessage
+}
+
+// importedRow is how rows are read in json lines.
+type importedRow struct {
+	Key       string          `json:"key"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// ImportSnapshots converts the export of another monitoring tool into
+// a snapshot store. Rows of the same timestamp make one snapshot, and
+// as cynic snapshots hold the whole status, every snapshot keeps the
+// last value of the keys seen before it. Timestamps are unix seconds
+// or RFC3339.
+func ImportSnapshots(r io.Reader, format ImportFormat) (*SnapshotStore, error) {
+	var rows []importRow
+	var err error
+
+	switch format {
+	case ImportFormatCSV:
+		rows, err = importCSV(r)
+	case ImportFormatJSONL:
+		rows, err = importJSONL(r)
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotUnknownImport, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].timestamp < rows[j].timestamp
+	})
+
+	store := snapshotStoreNew()
+	status := make(map[string]json.RawMessage)
+
+	for i, row := range rows {
+		status[row.key] = row.value
+
+		if i+1 < len(rows) && rows[i+1].timestamp == row.timestamp {
+			continue
+		}
+
+		data, err := json.Marshal(status)
+		if err != nil {
+			return nil, err
+		}
+		store.Snapshots = append(store.Snapshots, &Snapshot{Timestamp: row.timestamp, Data: string(data)})
+	}
+
+	return &store, nil
+}
+
+func importCSV(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	columns := map[string]int{"key": 0, "timestamp": 1, "value": 2}
+
+	var rows []importRow
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSnapshotBadImportRow, err)
+		}
+
+		if n == 1 && isImportHeader(record) {
+			for i, name := range record {
+				columns[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			continue
+		}
+
+		row, err := importRowNew(
+			record[columns["key"]],
+			record[columns["timestamp"]],
+			csvImportValue(record[columns["value"]]),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d: %v", ErrSnapshotBadImportRow, n, err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// isImportHeader is whether a csv record names the key, timestamp and
+// value columns.
+func isImportHeader(record []string) bool {
+	names := make(map[string]bool, len(record))
+	for _, name := range record {
+		names[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return names["key"] && names["timestamp"] && names["value"]
+}
+
+// csvImportValue reads values as json, and anything else as a
+// string, the way cynic-store exports them.
+func csvImportValue(value string) json.RawMessage {
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	quoted, _ := json.Marshal(value)
+	return quoted
+}
+
+func importJSONL(r io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var imported importedRow
+		if err := json.Unmarshal([]byte(text), &imported); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrSnapshotBadImportRow, line, err)
+		}
+
+		timestamp := string(imported.Timestamp)
+		var str string
+		if err := json.Unmarshal(imported.Timestamp, &str); err == nil {
+			timestamp = str
+		}
+
+		value := imported.Value
+		if value == nil {
+			value = json.RawMessage("null")
+		}
+
+		row, err := importRowNew(imported.Key, timestamp, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrSnapshotBadImportRow, line, err)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
+
+func importRowNew(key, timestamp string, value json.RawMessage) (importRow, error) {
+	if key == "" {
+		return importRow{}, fmt.Errorf("no key")
+	}
+
+	ts, err := parseImportTimestamp(strings.TrimSpace(timestamp))
+	if err != nil {
+		return importRow{}, err
+	}
+
+	return importRow{key: key, timestamp: ts, value: value}, nil
+}
+
+func parseImportTimestamp(timestamp string) (int64, error) {
+	if ts, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		return ts, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return 0, fmt.Errorf("bad timestamp %q", timestamp)
+	}
+	return t.Unix(), nil
+}
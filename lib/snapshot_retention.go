@@ -0,0 +1,154 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionTier keeps snapshots up to MaxAge old, at most one per
+// Resolution. A zero Resolution keeps every snapshot.
+type RetentionTier struct {
+	Resolution time.Duration
+	MaxAge     time.Duration
+}
+
+// RetentionConfig downsamples the dumped store files as they age, so
+// that long retention periods do not take up too much disk. Tiers are
+// ordered by MaxAge, e.g. raw snapshots for 24 hours, then one
+// snapshot per 5 minutes for 30 days. Snapshots older than the last
+// tier are dropped.
+//
+// Downsampling keeps the last snapshot of every Resolution window. It
+// only applies to file storage, and files are only compacted once
+// they are complete: the current file of a rotation is left alone.
+type RetentionConfig struct {
+	Tiers []RetentionTier
+
+	// CompactEvery is how often the store files are compacted.
+	CompactEvery time.Duration
+}
+
+// downsample applies the tiers to the snapshots, which must be sorted
+// by timestamp.
+func (s *RetentionConfig) downsample(snapshots []*Snapshot, now time.Time) []*Snapshot {
+	ret := make([]*Snapshot, 0, len(snapshots))
+
+	for i, snp := range snapshots {
+		tier, ok := s.tierOf(now.Sub(time.Unix(snp.Timestamp, 0)))
+		if !ok {
+			continue
+		}
+
+		if tier.Resolution > 0 && i+1 < len(snapshots) {
+			// keep only the last snapshot of the window
+			resolution := int64(tier.Resolution / time.Second)
+			if resolution > 0 && snapshots[i+1].Timestamp/resolution == snp.Timestamp/resolution {
+				continue
+			}
+		}
+
+		ret = append(ret, snp)
+	}
+
+	return ret
+}
+
+func (s *RetentionConfig) tierOf(age time.Duration) (RetentionTier, bool) {
+	for _, tier := range s.Tiers {
+		if age < tier.MaxAge {
+			return tier, true
+		}
+	}
+	return RetentionTier{}, false
+}
+
+// compactedSnapshotFiles lists the complete store files of a dump
+// directory.
+func compactedSnapshotFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, info := range infos {
+		name := strings.TrimSuffix(info.Name(), gzipFileSuffix)
+		if info.IsDir() || name == currentSnapshotFile || !strings.HasSuffix(name, snapshotFileSuffix) {
+			continue
+		}
+		paths = append(paths, path.Join(dir, info.Name()))
+	}
+
+	return paths, nil
+}
+
+// Prune downsamples every complete store file of the dump
+// directory into a single one, replacing them.
+func (s *fileSnapshotBackend) Prune(retention *RetentionConfig, now time.Time) error {
+	paths, err := compactedSnapshotFiles(s.config.Path)
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+
+	var snapshots []*Snapshot
+	for _, storePath := range paths {
+		store, err := ReadSnapshotStoreFileWithKey(storePath, s.config.Key)
+		if err != nil {
+			return fmt.Errorf("could not compact %s: %w", storePath, err)
+		}
+		snapshots = append(snapshots, store.Snapshots...)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp < snapshots[j].Timestamp
+	})
+
+	compacted := snapshotStoreNew()
+	compacted.Flags = s.config.storeFlags()
+	compacted.key = s.config.Key
+	compacted.Snapshots = retention.downsample(snapshots, now)
+
+	filename := fmt.Sprintf("%s.%v%s", now.Format(time.RFC3339), s.version(), snapshotFileSuffix)
+	compactedPath := path.Join(s.config.Path, filename)
+	tmpPath := compactedPath + ".tmp"
+
+	if len(compacted.Snapshots) > 0 {
+		if err := WriteSnapshotStoreFile(tmpPath, &compacted, s.config.Format); err != nil {
+			return err
+		}
+	}
+
+	for _, storePath := range paths {
+		if err := os.Remove(storePath); err != nil {
+			return err
+		}
+	}
+
+	if len(compacted.Snapshots) == 0 {
+		return nil
+	}
+
+	return os.Rename(tmpPath, compactedPath)
+}
@@ -0,0 +1,150 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// currentSnapshotFile is the file snapshots are accumulated in,
+	// when rotation is enabled.
+	currentSnapshotFile = "current.cynic"
+
+	snapshotFileSuffix = ".cynic"
+	gzipFileSuffix     = ".gz"
+)
+
+// RotationConfig makes snapshots accumulate in a single file, which
+// is rolled over once it grows too large or too old. A zero limit is
+// ignored.
+type RotationConfig struct {
+	// MaxBytes is the size after which the file is rotated.
+	MaxBytes int64
+
+	// MaxAge is the time after which the file is rotated, counted
+	// from its first snapshot.
+	MaxAge time.Duration
+
+	// Keep is the number of rotated files that are kept around. The
+	// oldest are removed first.
+	Keep int
+
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+func (s *RotationConfig) needsRotation(size int64, created, now time.Time) bool {
+	if s.MaxBytes > 0 && size >= s.MaxBytes {
+		return true
+	}
+
+	return s.MaxAge > 0 && !created.IsZero() && now.Sub(created) >= s.MaxAge
+}
+
+// rotate moves the current file out of the way, and removes the
+// rotated files in excess. Returns the path of the rotated file.
+func (s *RotationConfig) rotate(dir string, version uint8, now time.Time) (string, error) {
+	current := path.Join(dir, currentSnapshotFile)
+
+	filename := fmt.Sprintf("%s.%v%s", now.Format(time.RFC3339), version, snapshotFileSuffix)
+	rotated := path.Join(dir, filename)
+
+	if err := os.Rename(current, rotated); err != nil {
+		return "", err
+	}
+
+	if s.Compress {
+		if err := gzipFile(rotated); err != nil {
+			return "", err
+		}
+		rotated += gzipFileSuffix
+	}
+
+	return rotated, s.prune(dir)
+}
+
+func (s *RotationConfig) prune(dir string) error {
+	if s.Keep <= 0 {
+		return nil
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var rotated []os.FileInfo
+	for _, info := range infos {
+		name := strings.TrimSuffix(info.Name(), gzipFileSuffix)
+		if info.IsDir() || name == currentSnapshotFile || !strings.HasSuffix(name, snapshotFileSuffix) {
+			continue
+		}
+		rotated = append(rotated, info)
+	}
+
+	if len(rotated) <= s.Keep {
+		return nil
+	}
+
+	sort.Slice(rotated, func(i, j int) bool {
+		return rotated[i].ModTime().Before(rotated[j].ModTime())
+	})
+
+	for _, info := range rotated[:len(rotated)-s.Keep] {
+		if err := os.Remove(path.Join(dir, info.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// gzipFile replaces a file with a gzipped copy of itself.
+func gzipFile(filePath string) error {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(filePath+gzipFileSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(filePath)
+}
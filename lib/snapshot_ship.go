@@ -0,0 +1,253 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // #nosec: content-md5 is an integrity check, not security
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShippingRetries = 3
+	defaultShippingBackoff = 2 * time.Second
+	defaultShippingTimeout = 30 * time.Second
+
+	// shippingQueueSize is how many completed dumps can wait for
+	// upload before new ones are dropped.
+	shippingQueueSize = 16
+
+	// checksumHeader carries the hex sha256 of uploaded dumps. HTTP
+	// endpoints may echo it back in their response, to confirm what
+	// they received.
+	checksumHeader = "X-Cynic-Checksum-Sha256"
+)
+
+// SnapshotUpload is a completed dump file to be uploaded.
+type SnapshotUpload struct {
+	Name string
+	Data []byte
+
+	// SHA256 and MD5 are the hex checksums of Data.
+	SHA256 string
+	MD5    string
+}
+
+// SnapshotUploader uploads dump files somewhere off the monitoring
+// host. Uploaders should return an error wrapping
+// ErrSnapshotChecksumMismatch if the remote end did not receive what
+// was sent.
+type SnapshotUploader interface {
+	Upload(ctx context.Context, upload *SnapshotUpload) error
+}
+
+// ShippingConfig configures how completed dumps are uploaded.
+type ShippingConfig struct {
+	Uploader SnapshotUploader
+
+	// Retries is how many times a failed upload is retried, with a
+	// backoff that doubles every retry. Zero uses the default, and a
+	// negative value disables retries.
+	Retries int
+	Backoff time.Duration
+
+	// Timeout bounds each upload attempt.
+	Timeout time.Duration
+}
+
+// snapshotShipper uploads completed dumps in the background, so that
+// slow uploads do not delay snapshots.
+type snapshotShipper struct {
+	config ShippingConfig
+	ch     chan string
+	wg     sync.WaitGroup
+}
+
+func snapshotShipperNew(config *ShippingConfig) *snapshotShipper {
+	shipperConfig := *config
+	if shipperConfig.Retries < 0 {
+		shipperConfig.Retries = 0
+	} else if shipperConfig.Retries == 0 {
+		shipperConfig.Retries = defaultShippingRetries
+	}
+	if shipperConfig.Backoff <= 0 {
+		shipperConfig.Backoff = defaultShippingBackoff
+	}
+	if shipperConfig.Timeout <= 0 {
+		shipperConfig.Timeout = defaultShippingTimeout
+	}
+
+	return &snapshotShipper{
+		config: shipperConfig,
+		ch:     make(chan string, shippingQueueSize),
+	}
+}
+
+func (s *snapshotShipper) start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+// stop waits for the pending uploads to be done.
+func (s *snapshotShipper) stop() {
+	close(s.ch)
+	s.wg.Wait()
+}
+
+func (s *snapshotShipper) ship(dumpPath string) {
+	select {
+	case s.ch <- dumpPath:
+	default:
+		log.Println("too many pending snapshot uploads, not shipping: ", dumpPath)
+	}
+}
+
+func (s *snapshotShipper) run() {
+	defer s.wg.Done()
+
+	for dumpPath := range s.ch {
+		if err := s.upload(dumpPath); err != nil {
+			log.Println("could not ship snapshot dump: ", dumpPath, ": ", err)
+		}
+	}
+}
+
+func (s *snapshotShipper) upload(dumpPath string) error {
+	data, err := ioutil.ReadFile(dumpPath)
+	if err != nil {
+		return err
+	}
+
+	upload := snapshotUploadNew(filepath.Base(dumpPath), data)
+	backoff := s.config.Backoff
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+		err = s.config.Uploader.Upload(ctx, upload)
+		cancel()
+
+		if err == nil || attempt == s.config.Retries {
+			return err
+		}
+
+		log.Println("snapshot upload failed, retrying: ", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func snapshotUploadNew(name string, data []byte) *SnapshotUpload {
+	sha := sha256.Sum256(data)
+	md := md5.Sum(data) // #nosec
+
+	return &SnapshotUpload{
+		Name:   name,
+		Data:   data,
+		SHA256: hex.EncodeToString(sha[:]),
+		MD5:    hex.EncodeToString(md[:]),
+	}
+}
+
+// HTTPUploader PUTs dumps to an HTTP endpoint, at the base url
+// followed by the dump file name.
+type HTTPUploader struct {
+	baseURL string
+	client  *http.Client
+	header  http.Header
+}
+
+// HTTPUploaderNew creates an uploader for the given base url.
+func HTTPUploaderNew(baseURL string) *HTTPUploader {
+	return &HTTPUploader{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{},
+		header:  make(http.Header),
+	}
+}
+
+// SetHeader sets a header sent with every upload, e.g. for
+// authorization.
+func (s *HTTPUploader) SetHeader(key, value string) {
+	s.header.Set(key, value)
+}
+
+// Upload PUTs the dump. If the response carries a checksum header or
+// an md5 etag, it must match what was sent.
+func (s *HTTPUploader) Upload(ctx context.Context, upload *SnapshotUpload) error {
+	url := s.baseURL + "/" + upload.Name
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(upload.Data))
+	if err != nil {
+		return err
+	}
+
+	for key := range s.header {
+		req.Header.Set(key, s.header.Get(key))
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-MD5", md5Base64(upload.MD5))
+	req.Header.Set(checksumHeader, upload.SHA256)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrSnapshotUploadRejected, resp.Status)
+	}
+
+	if echoed := resp.Header.Get(checksumHeader); echoed != "" && echoed != upload.SHA256 {
+		return fmt.Errorf("%w: sent %s, got %s", ErrSnapshotChecksumMismatch, upload.SHA256, echoed)
+	}
+
+	return verifyETag(resp, upload)
+}
+
+// verifyETag compares an md5 looking etag with the md5 of the upload.
+// Other etags, like the ones of multipart or encrypted objects, are
+// ignored.
+func verifyETag(resp *http.Response, upload *SnapshotUpload) error {
+	etag := strings.Trim(resp.Header.Get("ETag"), `"`)
+	if len(etag) != hex.EncodedLen(md5.Size) {
+		return nil
+	}
+
+	if !strings.EqualFold(etag, upload.MD5) {
+		return fmt.Errorf("%w: sent md5 %s, got etag %s", ErrSnapshotChecksumMismatch, upload.MD5, etag)
+	}
+
+	return nil
+}
+
+func md5Base64(hexSum string) string {
+	sum, _ := hex.DecodeString(hexSum)
+	return base64.StdEncoding.EncodeToString(sum)
+}
@@ -0,0 +1,184 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm  = "AWS4-HMAC-SHA256"
+	s3Service    = "s3"
+	s3DateFormat = "20060102T150405Z"
+)
+
+// S3UploaderConfig configures an uploader for S3 compatible object
+// storage.
+type S3UploaderConfig struct {
+	// Endpoint is the base url of the storage, e.g.
+	// https://s3.us-east-1.amazonaws.com, or the url of a minio
+	// server. Objects are addressed path style.
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	// Prefix is prepended to the dump file names, to form the object
+	// keys.
+	Prefix string
+
+	AccessKey string
+	SecretKey string
+}
+
+// S3Uploader PUTs dumps as objects, signing requests with AWS
+// signature version 4. The sha256 of every dump is signed, so the
+// storage rejects anything that was altered on the way.
+type S3Uploader struct {
+	config S3UploaderConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+// S3UploaderNew creates an uploader with the given configuration.
+func S3UploaderNew(config S3UploaderConfig) *S3Uploader {
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &S3Uploader{
+		config: config,
+		client: &http.Client{},
+		now:    time.Now,
+	}
+}
+
+// Upload PUTs the dump, and checks the etag of the created object.
+func (s *S3Uploader) Upload(ctx context.Context, upload *SnapshotUpload) error {
+	key := s.config.Prefix + upload.Name
+	objectURL := s.config.Endpoint + "/" + s3EscapePath(s.config.Bucket+"/"+key)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL, bytes.NewReader(upload.Data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-MD5", md5Base64(upload.MD5))
+	s.sign(req, upload.SHA256)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrSnapshotUploadRejected, resp.Status)
+	}
+
+	return verifyETag(resp, upload)
+}
+
+// sign adds the signature v4 headers to a request whose payload has
+// the given hex sha256.
+func (s *S3Uploader) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format(s3DateFormat)
+	day := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders, canonicalHeaders := s3CanonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{day, s.config.Region, s3Service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), day)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3CanonicalHeaders returns the signed header names, and the
+// canonical headers block of a request.
+func s3CanonicalHeaders(req *http.Request) (string, string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for key := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(req.Header.Get(key))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	return strings.Join(names, ";"), canonical.String()
+}
+
+// s3EscapePath percent encodes everything but unreserved characters
+// and slashes, as signature v4 expects.
+func s3EscapePath(objectPath string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(objectPath); i++ {
+		c := objectPath[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("-._~/", c) >= 0 {
+			escaped.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", c)
+	}
+	return escaped.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,219 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const defaultSnapshotTable = "cynic_snapshots"
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlSnapshotBackend writes every snapshot as rows of (timestamp, key,
+// payload), so that history can be queried with sql, eg:
+//
+//	SELECT timestamp, payload FROM cynic_snapshots WHERE key = 'api';
+type sqlSnapshotBackend struct {
+	db      *sql.DB
+	table   string
+	created bool
+}
+
+func sqlSnapshotBackendNew(config *SnapshotConfig) (*sqlSnapshotBackend, error) {
+	if config.DB == nil {
+		return nil, ErrSnapshotNoDB
+	}
+
+	table := config.Table
+	if table == "" {
+		table = defaultSnapshotTable
+	}
+
+	// the table name can't be a query parameter
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("%w: %q", ErrSnapshotBadTable, table)
+	}
+
+	return &sqlSnapshotBackend{db: config.DB, table: table}, nil
+}
+
+func (s *sqlSnapshotBackend) createTable(ctx context.Context) error {
+	if s.created {
+		return nil
+	}
+
+	stmts := [...]string{
+		"CREATE TABLE IF NOT EXISTS " + s.table + " (" +
+			"timestamp INTEGER NOT NULL, " +
+			"key TEXT NOT NULL, " +
+			"payload TEXT NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS " + s.table + "_timestamp ON " + s.table + " (timestamp)",
+		"CREATE INDEX IF NOT EXISTS " + s.table + "_key ON " + s.table + " (key, timestamp)",
+	}
+
+	for _, stmt := range stmts {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	s.created = true
+	return nil
+}
+
+func (s *sqlSnapshotBackend) Append(snp *Snapshot) error {
+	ctx := context.Background()
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(snp.Data), &entries); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// #nosec: the table name is validated on creation
+	insert := "INSERT INTO " + s.table + " (timestamp, key, payload) VALUES (?, ?, ?)"
+	for _, key := range keys {
+		if _, err := tx.ExecContext(ctx, insert, snp.Timestamp, key, string(entries[key])); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Iterate puts the rows of every timestamp back together into a
+// snapshot.
+func (s *sqlSnapshotBackend) Iterate(fn func(snp *Snapshot) error) error {
+	ctx := context.Background()
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+
+	// #nosec: the table name is validated on creation
+	query := "SELECT timestamp, key, payload FROM " + s.table + " ORDER BY timestamp, key"
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var timestamp int64
+	entries := map[string]json.RawMessage{}
+
+	emit := func() error {
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		return fn(&Snapshot{Timestamp: timestamp, Data: string(data)})
+	}
+
+	for rows.Next() {
+		var ts int64
+		var key, payload string
+		if err := rows.Scan(&ts, &key, &payload); err != nil {
+			return err
+		}
+
+		if ts != timestamp && len(entries) > 0 {
+			if err := emit(); err != nil {
+				return err
+			}
+			entries = map[string]json.RawMessage{}
+		}
+
+		timestamp = ts
+		entries[key] = json.RawMessage(payload)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(entries) > 0 {
+		return emit()
+	}
+	return nil
+}
+
+// Prune drops the rows older than the last retention tier, and
+// keeps only the rows of the last snapshot of every window in tiers
+// with a resolution.
+func (s *sqlSnapshotBackend) Prune(retention *RetentionConfig, now time.Time) error {
+	if len(retention.Tiers) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	if err := s.createTable(ctx); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// #nosec: the table name is validated on creation
+	downsample := "DELETE FROM " + s.table + " WHERE timestamp >= ? AND timestamp < ? AND timestamp < " +
+		"(SELECT MAX(w.timestamp) FROM " + s.table + " w WHERE w.timestamp / ? = " + s.table + ".timestamp / ?)"
+
+	newest := now.Unix() + 1
+	for _, tier := range retention.Tiers {
+		oldest := now.Add(-tier.MaxAge).Unix()
+		resolution := int64(tier.Resolution / time.Second)
+
+		if resolution > 0 {
+			if _, err := tx.ExecContext(ctx, downsample, oldest, newest, resolution, resolution); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+
+		newest = oldest
+	}
+
+	// #nosec: the table name is validated on creation
+	expire := "DELETE FROM " + s.table + " WHERE timestamp < ?"
+	if _, err := tx.ExecContext(ctx, expire, newest); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,375 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// The stream format is a header, followed by any number of length
+// prefixed records, so that snapshots can be appended to a file
+// without re-encoding what is already in it:
+//
+//	header: magic (8 bytes) | version (1 byte) | flags (1 byte)
+//	record: length (4 bytes) | timestamp (8 bytes) | json data
+//
+// All integers are big endian. The magic spells "CYNICSTR". The low
+// two bits of the flags are the SnapshotCompression of the json data
+// of every record, and SnapshotFlagEncrypted is set if it is
+// encrypted.
+const (
+	streamStoreVersion = 2
+	streamHeaderSize   = 10
+	streamMaxRecord    = 64 << 20
+)
+
+// SnapshotFormat selects how snapshot store files are encoded.
+type SnapshotFormat int
+
+const (
+	// SnapshotFormatGob encodes the whole store with gob.
+	SnapshotFormatGob SnapshotFormat = iota
+
+	// SnapshotFormatStream uses the append-only stream format.
+	SnapshotFormatStream
+)
+
+// SnapshotWriter appends snapshots to a stream formatted store.
+type SnapshotWriter struct {
+	w        io.Writer
+	payloads snapshotPayloads
+}
+
+// SnapshotReader reads snapshots one by one from a stream formatted
+// store.
+type SnapshotReader struct {
+	r        *bufio.Reader
+	payloads snapshotPayloads
+	Version  uint8
+	Flags    uint8
+}
+
+// SnapshotWriterNew writes a store header to w, and returns a writer
+// for the snapshots that follow.
+func SnapshotWriterNew(w io.Writer) (*SnapshotWriter, error) {
+	return SnapshotWriterNewCompressed(w, SnapshotCompressionNone)
+}
+
+// SnapshotWriterNewCompressed is like SnapshotWriterNew, but the
+// snapshot payloads are compressed with the given algorithm.
+func SnapshotWriterNewCompressed(w io.Writer, compression SnapshotCompression) (*SnapshotWriter, error) {
+	return snapshotWriterNew(w, uint8(compression), nil)
+}
+
+// snapshotWriterNew writes a store header with the given flags to w,
+// and returns a writer for the snapshots that follow.
+func snapshotWriterNew(w io.Writer, flags uint8, key SnapshotKeyFunc) (*SnapshotWriter, error) {
+	writer, err := snapshotWriterNoHeader(w, flags, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var header [streamHeaderSize]byte
+	binary.BigEndian.PutUint64(header[:8], storeMagic)
+	header[8] = streamStoreVersion
+	header[9] = flags
+
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// snapshotWriterNoHeader returns a writer for a stream whose header,
+// with the given flags, was already written.
+func snapshotWriterNoHeader(w io.Writer, flags uint8, key SnapshotKeyFunc) (*SnapshotWriter, error) {
+	payloads, err := snapshotPayloadsFor(flags, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotWriter{w: w, payloads: payloads}, nil
+}
+
+// Write appends a snapshot to the store.
+func (s *SnapshotWriter) Write(snp *Snapshot) error {
+	data, err := s.payloads.encode(snp.Timestamp, []byte(snp.Data))
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, 4+8+len(data))
+	binary.BigEndian.PutUint32(record[:4], uint32(8+len(data)))
+	binary.BigEndian.PutUint64(record[4:12], uint64(snp.Timestamp))
+	copy(record[12:], data)
+
+	_, err = s.w.Write(record)
+	return err
+}
+
+// SnapshotReaderNew reads and validates the store header from r.
+func SnapshotReaderNew(r io.Reader) (*SnapshotReader, error) {
+	return SnapshotReaderNewWithKey(r, nil)
+}
+
+// SnapshotReaderNewWithKey is like SnapshotReaderNew, but encrypted
+// snapshots are decrypted with the key of key.
+func SnapshotReaderNewWithKey(r io.Reader, key SnapshotKeyFunc) (*SnapshotReader, error) {
+	reader := bufio.NewReader(r)
+
+	var header [streamHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotBadHeader, err.Error())
+	}
+
+	if binary.BigEndian.Uint64(header[:8]) != storeMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrSnapshotBadHeader)
+	}
+
+	if header[8] != streamStoreVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrSnapshotBadHeader, header[8])
+	}
+
+	payloads, err := snapshotPayloadsFor(header[9], key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotReader{
+		r:        reader,
+		payloads: payloads,
+		Version:  header[8],
+		Flags:    header[9],
+	}, nil
+}
+
+// Next returns the next snapshot, or io.EOF once there are none
+// left.
+func (s *SnapshotReader) Next() (*Snapshot, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(s.r, length[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size < 8 || size > streamMaxRecord {
+		return nil, fmt.Errorf("%w: record of %d bytes", ErrSnapshotBadRecord, size)
+	}
+
+	record := make([]byte, size)
+	if _, err := io.ReadFull(s.r, record); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	timestamp := int64(binary.BigEndian.Uint64(record[:8]))
+
+	data, err := s.payloads.decode(timestamp, record[8:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{Timestamp: timestamp, Data: string(data)}, nil
+}
+
+// ReadSnapshotStoreFile reads a store file of any format into memory.
+func ReadSnapshotStoreFile(path string) (SnapshotStore, error) {
+	return ReadSnapshotStoreFileWithKey(path, nil)
+}
+
+// ReadSnapshotStoreFileWithKey is like ReadSnapshotStoreFile, but
+// encrypted snapshots are decrypted with the key of key.
+func ReadSnapshotStoreFileWithKey(path string, key SnapshotKeyFunc) (SnapshotStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return SnapshotStore{}, err
+	}
+
+	return ReadSnapshotStoreWithKey(bytes.NewReader(data), key)
+}
+
+// ReadSnapshotStore reads a store of any format into memory. Gzipped
+// stores, like compressed rotated files, are read as well.
+func ReadSnapshotStore(r io.Reader) (SnapshotStore, error) {
+	return ReadSnapshotStoreWithKey(r, nil)
+}
+
+// ReadSnapshotStoreWithKey is like ReadSnapshotStore, but encrypted
+// snapshots are decrypted with the key of key, which the store keeps
+// to be written back.
+func ReadSnapshotStoreWithKey(r io.Reader, key SnapshotKeyFunc) (SnapshotStore, error) {
+	buffered := bufio.NewReader(r)
+
+	if gzipMagic, err := buffered.Peek(2); err == nil && gzipMagic[0] == 0x1f && gzipMagic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return SnapshotStore{}, err
+		}
+		defer gz.Close()
+
+		return ReadSnapshotStoreWithKey(gz, key)
+	}
+
+	magic, err := buffered.Peek(8)
+	if err != nil || binary.BigEndian.Uint64(magic) != storeMagic {
+		return decodeSnapshotStore(buffered, key)
+	}
+
+	reader, err := SnapshotReaderNewWithKey(buffered, key)
+	if err != nil {
+		return SnapshotStore{}, err
+	}
+
+	store := SnapshotStore{
+		Magic:     storeMagic,
+		Version:   reader.Version,
+		Flags:     reader.Flags,
+		Snapshots: make([]*Snapshot, 0),
+		key:       key,
+	}
+
+	for {
+		snp, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return store, nil
+		}
+		if err != nil {
+			return store, err
+		}
+		store.Snapshots = append(store.Snapshots, snp)
+	}
+}
+
+// WriteSnapshotStoreFile writes the store to a new file at path, in
+// the given format. The flags of the store select its compression, and
+// whether it is encrypted, with the key given to SetKey.
+func WriteSnapshotStoreFile(path string, store *SnapshotStore, format SnapshotFormat) error {
+	if format == SnapshotFormatStream {
+		return store.encodeToStreamFile(path)
+	}
+
+	gobStore := *store
+	gobStore.Magic = storeMagic
+	gobStore.Version = storeVersion
+	return gobStore.encodeToFile(path)
+}
+
+// Validate checks that the store header has the cynic magic, and a
+// version this package can read.
+func (s *SnapshotStore) Validate() error {
+	if s.Magic != storeMagic {
+		return fmt.Errorf("%w: bad magic", ErrSnapshotBadHeader)
+	}
+
+	if s.Version != storeVersion && s.Version != streamStoreVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrSnapshotBadHeader, s.Version)
+	}
+
+	return nil
+}
+
+// appendToStreamFile appends the snapshots of the store to the
+// stream store file at path, creating it if needed. An existing file
+// keeps the compression it was created with. Returns the timestamp of
+// the first snapshot in the file.
+func (s *SnapshotStore) appendToStreamFile(path string) (time.Time, error) {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	created, flags, err := streamFileInfo(path, s.key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return created, err
+	}
+	isNew := errors.Is(err, os.ErrNotExist)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return created, err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewWriter(file)
+
+	var writer *SnapshotWriter
+	if isNew {
+		writer, err = snapshotWriterNew(buffered, s.Flags, s.key)
+	} else {
+		writer, err = snapshotWriterNoHeader(buffered, flags, s.key)
+	}
+	if err != nil {
+		return created, err
+	}
+
+	for _, snp := range s.Snapshots {
+		if err := writer.Write(snp); err != nil {
+			return created, err
+		}
+	}
+
+	if created.IsZero() && len(s.Snapshots) > 0 {
+		created = time.Unix(s.Snapshots[0].Timestamp, 0)
+	}
+
+	return created, buffered.Flush()
+}
+
+func (s *SnapshotStore) encodeToStreamFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	_, err := s.appendToStreamFile(path)
+	return err
+}
+
+// streamFileInfo returns the time of the first snapshot in a stream
+// store file, or zero if it has none, and the flags of its header.
+func streamFileInfo(path string, key SnapshotKeyFunc) (time.Time, uint8, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer file.Close()
+
+	reader, err := SnapshotReaderNewWithKey(file, key)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	snp, err := reader.Next()
+	if errors.Is(err, io.EOF) {
+		return time.Time{}, reader.Flags, nil
+	}
+	if err != nil {
+		return time.Time{}, reader.Flags, err
+	}
+
+	return time.Unix(snp.Timestamp, 0), reader.Flags, nil
+}
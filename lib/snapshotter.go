@@ -0,0 +1,260 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// fileSnapshotBackend accumulates snapshots in a store, and dumps it
+// in a new file on every flush, or in the current file when rotating.
+type fileSnapshotBackend struct {
+	config *SnapshotConfig
+	store  *SnapshotStore
+}
+
+// snapshotter periodically takes snapshots of a status cache, and
+// dumps them to disk.
+type snapshotter struct {
+	cache   *StatusCache
+	config  *SnapshotConfig
+	backend SnapshotBackend
+	shipper *snapshotShipper
+
+	mux     sync.Mutex
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+	running bool
+}
+
+func snapshotterNew(cache *StatusCache, config *SnapshotConfig) *snapshotter {
+	snapper := &snapshotter{
+		cache:   cache,
+		config:  config,
+		backend: snapshotBackendNew(config),
+		stopCh:  make(chan struct{}),
+	}
+
+	if config.Shipping != nil {
+		snapper.shipper = snapshotShipperNew(config.Shipping)
+	}
+
+	return snapper
+}
+
+func fileSnapshotBackendNew(config *SnapshotConfig) *fileSnapshotBackend {
+	store := snapshotStoreNew()
+	store.Flags = config.storeFlags()
+	store.key = config.Key
+
+	return &fileSnapshotBackend{
+		config: config,
+		store:  &store,
+	}
+}
+
+func (s *snapshotter) start() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+
+	if s.shipper != nil {
+		s.shipper.start()
+	}
+
+	// the tickers are made before returning, so that a manual clock
+	// can be advanced right after
+	clock := clockOr(s.config.Clock)
+	tickerSnap := clock.NewTicker(s.config.Interval)
+	tickerDump := clock.NewTicker(s.config.DumpEvery)
+
+	var tickerCompact Ticker
+	if s.config.Retention != nil && s.config.Retention.CompactEvery > 0 {
+		tickerCompact = clock.NewTicker(s.config.Retention.CompactEvery)
+	}
+
+	s.wg.Add(1)
+	go s.run(tickerSnap, tickerDump, tickerCompact)
+}
+
+// stop stops taking snapshots, and flushes whatever is in the store
+// to disk, along with a final snapshot. Pending uploads are given a
+// chance to finish.
+func (s *snapshotter) stop() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.running {
+		return
+	}
+	s.running = false
+
+	close(s.stopCh)
+	s.wg.Wait()
+
+	s.snap(clockOr(s.config.Clock).Now())
+	s.dump()
+
+	if s.shipper != nil {
+		s.shipper.stop()
+	}
+}
+
+func (s *snapshotter) run(tickerSnap, tickerDump, tickerCompact Ticker) {
+	defer s.wg.Done()
+	defer tickerSnap.Stop()
+	defer tickerDump.Stop()
+
+	// a nil channel never fires, when there is nothing to compact
+	var compactCh <-chan time.Time
+	if tickerCompact != nil {
+		defer tickerCompact.Stop()
+		compactCh = tickerCompact.C()
+	}
+
+	for {
+		select {
+		case now := <-tickerSnap.C():
+			s.snap(now)
+		case <-tickerDump.C():
+			s.dump()
+		case <-compactCh:
+			s.compact()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *snapshotter) snap(now time.Time) {
+	data, err := s.cache.statusCacheToJSON("")
+	if err != nil {
+		log.Println("problem snapping map data")
+		return
+	}
+
+	snp := Snapshot{
+		Timestamp: now.Unix(),
+		Data:      string(data),
+	}
+
+	if err := s.backend.Append(&snp); err != nil {
+		log.Println("problem writing snapshot: ", err)
+	}
+}
+
+func (s *snapshotter) dump() {
+	flusher, ok := s.backend.(SnapshotFlusher)
+	if !ok {
+		return
+	}
+
+	completed, err := flusher.Flush()
+	if err != nil {
+		log.Println("problem encoding and dumping to file:", err)
+	}
+
+	if completed != "" && s.shipper != nil {
+		s.shipper.ship(completed)
+	}
+}
+
+func (s *snapshotter) compact() {
+	if err := s.backend.Prune(s.config.Retention, clockOr(s.config.Clock).Now()); err != nil {
+		log.Println("problem compacting snapshots: ", err)
+	}
+}
+
+func (s *fileSnapshotBackend) Append(snp *Snapshot) error {
+	s.store.add(snp)
+	return nil
+}
+
+func (s *fileSnapshotBackend) Flush() (string, error) {
+	if s.store.len() == 0 {
+		return "", nil
+	}
+
+	if s.config.Rotation != nil {
+		return s.flushRotating()
+	}
+
+	strDate := clockOr(s.config.Clock).Now().Format(time.RFC3339)
+	filename := fmt.Sprintf("%s.%v.cynic", strDate, s.version())
+	dumpPath := path.Join(s.config.Path, filename)
+
+	var err error
+	if s.config.Format == SnapshotFormatStream {
+		err = s.store.encodeToStreamFile(dumpPath)
+	} else {
+		err = s.store.encodeToFile(dumpPath)
+	}
+	if err != nil {
+		// keep the snapshots around so the next flush can retry
+		return "", err
+	}
+	s.store.clear()
+
+	return dumpPath, nil
+}
+
+func (s *fileSnapshotBackend) version() uint8 {
+	if s.config.Format == SnapshotFormatStream {
+		return streamStoreVersion
+	}
+	return s.store.Version
+}
+
+func (s *fileSnapshotBackend) flushRotating() (string, error) {
+	current := path.Join(s.config.Path, currentSnapshotFile)
+
+	// stream files are appended to, gob files need to be re-encoded
+	// as a whole
+	var created time.Time
+	var err error
+	if s.config.Format == SnapshotFormatStream {
+		created, err = s.store.appendToStreamFile(current)
+	} else {
+		created, err = s.store.mergeIntoFile(current)
+	}
+	if err != nil {
+		return "", err
+	}
+	s.store.clear()
+
+	info, err := os.Stat(current)
+	if err != nil {
+		return "", err
+	}
+
+	now := clockOr(s.config.Clock).Now()
+	if !s.config.Rotation.needsRotation(info.Size(), created, now) {
+		return "", nil
+	}
+
+	return s.config.Rotation.rotate(s.config.Path, s.version(), now)
+}
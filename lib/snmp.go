@@ -0,0 +1,563 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5" // #nosec: HMAC-MD5-96 is one of the snmp v3 auth protocols
+	"crypto/rand"
+	"crypto/sha1" // #nosec: HMAC-SHA-96 is one of the snmp v3 auth protocols
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNMPVersion is the version of the snmp traps.
+type SNMPVersion int
+
+const (
+	// SNMPv2c sends traps with a community string.
+	SNMPv2c SNMPVersion = iota
+
+	// SNMPv3 sends traps with the user based security model.
+	SNMPv3
+)
+
+// SNMPAuthProtocol is how snmp v3 traps are authenticated.
+type SNMPAuthProtocol string
+
+// SNMPPrivProtocol is how snmp v3 traps are encrypted.
+type SNMPPrivProtocol string
+
+const (
+	SNMPAuthNone SNMPAuthProtocol = ""
+	SNMPAuthMD5  SNMPAuthProtocol = "md5"
+	SNMPAuthSHA  SNMPAuthProtocol = "sha"
+
+	SNMPPrivNone SNMPPrivProtocol = ""
+	SNMPPrivAES  SNMPPrivProtocol = "aes"
+)
+
+const (
+	defaultSNMPPort      = "162"
+	defaultSNMPCommunity = "public"
+
+	// snmpEnterprise is the enterprise number of the cynic mib,
+	// 32473, the one reserved for documentation.
+	snmpEnterprise = 32473
+
+	snmpMaxMessageSize = 65507
+
+	// snmpMaxEngineBoots is the max of snmpEngineBoots.
+	snmpMaxEngineBoots = 2147483647
+)
+
+// The objects of the cynic mib, mibs/CYNIC-MIB.txt.
+var (
+	snmpSysUpTime      = []int{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	snmpTrapOID        = []int{1, 3, 6, 1, 6, 3, 1, 1, 4, 1, 0}
+	snmpCynicAlertTrap = []int{1, 3, 6, 1, 4, 1, snmpEnterprise, 1, 0, 1}
+
+	snmpCynicEventLabel    = []int{1, 3, 6, 1, 4, 1, snmpEnterprise, 1, 1, 1}
+	snmpCynicEventState    = []int{1, 3, 6, 1, 4, 1, snmpEnterprise, 1, 1, 2}
+	snmpCynicEventMessage  = []int{1, 3, 6, 1, 4, 1, snmpEnterprise, 1, 1, 3}
+	snmpCynicEventGroup    = []int{1, 3, 6, 1, 4, 1, snmpEnterprise, 1, 1, 4}
+	snmpCynicEventID       = []int{1, 3, 6, 1, 4, 1, snmpEnterprise, 1, 1, 5}
+	snmpCynicEventHostname = []int{1, 3, 6, 1, 4, 1, snmpEnterprise, 1, 1, 6}
+	snmpCynicEventLocation = []int{1, 3, 6, 1, 4, 1, snmpEnterprise, 1, 1, 7}
+)
+
+// BER tags used in snmp messages.
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berOID         = 0x06
+	berSequence    = 0x30
+	berTimeTicks   = 0x43
+	berCounter64   = 0x46
+	berTrapV2      = 0xa7
+)
+
+// SNMPConfig configures an snmp trap sink.
+type SNMPConfig struct {
+	// Addr is the host:port of the trap receiver. The port
+	// defaults to 162.
+	Addr    string
+	Version SNMPVersion
+
+	// Community is the community of v2c traps. It defaults to
+	// "public".
+	Community string
+
+	// User, AuthProtocol, AuthPassword, PrivProtocol and
+	// PrivPassword are the user based security of v3 traps. Privacy
+	// needs authentication.
+	User         string
+	AuthProtocol SNMPAuthProtocol
+	AuthPassword string
+	PrivProtocol SNMPPrivProtocol
+	PrivPassword string
+
+	// EngineID is the engine id of cynic, which receivers need to
+	// know the v3 users of. It defaults to one made of the hostname.
+	EngineID []byte
+
+	// BootsFile, if set, keeps the engine boots of v3 traps, which
+	// are incremented every time a sink is created with it. Receivers
+	// reject the traps of an engine whose time went back within the
+	// same boots, as it does when cynic restarts, so without it the
+	// boots are always 1, and receivers may reject the traps sent
+	// after a restart.
+	BootsFile string
+}
+
+// SNMPTrapSink sends alerts as snmp v2c or v3 traps, with the objects
+// of the cynic mib: the label, group and id of the event, its state,
+// which is the severity of the alert, and the message. Its Alert
+// method can be given to AlerterNew.
+type SNMPTrapSink struct {
+	config  SNMPConfig
+	started time.Time
+
+	// authKey and privKey are the v3 keys, localized to the engine
+	// id.
+	authKey []byte
+	privKey []byte
+
+	// boots are the engine boots of v3 traps.
+	boots int64
+
+	mux       sync.Mutex
+	conn      net.Conn
+	requestID int32
+}
+
+// SNMPTrapSinkNew creates a sink sending traps as configured.
+func SNMPTrapSinkNew(config SNMPConfig) (*SNMPTrapSink, error) {
+	if _, _, err := net.SplitHostPort(config.Addr); err != nil {
+		config.Addr = net.JoinHostPort(config.Addr, defaultSNMPPort)
+	}
+
+	sink := &SNMPTrapSink{config: config, started: time.Now()}
+
+	switch config.Version {
+	case SNMPv2c:
+		if sink.config.Community == "" {
+			sink.config.Community = defaultSNMPCommunity
+		}
+	case SNMPv3:
+		if err := sink.setupUSM(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown version %d", ErrSNMPConfig, config.Version)
+	}
+
+	return sink, nil
+}
+
+// EngineID returns the engine id of the sink, for receivers of v3
+// traps.
+func (s *SNMPTrapSink) EngineID() []byte {
+	return s.config.EngineID
+}
+
+// EngineBoots returns the engine boots of the sink, which its v3
+// traps carry.
+func (s *SNMPTrapSink) EngineBoots() int64 {
+	return s.boots
+}
+
+// Alert sends each alert as a trap. It stops at the first trap that
+// can't be sent, and returns its error, so that the alerts are
+// retried.
+func (s *SNMPTrapSink) Alert(messages []AlertMessage) error {
+	for i := range messages {
+		if err := s.send(&messages[i]); err != nil {
+			return fmt.Errorf("could not send snmp trap: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Close closes the socket of the sink.
+func (s *SNMPTrapSink) Close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *SNMPTrapSink) setupUSM() error {
+	config := &s.config
+	if config.User == "" {
+		return fmt.Errorf("%w: v3 traps need a user", ErrSNMPConfig)
+	}
+
+	if len(config.EngineID) == 0 {
+		config.EngineID = snmpEngineIDNew(currentHost())
+	}
+
+	s.boots = 1
+	if config.BootsFile != "" {
+		boots, err := snmpEngineBoots(config.BootsFile)
+		if err != nil {
+			return err
+		}
+		s.boots = boots
+	}
+
+	newHash, err := snmpAuthHash(config.AuthProtocol)
+	if err != nil {
+		return err
+	}
+
+	if newHash != nil {
+		if config.AuthPassword == "" {
+			return fmt.Errorf("%w: authentication needs a password", ErrSNMPConfig)
+		}
+		s.authKey = snmpLocalizeKey(newHash, config.AuthPassword, config.EngineID)
+	}
+
+	switch config.PrivProtocol {
+	case SNMPPrivNone:
+	case SNMPPrivAES:
+		if newHash == nil || config.PrivPassword == "" {
+			return fmt.Errorf("%w: privacy needs authentication, and a password", ErrSNMPConfig)
+		}
+		s.privKey = snmpLocalizeKey(newHash, config.PrivPassword, config.EngineID)[:aes.BlockSize]
+	default:
+		return fmt.Errorf("%w: unknown privacy protocol %q", ErrSNMPConfig, config.PrivProtocol)
+	}
+
+	return nil
+}
+
+func (s *SNMPTrapSink) send(msg *AlertMessage) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.requestID++
+	pdu := s.trapPDU(msg, s.requestID)
+
+	var packet []byte
+	var err error
+	if s.config.Version == SNMPv3 {
+		packet, err = s.v3Message(pdu, s.requestID)
+	} else {
+		packet = berSeq(
+			berInt(int64(SNMPv2c)+1),
+			berOctets([]byte(s.config.Community)),
+			pdu)
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.conn == nil {
+		conn, err := net.Dial("udp", s.config.Addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	if _, err := s.conn.Write(packet); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// trapPDU is the SNMPv2-Trap-PDU of an alert.
+func (s *SNMPTrapSink) trapPDU(msg *AlertMessage, requestID int32) []byte {
+	uptime := time.Since(s.started) / (10 * time.Millisecond)
+
+	varbinds := [][]byte{
+		berVarbind(snmpSysUpTime, berTLV(berTimeTicks, berUint(uint64(uptime)&0xffffffff))),
+		berVarbind(snmpTrapOID, berObjectID(snmpCynicAlertTrap)),
+		berVarbind(snmpCynicEventLabel, berOctets([]byte(msg.Label))),
+		berVarbind(snmpCynicEventState, berInt(int64(msg.Severity)+1)),
+		berVarbind(snmpCynicEventMessage, berOctets([]byte(alertText(nil, msg)))),
+		berVarbind(snmpCynicEventGroup, berOctets([]byte(msg.Group))),
+		berVarbind(snmpCynicEventID, berTLV(berCounter64, berUint(msg.EventID))),
+		berVarbind(snmpCynicEventHostname, berOctets([]byte(msg.CynicHostname))),
+		berVarbind(snmpCynicEventLocation, berOctets([]byte(msg.Location))),
+	}
+
+	return berTLV(berTrapV2, bytes.Join([][]byte{
+		berInt(int64(requestID)),
+		berInt(0),
+		berInt(0),
+		berSeq(varbinds...),
+	}, nil))
+}
+
+// v3Message wraps a pdu in a v3 message, encrypting and
+// authenticating it as configured.
+func (s *SNMPTrapSink) v3Message(pdu []byte, msgID int32) ([]byte, error) {
+	config := &s.config
+
+	var flags byte
+	if s.authKey != nil {
+		flags |= 0x01
+	}
+	if s.privKey != nil {
+		flags |= 0x02
+	}
+
+	// cynic is the authoritative engine of its traps, so the boots
+	// and time are its own
+	boots := s.boots
+	engineTime := int64(time.Since(s.started) / time.Second)
+
+	scoped := berSeq(berOctets(config.EngineID), berOctets(nil), pdu)
+	msgData := scoped
+	privParams := []byte{}
+
+	if s.privKey != nil {
+		salt := make([]byte, 8)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+
+		iv := make([]byte, 0, aes.BlockSize)
+		iv = append(iv, uint32Bytes(uint32(boots))...)
+		iv = append(iv, uint32Bytes(uint32(engineTime))...)
+		iv = append(iv, salt...)
+
+		block, err := aes.NewCipher(s.privKey)
+		if err != nil {
+			return nil, err
+		}
+
+		encrypted := make([]byte, len(scoped))
+		cipher.NewCFBEncrypter(block, iv).XORKeyStream(encrypted, scoped)
+
+		msgData = berOctets(encrypted)
+		privParams = salt
+	}
+
+	authParams := []byte{}
+	if s.authKey != nil {
+		authParams = make([]byte, 12)
+	}
+
+	securityParams := berSeq(
+		berOctets(config.EngineID),
+		berInt(boots),
+		berInt(engineTime),
+		berOctets([]byte(config.User)),
+		berOctets(authParams),
+		berOctets(privParams))
+
+	message := berSeq(
+		berInt(3),
+		berSeq(
+			berInt(int64(msgID)),
+			berInt(snmpMaxMessageSize),
+			berOctets([]byte{flags}),
+			berInt(3)),
+		berOctets(securityParams),
+		msgData)
+
+	if s.authKey != nil {
+		newHash, _ := snmpAuthHash(config.AuthProtocol)
+
+		// the auth params are the only 12 zero bytes right after
+		// the user name, so they are found by what precedes them
+		marker := append(berOctets([]byte(config.User)), berOctets(authParams)...)
+		offset := bytes.Index(message, marker) + len(marker) - len(authParams)
+
+		mac := hmac.New(newHash, s.authKey)
+		mac.Write(message)
+		copy(message[offset:], mac.Sum(nil)[:12])
+	}
+
+	return message, nil
+}
+
+func snmpAuthHash(protocol SNMPAuthProtocol) (func() hash.Hash, error) {
+	switch protocol {
+	case SNMPAuthNone:
+		return nil, nil
+	case SNMPAuthMD5:
+		return md5.New, nil
+	case SNMPAuthSHA:
+		return sha1.New, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown authentication protocol %q", ErrSNMPConfig, protocol)
+	}
+}
+
+// snmpLocalizeKey turns a password into a key localized to an engine
+// id, as described in RFC3414.
+func snmpLocalizeKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+
+	// a megabyte of the password repeated, hashed 64 bytes at a
+	// time
+	const keyLength = 1 << 20
+	buf := make([]byte, 64)
+	index := 0
+	for count := 0; count < keyLength; count += len(buf) {
+		for i := range buf {
+			buf[i] = password[index%len(password)]
+			index++
+		}
+		h.Write(buf)
+	}
+	key := h.Sum(nil)
+
+	h.Reset()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// snmpEngineBoots reads the engine boots kept in path, and writes them
+// back incremented, as every sink created is a boot. A missing file is
+// the first boot. The boots stop at their max, like RFC 3414 has it.
+func snmpEngineBoots(path string) (int64, error) {
+	var boots int64
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		boots, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+		if err != nil || boots < 0 {
+			return 0, fmt.Errorf("%w: bad engine boots in %s", ErrSNMPConfig, path)
+		}
+	case !os.IsNotExist(err):
+		return 0, err
+	}
+
+	if boots < snmpMaxEngineBoots {
+		boots++
+	}
+
+	if err := ioutil.WriteFile(path, []byte(strconv.FormatInt(boots, 10)+"\n"), 0600); err != nil {
+		return 0, err
+	}
+	return boots, nil
+}
+
+// snmpEngineIDNew makes an engine id out of the enterprise number and
+// a text, as described in RFC3411.
+func snmpEngineIDNew(text string) []byte {
+	id := uint32Bytes(snmpEnterprise | 0x80000000)
+	id = append(id, 0x04)
+
+	if len(text) > 27 {
+		text = text[:27]
+	}
+	return append(id, text...)
+}
+
+func uint32Bytes(value uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, value)
+	return buf
+}
+
+func berTLV(tag byte, content []byte) []byte {
+	buf := []byte{tag}
+
+	switch length := len(content); {
+	case length < 0x80:
+		buf = append(buf, byte(length))
+	default:
+		var size []byte
+		for ; length > 0; length >>= 8 {
+			size = append([]byte{byte(length)}, size...)
+		}
+		buf = append(buf, 0x80|byte(len(size)))
+		buf = append(buf, size...)
+	}
+
+	return append(buf, content...)
+}
+
+func berSeq(items ...[]byte) []byte {
+	return berTLV(berSequence, bytes.Join(items, nil))
+}
+
+func berOctets(value []byte) []byte {
+	return berTLV(berOctetString, value)
+}
+
+// berInt encodes a signed integer in the fewest bytes.
+func berInt(value int64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(value))
+
+	for len(buf) > 1 &&
+		((buf[0] == 0x00 && buf[1]&0x80 == 0) || (buf[0] == 0xff && buf[1]&0x80 != 0)) {
+		buf = buf[1:]
+	}
+	return berTLV(berInteger, buf)
+}
+
+// berUint is the content of an unsigned integer, like a counter, in
+// the fewest bytes, with a leading zero byte if it would read as
+// negative.
+func berUint(value uint64) []byte {
+	buf := make([]byte, 9)
+	binary.BigEndian.PutUint64(buf[1:], value)
+
+	for len(buf) > 1 && buf[0] == 0 && buf[1]&0x80 == 0 {
+		buf = buf[1:]
+	}
+	return buf
+}
+
+func berObjectID(oid []int) []byte {
+	buf := []byte{byte(oid[0]*40 + oid[1])}
+
+	for _, arc := range oid[2:] {
+		var enc []byte
+		enc = append(enc, byte(arc&0x7f))
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			enc = append([]byte{byte(arc&0x7f) | 0x80}, enc...)
+		}
+		buf = append(buf, enc...)
+	}
+
+	return berTLV(berOID, buf)
+}
+
+func berVarbind(oid []int, value []byte) []byte {
+	return berSeq(berObjectID(oid), value)
+}
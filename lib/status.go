@@ -18,6 +18,8 @@ limitations under the License.
 package cynic
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -25,9 +27,11 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,13 +40,43 @@ import (
 // retrieve information in the map in json format.
 type StatusCache struct {
 	server          *http.Server
+	mux             *http.ServeMux
 	contractResults *sync.Map
 	listener        net.Listener
-	alerter         *time.Ticker
+	alerter         *Alerter
+	planner         *Planner
+	slo             *SLOTracker
+	incidents       *IncidentTracker
+	grafana         *GrafanaDatasource
+	heartbeats      *heartbeats
+	push            *PushConfig
+	pushes          *pushes
+	admin           *AdminConfig
+	signature       *WebhookSignature
 	root            string
 
-	snapshot       *SnapshotStore
-	snapshotConfig *SnapshotConfig
+	// keyWatches are the keys whose changes are tracked.
+	keyWatches *keyWatches
+
+	// generation is bumped on every change to the contract
+	// results, for their json to be encoded again.
+	generation uint64
+
+	// extrasGeneration is bumped every time the extras, like the
+	// mutes or the active alerts, are seen to change.
+	extrasGeneration uint64
+
+	// boot is different for every status cache, so that the etags of
+	// a restarted process don't match those of the one before it.
+	boot string
+
+	// documents caches the json of the contract results.
+	documents *statusDocumentCache
+
+	// captures are the last failures captured by probes.
+	captures *captures
+
+	snapshotter *snapshotter
 }
 
 const (
@@ -54,13 +88,64 @@ const (
 	// be retrieved from.
 	DefaultStatusEndpoint = "/status/"
 
-	defaultLinksEndpoint = "/links"
+	defaultLinksEndpoint  = "/links"
+	adminMutesEndpoint    = "/admin/mutes"
+	adminTestEndpoint     = "/admin/alerts/test"
+	adminAckEndpoint      = "/admin/ack"
+	adminEventsEndpoint   = "/admin/events"
+	adminRunEndpoint      = "/admin/events/run"
+	adminDisableEndpoint  = "/admin/events/disable"
+	adminEnableEndpoint   = "/admin/events/enable"
+	adminPlannerEndpoint  = "/admin/planner"
+	adminJournalEndpoint  = "/admin/planner/journal"
+	adminLatenessEndpoint = "/admin/planner/lateness"
+	adminCapturesEndpoint = "/admin/captures"
+	alertsEndpoint        = "/alerts"
+	activeAlertsEndpoint  = "/alerts/active"
+	incidentsEndpoint     = "/incidents"
+	incidentStatsEndpoint = "/incidents/stats"
+	grafanaEndpoint       = "/grafana/"
+	feedEndpoint          = "/feed"
+
+	// mutedStatusKey is the reserved key under which active mute
+	// rules are shown.
+	mutedStatusKey = "__muted"
+
+	// activeAlertsStatusKey is the reserved key under which the
+	// active alerts are shown.
+	activeAlertsStatusKey = "__alerts"
+
+	// SelfMetricsStatusKey is the reserved key under which cynic
+	// publishes its own metrics.
+	SelfMetricsStatusKey = "__cynic"
+
+	// sloStatusKey is the reserved key under which the availability
+	// of the events is shown.
+	sloStatusKey = "__slo"
+
+	// incidentsStatusKey is the reserved key under which incidents
+	// are shown, which keeps them in snapshots.
+	incidentsStatusKey = "__incidents"
+
+	// disabledStatusKey is the reserved key under which disabled
+	// events are shown.
+	disabledStatusKey = "__disabled"
+
+	// overlappingStatusKey is the reserved key under which events
+	// that skipped runs, because they were still running, are shown.
+	overlappingStatusKey = "__overlapping"
+
+	// insecureStatusKey is the reserved key under which events that
+	// don't verify certificates are shown.
+	insecureStatusKey = "__insecure"
 )
 
 // StatusServerNew creates a new status server for cynic.
 func StatusServerNew(host, port, root string) StatusCache {
+	mux := http.NewServeMux()
 	server := &http.Server{
 		Addr:           host + ":" + port,
+		Handler:        mux,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
@@ -73,45 +158,112 @@ func StatusServerNew(host, port, root string) StatusCache {
 
 	return StatusCache{
 		contractResults: &sync.Map{},
+		keyWatches:      &keyWatches{},
+		documents:       &statusDocumentCache{},
+		captures:        &captures{},
+		heartbeats:      heartbeatsNew(),
+		pushes:          &pushes{expires: make(map[string]time.Time)},
 		listener:        listener,
 		server:          server,
+		mux:             mux,
 		alerter:         nil,
 		root:            root,
-		snapshot:        nil,
-		snapshotConfig:  nil,
+		boot:            strconv.FormatInt(time.Now().UnixNano(), 36),
+		snapshotter:     nil,
 	}
 }
 
 // WithSnapshots will make the cache dump snapshots of the data with
 // given intervals when the service starts.
 func (s *StatusCache) WithSnapshots(config *SnapshotConfig) {
-	store := snapshotStoreNew()
-	s.snapshotConfig = config
-	s.snapshot = &store
+	s.snapshotter = snapshotterNew(s, config)
+}
+
+// SnapshotBackend is where the snapshots of the cache are stored, or
+// nil without snapshots.
+func (s *StatusCache) SnapshotBackend() SnapshotBackend {
+	if s.snapshotter == nil {
+		return nil
+	}
+	return s.snapshotter.backend
+}
+
+// WithAlerter binds an alerter to the cache, so that the alerter's
+// state is shown and can be managed through the http interface.
+func (s *StatusCache) WithAlerter(alerter *Alerter) {
+	s.alerter = alerter
+}
+
+// WithSLO binds an SLO tracker to the cache, so that the availability
+// of the events is shown in the status.
+func (s *StatusCache) WithSLO(tracker *SLOTracker) {
+	s.slo = tracker
+}
+
+// WithIncidents binds an incident tracker to the cache, so that the
+// incidents are served, and kept in snapshots.
+func (s *StatusCache) WithIncidents(tracker *IncidentTracker) {
+	s.incidents = tracker
+}
+
+// WithPlanner binds a planner to the cache, so that its events can be
+// managed through the admin interface.
+func (s *StatusCache) WithPlanner(planner *Planner) {
+	s.planner = planner
+}
+
+// WithAdmin enables the admin interface for events and the planner,
+// and requires its token on every admin endpoint.
+func (s *StatusCache) WithAdmin(config *AdminConfig) {
+	s.admin = config
 }
 
 // Start starts all services associated with status caches. This
 // includes the web interface if enabled, and the dumping of statuses
 // in files.
 func (s *StatusCache) Start() {
-	if s.snapshotConfig != nil {
-		tickerSnap := time.NewTicker(s.snapshotConfig.Interval)
-		go func() {
-			for range tickerSnap.C {
-				s.snap()
-			}
-		}()
-
-		tickerDump := time.NewTicker(s.snapshotConfig.DumpEvery)
-		go func() {
-			for range tickerDump.C {
-				s.dump()
-			}
-		}()
+	if s.snapshotter != nil {
+		s.snapshotter.start()
 	}
 
-	http.HandleFunc(s.root, s.makeResponse)
-	http.HandleFunc(defaultLinksEndpoint, s.makeLinks)
+	s.mux.HandleFunc(s.root, s.makeResponse)
+	s.mux.HandleFunc(defaultLinksEndpoint, s.makeLinks)
+	s.mux.HandleFunc(heartbeatEndpoint, s.requireSignature(s.handlePing))
+	if s.push != nil {
+		s.mux.HandleFunc(pushEndpoint, s.requireSignature(s.handlePush))
+	}
+	if s.alerter != nil {
+		s.mux.HandleFunc(adminMutesEndpoint, s.requireAdmin(s.handleMutes))
+		s.mux.HandleFunc(adminTestEndpoint, s.requireAdmin(s.handleTestAlert))
+		s.mux.HandleFunc(adminAckEndpoint, s.requireAdmin(s.handleAck))
+		s.mux.HandleFunc(alertsEndpoint, s.handleAlerts)
+		s.mux.HandleFunc(activeAlertsEndpoint, s.handleActiveAlerts)
+	}
+	if s.incidents != nil {
+		s.mux.HandleFunc(incidentsEndpoint, s.handleIncidents)
+		s.mux.HandleFunc(incidentStatsEndpoint, s.handleIncidentStats)
+	}
+	if s.alerter != nil || s.incidents != nil {
+		s.mux.HandleFunc(feedEndpoint, s.handleFeed)
+	}
+	if s.grafana != nil {
+		s.mux.HandleFunc(grafanaEndpoint, s.handleGrafanaTest)
+		s.mux.HandleFunc(grafanaEndpoint+"search", s.handleGrafanaSearch)
+		s.mux.HandleFunc(grafanaEndpoint+"query", s.handleGrafanaQuery)
+		s.mux.HandleFunc(grafanaEndpoint+"annotations", s.handleGrafanaAnnotations)
+	}
+	if s.admin != nil {
+		s.mux.HandleFunc(adminCapturesEndpoint, s.requireAdmin(s.handleCaptures))
+	}
+	if s.admin != nil && s.planner != nil {
+		s.mux.HandleFunc(adminEventsEndpoint, s.requireAdmin(s.handleEvents))
+		s.mux.HandleFunc(adminRunEndpoint, s.requireAdmin(s.handleRunEvent))
+		s.mux.HandleFunc(adminDisableEndpoint, s.requireAdmin(s.handleToggleEvent(true)))
+		s.mux.HandleFunc(adminEnableEndpoint, s.requireAdmin(s.handleToggleEvent(false)))
+		s.mux.HandleFunc(adminPlannerEndpoint, s.requireAdmin(s.handlePlanner))
+		s.mux.HandleFunc(adminJournalEndpoint, s.requireAdmin(s.handleJournal))
+		s.mux.HandleFunc(adminLatenessEndpoint, s.requireAdmin(s.handleLateness))
+	}
 	err := s.server.Serve(s.listener)
 
 	if !errors.Is(err, http.ErrServerClosed) {
@@ -119,11 +271,21 @@ func (s *StatusCache) Start() {
 	}
 }
 
-// Stop gracefully shuts down the server.
+// Stop gracefully shuts down the server, and flushes any snapshots to
+// disk.
 func (s *StatusCache) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	s.stop(ctx)
+}
+
+// stop is Stop, giving up on open connections once ctx is done.
+func (s *StatusCache) stop(ctx context.Context) {
+	if s.snapshotter != nil {
+		s.snapshotter.stop()
+	}
+
 	err := s.server.Shutdown(ctx)
 	if err != nil {
 		log.Println("could not shutdown status server gracefully: ", err)
@@ -132,13 +294,25 @@ func (s *StatusCache) Stop() {
 
 // Update updates the information about all the contracts that are
 // running on different endpoints.
+//
+// The json of a value is cached until it is updated again, so values
+// should not be changed in place after being given to Update.
 func (s *StatusCache) Update(key string, value interface{}) {
-	s.contractResults.Store(key, value)
+	s.contractResults.Store(key, &statusEntry{value: value})
+	atomic.AddUint64(&s.generation, 1)
+	s.observeKey(key, value)
 }
 
 // Delete removes an entry from the sync map.
 func (s *StatusCache) Delete(key string) {
 	s.contractResults.Delete(key)
+	atomic.AddUint64(&s.generation, 1)
+}
+
+// Generation returns a counter that changes every time the contents
+// of the cache change.
+func (s *StatusCache) Generation() uint64 {
+	return atomic.LoadUint64(&s.generation)
 }
 
 // Get gets the value inside the contract results.
@@ -147,7 +321,7 @@ func (s *StatusCache) Get(key string) (interface{}, error) {
 	if !ok {
 		return nil, ErrStatusValueNotFound
 	}
-	return value, nil
+	return value.(*statusEntry).value, nil
 }
 
 // NumEntries returns the number of entries in the map.
@@ -166,13 +340,17 @@ func (s *StatusCache) GetPort() int {
 	return port
 }
 
-// Dump will dump the contents of the map into a snapshot file.
 func (s *StatusCache) makeResponse(w http.ResponseWriter, req *http.Request) {
 	query := req.URL.Path[len(s.root):]
+	s.expirePushes(s.planner.now())
 
-	jsonBuff, err := s.statusCacheToJSON(query)
+	// The generations are read before the body is made, so that the
+	// etag is never newer than the body.
+	generation := s.Generation()
+	extras := s.statusExtras()
+	etag := s.etag(generation, s.observeExtras(extras))
 
-	w.Header().Set("Content-Type", "application/json")
+	jsonBuff, err := s.statusToJSON(query, extras)
 
 	var ret string
 	if err != nil {
@@ -182,7 +360,81 @@ func (s *StatusCache) makeResponse(w http.ResponseWriter, req *http.Request) {
 		ret = string(jsonBuff)
 	}
 
-	fmt.Fprintf(w, "%s", ret)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	if err == nil {
+		w.Header().Set("ETag", etag)
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if !acceptsGzip(req) {
+		fmt.Fprintf(w, "%s", ret)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+
+	if _, err := gz.Write([]byte(ret)); err != nil {
+		log.Println("problem writing gzipped status response: ", err)
+	}
+}
+
+// etag returns the weak etag of the responses made at the generations
+// of the contract results and of the extras.
+func (s *StatusCache) etag(generation, extrasGeneration uint64) string {
+	return fmt.Sprintf(`W/"%s-%d-%d"`, s.boot, generation, extrasGeneration)
+}
+
+// observeExtras compares the extras with the ones seen last, bumping
+// the generation of the extras if they changed, and returns it.
+func (s *StatusCache) observeExtras(extras map[string]interface{}) uint64 {
+	// maps are encoded with their keys sorted
+	raw, err := json.Marshal(extras)
+
+	s.documents.mux.Lock()
+	defer s.documents.mux.Unlock()
+
+	if err != nil || !bytes.Equal(raw, s.documents.extras) {
+		s.documents.extras = raw
+		s.extrasGeneration++
+	}
+	return s.extrasGeneration
+}
+
+// etagMatches checks an If-None-Match header value against an etag,
+// using weak comparison.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" ||
+			strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(enc, ";")
+		if strings.TrimSpace(fields[0]) != "gzip" {
+			continue
+		}
+
+		for _, param := range fields[1:] {
+			if strings.ReplaceAll(param, " ", "") == "q=0" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
 }
 
 func (s *StatusCache) makeLinks(w http.ResponseWriter, req *http.Request) {
@@ -194,7 +446,7 @@ func (s *StatusCache) makeLinks(w http.ResponseWriter, req *http.Request) {
 		goto end
 	}
 
-	builder.WriteString("<h1>Links to services</h1>")
+	builder.WriteString("<h1>Links to status entries</h1>")
 	s.contractResults.Range(func(k interface{}, v interface{}) bool {
 		keyStr, _ := k.(string)
 
@@ -215,47 +467,209 @@ end:
 	}
 }
 
+// statusCacheToJSON returns the json of the status, or of the entry
+// of the given key. The json may be shared with other callers, and
+// must not be changed.
 func (s *StatusCache) statusCacheToJSON(query string) ([]byte, error) {
-	tmp := make(map[string]interface{})
+	return s.statusToJSON(query, s.statusExtras())
+}
+
+// statusExtras returns the entries shown along with the contract
+// results, under reserved keys.
+func (s *StatusCache) statusExtras() map[string]interface{} {
+	extras := make(map[string]interface{})
+	if s.alerter != nil {
+		if mutes := s.alerter.Mutes(); len(mutes) > 0 {
+			extras[mutedStatusKey] = mutes
+		}
+		if active := s.alerter.ActiveAlerts(); len(active) > 0 {
+			extras[activeAlertsStatusKey] = active
+		}
+	}
+	if s.slo != nil {
+		if reports := s.slo.Reports(time.Now()); len(reports) > 0 {
+			extras[sloStatusKey] = reports
+		}
+	}
+	if s.incidents != nil {
+		if incidents := s.incidents.Incidents(IncidentFilter{}); len(incidents) > 0 {
+			extras[incidentsStatusKey] = incidents
+		}
+	}
+	if s.planner != nil {
+		if disabled := s.planner.Disabled(); len(disabled) > 0 {
+			extras[disabledStatusKey] = disabled
+		}
+		if overlapping := s.planner.Overlapping(); len(overlapping) > 0 {
+			extras[overlappingStatusKey] = overlapping
+		}
+		if insecure := s.planner.Insecure(); len(insecure) > 0 {
+			extras[insecureStatusKey] = insecure
+		}
+	}
+
+	return extras
+}
+
+// statusToJSON is statusCacheToJSON, with the given extras.
+func (s *StatusCache) statusToJSON(query string, extras map[string]interface{}) ([]byte, error) {
+	if len(query) > 0 {
+		if extra, ok := extras[query]; ok {
+			return json.Marshal(extra)
+		}
+
+		value, ok := s.contractResults.Load(query)
+		if !ok {
+			return []byte("null"), nil
+		}
+		return value.(*statusEntry).encoded()
+	}
+
+	document, err := s.resultsDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(extras) == 0 {
+		return document.json, nil
+	}
+
+	return document.withExtras(extras)
+}
+
+// resultsDocument returns the json of the contract results, encoding
+// it again only if they changed since last time.
+func (s *StatusCache) resultsDocument() (*statusDocument, error) {
+	// The generation is read before the data, so that a concurrent
+	// update can at worst cause the document to be encoded again.
+	generation := s.Generation()
+
+	s.documents.mux.Lock()
+	defer s.documents.mux.Unlock()
+
+	if cached := s.documents.document; cached != nil && cached.generation == generation {
+		return cached, nil
+	}
+
+	document := &statusDocument{generation: generation}
 	s.contractResults.Range(func(k interface{}, v interface{}) bool {
 		keyStr, _ := k.(string)
-		tmp[keyStr] = v
+		document.keys = append(document.keys, keyStr)
+		document.entries = append(document.entries, v.(*statusEntry))
 		return true
 	})
-
-	var toEncode interface{}
-	if len(query) > 0 {
-		toEncode = tmp[query]
-	} else {
-		toEncode = tmp
+	sort.Sort(document)
+
+	raws := make([][]byte, len(document.entries))
+	for i, entry := range document.entries {
+		raw, err := entry.encoded()
+		if err != nil {
+			return nil, err
+		}
+		raws[i] = raw
 	}
 
-	jsonEnc, err := json.Marshal(toEncode)
-	return jsonEnc, err
+	document.json = encodeJSONObject(document.keys, raws)
+	s.documents.document = document
+
+	return document, nil
 }
 
-func (s *StatusCache) snap() {
-	data, err := s.statusCacheToJSON("")
-	if err != nil {
-		log.Println("problem snapping map data")
-		return
+// statusEntry is a value of the status cache, along with its json,
+// which is encoded the first time it is asked for.
+type statusEntry struct {
+	value interface{}
+
+	once sync.Once
+	raw  []byte
+	err  error
+}
+
+func (s *statusEntry) encoded() ([]byte, error) {
+	s.once.Do(func() {
+		s.raw, s.err = json.Marshal(s.value)
+	})
+	return s.raw, s.err
+}
+
+// statusDocumentCache holds the last json document of the contract
+// results, and the json of the extras seen last.
+type statusDocumentCache struct {
+	mux      sync.Mutex
+	document *statusDocument
+	extras   []byte
+}
+
+// statusDocument is the json of the contract results, and the
+// entries it was made of, sorted by key.
+type statusDocument struct {
+	generation uint64
+	keys       []string
+	entries    []*statusEntry
+	json       []byte
+}
+
+func (s *statusDocument) Len() int           { return len(s.keys) }
+func (s *statusDocument) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s *statusDocument) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+}
+
+// withExtras returns the json of the document, with extra entries
+// that take the place of entries with the same key.
+func (s *statusDocument) withExtras(extras map[string]interface{}) ([]byte, error) {
+	keys := make([]string, 0, len(s.keys)+len(extras))
+	raws := make(map[string][]byte, len(s.keys)+len(extras))
+
+	for i, key := range s.keys {
+		raw, err := s.entries[i].encoded()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		raws[key] = raw
 	}
 
-	snp := snapshot{
-		Timestamp: time.Now().Unix(),
-		Data:      string(data),
+	for key, extra := range extras {
+		raw, err := json.Marshal(extra)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := raws[key]; !ok {
+			keys = append(keys, key)
+		}
+		raws[key] = raw
 	}
-	s.snapshot.add(&snp)
-}
 
-func (s *StatusCache) dump() {
-	strDate := time.Now().Format(time.RFC3339)
-	filename := fmt.Sprintf("%s.%v.cynic", strDate, s.snapshot.Version)
+	sort.Strings(keys)
+
+	sorted := make([][]byte, len(keys))
+	for i, key := range keys {
+		sorted[i] = raws[key]
+	}
+
+	return encodeJSONObject(keys, sorted), nil
+}
 
-	dumpPath := path.Join(s.snapshotConfig.Path, filename)
-	if err := s.snapshot.encodeToFile(dumpPath); err != nil {
-		log.Println("problem encoding and dumping to file:", err)
+// encodeJSONObject writes a json object out of keys, and the json of
+// their values, the same way json.Marshal writes a map.
+func encodeJSONObject(keys []string, raws [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		// marshalling a string does not fail
+		keyJSON, _ := json.Marshal(key)
+		buf.Write(keyJSON)
+		buf.WriteByte(':')
+		buf.Write(raws[i])
 	}
 
-	s.snapshot.clear()
+	buf.WriteByte('}')
+	return buf.Bytes()
 }
@@ -19,4 +19,19 @@ package cynic
 
 import "fmt"
 
-var ErrStatusValueNotFound = fmt.Errorf("could not find required value")
+var (
+	ErrStatusValueNotFound = fmt.Errorf("could not find required value")
+	ErrAdminUnauthorized   = fmt.Errorf("missing or bad admin token")
+	ErrAdminDisabled       = fmt.Errorf("the admin interface is not enabled")
+	ErrEventNotFound       = fmt.Errorf("no such event")
+	ErrNoStatusCache       = fmt.Errorf("no status cache")
+	ErrNoAlerter           = fmt.Errorf("no alerter")
+	ErrNoPlannerJournal    = fmt.Errorf("the planner has no journal")
+	ErrNoCapture           = fmt.Errorf("no failure captured")
+	ErrPushUnauthorized    = fmt.Errorf("missing or bad push token")
+	ErrPushKey             = fmt.Errorf("bad push key")
+	ErrPushDocument        = fmt.Errorf("bad pushed document")
+	ErrBadTag              = fmt.Errorf("tags must be key:value")
+	ErrBadFeedLimit        = fmt.Errorf("feed limit must be a positive number")
+	ErrBadSignature        = fmt.Errorf("missing or bad signature")
+)
@@ -0,0 +1,412 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// StatusPagePort is the default port of the status page.
+	StatusPagePort = "9997"
+
+	// statusPageDays is how many days of uptime the status page shows.
+	statusPageDays = 90
+
+	defaultStatusPageTitle  = "Status"
+	defaultStatusPageMaxAge = time.Minute
+
+	// statusPageDefaultGroup is the group events without one are
+	// shown under.
+	statusPageDefaultGroup = "default"
+
+	statusPageJSONEndpoint = "/status.json"
+)
+
+// Statuses of the status page, and of its groups.
+const (
+	StatusOperational = "operational"
+	StatusDegraded    = "degraded"
+	StatusOutage      = "outage"
+)
+
+// StatusPageConfig configures a status page.
+type StatusPageConfig struct {
+	Host string
+	Port string
+
+	// Title is the title of the page. It defaults to "Status".
+	Title string
+
+	// Incidents, if set, is where the active incidents shown are
+	// taken from.
+	Incidents *IncidentTracker
+
+	// MaxAge is how long caches may keep the page. It defaults to a
+	// minute.
+	MaxAge time.Duration
+
+	// Clock tells the day the uptime bars end on. Defaults to the
+	// system clock.
+	Clock Clock
+}
+
+// StatusPageDay is the uptime of a group on a day. Uptime is nil if
+// nothing ran that day.
+type StatusPageDay struct {
+	Date   string   `json:"date"`
+	Runs   int      `json:"runs"`
+	Uptime *float64 `json:"uptime"`
+}
+
+// StatusPageGroup is the health of a group of events.
+type StatusPageGroup struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+
+	// Uptime is the percentage of runs that succeeded over the days.
+	Uptime float64         `json:"uptime"`
+	Days   []StatusPageDay `json:"days"`
+}
+
+// StatusPageIncident is an active incident, as shown publicly: the
+// group it affects, and since when.
+type StatusPageIncident struct {
+	Group string    `json:"group"`
+	Since time.Time `json:"since"`
+}
+
+// StatusPageOverview is what the status page shows.
+type StatusPageOverview struct {
+	Title     string               `json:"title"`
+	Status    string               `json:"status"`
+	Updated   time.Time            `json:"updated"`
+	Groups    []StatusPageGroup    `json:"groups"`
+	Incidents []StatusPageIncident `json:"incidents"`
+}
+
+// StatusPage is a result sink keeping the daily uptime of the groups of
+// events, which it serves as a public status page, on a port of its
+// own, along with the active incidents. Only group names, uptimes and
+// times are shown: no labels, urls, tags or errors. Like the SLO
+// tracker, it keeps its counts in memory, so it starts over when cynic
+// restarts.
+type StatusPage struct {
+	config   StatusPageConfig
+	clock    Clock
+	server   *http.Server
+	listener net.Listener
+
+	mux    sync.Mutex
+	groups map[string]*statusPageGroup
+}
+
+type statusPageGroup struct {
+	days [statusPageDays]sloBucket
+
+	// failing are the events of the group whose last run failed.
+	failing map[string]bool
+	events  map[string]bool
+}
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"dayClass": statusPageDayClass,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; color: #222; }
+.operational { color: #2a7; } .degraded { color: #d90; } .outage { color: #c33; }
+.bars { display: flex; gap: 1px; height: 2em; }
+.bar { flex: 1; background: #ccc; }
+.bar.up { background: #2a7; } .bar.partial { background: #d90; } .bar.down { background: #c33; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<h2 class="{{.Status}}">{{.Status}}</h2>
+{{range .Incidents}}<p class="outage">{{.Group}}: incident since {{.Since.Format "2006-01-02 15:04 MST"}}</p>
+{{end}}{{range .Groups}}<h3>{{.Name}} <span class="{{.Status}}">{{.Status}}</span></h3>
+<p>{{printf "%.3f" .Uptime}}% uptime</p>
+<div class="bars">{{range .Days}}
+<div class="bar {{dayClass .}}" title="{{.Date}}{{with .Uptime}}: {{printf "%.2f" .}}%{{end}}"></div>
+{{- end}}</div>
+{{end}}<p><small>Updated {{.Updated.Format "2006-01-02 15:04:05 MST"}}</small></p>
+</body>
+</html>
+`))
+
+// StatusPageNew creates a status page listening on its host and port,
+// to add to the result sinks of the planner, and to start.
+func StatusPageNew(config StatusPageConfig) (*StatusPage, error) {
+	if config.Port == "" {
+		config.Port = StatusPagePort
+	}
+	if config.Title == "" {
+		config.Title = defaultStatusPageTitle
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = defaultStatusPageMaxAge
+	}
+
+	page := &StatusPage{
+		config: config,
+		clock:  clockOr(config.Clock),
+		groups: make(map[string]*statusPageGroup),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", page.handlePage)
+	mux.HandleFunc(statusPageJSONEndpoint, page.handleJSON)
+
+	page.server = &http.Server{
+		Addr:           config.Host + ":" + config.Port,
+		Handler:        mux,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	listener, err := net.Listen("tcp", page.server.Addr)
+	if err != nil {
+		return nil, err
+	}
+	page.listener = listener
+
+	return page, nil
+}
+
+// Record counts the run in the day of the group of the event.
+func (s *StatusPage) Record(result EventResult) {
+	if result.Async {
+		return
+	}
+
+	at := result.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	name := result.Group
+	if name == "" {
+		name = statusPageDefaultGroup
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	group, ok := s.groups[name]
+	if !ok {
+		group = &statusPageGroup{failing: make(map[string]bool), events: make(map[string]bool)}
+		s.groups[name] = group
+	}
+
+	key := resultKey(result)
+	group.events[key] = true
+	if result.Failed {
+		group.failing[key] = true
+	} else {
+		delete(group.failing, key)
+	}
+
+	sloBuckets(group.days[:]).count(statusPageDay(at), result.Failed)
+}
+
+// statusPageDay is the day of t, in UTC, counted from the epoch.
+func statusPageDay(t time.Time) int64 {
+	return t.Unix() / (24 * 3600)
+}
+
+// Overview returns what the status page shows, as of now.
+func (s *StatusPage) Overview() StatusPageOverview {
+	now := s.clock.Now()
+	today := statusPageDay(now)
+
+	overview := StatusPageOverview{
+		Title:     s.config.Title,
+		Status:    StatusOperational,
+		Updated:   now,
+		Groups:    make([]StatusPageGroup, 0),
+		Incidents: make([]StatusPageIncident, 0),
+	}
+
+	s.mux.Lock()
+	failing := 0
+	for name, group := range s.groups {
+		report := group.report(name, today)
+		if report.Status != StatusOperational {
+			failing++
+		}
+		overview.Groups = append(overview.Groups, report)
+	}
+	s.mux.Unlock()
+
+	sort.Slice(overview.Groups, func(i, j int) bool {
+		return overview.Groups[i].Name < overview.Groups[j].Name
+	})
+
+	switch {
+	case failing > 0 && failing == len(overview.Groups):
+		overview.Status = StatusOutage
+	case failing > 0:
+		overview.Status = StatusDegraded
+	}
+
+	if s.config.Incidents != nil {
+		for _, incident := range s.config.Incidents.Incidents(IncidentFilter{OnlyOpen: true}) {
+			group := incident.Group
+			if group == "" {
+				group = statusPageDefaultGroup
+			}
+			overview.Incidents = append(overview.Incidents, StatusPageIncident{Group: group, Since: incident.Start})
+		}
+	}
+
+	return overview
+}
+
+func (s *statusPageGroup) report(name string, today int64) StatusPageGroup {
+	report := StatusPageGroup{
+		Name:   name,
+		Status: StatusOperational,
+		Uptime: 100,
+		Days:   make([]StatusPageDay, 0, statusPageDays),
+	}
+
+	switch {
+	case len(s.failing) > 0 && len(s.failing) == len(s.events):
+		report.Status = StatusOutage
+	case len(s.failing) > 0:
+		report.Status = StatusDegraded
+	}
+
+	var runs, failed uint64
+	for day := today - statusPageDays + 1; day <= today; day++ {
+		entry := StatusPageDay{Date: time.Unix(day*24*3600, 0).UTC().Format("2006-01-02")}
+
+		bucket := &s.days[day%statusPageDays]
+		if bucket.slot == day && bucket.runs > 0 {
+			uptime := 100 * float64(bucket.runs-bucket.failed) / float64(bucket.runs)
+			entry.Runs = int(bucket.runs)
+			entry.Uptime = &uptime
+
+			runs += uint64(bucket.runs)
+			failed += uint64(bucket.failed)
+		}
+
+		report.Days = append(report.Days, entry)
+	}
+
+	if runs > 0 {
+		report.Uptime = 100 * float64(runs-failed) / float64(runs)
+	}
+
+	return report
+}
+
+func statusPageDayClass(day StatusPageDay) string {
+	switch {
+	case day.Uptime == nil:
+		return ""
+	case *day.Uptime == 100:
+		return "up"
+	case *day.Uptime == 0:
+		return "down"
+	default:
+		return "partial"
+	}
+}
+
+// cacheHeaders lets caches, and proxies, keep the page for its max
+// age.
+func (s *StatusPage) cacheHeaders(w http.ResponseWriter, updated time.Time) {
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(s.config.MaxAge/time.Second)))
+	w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+}
+
+func (s *StatusPage) handlePage(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	overview := s.Overview()
+	s.cacheHeaders(w, overview.Updated)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := statusPageTemplate.Execute(w, &overview); err != nil {
+		log.Println("problem rendering status page: ", err)
+	}
+}
+
+func (s *StatusPage) handleJSON(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	overview := s.Overview()
+	s.cacheHeaders(w, overview.Updated)
+	writeJSON(w, http.StatusOK, &overview)
+}
+
+// GetPort returns the port the status page listens on.
+func (s *StatusPage) GetPort() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Start serves the status page, until it is stopped.
+func (s *StatusPage) Start() {
+	err := s.server.Serve(s.listener)
+
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Println("problem serving status page: ", err)
+	}
+}
+
+// Stop gracefully shuts down the status page.
+func (s *StatusPage) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	s.stop(ctx)
+}
+
+func (s *StatusPage) stop(ctx context.Context) {
+	if err := s.server.Shutdown(ctx); err != nil {
+		log.Println("could not shutdown status page gracefully: ", err)
+	}
+
+	// the listener is only closed by the shutdown if it was served
+	s.listener.Close()
+}
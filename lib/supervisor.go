@@ -0,0 +1,149 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Supervisor runs several sessions in one process, each with its own
+// planner, status cache and alerter, and each started and stopped on
+// its own.
+type Supervisor struct {
+	mux      sync.Mutex
+	sessions map[string]*supervised
+}
+
+type supervised struct {
+	session Session
+	runner  *Runner
+}
+
+// SupervisorNew creates a supervisor with no sessions.
+func SupervisorNew() *Supervisor {
+	return &Supervisor{
+		sessions: make(map[string]*supervised),
+	}
+}
+
+// Add starts a session under a name. Sessions may not share their
+// planner, status cache or alerter with another session.
+func (s *Supervisor) Add(name string, session Session) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.sessions[name]; ok {
+		return fmt.Errorf("%w: %s", ErrSessionExists, name)
+	}
+
+	for other, running := range s.sessions {
+		if shared := sharedPart(&session, &running.session); shared != "" {
+			return fmt.Errorf("%w: %s shares its %s with %s", ErrSessionInvalid, name, shared, other)
+		}
+	}
+
+	runner, err := StartWithStopper(session)
+	if err != nil {
+		return err
+	}
+
+	s.sessions[name] = &supervised{session: session, runner: runner}
+	return nil
+}
+
+// Stop stops the session with the given name, and removes it.
+func (s *Supervisor) Stop(ctx context.Context, name string) error {
+	s.mux.Lock()
+	running, ok := s.sessions[name]
+	delete(s.sessions, name)
+	s.mux.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, name)
+	}
+
+	return running.runner.Stop(ctx)
+}
+
+// StopAll stops all sessions at once, and returns the first error.
+func (s *Supervisor) StopAll(ctx context.Context) error {
+	s.mux.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*supervised)
+	s.mux.Unlock()
+
+	errs := make(chan error, len(sessions))
+	for _, running := range sessions {
+		go func(runner *Runner) {
+			errs <- runner.Stop(ctx)
+		}(running.runner)
+	}
+
+	var first error
+	for range sessions {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// Names returns the names of the running sessions, sorted.
+func (s *Supervisor) Names() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	names := make([]string, 0, len(s.sessions))
+	for name := range s.sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Runner returns the runner of the session with the given name.
+func (s *Supervisor) Runner(name string) (*Runner, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	running, ok := s.sessions[name]
+	if !ok {
+		return nil, false
+	}
+
+	return running.runner, true
+}
+
+// sharedPart returns what two sessions share, that they should not.
+func sharedPart(a, b *Session) string {
+	switch {
+	case a.Planner != nil && a.Planner == b.Planner:
+		return "planner"
+	case a.StatusCache != nil && a.StatusCache == b.StatusCache:
+		return "status cache"
+	case a.Alerter != nil && a.Alerter == b.Alerter:
+		return "alerter"
+	}
+
+	return ""
+}
@@ -0,0 +1,267 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Syslog facilities, as numbered by RFC5424.
+const (
+	SyslogFacilityUser   = 1
+	SyslogFacilityDaemon = 3
+	SyslogFacilityLocal0 = 16
+	SyslogFacilityLocal7 = 23
+)
+
+var syslogFacilities = map[string]int{
+	"user":   SyslogFacilityUser,
+	"daemon": SyslogFacilityDaemon,
+}
+
+func init() {
+	for i := 0; i <= SyslogFacilityLocal7-SyslogFacilityLocal0; i++ {
+		syslogFacilities["local"+strconv.Itoa(i)] = SyslogFacilityLocal0 + i
+	}
+}
+
+// ParseSyslogFacility returns the facility with the given name:
+// "user", "daemon", or "local0" to "local7". An empty name is daemon.
+func ParseSyslogFacility(name string) (int, error) {
+	if name == "" {
+		return SyslogFacilityDaemon, nil
+	}
+
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownSyslogFacility, name)
+	}
+	return facility, nil
+}
+
+// Syslog severities, as numbered by RFC5424.
+const (
+	syslogCritical = 2
+	syslogWarning  = 4
+	syslogInfo     = 6
+)
+
+const (
+	defaultSyslogAppName = "cynic"
+
+	// syslogSDID is the id of the structured data of alerts. 32473
+	// is the enterprise number reserved for documentation.
+	syslogSDID = "cynic@32473"
+
+	syslogDialTimeout = 5 * time.Second
+)
+
+var (
+	// syslogLocalSockets are where the local syslog daemon listens,
+	// depending on the system.
+	syslogLocalSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+	syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+)
+
+// SyslogSink writes alerts, and log lines, to syslog, in the RFC5424
+// format. Its Alert method can be given to AlerterNew, and it is an
+// io.Writer, so that logs can be sent to syslog with log.SetOutput.
+//
+// Alerts carry their event id, label, group, severity and fingerprint
+// as structured data.
+type SyslogSink struct {
+	network  string
+	addr     string
+	facility int
+	appName  string
+	hostname string
+	template *AlertTemplate
+
+	mux  sync.Mutex
+	conn net.Conn
+}
+
+// SyslogSinkNew creates a sink writing to the syslog server at addr,
+// over the given network: "udp", "tcp", or "unixgram". Without a
+// network, it writes to the local syslog daemon. Messages over tcp
+// are framed with octet counting.
+func SyslogSinkNew(network, addr string) *SyslogSink {
+	return &SyslogSink{
+		network:  network,
+		addr:     addr,
+		facility: SyslogFacilityDaemon,
+		appName:  defaultSyslogAppName,
+		hostname: currentHost(),
+	}
+}
+
+// SetFacility sets the facility of the messages. It defaults to
+// daemon.
+func (s *SyslogSink) SetFacility(facility int) {
+	s.facility = facility
+}
+
+// SetAppName sets the app name of the messages. It defaults to
+// "cynic".
+func (s *SyslogSink) SetAppName(appName string) {
+	s.appName = appName
+}
+
+// SetTemplate sets the template used for the text of each alert. By
+// default the hook result is printed as is.
+func (s *SyslogSink) SetTemplate(template *AlertTemplate) {
+	s.template = template
+}
+
+// Alert writes each alert as a message, with the severity of its
+// event. It stops at the first message that can't be sent, and
+// returns its error, so that the alerts are retried.
+func (s *SyslogSink) Alert(messages []AlertMessage) error {
+	for i := range messages {
+		msg := &messages[i]
+
+		params := [...][2]string{
+			{"event_id", strconv.FormatUint(msg.EventID, 10)},
+			{"label", msg.Label},
+			{"group", msg.Group},
+			{"severity", msg.Severity.String()},
+			{"fingerprint", msg.Fingerprint},
+			{"root_cause", msg.RootCause},
+			{"location", msg.Location},
+		}
+
+		var sd strings.Builder
+		sd.WriteString("[" + syslogSDID)
+		for _, param := range params {
+			if param[1] == "" {
+				continue
+			}
+			fmt.Fprintf(&sd, ` %s="%s"`, param[0], syslogParamEscaper.Replace(param[1]))
+		}
+		sd.WriteString("]")
+
+		text := alertText(s.template, msg)
+		if err := s.send(syslogSeverity(msg.Severity), "alert", sd.String(), text); err != nil {
+			return fmt.Errorf("could not send alert to syslog: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Write writes a log line, at the info severity.
+func (s *SyslogSink) Write(p []byte) (int, error) {
+	text := strings.TrimRight(string(p), "\n")
+	if err := s.send(syslogInfo, "log", "-", text); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection to syslog.
+func (s *SyslogSink) Close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// send writes a message, connecting first if needed. On errors the
+// connection is dropped, to be made again with the next message.
+func (s *SyslogSink) send(severity int, msgID, structuredData, text string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "<%d>1 %s %s %s %d %s %s %s",
+		s.facility*8+severity,
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(s.hostname),
+		syslogHeaderField(s.appName),
+		os.Getpid(),
+		msgID,
+		structuredData,
+		text)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	data := msg.Bytes()
+	if s.network == "tcp" {
+		data = append([]byte(strconv.Itoa(len(data))+" "), data...)
+	}
+
+	if _, err := s.conn.Write(data); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	if s.network != "" {
+		return net.DialTimeout(s.network, s.addr, syslogDialTimeout)
+	}
+
+	var err error
+	for _, path := range syslogLocalSockets {
+		var conn net.Conn
+		if conn, err = net.Dial("unixgram", path); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// syslogHeaderField is a header field value, or the nil value if it is
+// empty. Header fields can't have spaces.
+func syslogHeaderField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(value, " ", "_")
+}
+
+func syslogSeverity(severity Severity) int {
+	switch severity {
+	case SeverityCritical:
+		return syslogCritical
+	case SeverityWarning:
+		return syslogWarning
+	default:
+		return syslogInfo
+	}
+}
@@ -0,0 +1,294 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ThresholdConfig alerts on a number in the json body of a probed url,
+// found by Path, a JSONPath like $.queues[0].depth or
+// $.stats["in flight"]. Unset bounds are not checked.
+type ThresholdConfig struct {
+	Path  string   `json:"path"`
+	Above *float64 `json:"above"`
+	Below *float64 `json:"below"`
+
+	// MaxDelta fails the probe when the value moved by more than
+	// this, either way, over Window.
+	MaxDelta *float64       `json:"max_delta"`
+	Window   ConfigDuration `json:"window"`
+
+	// MaxRate fails the probe when the value moves faster than this,
+	// either way, per second between two probes.
+	MaxRate *float64 `json:"max_rate"`
+}
+
+// ThresholdRules checks threshold rules against json bodies. It keeps
+// the past values of each rule, for their deltas and rates.
+type ThresholdRules struct {
+	rules []ThresholdConfig
+	paths [][]jsonPathStep
+
+	mux     sync.Mutex
+	samples [][]thresholdSample
+}
+
+type thresholdSample struct {
+	at    time.Time
+	value float64
+}
+
+// ThresholdRulesNew returns the rules, or an error if a path does not
+// parse.
+func ThresholdRulesNew(rules []ThresholdConfig) (*ThresholdRules, error) {
+	ret := &ThresholdRules{
+		rules:   rules,
+		paths:   make([][]jsonPathStep, len(rules)),
+		samples: make([][]thresholdSample, len(rules)),
+	}
+
+	for i, rule := range rules {
+		path, err := parseJSONPath(rule.Path)
+		if err != nil {
+			return nil, err
+		}
+		ret.paths[i] = path
+	}
+
+	return ret, nil
+}
+
+// Check checks the rules against a body read at the given time. It
+// returns the value of each path, and the rules that failed.
+func (s *ThresholdRules) Check(body []byte, at time.Time) (map[string]float64, []string) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, []string{fmt.Sprintf("%v: body is not json: %v", ErrProbeThreshold, err)}
+	}
+
+	return s.CheckDocument(doc, at)
+}
+
+// CheckDocument is like Check, with a body already decoded as json.
+func (s *ThresholdRules) CheckDocument(doc interface{}, at time.Time) (map[string]float64, []string) {
+	var failures []string
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	values := make(map[string]float64, len(s.rules))
+	for i := range s.rules {
+		rule := &s.rules[i]
+
+		value, err := jsonPathNumber(doc, s.paths[i])
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %s: %v", ErrProbeThreshold, rule.Path, err))
+			continue
+		}
+		values[rule.Path] = value
+
+		if err := rule.check(value, s.samples[i], at); err != nil {
+			failures = append(failures, err.Error())
+		}
+		s.samples[i] = rule.keep(s.samples[i], thresholdSample{at: at, value: value})
+	}
+
+	return values, failures
+}
+
+// check checks a value against the rule, and the past values of the
+// rule, oldest first.
+func (s *ThresholdConfig) check(value float64, samples []thresholdSample, at time.Time) error {
+	if s.Above != nil && value > *s.Above {
+		return fmt.Errorf("%w: %s is %g, above %g", ErrProbeThreshold, s.Path, value, *s.Above)
+	}
+
+	if s.Below != nil && value < *s.Below {
+		return fmt.Errorf("%w: %s is %g, below %g", ErrProbeThreshold, s.Path, value, *s.Below)
+	}
+
+	if len(samples) == 0 {
+		return nil
+	}
+
+	if s.MaxDelta != nil {
+		// the oldest value within the window
+		for _, oldest := range samples {
+			if at.Sub(oldest.at) > time.Duration(s.Window) {
+				continue
+			}
+			if delta := value - oldest.value; math.Abs(delta) > *s.MaxDelta {
+				return fmt.Errorf("%w: %s moved by %g in %s, over %g",
+					ErrProbeThreshold, s.Path, delta, at.Sub(oldest.at).Round(time.Second), *s.MaxDelta)
+			}
+			break
+		}
+	}
+
+	last := samples[len(samples)-1]
+	if elapsed := at.Sub(last.at).Seconds(); s.MaxRate != nil && elapsed > 0 {
+		if rate := (value - last.value) / elapsed; math.Abs(rate) > *s.MaxRate {
+			return fmt.Errorf("%w: %s moves by %g/s, over %g/s", ErrProbeThreshold, s.Path, rate, *s.MaxRate)
+		}
+	}
+
+	return nil
+}
+
+// keep adds a sample to the past values of the rule, dropping those
+// older than its window. The last value is always kept, for the rate.
+func (s *ThresholdConfig) keep(samples []thresholdSample, sample thresholdSample) []thresholdSample {
+	samples = append(samples, sample)
+
+	window := time.Duration(s.Window)
+	if s.MaxDelta == nil || window <= 0 {
+		return samples[len(samples)-1:]
+	}
+
+	drop := 0
+	for drop < len(samples)-1 && sample.at.Sub(samples[drop].at) > window {
+		drop++
+	}
+
+	return samples[drop:]
+}
+
+// jsonPathStep is a step into a json document: a key of an object, or
+// an index of an array when key is empty.
+type jsonPathStep struct {
+	key   string
+	index int
+}
+
+// parseJSONPath parses the subset of JSONPath that finds a single
+// value: an optional $, followed by .key, ["key"], ['key'] and [index]
+// steps.
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	if rest == "" {
+		return nil, fmt.Errorf("%w: %q is empty", ErrThresholdPath, path)
+	}
+
+	var steps []jsonPathStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("%w: %q has an empty key", ErrThresholdPath, path)
+			}
+			steps = append(steps, jsonPathStep{key: key})
+			rest = rest[end+1:]
+
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: %q has an unclosed [", ErrThresholdPath, path)
+			}
+
+			step, err := parseJSONPathBracket(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q: %v", ErrThresholdPath, path, err)
+			}
+			steps = append(steps, step)
+			rest = rest[end+1:]
+
+		default:
+			if len(steps) > 0 {
+				return nil, fmt.Errorf("%w: %q: unexpected %q", ErrThresholdPath, path, rest)
+			}
+			// a path may start with its first key, without a dot
+			rest = "." + rest
+		}
+	}
+
+	return steps, nil
+}
+
+func parseJSONPathBracket(inside string) (jsonPathStep, error) {
+	if len(inside) >= 2 && (inside[0] == '"' || inside[0] == '\'') && inside[len(inside)-1] == inside[0] {
+		return jsonPathStep{key: inside[1 : len(inside)-1]}, nil
+	}
+
+	index, err := strconv.Atoi(inside)
+	if err != nil || index < 0 {
+		return jsonPathStep{}, fmt.Errorf("bad index %q", inside)
+	}
+
+	return jsonPathStep{index: index}, nil
+}
+
+// jsonPathNumber follows the steps into a decoded json document, to a
+// number, or a string holding one.
+func jsonPathNumber(doc interface{}, steps []jsonPathStep) (float64, error) {
+	doc, err := jsonPathValue(doc, steps)
+	if err != nil {
+		return 0, err
+	}
+
+	switch value := doc.(type) {
+	case float64:
+		return value, nil
+	case string:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", value)
+		}
+		return number, nil
+	default:
+		return 0, fmt.Errorf("not a number")
+	}
+}
+
+// jsonPathValue follows the steps into a decoded json document, to a
+// value.
+func jsonPathValue(doc interface{}, steps []jsonPathStep) (interface{}, error) {
+	for _, step := range steps {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[step.key]
+			if step.key == "" || !ok {
+				return nil, fmt.Errorf("no key %q", step.key)
+			}
+			doc = value
+
+		case []interface{}:
+			if step.key != "" || step.index >= len(node) {
+				return nil, fmt.Errorf("no index %d", step.index)
+			}
+			doc = node[step.index]
+
+		default:
+			return nil, fmt.Errorf("not found")
+		}
+	}
+
+	return doc, nil
+}
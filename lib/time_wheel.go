@@ -0,0 +1,194 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+const (
+	// wheelBits is the number of bits of time each level of the
+	// wheel covers: each level has 64 slots.
+	wheelBits  = 6
+	wheelSlots = 1 << wheelBits
+	wheelMask  = wheelSlots - 1
+
+	// wheelLevels of 64 slots cover 64^5 seconds, about 34 years.
+	// Events further out wait in a separate list.
+	wheelLevels = 5
+)
+
+// timingWheel is a hierarchical timing wheel: level 0 has a slot per
+// tick, and every level above has slots 64 times as wide as the one
+// below. Adding and removing events takes constant time, and each
+// event moves down a level at most once per level, as time goes by.
+type timingWheel struct {
+	current int
+	levels  [wheelLevels][wheelSlots][]*Event
+	far     []*Event
+	due     []*Event
+	byID    map[uint64]*Event
+}
+
+func timingWheelNew() *timingWheel {
+	return &timingWheel{
+		byID: make(map[uint64]*Event),
+	}
+}
+
+func (s *timingWheel) Len() int {
+	return len(s.byID)
+}
+
+// Push adds an event to expire on its absolute expiry, or moves it
+// there if it is queued already.
+func (s *timingWheel) Push(event *Event) {
+	if queued, ok := s.byID[event.ID()]; ok {
+		s.unlink(queued)
+	}
+
+	s.byID[event.ID()] = event
+	s.place(event)
+}
+
+// Remove removes the event with the given id, if it is queued.
+func (s *timingWheel) Remove(id uint64) (*Event, bool) {
+	event, ok := s.byID[id]
+	if !ok {
+		return nil, false
+	}
+
+	s.unlink(event)
+	delete(s.byID, id)
+	return event, true
+}
+
+// Contains returns if the event with the given id is queued.
+func (s *timingWheel) Contains(id uint64) bool {
+	_, ok := s.byID[id]
+	return ok
+}
+
+// Events returns the queued events, in no particular order.
+func (s *timingWheel) Events() []*Event {
+	events := make([]*Event, 0, len(s.byID))
+	for _, event := range s.byID {
+		events = append(events, event)
+	}
+	return events
+}
+
+// popDue turns the wheel up to now, and removes and returns an event
+// that is due by then, or nil if there is none.
+func (s *timingWheel) popDue(now int) *Event {
+	for s.current < now {
+		s.advance()
+	}
+
+	n := len(s.due)
+	if n == 0 {
+		return nil
+	}
+
+	event := s.due[n-1]
+	s.unlink(event)
+	delete(s.byID, event.ID())
+	return event
+}
+
+// advance moves the wheel by one tick, cascading the slots of the
+// higher levels that are now due into the lower ones, and moving the
+// events of the current slot to the due list.
+func (s *timingWheel) advance() {
+	s.current++
+
+	if s.current&(1<<(wheelBits*(wheelLevels-1))-1) == 0 {
+		far := s.far
+		s.far = nil
+		for _, event := range far {
+			s.place(event)
+		}
+	}
+
+	for level := wheelLevels - 1; level > 0; level-- {
+		if s.current&(1<<(wheelBits*level)-1) != 0 {
+			continue
+		}
+
+		slot := (s.current >> (wheelBits * level)) & wheelMask
+		events := s.levels[level][slot]
+		s.levels[level][slot] = nil
+		for _, event := range events {
+			s.place(event)
+		}
+	}
+
+	slot := s.current & wheelMask
+	for _, event := range s.levels[0][slot] {
+		s.link(&s.due, event, -1, -1)
+	}
+	s.levels[0][slot] = nil
+}
+
+// place puts an event in the list it belongs to, given how far in the
+// future it expires.
+func (s *timingWheel) place(event *Event) {
+	delta := event.priority - s.current
+	if delta <= 0 {
+		s.link(&s.due, event, -1, -1)
+		return
+	}
+
+	for level := 0; level < wheelLevels; level++ {
+		if delta < 1<<(wheelBits*(level+1)) {
+			slot := (event.priority >> (wheelBits * level)) & wheelMask
+			s.link(&s.levels[level][slot], event, level, slot)
+			return
+		}
+	}
+
+	s.link(&s.far, event, wheelLevels, -1)
+}
+
+// link appends an event to a list, and records where it is so that it
+// can be unlinked in constant time.
+func (s *timingWheel) link(list *[]*Event, event *Event, level, slot int) {
+	event.wheelLevel = level
+	event.wheelSlot = slot
+	event.index = len(*list)
+	*list = append(*list, event)
+}
+
+// unlink removes an event from the list it is in.
+func (s *timingWheel) unlink(event *Event) {
+	var list *[]*Event
+	switch {
+	case event.wheelLevel < 0:
+		list = &s.due
+	case event.wheelLevel == wheelLevels:
+		list = &s.far
+	default:
+		list = &s.levels[event.wheelLevel][event.wheelSlot]
+	}
+
+	last := len(*list) - 1
+	if event.index != last {
+		moved := (*list)[last]
+		(*list)[event.index] = moved
+		moved.index = event.index
+	}
+	(*list)[last] = nil
+	*list = (*list)[:last]
+	event.index = -1
+}
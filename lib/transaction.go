@@ -0,0 +1,404 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTransactionTimeout is how long all the steps of a transaction
+// may take, unless the event has a timeout.
+const defaultTransactionTimeout = 30 * time.Second
+
+// TransactionConfig is a check of several requests in a row, like a
+// user logging in, fetching a page and logging out. The steps share
+// their cookies, and variables extracted from earlier responses.
+type TransactionConfig struct {
+	// Variables are the variables the steps start with.
+	Variables map[string]string `json:"variables"`
+
+	Steps []TransactionStep `json:"steps"`
+}
+
+// TransactionStep is a request of a transaction. Its url, headers and
+// body may use the variables, as {name}.
+type TransactionStep struct {
+	Name string `json:"name"`
+
+	// Method defaults to GET.
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+
+	// Contracts are what the response must satisfy.
+	Contracts []ContractConfig `json:"contracts"`
+
+	// Extract sets variables, by name, from the response, for the
+	// steps after it.
+	Extract map[string]ExtractConfig `json:"extract"`
+
+	// Always runs the step even if one before it failed, like a
+	// logout. The other steps after a failure are skipped.
+	Always bool `json:"always"`
+}
+
+// ExtractConfig finds a value in a response: by JSONPath in its json
+// body, as the first group of a regular expression matching its body,
+// or as one of its headers.
+type ExtractConfig struct {
+	JSON   string `json:"json"`
+	Regex  string `json:"regex"`
+	Header string `json:"header"`
+}
+
+// TransactionResult is what the hook of a transaction stores in the
+// status cache.
+type TransactionResult struct {
+	LatencyMs int64 `json:"latency_ms"`
+
+	// FailedStep is the name of the first step that failed.
+	FailedStep string `json:"failed_step,omitempty"`
+
+	// Throttled is set when a step was not made, as it would have
+	// gone over the host limits. The steps after it are skipped like
+	// after a failure, and the run of the event is inconclusive, unless
+	// a step failed.
+	Throttled bool `json:"throttled,omitempty"`
+
+	Steps []TransactionStepResult `json:"steps"`
+
+	Location string            `json:"location,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// failedURL is the url of the failed step, if one failed.
+func (s *TransactionResult) failedURL() string {
+	if s.FailedStep == "" {
+		return ""
+	}
+
+	for i := range s.Steps {
+		if s.Steps[i].Name == s.FailedStep {
+			return s.Steps[i].URL
+		}
+	}
+	return ""
+}
+
+// TransactionStepResult is how a step of a transaction went. Variables
+// are not kept, as they often hold credentials.
+type TransactionStepResult struct {
+	Name      string   `json:"name"`
+	Method    string   `json:"method"`
+	URL       string   `json:"url"`
+	Status    int      `json:"status,omitempty"`
+	LatencyMs int64    `json:"latency_ms"`
+	Error     string   `json:"error,omitempty"`
+	Failures  []string `json:"failures,omitempty"`
+
+	// Throttled is set when the step was not made, as it would have
+	// gone over the host limits.
+	Throttled bool `json:"throttled,omitempty"`
+
+	// Skipped is set when the step did not run, as one before it
+	// failed or was throttled.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+func (s *TransactionStepResult) failed() bool {
+	return s.Error != "" || len(s.Failures) > 0
+}
+
+// transactionStep is a step, with its extractors compiled.
+type transactionStep struct {
+	TransactionStep
+	extractors []extractor
+}
+
+type extractor struct {
+	variable string
+	json     []jsonPathStep
+	regex    *regexp.Regexp
+	header   string
+}
+
+func (s *TransactionConfig) validate() error {
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("a transaction needs steps")
+	}
+
+	_, err := s.compile()
+	return err
+}
+
+func (s *TransactionConfig) compile() ([]transactionStep, error) {
+	steps := make([]transactionStep, 0, len(s.Steps))
+	for i, step := range s.Steps {
+		if step.Name == "" {
+			step.Name = strconv.Itoa(i + 1)
+		}
+		if step.Method == "" {
+			step.Method = http.MethodGet
+		}
+		if step.URL == "" {
+			return nil, fmt.Errorf("step %s needs a url", step.Name)
+		}
+
+		compiled := transactionStep{TransactionStep: step}
+		for variable, extract := range step.Extract {
+			extractor, err := extract.compile(variable)
+			if err != nil {
+				return nil, fmt.Errorf("step %s: %v", step.Name, err)
+			}
+			compiled.extractors = append(compiled.extractors, extractor)
+		}
+
+		steps = append(steps, compiled)
+	}
+
+	return steps, nil
+}
+
+func (s *ExtractConfig) compile(variable string) (extractor, error) {
+	compiled := extractor{variable: variable, header: s.Header}
+
+	given := 0
+	if s.JSON != "" {
+		given++
+		steps, err := parseJSONPath(s.JSON)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.json = steps
+	}
+	if s.Regex != "" {
+		given++
+		regex, err := regexp.Compile(s.Regex)
+		if err != nil {
+			return compiled, fmt.Errorf("extract %s: %v", variable, err)
+		}
+		compiled.regex = regex
+	}
+	if s.Header != "" {
+		given++
+	}
+
+	if given != 1 {
+		return compiled, fmt.Errorf("extract %s needs one of json, regex or header", variable)
+	}
+	return compiled, nil
+}
+
+// extract finds the value in the response, and its body.
+func (s *extractor) extract(resp *http.Response, body []byte) (string, error) {
+	switch {
+	case s.header != "":
+		value := resp.Header.Get(s.header)
+		if value == "" {
+			return "", fmt.Errorf("no header %s", s.header)
+		}
+		return value, nil
+
+	case s.regex != nil:
+		match := s.regex.FindSubmatch(body)
+		switch {
+		case match == nil:
+			return "", fmt.Errorf("no match of %s", s.regex)
+		case len(match) > 1:
+			return string(match[1]), nil
+		default:
+			return string(match[0]), nil
+		}
+
+	default:
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", fmt.Errorf("body is not json: %v", err)
+		}
+		value, err := jsonPathValue(doc, s.json)
+		if err != nil {
+			return "", err
+		}
+		if text, ok := value.(string); ok {
+			return text, nil
+		}
+		raw, err := json.Marshal(value)
+		return string(raw), err
+	}
+}
+
+// transactionHookNew returns a hook running the steps of the
+// transaction, and storing its result under key.
+func transactionHookNew(key string, config *TransactionConfig) (HookSignature, error) {
+	steps, err := config.compile()
+	if err != nil {
+		return nil, err
+	}
+	variables := config.Variables
+
+	return func(params *HookParameters) (bool, interface{}) {
+		timeout := defaultTransactionTimeout
+		if params.Timeout > 0 {
+			timeout = params.Timeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		result := runTransaction(ctx, params, steps, variables)
+		result.Location = params.Location
+		result.Tags = params.Tags
+
+		if params.Status != nil {
+			params.Status.Update(key, result)
+		}
+
+		if result.Throttled {
+			params.Inconclusive()
+		}
+		return result.FailedStep != "", result
+	}, nil
+}
+
+// runTransaction runs the steps, with a cookie jar and variables of
+// their own.
+func runTransaction(ctx context.Context, params *HookParameters, steps []transactionStep,
+	variables map[string]string) TransactionResult {
+	jar, _ := cookiejar.New(nil)
+
+	vars := make(map[string]string, len(variables))
+	for name, value := range variables {
+		vars[name] = value
+	}
+
+	start := time.Now()
+	result := TransactionResult{Steps: make([]TransactionStepResult, 0, len(steps))}
+	for i := range steps {
+		step := &steps[i]
+		if (result.FailedStep != "" || result.Throttled) && !step.Always {
+			result.Steps = append(result.Steps, TransactionStepResult{
+				Name: step.Name, Method: step.Method, URL: step.URL, Skipped: true,
+			})
+			continue
+		}
+
+		stepResult := step.run(ctx, params, jar, vars)
+		if stepResult.Throttled {
+			result.Throttled = true
+		}
+		if stepResult.failed() && result.FailedStep == "" {
+			result.FailedStep = step.Name
+		}
+		result.Steps = append(result.Steps, stepResult)
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+
+	return result
+}
+
+// run makes the request of the step, checks its contracts, and
+// extracts its variables into vars.
+func (s *transactionStep) run(ctx context.Context, params *HookParameters, jar http.CookieJar,
+	vars map[string]string) TransactionStepResult {
+	replacements := make([]string, 0, 2*len(vars))
+	for name, value := range vars {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	url := replacer.Replace(s.URL)
+	result := TransactionStepResult{Name: s.Name, Method: s.Method, URL: url}
+
+	target := url
+	if isUnixURL(url) {
+		var err error
+		if _, target, err = unixTarget(url); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	var body io.Reader
+	if s.Body != "" {
+		body = strings.NewReader(replacer.Replace(s.Body))
+	}
+	req, err := http.NewRequestWithContext(ctx, s.Method, target, body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for key, value := range params.Headers {
+		req.Header.Set(key, value)
+	}
+	for key, value := range s.Headers {
+		req.Header.Set(key, replacer.Replace(value))
+	}
+
+	release, ok := acquireHost(params.HostLimiter, url)
+	if !ok {
+		result.Throttled = true
+		return result
+	}
+	defer release()
+
+	client := &http.Client{Transport: params.Transports.client(url, "").Transport, Jar: jar}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	read, err := ioutil.ReadAll(io.LimitReader(resp.Body, defaultProbeBodyLimit))
+	latency := time.Since(start)
+	result.Status = resp.StatusCode
+	result.LatencyMs = latency.Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, contract := range s.Contracts {
+		if err := contract.check(resp.StatusCode, string(read), latency); err != nil {
+			result.Failures = append(result.Failures, err.Error())
+		}
+	}
+
+	for _, extractor := range s.extractors {
+		value, err := extractor.extract(resp, read)
+		if err != nil {
+			result.Failures = append(result.Failures, fmt.Sprintf("extract %s: %v", extractor.variable, err))
+			continue
+		}
+		vars[extractor.variable] = value
+	}
+
+	return result
+}
@@ -0,0 +1,280 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxIdlePerHost = 4
+	defaultIdleTimeout    = 90 * time.Second
+)
+
+// Address families probes can be forced over.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// UnixScheme is the scheme of urls probed over a unix domain socket.
+// The path of the socket is the host of the url, escaped, as in
+// http+unix://%2Frun%2Fapi.sock/health.
+const UnixScheme = "http+unix"
+
+const (
+	unixPrefix = UnixScheme + "://"
+
+	// unixHost is the host of the requests made over unix sockets.
+	unixHost = "localhost"
+)
+
+// familyNetworks are the networks dialed for each address family.
+var familyNetworks = map[string]string{
+	FamilyIPv4: "tcp4",
+	FamilyIPv6: "tcp6",
+}
+
+// familyClients are the clients forcing an address family, without a
+// transport pool.
+var familyClients = map[string]*http.Client{
+	"":         http.DefaultClient,
+	FamilyIPv4: {Transport: familyTransport(FamilyIPv4)},
+	FamilyIPv6: {Transport: familyTransport(FamilyIPv6)},
+}
+
+// familyTransport returns a transport dialing the address family only,
+// or any if it is empty.
+func familyTransport(family string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if network, ok := familyNetworks[family]; ok {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	return transport
+}
+
+// unixPool keeps the connections to unix sockets, without a transport
+// pool.
+var unixPool = TransportPoolNew(TransportLimits{})
+
+// isUnixURL returns whether rawURL is probed over a unix socket.
+func isUnixURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, unixPrefix)
+}
+
+// unixTarget splits a url over a unix socket into the path of the
+// socket, and the url requested over it.
+func unixTarget(rawURL string) (socket, target string, err error) {
+	rest := strings.TrimPrefix(rawURL, unixPrefix)
+	host, path := rest, "/"
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		host, path = rest[:i], rest[i:]
+	}
+	if path[0] != '/' {
+		path = "/" + path
+	}
+
+	socket, err = url.PathUnescape(host)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s: %v", ErrUnixURL, rawURL, err)
+	}
+	if socket == "" {
+		return "", "", fmt.Errorf("%w: %s: no socket", ErrUnixURL, rawURL)
+	}
+
+	return socket, "http://" + unixHost + path, nil
+}
+
+// unixTransport returns a transport dialing the unix socket, whatever
+// the address of its requests.
+func unixTransport(socket string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socket)
+	}
+
+	return transport
+}
+
+// TransportLimits are the connections kept open to the hosts probed,
+// so that repeated probes reuse them instead of connecting, and
+// handshaking TLS, every interval.
+type TransportLimits struct {
+	// MaxIdlePerHost is how many idle connections are kept to any one
+	// host. It defaults to 4.
+	MaxIdlePerHost int
+
+	// IdleTimeout is how long idle connections are kept. It defaults
+	// to 90 seconds, and should be longer than the intervals of the
+	// events for their connections to be reused.
+	IdleTimeout time.Duration
+}
+
+// ConnectionStats count the connections requests were made on.
+type ConnectionStats struct {
+	New    uint64 `json:"new"`
+	Reused uint64 `json:"reused"`
+}
+
+// record counts a connection, safe to call concurrently.
+func (s *ConnectionStats) record(reused bool) {
+	if reused {
+		atomic.AddUint64(&s.Reused, 1)
+	} else {
+		atomic.AddUint64(&s.New, 1)
+	}
+}
+
+func (s *ConnectionStats) load() ConnectionStats {
+	return ConnectionStats{New: atomic.LoadUint64(&s.New), Reused: atomic.LoadUint64(&s.Reused)}
+}
+
+// TransportPool keeps a transport per host, and address family, with
+// its idle connections.
+// It is shared by all the events of a planner.
+type TransportPool struct {
+	stats ConnectionStats
+
+	limits TransportLimits
+
+	mux     sync.Mutex
+	clients map[string]*http.Client
+}
+
+// TransportPoolNew creates a pool with the given limits.
+func TransportPoolNew(limits TransportLimits) *TransportPool {
+	if limits.MaxIdlePerHost <= 0 {
+		limits.MaxIdlePerHost = defaultMaxIdlePerHost
+	}
+	if limits.IdleTimeout <= 0 {
+		limits.IdleTimeout = defaultIdleTimeout
+	}
+
+	return &TransportPool{
+		limits:  limits,
+		clients: make(map[string]*http.Client),
+	}
+}
+
+// Client returns the client for the host of rawURL.
+func (s *TransportPool) Client(rawURL string) *http.Client {
+	return s.familyClient(rawURL, "")
+}
+
+// familyClient returns the client for the host of rawURL, dialing the
+// address family only, or any if it is empty. The clients of urls over
+// unix sockets dial their socket, whatever the family.
+func (s *TransportPool) familyClient(rawURL, family string) *http.Client {
+	key := rawURL
+	socket := ""
+	if isUnixURL(rawURL) {
+		socket, _, _ = unixTarget(rawURL)
+		key = unixPrefix + socket
+		family = ""
+	} else if parsed, err := url.Parse(rawURL); err == nil {
+		key = parsed.Scheme + "://" + parsed.Host
+	}
+	if family != "" {
+		key += " " + family
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	client, ok := s.clients[key]
+	if !ok {
+		transport := familyTransport(family)
+		if socket != "" {
+			transport = unixTransport(socket)
+		}
+		transport.MaxIdleConnsPerHost = s.limits.MaxIdlePerHost
+		transport.IdleConnTimeout = s.limits.IdleTimeout
+
+		client = &http.Client{Transport: &pooledTransport{Transport: transport, stats: &s.stats}}
+		s.clients[key] = client
+	}
+
+	return client
+}
+
+// Stats returns how many requests of the pool were made on new and
+// reused connections.
+func (s *TransportPool) Stats() ConnectionStats {
+	return s.stats.load()
+}
+
+// CloseIdle closes the idle connections of the pool.
+func (s *TransportPool) CloseIdle() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, client := range s.clients {
+		client.CloseIdleConnections()
+	}
+}
+
+// pooledTransport counts the connections of its requests in the
+// stats of its pool.
+type pooledTransport struct {
+	*http.Transport
+	stats *ConnectionStats
+}
+
+func (s *pooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return s.Transport.RoundTrip(withConnTrace(req, s.stats.record))
+}
+
+// client returns the client for the host of rawURL and the address
+// family, or a shared one if there is no pool.
+func (s *TransportPool) client(rawURL, family string) *http.Client {
+	if s == nil {
+		if isUnixURL(rawURL) {
+			return unixPool.familyClient(rawURL, "")
+		}
+		return familyClients[family]
+	}
+	return s.familyClient(rawURL, family)
+}
+
+// withConnTrace returns req, calling got with whether its connection
+// was reused.
+func withConnTrace(req *http.Request, got func(reused bool)) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			got(info.Reused)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
@@ -0,0 +1,216 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cynic
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSignatureHeader is the header signatures are sent in, and
+	// WebhookTimestampHeader the one of the time they were made at.
+	DefaultSignatureHeader = "X-Cynic-Signature"
+	WebhookTimestampHeader = "X-Cynic-Timestamp"
+
+	signaturePrefix = "sha256="
+
+	defaultSignatureMaxSkew = 5 * time.Minute
+
+	// maxSignedBodyBytes is how much of the body of a request is read
+	// to verify its signature.
+	maxSignedBodyBytes = 1 << 20
+)
+
+// WebhookSignature signs requests with an HMAC of their body, and
+// verifies the signatures of requests, so that integrations can tell
+// cynic traffic from anyone else's. The signature is
+// "sha256=<hex HMAC-SHA256>" of the timestamp, the method, the request
+// uri (the path and query) and the body, joined by dots, and the
+// timestamp the unix time it was made at. A signed request can't be
+// replayed to another endpoint.
+type WebhookSignature struct {
+	Secret string
+
+	// Header is the header of the signature. It defaults to
+	// X-Cynic-Signature.
+	Header string
+
+	// MaxSkew is how old, or how far in the future, the timestamp of
+	// a verified request may be, so that requests can't be replayed.
+	// It defaults to five minutes.
+	MaxSkew time.Duration
+}
+
+// Sign sets the signature headers of the request, of its body.
+func (s *WebhookSignature) Sign(req *http.Request, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(s.header(), signaturePrefix+s.mac(timestamp, req, body))
+}
+
+// Verify returns an error unless the request was signed with the
+// secret, within the max skew of now. The body of the request is read,
+// and replaced so that it can be read again.
+func (s *WebhookSignature) Verify(req *http.Request, now time.Time) error {
+	body := []byte{}
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(req.Body, maxSignedBodyBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(body) > maxSignedBodyBytes {
+			return fmt.Errorf("%w: body over %d bytes", ErrBadSignature, maxSignedBodyBytes)
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	timestamp := req.Header.Get(WebhookTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing or bad timestamp", ErrBadSignature)
+	}
+
+	maxSkew := s.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = defaultSignatureMaxSkew
+	}
+	skew := now.Sub(time.Unix(unix, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("%w: timestamp off by %s", ErrBadSignature, skew)
+	}
+
+	signature := strings.TrimPrefix(req.Header.Get(s.header()), signaturePrefix)
+	if !hmac.Equal([]byte(signature), []byte(s.mac(timestamp, req, body))) {
+		return fmt.Errorf("%w: signature does not match", ErrBadSignature)
+	}
+
+	return nil
+}
+
+func (s *WebhookSignature) header() string {
+	if s.Header == "" {
+		return DefaultSignatureHeader
+	}
+	return s.Header
+}
+
+func (s *WebhookSignature) mac(timestamp string, req *http.Request, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(s.Secret))
+	mac.Write([]byte(timestamp + "." + req.Method + "." + req.URL.RequestURI() + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookAlerter posts alert messages, as json, to a url. Its Alert
+// method can be given to AlerterNew as the alert hook.
+type WebhookAlerter struct {
+	url       string
+	client    *http.Client
+	signature *WebhookSignature
+}
+
+// WebhookPayload is what the webhook alerter posts.
+type WebhookPayload struct {
+	Alerts []AlertMessage `json:"alerts"`
+}
+
+// WebhookAlerterNew creates a webhook alerter that posts to the url.
+func WebhookAlerterNew(url string) *WebhookAlerter {
+	return &WebhookAlerter{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SetSignature signs the posts of the alerter.
+func (s *WebhookAlerter) SetSignature(signature *WebhookSignature) {
+	s.signature = signature
+}
+
+// Alert posts the messages. It returns the error of the post, so that
+// the alerts are retried.
+func (s *WebhookAlerter) Alert(messages []AlertMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	// a payload that can't be encoded never will be, so it is dropped
+	body, err := json.Marshal(WebhookPayload{Alerts: messages})
+	if err != nil {
+		log.Println("problem encoding webhook payload: ", err)
+		return nil
+	}
+
+	return s.post(body)
+}
+
+func (s *WebhookAlerter) post(body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.signature != nil {
+		s.signature.Sign(req, body, time.Now())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: webhook responded with: %s", ErrAlertSinkRejected, resp.Status)
+	}
+	return nil
+}
+
+// WithSignature has the status server reject the heartbeat pings and
+// pushed documents that were not signed with the signature.
+func (s *StatusCache) WithSignature(signature *WebhookSignature) {
+	s.signature = signature
+}
+
+// requireSignature wraps a handler of inbound requests, rejecting
+// those that are not signed, if the server verifies signatures.
+func (s *StatusCache) requireSignature(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if s.signature != nil {
+			if err := s.signature.Verify(req, time.Now()); err != nil {
+				writeJSONError(w, http.StatusUnauthorized, err)
+				return
+			}
+		}
+		handler(w, req)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func adminRequest(t *testing.T, method, url, token string, body io.Reader) *http.Response {
+	req, err := http.NewRequestWithContext(context.Background(), method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	return resp
+}
+
+func TestAdminEvents(t *testing.T) {
+	var runs int64
+	cynic.RegisterHook("testadmin-count", func(_ *cynic.HookParameters) (bool, interface{}) {
+		atomic.AddInt64(&runs, 1)
+		return false, nil
+	})
+
+	planner := cynic.PlannerNew()
+	server := cynic.StatusServerNew("", "0", "/testadminevents/")
+	server.WithPlanner(planner)
+	server.WithAdmin(&cynic.AdminConfig{Token: "secret"})
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	base := "http://127.0.0.1:" + strconv.Itoa(server.GetPort())
+
+	resp := adminRequest(t, http.MethodGet, base+"/admin/events", "", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusUnauthorized)
+
+	body := bytes.NewBufferString(`{"label": "counter", "interval": "5s", "hooks": ["testadmin-count"]}`)
+	resp = adminRequest(t, http.MethodPost, base+"/admin/events", "secret", body)
+	var created cynic.EventState
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusCreated)
+	assert(t, created.Label == "counter" && created.Secs == 5)
+
+	id := strconv.FormatUint(created.ID, 10)
+	resp = adminRequest(t, http.MethodPost, base+"/admin/events/run?id="+id, "secret", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusAccepted)
+	assert(t, eventually(func() bool { return atomic.LoadInt64(&runs) == 1 }))
+
+	resp = adminRequest(t, http.MethodPost, base+"/admin/events/run?wait=true&id="+id, "secret", nil)
+	var execution cynic.ExecutionResult
+	if err := json.NewDecoder(resp.Body).Decode(&execution); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusOK)
+	assert(t, len(execution.Hooks) == 1 && execution.Failures == 0)
+	assert(t, atomic.LoadInt64(&runs) == 2)
+
+	resp = adminRequest(t, http.MethodGet, base+"/admin/planner", "secret", nil)
+	var state cynic.PlannerState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, len(state.Events) == 1 && state.Events[0].ID == created.ID)
+
+	resp = adminRequest(t, http.MethodDelete, base+"/admin/events?id="+id, "secret", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusNoContent)
+	assert(t, len(planner.State().Events) == 0)
+
+	resp = adminRequest(t, http.MethodDelete, base+"/admin/events?id="+id, "secret", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusNotFound)
+}
+
+func TestAdminDisableEvent(t *testing.T) {
+	var runs int64
+	event := cynic.EventNew(1)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		atomic.AddInt64(&runs, 1)
+		return false, nil
+	})
+
+	planner := cynic.PlannerNew()
+	planner.Add(&event)
+
+	server := cynic.StatusServerNew("", "0", "/testadmindisableevent/")
+	server.WithPlanner(planner)
+	server.WithAdmin(&cynic.AdminConfig{Token: "secret"})
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	base := "http://127.0.0.1:" + strconv.Itoa(server.GetPort())
+	id := strconv.FormatUint(event.ID(), 10)
+
+	resp := adminRequest(t, http.MethodPost, base+"/admin/events/disable?id="+id, "secret", nil)
+	var state cynic.EventState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusOK && state.Disabled)
+
+	// the event stays planned, but does not run
+	for i := 0; i < 3; i++ {
+		planner.Tick()
+	}
+	assert(t, atomic.LoadInt64(&runs) == 0)
+	assert(t, planner.Len() == 1)
+
+	var status map[string]json.RawMessage
+	resp = adminRequest(t, http.MethodGet, base+"/testadmindisableevent/", "", nil)
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	_, ok := status["__disabled"]
+	assert(t, ok)
+
+	resp = adminRequest(t, http.MethodPost, base+"/admin/events/enable?id="+id, "secret", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusOK)
+
+	planner.Tick()
+	assert(t, atomic.LoadInt64(&runs) == 1)
+	assert(t, !event.IsDisabled())
+
+	resp = adminRequest(t, http.MethodPost, base+"/admin/events/disable?id=424242", "secret", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusNotFound)
+}
+
+func TestAdminDisabledRejects(t *testing.T) {
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	server := cynic.StatusServerNew("", "0", "/testadmindisabledrejects/")
+	server.WithAlerter(&alerter)
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	base := "http://127.0.0.1:" + strconv.Itoa(server.GetPort())
+	for _, request := range []struct{ method, path, body string }{
+		{http.MethodPost, "/admin/mutes", `{"group": "db", "duration": "1h"}`},
+		{http.MethodDelete, "/admin/mutes?id=1", ""},
+		{http.MethodPost, "/admin/ack", `{"event_id": 1}`},
+		{http.MethodPost, "/admin/alerts/test", ""},
+	} {
+		resp := adminRequest(t, request.method, base+request.path, "anything", bytes.NewBufferString(request.body))
+		resp.Body.Close()
+		assert(t, resp.StatusCode == http.StatusForbidden)
+	}
+	assert(t, len(alerter.Mutes()) == 0)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestDedupRenotify(t *testing.T) {
+	dedup := cynic.AlertDeduperNew(cynic.DedupConfig{
+		RenotifyAfter: time.Minute,
+	})
+
+	now := time.Now()
+	msg := cynic.AlertMessage{EventID: 1, Fingerprint: "down"}
+	other := cynic.AlertMessage{EventID: 1, Fingerprint: "slow"}
+
+	dedup.Observe(1, true, now)
+	assert(t, dedup.Allow(msg, now))
+	assert(t, !dedup.Allow(msg, now.Add(time.Second)))
+	assert(t, dedup.Allow(other, now.Add(time.Second)))
+	assert(t, dedup.Allow(msg, now.Add(time.Minute)))
+}
+
+func TestDedupRecoveryResets(t *testing.T) {
+	dedup := cynic.AlertDeduperNew(cynic.DedupConfig{
+		RenotifyAfter: time.Hour,
+	})
+
+	now := time.Now()
+	msg := cynic.AlertMessage{EventID: 1, Fingerprint: "down"}
+
+	dedup.Observe(1, true, now)
+	assert(t, dedup.Allow(msg, now))
+
+	dedup.Observe(1, false, now.Add(time.Second))
+	dedup.Observe(1, true, now.Add(2*time.Second))
+	assert(t, dedup.Allow(msg, now.Add(2*time.Second)))
+}
+
+func TestDedupFlapping(t *testing.T) {
+	dedup := cynic.AlertDeduperNew(cynic.DedupConfig{
+		FlapThreshold: 4,
+		FlapWindow:    time.Minute,
+	})
+
+	now := time.Now()
+	msg := cynic.AlertMessage{EventID: 1, Fingerprint: "down"}
+
+	for i := 0; i < 4; i++ {
+		dedup.Observe(1, i%2 == 0, now.Add(time.Duration(i)*time.Second))
+	}
+
+	assert(t, dedup.IsFlapping(1, now.Add(5*time.Second)))
+	assert(t, !dedup.Allow(msg, now.Add(5*time.Second)))
+
+	// changes fall out of the window eventually
+	assert(t, !dedup.IsFlapping(1, now.Add(2*time.Minute)))
+	assert(t, dedup.Allow(msg, now.Add(2*time.Minute)))
+}
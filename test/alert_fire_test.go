@@ -0,0 +1,138 @@
+/*
+Package cynic monitors you from the ceiling
+
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestAlerterTestFire(t *testing.T) {
+	var mux sync.Mutex
+	var delivered []cynic.AlertMessage
+	alerter := cynic.AlerterNew(60, func(alerts []cynic.AlertMessage) error {
+		mux.Lock()
+		defer mux.Unlock()
+		delivered = append(delivered, alerts...)
+		return nil
+	})
+	alerter.WithHistory(&cynic.AlertHistoryConfig{Capacity: 10})
+
+	// test alerts go out even if their label is muted
+	_, err := alerter.Mute(cynic.MuteRule{Label: "cynic test alert", Until: time.Now().Add(time.Hour)})
+	assert(t, err == nil)
+
+	alerter.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sent, err := alerter.TestFire(ctx, cynic.TestAlert{Severity: cynic.SeverityCritical})
+	assert(t, err == nil)
+	assert(t, sent.Label == "cynic test alert")
+	assert(t, sent.Tags[cynic.TestAlertTag] == "true")
+
+	alerter.Shutdown(ctx)
+
+	mux.Lock()
+	defer mux.Unlock()
+	assert(t, len(delivered) == 1)
+	assert(t, delivered[0].Fingerprint == sent.Fingerprint)
+	assert(t, delivered[0].Severity == cynic.SeverityCritical)
+	assert(t, delivered[0].Response == "this is a test, nothing is broken")
+
+	records := alerter.History(cynic.AlertHistoryFilter{})
+	assert(t, len(records) == 1 && records[0].Kind == cynic.AlertRecordAlert)
+	assert(t, len(alerter.ActiveAlerts()) == 0)
+}
+
+func TestAlerterTestFireNotRunning(t *testing.T) {
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := alerter.TestFire(ctx, cynic.TestAlert{})
+	assert(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestTestAlertEndpoint(t *testing.T) {
+	var mux sync.Mutex
+	var delivered []cynic.AlertMessage
+	alerter := cynic.AlerterNew(60, func(alerts []cynic.AlertMessage) error {
+		mux.Lock()
+		defer mux.Unlock()
+		delivered = append(delivered, alerts...)
+		return nil
+	})
+	alerter.Start()
+
+	server := cynic.StatusServerNew("", "0", "/testtestalertendpoint/")
+	server.WithAlerter(&alerter)
+	server.WithAdmin(&cynic.AdminConfig{Token: "secret"})
+
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	defer server.Stop()
+
+	base := "http://127.0.0.1:" + port + "/admin/alerts/test"
+
+	resp := adminRequest(t, http.MethodPost, base, "", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusUnauthorized)
+
+	resp = adminRequest(t, http.MethodGet, base, "secret", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusMethodNotAllowed)
+
+	body := bytes.NewBufferString(`{"label": "pager", "group": "db", "severity": "critical"}`)
+	resp = adminRequest(t, http.MethodPost, base, "secret", body)
+	defer resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusOK)
+
+	var sent cynic.AlertMessage
+	if err := json.NewDecoder(resp.Body).Decode(&sent); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, sent.Label == "pager" && sent.Group == "db")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	alerter.Shutdown(ctx)
+
+	mux.Lock()
+	defer mux.Unlock()
+	assert(t, len(delivered) == 1 && delivered[0].Label == "pager")
+}
+
+func TestControlTestAlertNoAlerter(t *testing.T) {
+	planner := cynic.PlannerNew()
+	control := cynic.ControlServiceNew(planner, nil, nil)
+
+	_, err := control.TestAlert(context.Background(), cynic.TestAlert{})
+	assert(t, errors.Is(err, cynic.ErrNoAlerter))
+}
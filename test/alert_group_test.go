@@ -0,0 +1,138 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestAlertRootCause(t *testing.T) {
+	api := "https://api.example.com/"
+	cases := []struct {
+		result interface{}
+		cause  string
+	}{
+		{cynic.ProbeResult{URL: "http://db.internal:5432/", Error: "connect: connection refused"}, "db.internal refused"},
+		{&cynic.ProbeResult{URL: api, Error: "context deadline exceeded"}, "api.example.com timeout"},
+		{cynic.ProbeResult{URL: "https://nope.example.com/", Error: "lookup nope: no such host"}, "nope.example.com dns"},
+		{cynic.ProbeResult{URL: api, Error: "x509: certificate has expired"}, "api.example.com tls"},
+		{cynic.ProbeResult{URL: api, Error: "something else"}, "api.example.com error"},
+		{cynic.ProbeResult{URL: api, Status: 503}, "api.example.com 5xx"},
+		{cynic.ProbeResult{URL: api, Status: 200, Failures: []string{"nope"}}, "api.example.com contract"},
+		{cynic.ProbeResult{URL: api, Status: 200}, ""},
+		{"down", ""},
+		{(*cynic.ProbeResult)(nil), ""},
+	}
+
+	for _, c := range cases {
+		if cause := cynic.AlertRootCause(c.result); cause != c.cause {
+			t.Errorf("expected root cause %q of %v, got %q", c.cause, c.result, cause)
+		}
+	}
+}
+
+func TestAlertGrouping(t *testing.T) {
+	var delivered []cynic.AlertMessage
+	grouping := cynic.AlertGrouping(cynic.AlertGroupConfig{}, func(alerts []cynic.AlertMessage) error {
+		delivered = alerts
+		return nil
+	})
+
+	grouping([]cynic.AlertMessage{
+		{Label: "alone", RootCause: "web refused"},
+		{Label: "orders", Group: "db", RootCause: "db refused", Severity: cynic.SeverityWarning},
+		{Label: "plain"},
+		{Label: "users", Group: "db", RootCause: "db refused", Severity: cynic.SeverityCritical},
+		{Label: "carts", Group: "db", RootCause: "db refused"},
+	})
+
+	assert(t, len(delivered) == 3)
+	assert(t, delivered[0].Label == "alone")
+	assert(t, delivered[2].Label == "plain")
+
+	group := delivered[1]
+	assert(t, group.Label == "db refused" && group.RootCause == "db refused")
+	assert(t, group.Group == "db")
+	assert(t, group.Severity == cynic.SeverityCritical)
+
+	response := group.Response.(cynic.AlertGroup)
+	assert(t, response.Count == 3)
+	assert(t, len(response.Labels) == 3 && response.Labels[1] == "users")
+
+	// below the minimum, alerts are left alone
+	grouping = cynic.AlertGrouping(cynic.AlertGroupConfig{MinAlerts: 4}, func(alerts []cynic.AlertMessage) error {
+		delivered = alerts
+		return nil
+	})
+	grouping([]cynic.AlertMessage{{RootCause: "db refused"}, {RootCause: "db refused"}})
+	assert(t, len(delivered) == 2)
+}
+
+// TestAlertGroupingConfig probes a dead host from several events, and
+// expects a single alert.
+func TestAlertGroupingConfig(t *testing.T) {
+	var mux sync.Mutex
+	var delivered []cynic.AlertMessage
+	cynic.RegisterAlertHook("testgroup-hook", func(alerts []cynic.AlertMessage) error {
+		mux.Lock()
+		defer mux.Unlock()
+		delivered = append(delivered, alerts...)
+		return nil
+	})
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	config, err := cynic.ParseConfig([]byte(`{
+		"alerts": {"hook": "testgroup-hook", "interval": "1h", "grouping": {"min_alerts": 2}},
+		"events": [
+			{"label": "home", "url": "`+dead.URL+`/", "interval": "1s"},
+			{"label": "health", "url": "`+dead.URL+`/health", "interval": "1s"},
+			{"label": "api", "url": "`+dead.URL+`/api", "interval": "1s"}
+		]}`), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := config.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	planner := cynic.PlannerNew()
+	planner.SetAlerter(session.Alerter)
+	for i := range session.Events {
+		planner.Add(&session.Events[i])
+	}
+
+	session.Alerter.Start()
+	planner.Advance(2 * time.Second)
+	session.Alerter.Shutdown(context.Background())
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	assert(t, len(delivered) == 1)
+	assert(t, delivered[0].RootCause == "127.0.0.1 refused")
+	assert(t, delivered[0].Response.(cynic.AlertGroup).Count == 3)
+}
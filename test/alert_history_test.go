@@ -0,0 +1,154 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestAlertHistoryResolution(t *testing.T) {
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	alerter.Start()
+	defer alerter.Stop()
+
+	failing := true
+	event := cynic.EventNew(1)
+	event.Label = "flaky"
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		return failing, "down"
+	})
+
+	planner := cynic.PlannerNew()
+	planner.SetAlerter(&alerter)
+	planner.Add(&event)
+
+	planner.Tick()
+	planner.Tick()
+
+	assert(t, eventually(func() bool {
+		return len(alerter.History(cynic.AlertHistoryFilter{})) == 1
+	}))
+	assert(t, len(alerter.ActiveAlerts()) == 1)
+
+	failing = false
+	planner.Tick()
+
+	assert(t, len(alerter.ActiveAlerts()) == 0)
+
+	records := alerter.History(cynic.AlertHistoryFilter{EventID: event.ID()})
+	assert(t, len(records) == 2)
+	assert(t, records[0].Kind == cynic.AlertRecordAlert && records[0].Label == "flaky")
+	assert(t, records[1].Kind == cynic.AlertRecordResolved)
+}
+
+func TestAlertHistoryFilterAndPersist(t *testing.T) {
+	config := cynic.AlertHistoryConfig{Path: path.Join(t.TempDir(), "history.jsonl")}
+	history := cynic.AlertHistoryNew(config)
+
+	now := time.Now()
+	history.Record(cynic.AlertRecord{Time: now, EventID: 1, Severity: cynic.SeverityCritical})
+	history.Record(cynic.AlertRecord{Time: now.Add(time.Minute), EventID: 2, Severity: cynic.SeverityInfo})
+	history.Record(cynic.AlertRecord{Time: now.Add(time.Hour), EventID: 1, Severity: cynic.SeverityInfo})
+
+	reloaded := cynic.AlertHistoryNew(config)
+	assert(t, len(reloaded.Query(cynic.AlertHistoryFilter{})) == 3)
+
+	info := cynic.SeverityInfo
+	assert(t, len(reloaded.Query(cynic.AlertHistoryFilter{Severity: &info})) == 2)
+	assert(t, len(reloaded.Query(cynic.AlertHistoryFilter{EventID: 1})) == 2)
+	assert(t, len(reloaded.Query(cynic.AlertHistoryFilter{
+		Since: now.Add(time.Second),
+		Until: now.Add(2 * time.Minute),
+	})) == 1)
+}
+
+func TestAlertsEndpoint(t *testing.T) {
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	alerter.Start()
+	defer alerter.Stop()
+
+	alerter.Ch <- cynic.AlertMessage{EventID: 7, Severity: cynic.SeverityCritical}
+	alerter.Ch <- cynic.AlertMessage{EventID: 8, Severity: cynic.SeverityInfo}
+	assert(t, eventually(func() bool {
+		return len(alerter.History(cynic.AlertHistoryFilter{})) == 2
+	}))
+
+	server := cynic.StatusServerNew("", "0", "/testalertsendpoint/")
+	server.WithAlerter(&alerter)
+
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	defer server.Stop()
+
+	req, err := makeBackgroundRequest("http://127.0.0.1:" + port + "/alerts?severity=critical")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	defer resp.Body.Close()
+
+	var records []cynic.AlertRecord
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, len(records) == 1 && records[0].EventID == 7)
+}
+
+func TestAlertAcknowledge(t *testing.T) {
+	delivered := make(chan []cynic.AlertMessage, 4)
+	alerter := cynic.AlerterNew(1, func(alerts []cynic.AlertMessage) error {
+		delivered <- alerts
+		return nil
+	})
+	alerter.Start()
+	defer alerter.Stop()
+
+	assert(t, alerter.Acknowledge(3) != nil)
+
+	alerter.Ch <- cynic.AlertMessage{EventID: 3}
+	assert(t, eventually(func() bool { return len(alerter.ActiveAlerts()) == 1 }))
+	assert(t, alerter.Acknowledge(3) == nil)
+
+	// re-notifications of acknowledged alerts are held back
+	alerter.Ch <- cynic.AlertMessage{EventID: 3}
+
+	select {
+	case alerts := <-delivered:
+		assert(t, len(alerts) == 1)
+	case <-time.After(3 * time.Second):
+		t.Fatal("first alert was never delivered")
+	}
+
+	active := alerter.ActiveAlerts()
+	assert(t, len(active) == 1 && active[0].Acknowledged)
+
+	records := alerter.History(cynic.AlertHistoryFilter{EventID: 3})
+	assert(t, len(records) == 2)
+	assert(t, records[1].Kind == cynic.AlertRecordAcknowledged)
+}
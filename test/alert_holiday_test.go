@@ -0,0 +1,113 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func writeCalendar(t *testing.T, name, data string) string {
+	calendarPath := path.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(calendarPath, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return calendarPath
+}
+
+func TestHolidayCalendarRoute(t *testing.T) {
+	var oncall []cynic.AlertMessage
+	cynic.RegisterAlertHook("testholiday-oncall", func(alerts []cynic.AlertMessage) error {
+		oncall = append(oncall, alerts...)
+		return nil
+	})
+
+	calendar, err := cynic.LoadHolidayCalendar(writeCalendar(t, "holidays.json", `{
+		"timezone": "UTC",
+		"holidays": [
+			{"name": "christmas", "date": "2021-12-25"},
+			{"name": "new year", "date": "2021-12-31", "until": "2022-01-01", "route": "testholiday-oncall"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var passed []cynic.AlertMessage
+	route := calendar.Route(func(alerts []cynic.AlertMessage) error {
+		passed = append(passed, alerts...)
+		return nil
+	})
+
+	route([]cynic.AlertMessage{
+		{Label: "workday", Now: "2021-12-24T12:00:00Z"},
+		{Label: "suppressed", Now: "2021-12-25T12:00:00Z"},
+		{Label: "critical", Now: "2021-12-25T12:00:00Z", Severity: cynic.SeverityCritical},
+		{Label: "rerouted", Now: "2022-01-01T23:00:00Z"},
+	})
+
+	assert(t, len(passed) == 2 && passed[0].Label == "workday" && passed[1].Label == "critical")
+	assert(t, len(oncall) == 1 && oncall[0].Label == "rerouted")
+
+	holiday, ok := calendar.On(time.Date(2021, time.December, 31, 8, 0, 0, 0, time.UTC))
+	assert(t, ok && holiday.Name == "new year")
+}
+
+func TestHolidayCalendarICal(t *testing.T) {
+	calendar, err := cynic.LoadHolidayCalendar(writeCalendar(t, "holidays.ics", strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20211224",
+		"DTEND;VALUE=DATE:20211227",
+		"SUMMARY:Winter",
+		"  break",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	holiday, ok := calendar.On(time.Date(2021, time.December, 26, 12, 0, 0, 0, time.Local))
+	assert(t, ok && holiday.Name == "Winter break" && holiday.Until == "2021-12-26")
+
+	// the end of an event is exclusive
+	_, ok = calendar.On(time.Date(2021, time.December, 27, 12, 0, 0, 0, time.Local))
+	assert(t, !ok)
+}
+
+func TestBadHolidayCalendar(t *testing.T) {
+	for _, data := range []string{
+		`{"holidays": [{"name": "nope", "date": "25/12/2021"}]}`,
+		`{"holidays": [{"name": "backwards", "date": "2021-12-25", "until": "2021-12-24"}]}`,
+		`{"timezone": "Nowhere/Nope"}`,
+	} {
+		_, err := cynic.LoadHolidayCalendar(writeCalendar(t, "holidays.json", data))
+		if !errors.Is(err, cynic.ErrBadHolidayCalendar) {
+			t.Error("expected", data, "to be bad, got:", err)
+		}
+	}
+
+	_, err := cynic.LoadHolidayCalendar(writeCalendar(t, "holidays.json",
+		`{"holidays": [{"name": "x", "date": "2021-12-25", "route": "testholiday-nope"}]}`))
+	assert(t, errors.Is(err, cynic.ErrConfigUnknownHook))
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+// eventually polls the condition for a little while, for state that
+// is updated by background goroutines.
+func eventually(cond func() bool) bool {
+	return eventuallyWithin(2*time.Second, cond)
+}
+
+// eventuallyWithin is eventually, with a deadline for slow state,
+// such as what the planner does on its one second ticks.
+func eventuallyWithin(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestAlertQueueOverflow(t *testing.T) {
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	alerter.WithQueue(&cynic.AlertQueueConfig{
+		Capacity: 2,
+		Overflow: cynic.DropOldest,
+	})
+	alerter.Start()
+	defer alerter.Stop()
+
+	for i := 0; i < 3; i++ {
+		alerter.Ch <- cynic.AlertMessage{EventID: uint64(i)}
+	}
+
+	assert(t, eventually(func() bool { return alerter.Dropped() == 1 }))
+	assert(t, alerter.Pending() == 2)
+}
+
+func TestAlertQueueSpill(t *testing.T) {
+	spillPath := path.Join(t.TempDir(), "alerts.json")
+	config := &cynic.AlertQueueConfig{SpillPath: spillPath}
+
+	first := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	first.WithQueue(config)
+	first.Start()
+
+	first.Ch <- cynic.AlertMessage{EventID: 1, Label: "one"}
+	first.Ch <- cynic.AlertMessage{EventID: 2, Label: "two"}
+	assert(t, eventually(func() bool { return first.Pending() == 2 }))
+	first.Stop()
+
+	var delivered []cynic.AlertMessage
+	done := make(chan struct{})
+
+	second := cynic.AlerterNew(1, func(alerts []cynic.AlertMessage) error {
+		delivered = alerts
+		close(done)
+		return nil
+	})
+	second.WithQueue(config)
+	assert(t, second.Pending() == 2)
+
+	second.Start()
+	defer second.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("spilled alerts were never delivered")
+	}
+
+	assert(t, len(delivered) == 2)
+	assert(t, delivered[0].Label == "one" && delivered[1].Label == "two")
+	assert(t, eventually(func() bool { return second.Pending() == 0 }))
+}
+
+func TestAlerterDigest(t *testing.T) {
+	batches := make(chan []cynic.AlertMessage, 4)
+
+	alerter := cynic.AlerterNew(60, func(alerts []cynic.AlertMessage) error {
+		batches <- alerts
+		return nil
+	})
+	alerter.WithDigest(&cynic.DigestConfig{
+		Interval:          100 * time.Millisecond,
+		ImmediateSeverity: cynic.SeverityCritical,
+	})
+	alerter.Start()
+	defer alerter.Stop()
+
+	alerter.Ch <- cynic.AlertMessage{Label: "page me", Severity: cynic.SeverityCritical}
+
+	select {
+	case batch := <-batches:
+		assert(t, len(batch) == 1 && batch[0].Label == "page me")
+	case <-time.After(time.Second):
+		t.Fatal("critical alert was not sent right away")
+	}
+
+	for i := 0; i < 3; i++ {
+		alerter.Ch <- cynic.AlertMessage{Severity: cynic.SeverityWarning}
+	}
+
+	select {
+	case batch := <-batches:
+		assert(t, len(batch) == 1)
+		digest, ok := batch[0].Response.(cynic.AlertDigest)
+		assert(t, ok && digest.Count == 3)
+		assert(t, batch[0].Severity == cynic.SeverityWarning)
+	case <-time.After(time.Second):
+		t.Fatal("digest was not sent")
+	}
+}
+
+func TestAlerterShutdownDelivers(t *testing.T) {
+	var delivered []cynic.AlertMessage
+
+	alerter := cynic.AlerterNew(60, func(alerts []cynic.AlertMessage) error {
+		delivered = append(delivered, alerts...)
+		return nil
+	})
+	alerter.WithDigest(&cynic.DigestConfig{
+		Interval:          time.Hour,
+		ImmediateSeverity: cynic.SeverityCritical,
+	})
+	alerter.Start()
+
+	alerter.Ch <- cynic.AlertMessage{EventID: 1, Label: "one"}
+	alerter.Ch <- cynic.AlertMessage{EventID: 2, Label: "two"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	alerter.Shutdown(ctx)
+
+	assert(t, len(delivered) == 1)
+	assert(t, alerter.Pending() == 0)
+}
+
+func TestAlerterRetriesFailedDeliveries(t *testing.T) {
+	clock := cynic.ManualClockNew(clockStart)
+
+	var attempts int32
+	alerter := cynic.AlerterNew(1, func(alerts []cynic.AlertMessage) error {
+		// the sink is down for the first two deliveries
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			return errors.New("sink is down")
+		}
+		return nil
+	})
+	alerter.WithClock(clock)
+	alerter.Start()
+	defer alerter.Stop()
+
+	alerter.Ch <- cynic.AlertMessage{EventID: 1, Label: "kept"}
+	assert(t, eventually(func() bool { return alerter.Pending() == 1 }))
+
+	// advance waits for the delivery of a tick to be done with, so that
+	// the backoff starts before the next tick
+	advance := func(want int32) {
+		clock.Advance(time.Second)
+		assert(t, eventually(func() bool { return atomic.LoadInt32(&attempts) == want }))
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	advance(1)
+	assert(t, alerter.Pending() == 1)
+
+	// one second of backoff after the first failure
+	advance(2)
+	assert(t, alerter.Pending() == 1)
+
+	// two after the second, so the next tick is too early
+	advance(2)
+	assert(t, alerter.Pending() == 1)
+
+	advance(3)
+	assert(t, eventually(func() bool { return alerter.Pending() == 0 }))
+}
+
+func TestAlerterShutdownKeepsFailedDeliveries(t *testing.T) {
+	spillPath := path.Join(t.TempDir(), "alerts.json")
+
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error {
+		return errors.New("sink is down")
+	})
+	alerter.WithQueue(&cynic.AlertQueueConfig{SpillPath: spillPath})
+	alerter.Start()
+
+	alerter.Ch <- cynic.AlertMessage{EventID: 1, Label: "kept"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	alerter.Shutdown(ctx)
+
+	assert(t, alerter.Pending() == 1)
+
+	// and are spilled, for the next run to deliver
+	next := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	next.WithQueue(&cynic.AlertQueueConfig{SpillPath: spillPath})
+	assert(t, next.Pending() == 1)
+}
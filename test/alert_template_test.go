@@ -0,0 +1,47 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestAlertTemplateFuncs(t *testing.T) {
+	tmpl, err := cynic.AlertTemplateNew("funcs", `{{json .Response}} {{truncate 3 .Label}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := tmpl.Render(&cynic.AlertMessage{
+		Response: map[string]int{"code": 500},
+		Label:    "database",
+	})
+
+	assert(t, err == nil)
+	assert(t, text == `{"code":500} dat...`)
+
+	// characters are not cut in half
+	text, err = tmpl.Render(&cynic.AlertMessage{Label: "ééééé"})
+	assert(t, err == nil)
+	assert(t, text == `null ééé...`)
+}
+
+func TestAlertTemplateBadSyntax(t *testing.T) {
+	_, err := cynic.AlertTemplateNew("bad", "{{.Label")
+	assert(t, err != nil)
+}
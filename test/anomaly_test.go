@@ -0,0 +1,120 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestAnomalyDetector(t *testing.T) {
+	detector := cynic.AnomalyDetectorNew(cynic.AnomalyConfig{Warmup: 10})
+
+	// a latency steadily around 100ms
+	for i := 0; i < 30; i++ {
+		result := detector.Observe(float64(95 + i%10))
+		assert(t, !result.Anomalous)
+		assert(t, result.Learning == (i < 10))
+	}
+
+	result := detector.Observe(104)
+	assert(t, !result.Anomalous && result.Score < 3)
+	assert(t, result.Mean > 95 && result.Mean < 105)
+
+	result = detector.Observe(400)
+	assert(t, result.Anomalous && result.Score > 3)
+	assert(t, result.Metric == cynic.AnomalyLatency)
+}
+
+func TestAnomalyDetectorMinDeviation(t *testing.T) {
+	detector := cynic.AnomalyDetectorNew(cynic.AnomalyConfig{Warmup: 5, MinDeviation: 10})
+	for i := 0; i < 10; i++ {
+		detector.Observe(50)
+	}
+
+	// nothing deviates from a flat metric, but a small change is
+	// still not anomalous
+	assert(t, !detector.Observe(55).Anomalous)
+	assert(t, detector.Observe(80).Anomalous)
+}
+
+func TestAnomalyHook(t *testing.T) {
+	status := cynic.StatusServerNew("localhost", "0", "/status")
+	hook := cynic.AnomalyHookNew("queue", cynic.AnomalyConfig{Metric: "$.depth", Warmup: 5})
+	params := &cynic.HookParameters{Status: &status}
+
+	// nothing probed yet
+	failed, result := hook(params)
+	assert(t, !failed && result == nil)
+
+	for i := 0; i < 10; i++ {
+		status.Update("queue", cynic.ProbeResult{Values: map[string]float64{"$.depth": float64(10 + i%3)}})
+		failed, _ = hook(params)
+		assert(t, !failed)
+	}
+
+	// probes that got no response are not learned
+	status.Update("queue", cynic.ProbeResult{Error: "timeout"})
+	failed, result = hook(params)
+	assert(t, !failed && result == nil)
+
+	status.Update("queue", cynic.ProbeResult{Values: map[string]float64{"$.depth": 90}})
+	failed, result = hook(params)
+	assert(t, failed && result.(cynic.AnomalyResult).Value == 90)
+}
+
+func TestConfigAnomaly(t *testing.T) {
+	depth := 10
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"depth": %d}`, depth)
+	}))
+	defer remote.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0"},
+		"events": [{"label": "queue", "url": "%s", "interval": "1s",
+			"thresholds": [{"path": "$.depth"}],
+			"anomaly": {"metric": "$.depth", "warmup": 5, "min_deviation": 5}}]}`, remote.URL)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	for i := 0; i < 10; i++ {
+		result := session.Events[0].Execute()
+		assert(t, !result.Failed())
+	}
+
+	depth = 100
+	result := session.Events[0].Execute()
+	assert(t, result.Failed() && result.Failures == 1)
+
+	for _, invalid := range []string{
+		`{"events": [{"interval": "1s", "hooks": ["x"], "anomaly": {}}]}`,
+		`{"events": [{"interval": "1s", "url": "http://x", "anomaly": {"metric": "$.depth"}}]}`,
+		`{"events": [{"interval": "1s", "url": "http://x", "anomaly": {"alpha": 2}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(invalid), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+type busCapture struct {
+	mux      sync.Mutex
+	topics   []string
+	payloads [][]byte
+}
+
+func (s *busCapture) publish(_ context.Context, topic string, payload []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.topics = append(s.topics, topic)
+	s.payloads = append(s.payloads, payload)
+	return nil
+}
+
+func TestBusPublisherJSON(t *testing.T) {
+	capture := &busCapture{}
+	publisher := cynic.BusPublisherNew(cynic.BusTransportFunc(capture.publish), cynic.BusConfig{})
+
+	publisher.Record(cynic.EventResult{EventID: 1, Label: "api", Failed: true})
+	publisher.Alert([]cynic.AlertMessage{{EventID: 1, Label: "api"}, {EventID: 2, Label: "db"}})
+	publisher.Close(context.Background())
+
+	assert(t, len(capture.topics) == 3)
+	assert(t, capture.topics[0] == "cynic.results")
+	assert(t, capture.topics[1] == "cynic.alerts" && capture.topics[2] == "cynic.alerts")
+
+	var result map[string]interface{}
+	assert(t, json.Unmarshal(capture.payloads[0], &result) == nil)
+	assert(t, result["label"] == "api" && result["failed"] == true)
+
+	var alert cynic.AlertMessage
+	assert(t, json.Unmarshal(capture.payloads[2], &alert) == nil)
+	assert(t, alert.Label == "db")
+
+	// messages published after closing are dropped
+	publisher.Record(cynic.EventResult{EventID: 3})
+	assert(t, publisher.Dropped() == 1)
+}
+
+func TestBusPublisherProtobuf(t *testing.T) {
+	capture := &busCapture{}
+	publisher := cynic.BusPublisherNew(cynic.BusTransportFunc(capture.publish), cynic.BusConfig{
+		Format:       cynic.BusFormatProtobuf,
+		ResultsTopic: "results",
+	})
+
+	publisher.Record(cynic.EventResult{
+		EventID: 1,
+		Label:   "a",
+		Failed:  true,
+		At:      time.Unix(0, 1),
+		Hooks:   []cynic.HookResult{{Failed: true, Result: 2}},
+	})
+	publisher.Close(context.Background())
+
+	expected := []byte{
+		0x08, 0x01, // event_id
+		0x12, 0x01, 'a', // label
+		0x22, 0x04, 'i', 'n', 'f', 'o', // severity
+		0x28, 0x01, // failed
+		0x38, 0x01, // at_unix_nano
+		0x42, 0x05, 0x08, 0x01, 0x12, 0x01, '2', // hooks
+	}
+
+	assert(t, len(capture.payloads) == 1)
+	assert(t, capture.topics[0] == "results")
+	assert(t, bytes.Equal(capture.payloads[0], expected))
+}
+
+func TestNATSTransport(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer listener.Close()
+
+	type received struct {
+		connect string
+		subject string
+		payload string
+		pong    bool
+	}
+	done := make(chan received, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var got received
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+
+		line, _ := reader.ReadString('\n')
+		got.connect = strings.TrimSpace(line)
+		reader.ReadString('\n') // PING
+		conn.Write([]byte("PONG\r\nPING\r\n"))
+
+		for i := 0; i < 2; i++ {
+			line, _ = reader.ReadString('\n')
+			line = strings.TrimSpace(line)
+
+			if line == "PONG" {
+				got.pong = true
+				continue
+			}
+
+			fields := strings.Fields(line)
+			got.subject = fields[1]
+			payload, _ := reader.ReadString('\n')
+			got.payload = strings.TrimSpace(payload)
+		}
+		done <- got
+	}()
+
+	transport := cynic.NATSTransportNew("nats://secret@" + listener.Addr().String())
+	defer transport.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	assert(t, transport.Publish(ctx, "cynic.results", []byte("hello")) == nil)
+
+	select {
+	case got := <-done:
+		assert(t, strings.HasPrefix(got.connect, "CONNECT {"))
+		assert(t, strings.Contains(got.connect, `"auth_token":"secret"`))
+		assert(t, got.subject == "cynic.results")
+		assert(t, got.payload == "hello")
+		assert(t, got.pong)
+	case <-time.After(3 * time.Second):
+		t.Fatal("nats server received nothing")
+	}
+}
+
+func TestNATSTransportRejected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("INFO {}\r\n"))
+		reader.ReadString('\n')
+		conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+	}()
+
+	transport := cynic.NATSTransportNew(listener.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = transport.Publish(ctx, "cynic.results", []byte("hello"))
+	assert(t, errors.Is(err, cynic.ErrNATSRejected))
+}
+
+func TestConfigBus(t *testing.T) {
+	data := `{"events": [{"label": "a", "interval": "1s", "hooks": ["testbus-hook"]}],
+	          "bus": {"transport": "testbus", "addr": "localhost:9092", "format": "protobuf"}}`
+
+	cynic.RegisterHook("testbus-hook", func(_ *cynic.HookParameters) (bool, interface{}) {
+		return false, nil
+	})
+
+	missingData := strings.Replace(data, `"transport": "testbus"`, `"transport": "testbus-missing"`, 1)
+	missing, err := cynic.ParseConfig([]byte(missingData), ".json")
+	assert(t, err == nil)
+
+	_, err = missing.Session()
+	assert(t, errors.Is(err, cynic.ErrBusUnknownTransport))
+
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	capture := &busCapture{}
+	cynic.RegisterBusTransport("testbus", func(addr string) (cynic.BusTransport, error) {
+		assert(t, addr == "localhost:9092")
+		return cynic.BusTransportFunc(capture.publish), nil
+	})
+
+	session, err := config.Session()
+	assert(t, err == nil)
+	assert(t, len(session.Sinks) == 1)
+
+	_, err = cynic.ParseConfig([]byte(`{"bus": {"transport": "nats", "addr": "x:1", "format": "xml"}}`), ".json")
+	assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+}
+
+func TestAlertFanout(t *testing.T) {
+	var order []string
+	hook := cynic.AlertFanout(
+		func(alerts []cynic.AlertMessage) error {
+			order = append(order, "first")
+			return errors.New("first failed")
+		},
+		func(alerts []cynic.AlertMessage) error {
+			order = append(order, "second")
+			return errors.New("second failed")
+		},
+	)
+
+	// every hook has the alerts, and the first error is returned
+	err := hook([]cynic.AlertMessage{{Label: "a"}})
+	assert(t, len(order) == 2 && order[0] == "first" && order[1] == "second")
+	assert(t, err != nil && err.Error() == "first failed")
+}
@@ -0,0 +1,90 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := cynic.CircuitBreakerNew(2, 50*time.Millisecond)
+
+	assert(t, breaker.Allow())
+	assert(t, breaker.Record(true) == cynic.CircuitClosed)
+	assert(t, breaker.Allow())
+	assert(t, breaker.Record(true) == cynic.CircuitOpen)
+	assert(t, !breaker.Allow())
+
+	// one probe goes through after the cool down
+	time.Sleep(60 * time.Millisecond)
+	assert(t, breaker.Allow())
+	assert(t, breaker.State() == cynic.CircuitHalfOpen)
+	assert(t, !breaker.Allow())
+
+	// and opens the circuit again right away if it fails
+	assert(t, breaker.Record(true) == cynic.CircuitOpen)
+	assert(t, !breaker.Allow())
+
+	time.Sleep(60 * time.Millisecond)
+	assert(t, breaker.Allow())
+	assert(t, breaker.Record(false) == cynic.CircuitClosed)
+	assert(t, breaker.Allow())
+}
+
+func TestConfigCircuitBreaker(t *testing.T) {
+	var down int32 = 1
+	var requests int32
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			panic(http.ErrAbortHandler)
+		}
+	}))
+	defer remote.Close()
+
+	config, err := cynic.ParseConfig([]byte(`{"status": {"port": "0"},
+		"circuit_breaker": {"failures": 2, "cooldown": "1h"},
+		"events": [{"label": "api", "url": "`+remote.URL+`", "interval": "1s", "repeat": true}]}`), ".json")
+	assert(t, err == nil)
+	assert(t, config.Events[0].CircuitBreaker != nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	for i := 0; i < 4; i++ {
+		session.Events[0].Execute()
+	}
+
+	// the last two runs did not probe
+	assert(t, atomic.LoadInt32(&requests) == 2)
+
+	value, err := session.StatusCache.Get("api")
+	assert(t, err == nil)
+	assert(t, value.(cynic.ProbeResult).CircuitOpen)
+}
+
+func TestConfigCircuitBreakerInvalid(t *testing.T) {
+	_, err := cynic.ParseConfig([]byte(`{"circuit_breaker": {"failures": 0, "cooldown": "1m"}}`), ".json")
+	assert(t, err != nil)
+}
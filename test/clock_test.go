@@ -0,0 +1,170 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+var clockStart = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestManualClockTicker(t *testing.T) {
+	clock := cynic.ManualClockNew(clockStart)
+	ticker := clock.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	ticks := make(chan time.Time, 10)
+	go func() {
+		for tick := range ticker.C() {
+			ticks <- tick
+		}
+	}()
+
+	clock.Advance(35 * time.Second)
+	assert(t, clock.Now().Equal(clockStart.Add(35*time.Second)))
+
+	for i := 1; i <= 3; i++ {
+		tick := <-ticks
+		assert(t, tick.Equal(clockStart.Add(time.Duration(i)*10*time.Second)))
+	}
+}
+
+func TestManualClockStoppedTicker(t *testing.T) {
+	clock := cynic.ManualClockNew(clockStart)
+	ticker := clock.NewTicker(time.Second)
+	ticker.Stop()
+
+	// nobody reads a stopped ticker, advancing must not block on it
+	clock.Advance(time.Minute)
+	assert(t, clock.Now().Equal(clockStart.Add(time.Minute)))
+}
+
+func TestPlannerAdvance(t *testing.T) {
+	count := 0
+	event := cynic.EventNew(10)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		count++
+		return false, nil
+	})
+
+	planner := cynic.PlannerNew()
+	planner.Add(&event)
+	planner.Advance(61 * time.Second)
+
+	assert(t, count == 6)
+}
+
+func TestAlerterMuteExpiresWithClock(t *testing.T) {
+	clock := cynic.ManualClockNew(clockStart)
+
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	alerter.WithClock(clock)
+
+	_, err := alerter.Mute(cynic.MuteRule{Label: "db", Until: clockStart.Add(time.Hour)})
+	assert(t, err == nil)
+	assert(t, len(alerter.Mutes()) == 1)
+
+	clock.Advance(2 * time.Hour)
+	assert(t, len(alerter.Mutes()) == 0)
+}
+
+func TestAlerterDeliversWithClock(t *testing.T) {
+	clock := cynic.ManualClockNew(clockStart)
+	delivered := make(chan []cynic.AlertMessage, 1)
+
+	alerter := cynic.AlerterNew(60, func(alerts []cynic.AlertMessage) error {
+		delivered <- alerts
+		return nil
+	})
+	alerter.WithClock(clock)
+	alerter.Start()
+	defer alerter.Stop()
+
+	alerter.Ch <- cynic.AlertMessage{EventID: 1, Label: "late"}
+	clock.Advance(time.Minute)
+
+	select {
+	case alerts := <-delivered:
+		assert(t, len(alerts) == 1 && alerts[0].Label == "late")
+	case <-time.After(3 * time.Second):
+		t.Fatal("alert was not delivered after advancing the clock")
+	}
+}
+
+func TestSnapshotsWithClock(t *testing.T) {
+	dir := t.TempDir()
+	clock := cynic.ManualClockNew(clockStart)
+
+	server := cynic.StatusServerNew("", "0", "/testsnapshotswithclock/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Minute,
+		DumpEvery: time.Hour,
+		Path:      dir,
+		Clock:     clock,
+	})
+	server.Update("hello", "kitty")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+
+	clock.Advance(3 * time.Minute)
+	server.Stop()
+
+	name := clockStart.Add(3*time.Minute).Format(time.RFC3339) + ".1.cynic"
+	store, err := cynic.ReadSnapshotStoreFile(path.Join(dir, name))
+	assert(t, err == nil)
+
+	// one per minute, and one on stop
+	assert(t, len(store.Snapshots) == 4)
+	assert(t, store.Snapshots[0].Timestamp == clockStart.Add(time.Minute).Unix())
+	assert(t, store.Snapshots[3].Timestamp == clockStart.Add(3*time.Minute).Unix())
+}
+
+func TestRunWithClock(t *testing.T) {
+	clock := cynic.ManualClockNew(clockStart)
+
+	var ran int32
+	event := cynic.EventNew(5)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		atomic.AddInt32(&ran, 1)
+		return false, nil
+	})
+
+	runner, err := cynic.StartWithStopper(cynic.Session{
+		Events: []cynic.Event{event},
+		Clock:  clock,
+	})
+	assert(t, err == nil)
+
+	assert(t, eventually(func() bool {
+		clock.Advance(time.Second)
+		return atomic.LoadInt32(&ran) > 0
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert(t, runner.Stop(ctx) == nil)
+}
@@ -0,0 +1,184 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func clusterEvents(n int) []*cynic.Event {
+	events := make([]*cynic.Event, n)
+	for i := range events {
+		event := cynic.EventNew(1)
+		event.Label = fmt.Sprintf("check-%d", i)
+		event.Group = "cluster"
+		events[i] = &event
+	}
+	return events
+}
+
+func TestShardRingBalance(t *testing.T) {
+	ring := cynic.ShardRingNew([]string{"a", "b", "c"}, 0)
+
+	counts := map[string]int{}
+	for _, event := range clusterEvents(3000) {
+		counts[ring.Owner(event)]++
+	}
+
+	assert(t, len(counts) == 3)
+	for _, count := range counts {
+		assert(t, count > 700 && count < 1300)
+	}
+}
+
+func TestShardRingOnlyMovesLeavingMember(t *testing.T) {
+	before := cynic.ShardRingNew([]string{"a", "b", "c"}, 0)
+	after := cynic.ShardRingNew([]string{"a", "c"}, 0)
+
+	for _, event := range clusterEvents(1000) {
+		owner := before.Owner(event)
+		if owner != "b" {
+			assert(t, after.Owner(event) == owner)
+		}
+	}
+
+	// owners only depend on group and label, not on ids
+	first := clusterEvents(10)
+	second := clusterEvents(10)
+	for i := range first {
+		assert(t, before.Owner(first[i]) == before.Owner(second[i]))
+	}
+
+	assert(t, cynic.ShardRingNew(nil, 0).Owner(first[0]) == "")
+}
+
+func TestClusterRunsEachEventOnce(t *testing.T) {
+	const nEvents = 12
+	members := cynic.StaticMembership{"a", "b"}
+
+	var mux sync.Mutex
+	ranBy := map[string]map[string]bool{}
+
+	start := func(self string) *cynic.Runner {
+		events := make([]cynic.Event, 0, nEvents)
+		for _, event := range clusterEvents(nEvents) {
+			label := event.Label
+			event.Repeat(true)
+			event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+				mux.Lock()
+				defer mux.Unlock()
+				if ranBy[label] == nil {
+					ranBy[label] = map[string]bool{}
+				}
+				ranBy[label][self] = true
+				return false, nil
+			})
+			events = append(events, *event)
+		}
+
+		runner, err := cynic.StartWithStopper(cynic.Session{
+			Events:  events,
+			Cluster: &cynic.ClusterConfig{Membership: members, Self: self},
+		})
+		assert(t, err == nil)
+		return runner
+	}
+
+	a := start("a")
+	defer a.Stop(context.Background())
+	b := start("b")
+	defer b.Stop(context.Background())
+
+	assert(t, eventuallyWithin(5*time.Second, func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(ranBy) == nEvents
+	}))
+
+	// another round or so, to catch any event run by both
+	time.Sleep(1500 * time.Millisecond)
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	owners := map[string]int{}
+	for _, instances := range ranBy {
+		assert(t, len(instances) == 1)
+		for instance := range instances {
+			owners[instance]++
+		}
+	}
+	assert(t, owners["a"] > 0 && owners["b"] > 0)
+}
+
+func TestClusterRebalancesOnMembership(t *testing.T) {
+	dir := t.TempDir()
+	other := cynic.DirectoryMembershipNew(dir, "other", time.Minute)
+	_, err := other.Members(context.Background())
+	assert(t, err == nil)
+
+	rebalances := make(chan []string, 4)
+	runner, err := cynic.StartWithStopper(cynic.Session{
+		Cluster: &cynic.ClusterConfig{
+			Membership: cynic.DirectoryMembershipNew(dir, "self", time.Minute),
+			Self:       "self",
+			Refresh:    50 * time.Millisecond,
+			OnRebalance: func(members []string) {
+				rebalances <- members
+			},
+		},
+	})
+	assert(t, err == nil)
+
+	members := <-rebalances
+	assert(t, len(members) == 2 && members[0] == "other" && members[1] == "self")
+
+	assert(t, other.Leave(context.Background()) == nil)
+	select {
+	case members = <-rebalances:
+		assert(t, len(members) == 1 && members[0] == "self")
+	case <-time.After(2 * time.Second):
+		t.Fatal("no rebalance after a member left")
+	}
+
+	// the heartbeat of self is removed once it stops
+	assert(t, runner.Stop(context.Background()) == nil)
+	_, err = os.Stat(filepath.Join(dir, "self"))
+	assert(t, os.IsNotExist(err))
+}
+
+func TestDirectoryMembershipStale(t *testing.T) {
+	dir := t.TempDir()
+	membership := cynic.DirectoryMembershipNew(dir, "host:1", time.Minute)
+
+	assert(t, os.WriteFile(filepath.Join(dir, "gone"), nil, 0600) == nil)
+	old := time.Now().Add(-time.Hour)
+	assert(t, os.Chtimes(filepath.Join(dir, "gone"), old, old) == nil)
+
+	members, err := membership.Members(context.Background())
+	assert(t, err == nil)
+	assert(t, len(members) == 1 && members[0] == "host:1")
+}
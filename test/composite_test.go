@@ -0,0 +1,111 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestCompositeExpressionErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"a and",
+		"(a or b",
+		"2 of a",
+		"150% of (a, b)",
+		"a b",
+	} {
+		_, err := cynic.CompositeHookNew(expression)
+		assert(t, errors.Is(err, cynic.ErrCompositeExpression))
+	}
+
+	_, err := cynic.CompositeHookNew("any of (a, b) and not group/c")
+	assert(t, err == nil)
+}
+
+func TestCompositeEvent(t *testing.T) {
+	planner := cynic.PlannerNew()
+
+	failing := map[string]bool{}
+	for _, label := range []string{"db-1", "db-2", "db-3"} {
+		label := label
+		event := cynic.EventNew(1)
+		event.Label = label
+		event.Repeat(true)
+		event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+			return failing[label], nil
+		})
+		planner.Add(&event)
+	}
+
+	hook, err := cynic.CompositeHookNew("2 of (db-1, db-2, db-3, db-4)")
+	assert(t, err == nil)
+
+	var (
+		alerted bool
+		result  cynic.CompositeResult
+	)
+	composite := cynic.EventNew(1)
+	composite.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		var value interface{}
+		alerted, value = hook(params)
+		result = value.(cynic.CompositeResult)
+		return alerted, value
+	})
+	planner.Add(&composite)
+
+	// nothing ran yet, so every event is unknown
+	_, err = planner.RunNow(composite.ID())
+	assert(t, err == nil)
+	assert(t, !alerted && len(result.Unknown) == 4)
+
+	failing["db-1"] = true
+	planner.Advance(2 * time.Second)
+	_, err = planner.RunNow(composite.ID())
+	assert(t, err == nil)
+	assert(t, !alerted && len(result.Failing) == 1)
+	assert(t, len(result.Unknown) == 1 && result.Unknown[0] == "db-4")
+
+	failing["db-3"] = true
+	planner.Advance(2 * time.Second)
+	_, err = planner.RunNow(composite.ID())
+	assert(t, err == nil)
+	assert(t, alerted && len(result.Failing) == 2)
+
+	failing["db-1"], failing["db-3"] = false, false
+	planner.Advance(2 * time.Second)
+	_, err = planner.RunNow(composite.ID())
+	assert(t, err == nil)
+	assert(t, !alerted && len(result.Failing) == 0)
+}
+
+func TestConfigComposite(t *testing.T) {
+	_, err := cynic.ParseConfig([]byte(`{"events": [{"composite": "a and"}]}`), ".json")
+	assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+
+	_, err = cynic.ParseConfig([]byte(`{"events": [{"url": "http://x", "composite": "a"}]}`), ".json")
+	assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+
+	data := `{"events": [{"label": "both", "interval": "1s", "composite": "all of (a, b)"}]}`
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil && config.Events[0].Composite == "all of (a, b)")
+}
@@ -0,0 +1,417 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+const testConfig = `{
+  "status": {"port": "0", "root": "/testconfig/"},
+  "events": [
+    {"label": "api", "group": "backend", "url": "http://localhost:9001/health", "interval": "2s",
+     "repeat": true, "severity": "critical",
+     "contracts": [{"status": 200, "contains": "ok", "max_latency": "1s"}]},
+    {"label": "named", "interval": 5, "hooks": ["testconfig-hook"]}
+  ],
+  "alerts": {"interval": "10s", "hook": "testconfig-alert"},
+  "snapshots": {"interval": "1m", "dump_every": "5m", "path": "/tmp", "compression": "gzip"}
+}`
+
+func TestConfigSession(t *testing.T) {
+	cynic.RegisterHook("testconfig-hook", func(_ *cynic.HookParameters) (bool, interface{}) {
+		return false, nil
+	})
+	cynic.RegisterAlertHook("testconfig-alert", func(_ []cynic.AlertMessage) error { return nil })
+
+	config, err := cynic.ParseConfig([]byte(testConfig), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := config.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, len(session.Events) == 2)
+	assert(t, session.Events[0].Label == "api")
+	assert(t, session.Events[0].Group == "backend")
+	assert(t, session.Events[0].GetSecs() == 2)
+	assert(t, session.Events[0].IsRepeating())
+	assert(t, session.Events[0].GetSeverity() == cynic.SeverityCritical)
+	assert(t, session.Events[0].NumHooks() == 1)
+	assert(t, session.Events[1].GetSecs() == 5)
+	assert(t, session.StatusCache != nil)
+	assert(t, session.Alerter != nil)
+	assert(t, session.SnapshotConfig.DumpEvery == 5*time.Minute)
+	assert(t, session.SnapshotConfig.Compression == cynic.SnapshotCompressionGzip)
+}
+
+func TestConfigUnknownHook(t *testing.T) {
+	config, err := cynic.ParseConfig([]byte(`{"events": [{"interval": "1s", "hooks": ["nope"]}]}`), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := config.Session(); !errors.Is(err, cynic.ErrConfigUnknownHook) {
+		t.Fatal("expected an unknown hook error, got:", err)
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	configs := []string{
+		`{"events": [{"url": "http://localhost", "interval": "10ms"}]}`,
+		`{"events": [{"interval": "1s"}]}`,
+		`{"snapshots": {"interval": "1s", "dump_every": "1s"}}`,
+		`{"stagger": "sideways"}`,
+		`{"stagger": "hashed", "distribute": "10s"}`,
+		`{"metrics": {"prefix": "cynic"}}`,
+		`{"metrics": {"statsd": "localhost:8125", "graphite": "localhost:2003"}}`,
+		`{"influx": {"token": "secret"}}`,
+		`{"alerts": {"syslog": {"facility": "kern"}}}`,
+		`{"alerts": {"snmp": {"addr": "localhost", "version": "1"}}}`,
+		`{"leader": {"ttl": "10s"}}`,
+		`{"status": {"port": "0"}, "snapshots": {"interval": "1s", "dump_every": "1s", "compression": "zstd"}}`,
+		`{"status": {"port": "0"}, "snapshots": {"interval": "1s", "dump_every": "1s", "compression": "lz4"}}`,
+	}
+
+	for _, data := range configs {
+		if _, err := cynic.ParseConfig([]byte(data), ".json"); !errors.Is(err, cynic.ErrConfigInvalid) {
+			t.Fatal("expected an invalid config error for", data, "got:", err)
+		}
+	}
+
+	if _, err := cynic.ParseConfig([]byte(`{}`), ".ini"); !errors.Is(err, cynic.ErrConfigUnknownFormat) {
+		t.Fatal("expected an unknown format error, got:", err)
+	}
+}
+
+const configJSON = `{
+  "status": {"host": "", "port": "9999"},
+  "location": "eu-west",
+  "events": [
+    {
+      "label": "example.com",
+      "url": "https://example.com/?q=a#b",
+      "interval": "30s",
+      "repeat": true,
+      "immediate": true,
+      "severity": "critical",
+      "tags": {"team": "web", "tier": "1"},
+      "contracts": [{"status": 200, "contains": "it's \"ok\"", "max_latency": "2s"}]
+    },
+    {"label": "hello", "interval": 10, "offset": 2.5, "repeat": true, "hooks": ["hello", "world"]}
+  ],
+  "snapshots": {"interval": "1m", "dump_every": "5m", "path": "/tmp"}
+}`
+
+const configYAML = `---
+# the same config as configJSON
+status:
+  host: ""
+  port: "9999"
+location: eu-west
+events:
+- label: example.com
+  url: https://example.com/?q=a#b
+  interval: 30s
+  repeat: true
+  immediate: True
+  severity: critical # a comment
+  tags:
+    team: web
+    tier: '1'
+  contracts:
+    - status: 200
+      contains: 'it''s "ok"'
+      max_latency: 2s
+- {label: hello, interval: 10, offset: 2.5, repeat: true, hooks: [hello, "world"]}
+
+snapshots: {interval: 1m, dump_every: 5m, path: /tmp}
+`
+
+const configTOML = `# the same config as configJSON
+location = "eu-west"
+snapshots = { interval = "1m", dump_every = "5m", path = "/tmp" }
+
+[status]
+host = ""
+port = "9999"
+
+[[events]]
+label = "example.com"
+url = "https://example.com/?q=a#b"
+interval = "30s"
+repeat = true
+immediate = true
+severity = "critical" # a comment
+tags.team = "web"
+tags.tier = '1'
+
+[[events.contracts]]
+status = 200
+contains = "it's \"ok\""
+max_latency = "2s"
+
+[[events]]
+label = "hello"
+interval = 10
+offset = 2.5
+repeat = true
+hooks = [
+  "hello",
+  'world', # trailing commas are fine
+]
+`
+
+func TestConfigYAMLAndTOML(t *testing.T) {
+	expected, err := cynic.ParseConfig([]byte(configJSON), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ext, data := range map[string]string{".yaml": configYAML, ".yml": configYAML, ".toml": configTOML} {
+		config, err := cynic.ParseConfig([]byte(data), ext)
+		if err != nil {
+			t.Fatal(ext, err)
+		}
+
+		if !reflect.DeepEqual(config, expected) {
+			t.Fatalf("%s: expected\n%+v\ngot\n%+v", ext, expected, config)
+		}
+	}
+}
+
+func TestConfigYAMLAndTOMLSyntax(t *testing.T) {
+	configs := map[string]string{
+		"events:\n\t- url: x":            ".yaml",
+		"status:\n  port: 1\n   host: x": ".yaml",
+		"a: 1\na: 2":                     ".yaml",
+		"a: &anchor 1":                   ".yaml",
+		"a: [1, 2":                       ".yaml",
+		"a: 1\n---\nb: 2":                ".yaml",
+		"a = ":                           ".toml",
+		"a = 1\na = 2":                   ".toml",
+		"a = 1979-05-27":                 ".toml",
+		"a = \"unterminated":             ".toml",
+		"a = \"\"\"multi-line\"\"\"":     ".toml",
+		"[a]\nb = 1\n[[a]]":              ".toml",
+		"a = [1, 2":                      ".toml",
+		"a = 1 b = 2":                    ".toml",
+		"a = 012":                        ".toml",
+	}
+
+	for data, ext := range configs {
+		if _, err := cynic.ParseConfig([]byte(data), ext); !errors.Is(err, cynic.ErrConfigSyntax) {
+			t.Fatalf("expected a syntax error for %q, got: %v", data, err)
+		}
+	}
+}
+
+func TestConfigRegisteredDecoder(t *testing.T) {
+	// decodes like yaml libraries do, with interface{} keys
+	cynic.RegisterConfigDecoder(".fake", func(_ []byte, v interface{}) error {
+		*(v.(*interface{})) = map[interface{}]interface{}{
+			"events": []interface{}{
+				map[interface{}]interface{}{"url": "http://localhost", "interval": "3s"},
+			},
+		}
+		return nil
+	})
+
+	config, err := cynic.ParseConfig(nil, ".FAKE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, len(config.Events) == 1)
+	assert(t, time.Duration(config.Events[0].Interval) == 3*time.Second)
+}
+
+func TestConfigProbeHook(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer remote.Close()
+
+	for _, tc := range []struct {
+		path  string
+		alert bool
+	}{{"/good", false}, {"/bad", true}} {
+		data := []byte(`{"status": {"port": "0"}, "events": [{"label": "api", "url": "` +
+			remote.URL + tc.path + `", "interval": "1s", "contracts": [{"status": 200, "contains": "ok"}]}]}`)
+
+		config, err := cynic.ParseConfig(data, ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		session, err := config.Session()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		session.Events[0].Execute()
+
+		value, err := session.StatusCache.Get("api")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := value.(cynic.ProbeResult)
+		assert(t, (len(result.Failures) > 0) == tc.alert)
+		assert(t, result.URL == remote.URL+tc.path)
+	}
+}
+
+func TestConfigRetry(t *testing.T) {
+	var requests int32
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			panic(http.ErrAbortHandler)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer remote.Close()
+
+	for _, tc := range []struct {
+		on5xx    bool
+		attempts int
+		status   int
+	}{{false, 2, http.StatusBadGateway}, {true, 3, http.StatusOK}} {
+		atomic.StoreInt32(&requests, 0)
+
+		// the client retries aborted requests on reused connections
+		// by itself, which would hide the first attempt
+		remote.CloseClientConnections()
+
+		data := fmt.Sprintf(`{"status": {"port": "0"},
+			"retry": {"attempts": 5, "backoff": "10ms", "on_5xx": %t},
+			"events": [{"label": "api", "url": "%s", "interval": "1s"}]}`, tc.on5xx, remote.URL)
+		config, err := cynic.ParseConfig([]byte(data), ".json")
+		assert(t, err == nil)
+
+		session, err := config.Session()
+		assert(t, err == nil)
+		session.Events[0].Execute()
+
+		value, err := session.StatusCache.Get("api")
+		assert(t, err == nil)
+
+		result := value.(cynic.ProbeResult)
+		assert(t, result.Attempts == tc.attempts)
+		assert(t, result.Status == tc.status)
+		assert(t, result.Error == "")
+	}
+}
+
+func TestConfigRetryInBackground(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil)
+	down := listener.Addr().String()
+	listener.Close()
+
+	var requests int32
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer remote.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [
+		{"label": "down", "url": "http://%s/", "interval": "1m", "immediate": true,
+		 "retry": {"attempts": 10, "backoff": "10s"}},
+		{"label": "up", "url": %q, "interval": "1s", "repeat": true}]}`, down, remote.URL)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	planner := cynic.PlannerNew()
+	planner.Add(&session.Events[0])
+	planner.Add(&session.Events[1])
+
+	// the retrying event waits in the background, while the other
+	// events keep firing
+	start := time.Now()
+	planner.Advance(4 * time.Second)
+	assert(t, time.Since(start) < time.Second)
+	assert(t, atomic.LoadInt32(&requests) >= 2)
+	for _, state := range planner.State().Events {
+		assert(t, state.Running == 0 || state.Label == "down")
+		assert(t, state.Running == 1 || state.Label != "down")
+	}
+
+	// stopping the planner cuts the wait short
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pending, err := planner.Stop(ctx)
+	assert(t, pending == 0 && err == nil)
+
+	value, err := session.StatusCache.Get("down")
+	assert(t, err == nil)
+	result := value.(cynic.ProbeResult)
+	assert(t, result.Attempts == 1 && result.Error != "")
+
+	// the retries wait half the interval at most, in all
+	data = fmt.Sprintf(`{"status": {"port": "0"}, "events": [
+		{"label": "down", "url": "http://%s/", "interval": "1s", "retry": {"attempts": 10, "backoff": "10s"}}]}`, down)
+	config, err = cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err = config.Session()
+	assert(t, err == nil)
+
+	start = time.Now()
+	session.Events[0].Execute()
+	assert(t, time.Since(start) < time.Second)
+
+	value, err = session.StatusCache.Get("down")
+	assert(t, err == nil)
+	result = value.(cynic.ProbeResult)
+	assert(t, result.Attempts == 2)
+}
+
+func TestConfigSeed(t *testing.T) {
+	config, err := cynic.ParseConfig([]byte(`{
+		"seed": 7,
+		"events": [{"url": "http://127.0.0.1:1/", "interval": "5s"}]
+	}`), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := config.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, session.Seed != nil && *session.Seed == 7)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestConfigWatcherReload(t *testing.T) {
+	var countA, countB, countC int64
+	counter := func(count *int64) cynic.HookSignature {
+		return func(_ *cynic.HookParameters) (bool, interface{}) {
+			atomic.AddInt64(count, 1)
+			return false, nil
+		}
+	}
+	cynic.RegisterHook("testwatch-a", counter(&countA))
+	cynic.RegisterHook("testwatch-b", counter(&countB))
+	cynic.RegisterHook("testwatch-c", counter(&countC))
+
+	configPath := path.Join(t.TempDir(), "cynic.json")
+	writeConfig := func(labels ...string) {
+		events := make([]string, 0, len(labels))
+		for _, label := range labels {
+			events = append(events, `{"label": "`+label+`", "interval": 1, "repeat": true, "hooks": ["testwatch-`+label+`"]}`)
+		}
+
+		data := `{"events": [` + strings.Join(events, ",") + `]}`
+		if err := ioutil.WriteFile(configPath, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("a", "b")
+	watcher, session, err := cynic.ConfigWatcherNew(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(watcher.Events(), ",") == "a,b")
+
+	for i := 0; i < 2; i++ {
+		session.Planner.Tick()
+	}
+	assert(t, atomic.LoadInt64(&countA) == 1)
+	assert(t, atomic.LoadInt64(&countB) == 1)
+
+	writeConfig("a", "c")
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(watcher.Events(), ",") == "a,c")
+
+	for i := 0; i < 2; i++ {
+		session.Planner.Tick()
+	}
+	assert(t, atomic.LoadInt64(&countA) == 3)
+	assert(t, atomic.LoadInt64(&countB) == 1)
+	assert(t, atomic.LoadInt64(&countC) == 1)
+}
+
+func TestConfigWatcherBadReload(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "cynic.json")
+	data := `{"events": [{"label": "a", "url": "http://localhost", "interval": 10}]}`
+	if err := ioutil.WriteFile(configPath, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher, _, err := cynic.ConfigWatcherNew(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data = `{"events": [{"label": "b", "interval": 10, "hooks": ["testwatch-nope"]}]}`
+	if err := ioutil.WriteFile(configPath, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := watcher.Reload(); err == nil {
+		t.Fatal("expected the reload to fail")
+	}
+	assert(t, strings.Join(watcher.Events(), ",") == "a")
+}
+
+func TestConfigWatcherKeepsDisabled(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "cynic.json")
+	writeConfig := func(data string) {
+		if err := ioutil.WriteFile(configPath, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	disabled := func(planner *cynic.Planner) bool {
+		events := planner.State().Events
+		return len(events) == 1 && events[0].Disabled
+	}
+
+	writeConfig(`{"events": [{"label": "a", "url": "http://localhost", "interval": 10}]}`)
+	watcher, session, err := cynic.ConfigWatcherNew(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	control := cynic.ControlServiceNew(session.Planner, nil, nil)
+	if _, err := control.DisableEvent(session.Planner.State().Events[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event is replaced, and stays disabled
+	writeConfig(`{"events": [{"label": "a", "url": "http://localhost", "interval": 20}]}`)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, disabled(session.Planner))
+
+	// unless the config changes whether it is
+	writeConfig(`{"events": [{"label": "a", "url": "http://localhost", "interval": 20, "disabled": true}]}`)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, disabled(session.Planner))
+
+	writeConfig(`{"events": [{"label": "a", "url": "http://localhost", "interval": 20}]}`)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, !disabled(session.Planner))
+}
+
+func TestConfigWatcherReloadSchedulesLikeStart(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(name, schedule string, labels ...string) string {
+		events := make([]string, 0, len(labels))
+		for _, label := range labels {
+			events = append(events, `{"label": "`+label+`", "url": "http://localhost", "interval": 60, "repeat": true}`)
+		}
+
+		configPath := path.Join(dir, name)
+		data := `{` + schedule + `, "events": [` + strings.Join(events, ",") + `]}`
+		if err := ioutil.WriteFile(configPath, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return configPath
+	}
+	stateOf := func(planner *cynic.Planner, label string) cynic.EventState {
+		for _, state := range planner.State().Events {
+			if state.Label == label {
+				return state
+			}
+		}
+		t.Fatal("no event", label)
+		return cynic.EventState{}
+	}
+
+	for _, schedule := range []string{`"stagger": "round_robin"`, `"stagger": "hashed"`, `"distribute": 60`} {
+		reloadPath := writeConfig("reload.json", schedule, "a")
+		watcher, reloaded, err := cynic.ConfigWatcherNew(reloadPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeConfig("reload.json", schedule, "a", "b")
+		if err := watcher.Reload(); err != nil {
+			t.Fatal(err)
+		}
+
+		_, started, err := cynic.ConfigWatcherNew(writeConfig("start.json", schedule, "a", "b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the added event is offset as if it was there from the start
+		want, got := stateOf(started.Planner, "b"), stateOf(reloaded.Planner, "b")
+		assert(t, want.NextTick > 0)
+		assert(t, got.NextTick == want.NextTick && got.Secs == want.Secs)
+	}
+}
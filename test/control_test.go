@@ -0,0 +1,84 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestControlServiceEvents(t *testing.T) {
+	planner := cynic.PlannerNew()
+	control := cynic.ControlServiceNew(planner, nil, nil)
+
+	state, err := control.AddEvent(cynic.EventConfig{
+		Label:    "probe",
+		URL:      "http://127.0.0.1:1",
+		Interval: cynic.ConfigDuration(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, state.Secs == 60)
+	assert(t, len(control.ListEvents()) == 1)
+
+	if err := control.DeleteEvent(state.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := control.DeleteEvent(state.ID); !errors.Is(err, cynic.ErrEventNotFound) {
+		t.Fatal("expected event not found, got:", err)
+	}
+
+	if _, err := control.GetStatus(""); !errors.Is(err, cynic.ErrNoStatusCache) {
+		t.Fatal("expected no status cache, got:", err)
+	}
+	if _, err := control.MuteAlert(cynic.MuteRule{Label: "probe"}); !errors.Is(err, cynic.ErrNoAlerter) {
+		t.Fatal("expected no alerter, got:", err)
+	}
+}
+
+func TestControlServiceStreamStatus(t *testing.T) {
+	server := cynic.StatusServerNew("", "0", "/testcontrolstreamstatus/")
+	control := cynic.ControlServiceNew(cynic.PlannerNew(), &server, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := control.StreamStatus(ctx, "", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := <-updates
+	assert(t, string(first.JSON) == "{}")
+
+	server.Update("hello", "kitty")
+	second := <-updates
+	assert(t, strings.Contains(string(second.JSON), "kitty"))
+	assert(t, second.Generation > first.Generation)
+
+	// the stream closes once the context is done
+	cancel()
+	for range updates {
+		continue
+	}
+}
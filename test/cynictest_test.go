@@ -0,0 +1,81 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+	"github.com/psyomn/cynic/lib/cynictest"
+)
+
+func TestCynictestHelpers(t *testing.T) {
+	url := cynictest.JSONEndpoint(t, http.StatusServiceUnavailable, map[string]string{"state": "down"})
+	status := cynictest.StatusServer(t, "/testcynictesthelpers/")
+
+	var recorder cynictest.AlertRecorder
+	alerter := cynic.AlerterNew(1, recorder.Func())
+
+	var counter cynictest.FireCounter
+
+	event := cynic.EventNew(1)
+	event.Label = "api"
+	event.Repeat(true)
+	event.SetDataRepo(status)
+	event.AddHook(counter.Hook())
+	event.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+		if err != nil {
+			return true, err.Error()
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return true, err.Error()
+		}
+		defer resp.Body.Close()
+
+		var body map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return true, err.Error()
+		}
+
+		params.Status.Update("api", body["state"])
+		return resp.StatusCode != http.StatusOK, body
+	})
+
+	planner := cynic.PlannerNew()
+	planner.SetAlerter(&alerter)
+	alerter.Start()
+	defer alerter.Stop()
+
+	planner.Add(&event)
+	planner.Advance(3 * time.Second)
+
+	counter.AssertFiredExactly(t, 2, time.Second)
+
+	value, err := status.Get("api")
+	assert(t, err == nil && value == "down")
+
+	alerts := recorder.WaitFor(t, 2, 3*time.Second)
+	assert(t, alerts[0].Label == "api")
+}
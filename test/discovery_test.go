@@ -0,0 +1,215 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+type fakeSource struct {
+	mux     sync.Mutex
+	targets []cynic.DiscoveryTarget
+	err     error
+}
+
+func (s *fakeSource) set(targets []cynic.DiscoveryTarget, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.targets, s.err = targets, err
+}
+
+func (s *fakeSource) Targets(_ context.Context) ([]cynic.DiscoveryTarget, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.targets, s.err
+}
+
+func plannedLabels(planner *cynic.Planner) string {
+	var labels []string
+	for _, event := range planner.State().Events {
+		labels = append(labels, event.Label)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+func TestDiscoveryFollowsSource(t *testing.T) {
+	source := &fakeSource{}
+	source.set([]cynic.DiscoveryTarget{{Host: "a", Port: 80}, {Host: "b", Port: 80}}, nil)
+
+	planner := cynic.PlannerNew()
+	runner, err := cynic.StartWithStopper(cynic.Session{
+		Planner: planner,
+		Discovery: []cynic.DiscoveryConfig{{
+			Source:  source,
+			Refresh: 20 * time.Millisecond,
+			Template: func(target cynic.DiscoveryTarget) cynic.Event {
+				event := cynic.EventNew(60)
+				event.Label = target.Host
+				return event
+			},
+		}},
+	})
+	assert(t, err == nil)
+	defer runner.Stop(context.Background())
+
+	assert(t, eventually(func() bool { return plannedLabels(planner) == "a,b" }))
+
+	source.set([]cynic.DiscoveryTarget{{Host: "b", Port: 80}, {Host: "c", Port: 80}}, nil)
+	assert(t, eventually(func() bool { return plannedLabels(planner) == "b,c" }))
+
+	// the events are kept while the source fails
+	source.set(nil, errors.New("unreachable"))
+	time.Sleep(100 * time.Millisecond)
+	assert(t, plannedLabels(planner) == "b,c")
+}
+
+func TestConsulSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/api" || r.Header.Get("X-Consul-Token") != "tok" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		assert(t, r.URL.Query().Get("passing") == "true" && r.URL.Query().Get("tag") == "v2")
+
+		_, _ = w.Write([]byte(`[
+		  {"Node": {"Node": "n1", "Address": "10.0.0.1"},
+		   "Service": {"ID": "api-1", "Address": "", "Port": 8080, "Meta": {"zone": "east"}}},
+		  {"Node": {"Node": "n2", "Address": "10.0.0.2"},
+		   "Service": {"ID": "api-2", "Address": "10.1.0.2", "Port": 8081}}
+		]`))
+	}))
+	defer server.Close()
+
+	source := &cynic.ConsulSource{Addr: server.URL, Service: "api", Tag: "v2", Token: "tok", Passing: true}
+	targets, err := source.Targets(context.Background())
+	assert(t, err == nil)
+	assert(t, len(targets) == 2)
+
+	assert(t, targets[0].Addr() == "10.0.0.1:8080")
+	assert(t, targets[0].Tags["zone"] == "east" && targets[0].Tags["node"] == "n1")
+	assert(t, targets[1].Addr() == "10.1.0.2:8081" && targets[1].Tags["id"] == "api-2")
+
+	source.Token = "wrong"
+	_, err = source.Targets(context.Background())
+	assert(t, errors.Is(err, cynic.ErrDiscoveryStatus))
+}
+
+func TestKubernetesSource(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert(t, ioutil.WriteFile(tokenFile, []byte("first\n"), 0600) == nil)
+
+	var mux sync.Mutex
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		mux.Unlock()
+
+		if r.URL.Path != "/api/v1/namespaces/prod/endpoints/api" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"subsets": [
+		  {"addresses": [{"ip": "10.2.0.1", "targetRef": {"kind": "Pod", "name": "api-x"}},
+		                 {"ip": "10.2.0.2"}],
+		   "ports": [{"name": "metrics", "port": 9090}, {"name": "http", "port": 8080}]},
+		  {"addresses": [{"ip": "10.2.0.3"}], "ports": [{"name": "metrics", "port": 9090}]}
+		]}`))
+	}))
+	defer server.Close()
+
+	source := &cynic.KubernetesSource{
+		API:       server.URL,
+		Namespace: "prod",
+		Service:   "api",
+		Port:      "http",
+		TokenFile: tokenFile,
+	}
+
+	targets, err := source.Targets(context.Background())
+	assert(t, err == nil)
+	assert(t, len(targets) == 2)
+	assert(t, targets[0].Addr() == "10.2.0.1:8080" && targets[0].Tags["pod"] == "api-x")
+	assert(t, targets[1].Addr() == "10.2.0.2:8080" && targets[1].Tags == nil)
+
+	// the token is read again, as it is rotated
+	assert(t, ioutil.WriteFile(tokenFile, []byte("second"), 0600) == nil)
+	source.Port = ""
+	targets, err = source.Targets(context.Background())
+	assert(t, err == nil)
+	assert(t, len(targets) == 3 && targets[2].Addr() == "10.2.0.3:9090")
+
+	mux.Lock()
+	assert(t, len(tokens) == 2 && tokens[0] == "Bearer first" && tokens[1] == "Bearer second")
+	mux.Unlock()
+
+	source.Namespace = "dev"
+	_, err = source.Targets(context.Background())
+	assert(t, errors.Is(err, cynic.ErrDiscoveryStatus))
+}
+
+func TestDiscoveryConfig(t *testing.T) {
+	config, err := cynic.ParseConfig([]byte(`{
+	  "discovery": [{
+	    "consul": {"addr": "http://127.0.0.1:1", "service": "api"},
+	    "refresh": "10s",
+	    "event": {"label": "api-{host}", "url": "http://{addr}/health", "interval": "30s",
+	              "tags": {"team": "core"}}
+	  }]
+	}`), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+	assert(t, len(session.Discovery) == 1)
+	assert(t, session.Discovery[0].Refresh == 10*time.Second)
+
+	source, ok := session.Discovery[0].Source.(*cynic.ConsulSource)
+	assert(t, ok && source.Service == "api")
+
+	target := cynic.DiscoveryTarget{Host: "10.0.0.1", Port: 8080, Tags: map[string]string{"team": "x", "zone": "east"}}
+	event := session.Discovery[0].Template(target)
+	assert(t, event.Label == "api-10.0.0.1")
+	assert(t, event.Tag("team") == "core" && event.Tag("zone") == "east")
+
+	for _, bad := range []string{
+		`{"discovery": [{"event": {"url": "http://{addr}", "interval": "30s"}}]}`,
+		`{"discovery": [{"srv": "_http._tcp.api", "consul": {"service": "api"},
+		  "event": {"url": "http://{addr}", "interval": "30s"}}]}`,
+		`{"discovery": [{"consul": {}, "event": {"url": "http://{addr}", "interval": "30s"}}]}`,
+		`{"discovery": [{"kubernetes": {"service": "api"}, "event": {"url": "http://{addr}", "interval": "30s"}}]}`,
+		`{"discovery": [{"srv": "_http._tcp.api", "event": {"url": "http://{addr}"}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(bad), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestDualStackProbes(t *testing.T) {
+	// the server only listens on ipv4
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert(t, err == nil)
+	remote := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	remote.Listener = listener
+	remote.Start()
+	defer remote.Close()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [
+		{"label": "api", "url": "http://localhost:%d/", "interval": "1s", "dual_stack": true},
+		{"label": "v4", "url": "http://localhost:%d/", "interval": "1s", "family": "ipv4"}]}`, port, port)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	result := session.Events[0].Execute()
+	assert(t, result.Failed())
+
+	value, err := session.StatusCache.Get("api/ipv4")
+	assert(t, err == nil)
+	v4 := value.(cynic.ProbeResult)
+	assert(t, v4.Family == cynic.FamilyIPv4 && v4.Error == "" && v4.Status == http.StatusOK)
+
+	value, err = session.StatusCache.Get("api/ipv6")
+	assert(t, err == nil)
+	v6 := value.(cynic.ProbeResult)
+	assert(t, v6.Family == cynic.FamilyIPv6 && v6.Error != "")
+
+	_, err = session.StatusCache.Get("api")
+	assert(t, err != nil)
+
+	result = session.Events[1].Execute()
+	assert(t, !result.Failed())
+	value, err = session.StatusCache.Get("v4")
+	assert(t, err == nil && value.(cynic.ProbeResult).Family == cynic.FamilyIPv4)
+
+	for _, invalid := range []string{
+		`{"events": [{"interval": "1s", "url": "http://x", "family": "ipx"}]}`,
+		`{"events": [{"interval": "1s", "url": "http://x", "family": "ipv4", "dual_stack": true}]}`,
+		`{"events": [{"interval": "1s", "hooks": ["x"], "dual_stack": true}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(invalid), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
+
+func TestTransportPoolFamilies(t *testing.T) {
+	pool := cynic.TransportPoolNew(cynic.TransportLimits{})
+	planner := cynic.PlannerNew()
+	planner.SetTransportPool(pool)
+
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer remote.Close()
+
+	port := remote.Listener.Addr().(*net.TCPAddr).Port
+	data := fmt.Sprintf(`{"events": [{"url": "http://localhost:%d/", "interval": "1s", "dual_stack": true}]}`, port)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+	planner.Add(&session.Events[0])
+
+	result := session.Events[0].Execute()
+	assert(t, len(result.Hooks) == 2)
+	assert(t, pool.Stats().New >= 1)
+}
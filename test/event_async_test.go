@@ -0,0 +1,221 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+func asyncResultHook(result cynic.HookResult) cynic.AsyncHookSignature {
+	return func(_ context.Context, _ *cynic.HookParameters) <-chan cynic.HookResult {
+		ch := make(chan cynic.HookResult, 1)
+		go func() { ch <- result }()
+		return ch
+	}
+}
+
+func blockingAsyncHook(release <-chan struct{}) cynic.AsyncHookSignature {
+	return func(ctx context.Context, _ *cynic.HookParameters) <-chan cynic.HookResult {
+		ch := make(chan cynic.HookResult, 1)
+		go func() {
+			select {
+			case <-release:
+				ch <- cynic.HookResult{Result: "released"}
+			case <-ctx.Done():
+			}
+		}()
+		return ch
+	}
+}
+
+func TestAsyncHookCompletes(t *testing.T) {
+	recorder := &resultRecorder{}
+	planner := cynic.PlannerNew()
+	planner.AddSink(recorder)
+
+	var mux sync.Mutex
+	var completed []cynic.HookResult
+
+	event := cynic.EventNew(1)
+	event.Label = "crawl"
+	event.AddAsyncHook(asyncResultHook(cynic.HookResult{Result: "pages"}), cynic.AsyncHookConfig{
+		OnComplete: func(result cynic.HookResult) {
+			mux.Lock()
+			defer mux.Unlock()
+			completed = append(completed, result)
+		},
+	})
+	planner.Add(&event)
+	planner.Advance(2 * time.Second)
+
+	assert(t, eventually(func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(completed) == 1
+	}))
+	assert(t, completed[0].Result == "pages" && !completed[0].Failed)
+
+	assert(t, eventually(func() bool {
+		recorder.mux.Lock()
+		defer recorder.mux.Unlock()
+		for _, result := range recorder.results {
+			if result.Async {
+				return result.Label == "crawl" && len(result.Hooks) == 1
+			}
+		}
+		return false
+	}))
+}
+
+func TestAsyncHookTimeout(t *testing.T) {
+	results := make(chan cynic.HookResult, 1)
+
+	event := cynic.EventNew(1)
+	event.AddAsyncHook(blockingAsyncHook(nil), cynic.AsyncHookConfig{
+		Timeout:    50 * time.Millisecond,
+		OnComplete: func(result cynic.HookResult) { results <- result },
+	})
+
+	execution := event.Execute()
+	assert(t, execution.AsyncStarted == 1)
+
+	select {
+	case result := <-results:
+		assert(t, result.Failed)
+		message, _ := result.Result.(string)
+		assert(t, strings.Contains(message, cynic.ErrAsyncHookTimeout.Error()))
+	case <-time.After(5 * time.Second):
+		t.Fatal("async hook did not time out")
+	}
+}
+
+func TestAsyncHookMaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+	done := make(chan cynic.HookResult, 2)
+
+	event := cynic.EventNew(1)
+	event.AddAsyncHook(blockingAsyncHook(release), cynic.AsyncHookConfig{
+		OnComplete: func(result cynic.HookResult) { done <- result },
+	})
+
+	first := event.Execute()
+	second := event.Execute()
+	assert(t, first.AsyncStarted == 1)
+	assert(t, second.AsyncStarted == 0 && second.AsyncBusy == 1)
+
+	close(release)
+	result := <-done
+	assert(t, result.Result == "released")
+
+	third := event.Execute()
+	assert(t, third.AsyncStarted == 1)
+	<-done
+}
+
+func TestAsyncHookPlannerLimit(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	var mux sync.Mutex
+	started := 0
+	hook := func(ctx context.Context, params *cynic.HookParameters) <-chan cynic.HookResult {
+		mux.Lock()
+		started++
+		mux.Unlock()
+		return blockingAsyncHook(release)(ctx, params)
+	}
+
+	planner := cynic.PlannerNew()
+	planner.SetAsyncLimit(1)
+
+	first := cynic.EventNew(1)
+	first.AddAsyncHook(hook, cynic.AsyncHookConfig{})
+	second := cynic.EventNew(1)
+	second.AddAsyncHook(hook, cynic.AsyncHookConfig{})
+	planner.Add(&first)
+	planner.Add(&second)
+	planner.Advance(2 * time.Second)
+
+	time.Sleep(50 * time.Millisecond)
+	mux.Lock()
+	defer mux.Unlock()
+	assert(t, started == 1)
+}
+
+func TestAsyncHookSkippedOnStop(t *testing.T) {
+	started := make(chan struct{}, 1)
+
+	event := cynic.EventNew(1)
+	event.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		params.StopChain()
+		return false, nil
+	})
+	event.AddAsyncHook(func(_ context.Context, _ *cynic.HookParameters) <-chan cynic.HookResult {
+		started <- struct{}{}
+		return nil
+	}, cynic.AsyncHookConfig{})
+
+	execution := event.Execute()
+	assert(t, execution.AsyncStarted == 0)
+	assert(t, execution.Skipped == 1)
+
+	select {
+	case <-started:
+		t.Fatal("async hook started after the chain stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAsyncHookCountedOnce(t *testing.T) {
+	slo := cynic.SLOTrackerNew(cynic.SLOConfig{})
+	incidents := cynic.IncidentTrackerNew(cynic.IncidentConfig{})
+	planner := cynic.PlannerNew()
+	planner.AddSink(slo)
+	planner.AddSink(incidents)
+
+	completed := make(chan cynic.HookResult, 1)
+	event := cynic.EventNew(1)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return false, "started" })
+	event.AddAsyncHook(asyncResultHook(cynic.HookResult{Failed: true, Result: "bad page"}), cynic.AsyncHookConfig{
+		OnComplete: func(result cynic.HookResult) { completed <- result },
+	})
+	planner.Add(&event)
+	planner.Advance(2 * time.Second)
+
+	select {
+	case <-completed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("async hook did not complete")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := planner.Stop(ctx)
+	assert(t, err == nil)
+
+	// the result of the async hook is not a run of its own
+	reports := slo.Reports(time.Now())
+	assert(t, len(reports) == 1 && reports[0].Windows[0].Runs == 1)
+	assert(t, reports[0].Windows[0].Availability == 100)
+	assert(t, len(incidents.Incidents(cynic.IncidentFilter{})) == 0)
+}
@@ -87,3 +87,122 @@ func TestSimpleErrorCases(t *testing.T) {
 		t.Run(c.name, setup(c.serCount, c.maxTime))
 	}
 }
+
+func TestFluentBuilder(t *testing.T) {
+	var repo cynic.StatusCache
+	hooked := 0
+
+	session, ok := cynic.EventBuilderNew(nil).
+		Add(cynic.EventNew(1), cynic.EventNew(1)).
+		WithInterval(30).
+		WithOffset(5).
+		Repeat(true).
+		WithRepo(&repo).
+		WithHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+			hooked++
+			return false, nil
+		}).
+		Build()
+	assert(t, ok)
+	assert(t, len(session.Events) == 2)
+
+	for i := range session.Events {
+		event := &session.Events[i]
+		assert(t, event.GetSecs() == 30)
+		assert(t, event.GetOffset() == 5)
+		assert(t, event.IsRepeating())
+		assert(t, event.NumHooks() == 1)
+		event.Execute()
+	}
+	assert(t, hooked == 2)
+}
+
+func TestFluentBuilderDistribute(t *testing.T) {
+	session, ok := cynic.EventBuilderNew([]cynic.Event{cynic.EventNew(1), cynic.EventNew(1)}).
+		WithInterval(30).
+		DistributeOver(10).
+		Build()
+	assert(t, ok)
+
+	assert(t, session.Events[0].GetSecs() == 5 && session.Events[0].GetOffset() == 0)
+	assert(t, session.Events[1].GetSecs() == 5 && session.Events[1].GetOffset() == 5)
+
+	_, ok = cynic.EventBuilderNew([]cynic.Event{cynic.EventNew(1), cynic.EventNew(1)}).
+		DistributeOver(1).
+		Build()
+	assert(t, !ok)
+}
+
+func TestStaggerKeepsIntervals(t *testing.T) {
+	var events []cynic.Event
+	for _, secs := range []int{60, 60, 60, 10} {
+		events = append(events, cynic.EventNew(secs))
+	}
+
+	session, ok := cynic.EventBuilderNew(events).Stagger(cynic.StaggerRoundRobin).Build()
+	assert(t, ok)
+
+	expected := []struct{ secs, offset int }{{60, 0}, {60, 15}, {60, 30}, {10, 7}}
+	for i, el := range session.Events {
+		assert(t, el.GetSecs() == expected[i].secs)
+		assert(t, el.GetOffset() == expected[i].offset)
+	}
+}
+
+func TestStaggerOffsetsOnlyTheFirstRun(t *testing.T) {
+	var ticks []int
+	tick := 0
+
+	event := cynic.EventNew(10)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		ticks = append(ticks, tick)
+		return false, nil
+	})
+
+	session, ok := cynic.EventBuilderNew([]cynic.Event{cynic.EventNew(10), event}).
+		Stagger(cynic.StaggerRoundRobin).
+		Build()
+	assert(t, ok && session.Events[1].GetOffset() == 5)
+
+	planner := cynic.PlannerNew()
+	planner.Add(&session.Events[1])
+	for tick = 1; tick <= 45; tick++ {
+		planner.Tick()
+	}
+
+	// the first run waits for the offset and the interval, and the
+	// others are an interval apart
+	assert(t, len(ticks) == 3 && ticks[0] >= 15 && ticks[0] <= 16)
+	for i := 1; i < len(ticks); i++ {
+		assert(t, ticks[i]-ticks[i-1] == 10)
+	}
+}
+
+func TestStaggerHashedIsStable(t *testing.T) {
+	build := func(labels ...string) map[string]int {
+		var events []cynic.Event
+		for _, label := range labels {
+			event := cynic.EventNew(60)
+			event.Label = label
+			events = append(events, event)
+		}
+
+		session, ok := cynic.EventBuilderNew(events).Stagger(cynic.StaggerHashed).Build()
+		assert(t, ok)
+
+		offsets := make(map[string]int)
+		for _, el := range session.Events {
+			assert(t, el.GetSecs() == 60)
+			assert(t, el.GetOffset() >= 0 && el.GetOffset() < 60)
+			offsets[el.Label] = el.GetOffset()
+		}
+		return offsets
+	}
+
+	before := build("api", "db", "cache")
+	after := build("db", "api", "queue")
+
+	assert(t, before["api"] == after["api"])
+	assert(t, before["db"] == after["db"])
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+// overlappingRuns ticks a planner with a repeating event, every run of
+// which blocks until released, for three runs. It returns the state
+// of the event, with the runs still going, and how many runs there
+// were once they were all released.
+func overlappingRuns(t *testing.T, config cynic.OverlapConfig, going int) (cynic.EventState, int32) {
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	var runs int32
+
+	event := cynic.EventNew(1)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		started <- struct{}{}
+		<-release
+		atomic.AddInt32(&runs, 1)
+		return false, nil
+	})
+	if err := event.SetOverlap(config); err != nil {
+		t.Fatal(err)
+	}
+
+	planner := cynic.PlannerNew()
+	planner.Add(&event)
+	planner.Advance(4 * time.Second)
+
+	for i := 0; i < going; i++ {
+		<-started
+	}
+	state := planner.State().Events[0]
+
+	close(release)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if current := planner.State().Events[0]; current.Running == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return state, atomic.LoadInt32(&runs)
+}
+
+func TestEventOverlapSkip(t *testing.T) {
+	state, runs := overlappingRuns(t, cynic.OverlapConfig{Policy: cynic.OverlapSkip}, 1)
+	assert(t, state.Overlap == cynic.OverlapSkip)
+	assert(t, state.Running == 1)
+	assert(t, state.SkippedRuns == 2)
+	assert(t, runs == 1)
+}
+
+func TestEventOverlapQueue(t *testing.T) {
+	state, runs := overlappingRuns(t, cynic.OverlapConfig{Policy: cynic.OverlapQueue}, 1)
+	assert(t, state.Running == 1)
+	assert(t, state.QueuedRuns == 1)
+	assert(t, state.SkippedRuns == 1)
+	assert(t, runs == 2)
+}
+
+func TestEventOverlapConcurrent(t *testing.T) {
+	state, runs := overlappingRuns(t, cynic.OverlapConfig{Policy: cynic.OverlapConcurrent, Limit: 2}, 2)
+	assert(t, state.Running == 2)
+	assert(t, state.SkippedRuns == 1)
+	assert(t, runs == 2)
+
+	state, runs = overlappingRuns(t, cynic.OverlapConfig{Policy: cynic.OverlapConcurrent}, 3)
+	assert(t, state.Running == 3)
+	assert(t, state.SkippedRuns == 0)
+	assert(t, runs == 3)
+}
+
+func TestEventOverlapStats(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	event := cynic.EventNew(1)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		<-release
+		return false, nil
+	})
+	if err := event.SetOverlap(cynic.OverlapConfig{Policy: cynic.OverlapSkip}); err != nil {
+		t.Fatal(err)
+	}
+
+	planner := cynic.PlannerNew()
+	planner.Add(&event)
+	planner.Advance(5 * time.Second)
+
+	assert(t, planner.Stats().OverlapSkips == 3)
+	assert(t, len(planner.Overlapping()) == 1)
+}
+
+func TestEventOverlapConfig(t *testing.T) {
+	for _, overlap := range []string{
+		`{"policy": "pile"}`,
+		`{"policy": "queue", "limit": -1}`,
+	} {
+		data := []byte(`{"events": [{"url": "http://127.0.0.1:1/", "interval": "5s", "overlap": ` + overlap + `}]}`)
+		if _, err := cynic.ParseConfig(data, ".json"); !errors.Is(err, cynic.ErrConfigInvalid) {
+			t.Error("expected", overlap, "to be invalid, got:", err)
+		}
+	}
+
+	data := []byte(`{"events": [{"url": "http://127.0.0.1:1/", "interval": "5s",
+		"overlap": {"policy": "queue", "limit": 2}}]}`)
+	_, err := cynic.ParseConfig(data, ".json")
+	assert(t, err == nil)
+}
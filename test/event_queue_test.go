@@ -18,20 +18,16 @@ limitations under the License.
 package test
 
 import (
-	"container/heap"
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
 
 	"github.com/psyomn/cynic/lib"
 )
 
-func makeEventQueue() cynic.EventQueue {
-	events := make(cynic.EventQueue, 0)
-	heap.Init(&events)
-	return events
-}
-
 func TestEventQueueTimestamp(t *testing.T) {
-	events := makeEventQueue()
+	events := cynic.EventQueueNew()
 
 	s1 := cynic.EventNew(10)
 	s2 := cynic.EventNew(2)
@@ -40,11 +36,9 @@ func TestEventQueueTimestamp(t *testing.T) {
 	ss := [...]cynic.Event{s1, s2, s3}
 
 	for i := 0; i < len(ss); i++ {
-		heap.Push(&events, &ss[i])
+		events.Push(&ss[i])
 	}
 
-	heap.Init(&events)
-
 	{
 		expectedID := s2.ID()
 		actualID, ok := events.PeekID()
@@ -56,7 +50,7 @@ func TestEventQueueTimestamp(t *testing.T) {
 	{
 		s4 := cynic.EventNew(1)
 		expectedID := s4.ID()
-		heap.Push(&events, &s4)
+		events.Push(&s4)
 
 		actualID, ok := events.PeekID()
 
@@ -66,9 +60,111 @@ func TestEventQueueTimestamp(t *testing.T) {
 }
 
 func TestPeekEmpty(t *testing.T) {
-	events := makeEventQueue()
+	events := cynic.EventQueueNew()
 	_, ok := events.PeekID()
 	assert(t, !ok)
+	assert(t, events.Pop() == nil)
+}
+
+func TestEventQueuePushTwice(t *testing.T) {
+	events := cynic.EventQueueNew()
+
+	first := cynic.EventNew(1)
+	first.SetAbsExpiry(10)
+	second := cynic.EventNew(1)
+	second.SetAbsExpiry(20)
+
+	events.Push(&first)
+	events.Push(&second)
+
+	first.SetAbsExpiry(30)
+	events.Push(&first)
+
+	assert(t, events.Len() == 2)
+	assert(t, events.Pop() == &second)
+	assert(t, events.Pop() == &first)
+}
+
+// queueOps are random expiries to push, and which of them to remove
+// afterwards.
+type queueOps struct {
+	Expiries []int64
+	Removed  []bool
+}
+
+func (queueOps) Generate(rand *rand.Rand, size int) reflect.Value {
+	ops := queueOps{
+		Expiries: make([]int64, rand.Intn(size+1)),
+	}
+	ops.Removed = make([]bool, len(ops.Expiries))
+
+	for i := range ops.Expiries {
+		// small range, so that there are ties
+		ops.Expiries[i] = rand.Int63n(int64(size) + 1)
+		ops.Removed[i] = rand.Intn(3) == 0
+	}
+
+	return reflect.ValueOf(ops)
+}
+
+func TestEventQueuePopsInOrder(t *testing.T) {
+	property := func(ops queueOps) bool {
+		events := cynic.EventQueueNew()
+		pushed := make([]cynic.Event, len(ops.Expiries))
+
+		for i, expiry := range ops.Expiries {
+			pushed[i] = cynic.EventNew(1)
+			pushed[i].SetAbsExpiry(expiry)
+			events.Push(&pushed[i])
+		}
+
+		kept := len(pushed)
+		for i := range pushed {
+			if !ops.Removed[i] {
+				continue
+			}
+
+			removed, ok := events.Remove(pushed[i].ID())
+			if !ok || removed != &pushed[i] || events.Contains(pushed[i].ID()) {
+				return false
+			}
+			kept--
+		}
+
+		if events.Len() != kept {
+			return false
+		}
+
+		last := int64(-1)
+		for event := events.Pop(); event != nil; event = events.Pop() {
+			if event.GetAbsExpiry() < last {
+				return false
+			}
+			last = event.GetAbsExpiry()
+			kept--
+		}
+
+		return kept == 0 && events.Len() == 0
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventQueueRemoveMissing(t *testing.T) {
+	events := cynic.EventQueueNew()
+	event := cynic.EventNew(1)
+
+	_, ok := events.Remove(event.ID())
+	assert(t, !ok)
+
+	events.Push(&event)
+	_, ok = events.Remove(event.ID())
+	assert(t, ok)
+
+	_, ok = events.Remove(event.ID())
+	assert(t, !ok)
 }
 
 func BenchmarkAdditionsPerSecond(b *testing.B) {
@@ -82,9 +178,9 @@ func BenchmarkAdditionsPerSecond(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		eventq := makeEventQueue()
+		eventq := cynic.EventQueueNew()
 		for j := 0; j < numNodes; j++ {
-			heap.Push(&eventq, events[j])
+			eventq.Push(events[j])
 		}
 	}
 }
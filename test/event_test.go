@@ -19,11 +19,14 @@ limitations under the License.
 package test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/psyomn/cynic/lib"
 )
@@ -155,3 +158,107 @@ func TestExtraField(t *testing.T) {
 	})
 	event.Execute()
 }
+
+func TestSessionDefaults(t *testing.T) {
+	overridden := cynic.EventNew(1)
+	overridden.SetTimeout(time.Second)
+	overridden.Repeat(false)
+	overridden.SetHeader("X-Team", "db")
+
+	session := cynic.Session{
+		Events: []cynic.Event{cynic.EventNew(1), overridden},
+		Defaults: &cynic.SessionDefaults{
+			Timeout: 5 * time.Second,
+			Repeat:  true,
+			Jitter:  3,
+			Headers: map[string]string{"X-Team": "ops", "X-Env": "prod"},
+		},
+	}
+
+	var headers []map[string]string
+	for i := range session.Events {
+		session.Events[i].AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+			headers = append(headers, params.Headers)
+			return false, nil
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cynic.Run(ctx, session)
+
+	defaulted := &session.Events[0]
+	assert(t, defaulted.GetTimeout() == 5*time.Second)
+	assert(t, defaulted.IsRepeating())
+	assert(t, defaulted.GetJitter() == 3)
+
+	kept := &session.Events[1]
+	assert(t, kept.GetTimeout() == time.Second)
+	assert(t, !kept.IsRepeating())
+	assert(t, kept.GetJitter() == 3)
+
+	defaulted.Execute()
+	kept.Execute()
+	assert(t, headers[0]["X-Team"] == "ops" && headers[0]["X-Env"] == "prod")
+	assert(t, headers[1]["X-Team"] == "db" && headers[1]["X-Env"] == "prod")
+}
+
+func TestEventAlerterOverridesPlanner(t *testing.T) {
+	delivered := make(chan []cynic.AlertMessage, 1)
+	own := cynic.AlerterNew(1, func(alerts []cynic.AlertMessage) error {
+		delivered <- alerts
+		return nil
+	})
+	own.Start()
+	defer own.Stop()
+
+	planner := cynic.PlannerNew()
+	plannerAlerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error {
+		t.Error("alert went to the planner alerter")
+		return nil
+	})
+	planner.SetAlerter(&plannerAlerter)
+
+	event := cynic.EventNew(1)
+	event.Label = "own"
+	event.SetAlerter(&own)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		return true, nil
+	})
+	planner.Add(&event)
+	event.Execute()
+
+	select {
+	case alerts := <-delivered:
+		assert(t, len(alerts) == 1 && alerts[0].Label == "own")
+	case <-time.After(3 * time.Second):
+		t.Fatal("alert was not delivered to the alerter of the event")
+	}
+}
+
+func TestExecuteResult(t *testing.T) {
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	alerter.Start()
+	defer alerter.Stop()
+
+	event := cynic.EventNew(1)
+	event.SetAlerter(&alerter)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return false, "fine" })
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { panic("boom") })
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return true, "down" })
+
+	result := event.Execute()
+	assert(t, result.Failed())
+	assert(t, result.Failures == 2)
+	assert(t, result.Alerted == 2)
+	assert(t, errors.Is(result.Err, cynic.ErrHookPanicked))
+	assert(t, len(result.Hooks) == 3)
+	assert(t, !result.Hooks[0].Failed && result.Hooks[0].Result == "fine")
+	assert(t, result.Hooks[1].Failed)
+	assert(t, result.Hooks[2].Failed && result.Hooks[2].Result == "down")
+
+	quiet := cynic.EventNew(1)
+	quiet.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return true, nil })
+	result = quiet.Execute()
+	assert(t, result.Failed() && result.Alerted == 0 && result.Err == nil)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+// windowedRuns counts the runs of a repeating event with the windows,
+// ticking a planner once at each of the times.
+func windowedRuns(t *testing.T, windows cynic.ExecutionWindows, times ...time.Time) int {
+	runs := 0
+	event := cynic.EventNew(1)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		runs++
+		return false, nil
+	})
+	if err := event.SetWindows(windows); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := cynic.ManualClockNew(times[0])
+	planner := cynic.PlannerNew()
+	planner.SetClock(clock)
+	planner.Add(&event)
+
+	// the event is due from the second tick on
+	planner.Tick()
+
+	for _, at := range times {
+		clock.Advance(at.Sub(clock.Now()))
+		planner.Tick()
+	}
+
+	return runs
+}
+
+func TestExecutionWindowsOfficeHours(t *testing.T) {
+	windows := cynic.ExecutionWindows{
+		Only:     []cynic.TimeWindow{{From: "09:00", To: "17:00", Days: []string{"weekdays"}}},
+		Timezone: "UTC",
+	}
+
+	// a monday
+	monday := time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	assert(t, windowedRuns(t, windows, monday.Add(10*time.Hour), monday.Add(16*time.Hour)) == 2)
+	assert(t, windowedRuns(t, windows, monday.Add(8*time.Hour), monday.Add(17*time.Hour)) == 0)
+	assert(t, windowedRuns(t, windows, monday.AddDate(0, 0, 5).Add(10*time.Hour)) == 0)
+}
+
+func TestExecutionWindowsNever(t *testing.T) {
+	windows := cynic.ExecutionWindows{
+		Never:    []cynic.TimeWindow{{MonthDays: []int{-1}}, {From: "23:00", To: "01:00", Days: []string{"sun"}}},
+		Timezone: "UTC",
+	}
+
+	lastOfFebruary := time.Date(2021, time.February, 28, 12, 0, 0, 0, time.UTC)
+	assert(t, windowedRuns(t, windows, lastOfFebruary) == 0)
+	assert(t, windowedRuns(t, windows, lastOfFebruary.AddDate(0, 0, -1)) == 1)
+
+	// the window of sunday spans into monday
+	sunday := time.Date(2021, time.March, 7, 0, 0, 0, 0, time.UTC)
+	assert(t, windowedRuns(t, windows, sunday.Add(23*time.Hour+30*time.Minute)) == 0)
+	assert(t, windowedRuns(t, windows, sunday.Add(24*time.Hour+30*time.Minute)) == 0)
+	assert(t, windowedRuns(t, windows, sunday.Add(25*time.Hour+30*time.Minute)) == 1)
+	assert(t, windowedRuns(t, windows, sunday.Add(30*time.Minute)) == 1)
+}
+
+func TestExecutionWindowsSimulate(t *testing.T) {
+	event := cynic.EventNew(1800)
+	event.Repeat(true)
+	err := event.SetWindows(cynic.ExecutionWindows{
+		Only:     []cynic.TimeWindow{{From: "00:00", To: "01:00"}},
+		Timezone: "UTC",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	planner := cynic.PlannerNew()
+	planner.SetClock(cynic.ManualClockNew(time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)))
+	planner.Add(&event)
+
+	// of the runs every half hour over two hours, only the one at
+	// 00:30 is in the window
+	runs := planner.Simulate(2 * time.Hour)
+	assert(t, len(runs) == 1)
+}
+
+func TestExecutionWindowsConfig(t *testing.T) {
+	for _, windows := range []string{
+		`{"only": [{"from": "9am"}]}`,
+		`{"only": [{"from": "09:00", "to": "09:00"}]}`,
+		`{"never": [{"days": ["someday"]}]}`,
+		`{"never": [{"month_days": [0]}]}`,
+		`{"timezone": "Nowhere/Nope"}`,
+	} {
+		data := []byte(`{"events": [{"url": "http://127.0.0.1:1/", "interval": "5s", "windows": ` + windows + `}]}`)
+		if _, err := cynic.ParseConfig(data, ".json"); !errors.Is(err, cynic.ErrConfigInvalid) {
+			t.Error("expected", windows, "to be invalid, got:", err)
+		}
+	}
+
+	data := []byte(`{"events": [{"url": "http://127.0.0.1:1/", "interval": "5s",
+		"windows": {"only": [{"from": "09:00", "to": "17:00", "days": ["Mon", "fri"]}], "timezone": "UTC"}}]}`)
+	_, err := cynic.ParseConfig(data, ".json")
+	assert(t, err == nil)
+}
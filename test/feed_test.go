@@ -0,0 +1,118 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+type testFeed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string   `xml:"title"`
+	Entries []struct {
+		ID      string `xml:"id"`
+		Title   string `xml:"title"`
+		Updated string `xml:"updated"`
+		Summary string `xml:"summary"`
+	} `xml:"entry"`
+}
+
+func getFeed(t *testing.T, url string) (testFeed, *http.Response) {
+	var feed testFeed
+
+	resp := adminRequest(t, http.MethodGet, url, "", nil)
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return feed, resp
+}
+
+func TestFeed(t *testing.T) {
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	alerter.Start()
+	defer alerter.Stop()
+
+	alerter.Ch <- cynic.AlertMessage{
+		EventID:  7,
+		Label:    "api",
+		Group:    "web",
+		Severity: cynic.SeverityCritical,
+		Response: "down",
+	}
+	assert(t, eventually(func() bool {
+		return len(alerter.History(cynic.AlertHistoryFilter{})) == 1
+	}))
+
+	start := time.Now().Add(-time.Hour)
+	incidents := cynic.IncidentTrackerNew(cynic.IncidentConfig{})
+	incidents.Record(cynic.EventResult{EventID: 8, Label: "db", Failed: true, At: start})
+	incidents.Record(cynic.EventResult{EventID: 8, Label: "db", At: start.Add(10 * time.Minute)})
+
+	server := cynic.StatusServerNew("", "0", "/testfeed/")
+	server.WithAlerter(&alerter)
+	server.WithIncidents(incidents)
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	base := "http://127.0.0.1:" + strconv.Itoa(server.GetPort()) + "/feed"
+
+	feed, resp := getFeed(t, base)
+	assert(t, resp.StatusCode == http.StatusOK)
+	assert(t, resp.Header.Get("Content-Type") == "application/atom+xml; charset=utf-8")
+	assert(t, feed.Title != "")
+	assert(t, len(feed.Entries) == 3)
+
+	// newest first: the alert, then the closing and the opening of the
+	// incident
+	assert(t, feed.Entries[0].Title == "[critical] web/api is alerting" && feed.Entries[0].Summary == `"down"`)
+	assert(t, feed.Entries[1].ID == "urn:cynic:incident:1:closed")
+	assert(t, feed.Entries[2].ID == "urn:cynic:incident:1:opened")
+	assert(t, feed.Entries[2].Updated == start.UTC().Format(time.RFC3339))
+
+	feed, _ = getFeed(t, base+"?limit=1")
+	assert(t, len(feed.Entries) == 1)
+
+	feed, _ = getFeed(t, base+"?event=8")
+	assert(t, len(feed.Entries) == 2)
+
+	_, resp = getFeed(t, base+"?limit=none")
+	assert(t, resp.StatusCode == http.StatusBadRequest)
+}
+
+func TestFeedNeedsAlertsOrIncidents(t *testing.T) {
+	server := cynic.StatusServerNew("", "0", "/testfeednone/")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	_, resp := getFeed(t, "http://127.0.0.1:"+strconv.Itoa(server.GetPort())+"/feed")
+	assert(t, resp.StatusCode == http.StatusNotFound)
+}
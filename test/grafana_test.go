@@ -0,0 +1,163 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+func grafanaPost(t *testing.T, url, body string, into interface{}) int {
+	resp := adminRequest(t, http.MethodPost, url, "", bytes.NewBufferString(body))
+	defer resp.Body.Close()
+
+	if into != nil {
+		if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestGrafanaDatasource(t *testing.T) {
+	datasource := cynic.GrafanaDatasourceNew(cynic.GrafanaConfig{})
+	incidents := cynic.IncidentTrackerNew(cynic.IncidentConfig{})
+
+	server := cynic.StatusServerNew("", "0", "/testgrafana/")
+	server.WithGrafana(datasource)
+	server.WithIncidents(incidents)
+
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, failed := range []bool{false, true, true, false} {
+		result := cynic.EventResult{
+			EventID: 1,
+			Label:   "api",
+			Group:   "web",
+			Failed:  failed,
+			Latency: time.Duration(i+1) * 10 * time.Millisecond,
+			At:      start.Add(time.Duration(i) * time.Minute),
+		}
+		datasource.Record(result)
+		incidents.Record(result)
+	}
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	base := "http://127.0.0.1:" + strconv.Itoa(server.GetPort()) + "/grafana/"
+
+	resp := adminRequest(t, http.MethodGet, base, "", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusOK)
+
+	var metrics []string
+	assert(t, grafanaPost(t, base+"search", `{"target": "api"}`, &metrics) == http.StatusOK)
+	assert(t, len(metrics) == 2 && metrics[0] == "web/api.latency_ms" && metrics[1] == "web/api.up")
+
+	query := `{
+		"range": {"from": "2021-03-01T11:00:00Z", "to": "2021-03-01T13:00:00Z"},
+		"targets": [{"target": "web/api.latency_ms"}, {"target": "web/api.up", "type": "table"}]
+	}`
+	var results []map[string]interface{}
+	assert(t, grafanaPost(t, base+"query", query, &results) == http.StatusOK)
+	assert(t, len(results) == 2)
+
+	datapoints, _ := results[0]["datapoints"].([]interface{})
+	assert(t, len(datapoints) == 4)
+	first, _ := datapoints[0].([]interface{})
+	assert(t, len(first) == 2 && first[0] == 10.0 && first[1] == float64(start.Unix()*1000))
+
+	rows, _ := results[1]["rows"].([]interface{})
+	assert(t, results[1]["type"] == "table" && len(rows) == 4)
+	second, _ := rows[1].([]interface{})
+	assert(t, len(second) == 2 && second[1] == 0.0)
+
+	var annotations []map[string]interface{}
+	annotationQuery := `{
+		"range": {"from": "2021-03-01T11:00:00Z", "to": "2021-03-01T13:00:00Z"},
+		"annotation": {"name": "incidents", "query": "web/"}
+	}`
+	assert(t, grafanaPost(t, base+"annotations", annotationQuery, &annotations) == http.StatusOK)
+	assert(t, len(annotations) == 1)
+	assert(t, annotations[0]["time"] == float64(start.Add(time.Minute).Unix()*1000))
+	assert(t, annotations[0]["timeEnd"] == float64(start.Add(3*time.Minute).Unix()*1000))
+	assert(t, annotations[0]["isRegion"] == true)
+}
+
+func TestGrafanaDownsample(t *testing.T) {
+	datasource := cynic.GrafanaDatasourceNew(cynic.GrafanaConfig{Points: 3})
+
+	server := cynic.StatusServerNew("", "0", "/testgrafanadownsample/")
+	server.WithGrafana(datasource)
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		datasource.Record(cynic.EventResult{
+			Label:   "api",
+			Latency: time.Duration(i) * time.Millisecond,
+			At:      start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	base := "http://127.0.0.1:" + strconv.Itoa(server.GetPort()) + "/grafana/"
+
+	var results []struct {
+		Datapoints [][2]float64 `json:"datapoints"`
+	}
+	query := `{"maxDataPoints": 2, "targets": [{"target": "/api.latency_ms"}]}`
+	assert(t, grafanaPost(t, base+"query", query, &results) == http.StatusOK)
+
+	// only the last 3 runs are kept, averaged into 2 points
+	assert(t, len(results) == 1 && len(results[0].Datapoints) == 2)
+	assert(t, results[0].Datapoints[0][0] == 2.5 && results[0].Datapoints[1][0] == 4)
+
+	var annotations []interface{}
+	assert(t, grafanaPost(t, base+"annotations", `{}`, &annotations) == http.StatusOK)
+	assert(t, len(annotations) == 0)
+}
+
+func TestConfigGrafana(t *testing.T) {
+	_, err := cynic.ParseConfig([]byte(`{"grafana": {"points": 10}}`), ".json")
+	assert(t, err != nil)
+
+	config, err := cynic.ParseConfig([]byte(`{
+		"status": {"port": "0"},
+		"grafana": {"points": 10},
+		"events": [{"url": "http://127.0.0.1:1/", "interval": "5s"}]
+	}`), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := config.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, session.Grafana != nil)
+}
@@ -0,0 +1,145 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestHeartbeat(t *testing.T) {
+	server := cynic.StatusServerNew("", "0", "/testheartbeat/")
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	ping := func(token string) int {
+		req, err := makeBackgroundRequest("http://127.0.0.1:" + port + "/ping/" + token)
+		assert(t, err == nil)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("could not connect:", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// tokens are only taken once their hook ran
+	assert(t, ping("backup") == http.StatusNotFound)
+
+	hook := cynic.HeartbeatHookNew("backup", 50*time.Millisecond)
+	params := &cynic.HookParameters{Status: &server}
+
+	late, result := hook(params)
+	assert(t, !late && result.(cynic.HeartbeatResult).Pings == 0)
+
+	assert(t, ping("backup") == http.StatusNoContent)
+	late, result = hook(params)
+	assert(t, !late && result.(cynic.HeartbeatResult).Pings == 1)
+	assert(t, !result.(cynic.HeartbeatResult).LastPing.IsZero())
+
+	time.Sleep(60 * time.Millisecond)
+	late, result = hook(params)
+	assert(t, late && result.(cynic.HeartbeatResult).Late)
+
+	assert(t, ping("backup") == http.StatusNoContent)
+	late, _ = hook(params)
+	assert(t, !late)
+
+	assert(t, ping("") == http.StatusNotFound)
+	assert(t, ping("other") == http.StatusNotFound)
+}
+
+func TestHeartbeatNeverPinged(t *testing.T) {
+	server := cynic.StatusServerNew("", "0", "/testheartbeat/")
+	hook := cynic.HeartbeatHookNew("nightly", 20*time.Millisecond)
+	params := &cynic.HookParameters{Status: &server}
+
+	late, _ := hook(params)
+	assert(t, !late)
+
+	time.Sleep(30 * time.Millisecond)
+	late, result := hook(params)
+	assert(t, late && result.(cynic.HeartbeatResult).LastPing.IsZero())
+}
+
+func TestHeartbeatClock(t *testing.T) {
+	clock := cynic.ManualClockNew(clockStart)
+	planner := cynic.PlannerNew()
+	planner.SetClock(clock)
+
+	server := cynic.StatusServerNew("", "0", "/testheartbeatclock/")
+	server.WithPlanner(planner)
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	hook := cynic.HeartbeatHookNew("backup", time.Hour)
+	params := &cynic.HookParameters{Status: &server, Planner: planner}
+	hook(params)
+
+	req, err := makeBackgroundRequest("http://127.0.0.1:" + strconv.Itoa(server.GetPort()) + "/ping/backup")
+	assert(t, err == nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	resp.Body.Close()
+
+	// pings and their checks are timed with the clock of the planner
+	late, result := hook(params)
+	assert(t, !late && result.(cynic.HeartbeatResult).LastPing.Equal(clockStart))
+
+	clock.Advance(2 * time.Hour)
+	late, _ = hook(params)
+	assert(t, late)
+}
+
+func TestConfigHeartbeat(t *testing.T) {
+	config, err := cynic.ParseConfig([]byte(`{"status": {"port": "0"},
+		"events": [{"label": "backup", "interval": "1m", "heartbeat": {"token": "s3cret"}}]}`), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	result := session.Events[0].Execute()
+	assert(t, !result.Failed())
+
+	value, err := session.StatusCache.Get("backup")
+	assert(t, err == nil)
+	_, ok := value.(cynic.HeartbeatResult)
+	assert(t, ok)
+
+	for _, invalid := range []string{
+		`{"events": [{"interval": "1s", "heartbeat": {"token": "x"}}]}`,
+		`{"events": [{"label": "a", "interval": "1s", "heartbeat": {}}]}`,
+		`{"events": [{"label": "a", "interval": "1s", "url": "http://x", "heartbeat": {"token": "x"}}]}`,
+		`{"events": [{"label": "a", "interval": "1s", "heartbeat": {"token": "x/y"}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(invalid), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestHookChainPassesResults(t *testing.T) {
+	event := cynic.EventNew(1)
+
+	var previous []interface{}
+	for i := 1; i <= 3; i++ {
+		value := i
+		event.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+			previous = append(previous, params.Previous)
+			return value == 2, value
+		})
+	}
+
+	result := event.Execute()
+	assert(t, result.Failures == 1 && result.Skipped == 0)
+	assert(t, len(previous) == 3)
+	assert(t, previous[0] == nil && previous[1] == 1 && previous[2] == 2)
+}
+
+func TestHookChainStops(t *testing.T) {
+	var ran int32
+	last := func(_ *cynic.HookParameters) (bool, interface{}) {
+		atomic.AddInt32(&ran, 1)
+		return false, nil
+	}
+
+	event := cynic.EventNew(1)
+	event.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		params.StopChain()
+		return false, "maintenance"
+	})
+	event.AddHook(last)
+	event.AddHook(last)
+
+	result := event.Execute()
+	assert(t, !result.Failed() && result.Skipped == 2 && len(result.Hooks) == 1)
+	assert(t, atomic.LoadInt32(&ran) == 0)
+
+	// a failed hook does not stop the chain, unless the event says so
+	failing := cynic.EventNew(1)
+	failing.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return true, "down" })
+	failing.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		assert(t, params.PreviousFailed && params.Previous == "down")
+		return last(params)
+	})
+
+	result = failing.Execute()
+	assert(t, result.Failures == 1 && result.Skipped == 0)
+	assert(t, atomic.LoadInt32(&ran) == 1)
+
+	failing.StopOnFailure(true)
+	result = failing.Execute()
+	assert(t, result.Failures == 1 && result.Skipped == 1)
+	assert(t, atomic.LoadInt32(&ran) == 1)
+
+	panicking := cynic.EventNew(1)
+	panicking.StopOnFailure(true)
+	panicking.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { panic("oops") })
+	panicking.AddHook(last)
+
+	result = panicking.Execute()
+	assert(t, result.Err != nil && result.Skipped == 1)
+	assert(t, atomic.LoadInt32(&ran) == 1)
+}
+
+func TestHookChainConfig(t *testing.T) {
+	var ran int32
+	cynic.RegisterHook("chain-test-after-probe", func(_ *cynic.HookParameters) (bool, interface{}) {
+		atomic.AddInt32(&ran, 1)
+		return false, nil
+	})
+
+	config, err := cynic.ParseConfig([]byte(`{"events": [{"label": "down", "url": "http://127.0.0.1:1/",
+		"interval": "1s", "stop_on_failure": true, "hooks": ["chain-test-after-probe"]}]}`), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	result := session.Events[0].Execute()
+	assert(t, result.Failed() && result.Skipped == 1)
+	assert(t, atomic.LoadInt32(&ran) == 0)
+}
@@ -0,0 +1,195 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestHostLimiterConcurrency(t *testing.T) {
+	limiter := cynic.HostLimiterNew(cynic.HostLimits{Concurrency: 1})
+
+	release, ok := limiter.Acquire("api:443")
+	assert(t, ok)
+
+	_, ok = limiter.Acquire("api:443")
+	assert(t, !ok)
+
+	// other hosts have their own limits
+	other, ok := limiter.Acquire("db:5432")
+	assert(t, ok)
+	other()
+
+	release()
+	release, ok = limiter.Acquire("api:443")
+	assert(t, ok)
+	release()
+
+	assert(t, limiter.Throttled() == 1)
+}
+
+func TestHostLimiterRate(t *testing.T) {
+	limiter := cynic.HostLimiterNew(cynic.HostLimits{Rate: 20, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		release, ok := limiter.AcquireURL("http://api.local/health")
+		assert(t, ok)
+		release()
+	}
+
+	_, ok := limiter.AcquireURL("http://api.local/other")
+	assert(t, !ok)
+	assert(t, limiter.Throttled() == 1)
+
+	// the bucket refills at the rate
+	time.Sleep(100 * time.Millisecond)
+	_, ok = limiter.AcquireURL("http://api.local/health")
+	assert(t, ok)
+}
+
+func TestConfigHostLimitsThrottleProbes(t *testing.T) {
+	var requests int32
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer remote.Close()
+
+	config, err := cynic.ParseConfig([]byte(`{"status": {"port": "0"},
+		"host_limits": {"rate": 0.001, "burst": 1},
+		"events": [
+		  {"label": "a", "url": "`+remote.URL+`/a", "interval": "1s"},
+		  {"label": "b", "url": "`+remote.URL+`/b", "interval": "1s"}
+		]}`), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	planner := cynic.PlannerNew()
+	planner.SetHostLimiter(cynic.HostLimiterNew(*session.HostLimits))
+	for i := range session.Events {
+		planner.Add(&session.Events[i])
+	}
+	planner.Advance(2 * time.Second)
+
+	assert(t, atomic.LoadInt32(&requests) == 1)
+
+	throttled := 0
+	for _, key := range []string{"a", "b"} {
+		value, err := session.StatusCache.Get(key)
+		assert(t, err == nil)
+		if value.(cynic.ProbeResult).Throttled {
+			throttled++
+		}
+	}
+	assert(t, throttled == 1)
+}
+
+func TestConfigHostLimitsInvalid(t *testing.T) {
+	_, err := cynic.ParseConfig([]byte(`{"host_limits": {"rate": -1}}`), ".json")
+	assert(t, err != nil)
+}
+
+func TestThrottledRunResolvesNothing(t *testing.T) {
+	var healthy int32
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer remote.Close()
+
+	config, err := cynic.ParseConfig([]byte(`{"status": {"port": "0"}, "events": [
+		{"label": "api", "url": "`+remote.URL+`/health", "interval": "1s", "repeat": true,
+		 "contracts": [{"status": 200}]}]}`), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	alerter.Start()
+	defer alerter.Stop()
+
+	slo := cynic.SLOTrackerNew(cynic.SLOConfig{})
+	limiter := cynic.HostLimiterNew(cynic.HostLimits{Concurrency: 1})
+
+	planner := cynic.PlannerNew()
+	planner.SetAlerter(&alerter)
+	planner.SetHostLimiter(limiter)
+	planner.AddSink(slo)
+	planner.Add(&session.Events[0])
+
+	planner.Advance(2 * time.Second)
+	assert(t, eventually(func() bool { return len(alerter.ActiveAlerts()) == 1 }))
+
+	// the host is busy: the run is throttled, and resolves nothing
+	atomic.StoreInt32(&healthy, 1)
+	release, ok := limiter.AcquireURL(remote.URL)
+	assert(t, ok)
+	planner.Tick()
+
+	value, err := session.StatusCache.Get("api")
+	assert(t, err == nil && value.(cynic.ProbeResult).Throttled)
+	assert(t, len(alerter.ActiveAlerts()) == 1)
+
+	reports := slo.Reports(time.Now())
+	assert(t, len(reports) == 1 && reports[0].Windows[0].Runs == 1)
+
+	release()
+	planner.Tick()
+	assert(t, len(alerter.ActiveAlerts()) == 0)
+	assert(t, slo.Reports(time.Now())[0].Windows[0].Runs == 2)
+}
+
+func TestThrottledTransactionIsInconclusive(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer remote.Close()
+
+	config, err := cynic.ParseConfig([]byte(`{"status": {"port": "0"}, "events": [
+		{"label": "flow", "interval": "1s", "transaction": {"steps": [
+		  {"name": "login", "url": "`+remote.URL+`/login"},
+		  {"name": "fetch", "url": "`+remote.URL+`/fetch"}]}}]}`), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	limiter := cynic.HostLimiterNew(cynic.HostLimits{Concurrency: 1})
+	planner := cynic.PlannerNew()
+	planner.SetHostLimiter(limiter)
+	planner.Add(&session.Events[0])
+
+	release, ok := limiter.AcquireURL(remote.URL)
+	assert(t, ok)
+	defer release()
+
+	execution, err := planner.RunNow(session.Events[0].ID())
+	assert(t, err == nil)
+	assert(t, execution.Inconclusive && !execution.Failed())
+
+	value, err := session.StatusCache.Get("flow")
+	assert(t, err == nil)
+	result := value.(cynic.TransactionResult)
+	assert(t, result.Throttled && result.FailedStep == "")
+	assert(t, result.Steps[0].Throttled && result.Steps[1].Skipped)
+}
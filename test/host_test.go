@@ -0,0 +1,86 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestHostHook(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("host checks only run on linux")
+	}
+
+	hook := cynic.HostHookNew(cynic.HostCheckConfig{
+		Mounts:           []string{"/"},
+		MaxDiskPercent:   100,
+		MaxInodePercent:  100,
+		MaxMemoryPercent: 100,
+		MaxLoad:          1e6,
+	})
+
+	failed, value := hook(&cynic.HookParameters{})
+	result, ok := value.(cynic.HostResult)
+	assert(t, ok)
+	assert(t, !failed && len(result.Problems) == 0)
+
+	disk, ok := result.Disks["/"]
+	assert(t, ok && disk.Total > 0 && disk.Used <= disk.Total)
+	assert(t, disk.Percent > 0 && disk.Percent <= 100)
+	assert(t, result.Memory != nil && result.Memory.Total > 0 && result.Memory.Percent > 0)
+	assert(t, result.Load != nil && result.Load.Load1 >= 0)
+
+	// something is always used
+	failed, value = cynic.HostHookNew(cynic.HostCheckConfig{
+		Mounts:           []string{"/"},
+		MaxDiskPercent:   1e-9,
+		MaxMemoryPercent: 1e-9,
+	})(&cynic.HookParameters{})
+	result = value.(cynic.HostResult)
+	assert(t, failed && len(result.Problems) == 2)
+	assert(t, result.Load == nil)
+
+	failed, value = cynic.HostHookNew(cynic.HostCheckConfig{Mounts: []string{"/no/such/mount"}})(&cynic.HookParameters{})
+	assert(t, failed && len(value.(cynic.HostResult).Problems) == 1)
+}
+
+func TestHostConfig(t *testing.T) {
+	config, err := cynic.ParseConfig([]byte(`{
+	  "status": {"port": "0"},
+	  "events": [{"label": "box", "interval": "10s",
+	              "host": {"mounts": ["/"], "max_disk_percent": 90, "max_load": 8}}]
+	}`), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+	assert(t, len(session.Events) == 1)
+
+	for _, bad := range []string{
+		`{"events": [{"interval": "10s", "url": "http://x", "host": {"max_load": 1}}]}`,
+		`{"events": [{"interval": "10s", "host": {"max_disk_percent": 90}}]}`,
+		`{"events": [{"interval": "10s", "host": {"max_load": -1}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(bad), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestIncidentTracker(t *testing.T) {
+	tracker := cynic.IncidentTrackerNew(cynic.IncidentConfig{})
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	record := func(after time.Duration, failed bool) {
+		tracker.Record(cynic.EventResult{
+			EventID: 4,
+			Label:   "api",
+			Failed:  failed,
+			At:      start.Add(after),
+			Hooks:   []cynic.HookResult{{Failed: failed, Result: "status " + strconv.Itoa(int(after/time.Minute))}},
+		})
+	}
+
+	record(0, false)
+	record(time.Minute, true)
+	record(2*time.Minute, true)
+	record(4*time.Minute, false)
+	record(5*time.Minute, true)
+
+	incidents := tracker.Incidents(cynic.IncidentFilter{})
+	assert(t, len(incidents) == 2)
+
+	first := incidents[0]
+	assert(t, !first.Open() && first.Failures == 2)
+	assert(t, first.Start.Equal(start.Add(time.Minute)))
+	assert(t, first.Duration == 3*time.Minute)
+	assert(t, first.FirstError == "status 1")
+
+	assert(t, incidents[1].Open() && incidents[1].ID > first.ID)
+
+	open := tracker.Incidents(cynic.IncidentFilter{OnlyOpen: true})
+	assert(t, len(open) == 1 && open[0].ID == incidents[1].ID)
+
+	stats := tracker.Stats(cynic.IncidentFilter{})
+	assert(t, stats.Incidents == 2 && stats.Open == 1 && stats.MTTR == 3*time.Minute)
+}
+
+func TestIncidentAlerts(t *testing.T) {
+	alerter := cynic.AlerterNew(1, func(_ []cynic.AlertMessage) error { return nil })
+	alerter.Start()
+	defer alerter.Stop()
+
+	tracker := cynic.IncidentTrackerNew(cynic.IncidentConfig{Alerter: &alerter})
+	tracker.Record(cynic.EventResult{EventID: 5, Failed: true, At: time.Now()})
+
+	alerter.Ch <- cynic.AlertMessage{EventID: 5}
+	alerter.Ch <- cynic.AlertMessage{EventID: 6}
+
+	assert(t, eventuallyWithin(3*time.Second, func() bool {
+		incidents := tracker.Incidents(cynic.IncidentFilter{EventID: 5})
+		return len(incidents) == 1 && len(incidents[0].Alerts) == 1
+	}))
+}
+
+func TestIncidentsEndpointAndRestore(t *testing.T) {
+	tracker := cynic.IncidentTrackerNew(cynic.IncidentConfig{})
+	now := time.Now()
+	tracker.Record(cynic.EventResult{EventID: 7, Label: "db", Failed: true, At: now.Add(-time.Minute)})
+	tracker.Record(cynic.EventResult{EventID: 7, Label: "db", At: now.Add(-30 * time.Second)})
+	tracker.Record(cynic.EventResult{EventID: 7, Label: "db", Failed: true, At: now})
+
+	server := cynic.StatusServerNew("", "0", "/testincidents/")
+	server.WithIncidents(tracker)
+
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	get := func(path string) []byte {
+		req, err := makeBackgroundRequest("http://127.0.0.1:" + port + path)
+		assert(t, err == nil)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("could not connect:", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		assert(t, err == nil)
+		return body
+	}
+
+	var incidents []cynic.Incident
+	assert(t, json.Unmarshal(get("/incidents?open=true"), &incidents) == nil)
+	assert(t, len(incidents) == 1 && incidents[0].Label == "db")
+
+	var stats cynic.IncidentStats
+	assert(t, json.Unmarshal(get("/incidents/stats"), &stats) == nil)
+	assert(t, stats.Incidents == 2 && stats.MTTR == 30*time.Second)
+
+	// the status, which is what snapshots keep, has the incidents
+	restored := cynic.IncidentTrackerNew(cynic.IncidentConfig{})
+	assert(t, restored.Restore(&cynic.Snapshot{Data: string(get("/testincidents/"))}) == nil)
+	assert(t, len(restored.Incidents(cynic.IncidentFilter{})) == 2)
+
+	// open incidents are closed by the next success
+	restored.Record(cynic.EventResult{EventID: 8, Label: "db", At: now.Add(time.Minute)})
+	stats = restored.Stats(cynic.IncidentFilter{})
+	assert(t, stats.Open == 0 && stats.Incidents == 2)
+
+	// and new ones don't reuse ids
+	restored.Record(cynic.EventResult{EventID: 8, Label: "db", Failed: true, At: now.Add(2 * time.Minute)})
+	ids := map[uint64]bool{}
+	for _, incident := range restored.Incidents(cynic.IncidentFilter{}) {
+		ids[incident.ID] = true
+	}
+	assert(t, len(ids) == 3)
+}
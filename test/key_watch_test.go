@@ -0,0 +1,114 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"testing"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+func TestKeyWatchTooManyChanges(t *testing.T) {
+	cache := cynic.StatusServerNew("", "0", "/testkeywatchrate/")
+	cache.Update("deploys", 1)
+
+	hook := cynic.KeyWatchHookNew(cynic.KeyWatchConfig{Key: "deploys", MaxChangesPerHour: 2})
+	params := &cynic.HookParameters{Status: &cache}
+
+	failed, _ := hook(params)
+	assert(t, !failed)
+
+	cache.Update("deploys", 2)
+	cache.Update("deploys", 2)
+	cache.Update("deploys", 3)
+
+	failed, value := hook(params)
+	result, _ := value.(cynic.KeyWatchResult)
+	assert(t, !failed && result.ChangesLastHour == 2)
+
+	cache.Update("deploys", 4)
+
+	failed, value = hook(params)
+	result, _ = value.(cynic.KeyWatchResult)
+	assert(t, failed && result.ChangesLastHour == 3 && len(result.Problems) == 1)
+}
+
+func TestKeyWatchUnchanged(t *testing.T) {
+	cache := cynic.StatusServerNew("", "0", "/testkeywatchstale/")
+
+	hook := cynic.KeyWatchHookNew(cynic.KeyWatchConfig{
+		Key:          "pushed",
+		MaxUnchanged: cynic.ConfigDuration(50 * time.Millisecond),
+	})
+	params := &cynic.HookParameters{Status: &cache}
+
+	failed, _ := hook(params)
+	assert(t, !failed)
+
+	time.Sleep(100 * time.Millisecond)
+	failed, _ = hook(params)
+	assert(t, failed)
+
+	cache.Update("pushed", map[string]string{"build": "42"})
+	failed, value := hook(params)
+	result, _ := value.(cynic.KeyWatchResult)
+	assert(t, !failed && result.ChangesLastHour == 1)
+}
+
+func TestKeyWatchNeedsStatus(t *testing.T) {
+	hook := cynic.KeyWatchHookNew(cynic.KeyWatchConfig{Key: "deploys", MaxChangesPerHour: 1})
+
+	failed, _ := hook(&cynic.HookParameters{})
+	assert(t, failed)
+}
+
+func TestConfigKeyWatch(t *testing.T) {
+	config, err := cynic.ParseConfig([]byte(`{
+		"status": {"port": "0"},
+		"events": [{
+			"label": "deploy-rate",
+			"interval": "1m",
+			"key_watch": {"key": "deploys", "max_changes_per_hour": 5, "max_unchanged": "24h"}
+		}]
+	}`), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := config.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.StatusCache.Update("deploys", "v1")
+	execution := session.Events[0].Execute()
+	assert(t, !execution.Failed())
+
+	_, err = session.StatusCache.Get("deploy-rate")
+	assert(t, err == nil)
+
+	for _, bad := range []string{
+		`{"events": [{"label": "deploys", "interval": "1m", "key_watch": {"key": "deploys", "max_unchanged": "1h"}}]}`,
+		`{"events": [{"label": "rate", "interval": "1m", "key_watch": {"key": "deploys"}}]}`,
+		`{"events": [{"label": "rate", "interval": "1m", "key_watch": {"key": "deploys", "max_changes_per_hour": -1}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(bad), ".json")
+		assert(t, err != nil)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestFileLeaseLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease")
+	lock := cynic.FileLeaseLockNew(path)
+	ctx := context.Background()
+
+	ok, err := lock.Acquire(ctx, "a", 200*time.Millisecond)
+	assert(t, ok && err == nil)
+
+	ok, err = lock.Acquire(ctx, "b", 200*time.Millisecond)
+	assert(t, !ok && err == nil)
+
+	// the holder renews its lease
+	ok, err = lock.Acquire(ctx, "a", 200*time.Millisecond)
+	assert(t, ok && err == nil)
+
+	// the lease lapses if not renewed
+	time.Sleep(250 * time.Millisecond)
+	ok, err = lock.Acquire(ctx, "b", time.Minute)
+	assert(t, ok && err == nil)
+
+	// only the holder releases the lease
+	assert(t, lock.Release(ctx, "a") == nil)
+	_, err = os.Stat(path)
+	assert(t, err == nil)
+
+	assert(t, lock.Release(ctx, "b") == nil)
+	_, err = os.Stat(path)
+	assert(t, os.IsNotExist(err))
+}
+
+func TestFileLeaseLockStaleLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease")
+	lock := cynic.FileLeaseLockNew(path)
+
+	// a lock file left over by a crash is taken over
+	assert(t, os.WriteFile(path+".lock", nil, 0600) == nil)
+	old := time.Now().Add(-time.Minute)
+	assert(t, os.Chtimes(path+".lock", old, old) == nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	ok, err := lock.Acquire(ctx, "a", time.Minute)
+	assert(t, ok && err == nil)
+}
+
+func TestLeaderStandbyTakesOver(t *testing.T) {
+	lock := cynic.FileLeaseLockNew(filepath.Join(t.TempDir(), "lease"))
+
+	start := func(holder string, runs *int32, changes chan bool) *cynic.Runner {
+		event := cynic.EventNew(1)
+		event.Repeat(true)
+		event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+			atomic.AddInt32(runs, 1)
+			return false, nil
+		})
+
+		runner, err := cynic.StartWithStopper(cynic.Session{
+			Events: []cynic.Event{event},
+			Leader: &cynic.LeaderConfig{
+				Lock:   lock,
+				Holder: holder,
+				TTL:    600 * time.Millisecond,
+				OnChange: func(leader bool) {
+					changes <- leader
+				},
+			},
+		})
+		assert(t, err == nil)
+		return runner
+	}
+
+	var leaderRuns, standbyRuns int32
+	leaderChanges := make(chan bool, 2)
+	standbyChanges := make(chan bool, 2)
+
+	leader := start("leader", &leaderRuns, leaderChanges)
+	assert(t, <-leaderChanges)
+
+	standby := start("standby", &standbyRuns, standbyChanges)
+	defer standby.Stop(context.Background())
+
+	assert(t, eventuallyWithin(5*time.Second, func() bool {
+		return atomic.LoadInt32(&leaderRuns) > 0
+	}))
+	assert(t, atomic.LoadInt32(&standbyRuns) == 0)
+
+	assert(t, leader.Stop(context.Background()) == nil)
+	assert(t, !<-leaderChanges)
+
+	select {
+	case leading := <-standbyChanges:
+		assert(t, leading)
+	case <-time.After(3 * time.Second):
+		t.Fatal("standby did not take over")
+	}
+
+	assert(t, eventuallyWithin(5*time.Second, func() bool {
+		return atomic.LoadInt32(&standbyRuns) > 0
+	}))
+}
@@ -0,0 +1,85 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestLocationCarried(t *testing.T) {
+	delivered := make(chan []cynic.AlertMessage, 1)
+	alerter := cynic.AlerterNew(1, func(alerts []cynic.AlertMessage) error {
+		delivered <- alerts
+		return nil
+	})
+	alerter.Start()
+	defer alerter.Stop()
+
+	recorder := &resultRecorder{}
+	planner := cynic.PlannerNew()
+	planner.SetLocation("eu-west")
+	planner.SetAlerter(&alerter)
+	planner.AddSink(recorder)
+
+	hookLocation := ""
+	event := cynic.EventNew(1)
+	event.Label = "api"
+	event.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		hookLocation = params.Location
+		return true, "down"
+	})
+	planner.Add(&event)
+	planner.Advance(2 * time.Second)
+
+	assert(t, hookLocation == "eu-west")
+	assert(t, len(recorder.results) == 1 && recorder.results[0].Location == "eu-west")
+
+	select {
+	case alerts := <-delivered:
+		assert(t, len(alerts) == 1 && alerts[0].Location == "eu-west")
+	case <-time.After(3 * time.Second):
+		t.Fatal("alert was never delivered")
+	}
+}
+
+func TestConfigLocation(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer remote.Close()
+
+	config, err := cynic.ParseConfig([]byte(`{"location": "us-east", "status": {"port": "0"},
+		"events": [{"label": "api", "url": "`+remote.URL+`", "interval": "1s"}]}`), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+	assert(t, session.Location == "us-east")
+
+	planner := cynic.PlannerNew()
+	planner.SetLocation(session.Location)
+	planner.Add(&session.Events[0])
+	planner.Advance(2 * time.Second)
+
+	value, err := session.StatusCache.Get("api")
+	assert(t, err == nil)
+	assert(t, value.(cynic.ProbeResult).Location == "us-east")
+}
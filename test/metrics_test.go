@@ -0,0 +1,125 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestStatsdEmitter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer conn.Close()
+
+	emitter, err := cynic.StatsdEmitterNew(conn.LocalAddr().String(), "test")
+	assert(t, err == nil)
+
+	planner := cynic.PlannerNew()
+	planner.SetMetrics(emitter)
+
+	event := cynic.EventNew(1)
+	event.Label = "api health"
+	event.Group = "prod"
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return true, nil })
+	planner.Add(&event)
+	planner.Advance(2 * time.Second)
+
+	buf := make([]byte, 1024)
+	assert(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)) == nil)
+	n, _, err := conn.ReadFrom(buf)
+	assert(t, err == nil)
+
+	lines := strings.Split(strings.TrimSpace(string(buf[:n])), "\n")
+	assert(t, len(lines) == 2)
+	assert(t, lines[0] == "test.prod.api_health.failure:1|c")
+	assert(t, strings.HasPrefix(lines[1], "test.prod.api_health.latency:"))
+	assert(t, strings.HasSuffix(lines[1], "|ms"))
+
+	emitter.Close(context.Background())
+}
+
+func TestGraphiteEmitter(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	emitter, err := cynic.GraphiteEmitterNew(listener.Addr().String(), "")
+	assert(t, err == nil)
+
+	at := time.Unix(1600000000, 0)
+	emitter.Emit(cynic.EventSample{EventID: 7, At: at, Latency: 1500 * time.Microsecond})
+	emitter.Close(context.Background())
+
+	select {
+	case lines := <-received:
+		assert(t, len(lines) == 3)
+		assert(t, lines[0] == "cynic.event_7.success 1 1600000000")
+		assert(t, lines[1] == "cynic.event_7.failure 0 1600000000")
+		assert(t, lines[2] == "cynic.event_7.latency_ms 1.500 1600000000")
+	case <-time.After(3 * time.Second):
+		t.Fatal("graphite server received nothing")
+	}
+}
+
+func TestMetricsEmitterBadAddress(t *testing.T) {
+	_, err := cynic.StatsdEmitterNew("localhost", "")
+	assert(t, errors.Is(err, cynic.ErrMetricsAddress))
+}
+
+func TestMetricsEmitterUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	emitter, err := cynic.GraphiteEmitterNew(addr, "")
+	assert(t, err == nil)
+
+	// samples to a server that is down are given up on, not retried
+	// forever
+	emitter.Emit(cynic.EventSample{EventID: 1, At: time.Now()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	emitter.Close(ctx)
+	assert(t, ctx.Err() == nil)
+}
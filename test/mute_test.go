@@ -0,0 +1,96 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestMuteRuleMatches(t *testing.T) {
+	now := time.Now()
+	rule := cynic.MuteRule{Group: "db", Until: now.Add(time.Hour)}
+
+	assert(t, rule.Matches(&cynic.AlertMessage{Group: "db", EventID: 3}, now))
+	assert(t, !rule.Matches(&cynic.AlertMessage{Group: "web"}, now))
+	assert(t, !rule.Matches(&cynic.AlertMessage{Group: "db"}, now.Add(2*time.Hour)))
+
+	rule.Label = "primary"
+	assert(t, !rule.Matches(&cynic.AlertMessage{Group: "db", Label: "replica"}, now))
+	assert(t, rule.Matches(&cynic.AlertMessage{Group: "db", Label: "primary"}, now))
+}
+
+func TestAlerterMuteUnmute(t *testing.T) {
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+
+	_, err := alerter.Mute(cynic.MuteRule{Until: time.Now().Add(time.Hour)})
+	assert(t, err != nil)
+
+	id, err := alerter.Mute(cynic.MuteRule{EventID: 1, Until: time.Now().Add(time.Hour)})
+	assert(t, err == nil)
+	_, err = alerter.Mute(cynic.MuteRule{Label: "expired", Until: time.Now().Add(-time.Hour)})
+	assert(t, err == nil)
+
+	assert(t, len(alerter.Mutes()) == 1)
+	assert(t, alerter.Unmute(id))
+	assert(t, !alerter.Unmute(id))
+	assert(t, len(alerter.Mutes()) == 0)
+}
+
+func TestMutesEndpoint(t *testing.T) {
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	server := cynic.StatusServerNew("", "0", "/testmutesendpoint/")
+	server.WithAlerter(&alerter)
+	server.WithAdmin(&cynic.AdminConfig{Token: "secret"})
+
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	defer server.Stop()
+
+	base := "http://127.0.0.1:" + port
+	body := bytes.NewBufferString(`{"group": "db", "duration": "1h", "reason": "upgrade"}`)
+
+	resp := adminRequest(t, http.MethodPost, base+"/admin/mutes", "secret", body)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusCreated)
+
+	mutes := alerter.Mutes()
+	assert(t, len(mutes) == 1)
+	assert(t, mutes[0].Group == "db" && mutes[0].Reason == "upgrade")
+
+	req, err := makeBackgroundRequest(base + "/testmutesendpoint/__muted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	defer resp.Body.Close()
+
+	var shown []cynic.MuteRule
+	if err := json.NewDecoder(resp.Body).Decode(&shown); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, len(shown) == 1 && shown[0].ID == mutes[0].ID)
+}
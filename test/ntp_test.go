@@ -0,0 +1,122 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+// fakeNTPServer answers ntp requests with its clock set skew ahead of
+// the local one, with the given stratum.
+func fakeNTPServer(t *testing.T, skew time.Duration, stratum byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert(t, err == nil)
+	t.Cleanup(func() { conn.Close() })
+
+	stamp := func(at time.Time) uint64 {
+		secs := uint64(at.Unix() + 2208988800)
+		return secs<<32 | uint64(at.Nanosecond())<<32/uint64(time.Second)
+	}
+
+	go func() {
+		request := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(request)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+
+			response := make([]byte, 48)
+			response[0] = 4<<3 | 4
+			response[1] = stratum
+			copy(response[12:16], "RATE")
+			copy(response[24:32], request[40:48])
+			binary.BigEndian.PutUint64(response[32:], stamp(time.Now().Add(skew)))
+			binary.BigEndian.PutUint64(response[40:], stamp(time.Now().Add(skew)))
+
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestQueryNTP(t *testing.T) {
+	server := fakeNTPServer(t, 3*time.Second, 2)
+
+	result, err := cynic.QueryNTP(context.Background(), server)
+	assert(t, err == nil)
+	assert(t, result.Stratum == 2)
+	assert(t, result.Offset() > 2900*time.Millisecond && result.Offset() < 3100*time.Millisecond)
+	assert(t, result.DelayMs >= 0 && result.DelayMs < 100)
+
+	_, err = cynic.QueryNTP(context.Background(), fakeNTPServer(t, 0, 0))
+	assert(t, errors.Is(err, cynic.ErrNTPResponse))
+}
+
+func TestNTPHook(t *testing.T) {
+	skewed := fakeNTPServer(t, -2*time.Second, 1)
+	inSync := fakeNTPServer(t, 0, 1)
+
+	failed, value := cynic.NTPHookNew(cynic.NTPCheckConfig{Server: skewed})(&cynic.HookParameters{})
+	result := value.(cynic.NTPResult)
+	assert(t, failed && result.Drifted && result.OffsetMs < -1900)
+
+	maxOffset := cynic.ConfigDuration(5 * time.Second)
+	failed, _ = cynic.NTPHookNew(cynic.NTPCheckConfig{Server: skewed, MaxOffset: maxOffset})(&cynic.HookParameters{})
+	assert(t, !failed)
+
+	failed, value = cynic.NTPHookNew(cynic.NTPCheckConfig{Server: inSync})(&cynic.HookParameters{})
+	assert(t, !failed && !value.(cynic.NTPResult).Drifted)
+
+	// nothing answers
+	timeout := cynic.ConfigDuration(100 * time.Millisecond)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer conn.Close()
+
+	config := cynic.NTPCheckConfig{Server: conn.LocalAddr().String(), Timeout: timeout}
+	failed, value = cynic.NTPHookNew(config)(&cynic.HookParameters{})
+	assert(t, failed && value.(cynic.NTPResult).Error != "")
+}
+
+func TestNTPConfig(t *testing.T) {
+	_, err := cynic.ParseConfig([]byte(`{
+	  "events": [{"label": "clock", "interval": "5m",
+	              "ntp": {"server": "pool.ntp.org", "max_offset": "500ms"}}]
+	}`), ".json")
+	assert(t, err == nil)
+
+	for _, bad := range []string{
+		`{"events": [{"interval": "10s", "ntp": {}}]}`,
+		`{"events": [{"interval": "10s", "url": "http://x", "ntp": {"server": "pool.ntp.org"}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(bad), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+// oauth2Server issues tokens "t1", "t2"... for the client "probe", and
+// serves /api to the bearer of the last one.
+type oauth2Server struct {
+	mux       sync.Mutex
+	issued    int
+	expiresIn int
+	form      map[string]string
+
+	*httptest.Server
+}
+
+func oauth2ServerNew(expiresIn int) *oauth2Server {
+	server := &oauth2Server{expiresIn: expiresIn}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mux.Lock()
+		defer server.mux.Unlock()
+
+		switch r.URL.Path {
+		case "/token":
+			user, pass, ok := r.BasicAuth()
+			if !ok {
+				user, pass = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+			}
+			if user != "probe" || pass != "s3cret" || r.PostFormValue("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			server.form = map[string]string{"scope": r.PostFormValue("scope")}
+			server.issued++
+			fmt.Fprintf(w, `{"access_token": "t%d", "token_type": "Bearer", "expires_in": %d}`,
+				server.issued, server.expiresIn)
+		case "/api":
+			if r.Header.Get("Authorization") != fmt.Sprintf("Bearer t%d", server.issued) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	return server
+}
+
+func (s *oauth2Server) tokens() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.issued
+}
+
+func TestOAuth2TokenSource(t *testing.T) {
+	server := oauth2ServerNew(1)
+	defer server.Close()
+
+	source := cynic.OAuth2TokenSourceNew(cynic.OAuth2Config{
+		TokenURL:     server.URL + "/token",
+		ClientID:     "probe",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"read", "health"},
+	})
+
+	token, err := source.Token(context.Background())
+	assert(t, err == nil && token == "t1")
+	assert(t, server.form["scope"] == "read health")
+
+	token, err = source.Token(context.Background())
+	assert(t, err == nil && token == "t1" && server.tokens() == 1)
+
+	// refreshed at half its lifetime at most
+	time.Sleep(600 * time.Millisecond)
+	token, err = source.Token(context.Background())
+	assert(t, err == nil && token == "t2")
+
+	source.Invalidate()
+	token, err = source.Token(context.Background())
+	assert(t, err == nil && token == "t3")
+
+	inBody := cynic.OAuth2TokenSourceNew(cynic.OAuth2Config{
+		TokenURL: server.URL + "/token", ClientID: "probe", ClientSecret: "s3cret", AuthInBody: true,
+	})
+	_, err = inBody.Token(context.Background())
+	assert(t, err == nil)
+
+	wrong := cynic.OAuth2TokenSourceNew(cynic.OAuth2Config{TokenURL: server.URL + "/token", ClientID: "x"})
+	_, err = wrong.Token(context.Background())
+	assert(t, errors.Is(err, cynic.ErrOAuth2Token))
+}
+
+func TestOAuth2Probes(t *testing.T) {
+	server := oauth2ServerNew(3600)
+	defer server.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [
+		{"label": "a", "url": "%[1]s/api", "interval": "1s", "contracts": [{"status": 200}],
+		 "oauth2": {"token_url": "%[1]s/token", "client_id": "probe", "client_secret": "s3cret"}},
+		{"label": "b", "url": "%[1]s/api", "interval": "1s", "contracts": [{"status": 200}],
+		 "oauth2": {"token_url": "%[1]s/token", "client_id": "probe", "client_secret": "s3cret"}}]}`, server.URL)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	// the events share their token
+	result := session.Events[0].Execute()
+	assert(t, !result.Failed())
+	result = session.Events[1].Execute()
+	assert(t, !result.Failed())
+	assert(t, server.tokens() == 1)
+
+	// another client got a newer token, so the one kept is refused,
+	// and fetched anew for the next probe
+	server.mux.Lock()
+	server.issued++
+	server.mux.Unlock()
+
+	result = session.Events[0].Execute()
+	assert(t, result.Failed())
+	result = session.Events[0].Execute()
+	assert(t, !result.Failed())
+	assert(t, server.tokens() == 3)
+
+	for _, invalid := range []string{
+		`{"events": [{"interval": "1s", "hooks": ["x"], "oauth2": {"token_url": "http://x", "client_id": "a"}}]}`,
+		`{"events": [{"interval": "1s", "url": "http://x", "oauth2": {"client_id": "a"}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(invalid), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+var plannerBackends = []struct {
+	name    string
+	backend cynic.PlannerBackend
+}{
+	{"heap", cynic.PlannerHeap},
+	{"wheel", cynic.PlannerTimingWheel},
+}
+
+// runPlanner adds events with the given intervals, deletes every
+// event that deleted says to, ticks the planner until after the last
+// one is due, and returns the ticks each event ran on.
+func runPlanner(backend cynic.PlannerBackend, secs []int, repeat, deleted []bool) map[int][]int {
+	planner := cynic.PlannerNewWithBackend(backend)
+	ran := make(map[int][]int)
+
+	events := make([]cynic.Event, len(secs))
+	last := 0
+	for i := range secs {
+		i := i
+		events[i] = cynic.EventNew(secs[i])
+		events[i].Repeat(repeat[i])
+		events[i].AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+			ran[i] = append(ran[i], params.Planner.State().Ticks)
+			return false, nil
+		})
+		planner.Add(&events[i])
+
+		if secs[i] > last {
+			last = secs[i]
+		}
+	}
+
+	for i := range deleted {
+		if deleted[i] {
+			planner.Delete(&events[i])
+		}
+	}
+
+	for tick := 0; tick <= 2*last+1; tick++ {
+		planner.Tick()
+	}
+
+	return ran
+}
+
+func TestPlannerBackendsAgree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	rounds := 10
+	if testing.Short() {
+		rounds = 3
+	}
+
+	for round := 0; round < rounds; round++ {
+		count := 1 + rng.Intn(50)
+		secs := make([]int, count)
+		repeat := make([]bool, count)
+		deleted := make([]bool, count)
+
+		// mostly short intervals, and some that cross the upper
+		// levels of the wheel
+		for i := range secs {
+			switch rng.Intn(4) {
+			case 0:
+				secs[i] = 1 + rng.Intn(64*64*2)
+			default:
+				secs[i] = 1 + rng.Intn(200)
+			}
+			repeat[i] = rng.Intn(2) == 0
+			deleted[i] = rng.Intn(5) == 0
+		}
+
+		expected := runPlanner(cynic.PlannerHeap, secs, repeat, deleted)
+		actual := runPlanner(cynic.PlannerTimingWheel, secs, repeat, deleted)
+
+		for i := range secs {
+			assert(t, fmt.Sprint(expected[i]) == fmt.Sprint(actual[i]))
+
+			if deleted[i] {
+				assert(t, len(actual[i]) == 0)
+			} else {
+				assert(t, len(actual[i]) > 0 && actual[i][0] == secs[i])
+			}
+		}
+	}
+}
+
+func TestPlannerBackendsTick(t *testing.T) {
+	for _, backend := range plannerBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, secs := range []int{1, 63, 64, 65, 4095, 4096, 4097, 64*64*64 + 3} {
+				ran := runPlanner(backend.backend, []int{secs}, []bool{false}, []bool{false})
+				assert(t, len(ran[0]) == 1 && ran[0][0] == secs)
+			}
+		})
+	}
+}
+
+// Allocation budgets of Planner.Tick: a tick with nothing due should
+// not allocate, and running an event should cost at most a couple of
+// allocations, for its hook parameters and putting it back in the
+// planner.
+const (
+	idleTickAllocBudget  = 0
+	eventTickAllocBudget = 2
+)
+
+func TestTickAllocationBudget(t *testing.T) {
+	noop := func(_ *cynic.HookParameters) (bool, interface{}) {
+		return false, nil
+	}
+
+	for _, backend := range plannerBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			idle := cynic.PlannerNewWithBackend(backend.backend)
+			for i := 0; i < 1000; i++ {
+				event := cynic.EventNew(1000000)
+				event.AddHook(noop)
+				idle.Add(&event)
+			}
+
+			allocs := testing.AllocsPerRun(100, idle.Tick)
+			if allocs > idleTickAllocBudget {
+				t.Fatal("idle tick allocates", allocs, "times, budget is", idleTickAllocBudget)
+			}
+
+			const due = 100
+			busy := cynic.PlannerNewWithBackend(backend.backend)
+			for i := 0; i < due; i++ {
+				event := cynic.EventNew(1)
+				event.Repeat(true)
+				event.AddHook(noop)
+				busy.Add(&event)
+			}
+			busy.Tick()
+
+			allocs = testing.AllocsPerRun(100, busy.Tick) / due
+			if allocs > eventTickAllocBudget {
+				t.Fatal("running an event allocates", allocs, "times, budget is", eventTickAllocBudget)
+			}
+		})
+	}
+}
+
+func benchmarkPlannerTick(b *testing.B, backend cynic.PlannerBackend, count int, repeat bool) {
+	rng := rand.New(rand.NewSource(1))
+	events := make([]cynic.Event, count)
+	for i := range events {
+		events[i] = cynic.EventNew(1 + rng.Intn(3600))
+		events[i].Repeat(repeat)
+		events[i].AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+			return false, nil
+		})
+	}
+
+	planner := cynic.PlannerNewWithBackend(backend)
+	for i := range events {
+		planner.Add(&events[i])
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		planner.Tick()
+	}
+}
+
+func BenchmarkPlannerTick(b *testing.B) {
+	for _, backend := range plannerBackends {
+		for _, count := range []int{1000, 10000, 100000} {
+			for _, repeat := range []bool{true, false} {
+				kind := "oneshot"
+				if repeat {
+					kind = "repeating"
+				}
+
+				name := fmt.Sprintf("%s/%d/%s", backend.name, count, kind)
+				b.Run(name, func(b *testing.B) {
+					benchmarkPlannerTick(b, backend.backend, count, repeat)
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkPlannerAdd(b *testing.B) {
+	for _, backend := range plannerBackends {
+		b.Run(backend.name, func(b *testing.B) {
+			rng := rand.New(rand.NewSource(1))
+			events := make([]cynic.Event, b.N)
+			for i := range events {
+				events[i] = cynic.EventNew(1 + rng.Intn(86400))
+			}
+
+			planner := cynic.PlannerNewWithBackend(backend.backend)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range events {
+				planner.Add(&events[i])
+			}
+		})
+	}
+}
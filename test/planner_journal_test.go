@@ -0,0 +1,184 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+func journalKinds(entries []cynic.JournalEntry) []cynic.JournalEntryKind {
+	kinds := make([]cynic.JournalEntryKind, 0, len(entries))
+	for _, entry := range entries {
+		kinds = append(kinds, entry.Kind)
+	}
+	return kinds
+}
+
+func sameKinds(got, want []cynic.JournalEntryKind) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPlannerJournal(t *testing.T) {
+	journal := cynic.PlannerJournalNew(cynic.PlannerJournalConfig{})
+	planner := cynic.PlannerNew()
+	planner.SetJournal(journal)
+
+	event := cynic.EventNew(2)
+	event.Label = "nightly"
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return false, nil })
+	planner.Add(&event)
+	planner.Advance(3 * time.Second)
+
+	planner.Delete(&event)
+	planner.Add(&event)
+
+	entries := journal.Entries(cynic.JournalFilter{})
+	assert(t, sameKinds(journalKinds(entries), []cynic.JournalEntryKind{
+		cynic.JournalAdded,
+		cynic.JournalFired,
+		cynic.JournalRescheduled,
+		cynic.JournalDeleted,
+		cynic.JournalSkippedDeleted,
+	}))
+	assert(t, entries[0].Label == "nightly" && entries[0].Expiry == 2)
+	assert(t, entries[1].Tick == 2)
+	assert(t, entries[2].Expiry == 4)
+
+	fired := journal.Entries(cynic.JournalFilter{EventID: event.ID(), Kind: cynic.JournalFired})
+	assert(t, len(fired) == 1)
+	assert(t, len(journal.Entries(cynic.JournalFilter{EventID: event.ID() + 1})) == 0)
+}
+
+func TestPlannerJournalRunNow(t *testing.T) {
+	journal := cynic.PlannerJournalNew(cynic.PlannerJournalConfig{})
+	planner := cynic.PlannerNew()
+	planner.SetJournal(journal)
+
+	event := cynic.EventNew(60)
+	planner.Add(&event)
+	if _, err := planner.RunNow(event.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	fired := journal.Entries(cynic.JournalFilter{Kind: cynic.JournalFired})
+	assert(t, len(fired) == 1 && fired[0].Reason != "")
+}
+
+func TestPlannerJournalCapacityAndFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal := cynic.PlannerJournalNew(cynic.PlannerJournalConfig{Capacity: 2, Path: path})
+	planner := cynic.PlannerNew()
+	planner.SetJournal(journal)
+
+	for i := 0; i < 3; i++ {
+		event := cynic.EventNew(60)
+		planner.Add(&event)
+	}
+
+	assert(t, len(journal.Entries(cynic.JournalFilter{})) == 2)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry cynic.JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, entry.Kind == cynic.JournalAdded)
+		lines++
+	}
+	assert(t, lines == 3)
+}
+
+func TestAdminPlannerJournal(t *testing.T) {
+	planner := cynic.PlannerNew()
+	server := cynic.StatusServerNew("", "0", "/testadminjournal/")
+	server.WithPlanner(planner)
+	server.WithAdmin(&cynic.AdminConfig{Token: "secret"})
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	url := "http://127.0.0.1:" + strconv.Itoa(server.GetPort()) + "/admin/planner/journal"
+
+	resp := adminRequest(t, http.MethodGet, url, "secret", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusNotFound)
+
+	planner.SetJournal(cynic.PlannerJournalNew(cynic.PlannerJournalConfig{}))
+	event := cynic.EventNew(60)
+	planner.Add(&event)
+	planner.Delete(&event)
+
+	resp = adminRequest(t, http.MethodGet, url+"?kind=deleted&event="+strconv.FormatUint(event.ID(), 10), "secret", nil)
+	var entries []cynic.JournalEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusOK)
+	assert(t, len(entries) == 1 && entries[0].Kind == cynic.JournalDeleted)
+
+	resp = adminRequest(t, http.MethodGet, url+"?since=yesterday", "secret", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusBadRequest)
+}
+
+func TestConfigJournal(t *testing.T) {
+	config, err := cynic.ParseConfig([]byte(`{
+		"journal": {"capacity": 5},
+		"events": [{"url": "http://127.0.0.1:1/", "interval": "5s"}]
+	}`), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := config.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, session.Journal != nil && session.Journal.Capacity == 5)
+
+	_, err = cynic.ParseConfig([]byte(`{"journal": {"capacity": -1}}`), ".json")
+	assert(t, err != nil)
+}
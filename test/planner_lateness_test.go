@@ -0,0 +1,62 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestPlannerLateness(t *testing.T) {
+	clock := cynic.ManualClockNew(time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC))
+	planner := cynic.PlannerNew()
+	planner.SetClock(clock)
+
+	late := cynic.EventNew(1)
+	late.Label = "late"
+	late.Repeat(true)
+	planner.Add(&late)
+
+	other := cynic.EventNew(60)
+	planner.Add(&other)
+
+	// the event is due on the second tick, a second after the first,
+	// but the planner only ticks three seconds after it
+	planner.Tick()
+	clock.Advance(3 * time.Second)
+	planner.Tick()
+
+	// ticking once a second from there, the planner stays behind
+	clock.Advance(time.Second)
+	planner.Tick()
+
+	report := planner.Lateness()
+	assert(t, report.Overall.Runs == 2)
+	assert(t, report.Overall.Max == 2*time.Second)
+	assert(t, report.Overall.P50 == 2*time.Second)
+
+	assert(t, len(report.Events) == 1)
+	assert(t, report.Events[0].EventID == late.ID())
+	assert(t, report.Events[0].Label == "late")
+	assert(t, report.Events[0].P99 == 2*time.Second)
+
+	assert(t, planner.Stats().Lateness.Max == 2*time.Second)
+
+	planner.Delete(&late)
+	assert(t, len(planner.Lateness().Events) == 0)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+	"github.com/psyomn/cynic/lib/cynictest"
+)
+
+func TestPlannerStopDrains(t *testing.T) {
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+	var runs int32
+
+	event := cynic.EventNew(1)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		started <- struct{}{}
+		<-release
+		atomic.AddInt32(&runs, 1)
+		return false, nil
+	})
+	assert(t, event.SetOverlap(cynic.OverlapConfig{Policy: cynic.OverlapQueue}) == nil)
+
+	planner := cynic.PlannerNew()
+	planner.Add(&event)
+	planner.Advance(2 * time.Second)
+	<-started
+
+	// the run is still going when the deadline passes
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	pending, err := planner.Stop(ctx)
+	cancel()
+	assert(t, pending == 1 && errors.Is(err, context.DeadlineExceeded))
+
+	// ticks are ignored, and the run queued behind it is dropped
+	planner.Advance(5 * time.Second)
+	close(release)
+
+	pending, err = planner.Stop(context.Background())
+	assert(t, pending == 0 && err == nil)
+	assert(t, atomic.LoadInt32(&runs) == 1)
+
+	_, err = planner.RunNow(event.ID())
+	assert(t, errors.Is(err, cynic.ErrPlannerStopped))
+}
+
+func TestPlannerStopWaitsForAsyncHooks(t *testing.T) {
+	release := make(chan struct{})
+	var completed int32
+
+	event := cynic.EventNew(1)
+	event.AddAsyncHook(blockingAsyncHook(release), cynic.AsyncHookConfig{
+		OnComplete: func(_ cynic.HookResult) { atomic.AddInt32(&completed, 1) },
+	})
+
+	planner := cynictest.Planner(t)
+	planner.Add(&event)
+	planner.Advance(2 * time.Second)
+
+	stopped := make(chan struct{})
+	go func() {
+		pending, err := planner.Stop(context.Background())
+		assert(t, pending == 0 && err == nil)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("planner stopped with an async hook in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-stopped
+	assert(t, atomic.LoadInt32(&completed) == 1)
+}
+
+func TestPlannerStopIdle(t *testing.T) {
+	var runs int32
+	event := cynic.EventNew(1)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		atomic.AddInt32(&runs, 1)
+		return false, nil
+	})
+
+	planner := cynic.PlannerNew()
+	planner.Add(&event)
+	planner.Advance(2 * time.Second)
+	assert(t, atomic.LoadInt32(&runs) == 1)
+
+	pending, err := planner.Stop(context.Background())
+	assert(t, pending == 0 && err == nil)
+
+	planner.Advance(3 * time.Second)
+	assert(t, atomic.LoadInt32(&runs) == 1)
+	assert(t, planner.State().Ticks == 2)
+}
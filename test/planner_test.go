@@ -18,8 +18,11 @@ limitations under the License.
 package test
 
 import (
+	"errors"
 	"log"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/psyomn/cynic/lib"
 )
@@ -716,3 +719,191 @@ func TestImmediateWithOffset(t *testing.T) {
 	}
 	assert(t, count == 2)
 }
+
+func TestDeleteRemovesFromQueue(t *testing.T) {
+	planner := cynic.PlannerNew()
+
+	first := cynic.EventNew(10)
+	second := cynic.EventNew(10)
+	planner.Add(&first)
+	planner.Add(&second)
+
+	assert(t, planner.Delete(&first))
+	assert(t, planner.Len() == 1)
+	assert(t, !planner.Delete(&first))
+}
+
+func TestAddFromHooks(t *testing.T) {
+	planner := cynic.PlannerNew()
+
+	var wg sync.WaitGroup
+	spawner := cynic.EventNew(1)
+	spawner.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				child := cynic.EventNew(100)
+				params.Planner.Add(&child)
+			}()
+		}
+		return false, nil
+	})
+	planner.Add(&spawner)
+
+	planner.Tick()
+	planner.Tick()
+	wg.Wait()
+
+	assert(t, planner.Len() == 10)
+}
+
+func TestSimulate(t *testing.T) {
+	var count int
+
+	repeating := cynic.EventNew(10)
+	repeating.Repeat(true)
+	repeating.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		count++
+		return false, 0
+	})
+
+	once := cynic.EventNew(15)
+
+	planner := cynic.PlannerNew()
+	planner.Add(&repeating)
+	planner.Add(&once)
+
+	runs := planner.Simulate(31 * time.Second)
+
+	assert(t, len(runs) == 4)
+	assert(t, runs[0].EventID == repeating.ID() && runs[0].After == 10*time.Second)
+	assert(t, runs[1].EventID == once.ID() && runs[1].After == 15*time.Second)
+	assert(t, runs[2].EventID == repeating.ID() && runs[2].After == 20*time.Second)
+	assert(t, runs[3].EventID == repeating.ID() && runs[3].After == 30*time.Second)
+
+	assert(t, count == 0)
+	assert(t, planner.Len() == 2)
+}
+
+func TestSimulateMatchesTicks(t *testing.T) {
+	var ticks []int
+
+	planner := cynic.PlannerNew()
+	for _, secs := range []int{3, 7, 11} {
+		event := cynic.EventNew(secs)
+		event.Repeat(true)
+		event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+			ticks = append(ticks, planner.State().Ticks)
+			return false, 0
+		})
+		planner.Add(&event)
+	}
+
+	runs := planner.Simulate(60 * time.Second)
+	for i := 0; i < 60; i++ {
+		planner.Tick()
+	}
+
+	assert(t, len(runs) == len(ticks))
+	for i := range runs {
+		assert(t, runs[i].Tick == ticks[i])
+	}
+}
+
+func seededPlanner(seed int64, ticks *[]int) *cynic.Planner {
+	planner := cynic.PlannerNew()
+	planner.SetSeed(seed)
+
+	for _, secs := range []int{3, 7, 11} {
+		event := cynic.EventNew(secs)
+		event.Repeat(true)
+		event.SetJitter(5)
+		event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+			*ticks = append(*ticks, planner.State().Ticks)
+			return false, 0
+		})
+		planner.Add(&event)
+	}
+
+	return planner
+}
+
+func TestSeededJitter(t *testing.T) {
+	var first, second, other []int
+
+	for _, planner := range []*cynic.Planner{seededPlanner(42, &first), seededPlanner(42, &second)} {
+		planner.Advance(120 * time.Second)
+	}
+	seededPlanner(7, &other).Advance(120 * time.Second)
+
+	assert(t, len(first) > 0 && len(first) == len(second))
+	for i := range first {
+		assert(t, first[i] == second[i])
+	}
+
+	same := len(first) == len(other)
+	for i := 0; same && i < len(first); i++ {
+		same = first[i] == other[i]
+	}
+	assert(t, !same)
+}
+
+func TestSeededSimulateMatchesTicks(t *testing.T) {
+	var ticks []int
+
+	planner := seededPlanner(42, &ticks)
+	planner.Advance(30 * time.Second)
+	ticks = nil
+
+	runs := planner.Simulate(60 * time.Second)
+	planner.Advance(60 * time.Second)
+
+	assert(t, len(runs) == len(ticks))
+	for i := range runs {
+		assert(t, runs[i].Tick == ticks[i])
+	}
+}
+
+func TestPlannerStats(t *testing.T) {
+	event := cynic.EventNew(1)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return false, nil })
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return true, nil })
+
+	planner := cynic.PlannerNew()
+	planner.Add(&event)
+	planner.Advance(5 * time.Second)
+
+	stats := planner.Stats()
+	assert(t, stats.Ticks == 5)
+	assert(t, stats.Queued == 1)
+	assert(t, stats.Executions == 4)
+	assert(t, stats.HookFailures == 4)
+}
+
+func TestPlannerRunNow(t *testing.T) {
+	planner := cynic.PlannerNew()
+
+	runs := 0
+	event := cynic.EventNew(5)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		runs++
+		return false, runs
+	})
+	planner.Add(&event)
+
+	before := planner.State().Events[0].NextTick
+
+	result, err := planner.RunNow(event.ID())
+	assert(t, err == nil)
+	assert(t, runs == 1 && len(result.Hooks) == 1 && result.Hooks[0].Result == 1)
+
+	// the schedule is left as it was
+	assert(t, planner.State().Events[0].NextTick == before)
+	planner.Advance(6 * time.Second)
+	assert(t, runs == 2)
+
+	_, err = planner.RunNow(event.ID() + 1000)
+	assert(t, errors.Is(err, cynic.ErrEventNotFound))
+}
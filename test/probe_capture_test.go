@@ -0,0 +1,185 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func executeFailed(event *cynic.Event) bool {
+	result := event.Execute()
+	return result.Failed()
+}
+
+func TestProbeCapture(t *testing.T) {
+	var broken int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t"})
+		w.Header().Set("X-Served-By", "web-3")
+		if atomic.LoadInt32(&broken) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "upstream timed out, and then some")
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [
+		{"label": "api", "url": %q, "interval": "1s", "contracts": [{"status": 200}],
+		 "capture": {"max_body_bytes": 8}},
+		{"label": "plain", "url": %q, "interval": "1s", "contracts": [{"status": 200}]}]}`,
+		server.URL+"/health", server.URL)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	event := session.Events[0]
+	event.SetHeader("Authorization", "Bearer hunter2")
+	event.SetHeader("X-Request-Source", "cynic")
+	assert(t, executeFailed(&event))
+	assert(t, executeFailed(&session.Events[1]))
+
+	capture, ok := session.StatusCache.Capture("api")
+	assert(t, ok)
+	assert(t, capture.Method == http.MethodGet && capture.URL == server.URL+"/health")
+	assert(t, capture.Status == http.StatusBadGateway && capture.Proto == "HTTP/1.1")
+	assert(t, capture.Body == "upstream" && capture.BodyTruncated)
+	assert(t, capture.Response.Get("X-Served-By") == "web-3")
+	assert(t, capture.Response.Get("Set-Cookie") == "REDACTED")
+	assert(t, capture.Request.Get("Authorization") == "REDACTED")
+	assert(t, capture.Request.Get("X-Request-Source") == "cynic")
+	assert(t, len(capture.Failures) == 1 && capture.Error == "")
+	assert(t, capture.RemoteAddr == strings.TrimPrefix(server.URL, "http://"))
+	assert(t, capture.Timings.TotalMs > 0 && capture.Timings.TotalMs >= capture.Timings.TTFBMs)
+	assert(t, strings.Contains(capture.Curl, "'Authorization: REDACTED'"))
+	assert(t, strings.HasSuffix(capture.Curl, "'"+server.URL+"/health'"))
+
+	// events without capture keep nothing
+	_, ok = session.StatusCache.Capture("plain")
+	assert(t, !ok)
+
+	// the last failure is kept while the probe succeeds
+	atomic.StoreInt32(&broken, 0)
+	assert(t, !executeFailed(&event))
+	kept, ok := session.StatusCache.Capture("api")
+	assert(t, ok && kept.Time.Equal(capture.Time))
+}
+
+func TestProbeCaptureNoResponse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [
+		{"label": "down", "url": "http://%s/", "interval": "1s", "capture": {}}]}`, addr)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	assert(t, executeFailed(&session.Events[0]))
+	capture, ok := session.StatusCache.Capture("down")
+	assert(t, ok && capture.Status == 0 && strings.Contains(capture.Error, "refused"))
+	assert(t, capture.Response == nil && capture.Timings.TotalMs > 0)
+
+	for _, invalid := range []string{
+		`{"events": [{"interval": "1s", "hooks": ["x"], "capture": {}}]}`,
+		`{"events": [{"interval": "1s", "url": "http://x/", "capture": {"max_body_bytes": -1}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(invalid), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
+
+func TestProbeCaptureRedactsURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	target := strings.Replace(server.URL, "http://", "http://bob:hunter2@", 1) +
+		"/health?api_key=s3cr3t&region=eu&flag#top"
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [
+		{"label": "api", "url": %q, "interval": "1s", "contracts": [{"status": 200}], "capture": {}}]}`, target)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+	assert(t, executeFailed(&session.Events[0]))
+
+	capture, ok := session.StatusCache.Capture("api")
+	assert(t, ok)
+
+	expected := strings.Replace(server.URL, "http://", "http://REDACTED@", 1) +
+		"/health?api_key=REDACTED&region=REDACTED&flag=REDACTED#top"
+	assert(t, capture.URL == expected)
+	assert(t, strings.HasSuffix(capture.Curl, "'"+expected+"'"))
+	assert(t, !strings.Contains(capture.Curl, "hunter2") && !strings.Contains(capture.Curl, "s3cr3t"))
+}
+
+func TestCapturesEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0", "admin_token": "secret"}, "events": [
+		{"label": "api", "url": %q, "interval": "1s", "contracts": [{"status": 200}], "capture": {}}]}`, server.URL)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+	assert(t, executeFailed(&session.Events[0]))
+
+	status := session.StatusCache
+	port := strconv.Itoa(status.GetPort())
+	go func() { status.Start() }()
+	defer status.Stop()
+
+	base := "http://127.0.0.1:" + port + "/admin/captures"
+
+	resp := adminRequest(t, http.MethodGet, base, "", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusUnauthorized)
+
+	resp = adminRequest(t, http.MethodGet, base+"?key=nope", "secret", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusNotFound)
+
+	resp = adminRequest(t, http.MethodGet, base, "secret", nil)
+	defer resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusOK)
+
+	var captures map[string]cynic.ProbeCapture
+	if err := json.NewDecoder(resp.Body).Decode(&captures); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, captures["api"].Status == http.StatusServiceUnavailable)
+}
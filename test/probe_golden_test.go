@@ -0,0 +1,141 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func goldenSession(t *testing.T, version *int32, golden string) cynic.Session {
+	data := `{"status": {"port": "0"}, "events": [
+		{"label": "api", "url": "` + goldenServer(t, version) + `", "interval": "1s", "golden": ` + golden + `}]}`
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+	return session
+}
+
+func goldenServer(t *testing.T, version *int32) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"version": "1.%d", "generated_at": %q, "items": [{"id": %d, "name": "a"}]}`,
+			atomic.LoadInt32(version), time.Now().Format(time.RFC3339Nano), time.Now().UnixNano())
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func goldenFailures(t *testing.T, session cynic.Session) []string {
+	value, err := session.StatusCache.Get("api")
+	assert(t, err == nil)
+	return value.(cynic.ProbeResult).Failures
+}
+
+func TestProbeGolden(t *testing.T) {
+	version := int32(1)
+	fixture := filepath.Join(t.TempDir(), "api.json")
+	err := ioutil.WriteFile(fixture, []byte(`{
+	  "items": [{"name": "a", "id": 0}],
+	  "version": "1.1"
+	}`), 0o600)
+	assert(t, err == nil)
+
+	session := goldenSession(t, &version, `{"file": "`+fixture+`", "ignore": ["$.generated_at", "$.items[0].id"]}`)
+	event := session.Events[0]
+	assert(t, !executeFailed(&event))
+
+	atomic.StoreInt32(&version, 2)
+	assert(t, executeFailed(&event))
+	failures := goldenFailures(t, session)
+	assert(t, len(failures) == 1)
+	assert(t, strings.Contains(failures[0], `$.version: expected "1.1", got "1.2"`))
+
+	// without ignoring them, the fields changing on every response differ
+	session = goldenSession(t, &version, `{"file": "`+fixture+`"}`)
+	event = session.Events[0]
+	assert(t, executeFailed(&event))
+	failures = goldenFailures(t, session)
+	assert(t, strings.Contains(failures[0], "$.generated_at: unexpected"))
+	assert(t, strings.Contains(failures[0], "$.items[0].id: expected 0"))
+}
+
+func TestProbeGoldenRecord(t *testing.T) {
+	version := int32(1)
+	fixture := filepath.Join(t.TempDir(), "api.json")
+
+	session := goldenSession(t, &version,
+		`{"file": "`+fixture+`", "record": true, "ignore": ["$.generated_at", "$.items[0].id"]}`)
+	event := session.Events[0]
+	assert(t, !executeFailed(&event))
+
+	recorded, err := ioutil.ReadFile(fixture)
+	assert(t, err == nil && strings.Contains(string(recorded), `"version": "1.1"`))
+
+	assert(t, !executeFailed(&event))
+	atomic.StoreInt32(&version, 3)
+	assert(t, executeFailed(&event))
+}
+
+func TestProbeGoldenText(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<h1>hello kitty</h1>")
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	same, changed := filepath.Join(dir, "same.html"), filepath.Join(dir, "changed.html")
+	assert(t, ioutil.WriteFile(same, []byte("<h1>hello kitty</h1>"), 0o600) == nil)
+	assert(t, ioutil.WriteFile(changed, []byte("<h1>hello world</h1>"), 0o600) == nil)
+
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [
+		{"label": "same", "url": %q, "interval": "1s", "golden": {"file": %q}},
+		{"label": "changed", "url": %q, "interval": "1s", "golden": {"file": %q}},
+		{"label": "missing", "url": %q, "interval": "1s", "golden": {"file": %q}}]}`,
+		server.URL, same, server.URL, changed, server.URL, filepath.Join(dir, "missing.html"))
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	assert(t, !executeFailed(&session.Events[0]))
+	assert(t, executeFailed(&session.Events[1]))
+	assert(t, executeFailed(&session.Events[2]))
+
+	value, err := session.StatusCache.Get("changed")
+	assert(t, err == nil)
+	failures := value.(cynic.ProbeResult).Failures
+	assert(t, len(failures) == 1 && strings.Contains(failures[0], "differs from byte 10"))
+
+	for _, invalid := range []string{
+		`{"events": [{"interval": "1s", "hooks": ["x"], "golden": {"file": "a.json"}}]}`,
+		`{"events": [{"interval": "1s", "url": "http://x/", "golden": {}}]}`,
+		`{"events": [{"interval": "1s", "url": "http://x/", "golden": {"file": "a.json", "ignore": ["$.a["]}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(invalid), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestProbeBodyLimit(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json" {
+			fmt.Fprintf(w, `{"pad": %q, "depth": 7}`, strings.Repeat("x", 4096))
+			return
+		}
+		fmt.Fprint(w, strings.Repeat("a", 4096)+"end")
+	}))
+	defer remote.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [
+		{"label": "big", "url": "%[1]s", "interval": "1s", "contracts": [{"contains": "end"}]},
+		{"label": "cut", "url": "%[1]s", "interval": "1s", "max_body_bytes": 1024,
+		 "contracts": [{"contains": "end"}]},
+		{"label": "json", "url": "%[1]s/json", "interval": "1s",
+		 "thresholds": [{"path": "$.depth", "above": 10}]},
+		{"label": "cut_json", "url": "%[1]s/json", "interval": "1s", "max_body_bytes": 1024,
+		 "thresholds": [{"path": "$.depth", "above": 10}]}]}`, remote.URL)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	probe := func(i int) (bool, cynic.ProbeResult) {
+		result := session.Events[i].Execute()
+		value, err := session.StatusCache.Get(session.Events[i].Label)
+		assert(t, err == nil)
+		return result.Failed(), value.(cynic.ProbeResult)
+	}
+
+	failed, result := probe(0)
+	assert(t, !failed && !result.Truncated)
+
+	failed, result = probe(1)
+	assert(t, failed && result.Truncated)
+
+	// the json is decoded as it is read
+	failed, result = probe(2)
+	assert(t, !failed && !result.Truncated && result.Values["$.depth"] == 7)
+
+	failed, result = probe(3)
+	assert(t, failed && result.Truncated && len(result.Values) == 0)
+	assert(t, strings.Contains(result.Failures[0], "truncated at 1024 bytes"))
+
+	invalid := `{"events": [{"interval": "1s", "url": "http://x", "max_body_bytes": -1}]}`
+	_, err = cynic.ParseConfig([]byte(invalid), ".json")
+	assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+}
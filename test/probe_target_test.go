@@ -0,0 +1,132 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestUnixSocketProbe(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	listener, err := net.Listen("unix", socket)
+	assert(t, err == nil)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok %s %s", r.URL.Path, r.URL.Query().Get("deep"))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	target := cynic.UnixScheme + "://" + url.PathEscape(socket) + "/health?deep=1"
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [{"label": "sock", "url": %q, "interval": "1s",
+		"contracts": [{"status": 200, "contains": "ok /health 1"}]}]}`, target)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	result := session.Events[0].Execute()
+	assert(t, !result.Failed())
+
+	value, err := session.StatusCache.Get("sock")
+	assert(t, err == nil)
+	probe := value.(cynic.ProbeResult)
+	assert(t, probe.URL == target && probe.Status == http.StatusOK && probe.Error == "")
+
+	// pooled clients dial the socket too, whatever the host requested
+	pool := cynic.TransportPoolNew(cynic.TransportLimits{})
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://localhost/pooled", nil)
+	assert(t, err == nil)
+	resp, err := pool.Client(target).Do(req)
+	assert(t, err == nil)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert(t, err == nil && string(body) == "ok /pooled ")
+
+	// a socket nobody listens on fails, with its path as root cause
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+	cause := cynic.AlertRootCause(cynic.ProbeResult{
+		URL:   cynic.UnixScheme + "://" + url.PathEscape(missing) + "/",
+		Error: "dial unix " + missing + ": connect: connection refused",
+	})
+	assert(t, cause == missing+" refused")
+}
+
+func TestUnixSocketConfig(t *testing.T) {
+	for _, invalid := range []string{
+		`{"events": [{"interval": "1s", "url": "http+unix:///health"}]}`,
+		`{"events": [{"interval": "1s", "url": "http+unix://%zz/health"}]}`,
+		`{"events": [{"interval": "1s", "url": "http+unix://%2Frun%2Fapi.sock/", "family": "ipv6"}]}`,
+		`{"events": [{"interval": "1s", "url": "http+unix://%2Frun%2Fapi.sock/", "dual_stack": true}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(invalid), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+
+	valid := `{"events": [{"interval": "1s", "url": "http+unix://%2Frun%2Fapi.sock"}]}`
+	_, err := cynic.ParseConfig([]byte(valid), ".json")
+	assert(t, err == nil)
+}
+
+func TestIPv6LiteralProbe(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("no ipv6 loopback:", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	data := fmt.Sprintf(`{"status": {"port": "0"},
+		"events": [{"label": "v6", "url": "http://[::1]:%d/", "interval": "1s", "family": "ipv6"}],
+		"discovery": [{"consul": {"addr": "http://127.0.0.1:1", "service": "api"},
+		  "event": {"label": "api-{host}", "url": "http://{host}:{port}/", "interval": "30s"}}]}`, port)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	result := session.Events[0].Execute()
+	assert(t, !result.Failed())
+
+	value, err := session.StatusCache.Get("v6")
+	assert(t, err == nil && value.(cynic.ProbeResult).Status == http.StatusOK)
+
+	// discovered IPv6 hosts are bracketed in urls, and not in labels
+	event := session.Discovery[0].Template(cynic.DiscoveryTarget{Host: "::1", Port: port})
+	assert(t, event.Label == "api-::1")
+	event.SetDataRepo(session.StatusCache)
+	result = event.Execute()
+	assert(t, !result.Failed())
+}
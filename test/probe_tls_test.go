@@ -0,0 +1,122 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+// probeTLS probes the url of the server with the tls config, and
+// returns the result of the probe, and the event.
+func probeTLS(t *testing.T, url, tlsConfig string) (cynic.ProbeResult, cynic.Event) {
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [{"label": "tls", "url": %q, "interval": "1s"%s}]}`,
+		url, tlsConfig)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := config.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.Events[0].Execute()
+	value, err := session.StatusCache.Get("tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value.(cynic.ProbeResult), session.Events[0]
+}
+
+func TestProbeTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	cert := server.Certificate()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600) == nil)
+
+	keyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	keyPin := "sha256/" + base64.StdEncoding.EncodeToString(keyHash[:])
+	certHash := sha256.Sum256(cert.Raw)
+	certPin := fmt.Sprintf("% X", certHash[:])
+	certPin = strings.ReplaceAll(certPin, " ", ":")
+	wrongPin := "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	// the test certificate is signed by no CA of the system
+	result, _ := probeTLS(t, server.URL, "")
+	assert(t, result.Error != "")
+
+	result, _ = probeTLS(t, server.URL, fmt.Sprintf(`, "tls": {"ca_file": %q}`, caFile))
+	assert(t, result.Error == "" && result.Status == http.StatusOK && !result.Insecure)
+
+	// the test certificate is for example.com, and not for nope.test
+	result, _ = probeTLS(t, server.URL, fmt.Sprintf(`, "tls": {"ca_file": %q, "server_name": "example.com"}`, caFile))
+	assert(t, result.Error == "")
+	result, _ = probeTLS(t, server.URL, fmt.Sprintf(`, "tls": {"ca_file": %q, "server_name": "nope.test"}`, caFile))
+	assert(t, result.Error != "")
+
+	result, _ = probeTLS(t, server.URL, fmt.Sprintf(`, "tls": {"ca_file": %q, "pinned_keys": [%q]}`, caFile, keyPin))
+	assert(t, result.Error == "")
+	result, _ = probeTLS(t, server.URL, fmt.Sprintf(`, "tls": {"ca_file": %q, "pinned_keys": [%q]}`, caFile, wrongPin))
+	assert(t, strings.Contains(result.Error, "pins"))
+
+	// pins are checked even when the chain is not verified
+	result, event := probeTLS(t, server.URL, fmt.Sprintf(`, "tls": {"insecure_skip_verify": true, "pinned_certs": [%q]}`,
+		certPin))
+	assert(t, result.Error == "" && result.Insecure)
+	result, _ = probeTLS(t, server.URL, fmt.Sprintf(`, "tls": {"insecure_skip_verify": true, "pinned_keys": [%q]}`,
+		wrongPin))
+	assert(t, result.Error != "" && result.Insecure)
+
+	planner := cynic.PlannerNew()
+	planner.Add(&event)
+	insecure := planner.Insecure()
+	assert(t, len(insecure) == 1 && insecure[0].Label == "tls" && insecure[0].InsecureTLS)
+}
+
+func TestProbeTLSConfig(t *testing.T) {
+	for _, invalid := range []string{
+		`{"events": [{"interval": "1s", "hooks": ["x"], "tls": {"server_name": "x"}}]}`,
+		`{"events": [{"interval": "1s", "url": "http+unix://%2Frun%2Fapi.sock/", "tls": {"server_name": "x"}}]}`,
+		`{"events": [{"interval": "1s", "url": "https://x/", "tls": {"pinned_keys": ["sha256/nope"]}}]}`,
+		`{"events": [{"interval": "1s", "url": "https://x/", "tls": {"pinned_certs": ["AB:CD"]}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(invalid), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+
+	config, err := cynic.ParseConfig([]byte(`{"events": [{"interval": "1s", "url": "https://x/",
+		"tls": {"ca_file": "/nonexistent/ca.pem"}}]}`), ".json")
+	assert(t, err == nil)
+	_, err = config.Session()
+	assert(t, errors.Is(err, cynic.ErrProbeTLS))
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestProbeTimings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		fmt.Fprint(w, "first half, ")
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, "second half")
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}
+	assert(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(block), 0o600) == nil)
+
+	// the test certificate is for example.com, and localhost is looked
+	// up
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [{"label": "slow", "url": "https://localhost:%d/",
+		"interval": "1s", "tls": {"ca_file": %q, "server_name": "example.com"}}]}`, port, caFile)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	probe := func() cynic.ProbeResult {
+		session.Events[0].Execute()
+		value, err := session.StatusCache.Get("slow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value.(cynic.ProbeResult)
+	}
+
+	result := probe()
+	assert(t, result.Error == "" && result.Timings != nil)
+	timings := result.Timings
+	assert(t, timings.ConnectMs > 0 && timings.TLSMs > 0)
+	assert(t, timings.TTFBMs >= 30 && timings.TransferMs >= 20)
+	assert(t, timings.TotalMs >= timings.DNSMs+timings.ConnectMs+timings.TLSMs+timings.TTFBMs+timings.TransferMs)
+
+	// a reused connection is neither looked up, connected nor
+	// handshaked again
+	result = probe()
+	assert(t, result.Reused)
+	assert(t, result.Timings.DNSMs == 0 && result.Timings.ConnectMs == 0 && result.Timings.TLSMs == 0)
+	assert(t, result.Timings.TTFBMs >= 30)
+}
+
+func TestProbeTimingsNoResponse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "events": [{"label": "down", "url": "http://%s/", "interval": "1s"}]}`,
+		addr)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	session.Events[0].Execute()
+	value, err := session.StatusCache.Get("down")
+	assert(t, err == nil)
+	result := value.(cynic.ProbeResult)
+	assert(t, result.Error != "" && result.Timings != nil)
+	assert(t, result.Timings.TTFBMs == 0 && result.Timings.TransferMs == 0 && result.Timings.TotalMs > 0)
+}
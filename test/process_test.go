@@ -0,0 +1,118 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestProcessHookPidFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process checks only run on linux")
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "test.pid")
+	assert(t, ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0600) == nil)
+
+	hook, err := cynic.ProcessHookNew(cynic.ProcessCheckConfig{PidFile: pidFile, MaxCPUPercent: 1e6})
+	assert(t, err == nil)
+
+	failed, value := hook(&cynic.HookParameters{})
+	result := value.(cynic.ProcessResult)
+	assert(t, !failed)
+	assert(t, len(result.Pids) == 1 && result.Pids[0] == os.Getpid())
+	assert(t, result.RSS > 0 && result.CPUPercent == nil)
+
+	// some cpu to measure
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		runtime.Gosched()
+	}
+
+	failed, value = hook(&cynic.HookParameters{})
+	result = value.(cynic.ProcessResult)
+	assert(t, !failed && result.CPUPercent != nil && *result.CPUPercent >= 0)
+
+	hook, err = cynic.ProcessHookNew(cynic.ProcessCheckConfig{PidFile: pidFile, MaxRSSMB: 1})
+	assert(t, err == nil)
+	failed, value = hook(&cynic.HookParameters{})
+	assert(t, failed && len(value.(cynic.ProcessResult).Problems) == 1)
+}
+
+func TestProcessHookPattern(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process checks only run on linux")
+	}
+
+	cmd := exec.Command("sleep", "37")
+	assert(t, cmd.Start() == nil)
+
+	pidFile := filepath.Join(t.TempDir(), "sleep.pid")
+	assert(t, ioutil.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0600) == nil)
+
+	byPattern, err := cynic.ProcessHookNew(cynic.ProcessCheckConfig{Pattern: `^sleep 37$`})
+	assert(t, err == nil)
+	byPidFile, err := cynic.ProcessHookNew(cynic.ProcessCheckConfig{PidFile: pidFile})
+	assert(t, err == nil)
+
+	failed, value := byPattern(&cynic.HookParameters{})
+	pids := value.(cynic.ProcessResult).Pids
+	assert(t, !failed && len(pids) == 1 && pids[0] == cmd.Process.Pid)
+
+	failed, _ = byPidFile(&cynic.HookParameters{})
+	assert(t, !failed)
+
+	assert(t, cmd.Process.Kill() == nil)
+	_ = cmd.Wait()
+
+	failed, value = byPattern(&cynic.HookParameters{})
+	assert(t, failed && len(value.(cynic.ProcessResult).Pids) == 0)
+
+	failed, _ = byPidFile(&cynic.HookParameters{})
+	assert(t, failed)
+}
+
+func TestProcessConfig(t *testing.T) {
+	_, err := cynic.ParseConfig([]byte(`{
+	  "events": [{"label": "nginx", "interval": "10s",
+	              "process": {"pattern": "^nginx: master", "max_rss_mb": 512}}]
+	}`), ".json")
+	assert(t, err == nil)
+
+	for _, bad := range []string{
+		`{"events": [{"interval": "10s", "process": {}}]}`,
+		`{"events": [{"interval": "10s", "process": {"pid_file": "/run/x.pid", "pattern": "x"}}]}`,
+		`{"events": [{"interval": "10s", "process": {"pattern": "("}}]}`,
+		`{"events": [{"interval": "10s", "url": "http://x", "process": {"pattern": "x"}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(bad), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+
+	_, err = cynic.ProcessHookNew(cynic.ProcessCheckConfig{})
+	assert(t, errors.Is(err, cynic.ErrHostCheck))
+}
@@ -0,0 +1,147 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestPush(t *testing.T) {
+	server := cynic.StatusServerNew("", "0", "/testpush/")
+	server.WithPush(&cynic.PushConfig{Token: "s3cret", MaxBytes: 64})
+
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	push := func(method, path, token, body string) int {
+		url := "http://127.0.0.1:" + port + path
+		req, err := http.NewRequestWithContext(context.Background(), method, url, strings.NewReader(body))
+		assert(t, err == nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("could not connect:", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert(t, push(http.MethodPost, "/push/etl", "", `{}`) == http.StatusUnauthorized)
+	assert(t, push(http.MethodPost, "/push/etl", "nope", `{}`) == http.StatusUnauthorized)
+	assert(t, push(http.MethodPost, "/push/etl", "s3cret", `{"rows":`) == http.StatusBadRequest)
+	assert(t, push(http.MethodPost, "/push/etl", "s3cret", strings.Repeat(" ", 65)+"{}") == http.StatusBadRequest)
+	assert(t, push(http.MethodPost, "/push/__cynic", "s3cret", `{}`) == http.StatusBadRequest)
+	assert(t, push(http.MethodPost, "/push/etl?ttl=-1s", "s3cret", `{}`) == http.StatusBadRequest)
+
+	assert(t, push(http.MethodPost, "/push/etl", "s3cret", `{"rows": 42}`) == http.StatusOK)
+
+	req, err := makeBackgroundRequest("http://127.0.0.1:" + port + "/testpush/etl")
+	assert(t, err == nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert(t, err == nil)
+
+	var pushed cynic.PushedStatus
+	assert(t, json.Unmarshal(body, &pushed) == nil)
+	assert(t, string(pushed.Status) == `{"rows":42}` && !pushed.Failed)
+	assert(t, pushed.ExpiresAt.IsZero())
+
+	assert(t, push(http.MethodDelete, "/push/etl", "s3cret", "") == http.StatusNoContent)
+	_, err = server.Get("etl")
+	assert(t, errors.Is(err, cynic.ErrStatusValueNotFound))
+}
+
+func TestPushedHook(t *testing.T) {
+	server := cynic.StatusServerNew("", "0", "/testpush/")
+	server.WithPush(&cynic.PushConfig{Token: "s3cret"})
+
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	push := func(path string) {
+		url := "http://127.0.0.1:" + port + path
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, strings.NewReader(`{}`))
+		assert(t, err == nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("could not connect:", err)
+		}
+		resp.Body.Close()
+		assert(t, resp.StatusCode == http.StatusOK)
+	}
+
+	hook := cynic.PushedHookNew("backup")
+	params := &cynic.HookParameters{Status: &server}
+
+	failed, _ := hook(params)
+	assert(t, failed)
+
+	push("/push/backup?ttl=50ms")
+	failed, result := hook(params)
+	assert(t, !failed && !result.(cynic.PushedStatus).ExpiresAt.IsZero())
+
+	push("/push/backup?ttl=50ms&failed=true")
+	failed, _ = hook(params)
+	assert(t, failed)
+
+	push("/push/backup?ttl=50ms")
+	time.Sleep(60 * time.Millisecond)
+	failed, _ = hook(params)
+	assert(t, failed)
+
+	_, err := server.Get("backup")
+	assert(t, errors.Is(err, cynic.ErrStatusValueNotFound))
+}
+
+func TestConfigPushed(t *testing.T) {
+	config, err := cynic.ParseConfig([]byte(`{"status": {"port": "0", "push_token": "s3cret", "push_ttl": "1h"},
+		"events": [{"label": "etl", "interval": "1m", "pushed": "etl-run"}]}`), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	result := session.Events[0].Execute()
+	assert(t, result.Failed())
+
+	_, err = cynic.ParseConfig([]byte(`{"events": [{"interval": "1s", "url": "http://x", "pushed": "a"}]}`), ".json")
+	assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+}
@@ -0,0 +1,105 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func routedEvent(label, group string) cynic.Event {
+	event := cynic.EventNew(1)
+	event.Label = label
+	event.Group = group
+	event.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		params.Status.Update(label, "ok")
+		return false, nil
+	})
+	return event
+}
+
+func TestPlannerRepoRoutes(t *testing.T) {
+	public := cynic.StatusServerNew("", "0", "/public/")
+	internal := cynic.StatusServerNew("", "0", "/internal/")
+	own := cynic.StatusServerNew("", "0", "/own/")
+
+	planner := cynic.PlannerNew()
+	planner.SetRepoRoutes(
+		cynic.RepoRoute{Group: "public", Repo: &public},
+		cynic.RepoRoute{Label: "www-*", Repo: &public},
+		cynic.RepoRoute{Repo: &internal},
+	)
+
+	events := []cynic.Event{
+		routedEvent("api", "public"),
+		routedEvent("www-eu", ""),
+		routedEvent("db", "storage"),
+		routedEvent("www-us", ""),
+	}
+	events[3].SetDataRepo(&own)
+
+	for i := range events {
+		planner.Add(&events[i])
+		_, err := planner.RunNow(events[i].ID())
+		assert(t, err == nil)
+	}
+
+	has := func(repo *cynic.StatusCache, key string) bool {
+		_, err := repo.Get(key)
+		return err == nil
+	}
+
+	assert(t, has(&public, "api") && has(&public, "www-eu"))
+	assert(t, has(&internal, "db") && !has(&internal, "api"))
+
+	// events with a repo of their own keep it
+	assert(t, has(&own, "www-us") && !has(&public, "www-us"))
+}
+
+func TestRunRoutesRepos(t *testing.T) {
+	server := cynic.StatusServerNew("", "0", "/testroutes/")
+	public := cynic.StatusServerNew("", "0", "/testroutespublic/")
+
+	runner, err := cynic.StartWithStopper(cynic.Session{
+		Events:      []cynic.Event{routedEvent("api", "public"), routedEvent("db", "")},
+		StatusCache: &server,
+		Defaults:    &cynic.SessionDefaults{Repo: &server, Repeat: true},
+		Routes:      []cynic.RepoRoute{{Group: "public", Repo: &public}},
+	})
+	assert(t, err == nil)
+
+	// the routed repo is served too
+	waitForServer(t, public.GetPort())
+
+	assert(t, eventuallyWithin(5*time.Second, func() bool {
+		_, apiErr := public.Get("api")
+		_, dbErr := server.Get("db")
+		return apiErr == nil && dbErr == nil
+	}))
+
+	_, err = server.Get("api")
+	assert(t, errors.Is(err, cynic.ErrStatusValueNotFound))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert(t, runner.Stop(ctx) == nil)
+}
@@ -0,0 +1,149 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+type resultRecorder struct {
+	mux     sync.Mutex
+	results []cynic.EventResult
+}
+
+func (s *resultRecorder) Record(result cynic.EventResult) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.results = append(s.results, result)
+}
+
+func TestResultSink(t *testing.T) {
+	recorder := &resultRecorder{}
+
+	planner := cynic.PlannerNew()
+	planner.AddSink(recorder)
+
+	event := cynic.EventNew(1)
+	event.Label = "db"
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return false, "fine" })
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) { return true, "slow" })
+	planner.Add(&event)
+	planner.Advance(2 * time.Second)
+
+	assert(t, len(recorder.results) == 1)
+
+	result := recorder.results[0]
+	assert(t, result.EventID == event.ID())
+	assert(t, result.Label == "db")
+	assert(t, result.Failed)
+	assert(t, len(result.Hooks) == 2)
+	assert(t, !result.Hooks[0].Failed && result.Hooks[0].Result == "fine")
+	assert(t, result.Hooks[1].Failed && result.Hooks[1].Result == "slow")
+}
+
+func TestInfluxSink(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert(t, req.Header.Get("Authorization") == "Token secret")
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := cynic.InfluxSinkNew(server.URL + "/api/v2/write?org=o&bucket=b")
+	sink.SetToken("secret")
+	sink.SetMeasurement("checks")
+
+	sink.Record(cynic.EventResult{
+		EventID:  3,
+		Label:    "api, eu",
+		Severity: cynic.SeverityCritical,
+		Failed:   true,
+		Latency:  1500 * time.Microsecond,
+		At:       time.Unix(1, 5),
+		Hooks:    []cynic.HookResult{{Failed: true, Result: `said "no"`}},
+	})
+	sink.Record(cynic.EventResult{EventID: 4, At: time.Unix(2, 0)})
+	sink.Close(context.Background())
+
+	select {
+	case body := <-bodies:
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		assert(t, len(lines) == 2)
+		assert(t, lines[0] == `checks,label=api\,\ eu,severity=critical `+
+			`event_id=3i,failed=true,failures=1i,hooks=1i,latency_ms=1.5,`+
+			`result="[\"said \\\"no\\\"\"]" 1000000005`)
+		assert(t, lines[1] == `checks,severity=info `+
+			`event_id=4i,failed=false,failures=0i,hooks=0i,latency_ms=0 2000000000`)
+	case <-time.After(3 * time.Second):
+		t.Fatal("influxdb received nothing")
+	}
+
+	// results recorded after closing are dropped
+	sink.Record(cynic.EventResult{EventID: 5})
+	assert(t, sink.Dropped() == 1)
+}
+
+func TestRunClosesResultSinks(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies <- string(body)
+	}))
+	defer server.Close()
+
+	sink := cynic.InfluxSinkNew(server.URL + "/write?db=cynic")
+	sink.SetBatching(100, time.Hour)
+
+	ran := make(chan struct{}, 1)
+	event := cynic.EventNew(1)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		ran <- struct{}{}
+		return false, nil
+	})
+
+	runner, err := cynic.StartWithStopper(cynic.Session{
+		Events: []cynic.Event{event},
+		Sinks:  []cynic.ResultSink{sink},
+	})
+	assert(t, err == nil)
+
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event did not run")
+	}
+	assert(t, runner.Stop(context.Background()) == nil)
+
+	select {
+	case body := <-bodies:
+		assert(t, strings.HasPrefix(body, "cynic,severity=warning event_id="))
+	default:
+		t.Fatal("pending results were not written on shutdown")
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestRunShutsDownGracefully(t *testing.T) {
+	delivered := make(chan []cynic.AlertMessage, 1)
+	alerter := cynic.AlerterNew(60, func(alerts []cynic.AlertMessage) error {
+		delivered <- alerts
+		return nil
+	})
+
+	session := cynic.Session{Alerter: &alerter}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		cynic.Run(ctx, session)
+		close(finished)
+	}()
+
+	alerter.Ch <- cynic.AlertMessage{EventID: 1, Label: "pending"}
+	cancel()
+
+	select {
+	case <-finished:
+	case <-time.After(3 * time.Second):
+		t.Fatal("run did not return after its context was done")
+	}
+
+	select {
+	case alerts := <-delivered:
+		assert(t, len(alerts) == 1 && alerts[0].Label == "pending")
+	default:
+		t.Fatal("pending alert was not delivered on shutdown")
+	}
+}
+
+func TestRunnerStop(t *testing.T) {
+	dir := t.TempDir()
+
+	server := cynic.StatusServerNew("", "0", "/testrunnerstop/")
+	server.Update("hello", "kitty")
+
+	delivered := make(chan []cynic.AlertMessage, 1)
+	alerter := cynic.AlerterNew(60, func(alerts []cynic.AlertMessage) error {
+		delivered <- alerts
+		return nil
+	})
+
+	runner, err := cynic.StartWithStopper(cynic.Session{
+		StatusCache: &server,
+		Alerter:     &alerter,
+		SnapshotConfig: &cynic.SnapshotConfig{
+			Interval:  time.Hour,
+			DumpEvery: time.Hour,
+			Path:      dir,
+		},
+	})
+	assert(t, err == nil)
+	waitForServer(t, server.GetPort())
+
+	alerter.Ch <- cynic.AlertMessage{EventID: 1, Label: "pending"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert(t, runner.Stop(ctx) == nil)
+
+	select {
+	case <-runner.Done():
+	default:
+		t.Fatal("runner is not done after stopping")
+	}
+
+	select {
+	case alerts := <-delivered:
+		assert(t, len(alerts) == 1)
+	default:
+		t.Fatal("pending alert was not delivered on stop")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	assert(t, err == nil)
+	assert(t, len(files) == 1)
+
+	// stopping twice is fine
+	assert(t, runner.Stop(ctx) == nil)
+}
+
+func TestRunnerInvalidSession(t *testing.T) {
+	_, err := cynic.StartWithStopper(cynic.Session{
+		SnapshotConfig: &cynic.SnapshotConfig{Interval: time.Hour, DumpEvery: time.Hour},
+	})
+	assert(t, errors.Is(err, cynic.ErrSessionInvalid))
+}
+
+func TestRunPublishesSelfMetrics(t *testing.T) {
+	clock := cynic.ManualClockNew(time.Unix(0, 0))
+	server := cynic.StatusServerNew("", "0", "/testselfmetrics/")
+
+	event := cynic.EventNew(1)
+	event.Repeat(true)
+	event.AddHook(func(_ *cynic.HookParameters) (bool, interface{}) {
+		return true, "down"
+	})
+
+	runner, err := cynic.StartWithStopper(cynic.Session{
+		Events:      []cynic.Event{event},
+		StatusCache: &server,
+		Clock:       clock,
+		SelfMetrics: 10 * time.Second,
+	})
+	assert(t, err == nil)
+	defer runner.Stop(context.Background())
+
+	var metrics cynic.SelfMetrics
+	assert(t, eventually(func() bool {
+		clock.Advance(10 * time.Second)
+
+		value, err := server.Get(cynic.SelfMetricsStatusKey)
+		if err != nil {
+			return false
+		}
+		metrics = value.(cynic.SelfMetrics)
+		return metrics.Planner.Executions > 0
+	}))
+
+	assert(t, metrics.Planner.HookFailures == metrics.Planner.Executions)
+	assert(t, metrics.Planner.Queued == 1)
+	assert(t, metrics.ExecutionsPerSecond > 0)
+	assert(t, metrics.Goroutines > 0)
+	assert(t, metrics.HeapAlloc > 0)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestScaffoldFiles(t *testing.T) {
+	dir := path.Join(t.TempDir(), "watchtower")
+
+	written, err := cynic.Scaffold(dir, cynic.ScaffoldProject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, len(written) == 7)
+	assert(t, written[5] == path.Join(dir, "watchtower.service"))
+
+	_, err = cynic.Scaffold(dir, cynic.ScaffoldProject{})
+	assert(t, errors.Is(err, cynic.ErrScaffoldExists))
+
+	_, err = cynic.Scaffold(dir, cynic.ScaffoldProject{Force: true})
+	assert(t, err == nil)
+
+	_, err = cynic.Scaffold(dir, cynic.ScaffoldProject{Name: "../escape"})
+	assert(t, errors.Is(err, cynic.ErrScaffoldName))
+}
+
+// TestScaffoldHappyPath runs the events of the config of a starter
+// project, with the hooks of its main, against a demo target.
+func TestScaffoldHappyPath(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := cynic.Scaffold(dir, cynic.ScaffoldProject{Name: "demo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cynic.RegisterHook("uptime", func(params *cynic.HookParameters) (bool, interface{}) {
+		params.Status.Update("uptime", "1s")
+		return false, "1s"
+	})
+	cynic.RegisterAlertHook("log", func(_ []cynic.AlertMessage) error { return nil })
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "Hostname: demo")
+	}))
+	defer target.Close()
+
+	config, err := cynic.LoadConfig(path.Join(dir, cynic.ScaffoldConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, len(config.Events) == 2)
+
+	config.Status.Port = "0"
+	config.Events[0].URL = target.URL
+
+	session, err := config.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range session.Events {
+		result := session.Events[i].Execute()
+		assert(t, result.Failures == 0)
+	}
+
+	value, err := session.StatusCache.Get("demo")
+	assert(t, err == nil)
+	assert(t, value.(cynic.ProbeResult).Status == http.StatusOK)
+
+	value, err = session.StatusCache.Get("uptime")
+	assert(t, err == nil)
+	assert(t, value == "1s")
+}
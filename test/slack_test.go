@@ -0,0 +1,186 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+type slackTestPayload struct {
+	Text        string `json:"text"`
+	Attachments []struct {
+		Title  string `json:"title"`
+		Text   string `json:"text"`
+		Fields []struct {
+			Title string `json:"title"`
+			Value string `json:"value"`
+		} `json:"fields"`
+	} `json:"attachments"`
+}
+
+func TestSlackAlerterPayload(t *testing.T) {
+	var received slackTestPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error("bad slack payload:", err)
+		}
+	}))
+	defer ts.Close()
+
+	slack := cynic.SlackAlerterNew(ts.URL)
+	slack.SetRateLimit(0)
+	slack.Alert([]cynic.AlertMessage{
+		{Response: "connection refused", Label: "api", EventID: 1},
+		{Response: "timeout", EventID: 2},
+	})
+
+	assert(t, len(received.Attachments) == 2)
+	assert(t, received.Attachments[0].Title == "api")
+	assert(t, received.Attachments[0].Text == "connection refused")
+	assert(t, received.Attachments[1].Title == "event 2")
+}
+
+func TestSlackAlerterRetry(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	slack := cynic.SlackAlerterNew(ts.URL)
+	slack.SetRateLimit(0)
+	slack.SetRetries(2, time.Millisecond)
+	slack.Alert([]cynic.AlertMessage{{Response: "down"}})
+
+	assert(t, calls == 2)
+}
+
+func TestSlackAlerterChunks(t *testing.T) {
+	var received []slackTestPayload
+	failing := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		var payload slackTestPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error("bad slack payload:", err)
+		}
+		received = append(received, payload)
+	}))
+	defer ts.Close()
+
+	messages := make([]cynic.AlertMessage, 250)
+	for i := range messages {
+		messages[i] = cynic.AlertMessage{Response: "down", EventID: uint64(i)}
+	}
+
+	slack := cynic.SlackAlerterNew(ts.URL)
+	slack.SetRateLimit(0)
+	slack.SetRetries(0, time.Millisecond)
+	assert(t, slack.Alert(messages) == nil)
+
+	// slack takes at most 100 attachments a message
+	assert(t, len(received) == 3)
+	assert(t, len(received[0].Attachments) == 100 && received[0].Attachments[0].Title == "event 0")
+	assert(t, len(received[1].Attachments) == 100 && received[1].Attachments[0].Title == "event 100")
+	assert(t, len(received[2].Attachments) == 50 && received[2].Attachments[49].Title == "event 249")
+	assert(t, received[2].Text == "cynic: 50 alert(s) (3 of 3)")
+
+	// failed posts are returned, so that the alerter keeps the alerts
+	failing = true
+	err := slack.Alert(messages[:1])
+	assert(t, errors.Is(err, cynic.ErrAlertSinkRejected))
+}
+
+func TestSlackAlerterTemplate(t *testing.T) {
+	var received slackTestPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error("bad slack payload:", err)
+		}
+	}))
+	defer ts.Close()
+
+	type result struct {
+		Code int
+	}
+
+	tmpl, err := cynic.AlertTemplateNew("slack", "{{.Label}} on {{.CynicHostname}} got {{.Response.Code}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slack := cynic.SlackAlerterNew(ts.URL)
+	slack.SetRateLimit(0)
+	slack.SetTemplate(tmpl)
+	slack.Alert([]cynic.AlertMessage{
+		{Response: result{Code: 503}, Label: "api", CynicHostname: "box"},
+	})
+
+	assert(t, len(received.Attachments) == 1)
+	assert(t, received.Attachments[0].Text == "api on box got 503")
+}
+
+func TestSlackAlerterEndpointAndLongSummary(t *testing.T) {
+	var received slackTestPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error("bad slack payload:", err)
+		}
+	}))
+	defer ts.Close()
+
+	result := cynic.ProbeResult{URL: "http://api.example.com/health", Status: 503}
+	assert(t, cynic.AlertEndpoint(result) == result.URL)
+	assert(t, cynic.AlertEndpoint(&result) == result.URL)
+	assert(t, cynic.AlertEndpoint("down") == "")
+
+	transaction := cynic.TransactionResult{FailedStep: "login", Steps: []cynic.TransactionStepResult{
+		{Name: "home", URL: "http://example.com/"},
+		{Name: "login", URL: "http://example.com/login"},
+	}}
+	assert(t, cynic.AlertEndpoint(transaction) == "http://example.com/login")
+
+	slack := cynic.SlackAlerterNew(ts.URL)
+	slack.SetRateLimit(0)
+	slack.Alert([]cynic.AlertMessage{
+		{Response: strings.Repeat("é", 600), Label: "api", Endpoint: cynic.AlertEndpoint(result)},
+	})
+
+	// long summaries are cut on characters, not bytes
+	assert(t, len(received.Attachments) == 1)
+	text := received.Attachments[0].Text
+	assert(t, utf8.ValidString(text) && text == strings.Repeat("é", 512)+"...")
+
+	fields := received.Attachments[0].Fields
+	assert(t, len(fields) == 3)
+	assert(t, fields[0].Title == "endpoint" && fields[0].Value == result.URL)
+}
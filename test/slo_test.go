@@ -0,0 +1,133 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestSLOTrackerWindows(t *testing.T) {
+	tracker := cynic.SLOTrackerNew(cynic.SLOConfig{Objective: 99})
+	now := time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	record := func(at time.Time, failed bool) {
+		tracker.Record(cynic.EventResult{EventID: 1, Label: "api", Failed: failed, At: at})
+	}
+
+	for i := 0; i < 9; i++ {
+		record(now.Add(-time.Duration(i)*time.Minute), false)
+	}
+	record(now.Add(-10*time.Minute), true)
+
+	// only in the day and the month
+	record(now.Add(-3*time.Hour), true)
+
+	// only in the month
+	record(now.Add(-10*24*time.Hour), false)
+
+	// out of every window
+	record(now.Add(-40*24*time.Hour), true)
+
+	reports := tracker.Reports(now)
+	assert(t, len(reports) == 1 && reports[0].Label == "api")
+
+	windows := reports[0].Windows
+	assert(t, len(windows) == 3)
+	assert(t, windows[0].Window == cynic.SLOWindowHour && windows[0].Runs == 10)
+	assert(t, windows[0].Availability == 90)
+	assert(t, windows[0].BudgetBurn > 9.99 && windows[0].BudgetBurn < 10.01)
+	assert(t, windows[1].Window == cynic.SLOWindowDay && windows[1].Runs == 11)
+	assert(t, windows[2].Window == cynic.SLOWindowMonth && windows[2].Runs == 12)
+	assert(t, windows[2].Availability > 83.3 && windows[2].Availability < 83.4)
+}
+
+func TestSLOTrackerAlerts(t *testing.T) {
+	delivered := make(chan []cynic.AlertMessage, 4)
+	alerter := cynic.AlerterNew(1, func(alerts []cynic.AlertMessage) error {
+		delivered <- alerts
+		return nil
+	})
+	alerter.Start()
+	defer alerter.Stop()
+
+	tracker := cynic.SLOTrackerNew(cynic.SLOConfig{
+		Objective:   95,
+		Alerter:     &alerter,
+		AlertWindow: cynic.SLOWindowDay,
+	})
+
+	now := time.Now()
+	for i := 0; i < 19; i++ {
+		tracker.Record(cynic.EventResult{EventID: 2, Label: "db", At: now})
+	}
+
+	// 95% is still within the objective, 90% is not
+	tracker.Record(cynic.EventResult{EventID: 2, Label: "db", Failed: true, At: now})
+	tracker.Record(cynic.EventResult{EventID: 2, Label: "db", Failed: true, At: now})
+	tracker.Record(cynic.EventResult{EventID: 2, Label: "db", Failed: true, At: now})
+
+	select {
+	case alerts := <-delivered:
+		assert(t, len(alerts) == 1)
+		assert(t, alerts[0].Label == "db" && alerts[0].Fingerprint == "slo:24h")
+
+		report := alerts[0].Response.(cynic.SLOReport)
+		assert(t, report.Windows[1].Availability < 95)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the slo alert was never delivered")
+	}
+
+	select {
+	case <-delivered:
+		t.Fatal("alerted again while still below the objective")
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
+
+func TestSLOStatusEndpoint(t *testing.T) {
+	tracker := cynic.SLOTrackerNew(cynic.SLOConfig{})
+	tracker.Record(cynic.EventResult{EventID: 3, Label: "web", At: time.Now()})
+
+	server := cynic.StatusServerNew("", "0", "/testslo/")
+	server.WithSLO(tracker)
+
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	req, err := makeBackgroundRequest("http://127.0.0.1:" + port + "/testslo/__slo")
+	assert(t, err == nil)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	defer resp.Body.Close()
+
+	var reports []cynic.SLOReport
+	assert(t, json.NewDecoder(resp.Body).Decode(&reports) == nil)
+	assert(t, len(reports) == 1 && reports[0].Objective == 99.9)
+	assert(t, reports[0].Windows[0].Availability == 100)
+}
@@ -0,0 +1,188 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+func snapshotKey(fill byte) cynic.SnapshotKeyFunc {
+	return func() ([]byte, error) {
+		return bytes.Repeat([]byte{fill}, 32), nil
+	}
+}
+
+func TestEncryptedSnapshotStore(t *testing.T) {
+	dir := t.TempDir()
+	store := cynic.SnapshotStore{
+		Flags:     uint8(cynic.SnapshotCompressionGzip) | cynic.SnapshotFlagEncrypted,
+		Snapshots: []*cynic.Snapshot{{Timestamp: 1, Data: `{"db":"internal-db-7.corp"}`}},
+	}
+	store.SetKey(snapshotKey(1))
+
+	for _, format := range []cynic.SnapshotFormat{cynic.SnapshotFormatGob, cynic.SnapshotFormatStream} {
+		storePath := path.Join(dir, "store.cynic")
+		if err := cynic.WriteSnapshotStoreFile(storePath, &store, format); err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := ioutil.ReadFile(storePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, !bytes.Contains(raw, []byte("internal-db")))
+
+		read, err := cynic.ReadSnapshotStoreFileWithKey(storePath, snapshotKey(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, read.Flags&cynic.SnapshotFlagEncrypted != 0)
+		assert(t, len(read.Snapshots) == 1 && read.Snapshots[0].Data == `{"db":"internal-db-7.corp"}`)
+
+		_, err = cynic.ReadSnapshotStoreFileWithKey(storePath, snapshotKey(2))
+		assert(t, errors.Is(err, cynic.ErrSnapshotBadRecord))
+
+		_, err = cynic.ReadSnapshotStoreFile(storePath)
+		assert(t, errors.Is(err, cynic.ErrSnapshotNoKey))
+	}
+}
+
+func TestEncryptedSnapshotTimestampTampering(t *testing.T) {
+	storePath := path.Join(t.TempDir(), "store.cynic")
+	store := cynic.SnapshotStore{
+		Flags:     cynic.SnapshotFlagEncrypted,
+		Snapshots: []*cynic.Snapshot{{Timestamp: 1, Data: `{"hello":"kitty"}`}},
+	}
+	store.SetKey(snapshotKey(1))
+	if err := cynic.WriteSnapshotStoreFile(storePath, &store, cynic.SnapshotFormatStream); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last byte of the timestamp of the first record, after the
+	// header and the length of the record
+	raw[10+4+7] = 2
+	_, err = cynic.ReadSnapshotStoreWithKey(bytes.NewReader(raw), snapshotKey(1))
+	assert(t, errors.Is(err, cynic.ErrSnapshotBadRecord))
+}
+
+// encryptedSnapshotDump dumps a status server with snapshots
+// encrypted with key, and returns the path of its store file.
+func encryptedSnapshotDump(t *testing.T, name string, key cynic.SnapshotKeyFunc) string {
+	dir := t.TempDir()
+
+	server := cynic.StatusServerNew("", "0", "/"+name+"/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Hour,
+		DumpEvery: time.Hour,
+		Path:      dir,
+		Encrypt:   true,
+		Key:       key,
+	})
+	server.Update("hello", name)
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	server.Stop()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatal("expected one dump, got:", len(files))
+	}
+
+	return path.Join(dir, files[0].Name())
+}
+
+func TestEncryptedSnapshotDump(t *testing.T) {
+	storePath := encryptedSnapshotDump(t, "testencryptedsnapshotdump", snapshotKey(1))
+
+	store, err := cynic.ReadSnapshotStoreFileWithKey(storePath, snapshotKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, store.Flags == cynic.SnapshotFlagEncrypted)
+	assert(t, len(store.Snapshots) == 1)
+}
+
+func TestEncryptedSnapshotKeysPerSession(t *testing.T) {
+	// two sessions of one process keep keys of their own
+	first := encryptedSnapshotDump(t, "testencryptedsnapshotkeysfirst", snapshotKey(1))
+	second := encryptedSnapshotDump(t, "testencryptedsnapshotkeyssecond", snapshotKey(2))
+
+	store, err := cynic.ReadSnapshotStoreFileWithKey(first, snapshotKey(1))
+	assert(t, err == nil && len(store.Snapshots) == 1)
+
+	store, err = cynic.ReadSnapshotStoreFileWithKey(second, snapshotKey(2))
+	assert(t, err == nil && len(store.Snapshots) == 1)
+
+	_, err = cynic.ReadSnapshotStoreFileWithKey(second, snapshotKey(1))
+	assert(t, errors.Is(err, cynic.ErrSnapshotBadRecord))
+}
+
+func TestConfigSnapshotEncryption(t *testing.T) {
+	data := []byte(`{
+		"status": {"port": "0"},
+		"snapshots": {"interval": "1m", "dump_every": "1h", "path": "/tmp", "encryption_key_env": "CYNIC_TEST_SNAPSHOT_KEY"},
+		"events": [{"url": "http://127.0.0.1:1/", "interval": "5s"}]
+	}`)
+
+	_, err := cynic.ParseConfig(data, ".json")
+	assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+
+	if err := os.Setenv("CYNIC_TEST_SNAPSHOT_KEY", base64.StdEncoding.EncodeToString(make([]byte, 5))); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("CYNIC_TEST_SNAPSHOT_KEY")
+
+	_, err = cynic.ParseConfig(data, ".json")
+	assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+
+	if err := os.Setenv("CYNIC_TEST_SNAPSHOT_KEY", base64.StdEncoding.EncodeToString(make([]byte, 16))); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := cynic.ParseConfig(data, ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := config.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, session.SnapshotConfig.Encrypt)
+
+	key, err := session.SnapshotConfig.Key()
+	assert(t, err == nil && len(key) == 16)
+}
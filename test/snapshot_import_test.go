@@ -0,0 +1,94 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestImportSnapshotsCSV(t *testing.T) {
+	export := strings.Join([]string{
+		"timestamp,key,value",
+		"20,db,down",
+		"10,api,200",
+		"10,db,up",
+		`20,queue,"{""depth"":3}"`,
+	}, "\n")
+
+	store, err := cynic.ImportSnapshots(strings.NewReader(export), cynic.ImportFormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, store.Validate() == nil)
+
+	if len(store.Snapshots) != 2 {
+		t.Fatal("expected two snapshots, got:", len(store.Snapshots))
+	}
+	assert(t, store.Snapshots[0].Timestamp == 10)
+	assert(t, store.Snapshots[0].Data == `{"api":200,"db":"up"}`)
+
+	// keys carry over to later snapshots
+	assert(t, store.Snapshots[1].Timestamp == 20)
+	assert(t, store.Snapshots[1].Data == `{"api":200,"db":"down","queue":{"depth":3}}`)
+}
+
+func TestImportSnapshotsJSONL(t *testing.T) {
+	export := strings.Join([]string{
+		`{"key": "api", "timestamp": "1970-01-01T00:01:00Z", "value": {"up": true}}`,
+		``,
+		`{"key": "db", "timestamp": 60, "value": 0.5}`,
+	}, "\n")
+
+	store, err := cynic.ImportSnapshots(strings.NewReader(export), cynic.ImportFormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.Snapshots) != 1 {
+		t.Fatal("expected one snapshot, got:", len(store.Snapshots))
+	}
+	assert(t, store.Snapshots[0].Timestamp == 60)
+
+	var status map[string]interface{}
+	assert(t, json.Unmarshal([]byte(store.Snapshots[0].Data), &status) == nil)
+	assert(t, status["db"] == 0.5)
+}
+
+func TestImportSnapshotsBadRows(t *testing.T) {
+	for _, tc := range []struct {
+		format cynic.ImportFormat
+		export string
+	}{
+		{cynic.ImportFormatCSV, "api,yesterday,200"},
+		{cynic.ImportFormatCSV, "api,10"},
+		{cynic.ImportFormatCSV, ",10,200"},
+		{cynic.ImportFormatJSONL, `{"key": "api", "timestamp": 10`},
+		{cynic.ImportFormatJSONL, `{"timestamp": 10, "value": 1}`},
+	} {
+		_, err := cynic.ImportSnapshots(strings.NewReader(tc.export), tc.format)
+		if !errors.Is(err, cynic.ErrSnapshotBadImportRow) {
+			t.Error("expected a bad row for", tc.export, "got:", err)
+		}
+	}
+
+	_, err := cynic.ImportSnapshots(strings.NewReader(""), cynic.ImportFormat(42))
+	assert(t, errors.Is(err, cynic.ErrSnapshotUnknownImport))
+}
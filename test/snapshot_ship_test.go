@@ -0,0 +1,135 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"crypto/md5" // #nosec
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+type uploadRecorder struct {
+	mux     sync.Mutex
+	uploads map[string][]byte
+	headers http.Header
+}
+
+func (s *uploadRecorder) handler(w http.ResponseWriter, r *http.Request) {
+	data, _ := ioutil.ReadAll(r.Body)
+
+	s.mux.Lock()
+	s.uploads[r.URL.Path] = data
+	s.headers = r.Header.Clone()
+	s.mux.Unlock()
+
+	sum := md5.Sum(data) // #nosec
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+}
+
+func TestSnapshotShippingHTTP(t *testing.T) {
+	recorder := &uploadRecorder{uploads: make(map[string][]byte)}
+	remote := httptest.NewServer(http.HandlerFunc(recorder.handler))
+	defer remote.Close()
+
+	dir := t.TempDir()
+	server := cynic.StatusServerNew("", "0", "/testsnapshotshippinghttp/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Hour,
+		DumpEvery: time.Hour,
+		Path:      dir,
+		Shipping:  &cynic.ShippingConfig{Uploader: cynic.HTTPUploaderNew(remote.URL + "/dumps")},
+	})
+	server.Update("hello", "kitty")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	server.Stop()
+
+	recorder.mux.Lock()
+	defer recorder.mux.Unlock()
+
+	if len(recorder.uploads) != 1 {
+		t.Fatal("expected one upload, got:", len(recorder.uploads))
+	}
+
+	for name, data := range recorder.uploads {
+		assert(t, strings.HasPrefix(name, "/dumps/"))
+
+		sum := sha256.Sum256(data)
+		assert(t, recorder.headers.Get("X-Cynic-Checksum-Sha256") == hex.EncodeToString(sum[:]))
+	}
+}
+
+func TestHTTPUploaderChecksumMismatch(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cynic-Checksum-Sha256", "nope")
+	}))
+	defer remote.Close()
+
+	uploader := cynic.HTTPUploaderNew(remote.URL)
+	err := uploader.Upload(context.Background(), &cynic.SnapshotUpload{Name: "a.cynic", Data: []byte("hi")})
+	if !errors.Is(err, cynic.ErrSnapshotChecksumMismatch) {
+		t.Fatal("expected a checksum mismatch, got:", err)
+	}
+}
+
+func TestS3Uploader(t *testing.T) {
+	recorder := &uploadRecorder{uploads: make(map[string][]byte)}
+	remote := httptest.NewServer(http.HandlerFunc(recorder.handler))
+	defer remote.Close()
+
+	uploader := cynic.S3UploaderNew(cynic.S3UploaderConfig{
+		Endpoint:  remote.URL,
+		Region:    "us-east-1",
+		Bucket:    "cynic",
+		Prefix:    "host/",
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+
+	data := []byte("snapshots")
+	sha := sha256.Sum256(data)
+	md := md5.Sum(data) // #nosec
+	upload := &cynic.SnapshotUpload{
+		Name:   "2021-01-01T00:00:00Z.1.cynic",
+		Data:   data,
+		SHA256: hex.EncodeToString(sha[:]),
+		MD5:    hex.EncodeToString(md[:]),
+	}
+
+	if err := uploader.Upload(context.Background(), upload); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.mux.Lock()
+	defer recorder.mux.Unlock()
+
+	assert(t, string(recorder.uploads["/cynic/host/2021-01-01T00:00:00Z.1.cynic"]) == "snapshots")
+	assert(t, recorder.headers.Get("X-Amz-Content-Sha256") == upload.SHA256)
+	assert(t, strings.HasPrefix(recorder.headers.Get("Authorization"),
+		"AWS4-HMAC-SHA256 Credential=access/"))
+}
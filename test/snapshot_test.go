@@ -0,0 +1,478 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestSnapshotFlushOnStop(t *testing.T) {
+	dir := t.TempDir()
+
+	server := cynic.StatusServerNew("", "0", "/testsnapshotflushonstop/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Hour,
+		DumpEvery: time.Hour,
+		Path:      dir,
+	})
+	server.Update("hello", "kitty")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	server.Stop()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatal("expected one dump, got:", len(files))
+	}
+
+	data, err := ioutil.ReadFile(path.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var store cynic.SnapshotStore
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&store); err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, len(store.Snapshots) == 1)
+	assert(t, strings.Contains(store.Snapshots[0].Data, "kitty"))
+}
+
+func TestSnapshotFailedFlushKeepsSnapshots(t *testing.T) {
+	dir := path.Join(t.TempDir(), "missing")
+
+	server := cynic.StatusServerNew("", "0", "/testsnapshotfailedflushkeepssnapshots/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Hour,
+		DumpEvery: time.Hour,
+		Path:      dir,
+	})
+	server.Update("hello", "kitty")
+
+	// the dump on stop can not create its file
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	server.Stop()
+
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	flusher, ok := server.SnapshotBackend().(cynic.SnapshotFlusher)
+	assert(t, ok)
+
+	dumpPath, err := flusher.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := cynic.ReadSnapshotStoreFile(dumpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, len(store.Snapshots) == 1)
+	assert(t, strings.Contains(store.Snapshots[0].Data, "kitty"))
+}
+
+func TestSnapshotStreamRoundTrip(t *testing.T) {
+	var buff bytes.Buffer
+
+	writer, err := cynic.SnapshotWriterNew(&buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		snp := cynic.Snapshot{Timestamp: int64(i), Data: `{"hello":"kitty"}`}
+		if err := writer.Write(&snp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := cynic.ReadSnapshotStore(bytes.NewReader(buff.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, len(store.Snapshots) == 3)
+	assert(t, store.Snapshots[2].Timestamp == 2)
+	assert(t, store.Snapshots[2].Data == `{"hello":"kitty"}`)
+}
+
+func TestSnapshotStreamTruncated(t *testing.T) {
+	var buff bytes.Buffer
+
+	writer, err := cynic.SnapshotWriterNew(&buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(&cynic.Snapshot{Timestamp: 1, Data: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buff.Bytes()
+	reader, err := cynic.SnapshotReaderNew(bytes.NewReader(data[:len(data)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reader.Next(); err == nil {
+		t.Fatal("expected an error on a truncated record")
+	}
+}
+
+func TestSnapshotStreamFormatDump(t *testing.T) {
+	dir := t.TempDir()
+
+	server := cynic.StatusServerNew("", "0", "/testsnapshotstreamformatdump/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Hour,
+		DumpEvery: time.Hour,
+		Path:      dir,
+		Format:    cynic.SnapshotFormatStream,
+	})
+	server.Update("hello", "kitty")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	server.Stop()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatal("expected one dump, got:", len(files))
+	}
+
+	store, err := cynic.ReadSnapshotStoreFile(path.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, len(store.Snapshots) == 1)
+	assert(t, strings.Contains(store.Snapshots[0].Data, "kitty"))
+}
+
+func TestSnapshotStreamCompressed(t *testing.T) {
+	var buff bytes.Buffer
+
+	writer, err := cynic.SnapshotWriterNewCompressed(&buff, cynic.SnapshotCompressionGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := strings.Repeat(`{"hello":"kitty"}`, 100)
+	if err := writer.Write(&cynic.Snapshot{Timestamp: 1, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, buff.Len() < len(data))
+
+	store, err := cynic.ReadSnapshotStore(bytes.NewReader(buff.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, len(store.Snapshots) == 1)
+	assert(t, store.Snapshots[0].Data == data)
+}
+
+func TestSnapshotCompressionNoCodec(t *testing.T) {
+	var buff bytes.Buffer
+
+	_, err := cynic.SnapshotWriterNewCompressed(&buff, cynic.SnapshotCompressionZstd)
+	if !errors.Is(err, cynic.ErrSnapshotNoCodec) {
+		t.Fatal("expected no codec error, got:", err)
+	}
+}
+
+func TestSnapshotCompressedGobDump(t *testing.T) {
+	dir := t.TempDir()
+
+	server := cynic.StatusServerNew("", "0", "/testsnapshotcompressedgobdump/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:    time.Hour,
+		DumpEvery:   time.Hour,
+		Path:        dir,
+		Compression: cynic.SnapshotCompressionGzip,
+	})
+	server.Update("hello", "kitty")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	server.Stop()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatal("expected one dump, got:", len(files))
+	}
+
+	store, err := cynic.ReadSnapshotStoreFile(path.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, store.Flags == uint8(cynic.SnapshotCompressionGzip))
+	assert(t, len(store.Snapshots) == 1)
+	assert(t, strings.Contains(store.Snapshots[0].Data, "kitty"))
+}
+
+func TestWriteSnapshotStoreFile(t *testing.T) {
+	dir := t.TempDir()
+	store := cynic.SnapshotStore{
+		Snapshots: []*cynic.Snapshot{{Timestamp: 1, Data: `{"hello":"kitty"}`}},
+	}
+
+	for _, format := range []cynic.SnapshotFormat{cynic.SnapshotFormatGob, cynic.SnapshotFormatStream} {
+		storePath := path.Join(dir, "store.cynic")
+		if err := cynic.WriteSnapshotStoreFile(storePath, &store, format); err != nil {
+			t.Fatal(err)
+		}
+
+		read, err := cynic.ReadSnapshotStoreFile(storePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := read.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, len(read.Snapshots) == 1)
+		assert(t, read.Snapshots[0].Data == `{"hello":"kitty"}`)
+	}
+
+	if err := store.Validate(); !errors.Is(err, cynic.ErrSnapshotBadHeader) {
+		t.Fatal("expected a bad header error, got:", err)
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	window := now.Add(-48*time.Hour).Unix() / 300 * 300
+
+	store := cynic.SnapshotStore{
+		Snapshots: []*cynic.Snapshot{
+			{Timestamp: now.Add(-40 * 24 * time.Hour).Unix(), Data: `{"age":"expired"}`},
+			{Timestamp: window + 10, Data: `{"age":"old"}`},
+			{Timestamp: window + 20, Data: `{"age":"old, last of window"}`},
+			{Timestamp: now.Add(-time.Hour).Unix(), Data: `{"age":"raw"}`},
+			{Timestamp: now.Add(-time.Hour).Unix() + 1, Data: `{"age":"raw"}`},
+		},
+	}
+	if err := cynic.WriteSnapshotStoreFile(path.Join(dir, "old.1.cynic"), &store, cynic.SnapshotFormatGob); err != nil {
+		t.Fatal(err)
+	}
+
+	server := cynic.StatusServerNew("", "0", "/testsnapshotretention/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Hour,
+		DumpEvery: time.Hour,
+		Path:      dir,
+		Retention: &cynic.RetentionConfig{
+			Tiers: []cynic.RetentionTier{
+				{MaxAge: 24 * time.Hour},
+				{Resolution: 5 * time.Minute, MaxAge: 30 * 24 * time.Hour},
+			},
+			CompactEvery: 10 * time.Millisecond,
+		},
+	})
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	var compacted cynic.SnapshotStore
+	ok := eventually(func() bool {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil || len(files) != 1 || files[0].Name() == "old.1.cynic" {
+			return false
+		}
+
+		compacted, err = cynic.ReadSnapshotStoreFile(path.Join(dir, files[0].Name()))
+		return err == nil
+	})
+	if !ok {
+		t.Fatal("store files were not compacted")
+	}
+
+	assert(t, len(compacted.Snapshots) == 3)
+	assert(t, compacted.Snapshots[0].Data == `{"age":"old, last of window"}`)
+	assert(t, compacted.Snapshots[1].Data == `{"age":"raw"}`)
+}
+
+func TestReadGzippedSnapshotStore(t *testing.T) {
+	var buff bytes.Buffer
+	gz := gzip.NewWriter(&buff)
+
+	writer, err := cynic.SnapshotWriterNew(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(&cynic.Snapshot{Timestamp: 1, Data: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := cynic.ReadSnapshotStore(&buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, len(store.Snapshots) == 1)
+}
+
+// memoryBackend keeps snapshots in memory, as a custom backend.
+type memoryBackend struct {
+	mux       sync.Mutex
+	snapshots []*cynic.Snapshot
+}
+
+func (s *memoryBackend) Append(snp *cynic.Snapshot) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.snapshots = append(s.snapshots, snp)
+	return nil
+}
+
+func (s *memoryBackend) Iterate(fn func(snp *cynic.Snapshot) error) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, snp := range s.snapshots {
+		if err := fn(snp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryBackend) Prune(retention *cynic.RetentionConfig, now time.Time) error {
+	return nil
+}
+
+func TestCustomSnapshotBackend(t *testing.T) {
+	backend := &memoryBackend{}
+	cynic.RegisterSnapshotBackend("memory-test", func(config *cynic.SnapshotConfig) (cynic.SnapshotBackend, error) {
+		return backend, nil
+	})
+
+	server := cynic.StatusServerNew("", "0", "/testcustomsnapshotbackend/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Hour,
+		DumpEvery: time.Hour,
+		Backend:   "memory-test",
+	})
+	server.Update("hello", "kitty")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	server.Stop()
+
+	assert(t, server.SnapshotBackend() == backend)
+
+	count := 0
+	err := server.SnapshotBackend().Iterate(func(snp *cynic.Snapshot) error {
+		count++
+		assert(t, strings.Contains(snp.Data, "kitty"))
+		return nil
+	})
+	assert(t, err == nil)
+	assert(t, count == 1)
+}
+
+func TestFileSnapshotBackendIterate(t *testing.T) {
+	dir := t.TempDir()
+
+	server := cynic.StatusServerNew("", "0", "/testfilesnapshotbackenditerate/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Hour,
+		DumpEvery: time.Hour,
+		Path:      dir,
+	})
+	server.Update("hello", "kitty")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	server.Stop()
+
+	var snapshots []*cynic.Snapshot
+	err := server.SnapshotBackend().Iterate(func(snp *cynic.Snapshot) error {
+		snapshots = append(snapshots, snp)
+		return nil
+	})
+	assert(t, err == nil)
+	assert(t, len(snapshots) == 1 && strings.Contains(snapshots[0].Data, "kitty"))
+
+	stop := errors.New("stop")
+	err = server.SnapshotBackend().Iterate(func(snp *cynic.Snapshot) error {
+		return stop
+	})
+	assert(t, errors.Is(err, stop))
+}
+
+func TestUnknownSnapshotBackend(t *testing.T) {
+	dir := t.TempDir()
+
+	// an unknown backend falls back to store files
+	server := cynic.StatusServerNew("", "0", "/testunknownsnapshotbackend/")
+	server.WithSnapshots(&cynic.SnapshotConfig{
+		Interval:  time.Hour,
+		DumpEvery: time.Hour,
+		Path:      dir,
+		Backend:   "nope",
+	})
+	server.Update("hello", "kitty")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	server.Stop()
+
+	files, err := ioutil.ReadDir(dir)
+	assert(t, err == nil && len(files) == 1)
+
+	data := []byte(`{
+		"status": {"port": "0"},
+		"snapshots": {"interval": "1m", "dump_every": "1h", "path": "/tmp", "backend": "nope"},
+		"events": [{"url": "http://127.0.0.1:1/", "interval": "5s"}]
+	}`)
+
+	_, err = cynic.ParseConfig(data, ".json")
+	assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+}
@@ -0,0 +1,294 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"  // #nosec: snmp v3 auth protocol
+	"crypto/sha1" // #nosec: snmp v3 auth protocol
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io/ioutil"
+	"net"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+type berNode struct {
+	tag     byte
+	content []byte
+}
+
+// berChildren splits the content of a constructed BER value into its
+// elements.
+func berChildren(t *testing.T, data []byte) []berNode {
+	var nodes []berNode
+
+	for len(data) > 0 {
+		assert(t, len(data) >= 2)
+		tag, length, header := data[0], int(data[1]), 2
+
+		if length&0x80 != 0 {
+			size := length & 0x7f
+			length = 0
+			for _, b := range data[2 : 2+size] {
+				length = length<<8 | int(b)
+			}
+			header += size
+		}
+
+		assert(t, len(data) >= header+length)
+		nodes = append(nodes, berNode{tag: tag, content: data[header : header+length]})
+		data = data[header+length:]
+	}
+
+	return nodes
+}
+
+func berIntValue(node berNode) int64 {
+	var value int64
+	for _, b := range node.content {
+		value = value<<8 | int64(b)
+	}
+	return value
+}
+
+// localizeKey is the password to key algorithm of RFC3414, A.2.
+func localizeKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	for i := 0; i < 1<<20; i++ {
+		h.Write([]byte{password[i%len(password)]})
+	}
+	key := h.Sum(nil)
+
+	h.Reset()
+	h.Write(key)
+	h.Write(engineID)
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func receiveTrap(t *testing.T, conn net.PacketConn) []byte {
+	buf := make([]byte, 65535)
+	assert(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)) == nil)
+	n, _, err := conn.ReadFrom(buf)
+	assert(t, err == nil)
+	return buf[:n]
+}
+
+// checkTrapPDU checks the varbinds of a trap of a critical alert of
+// the event "api", from "eu-west".
+func checkTrapPDU(t *testing.T, pdu berNode) {
+	assert(t, pdu.tag == 0xa7)
+
+	fields := berChildren(t, pdu.content)
+	assert(t, len(fields) == 4)
+
+	varbinds := berChildren(t, fields[3].content)
+	assert(t, len(varbinds) == 9)
+
+	trapOID := berChildren(t, varbinds[1].content)[1]
+	assert(t, bytes.Equal(trapOID.content, []byte{0x2b, 6, 1, 4, 1, 0x81, 0xfd, 0x59, 1, 0, 1}))
+
+	label := berChildren(t, varbinds[2].content)[1]
+	assert(t, label.tag == 0x04 && string(label.content) == "api")
+
+	state := berChildren(t, varbinds[3].content)[1]
+	assert(t, state.tag == 0x02 && berIntValue(state) == 3)
+
+	message := berChildren(t, varbinds[4].content)[1]
+	assert(t, string(message.content) == "timeout")
+
+	eventID := berChildren(t, varbinds[6].content)[1]
+	assert(t, eventID.tag == 0x46 && berIntValue(eventID) == 9)
+
+	location := berChildren(t, varbinds[8].content)[1]
+	assert(t, string(location.content) == "eu-west")
+}
+
+var testTrap = cynic.AlertMessage{
+	EventID:  9,
+	Label:    "api",
+	Severity: cynic.SeverityCritical,
+	Response: "timeout",
+	Location: "eu-west",
+}
+
+func TestLocalizeKeyVectors(t *testing.T) {
+	// RFC3414, A.3.1 and A.3.2
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+
+	md5Key := localizeKey(md5.New, "maplesyrup", engineID)
+	assert(t, hex.EncodeToString(md5Key) == "526f5eed9fcce26f8964c2930787d82b")
+
+	shaKey := localizeKey(sha1.New, "maplesyrup", engineID)
+	assert(t, hex.EncodeToString(shaKey) == "6695febc9288e36282235fc7151f128497b38f3f")
+}
+
+func TestSNMPv2cTrap(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer conn.Close()
+
+	sink, err := cynic.SNMPTrapSinkNew(cynic.SNMPConfig{
+		Addr:      conn.LocalAddr().String(),
+		Community: "noc",
+	})
+	assert(t, err == nil)
+	defer sink.Close()
+
+	sink.Alert([]cynic.AlertMessage{testTrap})
+
+	message := berChildren(t, receiveTrap(t, conn))
+	assert(t, len(message) == 1 && message[0].tag == 0x30)
+
+	fields := berChildren(t, message[0].content)
+	assert(t, len(fields) == 3)
+	assert(t, berIntValue(fields[0]) == 1)
+	assert(t, string(fields[1].content) == "noc")
+	checkTrapPDU(t, fields[2])
+}
+
+func TestSNMPv3Trap(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer conn.Close()
+
+	engineID := []byte{0x80, 0, 0x7e, 0xd9, 4, 't', 'e', 's', 't'}
+	sink, err := cynic.SNMPTrapSinkNew(cynic.SNMPConfig{
+		Addr:         conn.LocalAddr().String(),
+		Version:      cynic.SNMPv3,
+		User:         "noc",
+		AuthProtocol: cynic.SNMPAuthSHA,
+		AuthPassword: "authpassword",
+		PrivProtocol: cynic.SNMPPrivAES,
+		PrivPassword: "privpassword",
+		EngineID:     engineID,
+	})
+	assert(t, err == nil)
+	defer sink.Close()
+
+	sink.Alert([]cynic.AlertMessage{testTrap})
+	packet := receiveTrap(t, conn)
+
+	fields := berChildren(t, berChildren(t, packet)[0].content)
+	assert(t, len(fields) == 4)
+	assert(t, berIntValue(fields[0]) == 3)
+
+	global := berChildren(t, fields[1].content)
+	assert(t, bytes.Equal(global[2].content, []byte{0x03}))
+
+	security := berChildren(t, berChildren(t, fields[2].content)[0].content)
+	assert(t, len(security) == 6)
+	assert(t, bytes.Equal(security[0].content, engineID))
+	assert(t, string(security[3].content) == "noc")
+
+	// the message is authenticated with its auth params zeroed
+	mac := security[4].content
+	assert(t, len(mac) == 12)
+	zeroed := bytes.Replace(packet, mac, make([]byte, 12), 1)
+
+	authKey := localizeKey(sha1.New, "authpassword", engineID)
+	expected := hmac.New(sha1.New, authKey)
+	expected.Write(zeroed)
+	assert(t, bytes.Equal(mac, expected.Sum(nil)[:12]))
+
+	// the scoped pdu is encrypted with aes, with an iv made of the
+	// engine boots and time, and the salt
+	uint32Field := func(node berNode) []byte {
+		return append(make([]byte, 4-len(node.content)), node.content...)
+	}
+	iv := append(uint32Field(security[1]), uint32Field(security[2])...)
+	iv = append(iv, security[5].content...)
+	assert(t, len(iv) == 16)
+
+	block, err := aes.NewCipher(localizeKey(sha1.New, "privpassword", engineID)[:16])
+	assert(t, err == nil)
+
+	encrypted := fields[3].content
+	scoped := make([]byte, len(encrypted))
+	cipher.NewCFBDecrypter(block, iv).XORKeyStream(scoped, encrypted)
+
+	scopedFields := berChildren(t, berChildren(t, scoped)[0].content)
+	assert(t, len(scopedFields) == 3)
+	assert(t, bytes.Equal(scopedFields[0].content, engineID))
+	checkTrapPDU(t, scopedFields[2])
+}
+
+func TestSNMPConfigInvalid(t *testing.T) {
+	configs := []cynic.SNMPConfig{
+		{Addr: "localhost", Version: cynic.SNMPv3},
+		{Addr: "localhost", Version: cynic.SNMPv3, User: "u", AuthProtocol: "sha256", AuthPassword: "p"},
+		{Addr: "localhost", Version: cynic.SNMPv3, User: "u", PrivProtocol: cynic.SNMPPrivAES, PrivPassword: "p"},
+		{Addr: "localhost", Version: 7},
+	}
+
+	for _, config := range configs {
+		_, err := cynic.SNMPTrapSinkNew(config)
+		assert(t, errors.Is(err, cynic.ErrSNMPConfig))
+	}
+}
+
+func TestSNMPv3EngineBoots(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer conn.Close()
+
+	bootsFile := path.Join(t.TempDir(), "boots")
+	config := cynic.SNMPConfig{
+		Addr:      conn.LocalAddr().String(),
+		Version:   cynic.SNMPv3,
+		User:      "noc",
+		BootsFile: bootsFile,
+	}
+
+	// every sink created is a boot
+	for boots := int64(1); boots <= 2; boots++ {
+		sink, err := cynic.SNMPTrapSinkNew(config)
+		assert(t, err == nil)
+		assert(t, sink.EngineBoots() == boots)
+
+		sink.Alert([]cynic.AlertMessage{testTrap})
+		packet := receiveTrap(t, conn)
+		sink.Close()
+
+		fields := berChildren(t, berChildren(t, packet)[0].content)
+		security := berChildren(t, berChildren(t, fields[2].content)[0].content)
+		assert(t, berIntValue(security[1]) == boots)
+	}
+
+	data, err := ioutil.ReadFile(bootsFile)
+	assert(t, err == nil && string(data) == "2\n")
+
+	// and without a file, the boots are always 1
+	config.BootsFile = ""
+	sink, err := cynic.SNMPTrapSinkNew(config)
+	assert(t, err == nil && sink.EngineBoots() == 1)
+
+	assert(t, ioutil.WriteFile(bootsFile, []byte("nope"), 0600) == nil)
+	config.BootsFile = bootsFile
+	_, err = cynic.SNMPTrapSinkNew(config)
+	assert(t, errors.Is(err, cynic.ErrSNMPConfig))
+}
@@ -0,0 +1,127 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cynic "github.com/psyomn/cynic/lib"
+)
+
+func TestStatusPage(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	incidents := cynic.IncidentTrackerNew(cynic.IncidentConfig{})
+
+	page, err := cynic.StatusPageNew(cynic.StatusPageConfig{
+		Port:      "0",
+		Title:     "Acme status",
+		Incidents: incidents,
+		Clock:     cynic.ManualClockNew(now),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []cynic.EventResult{
+		{EventID: 1, Label: "api", Group: "web", At: now.Add(-24 * time.Hour)},
+		{EventID: 1, Label: "api", Group: "web", At: now},
+		{EventID: 2, Label: "secret-db", Group: "web", Failed: true, At: now,
+			Hooks: []cynic.HookResult{{Failed: true, Result: "password rejected"}}},
+		{EventID: 3, Label: "cron", At: now},
+	}
+	for _, result := range results {
+		page.Record(result)
+		incidents.Record(result)
+	}
+
+	overview := page.Overview()
+	assert(t, overview.Title == "Acme status")
+	assert(t, overview.Status == cynic.StatusDegraded)
+	assert(t, len(overview.Groups) == 2)
+	assert(t, overview.Groups[0].Name == "default" && overview.Groups[0].Status == cynic.StatusOperational)
+
+	web := overview.Groups[1]
+	assert(t, web.Name == "web" && web.Status == cynic.StatusDegraded)
+	assert(t, len(web.Days) == 90 && web.Days[89].Date == "2021-03-10")
+	assert(t, web.Days[89].Runs == 2 && *web.Days[89].Uptime == 50)
+	assert(t, web.Days[88].Runs == 1 && *web.Days[88].Uptime == 100)
+	assert(t, web.Days[0].Uptime == nil)
+	assert(t, web.Uptime > 66 && web.Uptime < 67)
+
+	assert(t, len(overview.Incidents) == 1 && overview.Incidents[0].Group == "web")
+
+	go page.Start()
+	waitForServer(t, page.GetPort())
+	defer page.Stop()
+
+	base := "http://127.0.0.1:" + strconv.Itoa(page.GetPort())
+
+	resp := adminRequest(t, http.MethodGet, base+"/", "", nil)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, resp.StatusCode == http.StatusOK)
+	assert(t, resp.Header.Get("Cache-Control") == "public, max-age=60")
+	assert(t, strings.Contains(string(body), "Acme status"))
+	assert(t, !strings.Contains(string(body), "secret-db"))
+	assert(t, !strings.Contains(string(body), "password"))
+
+	resp = adminRequest(t, http.MethodGet, base+"/status.json", "", nil)
+	var served cynic.StatusPageOverview
+	if err := json.NewDecoder(resp.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert(t, served.Status == cynic.StatusDegraded && len(served.Groups) == 2)
+
+	resp = adminRequest(t, http.MethodPost, base+"/", "", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusMethodNotAllowed)
+
+	resp = adminRequest(t, http.MethodGet, base+"/admin", "", nil)
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusNotFound)
+}
+
+func TestStatusPageOutage(t *testing.T) {
+	page, err := cynic.StatusPageNew(cynic.StatusPageConfig{Port: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Stop()
+
+	assert(t, page.Overview().Status == cynic.StatusOperational)
+
+	page.Record(cynic.EventResult{EventID: 1, Label: "api", Group: "web", Failed: true})
+	page.Record(cynic.EventResult{EventID: 2, Label: "cron", Group: "jobs", Failed: true})
+
+	overview := page.Overview()
+	assert(t, overview.Status == cynic.StatusOutage)
+	assert(t, overview.Groups[0].Status == cynic.StatusOutage)
+
+	page.Record(cynic.EventResult{EventID: 2, Label: "cron", Group: "jobs"})
+	assert(t, page.Overview().Status == cynic.StatusDegraded)
+}
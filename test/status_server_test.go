@@ -19,6 +19,7 @@ limitations under the License.
 package test
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -152,3 +153,193 @@ func TestRestEndpoint(t *testing.T) {
 
 	server.Stop()
 }
+
+func TestRestEndpointETag(t *testing.T) {
+	endpoint := "/testrestendpointetag"
+	server := cynic.StatusServerNew("", "0", endpoint)
+	server.Update("hello", "kitty")
+
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	defer server.Stop()
+
+	url := "http://127.0.0.1:" + port + endpoint
+	cli := &http.Client{}
+
+	get := func(etag string) *http.Response {
+		req, err := makeBackgroundRequest(url)
+		if err != nil {
+			t.Fatal("could not create request:", err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := cli.Do(req)
+		if err != nil {
+			t.Fatal("could not connect:", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	first := get("")
+	etag := first.Header.Get("ETag")
+	assert(t, first.StatusCode == http.StatusOK)
+	assert(t, etag != "")
+
+	assert(t, get(etag).StatusCode == http.StatusNotModified)
+
+	server.Update("hello", "doggo")
+	assert(t, get(etag).StatusCode == http.StatusOK)
+
+	// a restarted server, at the same generation, has other etags
+	restarted := cynic.StatusServerNew("", "0", endpoint)
+	restarted.Update("hello", "kitty")
+	go func() { restarted.Start() }()
+	defer restarted.Stop()
+
+	url = "http://127.0.0.1:" + strconv.Itoa(restarted.GetPort()) + endpoint
+	waitForServer(t, restarted.GetPort())
+	assert(t, get(etag).StatusCode == http.StatusOK)
+}
+
+func TestRestEndpointETagExtras(t *testing.T) {
+	endpoint := "/testrestendpointetagextras/"
+	alerter := cynic.AlerterNew(60, func(_ []cynic.AlertMessage) error { return nil })
+	server := cynic.StatusServerNew("", "0", endpoint)
+	server.WithAlerter(&alerter)
+	server.Update("hello", "kitty")
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	get := func(etag string) *http.Response {
+		req, err := makeBackgroundRequest("http://127.0.0.1:" + strconv.Itoa(server.GetPort()) + endpoint)
+		if err != nil {
+			t.Fatal("could not create request:", err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("could not connect:", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	etag := get("").Header.Get("ETag")
+	assert(t, get(etag).StatusCode == http.StatusNotModified)
+
+	// muting changes the extras, not the contract results
+	_, err := alerter.Mute(cynic.MuteRule{Group: "db", Until: time.Now().Add(time.Hour)})
+	assert(t, err == nil)
+
+	resp := get(etag)
+	assert(t, resp.StatusCode == http.StatusOK)
+	assert(t, resp.Header.Get("ETag") != etag)
+}
+
+func TestRestEndpointGzip(t *testing.T) {
+	endpoint := "/testrestendpointgzip"
+	server := cynic.StatusServerNew("", "0", endpoint)
+	server.Update("hello", "kitty")
+
+	port := strconv.Itoa(server.GetPort())
+	go func() { server.Start() }()
+	defer server.Stop()
+
+	cli := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	req, err := makeBackgroundRequest("http://127.0.0.1:" + port + endpoint)
+	if err != nil {
+		t.Fatal("could not create request:", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	defer resp.Body.Close()
+
+	assert(t, resp.Header.Get("Content-Encoding") == "gzip")
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal("response is not gzipped:", err)
+	}
+
+	var values map[string]string
+	if err := json.NewDecoder(gz).Decode(&values); err != nil {
+		t.Fatal(err)
+	}
+
+	assert(t, values["hello"] == "kitty")
+}
+
+func TestStatusJSONMatchesMarshal(t *testing.T) {
+	server := cynic.StatusServerNew("", "0", "/teststatusjsonmatches/")
+	control := cynic.ControlServiceNew(nil, &server, nil)
+
+	values := map[string]interface{}{
+		"zebra":     []int{1, 2, 3},
+		"<html>":    "a & b",
+		"été":       map[string]float64{"ratio": 0.5},
+		"Upper":     nil,
+		"__private": true,
+	}
+	for key, value := range values {
+		server.Update(key, value)
+	}
+
+	expected, err := json.Marshal(values)
+	assert(t, err == nil)
+
+	status, err := control.GetStatus("")
+	assert(t, err == nil)
+	assert(t, string(status.JSON) == string(expected))
+
+	// an update is seen right away, and only its key is encoded again
+	server.Update("zebra", "stripes")
+	values["zebra"] = "stripes"
+	expected, _ = json.Marshal(values)
+
+	status, err = control.GetStatus("")
+	assert(t, err == nil)
+	assert(t, string(status.JSON) == string(expected))
+
+	status, err = control.GetStatus("zebra")
+	assert(t, err == nil)
+	assert(t, string(status.JSON) == `"stripes"`)
+
+	status, err = control.GetStatus("nope")
+	assert(t, err == nil)
+	assert(t, string(status.JSON) == "null")
+}
+
+func BenchmarkStatusJSON(b *testing.B) {
+	server := cynic.StatusServerNew("", "0", "/benchstatusjson/")
+	control := cynic.ControlServiceNew(nil, &server, nil)
+
+	for i := 0; i < 1000; i++ {
+		server.Update(fmt.Sprintf("key-%d", i), map[string]interface{}{
+			"status":  200,
+			"latency": i,
+		})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%100 == 0 {
+			server.Update("key-0", i)
+		}
+		if _, err := control.GetStatus(""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
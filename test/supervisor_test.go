@@ -0,0 +1,101 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func tenantSession(root, key string) (cynic.Session, *cynic.StatusCache) {
+	server := cynic.StatusServerNew("", "0", root)
+
+	event := cynic.EventNew(1)
+	event.Immediate(true)
+	event.Repeat(true)
+	event.SetDataRepo(&server)
+	event.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		params.Status.Update(key, "up")
+		return false, nil
+	})
+
+	return cynic.Session{
+		Events:      []cynic.Event{event},
+		StatusCache: &server,
+	}, &server
+}
+
+func TestSupervisorIsolatesSessions(t *testing.T) {
+	supervisor := cynic.SupervisorNew()
+
+	staging, stagingStatus := tenantSession("/teststaging/", "staging")
+	production, productionStatus := tenantSession("/testproduction/", "production")
+
+	assert(t, supervisor.Add("staging", staging) == nil)
+	assert(t, supervisor.Add("production", production) == nil)
+
+	names := supervisor.Names()
+	assert(t, len(names) == 2 && names[0] == "production" && names[1] == "staging")
+
+	hasKey := func(status *cynic.StatusCache, key string) bool {
+		_, err := status.Get(key)
+		return err == nil
+	}
+
+	assert(t, eventuallyWithin(5*time.Second, func() bool {
+		return hasKey(stagingStatus, "staging") && hasKey(productionStatus, "production")
+	}))
+	assert(t, !hasKey(stagingStatus, "production"))
+	assert(t, !hasKey(productionStatus, "staging"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert(t, supervisor.Stop(ctx, "staging") == nil)
+	assert(t, errors.Is(supervisor.Stop(ctx, "staging"), cynic.ErrSessionNotFound))
+
+	runner, ok := supervisor.Runner("production")
+	assert(t, ok)
+	select {
+	case <-runner.Done():
+		t.Fatal("stopping one session stopped another")
+	default:
+	}
+
+	assert(t, supervisor.StopAll(ctx) == nil)
+	assert(t, len(supervisor.Names()) == 0)
+}
+
+func TestSupervisorRejectsSharing(t *testing.T) {
+	supervisor := cynic.SupervisorNew()
+
+	first, status := tenantSession("/testsharedfirst/", "first")
+	assert(t, supervisor.Add("first", first) == nil)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert(t, supervisor.StopAll(ctx) == nil)
+	}()
+
+	assert(t, errors.Is(supervisor.Add("first", cynic.Session{}), cynic.ErrSessionExists))
+
+	shared := cynic.Session{StatusCache: status}
+	assert(t, errors.Is(supervisor.Add("second", shared), cynic.ErrSessionInvalid))
+}
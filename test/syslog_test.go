@@ -0,0 +1,169 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+var syslogHeader = regexp.MustCompile(
+	`^<(\d+)>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}\S+ \S+ (\S+) \d+ (\S+) `)
+
+func readDatagram(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, 4096)
+	assert(t, conn.SetReadDeadline(time.Now().Add(3*time.Second)) == nil)
+	n, _, err := conn.ReadFrom(buf)
+	assert(t, err == nil)
+	return string(buf[:n])
+}
+
+func TestSyslogSinkAlert(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer conn.Close()
+
+	sink := cynic.SyslogSinkNew("udp", conn.LocalAddr().String())
+	defer sink.Close()
+	sink.SetFacility(cynic.SyslogFacilityLocal0)
+
+	sink.Alert([]cynic.AlertMessage{{
+		EventID:  4,
+		Label:    `db "main"`,
+		Severity: cynic.SeverityCritical,
+		Response: "down",
+	}})
+
+	msg := readDatagram(t, conn)
+	match := syslogHeader.FindStringSubmatch(msg)
+	assert(t, match != nil)
+
+	// local0 critical
+	assert(t, match[1] == "130")
+	assert(t, match[2] == "cynic")
+	assert(t, match[3] == "alert")
+	assert(t, strings.HasSuffix(msg,
+		` [cynic@32473 event_id="4" label="db \"main\"" severity="critical"] down`))
+}
+
+func TestSyslogSinkLogsOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert(t, err == nil)
+	defer listener.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// frames are the length of the message, a space, and the
+		// message
+		reader := bufio.NewReader(conn)
+		length, _ := reader.ReadString(' ')
+		size, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			return
+		}
+
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(reader, msg); err == nil {
+			lines <- string(msg)
+		}
+	}()
+
+	sink := cynic.SyslogSinkNew("tcp", listener.Addr().String())
+	defer sink.Close()
+	sink.SetAppName("watcher")
+
+	logger := log.New(sink, "", 0)
+	logger.Println("hello")
+
+	select {
+	case line := <-lines:
+		match := syslogHeader.FindStringSubmatch(line)
+		assert(t, match != nil)
+		assert(t, match[1] == "30") // daemon info
+		assert(t, match[2] == "watcher")
+		assert(t, match[3] == "log")
+		assert(t, strings.HasSuffix(line, " - hello"))
+	case <-time.After(3 * time.Second):
+		t.Fatal("syslog server received nothing")
+	}
+}
+
+func TestParseSyslogFacility(t *testing.T) {
+	facility, err := cynic.ParseSyslogFacility("local7")
+	assert(t, err == nil && facility == cynic.SyslogFacilityLocal7)
+
+	facility, err = cynic.ParseSyslogFacility("")
+	assert(t, err == nil && facility == cynic.SyslogFacilityDaemon)
+
+	_, err = cynic.ParseSyslogFacility("kern")
+	assert(t, errors.Is(err, cynic.ErrUnknownSyslogFacility))
+}
+
+func TestJournaldSink(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+	conn, err := net.ListenPacket("unixgram", socket)
+	assert(t, err == nil)
+	defer conn.Close()
+
+	sink := cynic.JournaldSinkNew()
+	defer sink.Close()
+	sink.SetSocket(socket)
+
+	sink.Alert([]cynic.AlertMessage{{
+		EventID:  2,
+		Label:    "api",
+		Severity: cynic.SeverityWarning,
+		Response: "line one\nline two",
+	}})
+
+	msg := readDatagram(t, conn)
+
+	value := "line one\nline two"
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+
+	assert(t, strings.HasPrefix(msg, "MESSAGE\n"+string(size[:])+value+"\n"))
+	assert(t, strings.Contains(msg, "\nPRIORITY=4\n"))
+	assert(t, strings.Contains(msg, "\nCYNIC_EVENT_ID=2\n"))
+	assert(t, strings.Contains(msg, "\nCYNIC_LABEL=api\n"))
+	assert(t, strings.Contains(msg, "\nCYNIC_SEVERITY=warning\n"))
+	assert(t, !strings.Contains(msg, "CYNIC_GROUP"))
+	assert(t, strings.HasSuffix(msg, "\nSYSLOG_IDENTIFIER=cynic\n"))
+
+	_, err = sink.Write([]byte("a log line\n"))
+	assert(t, err == nil)
+	assert(t, readDatagram(t, conn) == "MESSAGE=a log line\nPRIORITY=6\nSYSLOG_IDENTIFIER=cynic\n")
+}
@@ -0,0 +1,223 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestEventTags(t *testing.T) {
+	event := cynic.EventNew(1)
+	event.SetTag("team", "payments")
+
+	// copies of the event don't share later tags
+	other := event
+	other.SetTag("env", "prod")
+	assert(t, event.Tag("env") == "" && other.Tag("env") == "prod")
+	assert(t, other.Tag("team") == "payments")
+
+	tags := other.Tags()
+	tags["team"] = "changed"
+	assert(t, other.Tag("team") == "payments")
+
+	other.SetTag("team", "")
+	other.SetTag("env", "")
+	assert(t, other.Tags() == nil)
+}
+
+func TestTagsCarried(t *testing.T) {
+	delivered := make(chan []cynic.AlertMessage, 1)
+	alerter := cynic.AlerterNew(1, func(alerts []cynic.AlertMessage) error {
+		delivered <- alerts
+		return nil
+	})
+	alerter.WithHistory(&cynic.AlertHistoryConfig{})
+	alerter.Start()
+	defer alerter.Stop()
+
+	recorder := &resultRecorder{}
+	planner := cynic.PlannerNew()
+	planner.SetAlerter(&alerter)
+	planner.AddSink(recorder)
+
+	var hookTags map[string]string
+	event := cynic.EventNew(1)
+	event.Label = "api"
+	event.SetTag("team", "payments")
+	event.AddHook(func(params *cynic.HookParameters) (bool, interface{}) {
+		hookTags = params.Tags
+		return true, "down"
+	})
+	planner.Add(&event)
+	planner.Advance(2 * time.Second)
+
+	assert(t, hookTags["team"] == "payments")
+	assert(t, len(recorder.results) == 1 && recorder.results[0].Tags["team"] == "payments")
+	assert(t, planner.State().Events[0].Tags["team"] == "payments")
+
+	select {
+	case alerts := <-delivered:
+		assert(t, len(alerts) == 1 && alerts[0].Tags["team"] == "payments")
+	case <-time.After(3 * time.Second):
+		t.Fatal("alert was never delivered")
+	}
+
+	records := alerter.History(cynic.AlertHistoryFilter{Tags: map[string]string{"team": "payments"}})
+	assert(t, len(records) == 1)
+	records = alerter.History(cynic.AlertHistoryFilter{Tags: map[string]string{"team": "search"}})
+	assert(t, len(records) == 0)
+}
+
+func TestMuteRuleTags(t *testing.T) {
+	rule := cynic.MuteRule{Tags: map[string]string{"env": "staging"}, Until: time.Now().Add(time.Hour)}
+	staging := cynic.AlertMessage{Tags: map[string]string{"env": "staging", "team": "a"}}
+	prod := cynic.AlertMessage{Tags: map[string]string{"env": "prod"}}
+
+	assert(t, rule.Matches(&staging, time.Now()))
+	assert(t, !rule.Matches(&prod, time.Now()))
+	assert(t, !rule.Matches(&cynic.AlertMessage{}, time.Now()))
+
+	alerter := cynic.AlerterNew(60, func([]cynic.AlertMessage) error { return nil })
+	_, err := alerter.Mute(rule)
+	assert(t, err == nil)
+}
+
+func TestRepoRouteTags(t *testing.T) {
+	public := cynic.StatusServerNew("", "0", "/public/")
+	internal := cynic.StatusServerNew("", "0", "/internal/")
+
+	planner := cynic.PlannerNew()
+	planner.SetRepoRoutes(
+		cynic.RepoRoute{Tags: map[string]string{"visibility": "public"}, Repo: &public},
+		cynic.RepoRoute{Repo: &internal},
+	)
+
+	shown := routedEvent("api", "")
+	shown.SetTag("visibility", "public")
+	hidden := routedEvent("db", "")
+
+	for _, event := range []*cynic.Event{&shown, &hidden} {
+		planner.Add(event)
+		_, err := planner.RunNow(event.ID())
+		assert(t, err == nil)
+	}
+
+	_, err := public.Get("api")
+	assert(t, err == nil)
+	_, err = internal.Get("db")
+	assert(t, err == nil)
+}
+
+func TestTagsInSinksAndMetrics(t *testing.T) {
+	result := cynic.EventResult{
+		EventID: 1,
+		Label:   "api",
+		At:      time.Unix(2, 0),
+		Tags:    map[string]string{"team": "pay ments", "label": "ignored", "env": "prod"},
+	}
+
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := cynic.InfluxSinkNew(server.URL + "/write?db=cynic")
+	sink.Record(result)
+	sink.Close(context.Background())
+
+	select {
+	case body := <-bodies:
+		assert(t, strings.HasPrefix(body, `cynic,env=prod,label=api,severity=info,team=pay\ ments event_id=1i`))
+	case <-time.After(3 * time.Second):
+		t.Fatal("influxdb received nothing")
+	}
+
+	capture := &busCapture{}
+	publisher := cynic.BusPublisherNew(cynic.BusTransportFunc(capture.publish), cynic.BusConfig{
+		Format:       cynic.BusFormatProtobuf,
+		ResultsTopic: "results",
+	})
+	publisher.Record(cynic.EventResult{At: time.Unix(0, 1), Tags: map[string]string{"b": "2", "a": "1"}})
+	publisher.Close(context.Background())
+
+	expected := []byte{
+		0x22, 0x04, 'i', 'n', 'f', 'o', // severity
+		0x38, 0x01, // at_unix_nano
+		0x52, 0x06, 0x0a, 0x01, 'a', 0x12, 0x01, '1', // tags, by key
+		0x52, 0x06, 0x0a, 0x01, 'b', 0x12, 0x01, '2',
+	}
+	assert(t, len(capture.payloads) == 1 && bytes.Equal(capture.payloads[0], expected))
+
+	lines := string(cynic.DogStatsdFormat("cynic.api", cynic.EventSample{
+		Tags: map[string]string{"team": "payments", "env": "prod"},
+	}))
+	assert(t, lines == "cynic.api.success:1|c|#env:prod,team:payments\n"+
+		"cynic.api.latency:0.000|ms|#env:prod,team:payments\n")
+	assert(t, string(cynic.DogStatsdFormat("cynic.api", cynic.EventSample{})) ==
+		string(cynic.StatsdFormat("cynic.api", cynic.EventSample{})))
+}
+
+func TestTagsQuery(t *testing.T) {
+	server := cynic.StatusServerNew("", "0", "/testtags/")
+	alerter := cynic.AlerterNew(60, func([]cynic.AlertMessage) error { return nil })
+	alerter.WithHistory(&cynic.AlertHistoryConfig{})
+	server.WithAlerter(&alerter)
+
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	get := func(query url.Values) int {
+		req, err := makeBackgroundRequest("http://127.0.0.1:" + strconv.Itoa(server.GetPort()) + "/alerts?" + query.Encode())
+		assert(t, err == nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("could not connect:", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert(t, get(url.Values{"tag": {"team:payments", "env:prod"}}) == http.StatusOK)
+	assert(t, get(url.Values{"tag": {"team"}}) == http.StatusBadRequest)
+}
+
+func TestConfigTags(t *testing.T) {
+	config, err := cynic.ParseConfig([]byte(`{"events": [{"label": "api", "interval": "1s",
+		"hooks": ["tagged"], "tags": {"team": "payments"}}]}`), ".json")
+	assert(t, err == nil)
+
+	cynic.RegisterHook("tagged", func(*cynic.HookParameters) (bool, interface{}) { return false, nil })
+	session, err := config.Session()
+	assert(t, err == nil)
+	assert(t, session.Events[0].Tag("team") == "payments")
+}
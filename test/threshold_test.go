@@ -0,0 +1,127 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestThresholdRules(t *testing.T) {
+	above, below := 100.0, 1.0
+	rules, err := cynic.ThresholdRulesNew([]cynic.ThresholdConfig{
+		{Path: "$.queues[1].depth", Above: &above},
+		{Path: `workers["in use"]`, Below: &below},
+	})
+	assert(t, err == nil)
+
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	body := `{"queues": [{"depth": 500}, {"depth": %d}], "workers": {"in use": "%d"}}`
+
+	values, failures := rules.Check([]byte(fmt.Sprintf(body, 20, 4)), now)
+	assert(t, len(failures) == 0)
+	assert(t, values["$.queues[1].depth"] == 20 && values[`workers["in use"]`] == 4)
+
+	_, failures = rules.Check([]byte(fmt.Sprintf(body, 120, 0)), now)
+	assert(t, len(failures) == 2)
+	assert(t, strings.Contains(failures[0], "above 100"))
+
+	_, failures = rules.Check([]byte(`{"queues": []}`), now)
+	assert(t, len(failures) == 2 && strings.Contains(failures[0], "no index 1"))
+
+	_, failures = rules.Check([]byte(`<html>`), now)
+	assert(t, len(failures) == 1 && strings.Contains(failures[0], "not json"))
+
+	for _, path := range []string{"", "$", "$.a..b", "$.a[", "$.a[-1]", "$.a[x]", "$.a[0]b"} {
+		_, err := cynic.ThresholdRulesNew([]cynic.ThresholdConfig{{Path: path}})
+		assert(t, errors.Is(err, cynic.ErrThresholdPath))
+	}
+}
+
+func TestThresholdRulesOverTime(t *testing.T) {
+	maxDelta, maxRate := 50.0, 2.0
+	deltas, err := cynic.ThresholdRulesNew([]cynic.ThresholdConfig{
+		{Path: "depth", MaxDelta: &maxDelta, Window: cynic.ConfigDuration(5 * time.Minute)},
+	})
+	assert(t, err == nil)
+	rates, err := cynic.ThresholdRulesNew([]cynic.ThresholdConfig{{Path: "depth", MaxRate: &maxRate}})
+	assert(t, err == nil)
+
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	check := func(rules *cynic.ThresholdRules, after time.Duration, depth int) bool {
+		_, failures := rules.Check([]byte(fmt.Sprintf(`{"depth": %d}`, depth)), start.Add(after))
+		return len(failures) == 0
+	}
+
+	// 30 a minute stays under the rate, but not under the delta of
+	// the window once it adds up
+	assert(t, check(deltas, 0, 0))
+	assert(t, check(deltas, time.Minute, 30))
+	assert(t, !check(deltas, 2*time.Minute, 60))
+
+	// the first values fell out of the window
+	assert(t, check(deltas, 8*time.Minute, 90))
+
+	assert(t, check(rates, 0, 0))
+	assert(t, check(rates, time.Minute, 30))
+	assert(t, !check(rates, time.Minute+10*time.Second, 60))
+	assert(t, check(rates, 2*time.Minute, 0))
+}
+
+func TestConfigThresholds(t *testing.T) {
+	depth := 10
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"queue": {"depth": %d}}`, depth)
+	}))
+	defer remote.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0"},
+		"events": [{"label": "queue", "url": "%s", "interval": "1s",
+			"thresholds": [{"path": "$.queue.depth", "above": 100}]}]}`, remote.URL)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	result := session.Events[0].Execute()
+	assert(t, !result.Failed())
+	value, err := session.StatusCache.Get("queue")
+	assert(t, err == nil)
+	assert(t, value.(cynic.ProbeResult).Values["$.queue.depth"] == 10)
+
+	depth = 500
+	result = session.Events[0].Execute()
+	assert(t, result.Failed())
+
+	for _, invalid := range []string{
+		`{"events": [{"interval": "1s", "hooks": ["x"], "thresholds": [{"path": "a"}]}]}`,
+		`{"events": [{"interval": "1s", "url": "http://x", "thresholds": [{"path": "a["}]}]}`,
+		`{"events": [{"interval": "1s", "url": "http://x", "thresholds": [{"path": "a", "max_delta": 1}]}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(invalid), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func transactionServer(t *testing.T, logouts *int64) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("user") != "kitty" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+		_, _ = w.Write([]byte(`{"auth": {"token": "t1"}}`))
+	})
+	mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil || cookie.Value != "s1" || r.Header.Get("Authorization") != "Bearer t1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`<p>balance: 42</p>`))
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(logouts, 1)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func transactionEvent(t *testing.T, user string) (cynic.TransactionResult, bool) {
+	var logouts int64
+	server := transactionServer(t, &logouts)
+
+	config, err := cynic.ParseConfig([]byte(`{
+	  "status": {"port": "0"},
+	  "events": [{
+	    "label": "login-flow", "interval": "30s",
+	    "transaction": {
+	      "variables": {"base": "`+server.URL+`", "user": "`+user+`"},
+	      "steps": [
+	        {"name": "login", "method": "POST", "url": "{base}/login", "body": "user={user}",
+	         "headers": {"Content-Type": "application/x-www-form-urlencoded"},
+	         "contracts": [{"status": 200}],
+	         "extract": {"token": {"json": "$.auth.token"}}},
+	        {"name": "account", "url": "{base}/account", "headers": {"Authorization": "Bearer {token}"},
+	         "contracts": [{"status": 200}],
+	         "extract": {"balance": {"regex": "balance: (\\d+)"}}},
+	        {"name": "logout", "method": "POST", "url": "{base}/logout", "always": true}
+	      ]
+	    }
+	  }]
+	}`), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+
+	event := session.Events[0]
+	failed := executeFailed(&event)
+
+	value, err := session.StatusCache.Get("login-flow")
+	assert(t, err == nil)
+	assert(t, atomic.LoadInt64(&logouts) == 1)
+
+	return value.(cynic.TransactionResult), failed
+}
+
+func TestTransaction(t *testing.T) {
+	result, failed := transactionEvent(t, "kitty")
+	assert(t, !failed)
+	assert(t, result.FailedStep == "" && len(result.Steps) == 3)
+
+	for _, step := range result.Steps {
+		assert(t, step.Status == http.StatusOK && !step.Skipped && len(step.Failures) == 0)
+	}
+}
+
+func TestTransactionSkipsAfterFailure(t *testing.T) {
+	result, failed := transactionEvent(t, "nobody")
+	assert(t, failed)
+	assert(t, result.FailedStep == "login")
+
+	assert(t, result.Steps[0].Status == http.StatusUnauthorized && len(result.Steps[0].Failures) > 0)
+	assert(t, result.Steps[1].Skipped)
+
+	// logout runs anyway
+	assert(t, !result.Steps[2].Skipped && result.Steps[2].Status == http.StatusOK)
+}
+
+func TestTransactionConfig(t *testing.T) {
+	for _, bad := range []string{
+		`{"events": [{"interval": "30s", "transaction": {"steps": [{"url": "http://a"}]}}]}`,
+		`{"events": [{"label": "t", "interval": "30s", "transaction": {"steps": []}}]}`,
+		`{"events": [{"label": "t", "interval": "30s", "transaction": {"steps": [{"name": "x"}]}}]}`,
+		`{"events": [{"label": "t", "interval": "30s", "url": "http://a",
+		  "transaction": {"steps": [{"url": "http://a"}]}}]}`,
+		`{"events": [{"label": "t", "interval": "30s",
+		  "transaction": {"steps": [{"url": "http://a", "extract": {"v": {}}}]}}]}`,
+		`{"events": [{"label": "t", "interval": "30s",
+		  "transaction": {"steps": [{"url": "http://a", "extract": {"v": {"json": "$.a", "header": "X"}}}]}}]}`,
+		`{"events": [{"label": "t", "interval": "30s",
+		  "transaction": {"steps": [{"url": "http://a", "extract": {"v": {"regex": "("}}}]}}]}`,
+	} {
+		_, err := cynic.ParseConfig([]byte(bad), ".json")
+		assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Package cynic_testing tests that it can monitor you from the ceiling.
+
+Copyright 2018 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+func TestTransportPoolClients(t *testing.T) {
+	pool := cynic.TransportPoolNew(cynic.TransportLimits{})
+
+	a := pool.Client("http://a.example.com/health")
+	assert(t, a == pool.Client("http://a.example.com/other"))
+	assert(t, a != pool.Client("https://a.example.com/health"))
+	assert(t, a != pool.Client("http://b.example.com/health"))
+}
+
+func TestTransportPoolReusesConnections(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer remote.Close()
+
+	data := fmt.Sprintf(`{"status": {"port": "0"}, "transport": {"max_idle_per_host": 2, "idle_timeout": "1m"},
+		"events": [{"label": "api", "url": "%s", "interval": "1s"}]}`, remote.URL)
+	config, err := cynic.ParseConfig([]byte(data), ".json")
+	assert(t, err == nil)
+
+	session, err := config.Session()
+	assert(t, err == nil)
+	assert(t, session.Transports.MaxIdlePerHost == 2 && session.Transports.IdleTimeout == time.Minute)
+
+	pool := cynic.TransportPoolNew(*session.Transports)
+	planner := cynic.PlannerNew()
+	planner.SetTransportPool(pool)
+	planner.Add(&session.Events[0])
+
+	probe := func() cynic.ProbeResult {
+		result := session.Events[0].Execute()
+		assert(t, !result.Failed())
+
+		value, err := session.StatusCache.Get("api")
+		assert(t, err == nil)
+		return value.(cynic.ProbeResult)
+	}
+
+	first := probe()
+	assert(t, !first.Reused)
+	assert(t, first.Connections != nil && first.Connections.New == 1 && first.Connections.Reused == 0)
+
+	second := probe()
+	assert(t, second.Reused)
+	assert(t, second.Connections.New == 1 && second.Connections.Reused == 1)
+
+	assert(t, pool.Stats() == cynic.ConnectionStats{New: 1, Reused: 1})
+
+	// without idle connections, probes connect again
+	pool.CloseIdle()
+	third := probe()
+	assert(t, !third.Reused && third.Connections.New == 2)
+
+	_, err = cynic.ParseConfig([]byte(`{"transport": {"max_idle_per_host": -1}}`), ".json")
+	assert(t, errors.Is(err, cynic.ErrConfigInvalid))
+}
@@ -18,7 +18,9 @@ package test
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"testing"
+	"time"
 )
 
 // Assert is a simple helper to see if something is true, and if not
@@ -34,3 +36,25 @@ func makeBackgroundRequest(url string) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	return req, err
 }
+
+// waitForServer blocks until the status server on the port answers
+// requests.
+func waitForServer(t *testing.T, port int) {
+	url := "http://127.0.0.1:" + strconv.Itoa(port) + "/links"
+	deadline := time.Now().Add(2 * time.Second)
+
+	for time.Now().Before(deadline) {
+		req, err := makeBackgroundRequest(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatal("status server never came up on port", port)
+}
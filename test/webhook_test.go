@@ -0,0 +1,182 @@
+/*
+Copyright 2018-2021 Simon Symeonidis (psyomn)
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/psyomn/cynic/lib"
+)
+
+// signedRequest is a post of the body to the url, signed at the time
+// if there is a signature.
+func signedRequest(t *testing.T, signature *cynic.WebhookSignature, url string, body []byte,
+	at time.Time) *http.Request {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature != nil {
+		signature.Sign(req, body, at)
+	}
+	return req
+}
+
+func TestWebhookSignature(t *testing.T) {
+	signature := &cynic.WebhookSignature{Secret: "s3cret"}
+	other := &cynic.WebhookSignature{Secret: "nope"}
+	now := time.Now()
+	body := []byte(`{"ok": true}`)
+
+	req := signedRequest(t, signature, "http://localhost/", body, now)
+	assert(t, signature.Verify(req, now) == nil)
+
+	// the body can be read again once verified
+	read, err := ioutil.ReadAll(req.Body)
+	assert(t, err == nil && bytes.Equal(read, body))
+
+	req = signedRequest(t, signature, "http://localhost/", body, now)
+	req.Body = ioutil.NopCloser(bytes.NewReader([]byte(`{"ok": false}`)))
+	assert(t, errors.Is(signature.Verify(req, now), cynic.ErrBadSignature))
+
+	req = signedRequest(t, other, "http://localhost/", body, now)
+	assert(t, errors.Is(signature.Verify(req, now), cynic.ErrBadSignature))
+
+	req = signedRequest(t, signature, "http://localhost/", body, now.Add(-time.Hour))
+	assert(t, errors.Is(signature.Verify(req, now), cynic.ErrBadSignature))
+
+	req = signedRequest(t, nil, "http://localhost/", body, now)
+	assert(t, errors.Is(signature.Verify(req, now), cynic.ErrBadSignature))
+
+	// a signature for one path, query or method is no good on another
+	req = signedRequest(t, signature, "http://localhost/ping/api", body, now)
+	req.URL.Path = "/ping/admin"
+	assert(t, errors.Is(signature.Verify(req, now), cynic.ErrBadSignature))
+
+	req = signedRequest(t, signature, "http://localhost/ping/api?x=1", body, now)
+	req.URL.RawQuery = "x=2"
+	assert(t, errors.Is(signature.Verify(req, now), cynic.ErrBadSignature))
+
+	req = signedRequest(t, signature, "http://localhost/ping/api", body, now)
+	req.Method = http.MethodPut
+	assert(t, errors.Is(signature.Verify(req, now), cynic.ErrBadSignature))
+
+	custom := &cynic.WebhookSignature{Secret: "s3cret", Header: "X-Hub-Signature-256"}
+	req = signedRequest(t, custom, "http://localhost/", body, now)
+	assert(t, req.Header.Get("X-Hub-Signature-256") != "")
+	assert(t, custom.Verify(req, now) == nil)
+}
+
+func TestWebhookAlerter(t *testing.T) {
+	signature := &cynic.WebhookSignature{Secret: "s3cret"}
+	received := make(chan cynic.WebhookPayload, 1)
+
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := signature.Verify(req, time.Now()); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var payload cynic.WebhookPayload
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- payload
+	}))
+	defer remote.Close()
+
+	alerter := cynic.WebhookAlerterNew(remote.URL)
+	alerter.SetSignature(signature)
+	alerter.Alert([]cynic.AlertMessage{{Label: "api", Severity: cynic.SeverityCritical}})
+
+	select {
+	case payload := <-received:
+		assert(t, len(payload.Alerts) == 1 && payload.Alerts[0].Label == "api")
+	default:
+		t.Fatal("the webhook got no verified alerts")
+	}
+}
+
+func TestStatusServerSignature(t *testing.T) {
+	signature := &cynic.WebhookSignature{Secret: "s3cret"}
+
+	server := cynic.StatusServerNew("", "0", "/testsignature/")
+	server.WithSignature(signature)
+	go func() { server.Start() }()
+	waitForServer(t, server.GetPort())
+	defer server.Stop()
+
+	hook := cynic.HeartbeatHookNew("backup", time.Minute)
+	hook(&cynic.HookParameters{Status: &server})
+
+	url := "http://127.0.0.1:" + strconv.Itoa(server.GetPort()) + "/ping/backup"
+	ping := func(signature *cynic.WebhookSignature) int {
+		resp, err := http.DefaultClient.Do(signedRequest(t, signature, url, nil, time.Now()))
+		if err != nil {
+			t.Fatal("could not connect:", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert(t, ping(nil) == http.StatusUnauthorized)
+	assert(t, ping(&cynic.WebhookSignature{Secret: "nope"}) == http.StatusUnauthorized)
+	assert(t, ping(signature) == http.StatusNoContent)
+
+	// a ping signed for another heartbeat is rejected on this one
+	hook = cynic.HeartbeatHookNew("restore", time.Minute)
+	hook(&cynic.HookParameters{Status: &server})
+
+	req := signedRequest(t, signature, url, nil, time.Now())
+	req.URL.Path = "/ping/restore"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	resp.Body.Close()
+	assert(t, resp.StatusCode == http.StatusUnauthorized)
+}
+
+func TestConfigSignature(t *testing.T) {
+	for _, data := range []string{
+		`{"status": {"signature": {"header": "X-Sig"}}}`,
+		`{"alerts": {"webhook": {"url": "http://localhost/", "signature": {"secret": "x", "max_skew": "-1m"}}}}`,
+		`{"alerts": {"webhook": {}}}`,
+	} {
+		if _, err := cynic.ParseConfig([]byte(data), ".json"); !errors.Is(err, cynic.ErrConfigInvalid) {
+			t.Error("expected", data, "to be invalid, got:", err)
+		}
+	}
+
+	config, err := cynic.ParseConfig([]byte(`{"status": {"port": "0", "signature": {"secret": "x"}},
+		"alerts": {"webhook": {"url": "http://localhost/", "signature": {"secret": "y"}}}}`), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = config.Session()
+	assert(t, err == nil)
+}